@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// AppRoleCheck connects to the restored databases as the application's role
+type AppRoleCheck struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+	// Databases lists which of "moodys" and "tenant" to check; default tenant
+	Databases []string `json:"databases"`
+	// Tables the role must be able to read; empty means every table, view,
+	// materialized view, and foreign table outside system schemas
+	Tables []string `json:"tables"`
+}
+
+// AppRoleFailure is a relation the application role could not read
+type AppRoleFailure struct {
+	Database string `json:"database"`
+	User     string `json:"user"`
+	Table    string `json:"table"`
+	Error    string `json:"error"`
+}
+
+// validate checks the role and databases are set
+func (a *AppRoleCheck) validate() error {
+	if a.User == "" {
+		return fmt.Errorf("user is required")
+	}
+	for _, db := range a.Databases {
+		if db != "moodys" && db != "tenant" {
+			return fmt.Errorf("unknown database %q", db)
+		}
+	}
+	return nil
+}
+
+// databases returns the databases to check
+func (a *AppRoleCheck) databases() []string {
+	if len(a.Databases) == 0 {
+		return []string{"tenant"}
+	}
+	return a.Databases
+}
+
+// readableRelationsQuery lists the relations an application would read
+const readableRelationsQuery = `SELECT n.nspname || '.' || c.relname FROM pg_class c
+JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE c.relkind IN ('r', 'p', 'v', 'm', 'f') AND NOT c.relispartition
+	AND n.nspname NOT IN ('pg_catalog', 'information_schema') AND n.nspname NOT LIKE 'pg\_%'
+ORDER BY 1;`
+
+// smokeScript reads one row from each table in a single session, reporting each
+// outcome by the table's position as a notice so one denied table doesn't stop the
+// rest. Reading a row makes foreign tables use the role's user mapping.
+func smokeScript(tables []string) string {
+	var quoted []string
+	for _, t := range tables {
+		quoted = append(quoted, quoteLiteral(quoteQualifiedName(t)))
+	}
+	return `DO $smoke$
+DECLARE
+	tables text[] := ARRAY[` + strings.Join(quoted, ", ") + `];
+BEGIN
+	FOR i IN 1 .. array_length(tables, 1) LOOP
+		BEGIN
+			EXECUTE 'SELECT 1 FROM ' || tables[i] || ' LIMIT 1';
+			RAISE NOTICE 'smoke % ok', i;
+		EXCEPTION WHEN OTHERS THEN
+			RAISE NOTICE 'smoke % failed: %', i, SQLERRM;
+		END;
+	END LOOP;
+END
+$smoke$;
+`
+}
+
+// smokeNoticeRe matches the notices smokeScript raises
+var smokeNoticeRe = regexp.MustCompile(`NOTICE:\s+smoke (\d+) (ok|failed)(?:: (.*))?$`)
+
+// parseSmokeOutput returns the errors of failed tables by position (1-based) and how
+// many tables passed
+func parseSmokeOutput(output string) (map[int]string, int) {
+	failed := make(map[int]string)
+	passed := 0
+	for _, line := range strings.Split(output, "\n") {
+		m := smokeNoticeRe.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			continue
+		}
+		if m[2] == "ok" {
+			passed++
+			continue
+		}
+		i, _ := strconv.Atoi(m[1])
+		failed[i] = m[3]
+	}
+	return failed, passed
+}
+
+// checkAppRole reads every expected table in dest as the application role
+func checkAppRole(check *AppRoleCheck, dest DBConfig) ([]AppRoleFailure, error) {
+	tables := check.Tables
+	if len(tables) == 0 {
+		rows, err := queryRows(dest, readableRelationsQuery)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list relations in %s: %w", dest.DBName, err)
+		}
+		for _, row := range rows {
+			tables = append(tables, row[0])
+		}
+	}
+	if len(tables) == 0 {
+		return nil, nil
+	}
+
+	app := dest
+	app.User, app.Password, app.Auth = check.User, check.Password, nil
+	cmd := newPsqlCmd(app, "-X", "-q", "-v", "ON_ERROR_STOP=1")
+	cmd.Stdin = strings.NewReader(smokeScript(tables))
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("%s could not connect to %s: %w, output: %s", check.User, dest.DBName, err, output)
+	}
+
+	errs, passed := parseSmokeOutput(string(output))
+	var failures []AppRoleFailure
+	for i, t := range tables {
+		if msg, ok := errs[i+1]; ok {
+			failures = append(failures, AppRoleFailure{Database: dest.DBName, User: check.User, Table: t, Error: msg})
+		}
+	}
+	if passed+len(errs) != len(tables) {
+		return failures, fmt.Errorf("expected %d results from the smoke test of %s, got %d", len(tables), dest.DBName, passed+len(errs))
+	}
+	log.Printf("%s can read %d of %d relations in %s", check.User, passed, len(tables), dest.DBName)
+	return failures, nil
+}
+
+// SmokeTestAppRole verifies the application role can read the expected relations in each
+// restored database, catching GRANTs left out by --no-privileges before the application does
+func SmokeTestAppRole(check *AppRoleCheck, dests map[string]DBConfig) error {
+	var problems []string
+	for _, name := range check.databases() {
+		dest, ok := dests[name]
+		if !ok {
+			continue
+		}
+		failures, err := checkAppRole(check, dest)
+		for _, f := range failures {
+			activeReport.recordAppRoleFailure(f)
+			problems = append(problems, fmt.Sprintf("%s.%s: %s", f.Database, f.Table, f.Error))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s can't read %d relations:\n  %s", check.User, len(problems), strings.Join(problems, "\n  "))
+	}
+	return nil
+}
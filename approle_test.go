@@ -0,0 +1,24 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSmokeScript(t *testing.T) {
+	script := smokeScript([]string{"public.orders", "it's.t"})
+	if !strings.Contains(script, `ARRAY['"public"."orders"', '"it''s"."t"']`) {
+		t.Errorf("tables not quoted as expected:\n%s", script)
+	}
+}
+
+func TestParseSmokeOutput(t *testing.T) {
+	output := `psql:<stdin>:14: NOTICE:  smoke 1 ok
+psql:<stdin>:14: NOTICE:  smoke 2 failed: permission denied for table orders
+psql:<stdin>:14: NOTICE:  smoke 3 failed: user mapping not found for "app"
+`
+	failed, passed := parseSmokeOutput(output)
+	if passed != 1 || len(failed) != 2 || failed[2] != "permission denied for table orders" {
+		t.Errorf("got passed=%d failed=%v", passed, failed)
+	}
+}
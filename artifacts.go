@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+)
+
+// artifactCodec protects artifacts at rest and recovers their plaintext on restore
+type artifactCodec interface {
+	// ext is appended to the names of encoded artifacts
+	ext() string
+	// keyRef describes the key material for the manifest, never the key itself
+	keyRef() string
+	// scratchDir is where decoded copies are placed; empty means the system temp dir
+	scratchDir() string
+	// encode runs cmd with its stdout encoded into outputFile, streaming stderr as step
+	encode(cmd *exec.Cmd, outputFile, step string) error
+	// decodeFile writes the plaintext of src to dst
+	decodeFile(src, dst string) error
+}
+
+// artifactStreamer is a codec whose plaintext can be read as it is decoded, so data
+// sections are restored without a plaintext copy reaching the disk
+type artifactStreamer interface {
+	// open returns a reader yielding the plaintext of src
+	open(src string) (io.ReadCloser, error)
+}
+
+// newArtifactCodec selects the configured codec; nil means artifacts are stored in plaintext
+func newArtifactCodec(encryption *EncryptionConfig, gpg *GPGConfig) (artifactCodec, error) {
+	if encryption != nil && encryption.KeyRef != "" && gpg != nil {
+		return nil, fmt.Errorf("encryption and gpg are mutually exclusive")
+	}
+	if gpg != nil {
+		return gpg, nil
+	}
+
+	enc, err := NewEncryptor(encryption)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize encryption: %w", err)
+	}
+	if enc == nil {
+		return nil, nil
+	}
+	return enc, nil
+}
+
+// artifactResolver maps dump file names to readable plaintext paths, decoding
+// encrypted artifacts into a private scratch directory on first use, or to the streams
+// that decode them
+type artifactResolver struct {
+	mu         sync.Mutex
+	inputDir   string
+	codec      artifactCodec
+	scratchDir string
+}
+
+func newArtifactResolver(inputDir string, codec artifactCodec) *artifactResolver {
+	return &artifactResolver{inputDir: inputDir, codec: codec}
+}
+
+// Resolve returns a plaintext path for the named artifact in the input directory
+func (r *artifactResolver) Resolve(name string) (string, error) {
+	path := filepath.Join(r.inputDir, name)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	if _, err := os.Stat(path + partsIndexExt); err == nil {
+		return path + partsIndexExt, nil
+	}
+
+	if r.codec == nil {
+		for _, ext := range []string{encryptedExt, gpgExt} {
+			if _, err := os.Stat(path + ext); err == nil {
+				return "", fmt.Errorf("artifact %s is encrypted but no encryption is configured", path+ext)
+			}
+		}
+		return "", fmt.Errorf("artifact %s not found in %s", name, r.inputDir)
+	}
+
+	encPath := path + r.codec.ext()
+	if _, err := os.Stat(encPath); err != nil {
+		return "", fmt.Errorf("artifact %s not found in %s", name, r.inputDir)
+	}
+
+	r.mu.Lock()
+	if r.scratchDir == "" {
+		dir, err := os.MkdirTemp(r.codec.scratchDir(), "pg_restore_fdw_")
+		if err != nil {
+			r.mu.Unlock()
+			return "", fmt.Errorf("failed to create scratch directory: %w", err)
+		}
+		r.scratchDir = dir
+	}
+	scratchDir := r.scratchDir
+	r.mu.Unlock()
+
+	plainPath := filepath.Join(scratchDir, name)
+	if _, err := os.Stat(plainPath); err == nil {
+		return plainPath, nil
+	}
+
+	log.Printf("Decrypting %s", encPath)
+	if err := r.codec.decodeFile(encPath, plainPath); err != nil {
+		return "", err
+	}
+	return plainPath, nil
+}
+
+// Stream returns the path of the named artifact and a function opening its plaintext
+// when it is only stored encrypted by a codec that can stream it. ok is false when the
+// artifact is to be Resolved to a file instead.
+func (r *artifactResolver) Stream(name string) (path string, open func() (io.ReadCloser, error), ok bool) {
+	streamer, ok := r.codec.(artifactStreamer)
+	if !ok {
+		return "", nil, false
+	}
+	plainPath := filepath.Join(r.inputDir, name)
+	for _, plain := range []string{plainPath, plainPath + partsIndexExt} {
+		if _, err := os.Stat(plain); err == nil {
+			return "", nil, false
+		}
+	}
+	path = plainPath + r.codec.ext()
+	if _, err := os.Stat(path); err != nil {
+		return "", nil, false
+	}
+	return path, func() (io.ReadCloser, error) { return streamer.open(path) }, true
+}
+
+// artifactInput is an artifact as a restore reads it: a plaintext file, or an encrypted
+// file with the stream that decrypts it
+type artifactInput struct {
+	path   string
+	stream func() (io.ReadCloser, error)
+}
+
+// Input resolves the named artifact for a restore that can read it from a stream
+func (r *artifactResolver) Input(name string) (artifactInput, error) {
+	if path, stream, ok := r.Stream(name); ok {
+		return artifactInput{path: path, stream: stream}, nil
+	}
+	path, err := r.Resolve(name)
+	return artifactInput{path: path}, err
+}
+
+// checkStreamable rejects restoring a section from a stream when that needs a file:
+// per-table restores list and reread the archive, and rewrites of a plain script
+// work on a copy
+func checkStreamable(path string, format ArtifactFormat, perTable, rewrites bool) error {
+	if perTable && format.archive() {
+		return fmt.Errorf("per-table restore needs a seekable archive, but %s is encrypted and is only decrypted as a stream", path)
+	}
+	if rewrites && !format.archive() {
+		return fmt.Errorf("transforms and compatibility rules rewrite a copy of a script, but %s is encrypted and is only decrypted as a stream", path)
+	}
+	return nil
+}
+
+// Cleanup removes decrypted copies
+func (r *artifactResolver) Cleanup() {
+	if r.scratchDir != "" {
+		os.RemoveAll(r.scratchDir)
+		r.scratchDir = ""
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Authentication modes
+const (
+	AuthPassword = "password"
+	AuthKerberos = "kerberos"
+	AuthAWSIAM   = "aws-iam"
+	AuthAzureAD  = "azure-ad"
+	AuthPeer     = "peer"
+)
+
+// AuthConfig selects how a connection authenticates. Token modes fetch a short-lived
+// token through the cloud CLI and pass it as the password.
+type AuthConfig struct {
+	// Mode is password (default), kerberos, aws-iam, or azure-ad
+	Mode string `json:"mode"`
+	// Region is the AWS region of an aws-iam database; defaults to the CLI's region
+	Region string `json:"region"`
+	// GSSEncMode is libpq's gssencmode for kerberos, e.g. require
+	GSSEncMode string `json:"gssencmode"`
+	// KrbSrvName is the Kerberos service name; libpq defaults to postgres
+	KrbSrvName string `json:"krbsrvname"`
+	// SSLMode is libpq's sslmode; token modes default to require
+	SSLMode string `json:"sslmode"`
+	// OSUser runs the client commands as this OS user through sudo -n, e.g. postgres,
+	// for peer authentication over the Unix socket
+	OSUser string `json:"os_user"`
+}
+
+// validate checks the mode is known
+func (a *AuthConfig) validate() error {
+	if a == nil {
+		return nil
+	}
+	switch a.Mode {
+	case "", AuthPassword, AuthKerberos, AuthAWSIAM, AuthAzureAD:
+		if a.OSUser != "" {
+			return fmt.Errorf("os_user needs peer mode, since the environment passed through sudo is visible to other users")
+		}
+		return nil
+	case AuthPeer:
+		return nil
+	}
+	return fmt.Errorf("unknown auth mode %q; use password, kerberos, aws-iam, azure-ad, or peer", a.Mode)
+}
+
+// authToken is a cached token and when it should be replaced
+type authToken struct {
+	value   string
+	refresh time.Time
+}
+
+var (
+	authTokensMu sync.Mutex
+	authTokens   = make(map[string]authToken)
+)
+
+// tokenLifetime is how long a token is reused; RDS IAM tokens are valid for 15 minutes
+// and Azure AD tokens for longer, so commands started near expiry still connect
+const tokenLifetime = 10 * time.Minute
+
+// tokenCommand builds the CLI call that prints a token for the config
+func tokenCommand(config DBConfig) *exec.Cmd {
+	if config.Auth.Mode == AuthAWSIAM {
+		args := []string{"rds", "generate-db-auth-token",
+			"--hostname", config.dialHost(), "--port", config.dialPort(), "--username", config.User}
+		if config.Auth.Region != "" {
+			args = append(args, "--region", config.Auth.Region)
+		}
+		return exec.Command("aws", args...)
+	}
+	return exec.Command("az", "account", "get-access-token",
+		"--resource-type", "oss-rdbms", "--query", "accessToken", "--output", "tsv")
+}
+
+// authTokenFor returns a token for a token-mode config, fetching a new one when the
+// cached one is due for refresh, so long restores keep connecting with valid tokens
+func authTokenFor(config DBConfig) (string, error) {
+	key := strings.Join([]string{config.Auth.Mode, config.dialHost(), config.dialPort(), config.User, config.Auth.Region}, "|")
+	authTokensMu.Lock()
+	defer authTokensMu.Unlock()
+	if t, ok := authTokens[key]; ok && time.Now().Before(t.refresh) {
+		return t.value, nil
+	}
+
+	cmd := tokenCommand(config)
+	output, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			output = exitErr.Stderr
+		}
+		return "", fmt.Errorf("failed to get %s token for %s@%s: %w, output: %s", config.Auth.Mode, config.User, config.Host, err, output)
+	}
+	token := strings.TrimSpace(string(output))
+	authTokens[key] = authToken{value: token, refresh: time.Now().Add(tokenLifetime)}
+	return token, nil
+}
+
+// env returns the environment for a PostgreSQL client command connecting with config
+func (config DBConfig) env() []string {
+	return subprocessEnv(config.pgVars()...)
+}
+
+// pgVars returns the variables this tool sets for a client command connecting with config
+func (config DBConfig) pgVars() []string {
+	var env []string
+	if config.appName != "" {
+		env = append(env, "PGAPPNAME="+config.appName)
+	}
+	if config.Options != "" {
+		env = append(env, "PGOPTIONS="+config.Options)
+	}
+	auth := config.Auth
+	if auth == nil || auth.Mode == "" || auth.Mode == AuthPassword {
+		env = append(env, "PGPASSWORD="+config.Password)
+		if auth != nil && auth.SSLMode != "" {
+			env = append(env, "PGSSLMODE="+auth.SSLMode)
+		}
+		return env
+	}
+
+	switch auth.Mode {
+	case AuthPeer:
+		// The server maps the OS user running the command to the role; no password is sent
+	case AuthKerberos:
+		// Credentials come from the Kerberos ticket cache; no password is sent
+		if auth.GSSEncMode != "" {
+			env = append(env, "PGGSSENCMODE="+auth.GSSEncMode)
+		}
+		if auth.KrbSrvName != "" {
+			env = append(env, "PGKRBSRVNAME="+auth.KrbSrvName)
+		}
+	case AuthAWSIAM, AuthAzureAD:
+		token, err := authTokenFor(config)
+		if err != nil {
+			// The command fails to authenticate; make the reason visible even with -q
+			alwaysLog.Printf("%v", err)
+		}
+		env = append(env, "PGPASSWORD="+token)
+		if auth.SSLMode == "" {
+			env = append(env, "PGSSLMODE=require")
+		}
+	}
+	if auth.SSLMode != "" {
+		env = append(env, "PGSSLMODE="+auth.SSLMode)
+	}
+	return env
+}
+
+// command builds a client command connecting with config. With an OS user it runs
+// through sudo -n, which resets the environment, so the variables set for the command
+// are passed through env(1).
+func (config DBConfig) command(name string, args ...string) *exec.Cmd {
+	if config.Auth == nil || config.Auth.OSUser == "" {
+		cmd := exec.Command(name, args...)
+		cmd.Env = config.env()
+		return cmd
+	}
+	sudoArgs := append([]string{"-n", "-u", config.Auth.OSUser, "--", "env"}, config.pgVars()...)
+	sudoArgs = append(append(sudoArgs, name), args...)
+	cmd := exec.Command("sudo", sudoArgs...)
+	cmd.Env = subprocessEnv()
+	return cmd
+}
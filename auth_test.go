@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func envValue(env []string, key string) (string, bool) {
+	for i := len(env) - 1; i >= 0; i-- {
+		if k, v, _ := strings.Cut(env[i], "="); k == key {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+func TestDBConfigEnv(t *testing.T) {
+	t.Setenv("PGPASSWORD", "")
+	config := DBConfig{Password: "secret"}
+	if v, _ := envValue(config.env(), "PGPASSWORD"); v != "secret" {
+		t.Errorf("password auth: PGPASSWORD = %q", v)
+	}
+
+	config.Auth = &AuthConfig{Mode: AuthKerberos, GSSEncMode: "require", KrbSrvName: "pgsvc"}
+	env := config.env()
+	if v, _ := envValue(env, "PGPASSWORD"); v != "" {
+		t.Errorf("kerberos auth sent a password: %q", v)
+	}
+	if v, _ := envValue(env, "PGGSSENCMODE"); v != "require" {
+		t.Errorf("PGGSSENCMODE = %q", v)
+	}
+	if v, _ := envValue(env, "PGKRBSRVNAME"); v != "pgsvc" {
+		t.Errorf("PGKRBSRVNAME = %q", v)
+	}
+
+	if err := (&AuthConfig{Mode: "ldap"}).validate(); err == nil {
+		t.Error("expected an unknown mode to be rejected")
+	}
+}
+
+func TestPeerAuthCommand(t *testing.T) {
+	t.Setenv("PGPASSWORD", "")
+	config := DBConfig{Host: "/var/run/postgresql", User: "postgres", Password: "unused", Options: "-c work_mem=64MB",
+		Auth: &AuthConfig{Mode: AuthPeer}}
+	cmd := config.command("psql", "-c", "SELECT 1;")
+	if v, _ := envValue(cmd.Env, "PGPASSWORD"); v != "" {
+		t.Errorf("peer auth sent a password: %q", v)
+	}
+	if cmd.Args[0] != "psql" {
+		t.Errorf("ran %v", cmd.Args)
+	}
+
+	config.Auth.OSUser = "postgres"
+	cmd = config.command("psql", "-c", "SELECT 1;")
+	want := "sudo -n -u postgres -- env PGOPTIONS=-c work_mem=64MB psql -c SELECT 1;"
+	if got := strings.Join(cmd.Args, " "); got != want {
+		t.Errorf("ran %q, want %q", got, want)
+	}
+	if err := (&AuthConfig{Mode: AuthPassword, OSUser: "postgres"}).validate(); err == nil {
+		t.Error("os_user with a password should be rejected")
+	}
+}
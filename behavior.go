@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// BehaviorCheck is a statement exercised on both source and destination to compare behavior.
+// It typically calls functions or fires triggers that depend on foreign tables.
+type BehaviorCheck struct {
+	Name string `json:"name"`
+	SQL  string `json:"sql"`
+}
+
+// transactionControl are the statements that would end the transaction a check runs in,
+// letting its changes commit
+var transactionControl = map[string]bool{
+	"BEGIN": true, "START": true, "COMMIT": true, "END": true, "ROLLBACK": true, "ABORT": true, "PREPARE": true,
+}
+
+// validateBehaviorChecks rejects checks that could escape the rolled-back transaction,
+// through transaction control or a psql meta-command such as \c
+func validateBehaviorChecks(checks []BehaviorCheck) error {
+	for _, check := range checks {
+		words, meta := statementKeywords(check.SQL)
+		if meta {
+			return fmt.Errorf("behavior check %q must not use psql meta-commands", check.Name)
+		}
+		for _, w := range words {
+			// ROLLBACK TO SAVEPOINT stays inside the transaction
+			if transactionControl[w[0]] && !(w[0] == "ROLLBACK" && w[1] == "TO") {
+				return fmt.Errorf("behavior check %q must not use %s: checks run in a transaction that is rolled back", check.Name, w[0])
+			}
+		}
+	}
+	return nil
+}
+
+// statementKeywords returns the first two words of each statement in sql, upper-cased,
+// skipping comments and quoted text, and whether sql has a backslash outside them
+func statementKeywords(sql string) (words [][2]string, meta bool) {
+	var current [2]string
+	n := 0
+	word := func(w string) {
+		if n < 2 {
+			current[n] = strings.ToUpper(w)
+		}
+		n++
+	}
+	end := func() {
+		if n > 0 {
+			words = append(words, current)
+		}
+		current, n = [2]string{}, 0
+	}
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == ';':
+			end()
+			i++
+		case c == '\\':
+			return words, true
+		case strings.HasPrefix(sql[i:], "--"):
+			if j := strings.IndexByte(sql[i:], '\n'); j >= 0 {
+				i += j
+			} else {
+				i = len(sql)
+			}
+		case strings.HasPrefix(sql[i:], "/*"):
+			depth := 0
+			for i < len(sql) {
+				if strings.HasPrefix(sql[i:], "/*") {
+					depth++
+					i += 2
+				} else if strings.HasPrefix(sql[i:], "*/") {
+					depth--
+					i += 2
+					if depth == 0 {
+						break
+					}
+				} else {
+					i++
+				}
+			}
+		case c == '\'' || c == '"':
+			escapes := c == '\'' && i > 0 && (sql[i-1] == 'E' || sql[i-1] == 'e')
+			for i++; i < len(sql); i++ {
+				if escapes && sql[i] == '\\' {
+					i++
+				} else if sql[i] == c {
+					if i+1 < len(sql) && sql[i+1] == c {
+						i++
+						continue
+					}
+					break
+				}
+			}
+			i++
+			n++
+		case c == '$':
+			j := i + 1
+			for j < len(sql) && isIdentByte(sql[j]) && sql[j] != '$' {
+				j++
+			}
+			if j < len(sql) && sql[j] == '$' {
+				tag := sql[i : j+1]
+				if k := strings.Index(sql[j+1:], tag); k >= 0 {
+					i = j + 1 + k + len(tag)
+				} else {
+					i = len(sql)
+				}
+				n++
+			} else {
+				i = j
+			}
+		case isIdentByte(c):
+			j := i
+			for j < len(sql) && isIdentByte(sql[j]) {
+				j++
+			}
+			word(sql[i:j])
+			i = j
+		default:
+			i++
+		}
+	}
+	end()
+	return words, false
+}
+
+// behaviorResult captures the outcome of running a check against one database
+type behaviorResult struct {
+	Output string
+	Failed bool
+}
+
+// runBehaviorCheck executes a check inside a transaction that is always rolled back,
+// which validateBehaviorChecks keeps the check from ending early, masking the given database names in its output
+func runBehaviorCheck(config DBConfig, check BehaviorCheck, dbNames []string) behaviorResult {
+	script := "BEGIN;\n" + strings.TrimSpace(check.SQL) + "\n;\nROLLBACK;\n"
+
+	cmd := newPsqlCmd(config,
+		"-q",
+		"-t", // tuple only
+		"-A", // unaligned output
+		"-v", "ON_ERROR_STOP=1",
+	)
+	cmd.Stdin = strings.NewReader(script)
+
+	output, err := cmd.CombinedOutput()
+	return behaviorResult{
+		Output: normalizeBehaviorOutput(string(output), dbNames...),
+		Failed: err != nil,
+	}
+}
+
+// normalizeBehaviorOutput trims whitespace and masks the database names so error
+// messages that mention the current database compare equal across environments.
+// Only whole identifiers are masked: with databases tenant and tenant_dest, a value
+// tenant_accounts is left alone on both sides.
+func normalizeBehaviorOutput(output string, dbNames ...string) string {
+	output = strings.TrimSpace(output)
+	for _, dbName := range dbNames {
+		if dbName != "" {
+			output = maskIdentifier(output, dbName, "<dbname>")
+		}
+	}
+	return output
+}
+
+// maskIdentifier replaces occurrences of name that aren't part of a longer identifier
+func maskIdentifier(s, name, mask string) string {
+	var b strings.Builder
+	for {
+		i := strings.Index(s, name)
+		if i < 0 {
+			b.WriteString(s)
+			return b.String()
+		}
+		end := i + len(name)
+		if (i > 0 && isIdentByte(s[i-1])) || (end < len(s) && isIdentByte(s[end])) {
+			b.WriteString(s[:i+1])
+			s = s[i+1:]
+			continue
+		}
+		b.WriteString(s[:i])
+		b.WriteString(mask)
+		s = s[end:]
+	}
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+// ValidateBehavior runs each check on source and destination and reports any divergence
+// in results or errors, such as FDW-dependent functions broken by the pre-data rewrite
+func ValidateBehavior(srcConfig, destConfig DBConfig, checks []BehaviorCheck) error {
+	var mismatches []string
+
+	for _, check := range checks {
+		log.Printf("Running behavior check %q on %s and %s", check.Name, srcConfig.DBName, destConfig.DBName)
+
+		dbNames := []string{srcConfig.DBName, destConfig.DBName}
+		src := runBehaviorCheck(srcConfig, check, dbNames)
+		dest := runBehaviorCheck(destConfig, check, dbNames)
+
+		switch {
+		case src.Failed != dest.Failed:
+			mismatches = append(mismatches, fmt.Sprintf(
+				"%s: source failed=%t, destination failed=%t (source: %s, destination: %s)",
+				check.Name, src.Failed, dest.Failed, src.Output, dest.Output))
+		case src.Output != dest.Output:
+			mismatches = append(mismatches, fmt.Sprintf(
+				"%s: result mismatch (source: %s, destination: %s)",
+				check.Name, src.Output, dest.Output))
+		default:
+			log.Printf("Behavior check %q matched", check.Name)
+		}
+	}
+
+	if len(mismatches) > 0 {
+		return fmt.Errorf("%d of %d behavior checks diverged:\n%s",
+			len(mismatches), len(checks), strings.Join(mismatches, "\n"))
+	}
+	return nil
+}
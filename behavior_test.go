@@ -0,0 +1,70 @@
+package main
+
+import "testing"
+
+func TestNormalizeBehaviorOutput(t *testing.T) {
+	tests := []struct {
+		output, dbName, want string
+	}{
+		{"  42\n", "tenant", "42"},
+		{"ERROR:  permission denied for database tenant_staging\n", "tenant_staging", "ERROR:  permission denied for database <dbname>"},
+		{"current: tenant_staging, remote: moodys", "tenant_staging", "current: <dbname>, remote: moodys"},
+		{"\t7\n\n", "", "7"},
+	}
+	for _, tt := range tests {
+		if got := normalizeBehaviorOutput(tt.output, tt.dbName); got != tt.want {
+			t.Errorf("normalizeBehaviorOutput(%q, %q) = %q, want %q", tt.output, tt.dbName, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeBehaviorOutputDifferentNames(t *testing.T) {
+	names := []string{"tenant", "tenant_dest"}
+	tests := []struct {
+		src, dest string
+	}{
+		{"tenant_accounts|3", "tenant_accounts|3"},
+		{"connected to tenant", "connected to tenant_dest"},
+		{`ERROR:  permission denied for database "tenant"`, `ERROR:  permission denied for database "tenant_dest"`},
+	}
+	for _, tt := range tests {
+		src := normalizeBehaviorOutput(tt.src, names...)
+		dest := normalizeBehaviorOutput(tt.dest, names...)
+		if src != dest {
+			t.Errorf("identical results diverged: source %q, destination %q", src, dest)
+		}
+	}
+	if got := normalizeBehaviorOutput("tenant_accounts", names...); got != "tenant_accounts" {
+		t.Errorf("masked part of an identifier: %q", got)
+	}
+}
+
+func TestValidateBehaviorChecks(t *testing.T) {
+	tests := []struct {
+		sql string
+		ok  bool
+	}{
+		{"SELECT refresh_balances()", true},
+		{"INSERT INTO audit VALUES ('commit; end')", true},
+		{"SAVEPOINT s; UPDATE t SET x = 1; ROLLBACK TO SAVEPOINT s; SELECT 1", true},
+		{"DO $$BEGIN PERFORM 1; END$$", true},
+		{"DO $body$ BEGIN COMMIT; END $body$; SELECT 1", true},
+		{"SELECT 1 -- then commit;\n", true},
+		{"SELECT /* end; */ 1", true},
+		{`SELECT "end" FROM t`, true},
+		{"UPDATE t SET x = 1; COMMIT", false},
+		{"update t set x = 1;\nend;", false},
+		{"SELECT 1; ROLLBACK; DELETE FROM t", false},
+		{"abort", false},
+		{"SELECT 1;\nBEGIN; DELETE FROM t", false},
+		{"PREPARE TRANSACTION 'x'", false},
+		{"SELECT 1 \\g", false},
+		{"\\c moodys\nDELETE FROM t", false},
+	}
+	for _, tt := range tests {
+		err := validateBehaviorChecks([]BehaviorCheck{{Name: "check", SQL: tt.sql}})
+		if (err == nil) != tt.ok {
+			t.Errorf("validateBehaviorChecks(%q) = %v, want ok=%t", tt.sql, err, tt.ok)
+		}
+	}
+}
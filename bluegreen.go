@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// stagingSuffix is appended to destination names for blue/green restores
+const stagingSuffix = "_staging"
+
+// stagingName returns the database a blue/green restore fills before cutover
+func stagingName(live string) string {
+	return suffixedName(live, stagingSuffix)
+}
+
+// previousName returns the name the live database is moved aside to at cutover
+func previousName(live string, at time.Time) string {
+	return suffixedName(live, "_previous_"+at.Format("20060102_150405"))
+}
+
+// CutoverTarget is a staging database and the live name it replaces
+type CutoverTarget struct {
+	Staging DBConfig
+	Live    string
+}
+
+// CutoverRecord records what a cutover did with one database
+type CutoverRecord struct {
+	Database string `json:"database"`
+	Staging  string `json:"staging"`
+	// Previous is where the old live database was moved; empty when there was none
+	Previous string `json:"previous,omitempty"`
+	Dropped  bool   `json:"dropped,omitempty"`
+}
+
+// databaseExists reports whether a database exists on the config's server
+func databaseExists(config DBConfig, name string) (bool, error) {
+	rows, err := queryRows(maintenanceConfig(config), "SELECT 1 FROM pg_database WHERE datname = "+quoteLiteral(name))
+	if err != nil {
+		return false, fmt.Errorf("failed to look up database %s: %w", name, err)
+	}
+	return len(rows) > 0, nil
+}
+
+// setAllowConnections opens or closes a database to new connections
+func setAllowConnections(config DBConfig, name string, allow bool) error {
+	sql := fmt.Sprintf("ALTER DATABASE %s WITH ALLOW_CONNECTIONS %t;", quoteIdent(name), allow)
+	cmd := newPsqlCmd(maintenanceConfig(config), "-v", "ON_ERROR_STOP=1", "-c", sql)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to set connections on %s: %w, output: %s", name, err, output)
+	}
+	return nil
+}
+
+// moveAside renames the live database, refusing new connections first so clients that
+// reconnect right away can't block the rename. The moved database accepts connections
+// again afterwards, so it can be inspected or moved back.
+func moveAside(config DBConfig, newName string) error {
+	if err := setAllowConnections(config, config.DBName, false); err != nil {
+		return err
+	}
+	if err := renameDatabase(config, newName); err != nil {
+		if reopenErr := setAllowConnections(config, config.DBName, true); reopenErr != nil {
+			log.Printf("WARNING: %v", reopenErr)
+		}
+		return err
+	}
+	return setAllowConnections(config, newName, true)
+}
+
+// stagingServersQuery lists foreign servers whose dbname option names a database
+const stagingServersQuery = `SELECT srvname FROM pg_foreign_server WHERE %s = ANY (srvoptions) ORDER BY 1`
+
+// retargetStagingServers points foreign servers in config's database that reach another
+// staging database at that database's live name, so they keep working after cutover
+func retargetStagingServers(config DBConfig, targets []CutoverTarget) error {
+	var statements []string
+	for _, t := range targets {
+		rows, err := queryRows(config, fmt.Sprintf(stagingServersQuery, quoteLiteral("dbname="+t.Staging.DBName)))
+		if err != nil {
+			return fmt.Errorf("failed to list foreign servers in %s: %w", config.DBName, err)
+		}
+		for _, row := range rows {
+			statements = append(statements, fmt.Sprintf("ALTER SERVER %s OPTIONS (SET dbname %s);", quoteIdent(row[0]), quoteLiteral(t.Live)))
+		}
+	}
+	if len(statements) == 0 {
+		return nil
+	}
+	log.Printf("Pointing %d foreign servers in %s at live database names", len(statements), config.DBName)
+	cmd := newPsqlCmd(config, "-v", "ON_ERROR_STOP=1", "--single-transaction")
+	cmd.Stdin = strings.NewReader(strings.Join(statements, "\n") + "\n")
+	if output, err := runStreaming(cmd, "retarget_"+config.DBName, nil); err != nil {
+		return fmt.Errorf("failed to retarget foreign servers in %s: %w\nOutput: %s", config.DBName, err, output)
+	}
+	return nil
+}
+
+// Cutover swaps validated staging databases into place. Foreign servers between them
+// are first pointed at the live names; then, one database at a time, the live database
+// is renamed to <db>_previous_<timestamp> and staging is renamed to the live name. A
+// failed swap moves the previous database back; databases already swapped stay swapped.
+// With dropPrevious the old databases are dropped once every swap succeeded.
+func Cutover(targets []CutoverTarget, dropPrevious bool) error {
+	for _, t := range targets {
+		if err := retargetStagingServers(t.Staging, targets); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	var records []CutoverRecord
+	defer func() {
+		for _, record := range records {
+			activeReport.recordCutover(record)
+		}
+	}()
+	for _, t := range targets {
+		record := CutoverRecord{Database: t.Live, Staging: t.Staging.DBName}
+		live := t.Staging
+		live.DBName = t.Live
+		exists, err := databaseExists(live, t.Live)
+		if err != nil {
+			return err
+		}
+		if exists {
+			record.Previous = previousName(t.Live, now)
+			log.Printf("Moving %s aside to %s", t.Live, record.Previous)
+			if err := moveAside(live, record.Previous); err != nil {
+				return fmt.Errorf("cutover of %s failed: %w", t.Live, err)
+			}
+		}
+
+		log.Printf("Renaming %s to %s", t.Staging.DBName, t.Live)
+		if err := renameDatabase(t.Staging, t.Live); err != nil {
+			if record.Previous != "" {
+				previous := live
+				previous.DBName = record.Previous
+				if backErr := renameDatabase(previous, t.Live); backErr != nil {
+					return fmt.Errorf("cutover of %s failed: %w; moving %s back also failed: %v", t.Live, err, record.Previous, backErr)
+				}
+			}
+			return fmt.Errorf("cutover of %s failed: %w", t.Live, err)
+		}
+		records = append(records, record)
+	}
+
+	for i, record := range records {
+		if dropPrevious && record.Previous != "" {
+			previous := targets[i].Staging
+			previous.DBName = record.Previous
+			if err := DeleteDatabasesWithOptions(DropOptions{Force: true, Automatic: true}, previous); err != nil {
+				log.Printf("WARNING: failed to drop %s after cutover: %v", record.Previous, err)
+			} else {
+				records[i].Dropped = true
+			}
+		}
+	}
+	return nil
+}
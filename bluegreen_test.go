@@ -0,0 +1,21 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCutoverNames(t *testing.T) {
+	if got := stagingName("tenant_dest"); got != "tenant_dest_staging" {
+		t.Errorf("stagingName = %q", got)
+	}
+	at := time.Date(2024, 3, 1, 14, 5, 9, 0, time.UTC)
+	if got := previousName("tenant_dest", at); got != "tenant_dest_previous_20240301_140509" {
+		t.Errorf("previousName = %q", got)
+	}
+	long := strings.Repeat("t", maxIdentifierLength)
+	if got := stagingName(long); len(got) != maxIdentifierLength || !strings.HasSuffix(got, stagingSuffix) {
+		t.Errorf("stagingName of a long name = %q", got)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// CreateBundle tars the step logs, manifest, run state, and report of a run into
+// <dir>/bundle_<runID>.tar.gz for attaching to support tickets. Only the logs of steps
+// the report recorded are included, so logs left by earlier runs stay out.
+func CreateBundle(dir string, report *RunReport) (string, error) {
+	runID := report.RunID
+	var files []string
+	seen := make(map[string]bool)
+	report.mu.Lock()
+	for _, step := range report.Steps {
+		if step.LogFile == "" || seen[step.LogFile] {
+			continue
+		}
+		seen[step.LogFile] = true
+		if _, err := os.Stat(step.LogFile); err == nil {
+			files = append(files, step.LogFile)
+		}
+	}
+	report.mu.Unlock()
+	for _, candidate := range []string{
+		filepath.Join(dir, manifestFileName),
+		filepath.Join(dir, manifestFileName+".asc"),
+		runStatePath(dir, runID),
+		reportPath(dir, runID),
+	} {
+		if _, err := os.Stat(candidate); err == nil {
+			files = append(files, candidate)
+		}
+	}
+
+	bundlePath := filepath.Join(dir, fmt.Sprintf("bundle_%s.tar.gz", runID))
+	out, err := os.Create(bundlePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create bundle: %w", err)
+	}
+	defer out.Close()
+
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+
+	for _, file := range files {
+		if err := addToBundle(tw, dir, file); err != nil {
+			return "", err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return "", fmt.Errorf("failed to finish bundle: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return "", fmt.Errorf("failed to finish bundle: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return "", fmt.Errorf("failed to finish bundle: %w", err)
+	}
+
+	log.Printf("Bundled %d files into %s", len(files), bundlePath)
+	return bundlePath, nil
+}
+
+// addToBundle writes one file into the archive under its path relative to dir
+func addToBundle(tw *tar.Writer, dir, file string) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", file, err)
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return fmt.Errorf("failed to build header for %s: %w", file, err)
+	}
+	if rel, err := filepath.Rel(dir, file); err == nil {
+		header.Name = filepath.ToSlash(rel)
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to add %s to bundle: %w", file, err)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", file, err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(tw, f); err != nil {
+		return fmt.Errorf("failed to add %s to bundle: %w", file, err)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestCreateBundle(t *testing.T) {
+	dir := t.TempDir()
+	logs := filepath.Join(dir, "logs")
+	if err := os.MkdirAll(logs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"logs/restore_tenant_data.log": "restored",
+		"logs/dump_moodys.log":         "left by an earlier run",
+		manifestFileName:               "{}",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report := NewRunReport("20260601-101500")
+	report.recordStep("restore_tenant_data", filepath.Join(logs, "restore_tenant_data.log"), report.StartedAt, nil)
+	report.recordStep("restore_tenant_data", filepath.Join(logs, "restore_tenant_data.log"), report.StartedAt, nil)
+	report.recordStep("restore_moodys_data", "", report.StartedAt, nil)
+	report.Finish(nil)
+	if _, err := report.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := CreateBundle(dir, report)
+	if err != nil {
+		t.Fatalf("CreateBundle: %v", err)
+	}
+	if path != filepath.Join(dir, "bundle_20260601-101500.tar.gz") {
+		t.Errorf("bundle written to %s", path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, header.Name)
+	}
+	sort.Strings(names)
+
+	want := []string{"logs/restore_tenant_data.log", manifestFileName, "reports/report_20260601-101500.json"}
+	sort.Strings(want)
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("bundle holds %q, want %q", names, want)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// catalogSettings are the server settings recorded in a catalog snapshot
+var catalogSettings = []string{"server_version", "server_version_num", "server_encoding", "TimeZone", "DateStyle", "standard_conforming_strings"}
+
+// catalogSettingsQuery reads the recorded settings. The database's collation and ctype
+// come from pg_database, since PostgreSQL 16 dropped them from pg_settings.
+const catalogSettingsQuery = `SELECT name, setting FROM pg_settings WHERE name IN (%s)
+UNION ALL SELECT 'datcollate', datcollate FROM pg_database WHERE datname = current_database()
+UNION ALL SELECT 'datctype', datctype FROM pg_database WHERE datname = current_database()
+ORDER BY 1;`
+
+// extensionQuery lists installed extensions with their versions and schemas
+const extensionQuery = `SELECT e.extname, e.extversion, n.nspname
+FROM pg_extension e JOIN pg_namespace n ON n.oid = e.extnamespace
+ORDER BY 1;`
+
+// indexQuery lists the indexes of user tables with their definitions
+const indexQuery = `SELECT n.nspname || '.' || ci.relname, tn.nspname || '.' || ct.relname, pg_get_indexdef(i.indexrelid)
+FROM pg_index i JOIN pg_class ci ON ci.oid = i.indexrelid
+	JOIN pg_namespace n ON n.oid = ci.relnamespace
+	JOIN pg_class ct ON ct.oid = i.indrelid
+	JOIN pg_namespace tn ON tn.oid = ct.relnamespace
+WHERE n.nspname NOT IN ('pg_catalog', 'information_schema')
+	AND n.nspname NOT LIKE 'pg_toast%'
+ORDER BY 1;`
+
+// CatalogSnapshot records the shape of a source database when it was dumped, so a
+// restore can be checked against it after the source has moved on
+type CatalogSnapshot struct {
+	Database string            `json:"database"`
+	DBName   string            `json:"dbname"`
+	Settings map[string]string `json:"settings"`
+	// Tables holds row estimates and sizes from the statistics, not exact counts
+	Tables         []TableStats       `json:"tables"`
+	Indexes        []CatalogIndex     `json:"indexes"`
+	Extensions     []CatalogExtension `json:"extensions"`
+	ForeignServers []InspectedServer  `json:"foreign_servers"`
+	// Locale is the database's encoding and default collation
+	Locale *DatabaseLocale `json:"locale,omitempty"`
+	// DatabaseSettings are the database's ALTER DATABASE and ALTER ROLE IN DATABASE settings
+	DatabaseSettings []DatabaseSetting `json:"database_settings,omitempty"`
+}
+
+// CatalogIndex is an index and its definition
+type CatalogIndex struct {
+	Name       string `json:"name"`
+	Table      string `json:"table"`
+	Definition string `json:"definition"`
+}
+
+// CatalogExtension is an installed extension
+type CatalogExtension struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Schema  string `json:"schema"`
+}
+
+// TakeCatalogSnapshot reads the catalog of config's database. Foreign servers are
+// recorded as fdw inspect lists them, with passwords redacted and without probing.
+func TakeCatalogSnapshot(database string, config DBConfig) (CatalogSnapshot, error) {
+	snap := CatalogSnapshot{Database: database, DBName: config.DBName, Settings: make(map[string]string)}
+
+	names := make([]string, len(catalogSettings))
+	for i, name := range catalogSettings {
+		names[i] = quoteLiteral(name)
+	}
+	rows, err := queryRows(config, fmt.Sprintf(catalogSettingsQuery, strings.Join(names, ", ")))
+	if err != nil {
+		return snap, fmt.Errorf("failed to read settings of %s: %w", config.DBName, err)
+	}
+	for _, row := range rows {
+		if len(row) == 2 {
+			snap.Settings[row[0]] = row[1]
+		}
+	}
+
+	output, err := newPsqlCmd(config, "-t", "-A", "-F", "|", "-v", "ON_ERROR_STOP=1", "-c", tableStatsQuery).Output()
+	if err != nil {
+		return snap, fmt.Errorf("failed to inspect tables of %s: %w, output: %s", config.DBName, err, output)
+	}
+	if snap.Tables, err = parseTableStats(string(output)); err != nil {
+		return snap, err
+	}
+
+	if rows, err = queryRows(config, indexQuery); err != nil {
+		return snap, fmt.Errorf("failed to list indexes of %s: %w", config.DBName, err)
+	}
+	for _, row := range rows {
+		if len(row) == 3 {
+			snap.Indexes = append(snap.Indexes, CatalogIndex{Name: row[0], Table: row[1], Definition: row[2]})
+		}
+	}
+
+	if rows, err = queryRows(config, extensionQuery); err != nil {
+		return snap, fmt.Errorf("failed to list extensions of %s: %w", config.DBName, err)
+	}
+	for _, row := range rows {
+		if len(row) == 3 {
+			snap.Extensions = append(snap.Extensions, CatalogExtension{Name: row[0], Version: row[1], Schema: row[2]})
+		}
+	}
+
+	versionNum, _ := strconv.Atoi(snap.Settings["server_version_num"])
+	if snap.Locale, err = readLocale(config, versionNum, config.DBName); err != nil {
+		return snap, err
+	}
+	if snap.DatabaseSettings, err = readDatabaseSettings(config); err != nil {
+		return snap, err
+	}
+
+	inventory, err := InspectFDW(config, false)
+	if err != nil {
+		return snap, err
+	}
+	snap.ForeignServers = inventory.Servers
+	return snap, nil
+}
+
+// catalogNames returns the names of everything a snapshot records, by kind
+func catalogNames(snap CatalogSnapshot) map[string][]string {
+	names := map[string][]string{}
+	for _, t := range snap.Tables {
+		names["table"] = append(names["table"], t.Name)
+	}
+	for _, i := range snap.Indexes {
+		names["index"] = append(names["index"], i.Name)
+	}
+	for _, e := range snap.Extensions {
+		names["extension"] = append(names["extension"], e.Name)
+	}
+	for _, s := range snap.ForeignServers {
+		names["foreign server"] = append(names["foreign server"], s.Name)
+		for _, t := range s.ForeignTables {
+			names["foreign table"] = append(names["foreign table"], t.Schema+"."+t.Table)
+		}
+	}
+	return names
+}
+
+// compareCatalogs lists what the recorded source has that the restored database
+// lacks, and what the restored database has in addition
+func compareCatalogs(recorded, restored CatalogSnapshot) []string {
+	want, got := catalogNames(recorded), catalogNames(restored)
+	var kinds []string
+	for kind := range want {
+		kinds = append(kinds, kind)
+	}
+	for kind := range got {
+		if _, ok := want[kind]; !ok {
+			kinds = append(kinds, kind)
+		}
+	}
+	sort.Strings(kinds)
+
+	var diffs []string
+	for _, kind := range kinds {
+		have := make(map[string]bool)
+		for _, name := range got[kind] {
+			have[name] = true
+		}
+		had := make(map[string]bool)
+		for _, name := range want[kind] {
+			had[name] = true
+			if !have[name] {
+				diffs = append(diffs, fmt.Sprintf("missing %s %s", kind, name))
+			}
+		}
+		for _, name := range got[kind] {
+			if !had[name] {
+				diffs = append(diffs, fmt.Sprintf("unexpected %s %s", kind, name))
+			}
+		}
+	}
+	return diffs
+}
+
+// ValidateCatalogs compares each restored database with the catalog snapshot recorded
+// when its source was dumped, so the check doesn't depend on the source as it is now
+func ValidateCatalogs(snapshots []CatalogSnapshot, dests map[string]DBConfig) error {
+	failed := 0
+	for _, recorded := range snapshots {
+		dest, ok := dests[recorded.Database]
+		if !ok {
+			continue
+		}
+		restored, err := TakeCatalogSnapshot(recorded.Database, dest)
+		if err != nil {
+			return err
+		}
+		diffs := compareCatalogs(recorded, restored)
+		for _, diff := range diffs {
+			log.Printf("%s: %s", dest.DBName, diff)
+		}
+		if len(diffs) > 0 {
+			failed++
+		} else {
+			log.Printf("%s matches the catalog recorded from %s", dest.DBName, recorded.DBName)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d restored databases differ from the recorded source catalogs", failed)
+	}
+	return nil
+}
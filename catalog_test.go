@@ -0,0 +1,30 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompareCatalogs(t *testing.T) {
+	recorded := CatalogSnapshot{
+		Tables:     []TableStats{{Name: "public.a"}, {Name: "public.b"}},
+		Indexes:    []CatalogIndex{{Name: "public.a_pkey"}},
+		Extensions: []CatalogExtension{{Name: "plpgsql"}, {Name: "postgres_fdw"}},
+		ForeignServers: []InspectedServer{{Name: "moodys_server",
+			ForeignTables: []InspectedTable{{Schema: "public", Table: "rates"}}}},
+	}
+	restored := CatalogSnapshot{
+		Tables:         []TableStats{{Name: "public.a"}, {Name: "public.c"}},
+		Indexes:        []CatalogIndex{{Name: "public.a_pkey"}},
+		Extensions:     []CatalogExtension{{Name: "plpgsql"}, {Name: "postgres_fdw"}},
+		ForeignServers: []InspectedServer{{Name: "moodys_server"}},
+	}
+
+	want := []string{"missing foreign table public.rates", "missing table public.b", "unexpected table public.c"}
+	if got := compareCatalogs(recorded, restored); !reflect.DeepEqual(got, want) {
+		t.Errorf("compareCatalogs = %q, want %q", got, want)
+	}
+	if got := compareCatalogs(recorded, recorded); len(got) != 0 {
+		t.Errorf("a catalog should match itself, got %q", got)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// cdcPublication is the publication created on each source for change capture
+const cdcPublication = "pg_restore_fdw_cdc"
+
+// CDCSlot records the logical replication slot a database was dumped at
+type CDCSlot struct {
+	Database        string `json:"database"`
+	SlotName        string `json:"slot_name"`
+	Publication     string `json:"publication"`
+	ConsistentPoint string `json:"consistent_point"`
+}
+
+// cdcSlotName returns the replication slot name used for a database
+func cdcSlotName(namePrefix string) string {
+	return "pg_restore_fdw_" + namePrefix
+}
+
+// parseSlotRow parses "slot_name|consistent_point|snapshot_name|output_plugin" output
+func parseSlotRow(row string) (slot, lsn, snapshot string, err error) {
+	fields := strings.Split(strings.TrimSpace(row), "|")
+	if len(fields) != 4 {
+		return "", "", "", fmt.Errorf("unexpected replication slot output %q", row)
+	}
+	return fields[0], fields[1], fields[2], nil
+}
+
+// ensureCDCPublication creates the change capture publication if it doesn't exist.
+// It must exist before the slot so pgoutput can see it when decoding.
+func ensureCDCPublication(config DBConfig) error {
+	sql := fmt.Sprintf(`DO $$ BEGIN
+	IF NOT EXISTS (SELECT 1 FROM pg_publication WHERE pubname = %s) THEN
+		CREATE PUBLICATION %s FOR ALL TABLES;
+	END IF;
+END $$;`, quoteLiteral(cdcPublication), quoteIdent(cdcPublication))
+	cmd := newPsqlCmd(config, "-v", "ON_ERROR_STOP=1", "-c", sql)
+	if output, err := runStreaming(cmd, "cdc_publication_"+config.DBName, nil); err != nil {
+		return fmt.Errorf("failed to create publication on %s: %w, output: %s", config.DBName, err, output)
+	}
+	return nil
+}
+
+// openCDCSession creates a logical replication slot and exports the snapshot it starts
+// from, so a dump taken from that snapshot is followed exactly by the slot's changes.
+// The session must stay open until every dump using the snapshot has finished.
+func openCDCSession(config DBConfig, namePrefix string) (*snapshotSession, CDCSlot, error) {
+	if err := ensureCDCPublication(config); err != nil {
+		return nil, CDCSlot{}, err
+	}
+
+	cmd := pgCommand("psql", config).Param("replication", "database").Arg("-q", "-t", "-A").Cmd()
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, CDCSlot{}, fmt.Errorf("failed to open replication session input: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, CDCSlot{}, fmt.Errorf("failed to open replication session output: %w", err)
+	}
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	if err := cmd.Start(); err != nil {
+		return nil, CDCSlot{}, fmt.Errorf("failed to start replication session: %w", err)
+	}
+
+	fmt.Fprintf(stdin, "CREATE_REPLICATION_SLOT %s LOGICAL pgoutput EXPORT_SNAPSHOT;\n", cdcSlotName(namePrefix))
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err == nil {
+		var slot, lsn, snapshot string
+		if slot, lsn, snapshot, err = parseSlotRow(line); err == nil {
+			info := SnapshotInfo{Database: config.DBName, SnapshotID: snapshot, LSN: lsn, Timestamp: time.Now().UTC()}
+			log.Printf("Created replication slot %s on %s at LSN %s with snapshot %s", slot, config.DBName, lsn, snapshot)
+			session := &snapshotSession{cmd: cmd, stdin: stdin, Info: info, replication: true}
+			return session, CDCSlot{Database: namePrefix, SlotName: slot, Publication: cdcPublication, ConsistentPoint: lsn}, nil
+		}
+	}
+
+	stdin.Close()
+	cmd.Wait()
+	return nil, CDCSlot{}, fmt.Errorf("failed to create replication slot on %s: %w\nOutput: %s", config.DBName, err, stderr.String())
+}
+
+// sourceConninfo builds the connection string a subscription uses to reach the source
+func sourceConninfo(config DBConfig) string {
+	var parts []string
+	for _, kv := range [][2]string{
+		// Replication can't go through a pooler
+		{"host", config.dialHost()},
+		{"port", config.dialPort()},
+		{"user", config.User},
+		{"password", config.Password},
+		{"dbname", config.DBName},
+	} {
+		if kv[1] != "" {
+			parts = append(parts, kv[0]+"="+quoteConninfoValue(kv[1]))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// quoteConninfoValue quotes a libpq connection string value when needed
+func quoteConninfoValue(value string) string {
+	if value != "" && !strings.ContainsAny(value, ` '\`) {
+		return value
+	}
+	escaped := strings.ReplaceAll(value, `\`, `\\`)
+	return "'" + strings.ReplaceAll(escaped, `'`, `\'`) + "'"
+}
+
+// slotCaughtUp reports whether the slot has confirmed everything up to target
+func slotCaughtUp(config DBConfig, slot, target string) (bool, string, error) {
+	query := fmt.Sprintf(
+		"SELECT confirmed_flush_lsn >= %s::pg_lsn, pg_size_pretty(pg_wal_lsn_diff(%s::pg_lsn, confirmed_flush_lsn)) FROM pg_replication_slots WHERE slot_name = %s;",
+		quoteLiteral(target), quoteLiteral(target), quoteLiteral(slot))
+	output, err := newPsqlCmd(config, "-t", "-A", "-c", query).CombinedOutput()
+	if err != nil {
+		return false, "", fmt.Errorf("failed to query slot %s: %w, output: %s", slot, err, output)
+	}
+	done, lag, found := strings.Cut(strings.TrimSpace(string(output)), "|")
+	if !found {
+		return false, "", fmt.Errorf("replication slot %s not found on %s", slot, config.DBName)
+	}
+	return done == "t", lag, nil
+}
+
+// CDCCatchUp subscribes each destination to its source's slot from the dump and waits
+// until the changes made since the dump have been replayed. Subscriptions keep running
+// afterwards so the destination follows the source until cutover.
+func CDCCatchUp(slots []CDCSlot, sources, dests map[string]DBConfig, timeout time.Duration) error {
+	if len(slots) == 0 {
+		return fmt.Errorf("the manifest records no replication slots; dump with -cdc first")
+	}
+
+	for _, slot := range slots {
+		src, dest := sources[slot.Database], dests[slot.Database]
+		subscription := slot.SlotName
+
+		createSQL := fmt.Sprintf(
+			"CREATE SUBSCRIPTION %s CONNECTION %s PUBLICATION %s WITH (create_slot = false, slot_name = %s, copy_data = false);",
+			quoteIdent(subscription), quoteLiteral(sourceConninfo(src)), quoteIdent(slot.Publication), quoteLiteral(slot.SlotName))
+		cmd := newPsqlCmd(dest, "-v", "ON_ERROR_STOP=1", "-c", createSQL)
+		if output, err := runStreaming(cmd, "cdc_subscribe_"+dest.DBName, nil); err != nil {
+			return fmt.Errorf("failed to subscribe %s to slot %s: %w, output: %s", dest.DBName, slot.SlotName, err, output)
+		}
+
+		position, err := currentPosition(src)
+		if err != nil {
+			return err
+		}
+		log.Printf("Replaying changes on %s from %s to %s", dest.DBName, slot.ConsistentPoint, position.LSN)
+
+		span := startSpan("cdc catch-up "+slot.Database, "db.name", dest.DBName)
+		deadline := time.Now().Add(timeout)
+		for {
+			done, lag, err := slotCaughtUp(src, slot.SlotName, position.LSN)
+			if err != nil {
+				span.End(err)
+				return err
+			}
+			if done {
+				break
+			}
+			if time.Now().After(deadline) {
+				err := fmt.Errorf("%s did not catch up within %v (%s behind)", dest.DBName, timeout, lag)
+				span.End(err)
+				return err
+			}
+			log.Printf("%s is %s behind %s", dest.DBName, lag, src.DBName)
+			time.Sleep(5 * time.Second)
+		}
+		span.End(nil)
+
+		log.Printf("%s caught up with %s; it keeps following until DROP SUBSCRIPTION %s", dest.DBName, src.DBName, subscription)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func TestParseSlotRow(t *testing.T) {
+	slot, lsn, snapshot, err := parseSlotRow("pg_restore_fdw_moodys|0/1A2B3C4|00000003-0000001B-1|pgoutput\n")
+	if err != nil || slot != "pg_restore_fdw_moodys" || lsn != "0/1A2B3C4" || snapshot != "00000003-0000001B-1" {
+		t.Errorf("parseSlotRow = %q, %q, %q, %v", slot, lsn, snapshot, err)
+	}
+	for _, row := range []string{"", "pg_restore_fdw_moodys|0/1A2B3C4|00000003-0000001B-1", "ERROR:  replication slot already exists"} {
+		if _, _, _, err := parseSlotRow(row); err == nil {
+			t.Errorf("parseSlotRow(%q) succeeded", row)
+		}
+	}
+}
+
+func TestQuoteConninfoValue(t *testing.T) {
+	tests := map[string]string{
+		"moodys":      "moodys",
+		"":            "''",
+		"two words":   "'two words'",
+		"it's":        `'it\'s'`,
+		`back\slash`:  `'back\\slash'`,
+		`p@ss'w\rd 1`: `'p@ss\'w\\rd 1'`,
+		"db.internal": "db.internal",
+	}
+	for in, want := range tests {
+		if got := quoteConninfoValue(in); got != want {
+			t.Errorf("quoteConninfoValue(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestSourceConninfo(t *testing.T) {
+	tests := []struct {
+		config DBConfig
+		want   string
+	}{
+		{
+			DBConfig{Host: "db.internal", Port: "5432", User: "postgres", Password: "it's secret", DBName: "moodys"},
+			`host=db.internal port=5432 user=postgres password='it\'s secret' dbname=moodys`,
+		},
+		// Subscriptions bypass a pooler
+		{
+			DBConfig{Host: "pgbouncer", Port: "6432", DirectHost: "db.internal", DirectPort: "5432", DBName: "tenant"},
+			"host=db.internal port=5432 dbname=tenant",
+		},
+		{DBConfig{DBName: "tenant"}, "dbname=tenant"},
+	}
+	for _, tt := range tests {
+		if got := sourceConninfo(tt.config); got != tt.want {
+			t.Errorf("sourceConninfo(%+v) = %s, want %s", tt.config, got, tt.want)
+		}
+	}
+}
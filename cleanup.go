@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// What happens to the databases a restore created when it fails
+const (
+	// FailureKeep leaves partially restored databases in place for debugging
+	FailureKeep = "keep"
+	// FailureDrop drops the databases the failed restore created
+	FailureDrop = "drop-created-databases"
+	// FailureRename renames them to <db>_failed_<timestamp> so the names are free for a retry
+	FailureRename = "rename"
+)
+
+// maxIdentifierLength is PostgreSQL's NAMEDATALEN - 1
+const maxIdentifierLength = 63
+
+// validateFailurePolicy checks a restore failure policy; empty means keep
+func validateFailurePolicy(policy string) error {
+	switch policy {
+	case "", FailureKeep, FailureDrop, FailureRename:
+		return nil
+	}
+	return fmt.Errorf("unknown policy %q: use %s, %s, or %s", policy, FailureKeep, FailureDrop, FailureRename)
+}
+
+// FailureCleanup records what was done with a database left by a failed restore
+type FailureCleanup struct {
+	Database string `json:"database"`
+	Action   string `json:"action"`
+	// RenamedTo is the database's new name under the rename policy
+	RenamedTo string `json:"renamed_to,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// failedName returns the name a failed database is renamed to
+func failedName(name string, at time.Time) string {
+	return suffixedName(name, "_failed_"+at.Format("20060102_150405"))
+}
+
+// suffixedName appends suffix to a database name, trimming the name so the result
+// fits in an identifier
+func suffixedName(name, suffix string) string {
+	if len(name)+len(suffix) > maxIdentifierLength {
+		name = name[:maxIdentifierLength-len(suffix)]
+		for !utf8.ValidString(name) {
+			name = name[:len(name)-1]
+		}
+	}
+	return name + suffix
+}
+
+// createdDatabases tracks the databases a restore created, so a failure can be cleaned up
+// without touching databases that existed before the run
+type createdDatabases struct {
+	mu      sync.Mutex
+	configs []DBConfig
+}
+
+func (c *createdDatabases) add(config DBConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.configs = append(c.configs, config)
+}
+
+// cleanUp applies the failure policy to every created database and records each
+// decision in the run report. Cleanup errors are logged; the restore's error stands.
+func (c *createdDatabases) cleanUp(policy string) {
+	c.mu.Lock()
+	configs := append([]DBConfig(nil), c.configs...)
+	c.mu.Unlock()
+	if policy == "" {
+		policy = FailureKeep
+	}
+
+	now := time.Now()
+	for _, config := range configs {
+		decision := FailureCleanup{Database: config.DBName, Action: policy}
+		var err error
+		switch policy {
+		case FailureKeep:
+			log.Printf("Keeping partially restored database %s", config.DBName)
+		case FailureDrop:
+			log.Printf("Dropping partially restored database %s", config.DBName)
+			err = DeleteDatabasesWithOptions(DropOptions{Force: true, Automatic: true}, config)
+		case FailureRename:
+			decision.RenamedTo = failedName(config.DBName, now)
+			log.Printf("Renaming partially restored database %s to %s", config.DBName, decision.RenamedTo)
+			err = renameDatabase(config, decision.RenamedTo)
+		}
+		if err != nil {
+			log.Printf("WARNING: failed to clean up %s: %v", config.DBName, err)
+			decision.Error = err.Error()
+		}
+		activeReport.recordFailureCleanup(decision)
+	}
+}
+
+// renameDatabase ends the sessions on a database and renames it. Terminated sessions
+// take a moment to exit, so the rename is retried. Only the tool renames databases, so
+// the protections' deny rules apply but no confirmation is asked.
+func renameDatabase(config DBConfig, newName string) error {
+	if err := activeProtections.checkDrop(config, DropOptions{Automatic: true}); err != nil {
+		return err
+	}
+	terminateSQL := fmt.Sprintf("SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE datname = %s AND pid <> pg_backend_pid();",
+		quoteLiteral(config.DBName))
+	renameSQL := fmt.Sprintf("ALTER DATABASE %s RENAME TO %s;", quoteIdent(config.DBName), quoteIdent(newName))
+	return RetryWithBackoff("rename "+config.DBName, 3, func() error {
+		cmd := newPsqlCmd(maintenanceConfig(config), "-v", "ON_ERROR_STOP=1", "-c", terminateSQL, "-c", renameSQL)
+		if output, err := runStreaming(cmd, "rename_"+config.DBName, nil); err != nil {
+			return fmt.Errorf("failed to rename database %s: %w, output: %s", config.DBName, err, output)
+		}
+		return nil
+	})
+}
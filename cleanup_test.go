@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFailedName(t *testing.T) {
+	at := time.Date(2024, 3, 1, 14, 5, 9, 0, time.UTC)
+	if got := failedName("tenant_dest", at); got != "tenant_dest_failed_20240301_140509" {
+		t.Errorf("failedName = %q", got)
+	}
+	long := failedName(strings.Repeat("é", 40), at)
+	if len(long) > maxIdentifierLength || !strings.HasSuffix(long, "_failed_20240301_140509") {
+		t.Errorf("long name %q (%d bytes) not trimmed to an identifier", long, len(long))
+	}
+	if !strings.HasPrefix(long, "é") || strings.ContainsRune(long, '�') {
+		t.Errorf("long name %q split a character", long)
+	}
+}
+
+func TestValidateFailurePolicy(t *testing.T) {
+	for _, policy := range []string{"", FailureKeep, FailureDrop, FailureRename} {
+		if err := validateFailurePolicy(policy); err != nil {
+			t.Errorf("validateFailurePolicy(%q) = %v", policy, err)
+		}
+	}
+	if err := validateFailurePolicy("drop"); err == nil {
+		t.Error("expected an unknown policy to be rejected")
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// sameCluster reports whether two connections reach the same PostgreSQL server, which
+// is what CREATE DATABASE ... TEMPLATE needs
+func sameCluster(a, b DBConfig) bool {
+	return a.Port == b.Port && sameHost(a.Host, b.Host)
+}
+
+// cloneDatabase creates dest as a file-level copy of src. PostgreSQL refuses while
+// other sessions are connected to src, so the clone is retried briefly.
+func cloneDatabase(src, dest DBConfig) error {
+	if err := activeProtections.checkCreate(dest); err != nil {
+		return err
+	}
+	log.Printf("Cloning %s into %s", src.DBName, dest.DBName)
+	sql := fmt.Sprintf("CREATE DATABASE %s TEMPLATE %s;", quoteIdent(dest.DBName), quoteIdent(src.DBName))
+	return RetryWithBackoff("clone "+src.DBName, 3, func() error {
+		cmd := newPsqlCmd(maintenanceConfig(dest), "-v", "ON_ERROR_STOP=1", "-c", sql)
+		if output, err := runStreaming(cmd, "clone_"+dest.DBName, nil); err != nil {
+			return fmt.Errorf("failed to clone %s (no other sessions may be connected to it): %w, output: %s", src.DBName, err, output)
+		}
+		return nil
+	})
+}
+
+// setOption returns an ALTER ... OPTIONS clause setting key, adding it when the object
+// doesn't have it yet
+func setOption(options map[string]string, key, value string) string {
+	action := "SET"
+	if _, ok := options[key]; !ok {
+		action = "ADD"
+	}
+	return fmt.Sprintf("%s %s %s", action, quoteIdent(key), quoteLiteral(value))
+}
+
+// mappingRole returns the role clause of ALTER USER MAPPING for a pg_user_mappings name
+func mappingRole(usename string) string {
+	if usename == "public" {
+		return "PUBLIC"
+	}
+	return quoteIdent(usename)
+}
+
+// cloneRetargetStatements rewrites the foreign servers of a database cloned from from
+// that point at one of the source databases, so they reach its destination instead. This
+// is the catalog equivalent of the pre-data rewrite a restore does: dbname, host, and
+// port follow the destination, and user mappings that used the source credentials get
+// the destination's.
+func cloneRetargetStatements(servers []ForeignServer, from DBConfig, databases map[string]WorkflowDatabase) []string {
+	var statements []string
+	for _, server := range servers {
+		name, ok := serverTarget(server, from, databases)
+		if !ok {
+			continue
+		}
+		src, dest := databases[name].Source, databases[name].Dest
+
+		clauses := []string{setOption(server.Options, "dbname", dest.DBName)}
+		if host, ok := server.Options["host"]; ok && host != dest.Host {
+			clauses = append(clauses, setOption(server.Options, "host", dest.Host))
+		}
+		if port, ok := server.Options["port"]; ok && port != dest.Port {
+			clauses = append(clauses, setOption(server.Options, "port", dest.Port))
+		}
+		statements = append(statements, fmt.Sprintf("ALTER SERVER %s OPTIONS (%s);", quoteIdent(server.Name), strings.Join(clauses, ", ")))
+
+		users := make([]string, 0, len(server.Mappings))
+		for usename := range server.Mappings {
+			users = append(users, usename)
+		}
+		sort.Strings(users)
+		for _, usename := range users {
+			options := server.Mappings[usename]
+			var mappingClauses []string
+			if options["user"] == src.User && src.User != dest.User {
+				mappingClauses = append(mappingClauses, setOption(options, "user", dest.User))
+			}
+			if password, ok := options["password"]; ok && password == src.Password && src.Password != dest.Password {
+				mappingClauses = append(mappingClauses, setOption(options, "password", dest.Password))
+			}
+			if len(mappingClauses) > 0 {
+				statements = append(statements, fmt.Sprintf("ALTER USER MAPPING FOR %s SERVER %s OPTIONS (%s);",
+					mappingRole(usename), quoteIdent(server.Name), strings.Join(mappingClauses, ", ")))
+			}
+		}
+	}
+	return statements
+}
+
+// CloneWorkflow copies the selected source databases ("moodys" and "tenant"; empty
+// selects both) into their destinations with CREATE DATABASE ... TEMPLATE instead of
+// dumping and restoring. The clones' foreign servers are then pointed at the
+// destinations of the databases they reached, and the moodys server in tenant is
+// renamed to serverName. Source and destination must share a cluster.
+func CloneWorkflow(databases map[string]WorkflowDatabase, selected []string, serverName string) error {
+	var names []string
+	for name, db := range databases {
+		if !includesDatabase(selected, name) {
+			continue
+		}
+		if !sameCluster(db.Source, db.Dest) {
+			return fmt.Errorf("can't clone %s: %s:%s and %s:%s are different clusters",
+				name, db.Source.Host, db.Source.Port, db.Dest.Host, db.Dest.Port)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		db := databases[name]
+		if err := cloneDatabase(db.Source, db.Dest); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range names {
+		db := databases[name]
+		servers, err := listForeignServers(db.Dest)
+		if err != nil {
+			return err
+		}
+		statements := cloneRetargetStatements(servers, db.Source, databases)
+		if name == "tenant" && serverName != "" && serverName != moodysServerName {
+			for _, server := range servers {
+				if server.Name == moodysServerName {
+					statements = append(statements, fmt.Sprintf("ALTER SERVER %s RENAME TO %s;", quoteIdent(moodysServerName), quoteIdent(serverName)))
+				}
+			}
+		}
+		if len(statements) == 0 {
+			continue
+		}
+		log.Printf("Retargeting %d foreign server settings in %s", len(statements), db.Dest.DBName)
+		cmd := newPsqlCmd(db.Dest, "-v", "ON_ERROR_STOP=1", "--single-transaction")
+		cmd.Stdin = strings.NewReader(strings.Join(statements, "\n") + "\n")
+		if output, err := runStreaming(cmd, "retarget_"+db.Dest.DBName, nil); err != nil {
+			return fmt.Errorf("failed to retarget foreign servers in %s: %w\nOutput: %s", db.Dest.DBName, err, output)
+		}
+	}
+	return nil
+}
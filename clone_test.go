@@ -0,0 +1,45 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCloneRetargetStatements(t *testing.T) {
+	databases := map[string]WorkflowDatabase{
+		"moodys": {
+			Source: DBConfig{Host: "localhost", Port: "5432", DBName: "moodys", User: "src", Password: "srcpw"},
+			Dest:   DBConfig{Host: "localhost", Port: "5432", DBName: "moodys_dest", User: "dst", Password: "dstpw"},
+		},
+	}
+	tenant := DBConfig{Host: "localhost", Port: "5432", DBName: "tenant"}
+	servers := []ForeignServer{
+		{
+			Name:    "moodys_server",
+			Options: map[string]string{"host": "127.0.0.1", "port": "5432", "dbname": "moodys"},
+			Mappings: map[string]map[string]string{
+				"public":   {"user": "src", "password": "srcpw"},
+				"app user": {"user": "other", "password": "x"},
+			},
+		},
+		{Name: "elsewhere", Options: map[string]string{"host": "remote", "dbname": "moodys"}},
+	}
+
+	got := cloneRetargetStatements(servers, tenant, databases)
+	want := []string{
+		`ALTER SERVER "moodys_server" OPTIONS (SET "dbname" 'moodys_dest', SET "host" 'localhost');`,
+		`ALTER USER MAPPING FOR PUBLIC SERVER "moodys_server" OPTIONS (SET "user" 'dst', SET "password" 'dstpw');`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("cloneRetargetStatements =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestSameCluster(t *testing.T) {
+	if !sameCluster(DBConfig{Host: "localhost", Port: "5432"}, DBConfig{Host: "127.0.0.1", Port: "5432"}) {
+		t.Error("loopback spellings should be one cluster")
+	}
+	if sameCluster(DBConfig{Host: "a", Port: "5432"}, DBConfig{Host: "a", Port: "5433"}) {
+		t.Error("different ports should be different clusters")
+	}
+}
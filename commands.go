@@ -0,0 +1,543 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runConfigCommand runs the subcommands that need the configuration but no run of
+// their own and reports whether it ran one
+func runConfigCommand(e *runEnv) bool {
+	switch flag.Arg(0) {
+	case "daemon":
+		runDaemon(e)
+	case "healthcheck":
+		runHealthCheck(e)
+	case "history":
+		runHistory(e)
+	case "list", "describe":
+		runListDumpSets(e)
+	case "label":
+		runLabel(e)
+	case "estimate":
+		runEstimate(e)
+	case "grants":
+		if len(e.cfg.Grants) == 0 {
+			fatalf("grants needs grant templates in the configuration")
+		}
+		dests := map[string]DBConfig{"moodys": e.destMoodys, "tenant": e.destTenant}
+		if err := ApplyGrants(e.cfg.Grants, dests, e.flags.grantsDryRun); err != nil {
+			fatalf("Failed to apply grants: %v", err)
+		}
+	case "transform-diff":
+		// Without an FDW plan, tenant's foreign server is pointed at the destination moodys
+		retarget := func(database, file string) error {
+			if database != "tenant" {
+				return nil
+			}
+			return modifyPreDataFile(file, e.moodys, e.destMoodys)
+		}
+		if err := DiffTransforms(e.cfg.Transforms, e.flags.dumpDir, os.Stdout, retarget); err != nil {
+			fatalf("Failed to diff transforms: %v", err)
+		}
+	case "migrate-all":
+		runMigrateAll(e)
+	default:
+		return false
+	}
+	return true
+}
+
+// runDaemon starts the configured jobs on their schedules until it is stopped
+func runDaemon(e *runEnv) {
+	configPath := e.flags.configPath
+	sub := flag.NewFlagSet("daemon", flag.ExitOnError)
+	unit := sub.Bool("unit", false, "Print a systemd service unit that runs the daemon with this configuration and exit")
+	user := sub.String("user", "postgres", "With -unit, the user the service runs as")
+	sc := sub.Bool("sc", false, "Print the sc.exe commands that install the daemon as a Windows service with this configuration and exit")
+	service := sub.Bool("service", false, "Run under the Windows service control manager, as the commands printed by -sc do")
+	name := sub.String("name", "pg_restore_fdw", "With -sc or -service, the Windows service name")
+	logFile := sub.String("log-file", "", "Append the daemon's log and job output to this file, e.g. as a Windows service, which has no console")
+	sub.Parse(flag.Args()[1:])
+	if configPath == "" {
+		fatalf("daemon needs -config with a daemon section, which SIGHUP reloads")
+	}
+	if *sc {
+		exe, err := os.Executable()
+		if err != nil {
+			fatalf("Failed to find the executable: %v", err)
+		}
+		if *logFile == "" {
+			*logFile = filepath.Join(filepath.Dir(configPath), "pg_restore_fdw-daemon.log")
+		}
+		content, err := windowsServiceCommands(exe, configPath, *logFile, *name)
+		if err != nil {
+			fatalf("Failed to render service commands: %v", err)
+		}
+		fmt.Print(content)
+		return
+	}
+	if *unit {
+		exe, err := os.Executable()
+		if err != nil {
+			fatalf("Failed to find the executable: %v", err)
+		}
+		content, err := systemdUnit(exe, configPath, *user)
+		if err != nil {
+			fatalf("Failed to render unit: %v", err)
+		}
+		fmt.Print(content)
+		return
+	}
+	if e.cfg.Daemon == nil || len(e.cfg.Daemon.Jobs) == 0 {
+		fatalf("daemon needs jobs in the daemon section of %s", configPath)
+	}
+	daemon, err := NewDaemon(configPath, *e.cfg.Daemon)
+	if err != nil {
+		fatalf("Failed to start daemon: %v", err)
+	}
+	if *logFile != "" {
+		out, err := os.OpenFile(*logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			fatalf("Failed to open log file: %v", err)
+		}
+		defer out.Close()
+		if verbosity != VerbosityQuiet {
+			log.SetOutput(out)
+		}
+		alwaysLog.SetOutput(out)
+		daemon.output = out
+	}
+	run := daemon.Run
+	if *service {
+		run = func() error { return runService(daemon, *name) }
+	}
+	if err := run(); err != nil {
+		fatalf("Daemon failed: %v", err)
+	}
+}
+
+// runHealthCheck reports on the environment as JSON with a Nagios plugin exit code
+func runHealthCheck(e *runEnv) {
+	cfg := e.cfg
+	sources := map[string]DBConfig{"source_moodys": e.moodys, "source_tenant": e.tenant}
+	others := map[string]DBConfig{"dest_moodys": maintenanceConfig(e.destMoodys), "dest_tenant": maintenanceConfig(e.destTenant)}
+	if cfg.Workflow != nil {
+		for name, db := range cfg.Workflow.Databases {
+			sources["source_"+name], others["dest_"+name] = db.Source, maintenanceConfig(db.Dest)
+		}
+	}
+	if cfg.FanOut != nil {
+		for _, t := range cfg.FanOut.targets(e.destMoodys, e.destTenant, cfg.FDWTarget)[1:] {
+			others[t.name+"_moodys"], others[t.name+"_tenant"] = maintenanceConfig(t.moodys), maintenanceConfig(t.tenant)
+		}
+	}
+	if cfg.History != nil {
+		others["history"] = cfg.History.DBConfig
+	}
+	report := RunHealthCheck(sources, others, e.store)
+	if err := report.Write(os.Stdout); err != nil {
+		fatalf("Failed to write health report: %v", err)
+	}
+	os.Exit(report.ExitCode())
+}
+
+// runHistory shows the recorded runs of the last weeks
+func runHistory(e *runEnv) {
+	if e.cfg.History == nil {
+		fatalf("history needs a history database in the configuration")
+	}
+	weeks := 12
+	if flag.Arg(1) != "" {
+		var err error
+		if weeks, err = strconv.Atoi(flag.Arg(1)); err != nil || weeks < 1 {
+			fatalf("Usage: history [weeks]")
+		}
+	}
+	if err := ShowHistory(*e.cfg.History, weeks); err != nil {
+		fatalf("Failed to show history: %v", err)
+	}
+}
+
+// runListDumpSets shows the dump sets at the storage location, or in a local directory
+func runListDumpSets(e *runEnv) {
+	sub := flag.NewFlagSet(flag.Arg(0), flag.ExitOnError)
+	local := sub.String("local", "", "Look in this directory instead of the configured storage; -dump-dir when no storage is configured")
+	sub.Parse(flag.Args()[1:])
+	if *local == "" && e.store == nil {
+		*local = e.flags.dumpDir
+	}
+	var err error
+	if flag.Arg(0) == "list" {
+		var sets []DumpSetSummary
+		if *local != "" {
+			sets, err = ListLocalDumpSets(*local)
+		} else {
+			sets, err = e.store.ListDumpSets()
+		}
+		if err != nil {
+			fatalf("Failed to list dump sets: %v", err)
+		}
+		fmt.Println(formatDumpSets(sets))
+		return
+	}
+	name := sub.Arg(0)
+	if name == "" {
+		name = rootDumpSet
+	}
+	var manifest *Manifest
+	if *local != "" {
+		manifest, err = LoadManifest(filepath.Join(*local, name))
+	} else {
+		manifest, err = e.store.Set(name).FetchManifest()
+	}
+	if err != nil {
+		fatalf("Failed to read dump set %s: %v", name, err)
+	}
+	fmt.Println(describeDumpSet(name, manifest))
+}
+
+// runLabel edits the labels and hold of a dump set after it was dumped
+func runLabel(e *runEnv) {
+	sub := flag.NewFlagSet("label", flag.ExitOnError)
+	local := sub.String("local", "", "Edit the set in this directory instead of the configured storage; -dump-dir when no storage is configured")
+	add := sub.String("add", "", "Comma-separated labels to add")
+	remove := sub.String("remove", "", "Comma-separated labels to remove")
+	hold := sub.Bool("hold", false, "Mark the set immutable, so dumps refuse to replace it")
+	release := sub.Bool("release", false, "Lift the hold")
+	sub.Parse(flag.Args()[1:])
+	change := LabelChange{Add: parseLabels(*add), Remove: parseLabels(*remove), Hold: *hold, Release: *release}
+	if *hold && *release {
+		fatalf("-hold and -release can't be combined")
+	}
+	if len(change.Add) == 0 && len(change.Remove) == 0 && !*hold && !*release {
+		fatalf("Usage: label [-local dir] [-add labels] [-remove labels] [-hold | -release] [set]")
+	}
+	name := sub.Arg(0)
+	if name == "" {
+		name = rootDumpSet
+	}
+	if *local == "" && e.store == nil {
+		*local = e.flags.dumpDir
+	}
+	var manifest *Manifest
+	var err error
+	if *local != "" {
+		manifest, err = RelabelLocal(filepath.Join(*local, name), change, e.cfg.GPG)
+	} else {
+		manifest, err = e.store.Set(name).Relabel(change, e.cfg.GPG)
+	}
+	if err != nil {
+		fatalf("Failed to label dump set %s: %v", name, err)
+	}
+	alwaysLog.Printf("Dump set %s: labels [%s], held %t", name, strings.Join(manifest.Labels, ", "), manifest.Hold)
+}
+
+// runEstimate logs how large and slow a run of the sources would be
+func runEstimate(e *runEnv) {
+	dumpDir := e.flags.dumpDir
+	est, err := EstimateRun([]DBConfig{e.moodys, e.tenant}, dumpDir, e.cfg.Estimate)
+	if err != nil {
+		fatalf("Estimate failed: %v", err)
+	}
+	for _, db := range est.Databases {
+		alwaysLog.Printf("%s: %d tables, %d rows, %d indexes, %s data, %s indexes, %d large objects (%s)",
+			db.Database, len(db.Tables), db.Rows, db.Indexes, formatBytes(db.TableBytes),
+			formatBytes(db.IndexBytes), db.LargeObjects, formatBytes(db.LargeObjectBytes))
+		alwaysLog.Printf("%s: dump %s in %s, restore in %s, %s on the destination",
+			db.Database, formatBytes(db.DumpBytes), db.DumpDuration, db.RestoreDuration, formatBytes(db.DestinationBytes))
+	}
+	alwaysLog.Printf("Total: %s in the dump directory, dump %s, restore %s (rates from %s)",
+		formatBytes(est.DumpDirBytes), est.DumpDuration, est.RestoreDuration, est.Throughput.Source)
+	path, err := est.Save(dumpDir)
+	if err != nil {
+		fatalf("Failed to save estimate: %v", err)
+	}
+	alwaysLog.Printf("Estimate saved to %s", path)
+}
+
+// runMigrateAll migrates every tenant of a registry in child processes
+func runMigrateAll(e *runEnv) {
+	f := e.flags
+	registry := flag.Arg(1)
+	if registry == "" {
+		fatalf("Usage: migrate-all <registry.csv | table:schema.table>")
+	}
+	tenants, err := LoadRegistry(registry, e.moodys)
+	if err != nil {
+		fatalf("Failed to load tenant registry: %v", err)
+	}
+
+	// Children get the same flags, except where their dumps go
+	var childArgs []string
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name != "dump-dir" && f.Name != "concurrency" && f.Name != "status-file" {
+			childArgs = append(childArgs, fmt.Sprintf("-%s=%s", f.Name, f.Value.String()))
+		}
+	})
+
+	runID := time.Now().Format("20060102-150405")
+	migrationDir := filepath.Join(f.dumpDir, "migrate_"+runID)
+	if err := setStepLogDir(filepath.Join(migrationDir, "logs")); err != nil {
+		fatalf("Failed to create log directory: %v", err)
+	}
+	if f.statusFile != "" {
+		if activeStatus, err = newStatusWriter(f.statusFile, runID); err != nil {
+			fatalf("Failed to start status file: %v", err)
+		}
+	}
+	activePause = &PauseGate{}
+	watchPause(activePause, f.dumpDir)
+	results, err := MigrateAll(tenants, e.cfg.Naming, migrationDir, f.concurrency, childArgs)
+	activeStatus.finish(err)
+	if err != nil {
+		fatalf("Migration failed: %v", err)
+	}
+	matrix, err := WriteMigrationMatrix(migrationDir, runID, results)
+	if err != nil {
+		fatalf("Failed to write migration matrix: %v", err)
+	}
+
+	failed := 0
+	for _, r := range results {
+		alwaysLog.Printf("%-20s %-10s %s", r.Tenant, r.Status, r.Error)
+		if r.Status != "succeeded" {
+			failed++
+		}
+	}
+	alwaysLog.Printf("Migration matrix written to %s", matrix)
+	if failed > 0 {
+		fatalf("%d of %d migrations did not succeed", failed, len(results))
+	}
+}
+
+// runTargetCommand runs the subcommands that act on the named destinations and reports
+// whether it ran one
+func runTargetCommand(r *workflowRun) bool {
+	switch flag.Arg(0) {
+	case "fdw":
+		runFDW(r)
+	case "dump-table", "restore-table":
+		runTableCopy(r)
+	case "reconcile":
+		runReconcile(r)
+	default:
+		return false
+	}
+	return true
+}
+
+// runFDW retargets or inspects the foreign servers of a connection's database
+func runFDW(r *workflowRun) {
+	connections := r.connections()
+	switch flag.Arg(1) {
+	case "retarget":
+		sub := flag.NewFlagSet("fdw retarget", flag.ExitOnError)
+		connection := sub.String("connection", "dest_tenant", "Connection whose database holds the foreign server (source_moodys, source_tenant, dest_moodys, dest_tenant)")
+		database := sub.String("database", "", "Database to connect to instead of the connection's")
+		rt := FDWRetarget{Target: FDWTarget{Options: optionList{}}}
+		if r.cfg.FDWTarget != nil {
+			rt.Target = *r.cfg.FDWTarget
+			rt.Target.Options = optionList{}
+			for k, v := range r.cfg.FDWTarget.Options {
+				rt.Target.Options[k] = v
+			}
+		}
+		sub.StringVar(&rt.Server, "server", r.serverName, "Foreign server to retarget")
+		sub.StringVar(&rt.Target.Host, "host", rt.Target.Host, "New host option")
+		sub.StringVar(&rt.Target.Port, "port", rt.Target.Port, "New port option")
+		sub.StringVar(&rt.Target.DBName, "dbname", rt.Target.DBName, "New dbname option")
+		sub.StringVar(&rt.Target.SSLMode, "sslmode", rt.Target.SSLMode, "New sslmode option")
+		sub.StringVar(&rt.Target.User, "user", rt.Target.User, "New user for the user mappings")
+		sub.StringVar(&rt.Target.Password, "password", rt.Target.Password, "New password for the user mappings")
+		sub.Var(optionList(rt.Target.Options), "option", "Other server option as key=value (repeatable)")
+		mappingsFor := sub.String("mapping-for", "", "Comma-separated local roles whose user mappings change (default all)")
+		sub.BoolVar(&rt.DryRun, "dry-run", false, "Print the statements instead of running them")
+		sub.Parse(flag.Args()[2:])
+		config, ok := connections[*connection]
+		if !ok {
+			fatalf("Unknown connection %q", *connection)
+		}
+		target := *config
+		if *database != "" {
+			target.DBName = *database
+		}
+		if *mappingsFor != "" {
+			rt.MappingsFor = strings.Split(*mappingsFor, ",")
+		}
+		if err := RetargetFDW(target, rt); err != nil {
+			fatalf("Failed to retarget %s: %v", rt.Server, err)
+		}
+	case "inspect":
+		sub := flag.NewFlagSet("fdw inspect", flag.ExitOnError)
+		connection := sub.String("connection", "source_tenant", "Connection whose database to inspect (source_moodys, source_tenant, dest_moodys, dest_tenant)")
+		database := sub.String("database", "", "Database to connect to instead of the connection's")
+		asJSON := sub.Bool("json", false, "Print the inventory as JSON on stdout")
+		probe := sub.Bool("probe", true, "Read a row of each foreign table to check it is reachable")
+		sub.Parse(flag.Args()[2:])
+		config, ok := connections[*connection]
+		if !ok {
+			fatalf("Unknown connection %q", *connection)
+		}
+		target := *config
+		if *database != "" {
+			target.DBName = *database
+		}
+		inventory, err := InspectFDW(target, *probe)
+		if err != nil {
+			fatalf("Failed to inspect foreign servers: %v", err)
+		}
+		if *asJSON {
+			if err := PrintFDWInventory(inventory); err != nil {
+				fatalf("Failed to write inventory: %v", err)
+			}
+		} else {
+			LogFDWInventory(inventory)
+		}
+	default:
+		fatalf("Usage: fdw retarget [flags] | fdw inspect [flags]")
+	}
+}
+
+// runTableCopy copies one table between the configured connections with dump-table and
+// restore-table
+func runTableCopy(r *workflowRun) {
+	connections := r.connections()
+	sub := flag.NewFlagSet(flag.Arg(0), flag.ExitOnError)
+	table := sub.String("table", "", "Table to copy, as schema.table (default schema public)")
+	file := sub.String("file", "", "Archive to write or read (default <dump-dir>/table_<schema.table>.dump)")
+	from := sub.String("from", "source_tenant", "Connection to dump from, or with restore-table to validate against")
+	to := sub.String("to", "dest_tenant", "Connection to restore into")
+	dataOnly := sub.Bool("data-only", false, "Dump only the table's data, not its definition")
+	clean := sub.Bool("clean", false, "Drop the table before restoring it")
+	validate := sub.Bool("validate", false, "After restore-table, compare the table with -from")
+	triggers := sub.String("disable-triggers", "", "Keep the table's user triggers from firing while data loads: replica or disable")
+	sub.Parse(flag.Args()[1:])
+	if *table == "" {
+		fatalf("Usage: %s -table schema.table [flags]", flag.Arg(0))
+	}
+	if err := validTriggerMode(*triggers); err != nil {
+		fatalf("Invalid -disable-triggers: %v", err)
+	}
+	if *file == "" {
+		*file = tableArtifactName(r.flags.dumpDir, *table)
+	}
+	src, ok := connections[*from]
+	if !ok {
+		fatalf("Unknown connection %q", *from)
+	}
+	if flag.Arg(0) == "dump-table" {
+		if err := os.MkdirAll(filepath.Dir(*file), 0755); err != nil {
+			fatalf("Failed to create %s: %v", filepath.Dir(*file), err)
+		}
+		if err := DumpTable(*src, *table, *file, *dataOnly); err != nil {
+			fatalf("Failed to dump table: %v", err)
+		}
+		alwaysLog.Printf("Table %s dumped to %s", qualifyTable(*table), *file)
+		return
+	}
+	dest, ok := connections[*to]
+	if !ok {
+		fatalf("Unknown connection %q", *to)
+	}
+	if err := RestoreTable(*dest, *table, *file, *clean, *triggers); err != nil {
+		fatalf("Failed to restore table: %v", err)
+	}
+	if *validate {
+		if err := ValidateTableCopy(*src, *dest, *table, r.cfg.Validation); err != nil {
+			fatalf("Table validation failed: %v", err)
+		}
+	}
+	alwaysLog.Printf("Table %s restored into %s", qualifyTable(*table), dest.DBName)
+}
+
+// runReconcile brings the environments of a spec file to their declared state
+func runReconcile(r *workflowRun) {
+	f, cfg := r.flags, r.cfg
+	sub := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	dryRun := sub.Bool("dry-run", false, "List the actions reconcile would take without taking them")
+	only := sub.String("env", "", "Reconcile only this environment")
+	sub.Parse(flag.Args()[1:])
+	if sub.Arg(0) == "" {
+		fatalf("Usage: reconcile [-dry-run] [-env name] <spec.json>")
+	}
+	spec, err := LoadEnvironmentSpec(sub.Arg(0))
+	if err != nil {
+		fatalf("Invalid environment spec: %v", err)
+	}
+	actions, err := PlanReconcile(spec, *only)
+	if err != nil {
+		fatalf("Failed to compare environments with the spec: %v", err)
+	}
+	fmt.Println(formatReconcileActions(actions))
+	if *dryRun || len(actions) == 0 {
+		return
+	}
+	runID := time.Now().Format("20060102-150405")
+	if !f.noRunLock {
+		lock, err := acquireRunLock(reconcileDests(spec, actions), runID)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		defer lock.Release()
+	}
+	err = ApplyReconcile(spec, actions, ReconcileOptions{
+		Dump:    DumpOptions{Encryption: cfg.Encryption, GPG: cfg.GPG},
+		Restore: r.restoreOptions(runID),
+		Drop:    DropOptions{Force: f.force},
+	})
+	if err != nil {
+		fatalf("Reconcile failed: %v", err)
+	}
+	alwaysLog.Printf("Every environment matches its spec")
+}
+
+// runRestoreCommand runs the subcommands that restore from an existing dump set outside
+// the workflow and reports whether it ran one
+func runRestoreCommand(r *workflowRun) bool {
+	f, cfg := r.flags, r.cfg
+	switch flag.Arg(0) {
+	case "retry-failed":
+		runID := flag.Arg(1)
+		if runID == "" {
+			fatalf("Usage: retry-failed <runID>")
+		}
+		state, err := LoadRunState(f.dumpDir, runID)
+		if err != nil {
+			fatalf("Failed to load run state: %v", err)
+		}
+		codec, err := newArtifactCodec(cfg.Encryption, cfg.GPG)
+		if err != nil {
+			fatalf("Failed to initialize artifact encryption: %v", err)
+		}
+		if err := RetryFailedTables(state, codec, r.destMoodys, r.destTenant); err != nil {
+			fatalf("Retry failed: %v", err)
+		}
+		log.Printf("All quarantined tables of run %s restored", runID)
+	// consolidate restores several databases of the dump set into schemas of one
+	case "consolidate":
+		if cfg.Consolidate == nil {
+			fatalf("consolidate needs a consolidate section in the configuration")
+		}
+		if err := cfg.Consolidate.validate(); err != nil {
+			fatalf("Invalid consolidate configuration: %v", err)
+		}
+		dest := r.destTenant
+		cfg.Consolidate.Dest.apply(&dest)
+		runID := time.Now().Format("20060102-150405")
+		err := Consolidate(cfg.Consolidate, f.dumpDir, r.moodys, dest, r.restoreOptions(runID))
+		if err != nil {
+			fatalf("Consolidation failed: %v", err)
+		}
+		alwaysLog.Printf("Consolidated %d databases into %s", len(cfg.Consolidate.Sources), dest.DBName)
+	default:
+		return false
+	}
+	return true
+}
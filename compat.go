@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CompatRule rewrites or flags lines of plain SQL sections that break when restoring
+// into a different major PostgreSQL version
+type CompatRule struct {
+	Name string `json:"name"`
+	// Pattern is a regular expression matched against each line outside COPY data
+	Pattern string `json:"pattern"`
+	// Replace replaces matches, with $1-style references to the pattern's groups
+	Replace string `json:"replace"`
+	// Warn reports matching lines instead of rewriting them, for constructs that have
+	// no mechanical fix
+	Warn bool `json:"warn"`
+	// RemovedIn applies the rule when the destination is at least this major version
+	// and the source older
+	RemovedIn int `json:"removed_in"`
+	// AddedIn applies the rule when the destination is older than this major version
+	AddedIn int `json:"added_in"`
+}
+
+// CompatConfig adds rules to the built-in compatibility rules or turns some off
+type CompatConfig struct {
+	Rules []CompatRule `json:"rules"`
+	// Disable lists built-in rules by name
+	Disable []string `json:"disable"`
+}
+
+// builtinCompatRules cover what pg_dump writes into plain sections that newer or older
+// servers reject
+var builtinCompatRules = []CompatRule{
+	{Name: "default_with_oids", Pattern: `^SET default_with_oids = .*;$`, RemovedIn: 12},
+	{Name: "with_oids", Pattern: `^WITH OIDS;$`, Replace: ";", RemovedIn: 12},
+	{Name: "removed_time_types", Pattern: `\b(abstime|reltime|tinterval)\b`, Warn: true, RemovedIn: 12},
+	{Name: "default_table_access_method", Pattern: `^SET default_table_access_method = .*;$`, AddedIn: 12},
+	{Name: "default_toast_compression", Pattern: `^SET default_toast_compression = .*;$`, AddedIn: 14},
+	{Name: "column_compression", Pattern: `^ALTER TABLE ONLY .* ALTER COLUMN .* SET COMPRESSION \w+;$`, AddedIn: 14},
+	{Name: "transaction_timeout", Pattern: `^SET transaction_timeout = .*;$`, AddedIn: 17},
+}
+
+func (c *CompatConfig) validate() error {
+	for _, rule := range c.Rules {
+		if rule.Name == "" || rule.Pattern == "" {
+			return fmt.Errorf("compatibility rules need a name and a pattern")
+		}
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			return fmt.Errorf("rule %s: %w", rule.Name, err)
+		}
+	}
+	for _, name := range c.Disable {
+		found := false
+		for _, rule := range builtinCompatRules {
+			found = found || rule.Name == name
+		}
+		if !found {
+			return fmt.Errorf("unknown built-in rule %q", name)
+		}
+	}
+	return nil
+}
+
+// majorVersion turns a server_version_num into a major version, e.g. 160008 into 16.
+// 9.x releases all map to 9.
+func majorVersion(versionNum int) int {
+	return versionNum / 10000
+}
+
+// applies reports whether a rule is relevant between the versions; an unknown source
+// version (0) is taken to be older than anything a rule mentions
+func (rule CompatRule) applies(source, dest int) bool {
+	if rule.RemovedIn == 0 && rule.AddedIn == 0 {
+		return true
+	}
+	if rule.RemovedIn > 0 && dest >= rule.RemovedIn && source < rule.RemovedIn {
+		return true
+	}
+	return rule.AddedIn > 0 && dest < rule.AddedIn && (source == 0 || source >= rule.AddedIn)
+}
+
+type compiledCompatRule struct {
+	CompatRule
+	re *regexp.Regexp
+}
+
+// compatLayer rewrites plain sections for one destination
+type compatLayer struct {
+	source, dest int
+	rules        []compiledCompatRule
+
+	mu   sync.Mutex
+	hits map[string]int
+}
+
+// newCompatLayer selects the rules relevant from the source to the destination major
+// version, plus rules that apply whatever the versions. It returns nil when no rule
+// applies.
+func newCompatLayer(c *CompatConfig, source, dest int, always ...CompatRule) *compatLayer {
+	var rules []CompatRule
+	disabled := make(map[string]bool)
+	if source != dest {
+		rules = builtinCompatRules
+		if c != nil {
+			rules = append(append([]CompatRule(nil), builtinCompatRules...), c.Rules...)
+			for _, name := range c.Disable {
+				disabled[name] = true
+			}
+		}
+	}
+	layer := &compatLayer{source: source, dest: dest, hits: make(map[string]int)}
+	for _, rule := range rules {
+		if disabled[rule.Name] || !rule.applies(source, dest) {
+			continue
+		}
+		layer.rules = append(layer.rules, compiledCompatRule{rule, regexp.MustCompile(rule.Pattern)})
+	}
+	for _, rule := range always {
+		layer.rules = append(layer.rules, compiledCompatRule{rule, regexp.MustCompile(rule.Pattern)})
+	}
+	if len(layer.rules) == 0 {
+		return nil
+	}
+	return layer
+}
+
+// rewrite applies the rules to one line
+func (l *compatLayer) rewrite(line string) string {
+	body := strings.TrimRight(line, "\r\n")
+	ending := line[len(body):]
+	for _, rule := range l.rules {
+		if !rule.re.MatchString(body) {
+			continue
+		}
+		l.mu.Lock()
+		l.hits[rule.Name]++
+		l.mu.Unlock()
+		if !rule.Warn {
+			body = rule.re.ReplaceAllString(body, rule.Replace)
+		}
+	}
+	return body + ending
+}
+
+// rewriteScript copies a SQL script through the rules, leaving COPY data alone
+func (l *compatLayer) rewriteScript(src io.Reader, dst io.Writer) error {
+	reader := bufio.NewReaderSize(src, 1<<20)
+	writer := bufio.NewWriterSize(dst, 1<<20)
+	inCopy := false
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			switch {
+			case inCopy:
+				if strings.TrimRight(line, "\r\n") == `\.` {
+					inCopy = false
+				}
+			case strings.HasPrefix(line, "COPY ") && strings.HasSuffix(strings.TrimRight(line, "\r\n"), "FROM stdin;"):
+				inCopy = true
+			default:
+				line = l.rewrite(line)
+			}
+			if _, werr := writer.WriteString(line); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// report logs how often each rule matched
+func (l *compatLayer) report(file string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, rule := range l.rules {
+		n := l.hits[rule.Name]
+		switch {
+		case n == 0:
+		case rule.Warn:
+			log.Printf("WARNING: %s has %d lines matching %s, which PostgreSQL %d can't restore", file, n, rule.Name, l.dest)
+		default:
+			log.Printf("Rewrote %d lines of %s for PostgreSQL %d (%s)", n, file, l.dest, rule.Name)
+		}
+		delete(l.hits, rule.Name)
+	}
+}
+
+// copy returns a rewritten copy of a plain SQL section in a temporary directory, or the
+// input itself when it is an archive, compressed, or split, which are left as they are
+func (l *compatLayer) copy(inputFile string) (string, func(), error) {
+	none := func() {}
+	if l == nil {
+		return inputFile, none, nil
+	}
+	format, err := DetectArtifactFormat(inputFile)
+	if err != nil {
+		return "", none, err
+	}
+	if format.Kind != FormatPlain || format.Compression != "" || isPartsIndex(inputFile) {
+		if format.Kind == FormatPlain {
+			log.Printf("WARNING: %s is compressed or split; compatibility rules are not applied to it", filepath.Base(inputFile))
+		}
+		return inputFile, none, nil
+	}
+
+	in, err := os.Open(inputFile)
+	if err != nil {
+		return "", none, fmt.Errorf("failed to read %s: %w", inputFile, err)
+	}
+	defer in.Close()
+	dir, err := os.MkdirTemp("", "pg_restore_fdw_compat_")
+	if err != nil {
+		return "", none, fmt.Errorf("failed to create compatibility script directory: %w", err)
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	out, err := os.Create(filepath.Join(dir, filepath.Base(inputFile)))
+	if err != nil {
+		cleanup()
+		return "", none, fmt.Errorf("failed to create compatibility script: %w", err)
+	}
+	defer out.Close()
+	if err := l.rewriteScript(in, out); err != nil {
+		cleanup()
+		return "", none, fmt.Errorf("failed to rewrite %s: %w", inputFile, err)
+	}
+	l.report(filepath.Base(inputFile))
+	return out.Name(), cleanup, nil
+}
+
+// sourceMajorVersions reads the source major versions recorded in a dump set's manifest.
+// Dump sets from before catalogs were recorded yield an empty map.
+func sourceMajorVersions(dir string) map[string]int {
+	versions := make(map[string]int)
+	manifest, err := LoadManifest(dir)
+	if err != nil {
+		return versions
+	}
+	for _, catalog := range manifest.Catalogs {
+		if num, err := strconv.Atoi(catalog.Settings["server_version_num"]); err == nil {
+			versions[catalog.Database] = majorVersion(num)
+		}
+	}
+	return versions
+}
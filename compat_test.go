@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCompatLayerRewriteScript(t *testing.T) {
+	layer := newCompatLayer(nil, 11, 16)
+	if layer == nil {
+		t.Fatal("expected rules from 11 to 16")
+	}
+	script := "SET default_with_oids = false;\nCREATE TABLE public.t (\n    a integer\n)\nWITH OIDS;\nCOPY public.t (a) FROM stdin;\nWITH OIDS;\n\\.\n"
+	var out bytes.Buffer
+	if err := layer.rewriteScript(strings.NewReader(script), &out); err != nil {
+		t.Fatal(err)
+	}
+	want := "\nCREATE TABLE public.t (\n    a integer\n)\n;\nCOPY public.t (a) FROM stdin;\nWITH OIDS;\n\\.\n"
+	if out.String() != want {
+		t.Errorf("rewritten script = %q, want %q", out.String(), want)
+	}
+	if layer.hits["with_oids"] != 1 {
+		t.Errorf("with_oids hits = %d, want 1 (COPY data is left alone)", layer.hits["with_oids"])
+	}
+}
+
+func TestNewCompatLayer(t *testing.T) {
+	if newCompatLayer(nil, 16, 16) != nil {
+		t.Error("same versions need no rules")
+	}
+	layer := newCompatLayer(&CompatConfig{Disable: []string{"transaction_timeout"}}, 17, 16)
+	if layer != nil {
+		t.Errorf("expected no rules from 17 to 16 with transaction_timeout disabled, got %d", len(layer.rules))
+	}
+	layer = newCompatLayer(nil, 17, 13)
+	var names []string
+	for _, rule := range layer.rules {
+		names = append(names, rule.Name)
+	}
+	if got := strings.Join(names, ","); got != "default_toast_compression,column_compression,transaction_timeout" {
+		t.Errorf("rules from 17 to 13 = %s", got)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Config holds optional settings loaded from a JSON configuration file
+type Config struct {
+	BehaviorChecks []BehaviorCheck    `json:"behavior_checks"`
+	Protections    Protections        `json:"protections"`
+	Encryption     *EncryptionConfig  `json:"encryption"`
+	GPG            *GPGConfig         `json:"gpg"`
+	ErrorPolicy    ErrorPolicy        `json:"error_policy"`
+	Tracing        *TracingConfig     `json:"tracing"`
+	Hooks          []Hook             `json:"hooks"`
+	Subscriptions  SubscriptionPolicy `json:"subscriptions"`
+	Incremental    []IncrementalTable `json:"incremental"`
+	Naming         NameTemplates      `json:"naming"`
+	Renames        []RenameRule       `json:"renames"`
+	FDWTarget      *FDWTarget         `json:"fdw_target"`
+	Estimate       EstimateConfig     `json:"estimate"`
+	History        *HistoryConfig     `json:"history"`
+	Workflow       *WorkflowConfig    `json:"workflow"`
+	// Auth selects authentication for source_moodys, source_tenant, dest_moodys, and dest_tenant
+	Auth map[string]*AuthConfig `json:"auth"`
+	// Direct bypasses a pooler for the same connections
+	Direct map[string]DirectEndpoint `json:"direct"`
+	// Throttle limits the load dumps put on the sources
+	Throttle ThrottleConfig `json:"throttle"`
+	// Replicas dumps source_moodys or source_tenant from a read replica
+	Replicas map[string]*ReplicaConfig `json:"replicas"`
+	// Locks reports and optionally ends sessions that block the restore
+	Locks *LockPolicy `json:"locks"`
+	// PostData restores post-data in an index phase and a foreign key phase
+	PostData *PostDataConfig `json:"post_data"`
+	// IndexMemory sizes maintenance_work_mem per post-data worker
+	IndexMemory *IndexMemoryConfig `json:"index_memory"`
+	// Prewarm loads the largest restored tables and indexes into cache after restore
+	Prewarm *PrewarmConfig `json:"prewarm"`
+	// Watchdog reports, and optionally cancels, restore statements that run too long
+	Watchdog *StatementWatchdog `json:"watchdog"`
+	// Validation selects per-table validation strategies; nil compares row counts
+	Validation *ValidationConfig `json:"validation"`
+	// QueryPack holds checks with known answers run against the restored databases
+	QueryPack []QueryCheck `json:"query_pack"`
+	// Replay replays representative read-only queries with latency thresholds after restore
+	Replay *ReplayConfig `json:"replay"`
+	// AppRole smoke-tests read access as the application's role after restore
+	AppRole *AppRoleCheck `json:"app_role"`
+	// Grants render GRANT statements for the restored databases
+	Grants []GrantTemplate `json:"grants"`
+	// OnRestoreFailure is keep, drop-created-databases, or rename; see FailureKeep
+	OnRestoreFailure string `json:"on_restore_failure"`
+	// Storage uploads dump sets to S3 or GCS and downloads them for restore
+	Storage *StorageConfig `json:"storage"`
+	// ImportForeignSchema rebuilds tenant foreign tables from the remote schema
+	ImportForeignSchema *ImportForeignSchemaConfig `json:"import_foreign_schema"`
+	// FDWTuning sets performance options on the rewritten foreign servers and tables
+	FDWTuning *FDWTuning `json:"fdw_tuning"`
+	// Transforms rewrite plain sections before they are restored
+	Transforms []TransformRule `json:"transforms"`
+	// Compat adjusts the rules applied when restoring into another major version
+	Compat *CompatConfig `json:"compat"`
+	// Provider adapts restores to a managed service such as RDS at the destination
+	Provider *ProviderConfig `json:"provider"`
+	// Email mails the run report to a distribution list when a run ends
+	Email *EmailConfig `json:"email"`
+	// Daemon lists the runs the daemon subcommand starts on a schedule
+	Daemon *DaemonConfig `json:"daemon"`
+	// Delta keeps unchanged tables' archives from the previous dump set
+	Delta *DeltaConfig `json:"delta"`
+	// Locale checks the destinations' encoding and collation against the sources'
+	Locale *LocaleCheckConfig `json:"locale"`
+	// DatabaseSettings reapplies the sources' database-level settings after restore
+	DatabaseSettings *DatabaseSettingsConfig `json:"database_settings"`
+	// MaintenanceWindow limits when phases that change the destinations may start
+	MaintenanceWindow *MaintenanceWindowConfig `json:"maintenance_window"`
+	// SchemaSplit restores some tenant schemas into databases of their own
+	SchemaSplit []SplitTarget `json:"schema_split"`
+	// Consolidate lists the databases the consolidate subcommand restores into one
+	Consolidate *ConsolidateConfig `json:"consolidate"`
+	// FanOut restores to further destination clusters alongside dest_moodys and dest_tenant
+	FanOut *FanOutConfig `json:"fan_out"`
+	// EnvPassthrough names variables, or patterns such as MY_*, that the client commands
+	// inherit on top of the basic ones; ambient PG* variables are otherwise left out
+	EnvPassthrough []string `json:"env_passthrough"`
+
+	// Defaults and Connections override the built-in source_* and dest_* connections;
+	// Profiles hold further overrides selected with -profile
+	Defaults    ConnectionSettings            `json:"defaults"`
+	Connections map[string]ConnectionSettings `json:"connections"`
+	Profiles    map[string]ProfileConfig      `json:"profiles"`
+}
+
+// LoadConfig reads a JSON configuration file. An empty path yields an empty config.
+func LoadConfig(path string) (*Config, error) {
+	cfg := &Config{}
+	if path == "" {
+		return cfg, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	if err := json.Unmarshal(content, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	return cfg, nil
+}
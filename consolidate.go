@@ -0,0 +1,306 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ConsolidateConfig restores several databases of a dump set into schemas of one
+// destination database, e.g. to fold small databases into one on a shared cluster
+type ConsolidateConfig struct {
+	// Dest overrides dest_tenant's connection for the consolidated database; dbname is
+	// required
+	Dest    ConnectionSettings  `json:"dest"`
+	Sources []ConsolidateSource `json:"sources"`
+}
+
+// ConsolidateSource is a database of the dump set restored into the consolidated one
+type ConsolidateSource struct {
+	// Database names the dumped database: moodys, tenant, or a workflow database
+	Database string `json:"database"`
+	// Schema receives the database's public schema; default the database name. Its other
+	// schemas become <schema>_<name>.
+	Schema string `json:"schema"`
+	// Schemas maps source schemas to destination schemas, overriding the default
+	Schemas map[string]string `json:"schemas"`
+}
+
+func (c *ConsolidateConfig) validate() error {
+	if c.Dest.DBName == "" {
+		return fmt.Errorf("dest needs a dbname")
+	}
+	if len(c.Sources) < 2 {
+		return fmt.Errorf("list at least two sources")
+	}
+	seen := make(map[string]bool)
+	for _, s := range c.Sources {
+		if s.Database == "" {
+			return fmt.Errorf("sources need a database")
+		}
+		if seen[s.Database] {
+			return fmt.Errorf("database %s is listed twice", s.Database)
+		}
+		seen[s.Database] = true
+	}
+	return nil
+}
+
+// schemaMap maps each of the source's dumped schemas to its destination schema
+func (s ConsolidateSource) schemaMap(schemas []string) map[string]string {
+	prefix := s.Schema
+	if prefix == "" {
+		prefix = s.Database
+	}
+	mapping := make(map[string]string)
+	for _, schema := range schemas {
+		switch to, ok := s.Schemas[schema]; {
+		case ok:
+			mapping[schema] = to
+		case schema == "public":
+			mapping[schema] = prefix
+		default:
+			mapping[schema] = prefix + "_" + schema
+		}
+	}
+	return mapping
+}
+
+// dumpedSchemas lists the schemas a pre-data script creates, plus public, which recent
+// versions of pg_dump don't create
+func dumpedSchemas(entries []scriptEntry) []string {
+	schemas := []string{"public"}
+	for _, entry := range entries {
+		if entry.Type == "SCHEMA" && entry.Name != "public" {
+			schemas = append(schemas, entry.Name)
+		}
+	}
+	return schemas
+}
+
+// isGlobalObject reports whether an entry is database-wide rather than in a schema, such
+// as an extension, foreign server, or event trigger. Schemas and their comments and
+// grants are renamed with the schema instead.
+func isGlobalObject(typ, schema, name string) bool {
+	return (schema == "" || schema == "-") && typ != "SCHEMA" && !strings.HasPrefix(name, "SCHEMA ")
+}
+
+// globalObjects tracks which source created each global object of the consolidated
+// database, so later sources skip their conflicting copies
+type globalObjects map[string]string
+
+// claim records database as the creator of an object, or returns the database that
+// already created it
+func (g globalObjects) claim(database, typ, name string) (string, bool) {
+	key := typ + " " + name
+	if owner, ok := g[key]; ok {
+		return owner, false
+	}
+	g[key] = database
+	return database, true
+}
+
+// skipGlobal logs and records a global object left out because another source created it
+func skipGlobal(dest DBConfig, database, owner string, entry TOCEntry) {
+	log.Printf("WARNING: skipping %s %s of %s: %s already created it in %s", entry.Desc, entry.Name, database, owner, dest.DBName)
+	activeReport.recordSkipped(dest.DBName, entry, fmt.Sprintf("%s of %s conflicts with the one of %s", entry.Desc, database, owner))
+}
+
+// consolidatedSource is a source prepared for restore
+type consolidatedSource struct {
+	ConsolidateSource
+	renamer *renamer
+	preData string
+}
+
+// Consolidate restores the sources' databases from the dump set in dir into schemas of
+// dest: every source's pre-data first, in order, then each one's data and post-data.
+// References to a source's schemas are rewritten in every section, and global objects
+// another source already created are skipped. When tenant and moodys are both
+// consolidated, tenant's foreign server is pointed back at dest and its foreign tables at
+// moodys' new schemas.
+func Consolidate(c *ConsolidateConfig, dir string, srcMoodys, dest DBConfig, opts RestoreOptions) error {
+	codec, err := newArtifactCodec(opts.Encryption, opts.GPG)
+	if err != nil {
+		return err
+	}
+	artifacts := newArtifactResolver(dir, codec)
+	defer artifacts.Cleanup()
+	if err := setStepLogDir(filepath.Join(dir, "logs")); err != nil {
+		return err
+	}
+	scratch, err := os.MkdirTemp("", "pg_restore_fdw_consolidate_")
+	if err != nil {
+		return fmt.Errorf("failed to create consolidation directory: %w", err)
+	}
+	defer os.RemoveAll(scratch)
+
+	// Rename rules come from the schemas each pre-data script creates
+	globals := make(globalObjects)
+	targets := make(map[string]string)
+	renamers := make(map[string]*renamer)
+	var sources []consolidatedSource
+	for _, s := range c.Sources {
+		inFile, err := artifacts.Resolve(sectionArtifactName(dir, s.Database, "pre-data"))
+		if err != nil {
+			return err
+		}
+		inFile, _, cleanup, err := prepareArtifact(inFile)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		preamble, entries, err := splitScript(inFile)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", inFile, err)
+		}
+
+		var rules []RenameRule
+		mapping := s.schemaMap(dumpedSchemas(entries))
+		for _, schema := range sortedKeys(mapping) {
+			if other, ok := targets[mapping[schema]]; ok {
+				return fmt.Errorf("schema %s of %s and %s would both be restored into %s", schema, s.Database, other, mapping[schema])
+			}
+			targets[mapping[schema]] = s.Database + "." + schema
+			rules = append(rules, RenameRule{Schema: schema, To: mapping[schema]})
+		}
+		if renamers[s.Database], err = newRenamer(rules, s.Database); err != nil {
+			return err
+		}
+
+		var kept []scriptEntry
+		for _, entry := range entries {
+			if isGlobalObject(entry.Type, entry.Schema, entry.Name) {
+				if owner, ok := globals.claim(s.Database, entry.Type, entry.Name); !ok {
+					skipGlobal(dest, s.Database, owner, TOCEntry{Desc: entry.Type, Schema: "-", Name: entry.Name})
+					continue
+				}
+			}
+			kept = append(kept, entry)
+		}
+		script, _ := renderEntries(preamble, kept)
+		preData := filepath.Join(scratch, s.Database+"_pre-data.sql")
+		if err := os.WriteFile(preData, []byte(script), 0600); err != nil {
+			return fmt.Errorf("failed to write pre-data of %s: %w", s.Database, err)
+		}
+		sources = append(sources, consolidatedSource{ConsolidateSource: s, renamer: renamers[s.Database], preData: preData})
+	}
+
+	created := &createdDatabases{}
+	if err := CreateDatabase(dest); err != nil {
+		return fmt.Errorf("failed to create database %s: %w", dest.DBName, err)
+	}
+	created.add(dest)
+	if err := consolidateSections(sources, renamers, artifacts, dir, srcMoodys, dest, globals, opts); err != nil {
+		created.cleanUp(opts.OnFailure)
+		return err
+	}
+	return nil
+}
+
+// consolidateSections restores the prepared sources into dest
+func consolidateSections(sources []consolidatedSource, renamers map[string]*renamer, artifacts *artifactResolver, dir string, srcMoodys, dest DBConfig, globals globalObjects, opts RestoreOptions) error {
+	sessions := recordedSessionSettings(dir)
+	version, err := serverVersionNum(maintenanceConfig(dest))
+	if err != nil {
+		return err
+	}
+	sourceVersions := sourceMajorVersions(dir)
+	var always []CompatRule
+	if opts.NonSuperuser == NonSuperuserSkip {
+		privileges, err := lookUpPrivileges(dest.DBName, dest, opts.Provider)
+		if err != nil {
+			return fmt.Errorf("failed to check privileges on %s: %w", dest.DBName, err)
+		}
+		always = superuserRules(privileges)
+	}
+	always = append(always, opts.Provider.providerRules()...)
+	for _, s := range sources {
+		preData := s.preData
+		var remote *renamer
+		if s.Database == "tenant" && renamers["moodys"] != nil {
+			log.Printf("Pointing tenant's foreign server at moodys' schemas in %s", dest.DBName)
+			if err := modifyPreDataFile(preData, srcMoodys, dest); err != nil {
+				return fmt.Errorf("failed to modify tenant pre-data file: %w", err)
+			}
+			remote = renamers["moodys"]
+		}
+		// Pre-data dumped by another major version is rewritten like the built-in restore's
+		compat := newCompatLayer(opts.Compat, sourceVersions[s.Database], majorVersion(version), always...)
+		preData, cleanupCompat, err := compat.copy(preData)
+		if err != nil {
+			return err
+		}
+		defer cleanupCompat()
+		preData, cleanupTransforms, err := applyTransforms(opts.Transforms, s.Database, "pre-data", preData)
+		if err != nil {
+			return err
+		}
+		defer cleanupTransforms()
+		renamed, err := renamedCopy(preData, s.renamer, remote)
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(filepath.Dir(renamed))
+
+		log.Printf("Restoring pre-data of %s into %s", s.Database, dest.DBName)
+		sectionOpts := opts
+		sectionOpts.session = sessions[s.Database]
+		if err := restoreDatabaseSection(dest, renamed, "pre-data", sectionOpts); err != nil {
+			return fmt.Errorf("failed to restore %s pre-data: %w", s.Database, err)
+		}
+	}
+
+	for _, section := range []string{"data", "post-data"} {
+		for _, s := range sources {
+			inFile, err := artifacts.Resolve(sectionArtifactName(dir, s.Database, section))
+			if err != nil {
+				return err
+			}
+			inFile, format, cleanup, err := prepareArtifact(inFile)
+			if err != nil {
+				return err
+			}
+			defer cleanup()
+			if !format.archive() {
+				return fmt.Errorf("consolidating needs archive %s dumps, but %s is plain SQL", section, inFile)
+			}
+			entries, err := listTOC(inFile)
+			if err != nil {
+				return err
+			}
+			var kept []TOCEntry
+			for _, entry := range entries {
+				// Large object data follows its definition, claimed in pre-data
+				if section == "post-data" && isGlobalObject(entry.Desc, entry.Schema, entry.Name) {
+					if owner, ok := globals.claim(s.Database, entry.Desc, entry.Name); !ok {
+						skipGlobal(dest, s.Database, owner, entry)
+						continue
+					}
+				}
+				kept = append(kept, entry)
+			}
+			log.Printf("Restoring %s of %s into %s", section, s.Database, dest.DBName)
+			sectionOpts := opts
+			sectionOpts.renamer, sectionOpts.session = s.renamer, sessions[s.Database]
+			if section == "post-data" && opts.PostData != nil {
+				if err := restorePostDataPhases(dest, inFile, section, kept, sectionOpts); err != nil {
+					return fmt.Errorf("failed to restore %s %s: %w", s.Database, section, err)
+				}
+				continue
+			}
+			listFile, cleanupList, err := tempTOCList(kept)
+			if err != nil {
+				return err
+			}
+			defer cleanupList()
+			sectionOpts.listFile = listFile
+			if err := restoreDatabaseSection(dest, inFile, section, sectionOpts); err != nil {
+				return fmt.Errorf("failed to restore %s %s: %w", s.Database, section, err)
+			}
+		}
+	}
+	return nil
+}
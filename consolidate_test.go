@@ -0,0 +1,55 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConsolidateSchemaMap(t *testing.T) {
+	source := ConsolidateSource{Database: "billing", Schemas: map[string]string{"audit": "audit_billing"}}
+	got := source.schemaMap([]string{"public", "invoices", "audit"})
+	want := map[string]string{"public": "billing", "invoices": "billing_invoices", "audit": "audit_billing"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	source = ConsolidateSource{Database: "moodys", Schema: "ratings"}
+	if got := source.schemaMap([]string{"public"}); got["public"] != "ratings" {
+		t.Errorf("got %v", got)
+	}
+
+	schemas := dumpedSchemas([]scriptEntry{{Name: "sales", Type: "SCHEMA", Schema: "-"}, {Name: "orders", Type: "TABLE", Schema: "sales"}})
+	if !reflect.DeepEqual(schemas, []string{"public", "sales"}) {
+		t.Errorf("got %v", schemas)
+	}
+}
+
+func TestGlobalObjects(t *testing.T) {
+	for _, c := range []struct {
+		typ, schema, name string
+		want              bool
+	}{
+		{"EXTENSION", "-", "postgres_fdw", true},
+		{"COMMENT", "-", "EXTENSION postgres_fdw", true},
+		{"EVENT TRIGGER", "", "audit_ddl", true},
+		{"SCHEMA", "-", "sales", false},
+		{"COMMENT", "-", "SCHEMA sales", false},
+		{"TABLE", "public", "orders", false},
+	} {
+		if got := isGlobalObject(c.typ, c.schema, c.name); got != c.want {
+			t.Errorf("isGlobalObject(%s %s.%s) = %v", c.typ, c.schema, c.name, got)
+		}
+	}
+
+	globals := make(globalObjects)
+	if _, ok := globals.claim("moodys", "EXTENSION", "postgres_fdw"); !ok {
+		t.Error("first claim should succeed")
+	}
+	if owner, ok := globals.claim("tenant", "EXTENSION", "postgres_fdw"); ok || owner != "moodys" {
+		t.Errorf("second claim got %q, %v", owner, ok)
+	}
+
+	if err := (&ConsolidateConfig{Dest: ConnectionSettings{DBName: "combined"}, Sources: []ConsolidateSource{{Database: "a"}, {Database: "a"}}}).validate(); err == nil {
+		t.Error("expected a source listed twice to fail")
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// defaultCopyBatchSize is the number of rows sent per COPY statement
+const defaultCopyBatchSize = 50000
+
+// copyLineRe extracts the failing line number from a COPY error context
+var copyLineRe = regexp.MustCompile(`CONTEXT:\s+COPY [^,]+, line (\d+)`)
+
+// copySQLStateRe extracts the SQLSTATE psql reports with VERBOSITY=verbose
+var copySQLStateRe = regexp.MustCompile(`ERROR:\s+([0-9A-Z]{5}):`)
+
+// CopyLoader streams table data from a custom-format dump into the destination with
+// COPY, skipping rows the destination rejects up to MaxBadRows per table
+type CopyLoader struct {
+	Config     DBConfig
+	BatchSize  int
+	MaxBadRows int
+	SpillDir   string
+
+	// copyFn sends one batch of rows; replaced in tests
+	copyFn func(statement string, rows []string) error
+	// truncateFn empties a table before its rows are loaded; replaced in tests
+	truncateFn func(entry TOCEntry) error
+}
+
+// NewCopyLoader creates a loader writing rejected rows under spillDir
+func NewCopyLoader(config DBConfig, maxBadRows int, spillDir string) *CopyLoader {
+	l := &CopyLoader{
+		Config:     config,
+		BatchSize:  defaultCopyBatchSize,
+		MaxBadRows: maxBadRows,
+		SpillDir:   spillDir,
+	}
+	l.copyFn = l.psqlCopy
+	l.truncateFn = func(entry TOCEntry) error { return truncateTable(config, entry) }
+	return l
+}
+
+// rejectedRow is a row the destination refused, with the reason
+type rejectedRow struct {
+	Row    string
+	Reason string
+}
+
+// psqlCopy pipes a batch of COPY text-format rows into psql
+func (l *CopyLoader) psqlCopy(statement string, rows []string) error {
+	cmd := newPsqlCmd(l.Config, "-v", "ON_ERROR_STOP=1", "-v", "VERBOSITY=verbose", "-c", statement)
+	cmd.Stdin = strings.NewReader(strings.Join(rows, "\n") + "\n")
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w\nOutput: %s", err, output)
+	}
+	return nil
+}
+
+// loadBatch loads rows, isolating rows the destination rejects as invalid data by the
+// reported line number or by bisection. It stops once more than allowed rows are
+// rejected, and fails on any error that isn't about the data itself.
+func (l *CopyLoader) loadBatch(statement string, rows []string, allowed int) ([]rejectedRow, error) {
+	var rejected []rejectedRow
+
+	for len(rows) > 0 {
+		err := l.copyFn(statement, rows)
+		if err == nil {
+			return rejected, nil
+		}
+		// Connection, permission, missing-relation, or disk-full errors fail every row alike
+		if !isCopyDataError(err) {
+			return rejected, err
+		}
+		reason := copyErrorReason(err)
+
+		if len(rows) == 1 {
+			return append(rejected, rejectedRow{Row: rows[0], Reason: reason}), nil
+		}
+
+		if match := copyLineRe.FindStringSubmatch(err.Error()); match != nil {
+			line, _ := strconv.Atoi(match[1])
+			if line >= 1 && line <= len(rows) {
+				rejected = append(rejected, rejectedRow{Row: rows[line-1], Reason: reason})
+				rows = append(rows[:line-1:line-1], rows[line:]...)
+				if len(rejected) > allowed {
+					return rejected, nil
+				}
+				continue
+			}
+		}
+
+		// No usable line number; split the batch and load each half independently
+		mid := len(rows) / 2
+		for _, half := range [][]string{rows[:mid], rows[mid:]} {
+			halfRejected, err := l.loadBatch(statement, half, allowed-len(rejected))
+			rejected = append(rejected, halfRejected...)
+			if err != nil || len(rejected) > allowed {
+				return rejected, err
+			}
+		}
+		return rejected, nil
+	}
+
+	return rejected, nil
+}
+
+// isCopyDataError reports whether a COPY failed on the rows themselves: a data
+// exception (SQLSTATE class 22) or an integrity constraint violation (class 23)
+func isCopyDataError(err error) bool {
+	match := copySQLStateRe.FindStringSubmatch(err.Error())
+	return match != nil && (strings.HasPrefix(match[1], "22") || strings.HasPrefix(match[1], "23"))
+}
+
+// copyErrorReason extracts the first ERROR line from psql output
+func copyErrorReason(err error) string {
+	for _, line := range strings.Split(err.Error(), "\n") {
+		if idx := strings.Index(line, "ERROR:"); idx >= 0 {
+			return strings.TrimSpace(line[idx:])
+		}
+	}
+	return err.Error()
+}
+
+// LoadTable restores one TABLE DATA entry, returning the number of loaded and rejected rows.
+// It fails once more than MaxBadRows rows are rejected. Each batch commits on its own,
+// so the table is emptied first in case an earlier attempt left it half-loaded.
+func (l *CopyLoader) LoadTable(inputFile string, entry TOCEntry) (int, int, error) {
+	listFile, err := os.CreateTemp("", "pg_restore_fdw_*.list")
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to create TOC list file: %w", err)
+	}
+	listFile.Close()
+	defer os.Remove(listFile.Name())
+	if err := writeTOCList(listFile.Name(), []TOCEntry{entry}); err != nil {
+		return 0, 0, err
+	}
+
+	// pg_restore without -d writes the SQL script, including COPY data, to stdout
+	cmd := newPgRestoreScriptCmd(listFile.Name(), inputFile)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to open pg_restore output: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return 0, 0, fmt.Errorf("failed to start pg_restore: %w", err)
+	}
+
+	loaded, rejected, loadErr := l.loadStream(stdout, entry)
+	if loadErr != nil {
+		io.Copy(io.Discard, stdout)
+	}
+	if err := cmd.Wait(); err != nil && loadErr == nil {
+		loadErr = fmt.Errorf("pg_restore failed extracting %s: %w", entry.QualifiedName(), err)
+	}
+	return loaded, rejected, loadErr
+}
+
+// loadStream reads a pg_restore SQL script and loads the rows of its COPY blocks in batches
+func (l *CopyLoader) loadStream(r io.Reader, entry TOCEntry) (int, int, error) {
+	table := entry.QualifiedName()
+	if err := l.truncateFn(entry); err != nil {
+		return 0, 0, err
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 1024*1024), 256*1024*1024)
+
+	var (
+		statement string
+		batch     []string
+		loaded    int
+		rejected  []rejectedRow
+	)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		batchRejected, err := l.loadBatch(statement, batch, l.MaxBadRows-len(rejected))
+		rejected = append(rejected, batchRejected...)
+		if err != nil {
+			return fmt.Errorf("failed to load %s: %w", table, err)
+		}
+		loaded += len(batch) - len(batchRejected)
+		batch = batch[:0]
+
+		if len(rejected) > l.MaxBadRows {
+			return fmt.Errorf("table %s rejected %d rows, exceeding the limit of %d (last error: %s)",
+				table, len(rejected), l.MaxBadRows, rejected[len(rejected)-1].Reason)
+		}
+		return nil
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if statement == "" {
+			if strings.HasPrefix(line, "COPY ") && strings.HasSuffix(line, " FROM stdin;") {
+				statement = strings.TrimSuffix(line, " FROM stdin;") + " FROM STDIN"
+			}
+			continue
+		}
+
+		if line == `\.` {
+			if err := flush(); err != nil {
+				return loaded, len(rejected), l.spill(table, rejected, err)
+			}
+			statement = ""
+			continue
+		}
+
+		batch = append(batch, line)
+		if len(batch) >= l.BatchSize {
+			if err := flush(); err != nil {
+				return loaded, len(rejected), l.spill(table, rejected, err)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return loaded, len(rejected), fmt.Errorf("failed to read COPY data for %s: %w", table, err)
+	}
+
+	if err := l.spill(table, rejected, nil); err != nil {
+		return loaded, len(rejected), err
+	}
+	if len(rejected) > 0 {
+		log.Printf("Loaded %d rows into %s, skipped %d rejected rows", loaded, table, len(rejected))
+	}
+	return loaded, len(rejected), nil
+}
+
+// truncateTable empties a table before its data is reloaded, leaving tables that
+// inherit from it alone
+func truncateTable(config DBConfig, entry TOCEntry) error {
+	sql := fmt.Sprintf("TRUNCATE TABLE ONLY %s;", entry.QuotedName())
+	if output, err := newPsqlCmd(config, "-v", "ON_ERROR_STOP=1", "-c", sql).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to truncate %s before loading it: %w\nOutput: %s", entry.QualifiedName(), err, output)
+	}
+	return nil
+}
+
+// spill writes rejected rows with their error reasons to <SpillDir>/<db>_<table>.rejected.
+// The passed error is returned unchanged unless writing the spill file itself fails.
+func (l *CopyLoader) spill(table string, rejected []rejectedRow, loadErr error) error {
+	if len(rejected) == 0 {
+		return loadErr
+	}
+
+	if err := os.MkdirAll(l.SpillDir, 0755); err != nil {
+		return fmt.Errorf("failed to create spill directory: %w", err)
+	}
+
+	var b strings.Builder
+	for _, r := range rejected {
+		fmt.Fprintf(&b, "-- %s\n%s\n", r.Reason, r.Row)
+	}
+
+	path := filepath.Join(l.SpillDir, fmt.Sprintf("%s_%s.rejected", l.Config.DBName, table))
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write spill file %s: %w", path, err)
+	}
+
+	log.Printf("Wrote %d rejected rows for %s to %s", len(rejected), table, path)
+	return loadErr
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCopyLoaderLoadBatch(t *testing.T) {
+	bad := map[string]bool{"row3": true, "row7": true}
+
+	tests := []struct {
+		name       string
+		reportLine bool
+	}{
+		{"line number reported", true},
+		{"bisection without line number", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var loaded []string
+			loader := &CopyLoader{MaxBadRows: 10}
+			loader.copyFn = func(statement string, rows []string) error {
+				for i, row := range rows {
+					if bad[row] {
+						msg := "ERROR:  22P02: invalid input syntax for type integer"
+						if tt.reportLine {
+							msg += fmt.Sprintf("\nCONTEXT:  COPY t, line %d", i+1)
+						}
+						return errors.New(msg)
+					}
+				}
+				loaded = append(loaded, rows...)
+				return nil
+			}
+
+			var rows []string
+			for i := 1; i <= 10; i++ {
+				rows = append(rows, fmt.Sprintf("row%d", i))
+			}
+
+			rejected, err := loader.loadBatch("COPY t FROM STDIN", rows, loader.MaxBadRows)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(rejected) != 2 {
+				t.Fatalf("expected 2 rejected rows, got %d", len(rejected))
+			}
+			for _, r := range rejected {
+				if !bad[r.Row] {
+					t.Errorf("unexpected rejected row %q", r.Row)
+				}
+				if !strings.HasPrefix(r.Reason, "ERROR:") {
+					t.Errorf("unexpected reason %q", r.Reason)
+				}
+			}
+			if len(loaded) != 8 {
+				t.Errorf("expected 8 loaded rows, got %d", len(loaded))
+			}
+		})
+	}
+}
+
+func TestCopyLoaderLoadBatchFailsFast(t *testing.T) {
+	calls := 0
+	loader := &CopyLoader{MaxBadRows: 10}
+	loader.copyFn = func(statement string, rows []string) error {
+		calls++
+		return errors.New("ERROR:  42501: permission denied for table t")
+	}
+
+	rows := []string{"row1", "row2", "row3", "row4"}
+	rejected, err := loader.loadBatch("COPY t FROM STDIN", rows, loader.MaxBadRows)
+	if err == nil {
+		t.Fatal("expected a non-data error to fail the batch")
+	}
+	if len(rejected) != 0 || calls != 1 {
+		t.Errorf("rejected %d rows in %d attempts, want none in 1", len(rejected), calls)
+	}
+
+	// Without a SQLSTATE, e.g. a lost connection, nothing is treated as bad data
+	loader.copyFn = func(statement string, rows []string) error {
+		return errors.New("psql: error: connection to server failed")
+	}
+	if _, err := loader.loadBatch("COPY t FROM STDIN", rows, loader.MaxBadRows); err == nil {
+		t.Error("expected a connection error to fail the batch")
+	}
+}
+
+func TestCopyLoaderLoadBatchStopsBisecting(t *testing.T) {
+	calls := 0
+	loader := &CopyLoader{MaxBadRows: 1}
+	loader.copyFn = func(statement string, rows []string) error {
+		calls++
+		return errors.New("ERROR:  23505: duplicate key value violates unique constraint")
+	}
+
+	var rows []string
+	for i := 1; i <= 64; i++ {
+		rows = append(rows, fmt.Sprintf("row%d", i))
+	}
+	rejected, err := loader.loadBatch("COPY t FROM STDIN", rows, loader.MaxBadRows)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rejected) != 2 {
+		t.Errorf("expected bisection to stop after 2 rejected rows, got %d", len(rejected))
+	}
+	if calls > 20 {
+		t.Errorf("expected bisection to stop early, made %d attempts", calls)
+	}
+}
+
+func TestCopyLoaderReloadAfterPartialLoad(t *testing.T) {
+	script := `SET statement_timeout = 0;
+COPY public.t (id) FROM stdin;
+1
+2
+x
+4
+\.
+`
+	entry := TOCEntry{Desc: "TABLE DATA", Schema: "public", Name: "t"}
+
+	// The destination commits each batch on its own, like separate COPY statements
+	var table []string
+	var truncated []string
+	loader := &CopyLoader{BatchSize: 2, MaxBadRows: 0, SpillDir: t.TempDir(), Config: DBConfig{DBName: "tenant_dest"}}
+	loader.truncateFn = func(e TOCEntry) error {
+		truncated = append(truncated, e.QuotedName())
+		table = nil
+		return nil
+	}
+	loader.copyFn = func(statement string, rows []string) error {
+		for i, row := range rows {
+			if row == "x" {
+				return fmt.Errorf("ERROR:  22P02: invalid input syntax for type integer\nCONTEXT:  COPY t, line %d", i+1)
+			}
+		}
+		table = append(table, rows...)
+		return nil
+	}
+
+	if _, _, err := loader.loadStream(strings.NewReader(script), entry); err == nil {
+		t.Fatal("expected the load to exceed max bad rows")
+	}
+	if len(table) != 2 {
+		t.Fatalf("expected the first batch to stay committed, table has %q", table)
+	}
+
+	// Retrying with a higher limit starts from an empty table
+	loader.MaxBadRows = 1
+	loaded, rejected, err := loader.loadStream(strings.NewReader(script), entry)
+	if err != nil {
+		t.Fatalf("retry failed: %v", err)
+	}
+	if loaded != 3 || rejected != 1 {
+		t.Errorf("retry loaded %d and rejected %d rows, want 3 and 1", loaded, rejected)
+	}
+	if strings.Join(table, ",") != "1,2,4" {
+		t.Errorf("table after retry = %q, want each row once", table)
+	}
+	if len(truncated) != 2 || truncated[0] != `"public"."t"` {
+		t.Errorf("truncated %q, want the table emptied before each load", truncated)
+	}
+}
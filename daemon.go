@@ -0,0 +1,392 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"text/template"
+	"time"
+)
+
+// minJobInterval keeps a misconfigured job from running back to back
+const minJobInterval = time.Minute
+
+// daemonTick is how often the daemon checks for due jobs
+const daemonTick = 15 * time.Second
+
+// DaemonConfig lists the runs the daemon starts on a schedule
+type DaemonConfig struct {
+	Jobs []ScheduledJob `json:"jobs"`
+	// Listen is the address of the REST API, e.g. "127.0.0.1:8086"; the API is off
+	// without one
+	Listen string `json:"listen"`
+	// Token is the bearer token every API request needs; PG_RESTORE_FDW_API_TOKEN when
+	// unset
+	Token string `json:"token"`
+}
+
+// ScheduledJob is a run the daemon starts on a schedule, as a child process
+type ScheduledJob struct {
+	Name string `json:"name"`
+	// Args are the run's command line without the program name, e.g.
+	// ["-config", "/etc/pg_restore_fdw/nightly.json", "-blue-green", "restore"]
+	Args []string `json:"args"`
+	// Every starts the job at a fixed interval, e.g. "6h"
+	Every string `json:"every"`
+	// At starts the job every day at these local times, e.g. ["02:30"]
+	At []string `json:"at"`
+}
+
+func (d *DaemonConfig) validate() error {
+	if d.Listen != "" && d.apiToken() == "" {
+		return fmt.Errorf("listen needs a token, or PG_RESTORE_FDW_API_TOKEN set, since the API starts runs")
+	}
+	seen := make(map[string]bool)
+	for _, job := range d.Jobs {
+		if job.Name == "" || seen[job.Name] {
+			return fmt.Errorf("every job needs a unique name; %q isn't", job.Name)
+		}
+		seen[job.Name] = true
+		if len(job.Args) == 0 {
+			return fmt.Errorf("job %s has no args", job.Name)
+		}
+		if (job.Every == "") == (len(job.At) == 0) {
+			return fmt.Errorf("job %s needs either every or at", job.Name)
+		}
+		if job.Every != "" {
+			interval, err := time.ParseDuration(job.Every)
+			if err != nil {
+				return fmt.Errorf("job %s: %w", job.Name, err)
+			}
+			if interval < minJobInterval {
+				return fmt.Errorf("job %s runs more often than every %s", job.Name, minJobInterval)
+			}
+		}
+		for _, at := range job.At {
+			if _, err := time.Parse("15:04", at); err != nil {
+				return fmt.Errorf("job %s: at %q is not HH:MM", job.Name, at)
+			}
+		}
+	}
+	return nil
+}
+
+// apiToken returns the token API requests need
+func (d *DaemonConfig) apiToken() string {
+	if d.Token != "" {
+		return d.Token
+	}
+	return os.Getenv("PG_RESTORE_FDW_API_TOKEN")
+}
+
+// nextRun returns when a job is next due after a time: the interval after it, or the
+// first of the daily times after it
+func nextRun(job ScheduledJob, after time.Time) time.Time {
+	if job.Every != "" {
+		interval, _ := time.ParseDuration(job.Every)
+		return after.Add(interval)
+	}
+	var next time.Time
+	for _, at := range job.At {
+		t, _ := time.Parse("15:04", at)
+		candidate := time.Date(after.Year(), after.Month(), after.Day(), t.Hour(), t.Minute(), 0, 0, after.Location())
+		if !candidate.After(after) {
+			candidate = candidate.AddDate(0, 0, 1)
+		}
+		if next.IsZero() || candidate.Before(next) {
+			next = candidate
+		}
+	}
+	return next
+}
+
+// scheduledJob is a job with its next start, whether a run of it is in progress, and
+// how its last run went
+type scheduledJob struct {
+	ScheduledJob
+	next    time.Time
+	running bool
+
+	lastStart, lastFinish time.Time
+	lastError             string
+}
+
+// Daemon starts scheduled runs until it is stopped. SIGHUP reloads the job list from
+// the configuration file; SIGTERM and SIGINT stop it once running jobs have finished.
+type Daemon struct {
+	configPath string
+	exe        string
+	// listen is the REST API's address, empty when the API is off
+	listen string
+	// output receives job output; nil sends it to the daemon's stdout and stderr
+	output io.Writer
+	// stop asks Run to stop, as SIGTERM does
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	mu    sync.Mutex
+	jobs  []*scheduledJob
+	token string
+	// stopping refuses new runs while the running ones finish
+	stopping bool
+	wg       sync.WaitGroup
+}
+
+// NewDaemon schedules the configured jobs from now
+func NewDaemon(configPath string, config DaemonConfig) (*Daemon, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	d := &Daemon{configPath: configPath, exe: exe, listen: config.Listen, token: config.apiToken(), stop: make(chan struct{})}
+	d.setJobs(config.Jobs, time.Now())
+	return d, nil
+}
+
+// setJobs replaces the job list, keeping the next start of jobs whose schedule didn't change
+func (d *Daemon) setJobs(jobs []ScheduledJob, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	previous := make(map[string]*scheduledJob)
+	for _, job := range d.jobs {
+		previous[job.Name] = job
+	}
+	d.jobs = nil
+	for _, job := range jobs {
+		scheduled := &scheduledJob{ScheduledJob: job, next: nextRun(job, now)}
+		if old, ok := previous[job.Name]; ok {
+			scheduled.running = old.running
+			scheduled.lastStart, scheduled.lastFinish, scheduled.lastError = old.lastStart, old.lastFinish, old.lastError
+			if old.Every == job.Every && strings.Join(old.At, ",") == strings.Join(job.At, ",") {
+				scheduled.next = old.next
+			}
+		}
+		d.jobs = append(d.jobs, scheduled)
+		alwaysLog.Printf("Job %s scheduled for %s", job.Name, scheduled.next.Format("2006-01-02 15:04:05 MST"))
+	}
+}
+
+// reload reads the job list and API token from the configuration file again, keeping
+// the current ones when the file is invalid. The API's address only changes on restart.
+func (d *Daemon) reload() error {
+	cfg, err := LoadConfig(d.configPath)
+	if err == nil && cfg.Daemon == nil {
+		err = fmt.Errorf("no daemon section")
+	}
+	if err == nil {
+		err = cfg.Daemon.validate()
+	}
+	if err != nil {
+		alwaysLog.Printf("WARNING: keeping the current jobs, reloading %s failed: %v", d.configPath, err)
+		return err
+	}
+	d.setJobs(cfg.Daemon.Jobs, time.Now())
+	d.mu.Lock()
+	d.token = cfg.Daemon.apiToken()
+	d.mu.Unlock()
+	if cfg.Daemon.Listen != d.listen {
+		alwaysLog.Printf("WARNING: the API keeps listening on %q until the daemon restarts", d.listen)
+	}
+	alwaysLog.Printf("Reloaded %d jobs from %s", len(cfg.Daemon.Jobs), d.configPath)
+	return nil
+}
+
+// startDue starts every due job that isn't already running
+func (d *Daemon) startDue(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, job := range d.jobs {
+		if job.running || now.Before(job.next) {
+			continue
+		}
+		job.next = nextRun(job.ScheduledJob, now)
+		d.start(job, now)
+	}
+}
+
+// start runs a job in the background; the caller holds d.mu
+func (d *Daemon) start(job *scheduledJob, now time.Time) {
+	job.running = true
+	job.lastStart = now
+	d.wg.Add(1)
+	go d.run(job)
+}
+
+// run runs one job as a child process whose output goes to the daemon's, so it ends
+// up in the journal under systemd
+func (d *Daemon) run(job *scheduledJob) {
+	defer d.wg.Done()
+	alwaysLog.Printf("Starting job %s: %s", job.Name, strings.Join(job.Args, " "))
+	start := time.Now()
+	cmd := exec.Command(d.exe, job.Args...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if d.output != nil {
+		cmd.Stdout, cmd.Stderr = d.output, d.output
+	}
+	err := cmd.Run()
+
+	// A reload may have replaced the job while it ran
+	finished := time.Now()
+	var lastError string
+	if err != nil {
+		lastError = err.Error()
+	}
+	d.mu.Lock()
+	job.running = false
+	next := job.next
+	for _, current := range d.jobs {
+		if current.Name == job.Name {
+			current.running = false
+			current.lastFinish, current.lastError = finished, lastError
+			next = current.next
+		}
+	}
+	d.mu.Unlock()
+	elapsed := time.Since(start).Round(time.Second)
+	if err != nil {
+		alwaysLog.Printf("WARNING: job %s failed after %s: %v; next run %s", job.Name, elapsed, err, next.Format("2006-01-02 15:04:05 MST"))
+		return
+	}
+	alwaysLog.Printf("Job %s succeeded in %s; next run %s", job.Name, elapsed, next.Format("2006-01-02 15:04:05 MST"))
+}
+
+// Stop asks Run to stop once running jobs have finished, as SIGTERM does
+func (d *Daemon) Stop() {
+	d.stopOnce.Do(func() { close(d.stop) })
+}
+
+// Run schedules jobs and serves the REST API until SIGTERM, SIGINT, or Stop, then waits
+// for running jobs to finish
+func (d *Daemon) Run() error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(signals)
+	api, err := d.serveAPI()
+	if err != nil {
+		return err
+	}
+	ticker := time.NewTicker(daemonTick)
+	defer ticker.Stop()
+
+	sdNotify("READY=1")
+	alwaysLog.Printf("Daemon started; SIGHUP reloads jobs from %s", d.configPath)
+	d.startDue(time.Now())
+	for {
+		select {
+		case now := <-ticker.C:
+			d.startDue(now)
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				sdNotify("RELOADING=1")
+				d.reload()
+				sdNotify("READY=1")
+				continue
+			}
+			alwaysLog.Printf("Received %s; waiting for running jobs to finish", sig)
+			d.shutDown(api)
+			return nil
+		case <-d.stop:
+			alwaysLog.Printf("Stopping; waiting for running jobs to finish")
+			d.shutDown(api)
+			return nil
+		}
+	}
+}
+
+// shutDown refuses new runs and waits for the running ones to finish
+func (d *Daemon) shutDown(api *http.Server) {
+	sdNotify("STOPPING=1")
+	d.mu.Lock()
+	d.stopping = true
+	d.mu.Unlock()
+	if api != nil {
+		api.Close()
+	}
+	d.wg.Wait()
+	alwaysLog.Printf("Daemon stopped")
+}
+
+// sdNotify tells systemd about the daemon's state when it runs as a Type=notify service
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		debugf("Failed to notify systemd: %v", err)
+		return
+	}
+	defer conn.Close()
+	conn.Write([]byte(state))
+}
+
+// underJournald reports whether stderr goes to the systemd journal, which timestamps
+// every line itself
+func underJournald() bool {
+	return os.Getenv("JOURNAL_STREAM") != ""
+}
+
+// systemdUnitTemplate is the service unit printed by daemon -unit
+var systemdUnitTemplate = template.Must(template.New("unit").Parse(`[Unit]
+Description=pg_restore_fdw scheduled runs
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=notify
+ExecStart={{.Exe}} -config {{.Config}} daemon
+ExecReload=/bin/kill -HUP $MAINPID
+# Stopping waits for running jobs, which may take as long as a restore
+TimeoutStopSec=infinity
+KillMode=mixed
+Restart=on-failure
+User={{.User}}
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+// systemdUnit renders a service unit that runs the daemon with a configuration file
+func systemdUnit(exe, configPath, user string) (string, error) {
+	config, err := filepath.Abs(configPath)
+	if err != nil {
+		return "", err
+	}
+	var unit strings.Builder
+	err = systemdUnitTemplate.Execute(&unit, struct{ Exe, Config, User string }{exe, config, user})
+	return unit.String(), err
+}
+
+// windowsServiceTemplate is the sc.exe commands printed by daemon -sc. Like the systemd
+// unit, the service restarts after a failure.
+var windowsServiceTemplate = template.Must(template.New("sc").Parse(`sc.exe create {{.Name}} binPath= "\"{{.Exe}}\" -config \"{{.Config}}\" daemon -service -log-file \"{{.LogFile}}\"" start= delayed-auto
+sc.exe description {{.Name}} "pg_restore_fdw scheduled runs"
+sc.exe failure {{.Name}} reset= 86400 actions= restart/60000
+`))
+
+// windowsServiceCommands renders the sc.exe commands that install the daemon as a
+// Windows service with a configuration file, logging to logFile
+func windowsServiceCommands(exe, configPath, logFile, name string) (string, error) {
+	config, err := filepath.Abs(configPath)
+	if err != nil {
+		return "", err
+	}
+	if logFile, err = filepath.Abs(logFile); err != nil {
+		return "", err
+	}
+	var commands strings.Builder
+	err = windowsServiceTemplate.Execute(&commands, struct{ Exe, Config, LogFile, Name string }{exe, config, logFile, name})
+	return commands.String(), err
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNextRun(t *testing.T) {
+	after := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	if got := nextRun(ScheduledJob{Every: "6h"}, after); !got.Equal(after.Add(6 * time.Hour)) {
+		t.Errorf("every 6h: %s", got)
+	}
+	daily := ScheduledJob{At: []string{"23:15", "02:30"}}
+	if got := nextRun(daily, after); !got.Equal(time.Date(2026, 1, 1, 23, 15, 0, 0, time.UTC)) {
+		t.Errorf("later today: %s", got)
+	}
+	if got := nextRun(daily, time.Date(2026, 1, 1, 23, 15, 0, 0, time.UTC)); !got.Equal(time.Date(2026, 1, 2, 2, 30, 0, 0, time.UTC)) {
+		t.Errorf("tomorrow: %s", got)
+	}
+}
+
+func TestDaemonConfigValidate(t *testing.T) {
+	valid := DaemonConfig{Jobs: []ScheduledJob{{Name: "nightly", Args: []string{"restore"}, At: []string{"02:30"}}}}
+	if err := valid.validate(); err != nil {
+		t.Fatalf("valid config: %v", err)
+	}
+	for _, job := range []ScheduledJob{
+		{Name: "both", Args: []string{"restore"}, Every: "1h", At: []string{"02:30"}},
+		{Name: "neither", Args: []string{"restore"}},
+		{Name: "fast", Args: []string{"restore"}, Every: "10s"},
+		{Name: "bad time", Args: []string{"restore"}, At: []string{"2:30pm"}},
+		{Name: "no args", Every: "1h"},
+	} {
+		if err := (&DaemonConfig{Jobs: []ScheduledJob{job}}).validate(); err == nil {
+			t.Errorf("job %q passed validation", job.Name)
+		}
+	}
+}
+
+func TestDaemonSetJobsKeepsSchedule(t *testing.T) {
+	d := &Daemon{}
+	start := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	d.setJobs([]ScheduledJob{{Name: "a", Every: "1h"}, {Name: "b", Every: "1h"}}, start)
+	d.jobs[0].running = true
+	d.setJobs([]ScheduledJob{{Name: "a", Every: "1h"}, {Name: "b", Every: "2h"}}, start.Add(30*time.Minute))
+	if !d.jobs[0].running || !d.jobs[0].next.Equal(start.Add(time.Hour)) {
+		t.Errorf("unchanged job: %+v", d.jobs[0])
+	}
+	if !d.jobs[1].next.Equal(start.Add(150 * time.Minute)) {
+		t.Errorf("rescheduled job: next %s", d.jobs[1].next)
+	}
+}
+
+func TestSystemdUnit(t *testing.T) {
+	unit, err := systemdUnit("/usr/local/bin/pg_restore_fdw", "/etc/pg_restore_fdw/daemon.json", "postgres")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Type=notify", "ExecStart=/usr/local/bin/pg_restore_fdw -config /etc/pg_restore_fdw/daemon.json daemon", "ExecReload=/bin/kill -HUP $MAINPID", "User=postgres"} {
+		if !strings.Contains(unit, want) {
+			t.Errorf("unit lacks %q", want)
+		}
+	}
+}
+
+func TestDaemonConfigNeedsAPIToken(t *testing.T) {
+	t.Setenv("PG_RESTORE_FDW_API_TOKEN", "")
+	jobs := []ScheduledJob{{Name: "nightly", Args: []string{"restore"}, Every: "6h"}}
+	if err := (&DaemonConfig{Jobs: jobs, Listen: "127.0.0.1:8086"}).validate(); err == nil {
+		t.Error("API without a token passed validation")
+	}
+	t.Setenv("PG_RESTORE_FDW_API_TOKEN", "secret")
+	if err := (&DaemonConfig{Jobs: jobs, Listen: "127.0.0.1:8086"}).validate(); err != nil {
+		t.Errorf("token from the environment: %v", err)
+	}
+}
+
+func TestWindowsServiceCommands(t *testing.T) {
+	commands, err := windowsServiceCommands(`/opt/pg_restore_fdw/pg_restore_fdw.exe`, "/opt/pg_restore_fdw/daemon.json", "/opt/pg_restore_fdw/daemon.log", "pg_restore_fdw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`sc.exe create pg_restore_fdw binPath= "\"/opt/pg_restore_fdw/pg_restore_fdw.exe\" -config \"/opt/pg_restore_fdw/daemon.json\" daemon -service -log-file \"/opt/pg_restore_fdw/daemon.log\"" start= delayed-auto`,
+		"sc.exe failure pg_restore_fdw reset= 86400 actions= restart/60000",
+	} {
+		if !strings.Contains(commands, want) {
+			t.Errorf("commands lack %q:\n%s", want, commands)
+		}
+	}
+}
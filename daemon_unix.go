@@ -0,0 +1,13 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// runService fails outside Windows, whose service control manager -service is for
+func runService(d *Daemon, serviceName string) error {
+	return fmt.Errorf("-service runs the daemon as a Windows service, which %s doesn't have; use -unit for systemd", runtime.GOOS)
+}
@@ -0,0 +1,166 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var (
+	advapi32                          = syscall.NewLazyDLL("advapi32.dll")
+	procStartServiceCtrlDispatcherW   = advapi32.NewProc("StartServiceCtrlDispatcherW")
+	procRegisterServiceCtrlHandlerExW = advapi32.NewProc("RegisterServiceCtrlHandlerExW")
+	procSetServiceStatus              = advapi32.NewProc("SetServiceStatus")
+)
+
+// Service states, accepted controls, and control codes from winsvc.h
+const (
+	serviceWin32OwnProcess = 0x10
+
+	serviceStopped      = 1
+	serviceStartPending = 2
+	serviceStopPending  = 3
+	serviceRunning      = 4
+
+	serviceAcceptStop        = 0x1
+	serviceAcceptShutdown    = 0x4
+	serviceAcceptParamChange = 0x8
+
+	serviceControlStop        = 1
+	serviceControlInterrogate = 4
+	serviceControlShutdown    = 5
+	serviceControlParamChange = 6
+
+	errorCallNotImplemented   = 120
+	errorServiceSpecificError = 1066
+)
+
+// serviceStopWaitHint is how long the service control manager is told each stop
+// checkpoint may take; stopping waits for running jobs, so checkpoints keep coming
+const serviceStopWaitHint = time.Minute
+
+// serviceStatus is SERVICE_STATUS
+type serviceStatus struct {
+	ServiceType             uint32
+	CurrentState            uint32
+	ControlsAccepted        uint32
+	Win32ExitCode           uint32
+	ServiceSpecificExitCode uint32
+	CheckPoint              uint32
+	WaitHint                uint32
+}
+
+// serviceTableEntry is SERVICE_TABLE_ENTRYW
+type serviceTableEntry struct {
+	name *uint16
+	proc uintptr
+}
+
+// windowsService connects a daemon to the service control manager
+type windowsService struct {
+	daemon *Daemon
+	name   *uint16
+
+	mu     sync.Mutex
+	handle uintptr
+	status serviceStatus
+	err    error
+}
+
+// runService runs the daemon as a Windows service until the service control manager
+// stops it. Stop and shutdown stop it once running jobs finish, and paramchange
+// reloads the configuration as SIGHUP does.
+func runService(d *Daemon, serviceName string) error {
+	name, err := syscall.UTF16PtrFromString(serviceName)
+	if err != nil {
+		return err
+	}
+	s := &windowsService{daemon: d, name: name}
+	table := []serviceTableEntry{{name: name, proc: syscall.NewCallback(s.main)}, {}}
+	if r, _, err := procStartServiceCtrlDispatcherW.Call(uintptr(unsafe.Pointer(&table[0]))); r == 0 {
+		return fmt.Errorf("failed to connect to the service control manager: %w", err)
+	}
+	return s.err
+}
+
+// main is the service's ServiceMain, which the dispatcher calls on its own thread
+func (s *windowsService) main(argc, argv uintptr) uintptr {
+	handle, _, err := procRegisterServiceCtrlHandlerExW.Call(uintptr(unsafe.Pointer(s.name)), syscall.NewCallback(s.control), 0)
+	if handle == 0 {
+		s.err = fmt.Errorf("failed to register the service control handler: %w", err)
+		return 0
+	}
+	s.mu.Lock()
+	s.handle = handle
+	s.mu.Unlock()
+	s.setState(serviceStartPending, 0)
+	s.setState(serviceRunning, serviceAcceptStop|serviceAcceptShutdown|serviceAcceptParamChange)
+
+	s.err = s.daemon.Run()
+	s.mu.Lock()
+	if s.err != nil {
+		s.status.Win32ExitCode, s.status.ServiceSpecificExitCode = errorServiceSpecificError, 1
+	}
+	s.mu.Unlock()
+	s.setState(serviceStopped, 0)
+	return 0
+}
+
+// control is the service's HandlerEx
+func (s *windowsService) control(ctrl, eventType, eventData, context uintptr) uintptr {
+	switch ctrl {
+	case serviceControlStop, serviceControlShutdown:
+		s.setState(serviceStopPending, 0)
+		go s.reportStopping()
+		s.daemon.Stop()
+	case serviceControlParamChange:
+		go s.daemon.reload()
+	case serviceControlInterrogate:
+		s.mu.Lock()
+		state, accepted := s.status.CurrentState, s.status.ControlsAccepted
+		s.mu.Unlock()
+		s.setState(state, accepted)
+	default:
+		return errorCallNotImplemented
+	}
+	return 0
+}
+
+// reportStopping moves the stop checkpoint on while running jobs finish, so the service
+// control manager doesn't take the wait for a hang
+func (s *windowsService) reportStopping() {
+	ticker := time.NewTicker(serviceStopWaitHint / 2)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.mu.Lock()
+		if s.status.CurrentState != serviceStopPending {
+			s.mu.Unlock()
+			return
+		}
+		s.status.CheckPoint++
+		handle, status := s.handle, s.status
+		s.mu.Unlock()
+		procSetServiceStatus.Call(handle, uintptr(unsafe.Pointer(&status)))
+	}
+}
+
+// setState reports a state and the controls it accepts to the service control manager
+func (s *windowsService) setState(state, accepted uint32) {
+	s.mu.Lock()
+	s.status.ServiceType = serviceWin32OwnProcess
+	if state != s.status.CurrentState {
+		s.status.CheckPoint = 0
+	}
+	s.status.CurrentState, s.status.ControlsAccepted = state, accepted
+	s.status.WaitHint = 0
+	if state == serviceStopPending || state == serviceStartPending {
+		s.status.WaitHint = uint32(serviceStopWaitHint / time.Millisecond)
+	}
+	handle, status := s.handle, s.status
+	s.mu.Unlock()
+	procSetServiceStatus.Call(handle, uintptr(unsafe.Pointer(&status)))
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	errUnknownJob = errors.New("unknown job")
+	errJobRunning = errors.New("job is already running")
+	errStopping   = errors.New("the daemon is stopping")
+)
+
+// JobStatus is a scheduled job as the REST API reports it
+type JobStatus struct {
+	Name       string     `json:"name"`
+	Args       []string   `json:"args"`
+	Every      string     `json:"every,omitempty"`
+	At         []string   `json:"at,omitempty"`
+	Next       time.Time  `json:"next"`
+	Running    bool       `json:"running"`
+	LastStart  *time.Time `json:"last_start,omitempty"`
+	LastFinish *time.Time `json:"last_finish,omitempty"`
+	LastError  string     `json:"last_error,omitempty"`
+}
+
+func (j *scheduledJob) status() JobStatus {
+	status := JobStatus{
+		Name:      j.Name,
+		Args:      j.Args,
+		Every:     j.Every,
+		At:        j.At,
+		Next:      j.next,
+		Running:   j.running,
+		LastError: j.lastError,
+	}
+	if !j.lastStart.IsZero() {
+		start := j.lastStart
+		status.LastStart = &start
+	}
+	if !j.lastFinish.IsZero() {
+		finish := j.lastFinish
+		status.LastFinish = &finish
+	}
+	return status
+}
+
+// jobStatuses returns every job's status in configuration order
+func (d *Daemon) jobStatuses() []JobStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	statuses := make([]JobStatus, 0, len(d.jobs))
+	for _, job := range d.jobs {
+		statuses = append(statuses, job.status())
+	}
+	return statuses
+}
+
+// startNow starts a job outside its schedule, leaving its next scheduled start alone
+func (d *Daemon) startNow(name string) (JobStatus, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stopping {
+		return JobStatus{}, errStopping
+	}
+	for _, job := range d.jobs {
+		if job.Name != name {
+			continue
+		}
+		if job.running {
+			return job.status(), errJobRunning
+		}
+		alwaysLog.Printf("Job %s started through the API", name)
+		d.start(job, time.Now())
+		return job.status(), nil
+	}
+	return JobStatus{}, errUnknownJob
+}
+
+// apiHandler serves the REST API:
+//
+//	GET  /jobs             every job with its schedule and last run
+//	POST /jobs/{name}/run  starts a job now, unless it is already running
+//	POST /reload           rereads the configuration, as SIGHUP does
+func (d *Daemon) apiHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /jobs", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, d.jobStatuses())
+	})
+	mux.HandleFunc("POST /jobs/{name}/run", func(w http.ResponseWriter, r *http.Request) {
+		status, err := d.startNow(r.PathValue("name"))
+		switch {
+		case errors.Is(err, errUnknownJob):
+			writeAPIError(w, http.StatusNotFound, err)
+		case errors.Is(err, errJobRunning):
+			writeAPIError(w, http.StatusConflict, err)
+		case errors.Is(err, errStopping):
+			writeAPIError(w, http.StatusServiceUnavailable, err)
+		default:
+			writeJSON(w, http.StatusAccepted, status)
+		}
+	})
+	mux.HandleFunc("POST /reload", func(w http.ResponseWriter, r *http.Request) {
+		if err := d.reload(); err != nil {
+			writeAPIError(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, d.jobStatuses())
+	})
+	return d.authorize(mux)
+}
+
+// authorize rejects requests without the configured bearer token
+func (d *Daemon) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d.mu.Lock()
+		token := d.token
+		d.mu.Unlock()
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeAPIError(w, http.StatusUnauthorized, errors.New("missing or wrong bearer token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serveAPI starts the REST API when the daemon has an address for it
+func (d *Daemon) serveAPI() (*http.Server, error) {
+	if d.listen == "" {
+		return nil, nil
+	}
+	listener, err := net.Listen("tcp", d.listen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", d.listen, err)
+	}
+	server := &http.Server{Handler: d.apiHandler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			alwaysLog.Printf("WARNING: the API stopped serving: %v", err)
+		}
+	}()
+	alwaysLog.Printf("Serving the API on %s", listener.Addr())
+	return server, nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func apiRequest(t *testing.T, handler http.Handler, method, path, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestDaemonAPI(t *testing.T) {
+	d := &Daemon{exe: "true", token: "secret"}
+	d.setJobs([]ScheduledJob{{Name: "nightly", Args: []string{"restore"}, Every: "6h"}}, time.Now())
+	handler := d.apiHandler()
+
+	if rec := apiRequest(t, handler, "GET", "/jobs", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("request without a token: %d", rec.Code)
+	}
+	if rec := apiRequest(t, handler, "GET", "/jobs", "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("request with a wrong token: %d", rec.Code)
+	}
+
+	rec := apiRequest(t, handler, "GET", "/jobs", "secret")
+	var jobs []JobStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &jobs); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("GET /jobs: %d %s", rec.Code, rec.Body)
+	}
+	if len(jobs) != 1 || jobs[0].Name != "nightly" || jobs[0].Running || jobs[0].LastStart != nil {
+		t.Errorf("unexpected jobs %+v", jobs)
+	}
+
+	if rec := apiRequest(t, handler, "POST", "/jobs/weekly/run", "secret"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown job: %d", rec.Code)
+	}
+	next := d.jobs[0].next
+	if rec := apiRequest(t, handler, "POST", "/jobs/nightly/run", "secret"); rec.Code != http.StatusAccepted {
+		t.Fatalf("starting a job: %d %s", rec.Code, rec.Body)
+	}
+	d.mu.Lock()
+	running := d.jobs[0].running
+	d.mu.Unlock()
+	if running {
+		if rec := apiRequest(t, handler, "POST", "/jobs/nightly/run", "secret"); rec.Code != http.StatusConflict {
+			t.Errorf("starting a running job: %d", rec.Code)
+		}
+	}
+	d.wg.Wait()
+	job := d.jobStatuses()[0]
+	if job.Running || job.LastStart == nil || job.LastFinish == nil || job.LastError != "" {
+		t.Errorf("finished job: %+v", job)
+	}
+	if !job.Next.Equal(next) {
+		t.Errorf("starting a job through the API moved its schedule from %s to %s", next, job.Next)
+	}
+
+	d.stopping = true
+	if rec := apiRequest(t, handler, "POST", "/jobs/nightly/run", "secret"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("starting a job while stopping: %d", rec.Code)
+	}
+}
+
+func TestDaemonAPIReload(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "daemon.json")
+	write := func(content string) {
+		if err := os.WriteFile(configPath, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d := &Daemon{configPath: configPath, token: "old"}
+	handler := d.apiHandler()
+
+	write(`{"daemon": {"jobs": [{"name": "nightly", "args": ["restore"], "at": ["02:30"]}], "token": "new"}}`)
+	if rec := apiRequest(t, handler, "POST", "/reload", "old"); rec.Code != http.StatusOK {
+		t.Fatalf("reload: %d %s", rec.Code, rec.Body)
+	}
+	if len(d.jobs) != 1 || d.token != "new" {
+		t.Errorf("reload kept jobs %+v and token %q", d.jobs, d.token)
+	}
+
+	write(`{"daemon": {"jobs": [{"name": "nightly", "args": ["restore"]}], "token": "new"}}`)
+	if rec := apiRequest(t, handler, "POST", "/reload", "new"); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("invalid reload: %d", rec.Code)
+	}
+	if len(d.jobs) != 1 || d.jobs[0].At[0] != "02:30" {
+		t.Errorf("invalid reload replaced the jobs: %+v", d.jobs)
+	}
+}
@@ -1,21 +1,61 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 )
 
 type DBConfig struct {
-	Host     string
-	Port     string
-	User     string
-	Password string
-	DBName   string
+	Host     string `json:"host"`
+	Port     string `json:"port"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	DBName   string `json:"dbname"`
+	// Auth selects a passwordless authentication mode; nil uses Password
+	Auth *AuthConfig `json:"auth,omitempty"`
+	// DirectHost and DirectPort bypass a connection pooler in front of Host and Port
+	// for the connections this tool makes
+	DirectHost string `json:"direct_host,omitempty"`
+	DirectPort string `json:"direct_port,omitempty"`
+	// Options are passed to the server as PGOPTIONS, e.g. "-c statement_timeout=5min"
+	Options string `json:"options,omitempty"`
+	// Environment tags the database, e.g. prod, for protections and the run report
+	Environment string `json:"environment,omitempty"`
+	// appName is the application_name client commands connect with, when set
+	appName string
+}
+
+// newPsqlCmd builds a psql command connected to the config's database
+func newPsqlCmd(config DBConfig, args ...string) *exec.Cmd {
+	return pgCommand("psql", config).Arg(args...).Cmd()
+}
+
+// newPgRestoreCmd builds a pg_restore command that restores into the config's database
+func newPgRestoreCmd(config DBConfig, args ...string) *exec.Cmd {
+	return pgCommand("pg_restore", config).NoOwner().Arg(args...).Cmd()
+}
+
+// newPgRestoreListCmd builds a pg_restore command that prints the archive's table of contents
+func newPgRestoreListCmd(inputFile string) *exec.Cmd {
+	cmd := exec.Command("pg_restore", "-l", inputFile)
+	cmd.Env = subprocessEnv()
+	return cmd
+}
+
+// newPgRestoreScriptCmd builds a pg_restore command that writes the SQL script for the
+// entries in listFile to stdout instead of restoring into a database
+func newPgRestoreScriptCmd(listFile, inputFile string) *exec.Cmd {
+	cmd := exec.Command("pg_restore", "-L", listFile, "-f", "-", inputFile)
+	cmd.Env = subprocessEnv()
+	return cmd
 }
 
 // ProgressMonitor tracks progress of database operations
@@ -35,15 +75,26 @@ func NewProgressMonitor(operation string) *ProgressMonitor {
 	}
 }
 
+// Update reports the operation's status to the progress sinks, at most once per UpdateEvery
 func (pm *ProgressMonitor) Update(status string) {
+	pm.UpdateBytes(status, 0, 0)
+}
+
+// UpdateBytes is Update for operations that know how many bytes they have processed
+func (pm *ProgressMonitor) UpdateBytes(status string, done, total int64) {
 	now := time.Now()
 	if now.Sub(pm.LastUpdate) >= pm.UpdateEvery {
-		elapsed := now.Sub(pm.StartTime).Round(time.Second)
-		log.Printf("[%s] %s (elapsed: %v)", pm.Operation, status, elapsed)
 		pm.LastUpdate = now
+		emitProgress(ProgressEvent{Operation: pm.Operation, Status: status, StartedAt: pm.StartTime, UpdatedAt: now, BytesDone: done, BytesTotal: total})
 	}
 }
 
+// Finish reports the operation's final status regardless of when it last updated
+func (pm *ProgressMonitor) Finish(status string, done, total int64) {
+	pm.LastUpdate = time.Now()
+	emitProgress(ProgressEvent{Operation: pm.Operation, Status: status, StartedAt: pm.StartTime, UpdatedAt: pm.LastUpdate, BytesDone: done, BytesTotal: total, Finished: true})
+}
+
 // RetryWithBackoff retries a function with exponential backoff
 func RetryWithBackoff(operation string, maxAttempts int, fn func() error) error {
 	var lastErr error
@@ -66,19 +117,23 @@ func RetryWithBackoff(operation string, maxAttempts int, fn func() error) error
 
 // CreateDatabase creates a new PostgreSQL database
 func CreateDatabase(config DBConfig) error {
+	return createDatabaseWith(config, "")
+}
+
+// createDatabaseWith creates a database with extra CREATE DATABASE options, such as
+// the locale createOptions returns
+func createDatabaseWith(config DBConfig, options string) error {
+	if err := activeProtections.checkCreate(config); err != nil {
+		return err
+	}
+
 	log.Printf("Creating database: %s", config.DBName)
 
-	cmd := exec.Command(
-		"psql",
-		"-h", config.Host,
-		"-p", config.Port,
-		"-U", config.User,
-		"-c", fmt.Sprintf("CREATE DATABASE %s;", config.DBName),
-		"postgres", // Connect to default postgres database
-	)
-	cmd.Env = append(os.Environ(), "PGPASSWORD="+config.Password)
+	// Connect to the default postgres database
+	cmd := pgCommand("psql", config).Database("postgres").
+		Arg("-c", fmt.Sprintf("CREATE DATABASE %s%s;", quoteIdent(config.DBName), options)).Cmd()
 
-	output, err := cmd.CombinedOutput()
+	output, err := runStreaming(cmd, "create_"+config.DBName, nil)
 	if err != nil {
 		log.Printf("Error creating database: %s", output)
 		return fmt.Errorf("failed to create database: %w", err)
@@ -88,37 +143,269 @@ func CreateDatabase(config DBConfig) error {
 	return nil
 }
 
+// DumpOptions controls optional dump behavior
+type DumpOptions struct {
+	// Encryption encrypts each artifact as it is written; nil writes plaintext
+	Encryption *EncryptionConfig
+	// GPG encrypts and signs each artifact for GPG recipients instead of Encryption
+	GPG *GPGConfig
+	// SynchronizedSnapshots exports a snapshot per database before any dump starts and
+	// dumps every section from it, so all sections and both databases are near-consistent
+	SynchronizedSnapshots bool
+	// MaxSnapshotSkew warns when the databases were captured further apart than this
+	MaxSnapshotSkew time.Duration
+	// CDC creates a logical replication slot on each database and dumps from the
+	// snapshot it exports, so CDCCatchUp can replay changes made after the dump
+	CDC bool
+	// Databases selects which of "moodys" and "tenant" to dump; empty dumps both
+	Databases []string
+	// Replicas dumps "moodys" or "tenant" from a read replica when it is caught up
+	Replicas map[string]*ReplicaConfig
+	// Layout dumps data as plain SQL, optionally split into size-bounded parts
+	Layout ArtifactLayout
+	// Partitions dumps the data of each leaf partition into its own archive, in
+	// parallel from a shared snapshot, so large partitioned tables restore in parallel
+	Partitions bool
+	// Delta dumps the selected tables into archives of their own and keeps those of the
+	// dump set already in the output directory where the tables are unchanged
+	Delta *DeltaConfig
+	// Labels and Hold are recorded in the manifest
+	Labels []string
+	Hold   bool
+}
+
 // DumpWorkflow performs a complete dump of both moodys and tenant databases
 func DumpWorkflow(moodysConfig, tenantConfig DBConfig, outputDir string) error {
+	return DumpWorkflowWithOptions(moodysConfig, tenantConfig, outputDir, DumpOptions{})
+}
+
+// DumpWorkflowWithOptions performs a complete dump of both databases and writes the manifest
+func DumpWorkflowWithOptions(moodysConfig, tenantConfig DBConfig, outputDir string, opts DumpOptions) error {
+	manifest := &Manifest{CreatedAt: time.Now(), Labels: opts.Labels, Hold: opts.Hold}
+
+	// CDC catch-up streams from the configured source, so it always dumps from the primary
+	if opts.CDC && len(opts.Replicas) > 0 {
+		log.Printf("Ignoring replicas: -cdc dumps from the primary")
+		opts.Replicas = nil
+	}
+	for _, db := range []struct {
+		name   string
+		config *DBConfig
+	}{{"moodys", &moodysConfig}, {"tenant", &tenantConfig}} {
+		if !includesDatabase(opts.Databases, db.name) {
+			continue
+		}
+		config, source, err := chooseDumpSource(db.name, *db.config, opts.Replicas[db.name])
+		if err != nil {
+			return err
+		}
+		*db.config = config
+		manifest.Sources = append(manifest.Sources, source)
+	}
+
+	moodysConfig = activeThrottle.source(moodysConfig)
+	tenantConfig = activeThrottle.source(tenantConfig)
+
 	// Ensure output directory exists
 	if err := os.MkdirAll(outputDir, 0755); err != nil {
 		return fmt.Errorf("failed to create output directory: %w", err)
 	}
 
+	codec, err := newArtifactCodec(opts.Encryption, opts.GPG)
+	if err != nil {
+		return err
+	}
+	if opts.Partitions && (codec != nil || opts.Layout.SplitBytes > 0) {
+		return fmt.Errorf("partition dumps are not supported with encryption or split data")
+	}
+	if opts.Delta != nil && (codec != nil || opts.Layout.SplitBytes > 0) {
+		return fmt.Errorf("delta dumps are not supported with encryption or split data")
+	}
+	// Read the previous dump set's delta archives before the new manifest replaces it
+	previousDelta := make(map[string]map[string]ManifestArtifact)
+	if opts.Delta != nil {
+		for _, name := range []string{"moodys", "tenant"} {
+			previousDelta[name] = previousDeltaArtifacts(outputDir, name)
+		}
+	}
+	if err := setStepLogDir(filepath.Join(outputDir, "logs")); err != nil {
+		return err
+	}
+
 	// Dump databases in sections with appropriate formats
-	databases := []struct {
+	type database struct {
 		config     DBConfig
 		namePrefix string
-	}{
-		{moodysConfig, "moodys"},
-		{tenantConfig, "tenant"},
+	}
+	var databases []database
+	for _, db := range []database{{moodysConfig, "moodys"}, {tenantConfig, "tenant"}} {
+		if includesDatabase(opts.Databases, db.namePrefix) {
+			databases = append(databases, db)
+		}
+	}
+
+	// Export every snapshot before the first dump so the databases are captured together
+	snapshotIDs := make(map[string]string)
+	if opts.CDC {
+		opts.SynchronizedSnapshots = true
+		for _, db := range databases {
+			session, slot, err := openCDCSession(db.config, db.namePrefix)
+			if err != nil {
+				return err
+			}
+			defer session.Close()
+			snapshotIDs[db.namePrefix] = session.Info.SnapshotID
+			manifest.Snapshots = append(manifest.Snapshots, session.Info)
+			manifest.CDCSlots = append(manifest.CDCSlots, slot)
+		}
+	} else if opts.SynchronizedSnapshots || opts.Partitions || opts.Delta != nil {
+		// Partitions and delta tables are dumped by separate pg_dump runs, which must
+		// share a snapshot
+		opts.SynchronizedSnapshots = true
+		for _, db := range databases {
+			session, err := openSnapshotSession(db.config)
+			if err != nil {
+				return fmt.Errorf("failed to export snapshot of %s: %w", db.namePrefix, err)
+			}
+			defer session.Close()
+			snapshotIDs[db.namePrefix] = session.Info.SnapshotID
+			manifest.Snapshots = append(manifest.Snapshots, session.Info)
+		}
 	}
 
 	sections := []string{"pre-data", "data", "post-data"}
-	for _, db := range databases {
+	dumpDatabase := func(config DBConfig, namePrefix string) error {
+		if !opts.SynchronizedSnapshots {
+			position, err := currentPosition(config)
+			if err != nil {
+				return err
+			}
+			manifest.Snapshots = append(manifest.Snapshots, position)
+		}
+		catalog, err := TakeCatalogSnapshot(namePrefix, config)
+		if err != nil {
+			return fmt.Errorf("failed to record the catalog of %s: %w", namePrefix, err)
+		}
+		manifest.Catalogs = append(manifest.Catalogs, catalog)
+
+		layout := opts.Layout
+		var partitions []Partition
+		if opts.Partitions {
+			if partitions, err = listPartitions(config); err != nil {
+				return err
+			}
+			for _, p := range partitions {
+				layout.ExcludeData = append(layout.ExcludeData, p.Table)
+			}
+		}
+		var deltaTables []string
+		if opts.Delta != nil {
+			if deltaTables, err = opts.Delta.listDeltaTables(config); err != nil {
+				return err
+			}
+			layout.ExcludeData = append(layout.ExcludeData, deltaTables...)
+		}
+
 		for _, section := range sections {
-			outFile := filepath.Join(outputDir, fmt.Sprintf("%s_%s", db.namePrefix, section))
-			if err := dumpDatabaseSection(db.config, outFile, section); err != nil {
-				return fmt.Errorf("failed to dump %s %s: %w", db.namePrefix, section, err)
+			sectionSpan := startSpan(fmt.Sprintf("dump %s %s", namePrefix, section), "db.name", config.DBName)
+			outFile := filepath.Join(outputDir, fmt.Sprintf("%s_%s", namePrefix, section))
+			written, err := dumpDatabaseSection(config, outFile, section, codec, snapshotIDs[namePrefix], layout)
+			sectionSpan.End(err)
+			if err != nil {
+				return fmt.Errorf("failed to dump %s %s: %w", namePrefix, section, err)
+			}
+
+			artifact := ManifestArtifact{
+				Database: namePrefix,
+				DBName:   config.DBName,
+				Section:  section,
+				File:     filepath.Base(written),
+				Format:   opts.Layout.format(section),
+				Size:     artifactSize(written),
+			}
+			if codec != nil {
+				artifact.Encrypted = true
+				artifact.KeyRef = codec.keyRef()
+			}
+			manifest.Artifacts = append(manifest.Artifacts, artifact)
+
+			if section == "data" && len(partitions) > 0 {
+				dumped, err := dumpPartitions(config, outputDir, namePrefix, snapshotIDs[namePrefix], partitions)
+				if err != nil {
+					return err
+				}
+				manifest.Artifacts = append(manifest.Artifacts, dumped...)
+			}
+			if section == "data" && opts.Delta != nil {
+				dumped, err := dumpDeltaTables(config, outputDir, namePrefix, snapshotIDs[namePrefix], deltaTables, previousDelta[namePrefix])
+				if err != nil {
+					return err
+				}
+				manifest.Artifacts = append(manifest.Artifacts, dumped...)
 			}
 		}
+		return nil
+	}
+
+	for _, db := range databases {
+		dbSpan := startSpan("dump "+db.namePrefix, "db.name", db.config.DBName)
+		err := dumpDatabase(db.config, db.namePrefix)
+		dbSpan.End(err)
+		if err != nil {
+			return err
+		}
+	}
+
+	skew := snapshotSkew(manifest.Snapshots)
+	manifest.SnapshotSkew = skew.String()
+	if opts.MaxSnapshotSkew > 0 && skew > opts.MaxSnapshotSkew {
+		log.Printf("WARNING: databases were captured %v apart (limit %v); the dump set may be inconsistent across FDW boundaries",
+			skew.Round(time.Millisecond), opts.MaxSnapshotSkew)
 	}
 
+	if err := WriteManifest(outputDir, manifest); err != nil {
+		return err
+	}
+	if opts.GPG != nil {
+		return opts.GPG.SignFile(filepath.Join(outputDir, manifestFileName))
+	}
 	return nil
 }
 
-// dumpDatabaseSection dumps a specific section of a database
-func dumpDatabaseSection(config DBConfig, outputFile, section string) error {
+// sectionArtifactName returns the artifact name of a database's section in inputDir,
+// which holds data as a custom-format archive unless it was dumped as plain SQL or
+// in tar format
+func sectionArtifactName(inputDir, database, section string) string {
+	plain := fmt.Sprintf("%s_%s.sql", database, section)
+	if section == "pre-data" {
+		return plain
+	}
+	candidates := []string{fmt.Sprintf("%s_%s.tar", database, section)}
+	if section == "data" {
+		candidates = append([]string{plain}, candidates...)
+	}
+	for _, name := range candidates {
+		for _, candidate := range []string{name, name + partsIndexExt, name + encryptedExt, name + gpgExt} {
+			if _, err := os.Stat(filepath.Join(inputDir, candidate)); err == nil {
+				return name
+			}
+		}
+	}
+	return fmt.Sprintf("%s_%s.dump", database, section)
+}
+
+// sectionFormat returns the pg_dump format name used for a section
+func sectionFormat(section string) string {
+	if section == "pre-data" {
+		return FormatPlain
+	}
+	return FormatCustom
+}
+
+// dumpDatabaseSection dumps a specific section of a database and returns the written path.
+// With a codec, pg_dump output is streamed through it so no plaintext reaches disk.
+// A non-empty snapshotID dumps from that exported snapshot.
+func dumpDatabaseSection(config DBConfig, outputFile, section string, codec artifactCodec, snapshotID string, layout ArtifactLayout) (string, error) {
 	log.Printf("Dumping %s section of database %s to %s", section, config.DBName, outputFile)
 
 	// Configure format based on section
@@ -126,89 +413,134 @@ func dumpDatabaseSection(config DBConfig, outputFile, section string) error {
 	// Data and post-data can use custom format for parallel restore
 	var format string
 	fileExt := ".sql" // Default for text format
-	if section == "pre-data" {
+	switch layout.format(section) {
+	case FormatPlain:
 		format = "p" // plain text format
-	} else {
+	case FormatTar:
+		format = "t" // tar format for archival tools
+		fileExt = ".tar"
+	default:
 		format = "c" // custom format for parallel restore
 		fileExt = ".dump"
 	}
 
 	outputFile = outputFile + fileExt
+	split := format == "p" && section == "data" && layout.SplitBytes > 0
+	if split && codec != nil {
+		return "", fmt.Errorf("splitting data dumps is not supported with encryption")
+	}
 
-	cmd := exec.Command(
-		"pg_dump",
-		"-h", config.Host,
-		"-p", config.Port,
-		"-U", config.User,
-		"--no-owner",
-		"--no-privileges",
+	dump := pgCommand("pg_dump", config).NoOwner().Arg(
 		fmt.Sprintf("-F%s", format), // Format type
 		fmt.Sprintf("--section=%s", section),
-		"-f", outputFile,
-		config.DBName,
 	)
-	cmd.Env = append(os.Environ(), "PGPASSWORD="+config.Password)
+	if snapshotID != "" {
+		dump.Arg("--snapshot=" + snapshotID)
+	}
+	if section == "data" {
+		for _, table := range layout.ExcludeData {
+			dump.Arg("--exclude-table-data=" + quoteQualifiedName(table))
+		}
+	}
+	dump.Arg(activeThrottle.dumpArgs()...)
+	if codec == nil && !activeThrottle.limitsBandwidth() && !split {
+		dump.Arg("-f", outputFile)
+	}
+	cmd := dump.Cmd()
+
+	release := activeThrottle.acquire()
+	defer release()
+
+	if codec != nil {
+		outputFile += codec.ext()
+		if err := codec.encode(cmd, outputFile, stepName("dump", outputFile)); err != nil {
+			return "", err
+		}
+		log.Printf("Successfully dumped %s section of %s to %s (encrypted)", section, config.DBName, outputFile)
+		return outputFile, nil
+	}
+
+	if split {
+		parts := newPartWriter(outputFile, layout.SplitBytes)
+		cmd.Stdout = activeThrottle.limit(parts)
+		monitor := NewProgressMonitor(fmt.Sprintf("Dump %s", filepath.Base(outputFile)))
+		output, err := runStreaming(cmd, stepName("dump", outputFile), monitor)
+		indexPath, closeErr := parts.Close()
+		if err != nil {
+			log.Printf("Error dumping database section: %s", output)
+			return "", fmt.Errorf("failed to dump database section: %w", err)
+		}
+		if closeErr != nil {
+			return "", closeErr
+		}
+		log.Printf("Successfully dumped %s section of %s to %d parts indexed in %s", section, config.DBName, len(parts.index.Parts), indexPath)
+		return indexPath, nil
+	}
+
+	// A bandwidth limit needs pg_dump's output to pass through this process
+	if activeThrottle.limitsBandwidth() {
+		out, err := os.Create(outputFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to create dump file: %w", err)
+		}
+		defer out.Close()
+		cmd.Stdout = activeThrottle.limit(out)
+	}
 
-	output, err := cmd.CombinedOutput()
+	monitor := NewProgressMonitor(fmt.Sprintf("Dump %s", filepath.Base(outputFile)))
+	output, err := runStreaming(cmd, stepName("dump", outputFile), monitor)
 	if err != nil {
 		log.Printf("Error dumping database section: %s", output)
-		return fmt.Errorf("failed to dump database section: %w", err)
+		return "", fmt.Errorf("failed to dump database section: %w", err)
 	}
 
 	log.Printf("Successfully dumped %s section of %s to %s", section, config.DBName, outputFile)
-	return nil
+	return outputFile, nil
 }
 
 // modifyPreDataFile modifies the tenant pre-data SQL file to update FDW configuration
 func modifyPreDataFile(inputFile string, srcMoodysConfig, destMoodysConfig DBConfig) error {
+	// Only an uncompressed SQL script can be edited
+	if format, err := DetectArtifactFormat(inputFile); err != nil {
+		return err
+	} else if format.Kind != FormatPlain || format.Compression != "" {
+		return fmt.Errorf("pre-data file %s is %s, not plain SQL", inputFile, format)
+	}
+
 	// Read the current content
 	content, err := os.ReadFile(inputFile)
 	if err != nil {
 		return fmt.Errorf("failed to read pre-data file: %w", err)
 	}
 
-	// Log original content
-	log.Printf("Original pre-data file content:\n%s", string(content))
-
 	// Replace the FDW configuration
 	modified := string(content)
 
-	// Update host, port, and dbname in SERVER options
-	modified = strings.Replace(
-		modified,
-		fmt.Sprintf("dbname '%s'", srcMoodysConfig.DBName),
-		fmt.Sprintf("dbname '%s'", destMoodysConfig.DBName),
-		-1,
-	)
-	modified = strings.Replace(
-		modified,
-		fmt.Sprintf("host '%s'", srcMoodysConfig.Host),
-		fmt.Sprintf("host '%s'", destMoodysConfig.Host),
-		-1,
-	)
-	modified = strings.Replace(
-		modified,
-		fmt.Sprintf("port '%s'", srcMoodysConfig.Port),
-		fmt.Sprintf("port '%s'", destMoodysConfig.Port),
-		-1,
-	)
+	// replaceOption rewrites an option's value where the dump has it as from. pg_dump
+	// leaves backslashes alone, so that quoting is matched, along with the escape-string
+	// form of quoteLiteral.
+	replaceOption := func(key, from, to string) {
+		for _, quoted := range []string{dumpedLiteral(from), quoteLiteral(from)} {
+			modified = strings.Replace(modified, key+quoted, key+quoteLiteral(to), -1)
+		}
+	}
 
-	// Update user mapping options
-	modified = strings.Replace(
-		modified,
-		fmt.Sprintf("user '%s'", srcMoodysConfig.User),
-		fmt.Sprintf("user '%s'", destMoodysConfig.User),
-		-1,
-	)
-	modified = strings.Replace(
-		modified,
-		fmt.Sprintf("password '%s'", srcMoodysConfig.Password),
-		fmt.Sprintf("password '%s'", destMoodysConfig.Password),
-		-1,
-	)
+	// Update host, port, and dbname in SERVER options
+	replaceOption("dbname ", srcMoodysConfig.DBName, destMoodysConfig.DBName)
+	// An unset host or port, as socket connections often leave the port, keeps the
+	// dumped option rather than writing an empty one
+	if srcMoodysConfig.Host != "" && destMoodysConfig.Host != "" {
+		replaceOption("host ", srcMoodysConfig.Host, destMoodysConfig.Host)
+	}
+	if srcMoodysConfig.Port != "" && destMoodysConfig.Port != "" {
+		replaceOption("port ", srcMoodysConfig.Port, destMoodysConfig.Port)
+	}
 
-	// Log modified content
-	log.Printf("Modified pre-data file content:\n%s", modified)
+	// Update user mapping options. pg_dump quotes the reserved word "user" as an identifier.
+	for _, key := range []string{"user ", quoteIdent("user") + " "} {
+		replaceOption(key, srcMoodysConfig.User, destMoodysConfig.User)
+	}
+	replaceOption("password ", srcMoodysConfig.Password, destMoodysConfig.Password)
 
 	// Write the modified content back to the file
 	if err := os.WriteFile(inputFile, []byte(modified), 0644); err != nil {
@@ -218,52 +550,224 @@ func modifyPreDataFile(inputFile string, srcMoodysConfig, destMoodysConfig DBCon
 	return nil
 }
 
+// unsafeFileChars are replaced in destination labels used in file names
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// destinationLabel names a destination in the file names of its rewritten artifacts
+func destinationLabel(config DBConfig) string {
+	return unsafeFileChars.ReplaceAllString(fmt.Sprintf("%s_%s_%s", config.Host, config.Port, config.DBName), "_")
+}
+
+// destinationCopy copies a pre-data artifact to <name>.<destination>.sql next to it for
+// the rewrites made for one destination, so they start from the dump as it was and the
+// artifact stays unchanged for the next restore. An earlier copy is replaced.
+func destinationCopy(inputFile string, dest DBConfig) (string, error) {
+	ext := filepath.Ext(inputFile)
+	copyPath := strings.TrimSuffix(inputFile, ext) + "." + destinationLabel(dest) + ext
+	in, err := os.Open(inputFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", inputFile, err)
+	}
+	defer in.Close()
+	out, err := os.Create(copyPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", copyPath, err)
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, in); err != nil {
+		return "", fmt.Errorf("failed to copy %s: %w", inputFile, err)
+	}
+	debugf("Rewriting %s for %s in %s", filepath.Base(inputFile), dest.DBName, copyPath)
+	return copyPath, nil
+}
+
 // restoreDatabaseSection restores a specific section of a database with parallel processing
-func restoreDatabaseSection(config DBConfig, inputFile string, section string) error {
+func restoreDatabaseSection(config DBConfig, inputFile string, section string, opts RestoreOptions) error {
+	config = opts.Locks.session(config)
 	monitor := NewProgressMonitor(fmt.Sprintf("Restore %s", filepath.Base(inputFile)))
 	monitor.Update("Starting restore...")
 	startTime := time.Now()
 
+	var format ArtifactFormat
+	var err error
+	if opts.stream != nil {
+		format, err = detectStreamFormat(inputFile, opts.stream)
+	} else {
+		format, err = DetectArtifactFormat(inputFile)
+	}
+	if err != nil {
+		return err
+	}
+	if format.archive() && format.Compression != "" {
+		return fmt.Errorf("%s is a compressed %s archive; decompress it before restoring", inputFile, format.Kind)
+	}
+	log.Printf("Restoring %s as %s", filepath.Base(inputFile), format)
+
+	if section == "pre-data" && opts.RetryPreData && !format.archive() && format.Compression == "" && !isPartsIndex(inputFile) {
+		err := restoreScriptInPasses(config, inputFile, opts)
+		if err == nil {
+			log.Printf("Restore completed in %v", time.Since(startTime))
+		}
+		return err
+	}
+
+	// pg_restore can't run parallel workers on tar archives or an archive read from stdin
+	jobs := 1
+	if format.parallel() && opts.stream != nil {
+		log.Printf("Restoring %s serially, since it is decrypted on the way into pg_restore", filepath.Base(inputFile))
+	} else if format.parallel() && opts.renamer == nil {
+		jobs = getNumCPUs()
+		if opts.jobs > 0 {
+			jobs = opts.jobs
+		}
+	}
+	if section == "post-data" {
+		config = opts.IndexMemory.session(config, jobs)
+	}
+
+	step := stepName("restore", inputFile)
+	if opts.splitHome != "" {
+		step += "_" + opts.splitHome
+	}
+	if opts.phase != "" {
+		step += "_" + opts.phase
+	}
+	cancelled := false
 	result := RetryWithBackoff(fmt.Sprintf("restore %s", inputFile), 3, func() error {
 		var cmd *exec.Cmd
+		var producer *exec.Cmd
+		var script io.ReadCloser
+		var timer *tocTimer
+		config := config
+		if cancelled {
+			config = opts.Watchdog.retrySession(config)
+			log.Printf("Retrying %s with the watchdog's retry settings", filepath.Base(inputFile))
+		}
 
-		// Use psql for pre-data (plain text) and pg_restore for data/post-data (custom format)
-		if section == "pre-data" {
-			cmd = exec.Command(
-				"psql",
-				"-h", config.Host,
-				"-p", config.Port,
-				"-U", config.User,
-				"-d", config.DBName,
-				"-f", inputFile,
-			)
+		// An encrypted artifact is decrypted on the way into psql or pg_restore
+		var input io.ReadCloser
+		if opts.stream != nil {
+			var err error
+			if input, err = opts.stream(); err != nil {
+				return err
+			}
+			defer input.Close()
+		}
+
+		// Use psql for SQL scripts and pg_restore for archives, whatever the file is named
+		if !format.archive() {
+			psql := pgCommand("psql", config)
+			if opts.SingleTransaction {
+				psql.Arg("--single-transaction", "-v", "ON_ERROR_STOP=1")
+			} else {
+				psql.Arg(opts.ErrorPolicy.extraArgs("psql")...)
+			}
+			psql.Arg(sessionArgs(opts.session)...)
+			if input != nil {
+				cmd = psql.Arg("-f", "-").Cmd()
+				cmd.Stdin = input
+			} else if isPartsIndex(inputFile) {
+				// Split dumps are reassembled in order on psql's stdin
+				parts, err := openParts(inputFile)
+				if err != nil {
+					return err
+				}
+				defer parts.Close()
+				cmd = psql.Arg("-f", "-").Cmd()
+				cmd.Stdin = parts
+			} else if format.Compression != "" {
+				// Compressed scripts are decompressed on the way into psql
+				var err error
+				if producer, err = decompressCmd(format.Compression, inputFile); err != nil {
+					return err
+				}
+				if script, err = producer.StdoutPipe(); err != nil {
+					return err
+				}
+				defer script.Close()
+				cmd = psql.Arg("-f", "-").Cmd()
+				cmd.Stdin = script
+				if err := producer.Start(); err != nil {
+					return fmt.Errorf("failed to decompress %s: %w", inputFile, err)
+				}
+			} else {
+				cmd = psql.Arg("-f", inputFile).Cmd()
+			}
+		} else if opts.renamer != nil {
+			// Renamed objects go through the archive's SQL script, which rules out parallel workers
+			var err error
+			producer, script, err = renamedScriptCmd(inputFile, opts.listFile, input, opts.renamer)
+			if err != nil {
+				return err
+			}
+			defer script.Close()
+			cmd = newPsqlCmd(config, opts.ErrorPolicy.extraArgs("psql")...)
+			cmd.Stdin = script
+			if err := producer.Start(); err != nil {
+				return fmt.Errorf("failed to render script of %s: %w", inputFile, err)
+			}
 		} else {
-			numCPUs := getNumCPUs()
-			monitor.Update(fmt.Sprintf("Using %d parallel workers", numCPUs))
-			cmd = exec.Command(
-				"pg_restore",
-				"-h", config.Host,
-				"-p", config.Port,
-				"-U", config.User,
-				"-d", config.DBName,
-				"--no-owner",
-				"--no-privileges",
-				"-j", fmt.Sprintf("%d", numCPUs),
-				inputFile,
-			)
-		}
-
-		cmd.Env = append(os.Environ(), "PGPASSWORD="+config.Password)
+			restore := pgCommand("pg_restore", config).NoOwner()
+			if jobs > 1 {
+				monitor.Update(fmt.Sprintf("Using %d parallel workers", jobs))
+				restore.Arg("-j", fmt.Sprintf("%d", jobs))
+			}
+			if profile := opts.Provider.profile(); profile != nil && !profile.Tablespaces {
+				restore.Arg("--no-tablespaces")
+			}
+			restore.Arg(opts.ErrorPolicy.extraArgs("pg_restore")...)
+			if opts.listFile != "" {
+				restore.Arg("-L", opts.listFile)
+			}
+			if opts.TOCTiming {
+				restore.Arg("--verbose")
+				timer = newTOCTimer(step)
+			}
+			if input == nil {
+				restore.Arg(inputFile)
+			}
+			cmd = restore.Cmd()
+			cmd.Stdin = input
+		}
 
 		// Log the command being executed (with password redacted)
 		cmdStr := strings.Join(cmd.Args, " ")
 		log.Printf("Executing: %s", cmdStr)
 
-		if output, err := cmd.CombinedOutput(); err != nil {
+		watcher := opts.Locks.watch(config, step)
+		watchdog := opts.Watchdog.watch(config, step)
+		var observe func(string, time.Time)
+		if timer != nil {
+			observe = timer.observe
+		}
+		output, err := runObserved(opts.span, cmd, step, monitor, observe)
+		reportSlowestObjects(timer, time.Now())
+		watcher.Stop()
+		cancelled = watchdog.Stop() || cancelled
+		if producer != nil {
+			if werr := finishProducer(producer, script, err); werr != nil && err == nil {
+				err = fmt.Errorf("failed to read script of %s: %w", inputFile, werr)
+			}
+		}
+		if err != nil && opts.SingleTransaction {
+			if scriptErr := describeScriptError(string(output)); scriptErr != nil {
+				return fmt.Errorf("failed to restore database section, transaction rolled back at %w", scriptErr)
+			}
+		}
+
+		parsed := opts.ErrorPolicy.parseRestoreOutput(string(output))
+		if len(parsed.Messages) > 0 {
+			log.Printf("Restore of %s reported %s", filepath.Base(inputFile), parsed.Summary())
+		}
+		if err := opts.ErrorPolicy.Evaluate(err, string(output)); err != nil {
+			var restoreErr *RestoreError
+			if errors.As(err, &restoreErr) {
+				return fmt.Errorf("failed to restore database section: %w", err)
+			}
 			return fmt.Errorf("failed to restore database section: %w\nOutput: %s", err, output)
 		}
 
-		monitor.Update("Restore completed successfully")
+		monitor.Finish("Restore completed successfully", 0, 0)
 		return nil
 	})
 
@@ -271,18 +775,12 @@ func restoreDatabaseSection(config DBConfig, inputFile string, section string) e
 
 	// If this is a data section, get the record count
 	if section == "data" {
-		countCmd := exec.Command(
-			"psql",
-			"-h", config.Host,
-			"-p", config.Port,
-			"-U", config.User,
-			"-d", config.DBName,
+		countCmd := newPsqlCmd(config,
 			"-t", // tuple only
 			"-c", "SELECT COUNT(*) FROM customer_transactions;",
 		)
-		countCmd.Env = append(os.Environ(), "PGPASSWORD="+config.Password)
 
-		if output, err := countCmd.CombinedOutput(); err == nil {
+		if output, err := runStreaming(countCmd, "count_"+config.DBName, nil); err == nil {
 			count := strings.TrimSpace(string(output))
 			log.Printf("Restore completed in %v. Records restored: %s", duration, count)
 		} else {
@@ -295,60 +793,755 @@ func restoreDatabaseSection(config DBConfig, inputFile string, section string) e
 	return result
 }
 
+// finishProducer waits for the command feeding a consumer's stdin once the consumer has
+// exited. Nothing reads the rest of the script after that, so the read end is closed to
+// make the producer fail on its next write instead of blocking on a full pipe, and a
+// producer whose consumer failed is killed.
+func finishProducer(producer *exec.Cmd, script io.Closer, consumerErr error) error {
+	script.Close()
+	if consumerErr != nil {
+		producer.Process.Kill()
+	}
+	return producer.Wait()
+}
+
+// RestoreOptions controls optional restore behavior
+type RestoreOptions struct {
+	// PerTable restores each table's data separately, quarantining tables that fail
+	PerTable bool
+	// RunID identifies the run in saved run state; generated when empty
+	RunID string
+	// OnFailure is what happens to the databases created by a restore that fails:
+	// keep (the default), drop-created-databases, or rename
+	OnFailure string
+	// Databases selects which of "moodys" and "tenant" to restore; empty restores both
+	Databases []string
+	// ServerName renames the moodys foreign server in the tenant database
+	ServerName string
+	// FDWPlan retargets the discovered foreign servers of both databases instead of
+	// assuming tenant's point at moodys
+	FDWPlan []FDWRemap
+	// FDWTarget points the foreign server at another cluster; unset fields fall back to
+	// the destination moodys connection
+	FDWTarget *FDWTarget
+	// ImportForeignSchema imports the tenant's foreign tables from the remote schema
+	// instead of creating them from the dump
+	ImportForeignSchema *ImportForeignSchemaConfig
+	// Compat adds to and disables the rules that rewrite plain sections for a
+	// destination of a different major version
+	Compat *CompatConfig
+	// Transforms rewrite plain sections after the built-in rewrites
+	Transforms []TransformRule
+	// FDWTuning sets performance options on the foreign servers and tables the
+	// restore rewrites
+	FDWTuning *FDWTuning
+	// SchemaTemplate renames each tenant schema after restore, rendered with NameData
+	SchemaTemplate string
+	// NameData feeds the schema template
+	NameData NameData
+	// Renames rename schemas and tables on the destination by rewriting the restored SQL
+	Renames []RenameRule
+	// MaxBadRows loads per-table data through the COPY loader, skipping up to this many
+	// rejected rows per table into spill files. Zero disables row-level tolerance.
+	MaxBadRows int
+	// SingleTransaction restores plain-text sections in one transaction that stops and
+	// rolls back at the first error
+	SingleTransaction bool
+	// Locale decides what a destination whose encoding or collation differs from the
+	// source's does; nil warns
+	Locale *LocaleCheckConfig
+	// DatabaseSettings reapplies the source databases' recorded settings after restore
+	DatabaseSettings *DatabaseSettingsConfig
+	// RetryPreData restores a plain pre-data script object by object, retrying objects
+	// that fail on dependencies created later in the script
+	RetryPreData bool
+	// ErrorPolicy decides which reported errors fail a section
+	ErrorPolicy ErrorPolicy
+	// Encryption provides the key for encrypted artifacts
+	Encryption *EncryptionConfig
+	// GPG decrypts and verifies GPG-encrypted artifacts
+	GPG *GPGConfig
+	// RefreshMatviews refreshes materialized views after post-data, moodys first so
+	// FDW-backed views in tenant can reach their foreign tables
+	RefreshMatviews bool
+	// RefreshConcurrently uses REFRESH ... CONCURRENTLY where the view allows it
+	RefreshConcurrently bool
+	// Subscriptions controls how logical replication objects in post-data are restored
+	Subscriptions SubscriptionPolicy
+	// DisableEventTriggers keeps event triggers from firing during the restore: existing
+	// ones are disabled and dumped ones are created once everything else is restored
+	DisableEventTriggers bool
+	// SkipExtensionObjects leaves out post-data objects owned by extensions
+	SkipExtensionObjects bool
+	// Locks sets a lock timeout on restore sessions and reports who blocks them
+	Locks *LockPolicy
+	// Watchdog reports restore statements running too long and may cancel them, so the
+	// retry runs them with its retry settings
+	Watchdog *StatementWatchdog
+	// PostData restores post-data in an index phase and a foreign key phase
+	PostData *PostDataConfig
+	// IndexMemory sizes maintenance_work_mem for post-data from the destination's memory
+	IndexMemory *IndexMemoryConfig
+	// TOCTiming runs pg_restore with --verbose and reports the slowest objects restored
+	TOCTiming bool
+	// Provider adapts the restore to a managed service at the destination
+	Provider *ProviderConfig
+	// NonSuperuser restores as a role without superuser rights; NonSuperuserSkip leaves
+	// out extensions, extension comments, and event triggers the role can't create, and
+	// restores into an existing database when the role can't create one
+	NonSuperuser string
+	// Unlogged switches the destination tables to unlogged while data loads and back to
+	// logged before post-data, trading crash safety during the load for less WAL
+	Unlogged bool
+	// DetachPartitions detaches separately dumped partitions from their parents while
+	// their data loads and attaches them again afterwards
+	DetachPartitions bool
+	// SchemaSplit restores some of tenant's schemas into databases of their own, which
+	// the tenant destination reaches through foreign servers
+	SchemaSplit []SplitTarget
+
+	// listFile restricts a custom-format restore to the entries listed in it
+	listFile string
+	// jobs overrides the number of parallel pg_restore workers
+	jobs int
+	// phase names the part of a section restored, for its step name
+	phase string
+	// renamer rewrites the section's SQL for the database being restored
+	renamer *renamer
+	// skipEventTriggers leaves event triggers out of post-data
+	skipEventTriggers bool
+	// session are SET statements run ahead of plain scripts
+	session []string
+	// stream opens the plaintext of an encrypted section, which is then restored from
+	// psql's or pg_restore's stdin rather than a decrypted copy of inputFile
+	stream func() (io.ReadCloser, error)
+	// span is the span the section's commands belong under; nil uses the innermost open
+	// span
+	span *Span
+	// split and splitHome restrict tenant's sections to the objects of one database of
+	// a schema split: a target's name, or "" for the tenant destination
+	split     *schemaSplit
+	splitHome string
+}
+
 // RestoreWorkflow restores both databases with proper FDW configuration
 func RestoreWorkflow(srcMoodysConfig, srcTenantConfig, destMoodysConfig, destTenantConfig DBConfig, inputDir string) error {
-	// Create destination databases
-	if err := CreateDatabase(destMoodysConfig); err != nil {
-		return fmt.Errorf("failed to create moodys database: %w", err)
+	return RestoreWorkflowWithOptions(srcMoodysConfig, srcTenantConfig, destMoodysConfig, destTenantConfig, inputDir, RestoreOptions{})
+}
+
+// restoreRun is the state shared by the tasks of a RestoreWorkflowWithOptions run
+type restoreRun struct {
+	srcMoodys, srcTenant   DBConfig
+	destMoodys, destTenant DBConfig
+	inputDir               string
+	opts                   RestoreOptions
+
+	artifacts *artifactResolver
+	renamers  map[string]*renamer
+	// partitions are each database's separately dumped partition archives by table
+	partitions   map[string]map[string]string
+	split        *schemaSplit
+	splitTargets map[string]DBConfig
+	sessions     map[string][]string
+	// createOptions, compat, and privileges are looked up on each destination first
+	createOptions map[string]string
+	compat        map[string]*compatLayer
+	privileges    map[string]PrivilegeTarget
+	planDatabases map[string]WorkflowDatabase
+	state         *RunState
+	guard         *eventTriggerGuard
+	created       *createdDatabases
+}
+
+// RestoreWorkflowWithOptions restores both databases with proper FDW configuration
+func RestoreWorkflowWithOptions(srcMoodysConfig, srcTenantConfig, destMoodysConfig, destTenantConfig DBConfig, inputDir string, opts RestoreOptions) error {
+	codec, err := newArtifactCodec(opts.Encryption, opts.GPG)
+	if err != nil {
+		return err
+	}
+	if opts.GPG != nil {
+		if err := opts.GPG.VerifyFile(filepath.Join(inputDir, manifestFileName)); err != nil {
+			return fmt.Errorf("manifest provenance check failed: %w", err)
+		}
+	}
+	r := &restoreRun{
+		srcMoodys:  srcMoodysConfig,
+		srcTenant:  srcTenantConfig,
+		destMoodys: destMoodysConfig,
+		destTenant: destTenantConfig,
+		inputDir:   inputDir,
+		opts:       opts,
+		artifacts:  newArtifactResolver(inputDir, codec),
+		created:    &createdDatabases{},
+	}
+	defer r.artifacts.Cleanup()
+	if err := setStepLogDir(filepath.Join(inputDir, "logs")); err != nil {
+		return err
 	}
-	if err := CreateDatabase(destTenantConfig); err != nil {
-		return fmt.Errorf("failed to create tenant database: %w", err)
+	if err := r.checkOptions(); err != nil {
+		return err
+	}
+	if err := r.inspectDestinations(); err != nil {
+		return err
 	}
 
-	// Restore Moodys database first (it's the source for FDW)
-	sections := []string{"pre-data", "data", "post-data"}
-	for _, section := range sections {
-		fileExt := ".dump"
-		if section == "pre-data" {
-			fileExt = ".sql"
+	r.planDatabases = map[string]WorkflowDatabase{
+		"moodys": {Source: r.srcMoodys, Dest: r.destMoodys},
+		"tenant": {Source: r.srcTenant, Dest: r.destTenant},
+	}
+	r.state = NewRunState(r.opts.RunID, inputDir)
+	if r.opts.DisableEventTriggers {
+		r.guard = &eventTriggerGuard{}
+		defer func() {
+			if err := r.guard.restore(); err != nil {
+				log.Printf("WARNING: %v", err)
+			}
+		}()
+	}
+
+	if err := runTasks(r.tasks()); err != nil {
+		r.created.cleanUp(r.opts.OnFailure)
+		return err
+	}
+	return r.finish()
+}
+
+// checkOptions builds the renamers and schema split and rejects the combinations of
+// options the restore doesn't support
+func (r *restoreRun) checkOptions() error {
+	r.renamers = make(map[string]*renamer)
+	for _, database := range []string{"moodys", "tenant"} {
+		rn, err := newRenamer(r.opts.Renames, database)
+		if err != nil {
+			return err
 		}
-		inFile := filepath.Join(inputDir, fmt.Sprintf("moodys_%s%s", section, fileExt))
-		if err := restoreDatabaseSection(destMoodysConfig, inFile, section); err != nil {
-			return fmt.Errorf("failed to restore moodys %s: %w", section, err)
+		if rn != nil && r.opts.PerTable {
+			return fmt.Errorf("rename rules are not supported with per-table restore")
 		}
+		r.renamers[database] = rn
 	}
 
-	// Modify tenant pre-data file to update FDW configuration
-	tenantPreDataFile := filepath.Join(inputDir, "tenant_pre-data.sql")
-	if err := modifyPreDataFile(tenantPreDataFile, srcMoodysConfig, destMoodysConfig); err != nil {
-		return fmt.Errorf("failed to modify tenant pre-data file: %w", err)
+	r.partitions = partitionArtifacts(r.inputDir)
+	for database, files := range r.partitions {
+		if len(files) > 0 && r.renamers[database] != nil {
+			return fmt.Errorf("rename rules are not supported with partition dumps")
+		}
+	}
+
+	r.split = newSchemaSplit(r.opts.SchemaSplit)
+	if r.split != nil && includesDatabase(r.opts.Databases, "tenant") {
+		if r.renamers["tenant"] != nil || r.opts.PerTable || len(r.partitions["tenant"]) > 0 || r.opts.SchemaTemplate != "" {
+			return fmt.Errorf("a schema split is not supported with rename rules, per-table restore, partition dumps, or a schema template")
+		}
+	}
+	r.splitTargets = make(map[string]DBConfig)
+	for _, t := range r.opts.SchemaSplit {
+		r.splitTargets[t.DBName] = t.config(r.destTenant)
+	}
+	return nil
+}
+
+// inspectDestinations looks up each destination's version, locale, and the restoring
+// role's privileges. Plain sections dumped by another major version are rewritten for
+// the destination and, for a non-superuser, stripped of what the role can't create.
+func (r *restoreRun) inspectDestinations() error {
+	sourceVersions := sourceMajorVersions(r.inputDir)
+	sourceLocales := recordedLocales(r.inputDir)
+	r.sessions = recordedSessionSettings(r.inputDir)
+	r.createOptions = make(map[string]string)
+	r.compat = make(map[string]*compatLayer)
+	r.privileges = make(map[string]PrivilegeTarget)
+	for database, dest := range map[string]*DBConfig{"moodys": &r.destMoodys, "tenant": &r.destTenant} {
+		if !includesDatabase(r.opts.Databases, database) {
+			continue
+		}
+		version, err := serverVersionNum(maintenanceConfig(*dest))
+		if err != nil {
+			return err
+		}
+		if r.createOptions[database], err = r.opts.Locale.checkLocale(database, *dest, version, sourceLocales[database]); err != nil {
+			return err
+		}
+		var always []CompatRule
+		if r.opts.NonSuperuser == NonSuperuserSkip {
+			if r.privileges[database], err = lookUpPrivileges(database, *dest, r.opts.Provider); err != nil {
+				return fmt.Errorf("failed to check privileges on %s: %w", database, err)
+			}
+			always = superuserRules(r.privileges[database])
+			var removed []string
+			if dest.Options, removed = stripSettings(dest.Options, r.privileges[database].SuperuserSettings); len(removed) > 0 {
+				log.Printf("WARNING: %s can't change %s; leaving them out of the %s connection options", dest.User, strings.Join(removed, ", "), database)
+			}
+		}
+		always = append(always, r.opts.Provider.providerRules()...)
+		if r.compat[database] = newCompatLayer(r.opts.Compat, sourceVersions[database], majorVersion(version), always...); r.compat[database] != nil && sourceVersions[database] != majorVersion(version) {
+			log.Printf("Restoring %s from PostgreSQL %d into %d with compatibility rules", database, sourceVersions[database], majorVersion(version))
+		}
+		if r.opts.DisableEventTriggers && r.opts.NonSuperuser == NonSuperuserSkip && !r.privileges[database].Role.Superuser {
+			log.Printf("WARNING: %s can't disable event triggers without superuser; -disable-event-triggers is ignored", dest.User)
+			r.opts.DisableEventTriggers = false
+		}
+	}
+	return nil
+}
+
+// restoreSection restores a section artifact into config after the rewrites it needs
+func (r *restoreRun) restoreSection(parent *Span, config DBConfig, name, section string) error {
+	opts := r.opts
+	database, _, _ := strings.Cut(name, "_")
+	// Encrypted data is decrypted on the way into the restore, never to a file
+	var inFile string
+	var stream func() (io.ReadCloser, error)
+	var err error
+	if section == "data" {
+		inFile, stream, _ = r.artifacts.Stream(name)
+	}
+	if stream == nil {
+		if inFile, err = r.artifacts.Resolve(name); err != nil {
+			return err
+		}
+	}
+
+	var review *rewriteReview
+	if section == "pre-data" {
+		if review, err = reviewRewrites(inFile); err != nil {
+			return err
+		}
+		defer review.close()
+	}
+	if opts.FDWPlan != nil && section == "pre-data" {
+		if inFile, err = destinationCopy(inFile, config); err != nil {
+			return err
+		}
+		if err := applyFDWPlan(inFile, database, opts.FDWPlan, r.planDatabases); err != nil {
+			return err
+		}
+		if opts.FDWTuning != nil {
+			if err := tunePreDataFile(inFile, tunedServers(database, opts.FDWPlan), opts.FDWTuning); err != nil {
+				return err
+			}
+		}
+	}
+	var format ArtifactFormat
+	if stream != nil {
+		if format, err = detectStreamFormat(inFile, stream); err != nil {
+			return err
+		}
+		if err := checkStreamable(inFile, format, opts.PerTable, r.compat[database] != nil || len(transformsFor(opts.Transforms, database, section)) > 0); err != nil {
+			return err
+		}
+	} else {
+		var cleanup func()
+		if inFile, format, cleanup, err = prepareArtifact(inFile); err != nil {
+			return err
+		}
+		defer cleanup()
+		if inFile, cleanup, err = r.compat[database].copy(inFile); err != nil {
+			return err
+		}
+		defer cleanup()
+		if inFile, cleanup, err = applyTransforms(opts.Transforms, database, section, inFile); err != nil {
+			return err
+		}
+		defer cleanup()
+	}
+	plainData := section == "data" && !format.archive()
+	if plainData && (opts.PerTable || r.renamers[database] != nil) {
+		return fmt.Errorf("per-table restore and rename rules need an archive data dump, but %s is plain SQL", name)
+	}
+	sectionOpts := opts
+	if r.split != nil && database == "tenant" {
+		sectionOpts.split = r.split
+		if _, ok := r.splitTargets[config.DBName]; ok {
+			sectionOpts.splitHome = config.DBName
+		}
+		if section == "data" {
+			if plainData {
+				return fmt.Errorf("a schema split needs an archive data dump, but %s is plain SQL", name)
+			}
+			var entries []TOCEntry
+			if stream != nil {
+				entries, err = listTOCStream(inFile, stream)
+			} else {
+				entries, err = listTOC(inFile)
+			}
+			if err != nil {
+				return err
+			}
+			listFile, cleanup, err := tempTOCList(r.split.filterTOC(sectionOpts.splitHome, entries))
+			if err != nil {
+				return err
+			}
+			defer cleanup()
+			sectionOpts.listFile = listFile
+		}
+	}
+	sectionOpts.stream = stream
+	sectionOpts.renamer = r.renamers[database]
+	sectionOpts.session = r.sessions[database]
+	sectionOpts.skipEventTriggers = opts.NonSuperuser == NonSuperuserSkip && !r.privileges[database].Role.Superuser && !r.privileges[database].EventTriggers
+	if sectionOpts.renamer != nil && section == "pre-data" {
+		renamed, err := renamedCopy(inFile, sectionOpts.renamer, nil)
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(filepath.Dir(renamed))
+		inFile = renamed
+	}
+	if err := review.save(inFile); err != nil {
+		return err
+	}
+
+	span := startChildSpan(parent, "restore "+strings.TrimSuffix(name, filepath.Ext(name)), "db.name", config.DBName)
+	sectionOpts.span = span
+	if opts.PerTable && section == "data" {
+		err = restoreDataPerTable(config, inFile, r.state, opts)
+	} else if section == "post-data" {
+		err = restoreFilteredSection(config, inFile, section, sectionOpts, r.guard)
+	} else {
+		err = restoreDatabaseSection(config, inFile, section, sectionOpts)
+	}
+	span.End(err)
+	return err
+}
+
+// destConfigs returns the restored databases, split targets ahead of tenant so tenant's
+// views over them refresh after they do
+func (r *restoreRun) destConfigs() []DBConfig {
+	var configs []DBConfig
+	if includesDatabase(r.opts.Databases, "moodys") {
+		configs = append(configs, r.destMoodys)
+	}
+	if includesDatabase(r.opts.Databases, "tenant") {
+		for _, t := range r.opts.SchemaSplit {
+			configs = append(configs, r.splitTargets[t.DBName])
+		}
+		configs = append(configs, r.destTenant)
+	}
+	return configs
+}
+
+// tasks returns the workflow graph: each database's sections restore in order, and
+// tenant pre-data waits for moodys pre-data so its foreign server has something to
+// reach. Everything else about the two databases is independent and runs concurrently.
+func (r *restoreRun) tasks() []Task {
+	var tasks []Task
+	restoreMoodys := includesDatabase(r.opts.Databases, "moodys")
+	if restoreMoodys {
+		tasks = append(tasks,
+			Task{Name: "create_moodys", Run: r.createTask("moodys", r.destMoodys)},
+			Task{Name: "moodys_pre-data", DependsOn: []string{"create_moodys"}, Run: r.sectionTask("moodys", r.destMoodys, "pre-data")},
+			Task{Name: "moodys_data", DependsOn: []string{"moodys_pre-data"}, Run: r.sectionTask("moodys", r.destMoodys, "data")},
+			Task{Name: "moodys_post-data", DependsOn: []string{"moodys_data"}, Run: r.sectionTask("moodys", r.destMoodys, "post-data")},
+		)
+	}
+	if !includesDatabase(r.opts.Databases, "tenant") {
+		return tasks
 	}
 
-	// Restore Tenant pre-data first
-	if err := restoreDatabaseSection(destTenantConfig, tenantPreDataFile, "pre-data"); err != nil {
+	preDataDeps := []string{"create_tenant"}
+	if restoreMoodys {
+		preDataDeps = append(preDataDeps, "moodys_pre-data")
+	}
+	for _, t := range r.opts.SchemaSplit {
+		target := r.splitTargets[t.DBName]
+		preDataDeps = append(preDataDeps, "create_"+t.DBName)
+		tasks = append(tasks,
+			Task{Name: "create_" + t.DBName, Run: r.createTask("tenant", target)},
+			Task{Name: t.DBName + "_data", DependsOn: []string{"tenant_pre-data"}, Run: r.sectionTask("tenant", target, "data")},
+			Task{Name: t.DBName + "_post-data", DependsOn: []string{t.DBName + "_data"}, Run: r.sectionTask("tenant", target, "post-data")},
+		)
+	}
+	tasks = append(tasks,
+		Task{Name: "create_tenant", Run: r.createTask("tenant", r.destTenant)},
+		Task{Name: "tenant_pre-data", DependsOn: preDataDeps, Run: r.tenantPreData},
+		Task{Name: "tenant_data", DependsOn: []string{"tenant_pre-data"}, Run: r.sectionTask("tenant", r.destTenant, "data")},
+		Task{Name: "tenant_post-data", DependsOn: []string{"tenant_data"}, Run: r.sectionTask("tenant", r.destTenant, "post-data")},
+	)
+	if r.opts.SchemaTemplate != "" {
+		tasks = append(tasks, Task{Name: "tenant_rename_schemas", DependsOn: []string{"tenant_post-data"}, Run: func(*Span) error {
+			return renameSchemas(r.destTenant, r.opts.SchemaTemplate, r.opts.NameData)
+		}})
+	}
+	return tasks
+}
+
+// createTask creates a destination database, or restores into the existing one when the
+// role can't create databases
+func (r *restoreRun) createTask(database string, config DBConfig) func(*Span) error {
+	return func(*Span) error {
+		if target, ok := r.privileges[database]; ok && target.Exists && !target.Role.Superuser && !target.Role.CreateDB {
+			log.Printf("WARNING: %s can't create databases; restoring into the existing database %s", target.Role.Role, config.DBName)
+			return nil
+		}
+		if err := createDatabaseWith(config, r.createOptions[database]); err != nil {
+			return fmt.Errorf("failed to create database %s: %w", config.DBName, err)
+		}
+		r.created.add(config)
+		if r.guard != nil {
+			return r.guard.disable(config)
+		}
+		return nil
+	}
+}
+
+// sectionTask restores a section of database into config; data is followed by the
+// database's partitions and loads into unlogged tables when configured
+func (r *restoreRun) sectionTask(database string, config DBConfig, section string) func(*Span) error {
+	return func(parent *Span) error {
+		name := sectionArtifactName(r.inputDir, database, section)
+		if section != "data" {
+			if err := r.restoreSection(parent, config, name, section); err != nil {
+				return fmt.Errorf("failed to restore %s %s: %w", database, section, err)
+			}
+			return nil
+		}
+		load := func() error {
+			if err := r.restoreSection(parent, config, name, section); err != nil {
+				return fmt.Errorf("failed to restore %s %s: %w", database, section, err)
+			}
+			if len(r.partitions[database]) == 0 {
+				return nil
+			}
+			files := make(map[string]artifactInput)
+			for table, name := range r.partitions[database] {
+				input, err := r.artifacts.Input(name)
+				if err != nil {
+					return err
+				}
+				files[table] = input
+			}
+			if err := restorePartitions(r.opts.Locks.session(config), files, r.opts.DetachPartitions); err != nil {
+				return fmt.Errorf("failed to restore %s partitions: %w", database, err)
+			}
+			return nil
+		}
+		if r.opts.Unlogged {
+			return withUnloggedTables(config, load)
+		}
+		return load()
+	}
+}
+
+// tenantPreData rewrites tenant's pre-data for the destination and restores it, into the
+// split targets first when there are any
+func (r *restoreRun) tenantPreData(parent *Span) error {
+	opts := r.opts
+	tenantPreDataFile, err := r.artifacts.Resolve("tenant_pre-data.sql")
+	if err != nil {
+		return err
+	}
+	review, err := reviewRewrites(tenantPreDataFile)
+	if err != nil {
+		return err
+	}
+	defer review.close()
+	if tenantPreDataFile, err = destinationCopy(tenantPreDataFile, r.destTenant); err != nil {
+		return err
+	}
+	if err := r.retargetTenantPreData(tenantPreDataFile); err != nil {
+		return err
+	}
+
+	tenantPreDataFile, cleanupCompat, err := r.compat["tenant"].copy(tenantPreDataFile)
+	if err != nil {
+		return err
+	}
+	defer cleanupCompat()
+	tenantPreDataFile, cleanupTransforms, err := applyTransforms(opts.Transforms, "tenant", "pre-data", tenantPreDataFile)
+	if err != nil {
+		return err
+	}
+	defer cleanupTransforms()
+
+	if r.renamers["tenant"] != nil || r.renamers["moodys"] != nil {
+		renamed, err := renamedCopy(tenantPreDataFile, r.renamers["tenant"], r.renamers["moodys"])
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(filepath.Dir(renamed))
+		tenantPreDataFile = renamed
+	}
+	if err := review.save(tenantPreDataFile); err != nil {
+		return err
+	}
+
+	preDataOpts := opts
+	preDataOpts.session = r.sessions["tenant"]
+	if r.split != nil {
+		// The targets' tables exist before dest_tenant imports them
+		for _, t := range opts.SchemaSplit {
+			target := r.splitTargets[t.DBName]
+			targetFile, err := r.split.splitPreData(tenantPreDataFile, t.DBName, r.destTenant)
+			if err != nil {
+				return err
+			}
+			defer os.Remove(targetFile)
+			log.Printf("Restoring schemas %s of tenant into %s", strings.Join(t.Schemas, ", "), t.DBName)
+			span := startChildSpan(parent, "restore tenant_pre-data", "db.name", target.DBName)
+			preDataOpts.span = span
+			err = restoreDatabaseSection(target, targetFile, "pre-data", preDataOpts)
+			span.End(err)
+			if err != nil {
+				return fmt.Errorf("failed to restore tenant pre-data into %s: %w", t.DBName, err)
+			}
+		}
+		if tenantPreDataFile, err = r.split.splitPreData(tenantPreDataFile, "", r.destTenant); err != nil {
+			return err
+		}
+		defer os.Remove(tenantPreDataFile)
+	}
+
+	span := startChildSpan(parent, "restore tenant_pre-data", "db.name", r.destTenant.DBName)
+	preDataOpts.span = span
+	err = restoreDatabaseSection(r.destTenant, tenantPreDataFile, "pre-data", preDataOpts)
+	span.End(err)
+	if err != nil {
 		return fmt.Errorf("failed to restore tenant pre-data: %w", err)
 	}
+	return nil
+}
 
-	// Restore remaining tenant sections
-	for _, section := range []string{"data", "post-data"} {
-		inFile := filepath.Join(inputDir, fmt.Sprintf("tenant_%s.dump", section))
-		if err := restoreDatabaseSection(destTenantConfig, inFile, section); err != nil {
-			return fmt.Errorf("failed to restore tenant %s: %w", section, err)
+// retargetTenantPreData points the foreign servers of tenant's pre-data copy at their
+// destinations and applies the configured FDW rewrites in place
+func (r *restoreRun) retargetTenantPreData(file string) error {
+	opts := r.opts
+	if opts.FDWPlan != nil {
+		if err := applyFDWPlan(file, "tenant", opts.FDWPlan, r.planDatabases); err != nil {
+			return err
+		}
+	} else if err := modifyPreDataFile(file, r.srcMoodys, r.destMoodys); err != nil {
+		return fmt.Errorf("failed to modify tenant pre-data file: %w", err)
+	}
+	if opts.FDWTarget != nil {
+		target := opts.FDWTarget.withDefaults(r.destMoodys)
+		if err := probeFDWTarget(target); err != nil {
+			return err
+		}
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read pre-data file: %w", err)
+		}
+		rewritten := rewriteFDWTopology(string(content), moodysServerName, target)
+		if err := os.WriteFile(file, []byte(rewritten), 0644); err != nil {
+			return fmt.Errorf("failed to write retargeted pre-data file: %w", err)
+		}
+	}
+	if opts.FDWTuning != nil {
+		if err := tunePreDataFile(file, tunedServers("tenant", opts.FDWPlan), opts.FDWTuning); err != nil {
+			return err
+		}
+	}
+	if opts.ImportForeignSchema != nil {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read pre-data file: %w", err)
+		}
+		script, imported := importForeignSchema(string(content), opts.ImportForeignSchema)
+		log.Printf("Importing %d foreign tables from their remote schemas instead of the dump", len(imported))
+		if err := os.WriteFile(file, []byte(script), 0644); err != nil {
+			return fmt.Errorf("failed to write pre-data file: %w", err)
+		}
+	}
+	if opts.ServerName != "" && opts.ServerName != moodysServerName {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read pre-data file: %w", err)
+		}
+		renamed := renameServer(string(content), moodysServerName, opts.ServerName)
+		if err := os.WriteFile(file, []byte(renamed), 0644); err != nil {
+			return fmt.Errorf("failed to write renamed pre-data file: %w", err)
+		}
+	}
+	return nil
+}
+
+// finish reapplies database settings, refreshes materialized views, and re-enables
+// event triggers once every section is restored, then reports quarantined tables
+func (r *restoreRun) finish() error {
+	if r.opts.DatabaseSettings != nil {
+		recorded := recordedDatabaseSettings(r.inputDir)
+		for database, config := range map[string]DBConfig{"moodys": r.destMoodys, "tenant": r.destTenant} {
+			if !includesDatabase(r.opts.Databases, database) {
+				continue
+			}
+			if err := r.opts.DatabaseSettings.applyDatabaseSettings(config, recorded[database]); err != nil {
+				return err
+			}
+		}
+	}
+
+	if r.opts.RefreshMatviews && len(r.state.Quarantined) == 0 {
+		for _, config := range r.destConfigs() {
+			if err := RefreshMatviews(config, r.opts.RefreshConcurrently); err != nil {
+				return err
+			}
 		}
 	}
 
+	if r.guard != nil {
+		if err := r.guard.finish(); err != nil {
+			return err
+		}
+	}
+
+	if len(r.state.Quarantined) > 0 {
+		if err := r.state.Save(); err != nil {
+			return err
+		}
+		return fmt.Errorf("%d tables quarantined during restore; fix the cause and run: retry-failed %s",
+			len(r.state.Quarantined), r.state.RunID)
+	}
 	return nil
 }
 
+// includesDatabase reports whether name ("moodys" or "tenant") is selected; an empty
+// selection includes both
+func includesDatabase(selected []string, name string) bool {
+	if len(selected) == 0 {
+		return true
+	}
+	for _, s := range selected {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
 // getNumCPUs returns the number of CPU cores available for parallel processing
 func getNumCPUs() int {
 	return 1 //runtime.NumCPU()
 }
 
+// DropOptions controls how databases are dropped
+type DropOptions struct {
+	// Force terminates sessions connected to the database before dropping it
+	Force bool
+	// Automatic marks drops the tool makes on its own rather than at the operator's
+	// request; they skip the protections' confirmation prompt
+	Automatic bool
+}
+
 // DeleteDatabases ensures the databases are deleted if they exist
 func DeleteDatabases(configs ...DBConfig) error {
+	return DeleteDatabasesWithOptions(DropOptions{}, configs...)
+}
+
+// DeleteDatabasesWithOptions deletes the databases, optionally terminating active connections
+func DeleteDatabasesWithOptions(opts DropOptions, configs ...DBConfig) error {
 	for _, config := range configs {
-		if err := dropDatabase(config); err != nil {
+		if err := activeProtections.checkDrop(config, opts); err != nil {
+			return err
+		}
+
+		var err error
+		if opts.Force {
+			err = forceDropDatabase(config)
+		} else {
+			err = dropDatabase(config)
+		}
+		if err != nil {
 			return fmt.Errorf("failed to drop database %s: %w", config.DBName, err)
 		}
 	}
@@ -357,16 +1550,10 @@ func DeleteDatabases(configs ...DBConfig) error {
 
 // dropDatabase drops a PostgreSQL database
 func dropDatabase(config DBConfig) error {
-	cmd := exec.Command(
-		"psql",
-		"-h", config.Host,
-		"-p", config.Port,
-		"-U", config.User,
-		"-c", "DROP DATABASE IF EXISTS "+config.DBName,
-	)
-	cmd.Env = append(os.Environ(), "PGPASSWORD="+config.Password)
+	cmd := pgCommand("psql", config).Database("").
+		Arg("-c", "DROP DATABASE IF EXISTS "+quoteIdent(config.DBName)).Cmd()
 
-	output, err := cmd.CombinedOutput()
+	output, err := runStreaming(cmd, "drop_"+config.DBName, nil)
 	if err != nil {
 		return fmt.Errorf("failed to drop database %s: %v, output: %s", config.DBName, err, string(output))
 	}
@@ -412,21 +1599,15 @@ func populateTestData(config DBConfig, numRecords int) error {
 		);
 	`
 
-	cmd := exec.Command(
-		"psql",
-		"-h", config.Host,
-		"-p", config.Port,
-		"-U", config.User,
-		"-d", config.DBName,
+	cmd := newPsqlCmd(config,
 		"-c", createTableSQL,
 	)
-	cmd.Env = append(os.Environ(), "PGPASSWORD="+config.Password)
 
 	// Log the command being executed (with password redacted)
 	cmdStr := strings.Join(cmd.Args, " ")
 	log.Printf("Executing: %s", cmdStr)
 
-	if output, err := cmd.CombinedOutput(); err != nil {
+	if output, err := runStreaming(cmd, "populate_"+config.DBName+"_table", nil); err != nil {
 		log.Printf("Error creating table: %s", output)
 		return fmt.Errorf("failed to create table: %w", err)
 	}
@@ -464,20 +1645,14 @@ func populateTestData(config DBConfig, numRecords int) error {
 			FROM generate_series(1, %d);
 		`, currentBatch)
 
-		cmd = exec.Command(
-			"psql",
-			"-h", config.Host,
-			"-p", config.Port,
-			"-U", config.User,
-			"-d", config.DBName,
+		cmd = newPsqlCmd(config,
 			"-c", insertSQL,
 		)
-		cmd.Env = append(os.Environ(), "PGPASSWORD="+config.Password)
 
 		cmdStr := strings.Join(cmd.Args, " ")
 		log.Printf("Executing: %s", cmdStr)
 
-		if output, err := cmd.CombinedOutput(); err != nil {
+		if output, err := runStreaming(cmd, "populate_"+config.DBName+"_batch", nil); err != nil {
 			log.Printf("Error inserting test data: %s", output)
 			return fmt.Errorf("failed to insert test data: %w", err)
 		}
@@ -501,17 +1676,11 @@ func populateTestData(config DBConfig, numRecords int) error {
 		CREATE INDEX IF NOT EXISTS idx_customer_transactions_amount ON customer_transactions(amount);
 	`
 
-	cmd = exec.Command(
-		"psql",
-		"-h", config.Host,
-		"-p", config.Port,
-		"-U", config.User,
-		"-d", config.DBName,
+	cmd = newPsqlCmd(config,
 		"-c", indexSQL,
 	)
-	cmd.Env = append(os.Environ(), "PGPASSWORD="+config.Password)
 
-	if output, err := cmd.CombinedOutput(); err != nil {
+	if output, err := runStreaming(cmd, "populate_"+config.DBName+"_indexes", nil); err != nil {
 		log.Printf("Error creating indexes: %s", output)
 		return fmt.Errorf("failed to create indexes: %w", err)
 	}
@@ -532,33 +1701,21 @@ func ValidateDatabaseContent(srcConfig, destConfig DBConfig) error {
 	validateSQL := `SELECT COUNT(*) FROM customer_transactions;`
 
 	// Get source count
-	srcCmd := exec.Command(
-		"psql",
-		"-h", srcConfig.Host,
-		"-p", srcConfig.Port,
-		"-U", srcConfig.User,
-		"-d", srcConfig.DBName,
+	srcCmd := newPsqlCmd(srcConfig,
 		"-t", // tuple only
 		"-c", validateSQL,
 	)
-	srcCmd.Env = append(os.Environ(), "PGPASSWORD="+srcConfig.Password)
-	srcOutput, err := srcCmd.CombinedOutput()
+	srcOutput, err := runStreaming(srcCmd, "validate_"+srcConfig.DBName, nil)
 	if err != nil {
 		return fmt.Errorf("failed to get source record count: %w", err)
 	}
 
 	// Get destination count
-	destCmd := exec.Command(
-		"psql",
-		"-h", destConfig.Host,
-		"-p", destConfig.Port,
-		"-U", destConfig.User,
-		"-d", destConfig.DBName,
+	destCmd := newPsqlCmd(destConfig,
 		"-t", // tuple only
 		"-c", validateSQL,
 	)
-	destCmd.Env = append(os.Environ(), "PGPASSWORD="+destConfig.Password)
-	destOutput, err := destCmd.CombinedOutput()
+	destOutput, err := runStreaming(destCmd, "validate_"+destConfig.DBName, nil)
 	if err != nil {
 		return fmt.Errorf("failed to get destination record count: %w", err)
 	}
@@ -587,17 +1744,11 @@ func CreateSampleTable(config DBConfig) error {
 		('Google', 'AA');
 	`
 
-	cmd := exec.Command(
-		"psql",
-		"-h", config.Host,
-		"-p", config.Port,
-		"-U", config.User,
-		"-d", config.DBName,
+	cmd := newPsqlCmd(config,
 		"-c", createTableSQL,
 	)
-	cmd.Env = append(os.Environ(), "PGPASSWORD="+config.Password)
 
-	output, err := cmd.CombinedOutput()
+	output, err := runStreaming(cmd, "create_"+config.DBName+"_sample_table", nil)
 	if err != nil {
 		log.Printf("Error creating sample table: %s", output)
 		return fmt.Errorf("failed to create sample table: %w", err)
@@ -607,18 +1758,22 @@ func CreateSampleTable(config DBConfig) error {
 	return nil
 }
 
+// moodysServerName is the foreign server through which tenant reaches moodys
+const moodysServerName = "moodys_server"
+
 // SetupFDW sets up Foreign Data Wrapper between tenant and moodys databases
 func SetupFDW(tenantConfig, moodysConfig DBConfig) error {
+	server := quoteIdent(moodysServerName)
 	setupSQL := fmt.Sprintf(`
 		CREATE EXTENSION IF NOT EXISTS postgres_fdw;
 		
-		CREATE SERVER IF NOT EXISTS moodys_server
+		CREATE SERVER IF NOT EXISTS %s
 		FOREIGN DATA WRAPPER postgres_fdw
-		OPTIONS (host '%s', port '%s', dbname '%s');
+		OPTIONS (host %s, port %s, dbname %s);
 		
 		CREATE USER MAPPING IF NOT EXISTS FOR %s
-		SERVER moodys_server
-		OPTIONS (user '%s', password '%s');
+		SERVER %s
+		OPTIONS (user %s, password %s);
 		
 		CREATE FOREIGN TABLE companies_foreign (
 			id INTEGER,
@@ -626,22 +1781,17 @@ func SetupFDW(tenantConfig, moodysConfig DBConfig) error {
 			rating VARCHAR(10),
 			last_updated TIMESTAMP
 		)
-		SERVER moodys_server
+		SERVER %s
 		OPTIONS (schema_name 'public', table_name 'companies');
-	`, moodysConfig.Host, moodysConfig.Port, moodysConfig.DBName,
-		tenantConfig.User, moodysConfig.User, moodysConfig.Password)
-
-	cmd := exec.Command(
-		"psql",
-		"-h", tenantConfig.Host,
-		"-p", tenantConfig.Port,
-		"-U", tenantConfig.User,
-		"-d", tenantConfig.DBName,
+	`, server, quoteLiteral(moodysConfig.Host), quoteLiteral(moodysConfig.Port), quoteLiteral(moodysConfig.DBName),
+		quoteIdent(tenantConfig.User), server, quoteLiteral(moodysConfig.User), quoteLiteral(moodysConfig.Password),
+		server)
+
+	cmd := newPsqlCmd(tenantConfig,
 		"-c", setupSQL,
 	)
-	cmd.Env = append(os.Environ(), "PGPASSWORD="+tenantConfig.Password)
 
-	output, err := cmd.CombinedOutput()
+	output, err := runStreaming(cmd, "setup_fdw_"+tenantConfig.DBName, nil)
 	if err != nil {
 		log.Printf("Error setting up FDW: %s", output)
 		return fmt.Errorf("failed to setup FDW: %w", err)
@@ -3,6 +3,7 @@ package main
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -115,3 +116,64 @@ func TestDatabaseWorkflow(t *testing.T) {
 		}
 	})
 }
+
+func TestDestinationCopy(t *testing.T) {
+	dir := t.TempDir()
+	original := "CREATE SERVER moodys_server FOREIGN DATA WRAPPER postgres_fdw OPTIONS (host 'src', port '5432', dbname 'moodys');\n"
+	preData := filepath.Join(dir, "tenant_pre-data.sql")
+	if err := os.WriteFile(preData, []byte(original), 0644); err != nil {
+		t.Fatal(err)
+	}
+	src := DBConfig{Host: "src", Port: "5432", DBName: "moodys"}
+	// Restoring twice starts from the dump each time, not from the last rewrite
+	for _, dest := range []DBConfig{
+		{Host: "qa", Port: "5432", DBName: "moodys"},
+		{Host: "qa", Port: "5432", DBName: "moodys"},
+	} {
+		copyPath, err := destinationCopy(preData, dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if filepath.Base(copyPath) != "tenant_pre-data.qa_5432_moodys.sql" {
+			t.Errorf("copied to %s", copyPath)
+		}
+		if err := modifyPreDataFile(copyPath, src, dest); err != nil {
+			t.Fatal(err)
+		}
+		if content, _ := os.ReadFile(copyPath); string(content) != strings.Replace(original, "'src'", "'qa'", 1) {
+			t.Errorf("rewrote %q", content)
+		}
+	}
+	if content, _ := os.ReadFile(preData); string(content) != original {
+		t.Errorf("the artifact was changed: %q", content)
+	}
+}
+
+func TestModifyPreDataFileBackslash(t *testing.T) {
+	// pg_dump writes backslashes as they are, under standard_conforming_strings
+	original := `SET standard_conforming_strings = on;
+CREATE SERVER moodys_server FOREIGN DATA WRAPPER postgres_fdw OPTIONS (host 'src', port '5432', dbname 'moodys');
+CREATE USER MAPPING FOR postgres SERVER moodys_server OPTIONS ("user" 'svc', password 'se\cr''et');
+`
+	preData := filepath.Join(t.TempDir(), "tenant_pre-data.sql")
+	if err := os.WriteFile(preData, []byte(original), 0644); err != nil {
+		t.Fatal(err)
+	}
+	src := DBConfig{Host: "src", Port: "5432", User: "svc", Password: `se\cr'et`, DBName: "moodys"}
+	dest := DBConfig{Host: "qa", Port: "5432", User: "svc_qa", Password: `qa\pass`, DBName: "moodys"}
+	if err := modifyPreDataFile(preData, src, dest); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(preData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `SET standard_conforming_strings = on;
+CREATE SERVER moodys_server FOREIGN DATA WRAPPER postgres_fdw OPTIONS (host 'qa', port '5432', dbname 'moodys');
+CREATE USER MAPPING FOR postgres SERVER moodys_server OPTIONS ("user" 'svc_qa', password E'qa\\pass');
+`
+	if string(content) != want {
+		t.Errorf("rewrote to\n%s\nwant\n%s", content, want)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"strings"
+)
+
+// DatabaseSettingsConfig reapplies the source databases' ALTER DATABASE ... SET settings,
+// which pg_dump's sectioned dumps leave out, on the destinations after restore
+type DatabaseSettingsConfig struct {
+	// Include are setting names or path.Match patterns to apply; empty applies all
+	Include []string `json:"include"`
+	// Exclude are names or patterns never applied, e.g. "default_transaction_read_only"
+	Exclude []string `json:"exclude"`
+	// Roles also applies ALTER ROLE ... IN DATABASE settings; the roles must exist on
+	// the destination
+	Roles bool `json:"roles"`
+}
+
+func (c *DatabaseSettingsConfig) validate() error {
+	for _, pattern := range append(append([]string{}, c.Include...), c.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("setting pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// selects reports whether a recorded setting is applied
+func (c *DatabaseSettingsConfig) selects(s DatabaseSetting) bool {
+	if s.Role != "" && !c.Roles {
+		return false
+	}
+	match := func(patterns []string) bool {
+		for _, pattern := range patterns {
+			if matched, _ := path.Match(pattern, strings.ToLower(s.Name)); matched {
+				return true
+			}
+		}
+		return false
+	}
+	return (len(c.Include) == 0 || match(c.Include)) && !match(c.Exclude)
+}
+
+// DatabaseSetting is one entry of pg_db_role_setting for a database; Role is empty
+// for settings of the database as a whole
+type DatabaseSetting struct {
+	Role  string `json:"role,omitempty"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// databaseSettingsQuery reads the settings of the current database, database-wide ones first
+const databaseSettingsQuery = `SELECT coalesce(r.rolname, ''), unnest(s.setconfig)
+FROM pg_db_role_setting s
+	JOIN pg_database d ON d.oid = s.setdatabase
+	LEFT JOIN pg_roles r ON r.oid = s.setrole
+WHERE d.datname = current_database()
+ORDER BY s.setrole <> 0, 1;`
+
+// listQuotedSettings hold lists whose elements are quoted individually, so their
+// recorded value is applied as written rather than as one literal
+var listQuotedSettings = map[string]bool{
+	"search_path":               true,
+	"temp_tablespaces":          true,
+	"session_preload_libraries": true,
+	"local_preload_libraries":   true,
+}
+
+// readDatabaseSettings returns the settings recorded for config's database
+func readDatabaseSettings(config DBConfig) ([]DatabaseSetting, error) {
+	rows, err := queryRows(config, databaseSettingsQuery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read database settings of %s: %w", config.DBName, err)
+	}
+	return parseDatabaseSettings(rows), nil
+}
+
+// parseDatabaseSettings splits the name=value entries of setconfig
+func parseDatabaseSettings(rows [][]string) []DatabaseSetting {
+	var settings []DatabaseSetting
+	for _, row := range rows {
+		if len(row) != 2 {
+			continue
+		}
+		name, value, ok := strings.Cut(row[1], "=")
+		if !ok {
+			continue
+		}
+		settings = append(settings, DatabaseSetting{Role: row[0], Name: name, Value: value})
+	}
+	return settings
+}
+
+// alterStatement returns the statement applying a setting to dbname
+func (s DatabaseSetting) alterStatement(dbname string) string {
+	value := quoteLiteral(s.Value)
+	if listQuotedSettings[strings.ToLower(s.Name)] {
+		value = s.Value
+	}
+	if s.Role != "" {
+		return fmt.Sprintf("ALTER ROLE %s IN DATABASE %s SET %s = %s;", quoteIdent(s.Role), quoteIdent(dbname), quoteIdent(s.Name), value)
+	}
+	return fmt.Sprintf("ALTER DATABASE %s SET %s = %s;", quoteIdent(dbname), quoteIdent(s.Name), value)
+}
+
+// recordedDatabaseSettings returns the settings the manifest in dir recorded for each
+// source database
+func recordedDatabaseSettings(dir string) map[string][]DatabaseSetting {
+	settings := make(map[string][]DatabaseSetting)
+	manifest, err := LoadManifest(dir)
+	if err != nil {
+		return settings
+	}
+	for _, catalog := range manifest.Catalogs {
+		settings[catalog.Database] = catalog.DatabaseSettings
+	}
+	return settings
+}
+
+// applyDatabaseSettings applies the selected settings to config's database. They take
+// effect for new sessions, so the restore's own sessions aren't affected.
+func (c *DatabaseSettingsConfig) applyDatabaseSettings(config DBConfig, settings []DatabaseSetting) error {
+	args := []string{"-X", "-q", "-v", "ON_ERROR_STOP=1"}
+	var applied []string
+	for _, s := range settings {
+		if !c.selects(s) {
+			continue
+		}
+		args = append(args, "-c", s.alterStatement(config.DBName))
+		applied = append(applied, s.Name)
+	}
+	if len(applied) == 0 {
+		log.Printf("No recorded database settings to apply to %s", config.DBName)
+		return nil
+	}
+	if output, err := newPsqlCmd(maintenanceConfig(config), args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to apply database settings to %s: %w, output: %s", config.DBName, err, output)
+	}
+	log.Printf("Applied %d database settings to %s: %s", len(applied), config.DBName, strings.Join(applied, ", "))
+	return nil
+}
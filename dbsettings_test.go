@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+func TestDatabaseSettings(t *testing.T) {
+	settings := parseDatabaseSettings([][]string{
+		{"", `search_path="$user", app, public`},
+		{"", "TimeZone=America/New_York"},
+		{"", "work_mem=64MB"},
+		{"reporting", "statement_timeout=5min"},
+		{"", "malformed"},
+	})
+	if len(settings) != 4 || settings[1].Name != "TimeZone" || settings[3].Role != "reporting" {
+		t.Fatalf("parsed %+v", settings)
+	}
+
+	want := []string{
+		`ALTER DATABASE "tenant_db" SET "search_path" = "$user", app, public;`,
+		`ALTER DATABASE "tenant_db" SET "TimeZone" = 'America/New_York';`,
+		`ALTER DATABASE "tenant_db" SET "work_mem" = '64MB';`,
+		`ALTER ROLE "reporting" IN DATABASE "tenant_db" SET "statement_timeout" = '5min';`,
+	}
+	for i, s := range settings {
+		if got := s.alterStatement("tenant_db"); got != want[i] {
+			t.Errorf("alterStatement = %s, want %s", got, want[i])
+		}
+	}
+
+	c := &DatabaseSettingsConfig{Exclude: []string{"work_*"}}
+	if err := c.validate(); err != nil {
+		t.Fatal(err)
+	}
+	var selected []string
+	for _, s := range settings {
+		if c.selects(s) {
+			selected = append(selected, s.Name)
+		}
+	}
+	if len(selected) != 2 || selected[0] != "search_path" || selected[1] != "TimeZone" {
+		t.Errorf("selected %v", selected)
+	}
+	c = &DatabaseSettingsConfig{Include: []string{"statement_timeout"}, Roles: true}
+	if !c.selects(settings[3]) || c.selects(settings[0]) {
+		t.Error("include patterns or roles not honored")
+	}
+	if err := (&DatabaseSettingsConfig{Include: []string{"["}}).validate(); err == nil {
+		t.Error("bad pattern passed validation")
+	}
+}
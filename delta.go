@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DeltaConfig dumps the data of tables that rarely change into archives of their own,
+// which the next dump into the same directory keeps instead of dumping the table again
+// while its rows are unchanged
+type DeltaConfig struct {
+	// Tables are schema-qualified names or path.Match patterns such as "ref.*"
+	Tables []string `json:"tables"`
+}
+
+func (d *DeltaConfig) validate() error {
+	if len(d.Tables) == 0 {
+		return fmt.Errorf("tables is required")
+	}
+	for _, pattern := range d.Tables {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("table pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// matches reports whether a table is dumped separately
+func (d *DeltaConfig) matches(table string) bool {
+	for _, pattern := range d.Tables {
+		if matched, _ := path.Match(pattern, table); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// deltaTablesQuery lists ordinary tables outside system schemas; partitions are left to
+// -partitions
+const deltaTablesQuery = `SELECT n.nspname || '.' || c.relname FROM pg_class c
+JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE c.relkind = 'r' AND NOT c.relispartition
+	AND n.nspname NOT IN ('pg_catalog', 'information_schema') AND n.nspname NOT LIKE 'pg\_%'
+ORDER BY 1;`
+
+// listDeltaTables returns the tables of config's database that d selects
+func (d *DeltaConfig) listDeltaTables(config DBConfig) ([]string, error) {
+	rows, err := queryRows(config, deltaTablesQuery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables of %s: %w", config.DBName, err)
+	}
+	var tables []string
+	for _, row := range rows {
+		if d.matches(row[0]) {
+			tables = append(tables, row[0])
+		}
+	}
+	return tables, nil
+}
+
+// deltaArtifactName returns the archive a delta table's data is dumped to
+func deltaArtifactName(namePrefix, table string) string {
+	return fmt.Sprintf("%s_data_table_%s.dump", namePrefix, table)
+}
+
+// tableFingerprint returns the row count and a checksum of every row of a table as of
+// an exported snapshot, so they describe exactly the rows pg_dump would write
+func tableFingerprint(config DBConfig, snapshotID, table string) (int64, string, error) {
+	query := fmt.Sprintf("SELECT count(*), coalesce(md5(string_agg(h, '' ORDER BY h)), '') FROM (SELECT md5(t::text) AS h FROM %s t) rows;",
+		quoteQualifiedName(table))
+	output, err := newPsqlCmd(config, "-X", "-q", "-t", "-A", "-F", fieldSep, "-v", "ON_ERROR_STOP=1",
+		"-c", "BEGIN ISOLATION LEVEL REPEATABLE READ READ ONLY;",
+		"-c", "SET TRANSACTION SNAPSHOT "+quoteLiteral(snapshotID)+";",
+		"-c", query, "-c", "COMMIT;").Output()
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to checksum %s: %w", table, err)
+	}
+	return parseFingerprint(string(output))
+}
+
+// parseFingerprint parses the count and checksum tableFingerprint queries
+func parseFingerprint(output string) (int64, string, error) {
+	count, checksum, ok := strings.Cut(strings.TrimRight(output, "\r\n"), fieldSep)
+	if !ok {
+		return 0, "", fmt.Errorf("unexpected checksum output %q", output)
+	}
+	rows, err := strconv.ParseInt(count, 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("unexpected row count %q", count)
+	}
+	return rows, checksum, nil
+}
+
+// previousDeltaArtifacts returns the delta archives of the dump set already in dir by
+// table, for one database
+func previousDeltaArtifacts(dir, namePrefix string) map[string]ManifestArtifact {
+	previous := make(map[string]ManifestArtifact)
+	manifest, err := LoadManifest(dir)
+	if err != nil {
+		return previous
+	}
+	for _, a := range manifest.Artifacts {
+		if a.Database == namePrefix && a.Table != "" && !a.Encrypted {
+			previous[a.Table] = a
+		}
+	}
+	return previous
+}
+
+// reusable reports whether a previous archive still holds exactly a table's rows
+func reusable(previous ManifestArtifact, dir string, rows int64, checksum string) bool {
+	if previous.Rows != rows || previous.Checksum != checksum {
+		return false
+	}
+	info, err := os.Stat(filepath.Join(dir, previous.File))
+	return err == nil && info.Size() == previous.Size
+}
+
+// dumpDeltaTables dumps each table into its own archive, keeping the archive of the
+// previous dump set instead where the table's row count and checksum haven't changed.
+// Checksums read each table in full, which costs source reads but no transfer or storage.
+func dumpDeltaTables(config DBConfig, outputDir, namePrefix, snapshotID string, tables []string, previous map[string]ManifestArtifact) ([]ManifestArtifact, error) {
+	artifacts := make([]ManifestArtifact, len(tables))
+	log.Printf("Checking %d delta tables of %s against the previous dump set", len(tables), config.DBName)
+	err := runConcurrently(len(tables), getNumCPUs(), func(i int) error {
+		table := tables[i]
+		rows, checksum, err := tableFingerprint(config, snapshotID, table)
+		if err != nil {
+			return err
+		}
+		if old, ok := previous[table]; ok && reusable(old, outputDir, rows, checksum) {
+			old.DBName, old.Reused = config.DBName, true
+			artifacts[i] = old
+			debugf("Keeping the archive of unchanged table %s", table)
+			return nil
+		}
+		outFile := filepath.Join(outputDir, deltaArtifactName(namePrefix, table))
+		if err := dumpTableArchive(config, outFile, table, snapshotID, "table"); err != nil {
+			return err
+		}
+		artifacts[i] = ManifestArtifact{
+			Database: namePrefix,
+			DBName:   config.DBName,
+			Section:  "data",
+			File:     filepath.Base(outFile),
+			Format:   FormatCustom,
+			Size:     artifactSize(outFile),
+			Table:    table,
+			Rows:     rows,
+			Checksum: checksum,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	reused := 0
+	kept := make(map[string]bool)
+	for _, a := range artifacts {
+		kept[a.File] = true
+		if a.Reused {
+			reused++
+		}
+	}
+	// Archives of tables that are gone or no longer selected would only take up space
+	for _, old := range previous {
+		if !kept[old.File] {
+			os.Remove(filepath.Join(outputDir, old.File))
+		}
+	}
+	log.Printf("Reused %d of %d delta table archives of %s; dumped %d", reused, len(tables), config.DBName, len(tables)-reused)
+	return artifacts, nil
+}
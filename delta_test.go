@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDeltaConfig(t *testing.T) {
+	d := &DeltaConfig{Tables: []string{"ref.*", "public.countries"}}
+	if err := d.validate(); err != nil {
+		t.Fatal(err)
+	}
+	for table, want := range map[string]bool{"ref.ratings": true, "public.countries": true, "public.orders": false} {
+		if got := d.matches(table); got != want {
+			t.Errorf("matches(%s) = %v, want %v", table, got, want)
+		}
+	}
+	if err := (&DeltaConfig{}).validate(); err == nil {
+		t.Error("empty tables passed validation")
+	}
+	if err := (&DeltaConfig{Tables: []string{"ref.["}}).validate(); err == nil {
+		t.Error("bad pattern passed validation")
+	}
+}
+
+func TestParseFingerprint(t *testing.T) {
+	rows, checksum, err := parseFingerprint("42" + fieldSep + "0cc175b9c0f1b6a831c399e269772661\n")
+	if err != nil || rows != 42 || checksum != "0cc175b9c0f1b6a831c399e269772661" {
+		t.Errorf("parseFingerprint = %d, %q, %v", rows, checksum, err)
+	}
+	if rows, checksum, err := parseFingerprint("0" + fieldSep + "\n"); err != nil || rows != 0 || checksum != "" {
+		t.Errorf("empty table = %d, %q, %v", rows, checksum, err)
+	}
+	if _, _, err := parseFingerprint("ERROR"); err == nil {
+		t.Error("unexpected output parsed")
+	}
+}
+
+func TestDeltaReuse(t *testing.T) {
+	dir := t.TempDir()
+	file := deltaArtifactName("moodys", "ref.ratings")
+	if err := os.WriteFile(filepath.Join(dir, file), []byte("archive"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	artifact := ManifestArtifact{Database: "moodys", Section: "data", File: file, Size: 7, Table: "ref.ratings", Rows: 3, Checksum: "abc"}
+	manifest := &Manifest{Artifacts: []ManifestArtifact{
+		{Database: "moodys", Section: "data", File: "moodys_data.dump"},
+		artifact,
+		{Database: "tenant", Section: "data", File: "tenant_data_table_ref.x.dump", Table: "ref.x"},
+	}}
+	if err := WriteManifest(dir, manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	previous := previousDeltaArtifacts(dir, "moodys")
+	if len(previous) != 1 || previous["ref.ratings"].File != file {
+		t.Fatalf("previous = %+v", previous)
+	}
+	if !reusable(previous["ref.ratings"], dir, 3, "abc") {
+		t.Error("unchanged table not reused")
+	}
+	if reusable(previous["ref.ratings"], dir, 3, "abd") || reusable(previous["ref.ratings"], dir, 4, "abc") {
+		t.Error("changed table reused")
+	}
+	os.WriteFile(filepath.Join(dir, file), []byte("truncated"), 0644)
+	if reusable(previous["ref.ratings"], dir, 3, "abc") {
+		t.Error("archive of another size reused")
+	}
+
+	if got := partitionArtifacts(dir)["moodys"]["ref.ratings"]; got != file {
+		t.Errorf("restore doesn't load the delta archive: %q", got)
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// rootDumpSet names the dump set stored directly at a location rather than below it
+const rootDumpSet = "."
+
+// DumpSetSummary describes a dump set found at a storage location or in a directory
+type DumpSetSummary struct {
+	// Name is the set's prefix or subdirectory relative to the location, or rootDumpSet
+	Name      string
+	CreatedAt time.Time
+	// Size and Files count what is stored, which includes checksums.json and signatures
+	Size      int64
+	Files     int
+	Databases []string
+	Sources   []string
+	Encrypted bool
+	// Checksums reports whether the set has checksums.json to verify downloads against
+	Checksums bool
+	Labels    []string
+	Hold      bool
+	// Missing lists artifacts the manifest names that aren't stored
+	Missing []string
+}
+
+// summarizeDumpSet describes a set from its manifest and what is stored, where stored
+// reports whether one of its files is
+func summarizeDumpSet(name string, m *Manifest, size int64, files int, checksums bool, stored func(file string) bool) DumpSetSummary {
+	summary := DumpSetSummary{Name: name, CreatedAt: m.CreatedAt, Size: size, Files: files, Checksums: checksums, Labels: m.Labels, Hold: m.Hold}
+	seen := make(map[string]bool)
+	for _, a := range m.Artifacts {
+		if !seen[a.Database] {
+			seen[a.Database] = true
+			summary.Databases = append(summary.Databases, a.Database)
+		}
+		summary.Encrypted = summary.Encrypted || a.Encrypted
+		if !stored(a.File) {
+			summary.Missing = append(summary.Missing, a.File)
+		}
+	}
+	for _, s := range m.Sources {
+		source := fmt.Sprintf("%s@%s:%s", s.Database, s.Host, s.Port)
+		if s.Replica {
+			source += " (replica)"
+		}
+		summary.Sources = append(summary.Sources, source)
+	}
+	return summary
+}
+
+// sortDumpSets orders sets newest first
+func sortDumpSets(sets []DumpSetSummary) {
+	sort.SliceStable(sets, func(i, j int) bool { return sets[i].CreatedAt.After(sets[j].CreatedAt) })
+}
+
+// ListLocalDumpSets finds the dump sets in dir and its immediate subdirectories
+func ListLocalDumpSets(dir string) ([]DumpSetSummary, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", dir, err)
+	}
+	names := []string{rootDumpSet}
+	for _, e := range entries {
+		if e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	var sets []DumpSetSummary
+	for _, name := range names {
+		setDir := filepath.Join(dir, name)
+		m, err := LoadManifest(setDir)
+		if err != nil {
+			continue
+		}
+		stored := func(file string) bool {
+			_, err := os.Stat(filepath.Join(setDir, file))
+			return err == nil
+		}
+		// Split artifacts are listed by their parts, which needs every index
+		files := []string{manifestFileName}
+		for _, a := range m.Artifacts {
+			if artifact, err := artifactFiles(setDir, a.File); err == nil {
+				files = append(files, artifact...)
+			}
+		}
+		for _, f := range []string{manifestFileName + ".asc", checksumsFileName} {
+			if stored(f) {
+				files = append(files, f)
+			}
+		}
+		var size, count int64
+		for _, f := range files {
+			if info, err := os.Stat(filepath.Join(setDir, f)); err == nil {
+				size += info.Size()
+				count++
+			}
+		}
+		sets = append(sets, summarizeDumpSet(name, m, size, int(count), stored(checksumsFileName), stored))
+	}
+	sortDumpSets(sets)
+	return sets, nil
+}
+
+// DumpSetFilter picks the dump set a restore uses from those listed
+type DumpSetFilter struct {
+	// Before keeps sets created before it; zero keeps all
+	Before time.Time
+	// Label keeps sets carrying it
+	Label string
+}
+
+// parseSetTime parses a -from value: a timestamp, or a date standing for the end of
+// that day, so -from=2024-06-01 selects the last set created on or before it
+func parseSetTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.Add(time.Nanosecond), nil
+	}
+	day, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither a date (2006-01-02) nor an RFC 3339 timestamp", value)
+	}
+	return day.AddDate(0, 0, 1), nil
+}
+
+// matches reports whether a set passes the filter
+func (f DumpSetFilter) matches(s DumpSetSummary) bool {
+	if !f.Before.IsZero() && !s.CreatedAt.Before(f.Before) {
+		return false
+	}
+	if f.Label == "" {
+		return true
+	}
+	for _, label := range s.Labels {
+		if label == f.Label {
+			return true
+		}
+	}
+	return false
+}
+
+// selectDumpSet returns the newest complete set of sets, which are sorted newest first,
+// passing the filter. Sets missing artifacts are skipped with a warning.
+func selectDumpSet(sets []DumpSetSummary, filter DumpSetFilter) (DumpSetSummary, error) {
+	for _, s := range sets {
+		if !filter.matches(s) {
+			continue
+		}
+		if len(s.Missing) > 0 {
+			log.Printf("WARNING: skipping dump set %s: its manifest names artifacts that aren't stored: %s", s.Name, strings.Join(s.Missing, ", "))
+			continue
+		}
+		return s, nil
+	}
+	var conditions []string
+	if !filter.Before.IsZero() {
+		conditions = append(conditions, "created before "+filter.Before.Local().Format(time.RFC3339))
+	}
+	if filter.Label != "" {
+		conditions = append(conditions, "labelled "+filter.Label)
+	}
+	if len(conditions) == 0 {
+		return DumpSetSummary{}, fmt.Errorf("no complete dump set found")
+	}
+	return DumpSetSummary{}, fmt.Errorf("no complete dump set %s found", strings.Join(conditions, " and "))
+}
+
+// storedObject is an entry of list-objects-v2
+type storedObject struct {
+	Key  string
+	Size int64
+}
+
+// setPrefixes groups stored objects by the set they belong to: objects directly at the
+// location belong to rootDumpSet, objects one level below to the set named by their
+// prefix. Only groups holding a manifest are sets; deeper objects are ignored.
+func setPrefixes(prefix string, objects []storedObject) map[string][]storedObject {
+	groups := make(map[string][]storedObject)
+	for _, o := range objects {
+		rel := o.Key
+		if prefix != "" {
+			rel = strings.TrimPrefix(o.Key, prefix+"/")
+		}
+		dir := path.Dir(rel)
+		if strings.Contains(dir, "/") {
+			continue
+		}
+		groups[dir] = append(groups[dir], o)
+	}
+	sets := make(map[string][]storedObject)
+	for dir, objects := range groups {
+		for _, o := range objects {
+			if path.Base(o.Key) == manifestFileName {
+				sets[dir] = objects
+				break
+			}
+		}
+	}
+	return sets
+}
+
+// Set returns a store for the dump set with the given name below the location
+func (s *ObjectStore) Set(name string) *ObjectStore {
+	set := *s
+	if name != "" && name != rootDumpSet {
+		set.prefix = path.Join(s.prefix, name)
+		set.config.URL = strings.TrimSuffix(s.config.URL, "/") + "/" + name
+	}
+	return &set
+}
+
+// listObjects returns every object below the location
+func (s *ObjectStore) listObjects() ([]storedObject, error) {
+	var listing struct{ Contents []storedObject }
+	args := []string{"list-objects-v2", "--bucket", s.bucket}
+	if s.prefix != "" {
+		args = append(args, "--prefix", s.prefix+"/")
+	}
+	if err := s.run(&listing, args...); err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", s.config.URL, err)
+	}
+	return listing.Contents, nil
+}
+
+// FetchManifest downloads a set's manifest into a temporary directory and reads it
+func (s *ObjectStore) FetchManifest() (*Manifest, error) {
+	dir, err := os.MkdirTemp("", "pg_restore_fdw_manifest_")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	if err := s.Download(manifestFileName, filepath.Join(dir, manifestFileName), nil); err != nil {
+		return nil, err
+	}
+	return LoadManifest(dir)
+}
+
+// ListDumpSets finds the dump sets stored at the location and below it
+func (s *ObjectStore) ListDumpSets() ([]DumpSetSummary, error) {
+	objects, err := s.listObjects()
+	if err != nil {
+		return nil, err
+	}
+	var sets []DumpSetSummary
+	for name, stored := range setPrefixes(s.prefix, objects) {
+		m, err := s.Set(name).FetchManifest()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the manifest of %s: %w", name, err)
+		}
+		var size int64
+		names := make(map[string]bool)
+		for _, o := range stored {
+			size += o.Size
+			names[path.Base(o.Key)] = true
+		}
+		isStored := func(file string) bool { return names[file] }
+		sets = append(sets, summarizeDumpSet(name, m, size, len(stored), names[checksumsFileName], isStored))
+	}
+	sortDumpSets(sets)
+	return sets, nil
+}
+
+// formatDumpSets lays out sets one per line, newest first
+func formatDumpSets(sets []DumpSetSummary) string {
+	if len(sets) == 0 {
+		return "No dump sets found"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-24s %-20s %10s %6s  %-16s %s\n", "set", "created", "size", "files", "databases", "sources")
+	for _, s := range sets {
+		name := s.Name
+		if s.Encrypted {
+			name += " (encrypted)"
+		}
+		if len(s.Missing) > 0 {
+			name += " (incomplete)"
+		}
+		if s.Hold {
+			name += " (held)"
+		}
+		if len(s.Labels) > 0 {
+			name += " [" + strings.Join(s.Labels, ",") + "]"
+		}
+		fmt.Fprintf(&b, "%-24s %-20s %10s %6d  %-16s %s\n", name, s.CreatedAt.Local().Format("2006-01-02 15:04:05"),
+			formatBytes(s.Size), s.Files, strings.Join(s.Databases, ","), strings.Join(s.Sources, ", "))
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// describeDumpSet lays out a set's manifest for an operator choosing what to restore
+func describeDumpSet(name string, m *Manifest) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Dump set %s, created %s\n", name, m.CreatedAt.Local().Format(time.RFC3339))
+	if len(m.Labels) > 0 {
+		fmt.Fprintf(&b, "  labels: %s\n", strings.Join(m.Labels, ", "))
+	}
+	if m.Hold {
+		b.WriteString("  held: dumps won't replace it until it is released\n")
+	}
+	for _, s := range m.Sources {
+		fmt.Fprintf(&b, "  source %s: %s:%s", s.Database, s.Host, s.Port)
+		if s.Replica {
+			fmt.Fprintf(&b, " (replica, %s behind)", s.ReplayLag)
+		}
+		b.WriteString("\n")
+	}
+	for _, c := range m.Catalogs {
+		fmt.Fprintf(&b, "  catalog %s: database %s, PostgreSQL %s, %d tables, %d indexes, %d extensions, %d foreign servers\n",
+			c.Database, c.DBName, c.Settings["server_version"], len(c.Tables), len(c.Indexes), len(c.Extensions), len(c.ForeignServers))
+	}
+	for _, s := range m.Snapshots {
+		fmt.Fprintf(&b, "  snapshot %s: %s at %s\n", s.Database, s.LSN, s.Timestamp.Local().Format(time.RFC3339))
+	}
+	if m.SnapshotSkew != "" {
+		fmt.Fprintf(&b, "  snapshot skew: %s\n", m.SnapshotSkew)
+	}
+	fmt.Fprintf(&b, "  %d artifacts:\n", len(m.Artifacts))
+	for _, a := range m.Artifacts {
+		var notes []string
+		if a.Encrypted {
+			notes = append(notes, "encrypted")
+		}
+		if a.Partition != "" {
+			notes = append(notes, "partition "+a.Partition)
+		}
+		if a.Table != "" {
+			notes = append(notes, "table "+a.Table)
+		}
+		if a.Reused {
+			notes = append(notes, "reused")
+		}
+		line := fmt.Sprintf("    %-40s %-7s %-9s %-7s %10s", a.File, a.Database, a.Section, a.Format, formatBytes(a.Size))
+		if len(notes) > 0 {
+			line += "  " + strings.Join(notes, ", ")
+		}
+		b.WriteString(line + "\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestListLocalDumpSets(t *testing.T) {
+	dir := t.TempDir()
+	older := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	for name, created := range map[string]time.Time{"2024-06-01": older, "2024-06-02": older.AddDate(0, 0, 1)} {
+		setDir := filepath.Join(dir, name)
+		os.Mkdir(setDir, 0755)
+		manifest := &Manifest{CreatedAt: created, Artifacts: []ManifestArtifact{
+			{Database: "moodys", Section: "data", File: "moodys_data"},
+			{Database: "tenant", Section: "data", File: "tenant_data", Encrypted: true},
+		}}
+		if err := WriteManifest(setDir, manifest); err != nil {
+			t.Fatal(err)
+		}
+		os.WriteFile(filepath.Join(setDir, "moodys_data"), []byte("archive"), 0644)
+		os.WriteFile(filepath.Join(setDir, "tenant_data"), []byte("archive"), 0644)
+	}
+	os.Mkdir(filepath.Join(dir, "logs"), 0755)
+
+	sets, err := ListLocalDumpSets(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sets) != 2 || sets[0].Name != "2024-06-02" || sets[1].Name != "2024-06-01" {
+		t.Fatalf("listed %+v", sets)
+	}
+	s := sets[0]
+	if s.Files != 3 || len(s.Databases) != 2 || !s.Encrypted || s.Checksums {
+		t.Errorf("summarized %+v", s)
+	}
+}
+
+func TestSetPrefixes(t *testing.T) {
+	objects := []storedObject{
+		{Key: "nightly/manifest.json", Size: 10},
+		{Key: "nightly/tenant_data", Size: 100},
+		{Key: "nightly/2024-06-01/manifest.json", Size: 10},
+		{Key: "nightly/2024-06-01/checksums.json", Size: 5},
+		{Key: "nightly/partial/tenant_data", Size: 100},
+		{Key: "nightly/2024-06-01/deeper/manifest.json", Size: 10},
+	}
+	sets := setPrefixes("nightly", objects)
+	if len(sets) != 2 || len(sets[rootDumpSet]) != 2 || len(sets["2024-06-01"]) != 2 {
+		t.Errorf("grouped %v", sets)
+	}
+}
+
+func TestSelectDumpSet(t *testing.T) {
+	day := time.Date(2024, 6, 1, 12, 0, 0, 0, time.Local)
+	// Newest first, as listed
+	sets := []DumpSetSummary{
+		{Name: "partial", CreatedAt: day.AddDate(0, 0, 2), Missing: []string{"tenant_data"}},
+		{Name: "06-02", CreatedAt: day.AddDate(0, 0, 1)},
+		{Name: "06-01", CreatedAt: day, Labels: []string{"pre-release"}},
+		{Name: "05-31", CreatedAt: day.AddDate(0, 0, -1)},
+	}
+	before, err := parseSetTime("2024-06-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		filter DumpSetFilter
+		want   string
+	}{
+		{DumpSetFilter{}, "06-02"},
+		{DumpSetFilter{Before: before}, "06-01"},
+		{DumpSetFilter{Label: "pre-release"}, "06-01"},
+	} {
+		got, err := selectDumpSet(sets, c.filter)
+		if err != nil || got.Name != c.want {
+			t.Errorf("%+v selected %s, %v; want %s", c.filter, got.Name, err, c.want)
+		}
+	}
+	if _, err := selectDumpSet(sets, DumpSetFilter{Label: "release-1.42"}); err == nil {
+		t.Error("a label no set carries should select nothing")
+	}
+	if _, err := parseSetTime("June 1"); err == nil {
+		t.Error("expected an error for an unparseable date")
+	}
+}
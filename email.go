@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"html/template"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// EmailConfig mails the run report to a distribution list over SMTP when a run ends
+type EmailConfig struct {
+	// Host and Port reach the SMTP server; Port defaults to 587. Port 465 uses implicit
+	// TLS; other ports upgrade with STARTTLS when the server offers it.
+	Host string `json:"host"`
+	Port string `json:"port"`
+	// Username and Password authenticate with PLAIN; Password defaults to SMTP_PASSWORD
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+	// OnlyOnFailure mails only the reports of failed runs
+	OnlyOnFailure bool `json:"only_on_failure"`
+}
+
+func (e *EmailConfig) validate() error {
+	if e.Host == "" || e.From == "" || len(e.To) == 0 {
+		return fmt.Errorf("host, from, and to are required")
+	}
+	for _, addr := range append([]string{e.From}, e.To...) {
+		if strings.ContainsAny(addr, "\r\n") {
+			return fmt.Errorf("invalid address %q", addr)
+		}
+	}
+	return nil
+}
+
+// reportEmailTemplate renders the run report as an HTML summary
+var reportEmailTemplate = template.Must(template.New("report").Parse(`<html><body style="font-family: sans-serif">
+<h2>Run {{.RunID}} {{.Status}}</h2>
+<p>Started {{.StartedAt.Format "2006-01-02 15:04:05 MST"}}, took {{.Duration}}.</p>
+{{if .Error}}<p style="color: #b00"><b>Error:</b> {{.Error}}</p>{{end}}
+<h3>Phases</h3>
+<table border="1" cellpadding="4" cellspacing="0">
+<tr><th>Phase</th><th>Status</th><th>Duration</th></tr>
+{{range .Phases}}<tr><td>{{.Name}}</td><td>{{.Status}}</td><td>{{.Duration}}</td></tr>
+{{end}}</table>
+{{if .Validation}}<h3>Validation</h3>
+<p>{{len .Validation}} tables compared, {{.ValidationProblems}} with mismatches or errors.</p>
+{{if .ValidationDetails}}<table border="1" cellpadding="4" cellspacing="0">
+<tr><th>Table</th><th>Strategy</th><th>Mismatches</th><th>Duration</th><th>Error</th></tr>
+{{range .ValidationDetails}}<tr><td>{{.Table}}</td><td>{{.Strategy}}</td><td>{{.Mismatches}}</td><td>{{.Duration}}</td><td>{{.Error}}</td></tr>
+{{end}}</table>{{end}}{{end}}
+{{if .Warnings}}<h3>Warnings</h3>
+<ul>{{range .Warnings}}<li>{{.}}</li>{{end}}</ul>{{end}}
+{{if .Skipped}}<p>{{len .Skipped}} objects were left out of the restore; see the run report.</p>{{end}}
+<h3>Steps</h3>
+<table border="1" cellpadding="4" cellspacing="0">
+<tr><th>Step</th><th>Status</th><th>Duration</th></tr>
+{{range .Steps}}<tr><td>{{.Name}}</td><td>{{.Status}}</td><td>{{.Duration}}</td></tr>
+{{end}}</table>
+</body></html>
+`))
+
+// renderReportEmail returns the subject and HTML body of a run report's email
+func renderReportEmail(r *RunReport) (string, string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data := struct {
+		*RunReport
+		ValidationProblems int
+		ValidationDetails  []TableValidation
+	}{RunReport: r}
+	for _, v := range r.Validation {
+		if v.Mismatches > 0 || v.Error != "" {
+			data.ValidationProblems++
+			data.ValidationDetails = append(data.ValidationDetails, v)
+		}
+	}
+	var body bytes.Buffer
+	if err := reportEmailTemplate.Execute(&body, data); err != nil {
+		return "", "", fmt.Errorf("failed to render report email: %w", err)
+	}
+	subject := fmt.Sprintf("pg_restore_fdw run %s %s in %s", r.RunID, r.Status, r.Duration)
+	if len(r.Warnings) > 0 {
+		subject += fmt.Sprintf(" (%d warnings)", len(r.Warnings))
+	}
+	return subject, body.String(), nil
+}
+
+// smtpTimeout bounds the whole SMTP exchange, from dialing to QUIT, so an unresponsive
+// server can't hold up the end of a run
+const smtpTimeout = 2 * time.Minute
+
+// emailMessage builds an RFC 5322 message with an HTML body. The body is
+// quoted-printable, which keeps its lines within SMTP's 998-byte limit and its bytes
+// 7-bit whatever the report holds.
+func emailMessage(from string, to []string, subject, html string, date time.Time) []byte {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", strings.NewReplacer("\r", " ", "\n", " ").Replace(subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/html; charset=UTF-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	body := quotedprintable.NewWriter(&msg)
+	body.Write([]byte(html))
+	body.Close()
+	return msg.Bytes()
+}
+
+// SendReportEmail mails the run report's summary to the configured recipients
+func SendReportEmail(config EmailConfig, r *RunReport) error {
+	if config.OnlyOnFailure && r.Status == "succeeded" {
+		return nil
+	}
+	subject, html, err := renderReportEmail(r)
+	if err != nil {
+		return err
+	}
+	if config.Port == "" {
+		config.Port = "587"
+	}
+	if config.Password == "" {
+		config.Password = os.Getenv("SMTP_PASSWORD")
+	}
+	var auth smtp.Auth
+	if config.Username != "" {
+		auth = smtp.PlainAuth("", config.Username, config.Password, config.Host)
+	}
+	addr := net.JoinHostPort(config.Host, config.Port)
+	msg := emailMessage(config.From, config.To, subject, html, time.Now())
+	if err := sendMail(config, auth, msg, smtpTimeout); err != nil {
+		return fmt.Errorf("failed to send report email through %s: %w", addr, err)
+	}
+	alwaysLog.Printf("Run report emailed to %s", strings.Join(config.To, ", "))
+	return nil
+}
+
+// sendMail is smtp.SendMail with a deadline: dialing and the whole exchange must finish
+// within timeout. Port 465 expects TLS from the first byte; other ports upgrade with
+// STARTTLS when the server offers it.
+func sendMail(config EmailConfig, auth smtp.Auth, msg []byte, timeout time.Duration) error {
+	addr := net.JoinHostPort(config.Host, config.Port)
+	deadline := time.Now().Add(timeout)
+	conn, err := (&net.Dialer{Deadline: deadline}).Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return err
+	}
+	implicitTLS := config.Port == "465"
+	if implicitTLS {
+		conn = tls.Client(conn, &tls.Config{ServerName: config.Host})
+	}
+	client, err := smtp.NewClient(conn, config.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if !implicitTLS {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(&tls.Config{ServerName: config.Host}); err != nil {
+				return err
+			}
+		}
+	}
+	if auth != nil {
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(config.From); err != nil {
+		return err
+	}
+	for _, rcpt := range config.To {
+		if err := client.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io"
+	"log"
+	"mime/quotedprintable"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderReportEmail(t *testing.T) {
+	r := NewRunReport("20260101-120000")
+	r.Phases = []PhaseReport{{Name: "restore", Status: "succeeded", Duration: "2m0s"}}
+	r.Steps = []StepReport{{Name: "restore_tenant_data", Status: "succeeded", Duration: "1m30s"}}
+	r.Validation = []TableValidation{
+		{Table: "public.orders", Strategy: "count", Duration: "1s"},
+		{Table: "public.<ledger>", Strategy: "checksum", Mismatches: 1, Duration: "4s"},
+	}
+	r.Warnings = []string{"archive_mode is off"}
+	r.Finish(nil)
+
+	subject, html, err := renderReportEmail(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(subject, "pg_restore_fdw run 20260101-120000 succeeded in ") || !strings.HasSuffix(subject, "(1 warnings)") {
+		t.Errorf("subject = %q", subject)
+	}
+	for _, want := range []string{"restore_tenant_data", "1m30s", "2 tables compared, 1 with mismatches", "public.&lt;ledger&gt;", "archive_mode is off"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("body lacks %q", want)
+		}
+	}
+	if strings.Contains(html, "<td>public.orders</td>") {
+		t.Error("body lists a table without mismatches")
+	}
+}
+
+func TestEmailMessage(t *testing.T) {
+	date := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	msg := string(emailMessage("dba@example.com", []string{"a@example.com", "b@example.com"}, "run\r\nBcc: x", "<p>\nhi</p>", date))
+	for _, want := range []string{
+		"To: a@example.com, b@example.com\r\n",
+		"Subject: run  Bcc: x\r\n",
+		"Date: Thu, 01 Jan 2026 12:00:00 +0000\r\n",
+		"Content-Type: text/html; charset=UTF-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n<p>\r\nhi</p>",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message lacks %q:\n%s", want, msg)
+		}
+	}
+
+	// Long lines are wrapped and 8-bit text is escaped
+	long := strings.Repeat("<td>schéma</td>", 100)
+	msg = string(emailMessage("dba@example.com", []string{"a@example.com"}, "run", long, date))
+	_, body, _ := strings.Cut(msg, "\r\n\r\n")
+	for _, line := range strings.Split(body, "\r\n") {
+		if len(line) > 76 {
+			t.Errorf("body line of %d bytes", len(line))
+		}
+	}
+	decoded, err := io.ReadAll(quotedprintable.NewReader(strings.NewReader(body)))
+	if err != nil || string(decoded) != long {
+		t.Errorf("body decodes to %q, %v", decoded, err)
+	}
+	if err := (&EmailConfig{Host: "smtp", From: "a@example.com"}).validate(); err == nil {
+		t.Error("config without recipients passed validation")
+	}
+}
+
+func TestSendMailTimeout(t *testing.T) {
+	// A server that accepts the connection and never greets
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			io.Copy(io.Discard, conn)
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	config := EmailConfig{Host: host, Port: port, From: "a@example.com", To: []string{"b@example.com"}}
+	start := time.Now()
+	if err := sendMail(config, nil, []byte("hi"), 200*time.Millisecond); err == nil {
+		t.Fatal("sendMail succeeded against a silent server")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("sendMail gave up after %v", elapsed)
+	}
+}
+
+func TestWarningWriter(t *testing.T) {
+	r := NewRunReport("run")
+	logger := log.New(warningWriter{out: io.Discard, report: r}, "", log.LstdFlags)
+	logger.Printf("WARNING: archive_mode is off on db:5432")
+	logger.Printf("Restored 3 tables")
+	logger.Printf("ALERT: dump size grew 80%%")
+	want := []string{"archive_mode is off on db:5432", "dump size grew 80%"}
+	if len(r.Warnings) != 2 || r.Warnings[0] != want[0] || r.Warnings[1] != want[1] {
+		t.Errorf("warnings = %q, want %q", r.Warnings, want)
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Encrypted artifacts are a header followed by AES-256-GCM sealed chunks. Each chunk's
+// nonce is the header's random prefix, a chunk counter, and a final-chunk flag, so
+// reordered, truncated, or extended files fail authentication.
+const (
+	encryptionMagic     = "PGRFENC1"
+	encryptionChunkSize = 64 * 1024
+	encryptionPrefixLen = 7
+	encryptedExt        = ".enc"
+)
+
+// EncryptionConfig selects the key used to encrypt dump artifacts at rest
+type EncryptionConfig struct {
+	// KeyRef locates a 32-byte key: "env:VAR" (base64), "file:/path" (raw or base64),
+	// or "cmd:<command>" printing a base64 key, e.g. a KMS decrypt call
+	KeyRef string `json:"key_ref"`
+	// ScratchDir receives decrypted copies during restore; defaults to the system temp dir
+	ScratchDir string `json:"scratch_dir"`
+}
+
+// Encryptor encrypts and decrypts artifacts with a resolved key
+type Encryptor struct {
+	KeyRef     string
+	ScratchDir string
+	aead       cipher.AEAD
+}
+
+// NewEncryptor resolves the configured key. A nil config disables encryption.
+func NewEncryptor(cfg *EncryptionConfig) (*Encryptor, error) {
+	if cfg == nil || cfg.KeyRef == "" {
+		return nil, nil
+	}
+
+	key, err := resolveKey(cfg.KeyRef)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key from %s must be 32 bytes, got %d", cfg.KeyRef, len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+
+	return &Encryptor{KeyRef: cfg.KeyRef, ScratchDir: cfg.ScratchDir, aead: aead}, nil
+}
+
+// resolveKey loads key material from the environment, a file, or a command
+func resolveKey(ref string) ([]byte, error) {
+	kind, value, ok := strings.Cut(ref, ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid key reference %q, expected env:, file:, or cmd: prefix", ref)
+	}
+
+	var raw []byte
+	switch kind {
+	case "env":
+		v, found := os.LookupEnv(value)
+		if !found {
+			return nil, fmt.Errorf("encryption key variable %s is not set", value)
+		}
+		raw = []byte(v)
+	case "file":
+		content, err := os.ReadFile(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read encryption key file: %w", err)
+		}
+		if len(content) == 32 {
+			return content, nil
+		}
+		raw = content
+	case "cmd":
+		output, err := exec.Command("sh", "-c", value).Output()
+		if err != nil {
+			return nil, fmt.Errorf("encryption key command failed: %w", err)
+		}
+		raw = output
+	default:
+		return nil, fmt.Errorf("unknown key reference type %q", kind)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(raw)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode base64 key from %s: %w", ref, err)
+	}
+	return key, nil
+}
+
+// chunkNonce builds the nonce for a chunk
+func chunkNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 0, 12)
+	nonce = append(nonce, prefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, counter)
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+// encryptWriter seals plaintext in fixed-size chunks as it is written
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	buf     []byte
+}
+
+// NewWriter returns a writer that encrypts everything written to it into w.
+// Close must be called to seal the final chunk.
+func (e *Encryptor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	prefix := make([]byte, encryptionPrefixLen)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce prefix: %w", err)
+	}
+	if _, err := w.Write(append([]byte(encryptionMagic), prefix...)); err != nil {
+		return nil, fmt.Errorf("failed to write encryption header: %w", err)
+	}
+	return &encryptWriter{w: w, aead: e.aead, prefix: prefix}, nil
+}
+
+func (ew *encryptWriter) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		n := min(encryptionChunkSize-len(ew.buf), len(p))
+		ew.buf = append(ew.buf, p[:n]...)
+		p = p[n:]
+		// Hold a full chunk back until more data arrives so Close can mark the real last chunk
+		if len(ew.buf) == encryptionChunkSize && len(p) > 0 {
+			if err := ew.seal(false); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (ew *encryptWriter) seal(last bool) error {
+	sealed := ew.aead.Seal(nil, chunkNonce(ew.prefix, ew.counter, last), ew.buf, nil)
+	if _, err := ew.w.Write(sealed); err != nil {
+		return fmt.Errorf("failed to write encrypted chunk: %w", err)
+	}
+	ew.counter++
+	ew.buf = ew.buf[:0]
+	return nil
+}
+
+func (ew *encryptWriter) Close() error {
+	return ew.seal(true)
+}
+
+// decryptReader authenticates and decrypts chunks as they are read
+type decryptReader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	plain   []byte
+	next    []byte
+	done    bool
+}
+
+// NewReader returns a reader yielding the plaintext of an encrypted stream
+func (e *Encryptor) NewReader(r io.Reader) (io.Reader, error) {
+	header := make([]byte, len(encryptionMagic)+encryptionPrefixLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("failed to read encryption header: %w", err)
+	}
+	if string(header[:len(encryptionMagic)]) != encryptionMagic {
+		return nil, errors.New("not an encrypted artifact")
+	}
+	return &decryptReader{r: r, aead: e.aead, prefix: header[len(encryptionMagic):]}, nil
+}
+
+func (dr *decryptReader) Read(p []byte) (int, error) {
+	for len(dr.plain) == 0 {
+		if dr.done {
+			return 0, io.EOF
+		}
+		if err := dr.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, dr.plain)
+	dr.plain = dr.plain[n:]
+	return n, nil
+}
+
+// open reads and decrypts the next chunk, looking one byte ahead to detect the last chunk
+func (dr *decryptReader) open() error {
+	sealedSize := encryptionChunkSize + dr.aead.Overhead()
+	chunk := make([]byte, sealedSize+1)
+	copy(chunk, dr.next)
+	n, err := io.ReadFull(dr.r, chunk[len(dr.next):])
+	n += len(dr.next)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return fmt.Errorf("failed to read encrypted chunk: %w", err)
+	}
+
+	last := n <= sealedSize
+	if last {
+		chunk = chunk[:n]
+		dr.next = nil
+	} else {
+		dr.next = []byte{chunk[sealedSize]}
+		chunk = chunk[:sealedSize]
+	}
+
+	plain, err := dr.aead.Open(nil, chunkNonce(dr.prefix, dr.counter, last), chunk, nil)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt chunk %d (corrupted, truncated, or wrong key): %w", dr.counter, err)
+	}
+	dr.counter++
+	dr.plain = plain
+	dr.done = last
+	return nil
+}
+
+// DecryptFile writes the plaintext of an encrypted artifact to dst
+func (e *Encryptor) DecryptFile(src, dst string) error {
+	in, err := e.open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create decrypted file: %w", err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return fmt.Errorf("failed to decrypt %s: %w", src, err)
+	}
+	return out.Close()
+}
+
+// open returns the plaintext of an encrypted artifact as it is decrypted
+func (e *Encryptor) open(src string) (io.ReadCloser, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open encrypted artifact: %w", err)
+	}
+	reader, err := e.NewReader(in)
+	if err != nil {
+		in.Close()
+		return nil, fmt.Errorf("failed to decrypt %s: %w", src, err)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{reader, in}, nil
+}
+
+func (e *Encryptor) ext() string { return encryptedExt }
+
+func (e *Encryptor) keyRef() string { return e.KeyRef }
+
+func (e *Encryptor) scratchDir() string { return e.ScratchDir }
+
+func (e *Encryptor) decodeFile(src, dst string) error { return e.DecryptFile(src, dst) }
+
+// encode runs cmd with its stdout encrypted into outputFile
+func (e *Encryptor) encode(cmd *exec.Cmd, outputFile, step string) error {
+	out, err := os.OpenFile(outputFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create encrypted artifact: %w", err)
+	}
+	defer out.Close()
+
+	writer, err := e.NewWriter(out)
+	if err != nil {
+		return err
+	}
+
+	cmd.Stdout = activeThrottle.limit(writer)
+	if output, err := runStreaming(cmd, step, nil); err != nil {
+		log.Printf("Error dumping database section: %s", output)
+		return fmt.Errorf("failed to dump database section: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// isEncrypted reports whether a file starts with the encryption header
+func isEncrypted(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	header := make([]byte, len(encryptionMagic))
+	if _, err := io.ReadFull(f, header); err != nil {
+		return false
+	}
+	return bytes.Equal(header, []byte(encryptionMagic))
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestEncryptor(t *testing.T) *Encryptor {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_DUMP_KEY", base64.StdEncoding.EncodeToString(key))
+
+	enc, err := NewEncryptor(&EncryptionConfig{KeyRef: "env:TEST_DUMP_KEY"})
+	if err != nil {
+		t.Fatalf("NewEncryptor: %v", err)
+	}
+	return enc
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	enc := newTestEncryptor(t)
+
+	for _, size := range []int{0, 10, encryptionChunkSize, encryptionChunkSize*3 + 17} {
+		plain := make([]byte, size)
+		rand.Read(plain)
+
+		var sealed bytes.Buffer
+		w, err := enc.NewWriter(&sealed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(plain)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := enc.NewReader(bytes.NewReader(sealed.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("size %d: decrypt failed: %v", size, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("size %d: round trip mismatch", size)
+		}
+
+		// Dropping the final chunk must be detected
+		if size > encryptionChunkSize {
+			truncated := sealed.Bytes()[:sealed.Len()-(size%encryptionChunkSize)-enc.aead.Overhead()]
+			r, _ := enc.NewReader(bytes.NewReader(truncated))
+			if _, err := io.ReadAll(r); err == nil {
+				t.Errorf("size %d: truncated stream decrypted without error", size)
+			}
+		}
+	}
+}
+
+func TestArtifactStream(t *testing.T) {
+	enc := newTestEncryptor(t)
+	enc.ScratchDir = t.TempDir()
+	dir := t.TempDir()
+
+	plain := []byte("PGDMP archive contents")
+	out, err := os.Create(filepath.Join(dir, "tenant_data.tar"+encryptedExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := enc.NewWriter(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(plain)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	out.Close()
+	os.WriteFile(filepath.Join(dir, "moodys_data.tar"), plain, 0644)
+
+	artifacts := newArtifactResolver(dir, enc)
+	defer artifacts.Cleanup()
+	if _, _, ok := artifacts.Stream("moodys_data.tar"); ok {
+		t.Error("a plaintext artifact was streamed")
+	}
+	input, err := artifacts.Input("tenant_data.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if input.stream == nil || input.path != filepath.Join(dir, "tenant_data.tar"+encryptedExt) {
+		t.Fatalf("Input = %+v, want the stream of the encrypted artifact", input)
+	}
+	format, err := detectStreamFormat(input.path, input.stream)
+	if err != nil || format.Kind != FormatCustom {
+		t.Errorf("detectStreamFormat = %v, %v, want custom", format, err)
+	}
+	r, err := input.stream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Errorf("stream = %q, %v, want %q", got, err, plain)
+	}
+	if entries, _ := os.ReadDir(enc.ScratchDir); len(entries) != 0 {
+		t.Errorf("streaming left %d entries in the scratch directory", len(entries))
+	}
+	if err := checkStreamable(input.path, format, true, false); err == nil {
+		t.Error("a per-table restore was allowed from a stream")
+	}
+	if err := checkStreamable(input.path, ArtifactFormat{Kind: FormatPlain}, false, true); err == nil {
+		t.Error("rewriting a streamed script was allowed")
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// inheritedEnv names the variables, or patterns of them, that the PostgreSQL client
+// commands get from this tool's environment: what they need to run and authenticate,
+// and nothing that changes where or how libpq connects. Ambient PG* variables such as
+// PGHOST, PGSERVICE, or PGOPTIONS are left out, so a connection is made only with what
+// the configuration says. platformEnv adds what the platform's programs need. Hooks and
+// transform commands run the operator's own scripts and inherit everything.
+var inheritedEnv = []string{
+	"PATH", "HOME", "USER", "LOGNAME", "SHELL", "TMPDIR", "TZ", "TERM",
+	"LANG", "LANGUAGE", "LC_*",
+	"KRB5CCNAME", "KRB5_CONFIG", "SSL_CERT_FILE", "SSL_CERT_DIR",
+}
+
+// passEnv holds the configuration's env_passthrough: further variables or patterns
+// the client commands inherit, e.g. PGSSLROOTCERT or PGSERVICEFILE
+var passEnv []string
+
+// validateEnvPassthrough checks the passthrough patterns
+func validateEnvPassthrough(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" || strings.Contains(pattern, "=") {
+			return fmt.Errorf("invalid variable name or pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+// cleanEnv returns the variables of environ that are inherited, followed by extra
+func cleanEnv(environ []string, extra ...string) []string {
+	patterns := append(append(append([]string(nil), inheritedEnv...), platformEnv...), passEnv...)
+	var env []string
+	for _, kv := range environ {
+		name, _, _ := strings.Cut(kv, "=")
+		for _, pattern := range patterns {
+			if matchEnvName(pattern, name, foldEnvNames) {
+				env = append(env, kv)
+				break
+			}
+		}
+	}
+	return append(env, extra...)
+}
+
+// matchEnvName reports whether a variable name matches a pattern, ignoring case when
+// fold is set
+func matchEnvName(pattern, name string, fold bool) bool {
+	if fold {
+		pattern, name = strings.ToUpper(pattern), strings.ToUpper(name)
+	}
+	matched, _ := path.Match(pattern, name)
+	return matched
+}
+
+// subprocessEnv returns the environment for a client command: what it inherits from
+// this tool's environment, followed by the variables the tool sets for it
+func subprocessEnv(extra ...string) []string {
+	return cleanEnv(os.Environ(), extra...)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCleanEnv(t *testing.T) {
+	environ := []string{"PATH=/usr/bin", "LC_ALL=C.UTF-8", "PGHOST=elsewhere", "PGSERVICE=prod", "PGSSLROOTCERT=/etc/ca.pem", "SECRET=x"}
+	got := strings.Join(cleanEnv(environ, "PGPASSWORD=pw"), " ")
+	if got != "PATH=/usr/bin LC_ALL=C.UTF-8 PGPASSWORD=pw" {
+		t.Errorf("got %q", got)
+	}
+	passEnv = []string{"PGSSL*"}
+	defer func() { passEnv = nil }()
+	got = strings.Join(cleanEnv(environ), " ")
+	if got != "PATH=/usr/bin LC_ALL=C.UTF-8 PGSSLROOTCERT=/etc/ca.pem" {
+		t.Errorf("with a passthrough got %q", got)
+	}
+}
+
+func TestMatchEnvName(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		fold, want    bool
+	}{
+		{"PATH", "PATH", false, true},
+		{"PATH", "Path", false, false},
+		{"PATH", "Path", true, true},
+		{"SYSTEMROOT", "SystemRoot", true, true},
+		{"LC_*", "lc_all", true, true},
+		{"PGSSL*", "PGHOST", true, false},
+	}
+	for _, tt := range tests {
+		if got := matchEnvName(tt.pattern, tt.name, tt.fold); got != tt.want {
+			t.Errorf("matchEnvName(%q, %q, %t) = %t, want %t", tt.pattern, tt.name, tt.fold, got, tt.want)
+		}
+	}
+}
+
+func TestValidateEnvPassthrough(t *testing.T) {
+	if err := validateEnvPassthrough([]string{"PGSSLROOTCERT", "MY_*"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for _, bad := range []string{"", "A=B", "[X"} {
+		if validateEnvPassthrough([]string{bad}) == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
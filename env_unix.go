@@ -0,0 +1,9 @@
+//go:build !windows
+
+package main
+
+// platformEnv are further variables the client commands inherit on this platform
+var platformEnv []string
+
+// foldEnvNames is set where variable names are case-insensitive
+const foldEnvNames = false
@@ -0,0 +1,14 @@
+//go:build windows
+
+package main
+
+// platformEnv are the variables Windows programs need: libpq can't load the socket
+// library without SYSTEMROOT, and it finds pgpass.conf under APPDATA
+var platformEnv = []string{
+	"SYSTEMROOT", "SYSTEMDRIVE", "WINDIR", "COMSPEC", "PATHEXT",
+	"APPDATA", "LOCALAPPDATA", "USERPROFILE", "TEMP", "TMP",
+}
+
+// foldEnvNames is set where variable names are case-insensitive; Windows usually
+// spells PATH as Path
+const foldEnvNames = true
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tableStatsQuery reports size, row estimate, and index count of every user table.
+// Foreign tables and materialized views hold no dumped data and are left out.
+const tableStatsQuery = `SELECT n.nspname || '.' || c.relname,
+	pg_table_size(c.oid),
+	pg_indexes_size(c.oid),
+	greatest(c.reltuples, 0)::bigint,
+	(SELECT count(*) FROM pg_index i WHERE i.indrelid = c.oid)
+FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE c.relkind IN ('r', 'p')
+	AND n.nspname NOT IN ('pg_catalog', 'information_schema')
+	AND n.nspname NOT LIKE 'pg_toast%'
+ORDER BY 1;`
+
+// largeObjectQuery reports the number and on-disk size of large objects
+const largeObjectQuery = `SELECT count(*), pg_total_relation_size('pg_catalog.pg_largeobject') FROM pg_largeobject_metadata;`
+
+// EstimateConfig holds the throughput assumptions used when no previous run is available
+type EstimateConfig struct {
+	// DumpMBPerSec is the rate dump files are written at
+	DumpMBPerSec float64 `json:"dump_mb_per_sec"`
+	// RestoreMBPerSec is the rate dump files are loaded at, excluding index builds
+	RestoreMBPerSec float64 `json:"restore_mb_per_sec"`
+	// IndexMBPerSec is the rate indexes are rebuilt at, in index bytes
+	IndexMBPerSec float64 `json:"index_mb_per_sec"`
+	// CompressionRatio is dump bytes per byte of table data
+	CompressionRatio float64 `json:"compression_ratio"`
+}
+
+// withDefaults fills unset assumptions
+func (c EstimateConfig) withDefaults() EstimateConfig {
+	if c.DumpMBPerSec <= 0 {
+		c.DumpMBPerSec = 40
+	}
+	if c.RestoreMBPerSec <= 0 {
+		c.RestoreMBPerSec = 15
+	}
+	if c.IndexMBPerSec <= 0 {
+		c.IndexMBPerSec = 25
+	}
+	if c.CompressionRatio <= 0 {
+		c.CompressionRatio = 0.35
+	}
+	return c
+}
+
+// TableStats describes one source table
+type TableStats struct {
+	Name       string `json:"name"`
+	TableBytes int64  `json:"table_bytes"`
+	IndexBytes int64  `json:"index_bytes"`
+	Rows       int64  `json:"rows"`
+	Indexes    int    `json:"indexes"`
+}
+
+// DatabaseEstimate is the prediction for one source database
+type DatabaseEstimate struct {
+	Database         string       `json:"database"`
+	Tables           []TableStats `json:"tables"`
+	Rows             int64        `json:"rows"`
+	Indexes          int          `json:"indexes"`
+	TableBytes       int64        `json:"table_bytes"`
+	IndexBytes       int64        `json:"index_bytes"`
+	LargeObjects     int64        `json:"large_objects"`
+	LargeObjectBytes int64        `json:"large_object_bytes"`
+	DumpBytes        int64        `json:"dump_bytes"`
+	DumpDuration     string       `json:"dump_duration"`
+	RestoreDuration  string       `json:"restore_duration"`
+	// DestinationBytes is the space the restored database needs
+	DestinationBytes int64 `json:"destination_bytes"`
+}
+
+// Throughput is the rates an estimate was computed with
+type Throughput struct {
+	// Source is "config" or the run ID the rates were measured on
+	Source string `json:"source"`
+	EstimateConfig
+	// RestoreIncludesIndexes is set for measured rates, which already cover index builds
+	RestoreIncludesIndexes bool `json:"restore_includes_indexes"`
+}
+
+// Estimate is the output of the estimate subcommand
+type Estimate struct {
+	CreatedAt  time.Time          `json:"created_at"`
+	Throughput Throughput         `json:"throughput"`
+	Databases  []DatabaseEstimate `json:"databases"`
+	// DumpDirBytes is the space the dump directory needs
+	DumpDirBytes    int64  `json:"dump_dir_bytes"`
+	DumpDuration    string `json:"dump_duration"`
+	RestoreDuration string `json:"restore_duration"`
+}
+
+// parseTableStats parses "name|table_bytes|index_bytes|rows|indexes" rows
+func parseTableStats(output string) ([]TableStats, error) {
+	var tables []TableStats
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, "|")
+		if len(fields) != 5 {
+			return nil, fmt.Errorf("unexpected table statistics row %q", line)
+		}
+		var nums [4]int64
+		for i, f := range fields[1:] {
+			n, err := strconv.ParseInt(f, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("unexpected table statistics row %q: %w", line, err)
+			}
+			nums[i] = n
+		}
+		tables = append(tables, TableStats{
+			Name:       fields[0],
+			TableBytes: nums[0],
+			IndexBytes: nums[1],
+			Rows:       nums[2],
+			Indexes:    int(nums[3]),
+		})
+	}
+	return tables, nil
+}
+
+// secondsAt returns how long moving bytes takes at a rate in MB/s
+func secondsAt(bytes int64, mbPerSec float64) time.Duration {
+	return time.Duration(float64(bytes) / (mbPerSec * 1024 * 1024) * float64(time.Second))
+}
+
+// predict fills in the sizes and durations of a database estimate from its tables
+func (d *DatabaseEstimate) predict(t Throughput) (dump, restore time.Duration) {
+	d.Rows, d.Indexes, d.TableBytes, d.IndexBytes = 0, 0, 0, 0
+	for _, table := range d.Tables {
+		d.Rows += table.Rows
+		d.Indexes += table.Indexes
+		d.TableBytes += table.TableBytes
+		d.IndexBytes += table.IndexBytes
+	}
+	d.DumpBytes = int64(math.Ceil(float64(d.TableBytes+d.LargeObjectBytes) * t.CompressionRatio))
+	d.DestinationBytes = d.TableBytes + d.IndexBytes + d.LargeObjectBytes
+
+	dump = secondsAt(d.DumpBytes, t.DumpMBPerSec)
+	restore = secondsAt(d.DumpBytes, t.RestoreMBPerSec)
+	if !t.RestoreIncludesIndexes {
+		restore += secondsAt(d.IndexBytes, t.IndexMBPerSec)
+	}
+	d.DumpDuration = dump.Round(time.Second).String()
+	d.RestoreDuration = restore.Round(time.Second).String()
+	return dump, restore
+}
+
+// measuredThroughput derives dump and restore rates from the run that produced the dump
+// set in dir: the size of its artifacts over the duration of its dump and restore phases
+func measuredThroughput(dir string, assumed EstimateConfig) (Throughput, bool) {
+	manifest, err := LoadManifest(dir)
+	if err != nil {
+		return Throughput{}, false
+	}
+	report, err := latestReport(dir)
+	if err != nil || report.StartedAt.After(manifest.CreatedAt) {
+		return Throughput{}, false
+	}
+
+	var bytes int64
+	for _, a := range manifest.Artifacts {
+		bytes += a.Size
+	}
+	rates := make(map[string]float64)
+	for _, phase := range report.Phases {
+		if phase.Name != "dump" && phase.Name != "restore" || phase.Status != "succeeded" {
+			continue
+		}
+		d, err := time.ParseDuration(phase.Duration)
+		if err != nil || d <= 0 {
+			continue
+		}
+		rates[phase.Name] = float64(bytes) / (1024 * 1024) / d.Seconds()
+	}
+	if bytes == 0 || rates["dump"] == 0 || rates["restore"] == 0 {
+		return Throughput{}, false
+	}
+
+	assumed.DumpMBPerSec = rates["dump"]
+	assumed.RestoreMBPerSec = rates["restore"]
+	return Throughput{Source: report.RunID, EstimateConfig: assumed, RestoreIncludesIndexes: true}, true
+}
+
+// inspectDatabase collects table and large object statistics of a source database
+func inspectDatabase(config DBConfig) (DatabaseEstimate, error) {
+	est := DatabaseEstimate{Database: config.DBName}
+	output, err := newPsqlCmd(config, "-t", "-A", "-F", "|", "-v", "ON_ERROR_STOP=1", "-c", tableStatsQuery).Output()
+	if err != nil {
+		return est, fmt.Errorf("failed to inspect tables of %s: %w, output: %s", config.DBName, err, output)
+	}
+	if est.Tables, err = parseTableStats(string(output)); err != nil {
+		return est, err
+	}
+
+	output, err = newPsqlCmd(config, "-t", "-A", "-F", "|", "-v", "ON_ERROR_STOP=1", "-c", largeObjectQuery).Output()
+	if err != nil {
+		return est, fmt.Errorf("failed to inspect large objects of %s: %w, output: %s", config.DBName, err, output)
+	}
+	fields := strings.Split(strings.TrimSpace(string(output)), "|")
+	if len(fields) == 2 {
+		est.LargeObjects, _ = strconv.ParseInt(fields[0], 10, 64)
+		est.LargeObjectBytes, _ = strconv.ParseInt(fields[1], 10, 64)
+	}
+	if est.LargeObjects == 0 {
+		est.LargeObjectBytes = 0
+	}
+	return est, nil
+}
+
+// EstimateRun inspects the source databases and predicts the dump size, durations, and
+// disk space of a full run. Rates come from the run that produced the dump set in dir
+// when there is one, and from the configured assumptions otherwise.
+func EstimateRun(sources []DBConfig, dir string, assumed EstimateConfig) (*Estimate, error) {
+	assumed = assumed.withDefaults()
+	throughput, ok := measuredThroughput(dir, assumed)
+	if !ok {
+		throughput = Throughput{Source: "config", EstimateConfig: assumed}
+	}
+
+	est := &Estimate{CreatedAt: time.Now().UTC(), Throughput: throughput}
+	var dump, restore time.Duration
+	for _, config := range sources {
+		db, err := inspectDatabase(config)
+		if err != nil {
+			return nil, err
+		}
+		d, r := db.predict(throughput)
+		dump += d
+		restore += r
+		est.DumpDirBytes += db.DumpBytes
+		est.Databases = append(est.Databases, db)
+	}
+	est.DumpDuration = dump.Round(time.Second).String()
+	est.RestoreDuration = restore.Round(time.Second).String()
+	return est, nil
+}
+
+// Save writes the estimate to dir/estimate_<timestamp>.json and returns its path
+func (e *Estimate) Save(dir string) (string, error) {
+	content, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode estimate: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create dump directory: %w", err)
+	}
+	path := filepath.Join(dir, fmt.Sprintf("estimate_%s.json", e.CreatedAt.Format("20060102-150405")))
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		return "", fmt.Errorf("failed to write estimate: %w", err)
+	}
+	return path, nil
+}
+
+// formatBytes renders a byte count with a binary unit
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTableStats(t *testing.T) {
+	tables, err := parseTableStats("public.companies|104857600|20971520|1000000|2\npublic.empty|0|8192|0|1\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tables) != 2 || tables[0].Name != "public.companies" || tables[0].Rows != 1000000 || tables[1].Indexes != 1 {
+		t.Errorf("unexpected tables: %+v", tables)
+	}
+	if _, err := parseTableStats("public.broken|x|0|0|0"); err == nil {
+		t.Error("expected an error for a non-numeric size")
+	}
+}
+
+func TestPredict(t *testing.T) {
+	const mb = 1024 * 1024
+	db := DatabaseEstimate{Tables: []TableStats{
+		{TableBytes: 800 * mb, IndexBytes: 200 * mb, Rows: 10, Indexes: 1},
+		{TableBytes: 200 * mb, IndexBytes: 0, Rows: 5},
+	}}
+	assumed := Throughput{EstimateConfig: EstimateConfig{
+		DumpMBPerSec: 10, RestoreMBPerSec: 5, IndexMBPerSec: 20, CompressionRatio: 0.5,
+	}}
+
+	dump, restore := db.predict(assumed)
+	if db.DumpBytes != 500*mb || db.DestinationBytes != 1200*mb || db.Rows != 15 {
+		t.Errorf("unexpected sizes: %+v", db)
+	}
+	if dump != 50*time.Second || restore != 110*time.Second {
+		t.Errorf("dump %v restore %v, want 50s and 1m50s", dump, restore)
+	}
+
+	assumed.RestoreIncludesIndexes = true
+	if _, restore = db.predict(assumed); restore != 100*time.Second {
+		t.Errorf("restore with measured rates = %v, want 1m40s", restore)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	for n, want := range map[int64]string{512: "512 B", 1536: "1.5 KiB", 5 << 30: "5.0 GiB"} {
+		if got := formatBytes(n); got != want {
+			t.Errorf("formatBytes(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// mainDestination names dest_moodys and dest_tenant among fan-out destinations
+const mainDestination = "main"
+
+// defaultFanOutConcurrency is how many destinations restore at once by default
+const defaultFanOutConcurrency = 2
+
+// FanOutConfig restores the dump set to further destination clusters alongside
+// dest_moodys and dest_tenant, e.g. to refresh staging, QA, and perf together
+type FanOutConfig struct {
+	// Concurrency is how many destinations restore at once, the main one included
+	Concurrency  int                 `json:"concurrency"`
+	Destinations []FanOutDestination `json:"destinations"`
+}
+
+// FanOutDestination is one more destination, given as overrides of dest_moodys and
+// dest_tenant
+type FanOutDestination struct {
+	Name   string             `json:"name"`
+	Moodys ConnectionSettings `json:"moodys"`
+	Tenant ConnectionSettings `json:"tenant"`
+	// FDWTarget points the tenant's foreign server somewhere other than this
+	// destination's moodys; it replaces the top-level fdw_target
+	FDWTarget *FDWTarget `json:"fdw_target"`
+}
+
+func (c *FanOutConfig) validate() error {
+	if len(c.Destinations) == 0 {
+		return fmt.Errorf("list at least one destination")
+	}
+	if c.Concurrency < 0 {
+		return fmt.Errorf("concurrency must not be negative")
+	}
+	seen := map[string]bool{mainDestination: true}
+	for _, d := range c.Destinations {
+		if d.Name == "" {
+			return fmt.Errorf("destinations need a name")
+		}
+		if seen[d.Name] {
+			return fmt.Errorf("destination %q is listed twice or reuses the name %q of dest_moodys and dest_tenant", d.Name, mainDestination)
+		}
+		seen[d.Name] = true
+	}
+	return nil
+}
+
+// fanOutTarget is one destination of a fan-out restore
+type fanOutTarget struct {
+	name           string
+	moodys, tenant DBConfig
+	fdwTarget      *FDWTarget
+}
+
+// targets returns the main destination followed by the configured ones, each layered
+// over the main destination's connections
+func (c *FanOutConfig) targets(destMoodys, destTenant DBConfig, fdwTarget *FDWTarget) []fanOutTarget {
+	targets := []fanOutTarget{{name: mainDestination, moodys: destMoodys, tenant: destTenant, fdwTarget: fdwTarget}}
+	for _, d := range c.Destinations {
+		t := fanOutTarget{name: d.Name, moodys: destMoodys, tenant: destTenant, fdwTarget: fdwTarget}
+		d.Moodys.apply(&t.moodys)
+		d.Tenant.apply(&t.tenant)
+		if d.FDWTarget != nil {
+			t.fdwTarget = d.FDWTarget
+		}
+		targets = append(targets, t)
+	}
+	return targets
+}
+
+// fanOutConfigs returns the destination databases of every target, for run locks
+func fanOutConfigs(targets []fanOutTarget) []DBConfig {
+	var configs []DBConfig
+	for _, t := range targets {
+		configs = append(configs, t.moodys, t.tenant)
+	}
+	return configs
+}
+
+// DestinationReport records the restore to one destination of a fan-out
+type DestinationReport struct {
+	Name     string `json:"name"`
+	Moodys   string `json:"moodys"`
+	Tenant   string `json:"tenant"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// RestoreFanOut restores the dump set in dir to every target, up to concurrency at
+// once. Each target gets its own foreign server rewrites, pointing the tenant at its
+// own moodys. A failed destination doesn't stop the others; the error lists them all.
+func RestoreFanOut(targets []fanOutTarget, srcMoodys, srcTenant DBConfig, dir string, opts RestoreOptions, concurrency int) error {
+	if concurrency == 0 {
+		concurrency = defaultFanOutConcurrency
+	}
+	errs := make([]error, len(targets))
+	runConcurrently(len(targets), concurrency, func(i int) error {
+		t := targets[i]
+		targetOpts := opts
+		targetOpts.FDWTarget = t.fdwTarget
+		log.Printf("Restoring to destination %s (%s, %s)", t.name, describeDB(t.moodys), describeDB(t.tenant))
+		start := time.Now()
+		errs[i] = RestoreWorkflowWithOptions(srcMoodys, srcTenant, t.moodys, t.tenant, dir, targetOpts)
+		record := DestinationReport{Name: t.name, Moodys: describeDB(t.moodys), Tenant: describeDB(t.tenant),
+			Status: "succeeded", Duration: time.Since(start).Round(time.Second).String()}
+		if errs[i] != nil {
+			record.Status, record.Error = "failed", errs[i].Error()
+			log.Printf("Restore to destination %s failed: %v", t.name, errs[i])
+		} else {
+			log.Printf("Restored to destination %s in %s", t.name, record.Duration)
+		}
+		activeReport.recordDestination(record)
+		return nil
+	})
+	var failed []string
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", targets[i].name, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d destinations failed: %s", len(failed), len(targets), strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// describeDB identifies a database for logs and reports
+func describeDB(config DBConfig) string {
+	return fmt.Sprintf("%s:%s/%s", config.Host, config.Port, config.DBName)
+}
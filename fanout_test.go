@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestFanOutTargets(t *testing.T) {
+	c := &FanOutConfig{Destinations: []FanOutDestination{
+		{Name: "qa", Moodys: ConnectionSettings{Host: "qa-db"}, Tenant: ConnectionSettings{Host: "qa-db"}},
+		{Name: "perf", Moodys: ConnectionSettings{Host: "perf-db", Port: "6432"}, FDWTarget: &FDWTarget{Host: "perf-replica"}},
+	}}
+	if err := c.validate(); err != nil {
+		t.Fatal(err)
+	}
+	destMoodys := DBConfig{Host: "staging-db", Port: "5432", DBName: "moodys_dest"}
+	destTenant := DBConfig{Host: "staging-db", Port: "5432", DBName: "tenant_dest"}
+	targets := c.targets(destMoodys, destTenant, nil)
+	if len(targets) != 3 || targets[0].name != mainDestination || targets[0].moodys != destMoodys {
+		t.Fatalf("targets %+v", targets)
+	}
+	if qa := targets[1]; qa.moodys.Host != "qa-db" || qa.tenant.Host != "qa-db" || qa.moodys.DBName != "moodys_dest" || qa.fdwTarget != nil {
+		t.Errorf("qa %+v", qa)
+	}
+	if perf := targets[2]; describeDB(perf.moodys) != "perf-db:6432/moodys_dest" || perf.tenant.Host != "staging-db" || perf.fdwTarget == nil {
+		t.Errorf("perf %+v", perf)
+	}
+	if got := len(fanOutConfigs(targets[1:])); got != 4 {
+		t.Errorf("%d configs to lock, want 4", got)
+	}
+
+	for _, bad := range []FanOutConfig{
+		{},
+		{Destinations: []FanOutDestination{{Name: "main"}}},
+		{Destinations: []FanOutDestination{{Name: "qa"}, {Name: "qa"}}},
+		{Destinations: []FanOutDestination{{}}},
+	} {
+		if err := bad.validate(); err == nil {
+			t.Errorf("%+v should be invalid", bad)
+		}
+	}
+}
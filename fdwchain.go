@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// foreignServerQuery lists postgres_fdw servers with their options as name|key=value,...
+const foreignServerQuery = `SELECT s.srvname, array_to_string(s.srvoptions, ',')
+FROM pg_foreign_server s JOIN pg_foreign_data_wrapper w ON w.oid = s.srvfdw
+WHERE w.fdwname = 'postgres_fdw'
+ORDER BY 1;`
+
+// userMappingQuery lists user mappings of postgres_fdw servers as server|local user|key=value,...
+// Options are only visible to superusers and the mapping's user.
+const userMappingQuery = `SELECT m.srvname, m.usename, coalesce(array_to_string(m.umoptions, ','), '')
+FROM pg_user_mappings m JOIN pg_foreign_server s ON s.oid = m.srvid
+	JOIN pg_foreign_data_wrapper w ON w.oid = s.srvfdw
+WHERE w.fdwname = 'postgres_fdw'
+ORDER BY 1, 2;`
+
+// foreignTableCountQuery counts foreign tables per server as server|count
+const foreignTableCountQuery = `SELECT s.srvname, count(*)
+FROM pg_foreign_table ft JOIN pg_foreign_server s ON s.oid = ft.ftserver
+GROUP BY 1 ORDER BY 1;`
+
+// ForeignServer is a postgres_fdw server and the connection options it was created with
+type ForeignServer struct {
+	Name    string
+	Options map[string]string
+	// Mappings are the server's user mappings by local user
+	Mappings map[string]map[string]string
+	// ForeignTables counts the foreign tables using the server
+	ForeignTables int
+}
+
+// parseOptionList parses a key=value,... option list
+func parseOptionList(list string) map[string]string {
+	options := make(map[string]string)
+	for _, opt := range strings.Split(list, ",") {
+		if key, value, ok := strings.Cut(opt, "="); ok {
+			options[key] = value
+		}
+	}
+	return options
+}
+
+// parseForeignServers parses foreignServerQuery output
+func parseForeignServers(output string) []ForeignServer {
+	var servers []ForeignServer
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		name, options, ok := strings.Cut(line, "|")
+		if !ok || name == "" {
+			continue
+		}
+		servers = append(servers, ForeignServer{Name: name, Options: parseOptionList(options)})
+	}
+	return servers
+}
+
+// catalogRows runs a catalog query and returns its |-separated rows
+func catalogRows(config DBConfig, query string) ([][]string, error) {
+	output, err := newPsqlCmd(config, "-t", "-A", "-F", "|", "-v", "ON_ERROR_STOP=1", "-c", query).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the catalog of %s: %w, output: %s", config.DBName, err, output)
+	}
+	var rows [][]string
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if line != "" {
+			rows = append(rows, strings.Split(line, "|"))
+		}
+	}
+	return rows, nil
+}
+
+// listForeignServers returns the postgres_fdw servers of a database with their user
+// mappings and foreign table counts
+func listForeignServers(config DBConfig) ([]ForeignServer, error) {
+	output, err := newPsqlCmd(config, "-t", "-A", "-F", "|", "-v", "ON_ERROR_STOP=1", "-c", foreignServerQuery).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list foreign servers in %s: %w, output: %s", config.DBName, err, output)
+	}
+	servers := parseForeignServers(string(output))
+	byName := make(map[string]*ForeignServer, len(servers))
+	for i := range servers {
+		servers[i].Mappings = make(map[string]map[string]string)
+		byName[servers[i].Name] = &servers[i]
+	}
+
+	mappings, err := catalogRows(config, userMappingQuery)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range mappings {
+		if server, ok := byName[row[0]]; ok && len(row) == 3 {
+			server.Mappings[row[1]] = parseOptionList(row[2])
+		}
+	}
+
+	counts, err := catalogRows(config, foreignTableCountQuery)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range counts {
+		if server, ok := byName[row[0]]; ok && len(row) == 2 {
+			server.ForeignTables, _ = strconv.Atoi(row[1])
+		}
+	}
+	return servers, nil
+}
+
+// serverTarget returns the workflow database a foreign server of from points at. Unset
+// host and port default to from's, as libpq would resolve them on that server.
+func serverTarget(server ForeignServer, from DBConfig, databases map[string]WorkflowDatabase) (string, bool) {
+	host, port := server.Options["host"], server.Options["port"]
+	if host == "" {
+		host = from.Host
+	}
+	if port == "" {
+		port = from.Port
+	}
+	dbname := server.Options["dbname"]
+	if dbname == "" {
+		dbname = from.DBName
+	}
+	for name, db := range databases {
+		if db.Source.DBName == dbname && db.Source.Port == port && sameHost(db.Source.Host, host) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// sameHost compares host names, treating the loopback spellings as one
+func sameHost(a, b string) bool {
+	loopback := func(h string) string {
+		if h == "127.0.0.1" || h == "::1" || h == "" {
+			return "localhost"
+		}
+		return h
+	}
+	return loopback(a) == loopback(b)
+}
+
+// DiscoverFDWDependencies scans each database's foreign servers and returns the workflow
+// databases each one points at. Servers pointing outside the workflow are left alone,
+// and fail discovery when they can't be reached.
+func DiscoverFDWDependencies(databases map[string]WorkflowDatabase) (map[string][]string, error) {
+	plan, err := PlanFDWRemaps(databases)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkFDWPlan(plan); err != nil {
+		return nil, err
+	}
+	deps := make(map[string][]string)
+	for _, r := range plan {
+		if r.Status == RemapTarget && r.Target != r.Database {
+			deps[r.Database] = appendUnique(deps[r.Database], r.Target)
+		}
+	}
+	return deps, nil
+}
+
+func sortedDatabaseNames(databases map[string]WorkflowDatabase) []string {
+	names := make([]string, 0, len(databases))
+	for name := range databases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+// chainSteps builds a workflow that dumps every database, then restores each one after
+// the databases its foreign servers point at, and validates it
+func chainSteps(databases map[string]WorkflowDatabase, deps map[string][]string) ([]WorkflowStep, error) {
+	names := sortedDatabaseNames(databases)
+	ordered, err := topoSort(names, deps)
+	if err != nil {
+		return nil, fmt.Errorf("foreign servers reference each other in a circle, so no database can be restored first: %w", err)
+	}
+
+	var steps []WorkflowStep
+	for _, name := range ordered {
+		steps = append(steps, WorkflowStep{Name: "dump_" + name, Action: StepDump, Database: name})
+	}
+	for _, name := range ordered {
+		restoreDeps := []string{"dump_" + name}
+		for _, dep := range deps[name] {
+			restoreDeps = append(restoreDeps, "restore_"+dep)
+		}
+		steps = append(steps,
+			WorkflowStep{Name: "restore_" + name, Action: StepRestore, Database: name, DependsOn: restoreDeps},
+			WorkflowStep{Name: "validate_" + name, Action: StepValidate, Database: name, DependsOn: []string{"restore_" + name}},
+		)
+	}
+	return steps, nil
+}
+
+// DiscoverWorkflow fills in a workflow without steps: foreign server targets are found by
+// scanning each database, and restores are ordered so every FDW chain is restored from
+// the far end inwards
+func DiscoverWorkflow(config WorkflowConfig, builtin map[string]WorkflowDatabase) (WorkflowConfig, error) {
+	databases := make(map[string]WorkflowDatabase)
+	for name, db := range builtin {
+		databases[name] = db
+	}
+	for name, db := range config.Databases {
+		databases[name] = db
+	}
+
+	deps, err := DiscoverFDWDependencies(databases)
+	if err != nil {
+		return config, err
+	}
+	for name, targets := range deps {
+		db := databases[name]
+		for _, target := range targets {
+			db.ForeignServers = appendUnique(db.ForeignServers, target)
+		}
+		databases[name] = db
+	}
+
+	if config.Steps, err = chainSteps(databases, deps); err != nil {
+		return config, err
+	}
+	config.Databases = databases
+	return config, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseForeignServers(t *testing.T) {
+	servers := parseForeignServers("moodys_server|host=localhost,port=5432,dbname=moodys\nref|dbname=reference\n")
+	want := []ForeignServer{
+		{Name: "moodys_server", Options: map[string]string{"host": "localhost", "port": "5432", "dbname": "moodys"}},
+		{Name: "ref", Options: map[string]string{"dbname": "reference"}},
+	}
+	if !reflect.DeepEqual(servers, want) {
+		t.Errorf("got %+v, want %+v", servers, want)
+	}
+}
+
+func TestServerTarget(t *testing.T) {
+	databases := map[string]WorkflowDatabase{
+		"shared":    {Source: DBConfig{Host: "localhost", Port: "5432", DBName: "shared"}},
+		"reference": {Source: DBConfig{Host: "ref-db", Port: "5432", DBName: "reference"}},
+	}
+	from := DBConfig{Host: "127.0.0.1", Port: "5432", DBName: "tenant"}
+
+	if got, ok := serverTarget(ForeignServer{Options: map[string]string{"dbname": "shared"}}, from, databases); !ok || got != "shared" {
+		t.Errorf("defaulted host and port: got %q, %v", got, ok)
+	}
+	if got, ok := serverTarget(ForeignServer{Options: map[string]string{"host": "ref-db", "dbname": "reference"}}, from, databases); !ok || got != "reference" {
+		t.Errorf("explicit host: got %q, %v", got, ok)
+	}
+	if _, ok := serverTarget(ForeignServer{Options: map[string]string{"host": "other", "dbname": "reference"}}, from, databases); ok {
+		t.Error("matched a server on another host")
+	}
+}
+
+func TestChainSteps(t *testing.T) {
+	databases := map[string]WorkflowDatabase{"tenant": {}, "shared": {}, "reference": {}}
+	deps := map[string][]string{"tenant": {"shared"}, "shared": {"reference"}}
+
+	steps, err := chainSteps(databases, deps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := make(map[string]WorkflowStep)
+	var restores []string
+	for _, s := range steps {
+		byName[s.Name] = s
+		if s.Action == StepRestore {
+			restores = append(restores, s.Database)
+		}
+	}
+	if !reflect.DeepEqual(restores, []string{"reference", "shared", "tenant"}) {
+		t.Errorf("restore order = %v", restores)
+	}
+	if got := byName["restore_tenant"].DependsOn; !reflect.DeepEqual(got, []string{"dump_tenant", "restore_shared"}) {
+		t.Errorf("restore_tenant depends on %v", got)
+	}
+
+	deps["reference"] = []string{"tenant"}
+	if _, err := chainSteps(databases, deps); err == nil || !strings.Contains(err.Error(), "reference, shared, tenant") {
+		t.Errorf("expected a cycle naming all three databases, got %v", err)
+	}
+}
+
+func TestServerProbeTarget(t *testing.T) {
+	server := ForeignServer{
+		Options: map[string]string{"host": "ledger-db", "dbname": "ledger", "sslmode": "require"},
+		Mappings: map[string]map[string]string{
+			"public":   {"user": "reader", "password": "r"},
+			"postgres": {"user": "ledger_fdw", "password": "secret"},
+		},
+	}
+	from := DBConfig{Host: "localhost", Port: "5432", User: "postgres", DBName: "tenant"}
+
+	got := serverProbeTarget(server, from)
+	want := FDWTarget{Host: "ledger-db", Port: "5432", DBName: "ledger", User: "ledger_fdw", Password: "secret", SSLMode: "require"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	from.User = "app"
+	if got := serverProbeTarget(server, from); got.User != "reader" {
+		t.Errorf("expected the public mapping, got user %q", got.User)
+	}
+}
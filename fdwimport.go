@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// ImportForeignSchemaConfig rebuilds the tenant's foreign tables with IMPORT FOREIGN
+// SCHEMA against the restored server instead of replaying the dumped definitions, which
+// go stale when the remote tables change
+type ImportForeignSchemaConfig struct {
+	// Servers whose foreign tables are imported; defaults to the moodys server
+	Servers []string `json:"servers"`
+	// Include limits the import to these tables, as schema.table or table; empty imports all
+	Include []string `json:"include"`
+	// Exclude keeps the dumped definitions of these tables
+	Exclude []string `json:"exclude"`
+}
+
+func (c *ImportForeignSchemaConfig) validate() error {
+	for _, name := range append(append([]string(nil), c.Include...), c.Exclude...) {
+		if name == "" || strings.Count(name, ".") > 1 {
+			return fmt.Errorf("invalid table %q: use schema.table or table", name)
+		}
+	}
+	return nil
+}
+
+// servers returns the servers to import from
+func (c *ImportForeignSchemaConfig) servers() []string {
+	if len(c.Servers) == 0 {
+		return []string{moodysServerName}
+	}
+	return c.Servers
+}
+
+// selects reports whether a foreign table is imported rather than restored as dumped
+func (c *ImportForeignSchemaConfig) selects(schema, table string) bool {
+	matches := func(names []string) bool {
+		for _, name := range names {
+			if name == table || name == schema+"."+table {
+				return true
+			}
+		}
+		return false
+	}
+	if len(c.Include) > 0 && !matches(c.Include) {
+		return false
+	}
+	return !matches(c.Exclude)
+}
+
+// dumpedIdent matches any identifier as pg_dump writes it, quoted only when needed
+const dumpedIdent = `(?:"(?:[^"]|"")*"|[^\s."(]+)`
+
+// foreignTableRe matches a CREATE FOREIGN TABLE statement in a pg_dump script, capturing
+// the qualified name, the server, and the table options
+var foreignTableRe = regexp.MustCompile(`(?ms)^CREATE FOREIGN TABLE (` + dumpedIdent + `\.` + dumpedIdent + `) \(\n.*?^\)\nSERVER (` + dumpedIdent + `)(?:\nOPTIONS \(\n(.*?)\n\))?;\n`)
+
+// dumpedIdentRe matches the parts of a qualified name
+var dumpedIdentRe = regexp.MustCompile(dumpedIdent)
+
+// dumpOptionRe matches one key 'value' pair of a dumped OPTIONS list
+var dumpOptionRe = regexp.MustCompile(`(\w+) '((?:[^']|'')*)'`)
+
+// unquoteIdent undoes pg_dump's identifier quoting
+func unquoteIdent(ident string) string {
+	if len(ident) >= 2 && strings.HasPrefix(ident, `"`) && strings.HasSuffix(ident, `"`) {
+		return strings.ReplaceAll(ident[1:len(ident)-1], `""`, `"`)
+	}
+	return ident
+}
+
+// splitDumpedName splits a qualified name from a pg_dump script into schema and name
+func splitDumpedName(qualified string) (string, string) {
+	parts := dumpedIdentRe.FindAllString(qualified, 2)
+	if len(parts) != 2 {
+		return "", unquoteIdent(qualified)
+	}
+	return unquoteIdent(parts[0]), unquoteIdent(parts[1])
+}
+
+// importForeignSchema replaces the selected CREATE FOREIGN TABLE statements of a pre-data
+// script with IMPORT FOREIGN SCHEMA ... LIMIT TO statements, one per local and remote
+// schema pair, placed where the group's first table was created. Tables whose local
+// name differs from the remote one keep their dumped definitions, since IMPORT names
+// tables after the remote ones. It returns the script and the imported tables.
+func importForeignSchema(script string, c *ImportForeignSchemaConfig) (string, []string) {
+	type group struct {
+		server, localSchema, remoteSchema string
+		tables                            []string
+	}
+	byKey := make(map[string]*group)
+	var imported []string
+
+	matches := foreignTableRe.FindAllStringSubmatchIndex(script, -1)
+	replacements := make([]string, len(matches))
+	first := make(map[int]*group)
+	for i, m := range matches {
+		replacements[i] = script[m[0]:m[1]]
+		server := script[m[4]:m[5]]
+		if !includesDatabase(c.servers(), unquoteIdent(server)) {
+			continue
+		}
+		schema, table := splitDumpedName(script[m[2]:m[3]])
+		if !c.selects(schema, table) {
+			continue
+		}
+		remoteSchema, remoteTable := schema, table
+		if m[6] >= 0 {
+			for _, opt := range dumpOptionRe.FindAllStringSubmatch(script[m[6]:m[7]], -1) {
+				value := strings.ReplaceAll(opt[2], "''", "'")
+				switch opt[1] {
+				case "schema_name":
+					remoteSchema = value
+				case "table_name":
+					remoteTable = value
+				}
+			}
+		}
+		if remoteTable != table {
+			log.Printf("Keeping the dumped definition of %s.%s: it reads remote table %s, and IMPORT FOREIGN SCHEMA would name it after that", schema, table, remoteTable)
+			continue
+		}
+
+		key := server + "\x00" + schema + "\x00" + remoteSchema
+		g, ok := byKey[key]
+		if !ok {
+			g = &group{server: server, localSchema: schema, remoteSchema: remoteSchema}
+			byKey[key] = g
+			first[i] = g
+		}
+		g.tables = append(g.tables, table)
+		imported = append(imported, schema+"."+table)
+		replacements[i] = ""
+	}
+
+	for i, g := range first {
+		quoted := make([]string, len(g.tables))
+		for j, table := range g.tables {
+			quoted[j] = quoteIdent(table)
+		}
+		// The server keeps its dumped spelling so later server renames still match it
+		replacements[i] = fmt.Sprintf("IMPORT FOREIGN SCHEMA %s LIMIT TO (%s) FROM SERVER %s INTO %s;\n",
+			quoteIdent(g.remoteSchema), strings.Join(quoted, ", "), g.server, quoteIdent(g.localSchema))
+	}
+
+	var b strings.Builder
+	last := 0
+	for i, m := range matches {
+		b.WriteString(script[last:m[0]])
+		b.WriteString(replacements[i])
+		last = m[1]
+	}
+	b.WriteString(script[last:])
+	return b.String(), imported
+}
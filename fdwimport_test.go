@@ -0,0 +1,56 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const foreignTablesScript = `CREATE FOREIGN TABLE public.rates (
+    id integer,
+    rate numeric
+)
+SERVER moodys_server
+OPTIONS (
+    schema_name 'ref',
+    table_name 'rates'
+);
+CREATE FOREIGN TABLE public."Issuers" (
+    id integer
+)
+SERVER moodys_server
+OPTIONS (
+    schema_name 'ref'
+);
+CREATE FOREIGN TABLE public.fx (
+    id integer
+)
+SERVER moodys_server
+OPTIONS (
+    table_name 'fx_rates'
+);
+CREATE FOREIGN TABLE public.local_files (
+    line text
+)
+SERVER files;
+`
+
+func TestImportForeignSchema(t *testing.T) {
+	script, imported := importForeignSchema(foreignTablesScript, &ImportForeignSchemaConfig{})
+	if want := []string{"public.rates", "public.Issuers"}; !reflect.DeepEqual(imported, want) {
+		t.Errorf("imported = %q, want %q", imported, want)
+	}
+	if !strings.HasPrefix(script, `IMPORT FOREIGN SCHEMA "ref" LIMIT TO ("rates", "Issuers") FROM SERVER moodys_server INTO "public";`+"\n") {
+		t.Errorf("script doesn't start with the import:\n%s", script)
+	}
+	for _, kept := range []string{"CREATE FOREIGN TABLE public.fx", "CREATE FOREIGN TABLE public.local_files"} {
+		if !strings.Contains(script, kept) {
+			t.Errorf("script lost %s", kept)
+		}
+	}
+
+	_, imported = importForeignSchema(foreignTablesScript, &ImportForeignSchemaConfig{Exclude: []string{"Issuers"}})
+	if want := []string{"public.rates"}; !reflect.DeepEqual(imported, want) {
+		t.Errorf("with exclude, imported = %q, want %q", imported, want)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// foreignTableQuery lists the foreign tables of postgres_fdw servers with their options
+const foreignTableQuery = `SELECT n.nspname, c.relname, s.srvname, coalesce(array_to_string(ft.ftoptions, ','), '')
+FROM pg_foreign_table ft JOIN pg_class c ON c.oid = ft.ftrelid
+	JOIN pg_namespace n ON n.oid = c.relnamespace
+	JOIN pg_foreign_server s ON s.oid = ft.ftserver
+	JOIN pg_foreign_data_wrapper w ON w.oid = s.srvfdw
+WHERE w.fdwname = 'postgres_fdw'
+ORDER BY 3, 1, 2;`
+
+// redacted replaces secret option values in an inventory
+const redacted = "********"
+
+// FDWInventory is what fdw inspect reports about one database
+type FDWInventory struct {
+	Database string            `json:"database"`
+	Servers  []InspectedServer `json:"servers"`
+}
+
+// InspectedServer is a foreign server with its mappings and tables
+type InspectedServer struct {
+	Name          string             `json:"name"`
+	Options       map[string]string  `json:"options"`
+	Mappings      []InspectedMapping `json:"user_mappings"`
+	ForeignTables []InspectedTable   `json:"foreign_tables"`
+}
+
+// InspectedMapping is a user mapping; its options are only visible to superusers and
+// the mapping's user
+type InspectedMapping struct {
+	User    string            `json:"user"`
+	Options map[string]string `json:"options"`
+}
+
+// InspectedTable is a foreign table and the remote table it reads
+type InspectedTable struct {
+	Schema       string `json:"schema"`
+	Table        string `json:"table"`
+	RemoteSchema string `json:"remote_schema"`
+	RemoteTable  string `json:"remote_table"`
+	// Reachable is unset when reachability wasn't checked
+	Reachable *bool  `json:"reachable,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// redactOptions copies options with password values hidden
+func redactOptions(options map[string]string) map[string]string {
+	out := make(map[string]string, len(options))
+	for k, v := range options {
+		if strings.Contains(k, "password") {
+			v = redacted
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// buildInventory assembles an inventory from listed servers and foreignTableQuery rows.
+// Remote names default to the local ones, as postgres_fdw resolves them.
+func buildInventory(database string, servers []ForeignServer, tables [][]string) FDWInventory {
+	inventory := FDWInventory{Database: database, Servers: []InspectedServer{}}
+	index := make(map[string]int, len(servers))
+	for _, server := range servers {
+		inspected := InspectedServer{Name: server.Name, Options: redactOptions(server.Options),
+			Mappings: []InspectedMapping{}, ForeignTables: []InspectedTable{}}
+		users := make([]string, 0, len(server.Mappings))
+		for usename := range server.Mappings {
+			users = append(users, usename)
+		}
+		sort.Strings(users)
+		for _, usename := range users {
+			inspected.Mappings = append(inspected.Mappings, InspectedMapping{User: usename, Options: redactOptions(server.Mappings[usename])})
+		}
+		index[server.Name] = len(inventory.Servers)
+		inventory.Servers = append(inventory.Servers, inspected)
+	}
+
+	for _, row := range tables {
+		if len(row) != 4 {
+			continue
+		}
+		i, ok := index[row[2]]
+		if !ok {
+			continue
+		}
+		options := parseOptionList(row[3])
+		table := InspectedTable{Schema: row[0], Table: row[1], RemoteSchema: options["schema_name"], RemoteTable: options["table_name"]}
+		if table.RemoteSchema == "" {
+			table.RemoteSchema = table.Schema
+		}
+		if table.RemoteTable == "" {
+			table.RemoteTable = table.Table
+		}
+		inventory.Servers[i].ForeignTables = append(inventory.Servers[i].ForeignTables, table)
+	}
+	return inventory
+}
+
+// probeForeignTable reads one row of a foreign table through its server, which checks
+// the connection, the user mapping, and that the remote table exists
+func probeForeignTable(config DBConfig, table *InspectedTable) {
+	sql := fmt.Sprintf("SELECT 1 FROM %s.%s LIMIT 1;", quoteIdent(table.Schema), quoteIdent(table.Table))
+	cmd := newPsqlCmd(config, "-X", "-q", "-v", "ON_ERROR_STOP=1", "-c", "SET statement_timeout = '30s';", "-c", sql)
+	reachable := true
+	if output, err := cmd.CombinedOutput(); err != nil {
+		reachable = false
+		table.Error = strings.TrimSpace(string(output))
+		if table.Error == "" {
+			table.Error = err.Error()
+		}
+	}
+	table.Reachable = &reachable
+}
+
+// InspectFDW inventories the postgres_fdw servers, user mappings, and foreign tables of
+// config's database. With probe, each foreign table is read through its server.
+func InspectFDW(config DBConfig, probe bool) (FDWInventory, error) {
+	servers, err := listForeignServers(config)
+	if err != nil {
+		return FDWInventory{}, err
+	}
+	tables, err := queryRows(config, foreignTableQuery)
+	if err != nil {
+		return FDWInventory{}, fmt.Errorf("failed to list foreign tables: %w", err)
+	}
+	inventory := buildInventory(config.DBName, servers, tables)
+	if probe {
+		for i := range inventory.Servers {
+			for j := range inventory.Servers[i].ForeignTables {
+				table := &inventory.Servers[i].ForeignTables[j]
+				debugf("Probing foreign table %s.%s", table.Schema, table.Table)
+				probeForeignTable(config, table)
+			}
+		}
+	}
+	return inventory, nil
+}
+
+// formatOptions renders options as sorted key=value pairs
+func formatOptions(options map[string]string) string {
+	return optionList(options).String()
+}
+
+// PrintFDWInventory writes an inventory to stdout as indented JSON
+func PrintFDWInventory(inventory FDWInventory) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(inventory)
+}
+
+// LogFDWInventory logs an inventory as tables
+func LogFDWInventory(inventory FDWInventory) {
+	if len(inventory.Servers) == 0 {
+		alwaysLog.Printf("%s has no postgres_fdw servers", inventory.Database)
+		return
+	}
+	for _, server := range inventory.Servers {
+		alwaysLog.Printf("Server %s: %s", server.Name, formatOptions(server.Options))
+		for _, m := range server.Mappings {
+			alwaysLog.Printf("  mapping for %-20s %s", m.User, formatOptions(m.Options))
+		}
+		if len(server.ForeignTables) == 0 {
+			continue
+		}
+		alwaysLog.Printf("  %-40s %-40s %s", "foreign table", "remote table", "reachable")
+		for _, t := range server.ForeignTables {
+			status := "not checked"
+			if t.Reachable != nil && *t.Reachable {
+				status = "yes"
+			} else if t.Reachable != nil {
+				status = "no: " + t.Error
+			}
+			alwaysLog.Printf("  %-40s %-40s %s", t.Schema+"."+t.Table, t.RemoteSchema+"."+t.RemoteTable, status)
+		}
+	}
+	unreachable := 0
+	for _, server := range inventory.Servers {
+		for _, t := range server.ForeignTables {
+			if t.Reachable != nil && !*t.Reachable {
+				unreachable++
+			}
+		}
+	}
+	if unreachable > 0 {
+		log.Printf("WARNING: %d foreign tables in %s are unreachable", unreachable, inventory.Database)
+	}
+}
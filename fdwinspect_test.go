@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestBuildInventory(t *testing.T) {
+	servers := []ForeignServer{{
+		Name:     "moodys_server",
+		Options:  map[string]string{"host": "db", "dbname": "moodys"},
+		Mappings: map[string]map[string]string{"public": {"user": "reader", "password": "secret"}},
+	}}
+	tables := [][]string{
+		{"public", "rates", "moodys_server", "schema_name=ref,table_name=fx_rates"},
+		{"public", "issuers", "moodys_server", ""},
+		{"public", "other", "unknown_server", ""},
+	}
+
+	inventory := buildInventory("tenant", servers, tables)
+	if len(inventory.Servers) != 1 {
+		t.Fatalf("got %d servers, want 1", len(inventory.Servers))
+	}
+	server := inventory.Servers[0]
+	if got := server.Mappings[0].Options["password"]; got != redacted {
+		t.Errorf("password = %q, want it redacted", got)
+	}
+	if servers[0].Mappings["public"]["password"] != "secret" {
+		t.Error("redaction changed the listed server")
+	}
+	if len(server.ForeignTables) != 2 {
+		t.Fatalf("got %d foreign tables, want 2", len(server.ForeignTables))
+	}
+	if got := server.ForeignTables[0]; got.RemoteSchema != "ref" || got.RemoteTable != "fx_rates" {
+		t.Errorf("remote name = %s.%s, want ref.fx_rates", got.RemoteSchema, got.RemoteTable)
+	}
+	if got := server.ForeignTables[1]; got.RemoteSchema != "public" || got.RemoteTable != "issuers" {
+		t.Errorf("remote name = %s.%s, want the local name", got.RemoteSchema, got.RemoteTable)
+	}
+}
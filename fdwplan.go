@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FDW remap statuses
+const (
+	// RemapTarget servers point at a database being migrated and are retargeted
+	RemapTarget = "remap"
+	// RemapExternal servers point at a reachable database outside the migration
+	RemapExternal = "external"
+	// RemapUnreachable servers point outside the migration at a database that can't be reached
+	RemapUnreachable = "unreachable"
+)
+
+// FDWRemap is one foreign server in the remapping plan
+type FDWRemap struct {
+	Database      string `json:"database"`
+	Server        string `json:"server"`
+	Host          string `json:"host"`
+	Port          string `json:"port"`
+	DBName        string `json:"dbname"`
+	ForeignTables int    `json:"foreign_tables"`
+	// Target is the database the server is retargeted to follow
+	Target string `json:"target,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// fdwPlanFile is where the remapping plan is written in the dump directory
+const fdwPlanFile = "fdw_plan.json"
+
+// PlanFDWRemaps discovers every postgres_fdw server in the source databases and decides
+// what happens to it on restore. Servers pointing at one of the databases are retargeted
+// to its destination; the rest are probed with their user mapping's credentials.
+func PlanFDWRemaps(databases map[string]WorkflowDatabase) ([]FDWRemap, error) {
+	var plan []FDWRemap
+	for _, name := range sortedDatabaseNames(databases) {
+		db := databases[name]
+		servers, err := listForeignServers(db.Source)
+		if err != nil {
+			return nil, err
+		}
+		for _, server := range servers {
+			remap := FDWRemap{
+				Database:      name,
+				Server:        server.Name,
+				Host:          server.Options["host"],
+				Port:          server.Options["port"],
+				DBName:        server.Options["dbname"],
+				ForeignTables: server.ForeignTables,
+			}
+			if target, ok := serverTarget(server, db.Source, databases); ok {
+				remap.Target, remap.Status = target, RemapTarget
+			} else if err := probeFDWTarget(serverProbeTarget(server, db.Source)); err != nil {
+				remap.Status, remap.Error = RemapUnreachable, err.Error()
+			} else {
+				remap.Status = RemapExternal
+			}
+			plan = append(plan, remap)
+		}
+	}
+	return plan, nil
+}
+
+// serverProbeTarget builds connection settings for a server outside the migration from
+// its options and a user mapping, preferring the mapping for the connecting user
+func serverProbeTarget(server ForeignServer, from DBConfig) FDWTarget {
+	t := FDWTarget{
+		Host:    server.Options["host"],
+		Port:    server.Options["port"],
+		DBName:  server.Options["dbname"],
+		SSLMode: server.Options["sslmode"],
+	}
+	mapping, ok := server.Mappings[from.User]
+	if !ok {
+		mapping = server.Mappings["public"]
+	}
+	t.User, t.Password = mapping["user"], mapping["password"]
+	return t.withDefaults(from)
+}
+
+// checkFDWPlan logs the plan and fails when a server can't be reached
+func checkFDWPlan(plan []FDWRemap) error {
+	var unreachable []string
+	for _, r := range plan {
+		switch r.Status {
+		case RemapTarget:
+			log.Printf("Foreign server %s in %s (%d foreign tables) will follow %s to its destination",
+				r.Server, r.Database, r.ForeignTables, r.Target)
+		case RemapExternal:
+			log.Printf("WARNING: foreign server %s in %s (%d foreign tables) points at %s:%s/%s outside the migration and is left as is",
+				r.Server, r.Database, r.ForeignTables, r.Host, r.Port, r.DBName)
+		default:
+			unreachable = append(unreachable, fmt.Sprintf("%s in %s (%s:%s/%s)", r.Server, r.Database, r.Host, r.Port, r.DBName))
+		}
+	}
+	if len(unreachable) > 0 {
+		return fmt.Errorf("foreign servers point at databases that can't be reached: %s", strings.Join(unreachable, ", "))
+	}
+	return nil
+}
+
+// WriteFDWPlan saves the plan in the dump directory
+func WriteFDWPlan(dir string, plan []FDWRemap) error {
+	content, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode FDW plan: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create dump directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, fdwPlanFile), content, 0644); err != nil {
+		return fmt.Errorf("failed to write FDW plan: %w", err)
+	}
+	return nil
+}
+
+// applyFDWPlan retargets the servers of a database's pre-data file that the plan remaps
+func applyFDWPlan(inputFile, database string, plan []FDWRemap, databases map[string]WorkflowDatabase) error {
+	retargeted := make(map[string]bool)
+	for _, r := range plan {
+		if r.Database != database || r.Status != RemapTarget || retargeted[r.Target] {
+			continue
+		}
+		retargeted[r.Target] = true
+		target := databases[r.Target]
+		if err := modifyPreDataFile(inputFile, target.Source, target.Dest); err != nil {
+			return fmt.Errorf("failed to retarget foreign servers of %s at %s: %w", database, r.Target, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCheckFDWPlan(t *testing.T) {
+	plan := []FDWRemap{
+		{Database: "tenant", Server: "moodys_server", Target: "moodys", Status: RemapTarget},
+		{Database: "tenant", Server: "reporting", Host: "reports.internal", Port: "5432", DBName: "reports", Status: RemapExternal},
+	}
+	if err := checkFDWPlan(plan); err != nil {
+		t.Errorf("checkFDWPlan: %v", err)
+	}
+	plan = append(plan, FDWRemap{Database: "tenant", Server: "legacy", Host: "gone.internal", Status: RemapUnreachable, Error: "timeout"})
+	if err := checkFDWPlan(plan); err == nil {
+		t.Error("checkFDWPlan accepted an unreachable server")
+	}
+}
+
+func TestWriteFDWPlan(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "dump")
+	plan := []FDWRemap{
+		{Database: "tenant", Server: "moodys_server", Host: "localhost", Port: "5432", DBName: "moodys", ForeignTables: 3, Target: "moodys", Status: RemapTarget},
+		{Database: "tenant", Server: "legacy", Host: "gone.internal", DBName: "legacy", Status: RemapUnreachable, Error: "timeout"},
+	}
+	if err := WriteFDWPlan(dir, plan); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join(dir, fdwPlanFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed []FDWRemap
+	if err := json.Unmarshal(content, &parsed); err != nil {
+		t.Fatalf("failed to parse %s: %v", fdwPlanFile, err)
+	}
+	if !reflect.DeepEqual(parsed, plan) {
+		t.Errorf("parsed plan = %+v, want %+v", parsed, plan)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// FDWRetarget rewrites a foreign server of an existing database in place, for when the
+// database it reaches moved but nothing is restored
+type FDWRetarget struct {
+	// Server is the foreign server to change
+	Server string
+	// Target holds the new settings; empty fields keep the server's current values
+	Target FDWTarget
+	// MappingsFor lists the local roles whose user mappings get Target's user and
+	// password; empty changes every mapping of the server
+	MappingsFor []string
+	// DryRun prints the statements instead of running them
+	DryRun bool
+}
+
+// optionList is a repeatable key=value command-line flag
+type optionList map[string]string
+
+func (o optionList) String() string {
+	var pairs []string
+	for k, v := range o {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (o optionList) Set(value string) error {
+	key, v, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("expected key=value, got %q", value)
+	}
+	o[key] = v
+	return nil
+}
+
+// retargetServerStatements returns the ALTER statements that apply target to a server
+// and the selected user mappings, leaving unchanged values alone
+func retargetServerStatements(server ForeignServer, target FDWTarget, mappingsFor []string) []string {
+	options := map[string]string{"host": target.Host, "port": target.Port, "dbname": target.DBName, "sslmode": target.SSLMode}
+	for k, v := range target.Options {
+		options[k] = v
+	}
+	keys := make([]string, 0, len(options))
+	for k, v := range options {
+		if v != "" && server.Options[k] != v {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var statements []string
+	if len(keys) > 0 {
+		clauses := make([]string, 0, len(keys))
+		for _, k := range keys {
+			clauses = append(clauses, setOption(server.Options, k, options[k]))
+		}
+		statements = append(statements, fmt.Sprintf("ALTER SERVER %s OPTIONS (%s);", quoteIdent(server.Name), strings.Join(clauses, ", ")))
+	}
+
+	users := make([]string, 0, len(server.Mappings))
+	for usename := range server.Mappings {
+		if includesDatabase(mappingsFor, usename) {
+			users = append(users, usename)
+		}
+	}
+	sort.Strings(users)
+	for _, usename := range users {
+		current := server.Mappings[usename]
+		var clauses []string
+		if target.User != "" && current["user"] != target.User {
+			clauses = append(clauses, setOption(current, "user", target.User))
+		}
+		if target.Password != "" && current["password"] != target.Password {
+			clauses = append(clauses, setOption(current, "password", target.Password))
+		}
+		if len(clauses) > 0 {
+			statements = append(statements, fmt.Sprintf("ALTER USER MAPPING FOR %s SERVER %s OPTIONS (%s);",
+				mappingRole(usename), quoteIdent(server.Name), strings.Join(clauses, ", ")))
+		}
+	}
+	return statements
+}
+
+// retargetedProbe returns the connection settings the server will use after the
+// change, so the new target can be probed before anything is altered
+func retargetedProbe(server ForeignServer, from DBConfig, r FDWRetarget) FDWTarget {
+	t := serverProbeTarget(server, from)
+	if r.Target.Host != "" {
+		t.Host = r.Target.Host
+	}
+	if r.Target.Port != "" {
+		t.Port = r.Target.Port
+	}
+	if r.Target.DBName != "" {
+		t.DBName = r.Target.DBName
+	}
+	if r.Target.SSLMode != "" {
+		t.SSLMode = r.Target.SSLMode
+	}
+	if r.Target.User != "" {
+		t.User = r.Target.User
+	}
+	if r.Target.Password != "" {
+		t.Password = r.Target.Password
+	}
+	t.Options = make(map[string]string)
+	for k, v := range server.Options {
+		t.Options[k] = v
+	}
+	for k, v := range r.Target.Options {
+		t.Options[k] = v
+	}
+	return t
+}
+
+// RetargetFDW points a foreign server of config's database and its user mappings at a
+// new target with ALTER SERVER and ALTER USER MAPPING. The new target is probed first,
+// and the statements run in one transaction.
+func RetargetFDW(config DBConfig, r FDWRetarget) error {
+	servers, err := listForeignServers(config)
+	if err != nil {
+		return err
+	}
+	var server *ForeignServer
+	for i := range servers {
+		if servers[i].Name == r.Server {
+			server = &servers[i]
+		}
+	}
+	if server == nil {
+		return fmt.Errorf("no postgres_fdw server %s in %s", r.Server, config.DBName)
+	}
+	for _, role := range r.MappingsFor {
+		if _, ok := server.Mappings[role]; !ok {
+			return fmt.Errorf("server %s has no user mapping for %s", r.Server, role)
+		}
+	}
+
+	statements := retargetServerStatements(*server, r.Target, r.MappingsFor)
+	if len(statements) == 0 {
+		alwaysLog.Printf("Server %s in %s already matches the target", r.Server, config.DBName)
+		return nil
+	}
+	if r.DryRun {
+		alwaysLog.Printf("Retarget of %s in %s (dry run):\n%s", r.Server, config.DBName, strings.Join(statements, "\n"))
+		return nil
+	}
+
+	if err := probeFDWTarget(retargetedProbe(*server, config, r)); err != nil {
+		return err
+	}
+	log.Printf("Retargeting server %s in %s with %d statements", r.Server, config.DBName, len(statements))
+	cmd := newPsqlCmd(config, "-v", "ON_ERROR_STOP=1", "--single-transaction")
+	cmd.Stdin = strings.NewReader(strings.Join(statements, "\n") + "\n")
+	if output, err := runStreaming(cmd, "retarget_"+config.DBName, nil); err != nil {
+		return fmt.Errorf("failed to retarget %s in %s: %w\nOutput: %s", r.Server, config.DBName, err, output)
+	}
+	alwaysLog.Printf("Server %s in %s now points at %s", r.Server, config.DBName, describeTarget(retargetedProbe(*server, config, r)))
+	return nil
+}
+
+// describeTarget formats a target for logs, without its password
+func describeTarget(t FDWTarget) string {
+	return fmt.Sprintf("%s:%s/%s as %s", t.Host, t.Port, t.DBName, t.User)
+}
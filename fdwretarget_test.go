@@ -0,0 +1,45 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRetargetServerStatements(t *testing.T) {
+	server := ForeignServer{
+		Name:    "moodys_server",
+		Options: map[string]string{"host": "old", "port": "5432", "dbname": "moodys"},
+		Mappings: map[string]map[string]string{
+			"public": {"user": "reader", "password": "old"},
+			"app":    {"user": "reader"},
+		},
+	}
+	target := FDWTarget{Host: "new", Port: "5432", Password: "new", Options: map[string]string{"fetch_size": "1000"}}
+
+	got := retargetServerStatements(server, target, []string{"app"})
+	want := []string{
+		`ALTER SERVER "moodys_server" OPTIONS (ADD "fetch_size" '1000', SET "host" 'new');`,
+		`ALTER USER MAPPING FOR "app" SERVER "moodys_server" OPTIONS (ADD "password" 'new');`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("retargetServerStatements =\n%q\nwant\n%q", got, want)
+	}
+
+	got = retargetServerStatements(server, FDWTarget{Host: "old", User: "reader"}, nil)
+	if len(got) != 0 {
+		t.Errorf("unchanged target should need no statements, got %q", got)
+	}
+}
+
+func TestOptionList(t *testing.T) {
+	o := optionList{}
+	if err := o.Set("sslrootcert=/etc/ca.pem"); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Set("fetch_size"); err == nil {
+		t.Error("expected an error without =")
+	}
+	if o.String() != "sslrootcert=/etc/ca.pem" {
+		t.Errorf("String() = %q", o.String())
+	}
+}
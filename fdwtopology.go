@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// FDWTarget is the cluster restored tenant databases reach moodys on through the foreign
+// server, when it differs from where this tool restores moodys
+type FDWTarget struct {
+	Host     string `json:"host"`
+	Port     string `json:"port"`
+	DBName   string `json:"dbname"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	SSLMode  string `json:"sslmode"`
+	// Options are extra foreign server options, e.g. sslrootcert or fetch_size
+	Options map[string]string `json:"options"`
+}
+
+// withDefaults fills unset connection fields from the destination moodys config
+func (t FDWTarget) withDefaults(config DBConfig) FDWTarget {
+	if t.Host == "" {
+		t.Host = config.Host
+	}
+	if t.Port == "" {
+		t.Port = config.Port
+	}
+	if t.DBName == "" {
+		t.DBName = config.DBName
+	}
+	if t.User == "" {
+		t.User = config.User
+		if t.Password == "" {
+			t.Password = config.Password
+		}
+	}
+	return t
+}
+
+// serverOptions returns the foreign server options the target sets
+func (t FDWTarget) serverOptions() map[string]string {
+	options := map[string]string{"host": t.Host, "dbname": t.DBName}
+	// An unset port, usual for socket directories, keeps the dumped one
+	if t.Port != "" {
+		options["port"] = t.Port
+	}
+	if t.SSLMode != "" {
+		options["sslmode"] = t.SSLMode
+	}
+	for k, v := range t.Options {
+		options[k] = v
+	}
+	return options
+}
+
+// fdwOptionRe matches a name 'value' pair in an OPTIONS list; names may be quoted
+var fdwOptionRe = regexp.MustCompile(`("?[A-Za-z_][\w]*"?)\s+'((?:[^']|'')*)'`)
+
+// mergeOptions overrides and adds options in the body of an OPTIONS (...) list,
+// keeping options the overrides don't mention, and renders it the way pg_dump does
+func mergeOptions(body string, overrides map[string]string) string {
+	values := make(map[string]string)
+	var order []string
+	for _, m := range fdwOptionRe.FindAllStringSubmatch(body, -1) {
+		key := strings.Trim(m[1], `"`)
+		if _, seen := values[key]; !seen {
+			order = append(order, key)
+		}
+		values[key] = strings.ReplaceAll(m[2], "''", "'")
+	}
+
+	var added []string
+	for key, value := range overrides {
+		if _, seen := values[key]; !seen {
+			added = append(added, key)
+		}
+		values[key] = value
+	}
+	sort.Strings(added)
+	order = append(order, added...)
+
+	lines := make([]string, 0, len(order))
+	for _, key := range order {
+		name := key
+		if key == "user" {
+			name = quoteIdent(key)
+		}
+		lines = append(lines, fmt.Sprintf("    %s %s", name, quoteLiteral(values[key])))
+	}
+	return "\n" + strings.Join(lines, ",\n") + "\n"
+}
+
+// rewriteFDWTopology points a foreign server and its user mappings in a pre-data script
+// at the target cluster and credentials
+func rewriteFDWTopology(script, server string, t FDWTarget) string {
+	name := regexp.QuoteMeta(server) + `|` + regexp.QuoteMeta(quoteIdent(server))
+	serverRe := regexp.MustCompile(`(?s)(CREATE SERVER (?:` + name + `) FOREIGN DATA WRAPPER \S+ OPTIONS \()(.*?)(\);)`)
+	mappingRe := regexp.MustCompile(`(?s)(CREATE USER MAPPING FOR \S+ SERVER (?:` + name + `) OPTIONS \()(.*?)(\);)`)
+
+	rewrite := func(re *regexp.Regexp, overrides map[string]string) {
+		script = re.ReplaceAllStringFunc(script, func(stmt string) string {
+			m := re.FindStringSubmatch(stmt)
+			return m[1] + mergeOptions(m[2], overrides) + m[3]
+		})
+	}
+	rewrite(serverRe, t.serverOptions())
+	rewrite(mappingRe, map[string]string{"user": t.User, "password": t.Password})
+	return script
+}
+
+// probeFDWTarget connects to the target with the foreign server's credentials and SSL
+// settings, so a wrong host, password, or certificate fails before tenant restore starts.
+// It runs from this machine, which needs the same network path as the destination cluster.
+func probeFDWTarget(t FDWTarget) error {
+	// Socket directories often leave the port to libpq's default, which the builder omits
+	config := DBConfig{Host: t.Host, Port: t.Port, User: t.User, Password: t.Password, DBName: t.DBName}
+	probe := pgCommand("psql", config).Param("connect_timeout", "10").Param("sslmode", t.SSLMode)
+	for _, key := range []string{"sslrootcert", "sslcert", "sslkey"} {
+		probe.Param(key, t.Options[key])
+	}
+	cmd := probe.Arg("-t", "-A", "-c", "SELECT 1;").Cmd()
+	if output, err := runStreaming(cmd, "probe_fdw_target", nil); err != nil {
+		return fmt.Errorf("FDW target %s:%s/%s is not reachable as %s: %w, output: %s",
+			t.Host, t.Port, t.DBName, t.User, err, output)
+	}
+	log.Printf("FDW target %s:%s/%s reachable as %s", t.Host, t.Port, t.DBName, t.User)
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestRewriteFDWTopology(t *testing.T) {
+	script := `CREATE SERVER moodys_server FOREIGN DATA WRAPPER postgres_fdw OPTIONS (
+    dbname 'moodys',
+    fetch_size '1000',
+    host 'localhost',
+    port '5432'
+);
+
+CREATE USER MAPPING FOR postgres SERVER moodys_server OPTIONS (
+    password 'your_password',
+    "user" 'postgres'
+);`
+
+	target := FDWTarget{
+		Host: "moodys.prod-b.internal", Port: "6432", DBName: "moodys_v2",
+		User: "fdw_reader", Password: "it's secret", SSLMode: "verify-full",
+	}
+	want := `CREATE SERVER moodys_server FOREIGN DATA WRAPPER postgres_fdw OPTIONS (
+    dbname 'moodys_v2',
+    fetch_size '1000',
+    host 'moodys.prod-b.internal',
+    port '6432',
+    sslmode 'verify-full'
+);
+
+CREATE USER MAPPING FOR postgres SERVER moodys_server OPTIONS (
+    password 'it''s secret',
+    "user" 'fdw_reader'
+);`
+
+	if got := rewriteFDWTopology(script, "moodys_server", target); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
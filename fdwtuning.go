@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// FDWTuning sets postgres_fdw performance options on the foreign servers a restore
+// rewrites, so the migrated environment doesn't run on the defaults
+type FDWTuning struct {
+	// UseRemoteEstimate asks the remote server for row estimates when planning
+	UseRemoteEstimate *bool `json:"use_remote_estimate"`
+	// FetchSize is the number of rows fetched per round trip
+	FetchSize int `json:"fetch_size"`
+	// BatchSize is the number of rows inserted per round trip
+	BatchSize int `json:"batch_size"`
+	// AsyncCapable lets foreign scans run concurrently under Append nodes
+	AsyncCapable *bool `json:"async_capable"`
+	// Tables tune single foreign tables by schema.table, overriding the server's settings
+	Tables map[string]FDWTuning `json:"tables"`
+}
+
+func (t *FDWTuning) validate() error {
+	if t.FetchSize < 0 || t.BatchSize < 0 {
+		return fmt.Errorf("fetch_size and batch_size can't be negative")
+	}
+	for name, table := range t.Tables {
+		if len(table.Tables) > 0 {
+			return fmt.Errorf("table %s can't have tables of its own", name)
+		}
+		if err := table.validate(); err != nil {
+			return fmt.Errorf("table %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// options returns the postgres_fdw options the tuning sets
+func (t FDWTuning) options() map[string]string {
+	options := make(map[string]string)
+	if t.UseRemoteEstimate != nil {
+		options["use_remote_estimate"] = strconv.FormatBool(*t.UseRemoteEstimate)
+	}
+	if t.FetchSize > 0 {
+		options["fetch_size"] = strconv.Itoa(t.FetchSize)
+	}
+	if t.BatchSize > 0 {
+		options["batch_size"] = strconv.Itoa(t.BatchSize)
+	}
+	if t.AsyncCapable != nil {
+		options["async_capable"] = strconv.FormatBool(*t.AsyncCapable)
+	}
+	return options
+}
+
+// tunedServers returns the foreign servers of a database's pre-data that a restore
+// rewrites: the discovered ones retargeted by the plan, or else tenant's moodys server
+func tunedServers(database string, plan []FDWRemap) []string {
+	if plan == nil {
+		if database == "tenant" {
+			return []string{moodysServerName}
+		}
+		return nil
+	}
+	var servers []string
+	for _, r := range plan {
+		if r.Database == database && r.Status == RemapTarget {
+			servers = append(servers, r.Server)
+		}
+	}
+	return servers
+}
+
+// tuneFDW merges the tuning options into the CREATE SERVER statements of the given
+// servers in a pre-data script, and the per-table options into their foreign tables
+func tuneFDW(script string, servers []string, t *FDWTuning) string {
+	for _, server := range servers {
+		name := regexp.QuoteMeta(server) + `|` + regexp.QuoteMeta(quoteIdent(server))
+		serverRe := regexp.MustCompile(`(?s)(CREATE SERVER (?:` + name + `) FOREIGN DATA WRAPPER [^\s;]+)(?: OPTIONS \((.*?)\))?;`)
+		if options := t.options(); len(options) > 0 {
+			script = serverRe.ReplaceAllStringFunc(script, func(stmt string) string {
+				m := serverRe.FindStringSubmatch(stmt)
+				return m[1] + " OPTIONS (" + mergeOptions(m[2], options) + ");"
+			})
+		}
+	}
+	if len(t.Tables) == 0 {
+		return script
+	}
+
+	return foreignTableRe.ReplaceAllStringFunc(script, func(stmt string) string {
+		m := foreignTableRe.FindStringSubmatchIndex(stmt)
+		if !includesDatabase(servers, unquoteIdent(stmt[m[4]:m[5]])) {
+			return stmt
+		}
+		schema, table := splitDumpedName(stmt[m[2]:m[3]])
+		tuning, ok := t.Tables[schema+"."+table]
+		if !ok || len(tuning.options()) == 0 {
+			return stmt
+		}
+		body := ""
+		if m[6] >= 0 {
+			body = stmt[m[6]:m[7]]
+		}
+		return stmt[:m[5]] + "\nOPTIONS (" + mergeOptions(body, tuning.options()) + ");\n"
+	})
+}
+
+// tunePreDataFile applies tuneFDW to a pre-data file in place
+func tunePreDataFile(path string, servers []string, t *FDWTuning) error {
+	if len(servers) == 0 {
+		return nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read pre-data file: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(tuneFDW(string(content), servers, t)), 0644); err != nil {
+		return fmt.Errorf("failed to write tuned pre-data file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTuneFDW(t *testing.T) {
+	script := `CREATE SERVER moodys_server FOREIGN DATA WRAPPER postgres_fdw OPTIONS (
+    dbname 'moodys'
+);
+CREATE SERVER other FOREIGN DATA WRAPPER postgres_fdw;
+CREATE FOREIGN TABLE public.rates (
+    id integer
+)
+SERVER moodys_server
+OPTIONS (
+    table_name 'rates'
+);
+`
+	on := true
+	tuning := &FDWTuning{UseRemoteEstimate: &on, FetchSize: 1000, Tables: map[string]FDWTuning{"public.rates": {FetchSize: 50000}}}
+	got := tuneFDW(script, []string{"moodys_server"}, tuning)
+
+	for _, want := range []string{
+		"CREATE SERVER moodys_server FOREIGN DATA WRAPPER postgres_fdw OPTIONS (\n    dbname 'moodys',\n    fetch_size '1000',\n    use_remote_estimate 'true'\n);",
+		"CREATE SERVER other FOREIGN DATA WRAPPER postgres_fdw;",
+		"SERVER moodys_server\nOPTIONS (\n    table_name 'rates',\n    fetch_size '50000'\n);\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("tuned script lacks %q:\n%s", want, got)
+		}
+	}
+
+	bare := tuneFDW("CREATE SERVER s FOREIGN DATA WRAPPER postgres_fdw;\n", []string{"s"}, &FDWTuning{BatchSize: 100})
+	if want := "CREATE SERVER s FOREIGN DATA WRAPPER postgres_fdw OPTIONS (\n    batch_size '100'\n);\n"; bare != want {
+		t.Errorf("tuned server without options = %q, want %q", bare, want)
+	}
+}
+
+func TestFDWTuningValidate(t *testing.T) {
+	nested := &FDWTuning{Tables: map[string]FDWTuning{"public.t": {Tables: map[string]FDWTuning{"x": {}}}}}
+	if err := nested.validate(); err == nil {
+		t.Error("expected nested tables to be rejected")
+	}
+	if err := (&FDWTuning{FetchSize: -1}).validate(); err == nil {
+		t.Error("expected a negative fetch_size to be rejected")
+	}
+}
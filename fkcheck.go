@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// columnSep separates column names within one field of foreignKeyQuery output
+const columnSep = "\x1d"
+
+// foreignKeyQuery lists the foreign keys outside system schemas with their columns in
+// key order. Table names come back quoted and qualified as needed by regclass.
+const foreignKeyQuery = `SELECT c.conname, c.conrelid::regclass::text, c.confrelid::regclass::text,
+	(SELECT string_agg(a.attname, E'\x1d' ORDER BY k.i) FROM unnest(c.conkey) WITH ORDINALITY k(attnum, i)
+		JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = k.attnum),
+	(SELECT string_agg(a.attname, E'\x1d' ORDER BY k.i) FROM unnest(c.confkey) WITH ORDINALITY k(attnum, i)
+		JOIN pg_attribute a ON a.attrelid = c.confrelid AND a.attnum = k.attnum)
+FROM pg_constraint c
+JOIN pg_namespace n ON n.oid = c.connamespace
+WHERE c.contype = 'f' AND c.conparentid = 0
+	AND n.nspname NOT IN ('pg_catalog', 'information_schema') AND n.nspname NOT LIKE 'pg\_%'
+ORDER BY 2, 1;`
+
+// orphanSamples is how many orphaned keys are reported per constraint
+const orphanSamples = 5
+
+// ForeignKey is a foreign key constraint to check
+type ForeignKey struct {
+	Name       string
+	Table      string
+	RefTable   string
+	Columns    []string
+	RefColumns []string
+}
+
+// ForeignKeyCheck is the outcome of checking one foreign key after restore
+type ForeignKeyCheck struct {
+	Database   string `json:"database"`
+	Constraint string `json:"constraint"`
+	Table      string `json:"table"`
+	References string `json:"references"`
+	Orphans    int64  `json:"orphans"`
+	// Samples are orphaned key values, e.g. "(42)"
+	Samples []string `json:"samples,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// parseForeignKeys parses foreignKeyQuery rows
+func parseForeignKeys(rows [][]string) ([]ForeignKey, error) {
+	var keys []ForeignKey
+	for _, row := range rows {
+		if len(row) != 5 {
+			return nil, fmt.Errorf("unexpected foreign key row %q", strings.Join(row, "|"))
+		}
+		keys = append(keys, ForeignKey{
+			Name:       row[0],
+			Table:      row[1],
+			RefTable:   row[2],
+			Columns:    strings.Split(row[3], columnSep),
+			RefColumns: strings.Split(row[4], columnSep),
+		})
+	}
+	return keys, nil
+}
+
+// orphanQuery finds child rows whose key has no parent row. Rows with a NULL in the key
+// are not checked, as under the default MATCH SIMPLE. Every result row carries the total
+// orphan count followed by the orphaned key.
+func orphanQuery(fk ForeignKey, limit int) string {
+	var notNull, match, key []string
+	for i, col := range fk.Columns {
+		c := "c." + quoteIdent(col)
+		notNull = append(notNull, c+" IS NOT NULL")
+		match = append(match, fmt.Sprintf("p.%s = %s", quoteIdent(fk.RefColumns[i]), c))
+		key = append(key, c)
+	}
+	return fmt.Sprintf("SELECT count(*) OVER (), ROW(%s)::text FROM %s c WHERE %s AND NOT EXISTS (SELECT 1 FROM %s p WHERE %s) LIMIT %d;",
+		strings.Join(key, ", "), fk.Table, strings.Join(notNull, " AND "), fk.RefTable, strings.Join(match, " AND "), limit)
+}
+
+// checkForeignKey counts the orphaned rows of one foreign key
+func checkForeignKey(config DBConfig, fk ForeignKey) ForeignKeyCheck {
+	result := ForeignKeyCheck{Database: config.DBName, Constraint: fk.Name, Table: fk.Table, References: fk.RefTable}
+	if len(fk.Columns) != len(fk.RefColumns) {
+		result.Error = "column lists differ in length"
+		return result
+	}
+	rows, err := queryRows(config, orphanQuery(fk, orphanSamples))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	for _, row := range rows {
+		if len(row) != 2 {
+			result.Error = fmt.Sprintf("unexpected orphan row %q", strings.Join(row, "|"))
+			return result
+		}
+		result.Orphans, _ = strconv.ParseInt(row[0], 10, 64)
+		result.Samples = append(result.Samples, row[1])
+	}
+	return result
+}
+
+// CheckForeignKeys looks for orphaned rows behind every foreign key in the database,
+// running up to workers checks at once. Constraints restored while triggers were
+// disabled are never re-checked by PostgreSQL, so this catches broken references a
+// data-only restore let through. Results are added to the run report.
+func CheckForeignKeys(config DBConfig, workers int) ([]ForeignKeyCheck, error) {
+	rows, err := queryRows(config, foreignKeyQuery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list foreign keys in %s: %w", config.DBName, err)
+	}
+	keys, err := parseForeignKeys(rows)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Checking %d foreign keys in %s", len(keys), config.DBName)
+
+	if workers < 1 {
+		workers = 1
+	}
+	results := make([]ForeignKeyCheck, len(keys))
+	slots := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for i, fk := range keys {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, fk ForeignKey) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			results[i] = checkForeignKey(config, fk)
+		}(i, fk)
+	}
+	wg.Wait()
+
+	var problems []string
+	for _, r := range results {
+		activeReport.recordForeignKey(r)
+		switch {
+		case r.Error != "":
+			problems = append(problems, fmt.Sprintf("%s on %s: check failed: %s", r.Constraint, r.Table, r.Error))
+		case r.Orphans > 0:
+			problems = append(problems, fmt.Sprintf("%s on %s: %d rows reference missing %s rows, e.g. %s",
+				r.Constraint, r.Table, r.Orphans, r.References, strings.Join(r.Samples, ", ")))
+		}
+	}
+	if len(problems) > 0 {
+		return results, fmt.Errorf("%d foreign keys in %s are violated:\n  %s", len(problems), config.DBName, strings.Join(problems, "\n  "))
+	}
+	log.Printf("All %d foreign keys in %s hold", len(keys), config.DBName)
+	return results, nil
+}
@@ -0,0 +1,19 @@
+package main
+
+import "testing"
+
+func TestOrphanQuery(t *testing.T) {
+	keys, err := parseForeignKeys([][]string{{"line_order_fk", "sales.lines", "sales.orders", "order_id\x1dregion", "id\x1dregion"}})
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("got %v, %v", keys, err)
+	}
+	want := `SELECT count(*) OVER (), ROW(c."order_id", c."region")::text FROM sales.lines c ` +
+		`WHERE c."order_id" IS NOT NULL AND c."region" IS NOT NULL AND NOT EXISTS ` +
+		`(SELECT 1 FROM sales.orders p WHERE p."id" = c."order_id" AND p."region" = c."region") LIMIT 5;`
+	if got := orphanQuery(keys[0], 5); got != want {
+		t.Errorf("orphan query:\n got %s\nwant %s", got, want)
+	}
+	if _, err := parseForeignKeys([][]string{{"short"}}); err == nil {
+		t.Error("expected an error for a short row")
+	}
+}
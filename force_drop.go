@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// forceDropSupportedVersion is the first server version supporting DROP DATABASE ... WITH (FORCE)
+const forceDropSupportedVersion = 130000
+
+// isLocalHost reports whether the host refers to the local machine
+func isLocalHost(host string) bool {
+	switch host {
+	case "", "localhost", "127.0.0.1", "::1":
+		return true
+	}
+	return strings.HasPrefix(host, "/")
+}
+
+// maintenanceConfig returns the config pointed at the maintenance database
+func maintenanceConfig(config DBConfig) DBConfig {
+	config.DBName = "postgres"
+	return config
+}
+
+// serverVersionNum returns the numeric server version, e.g. 160008
+func serverVersionNum(config DBConfig) (int, error) {
+	cmd := newPsqlCmd(config, "-t", "-A", "-c", "SHOW server_version_num;")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("failed to query server version: %w, output: %s", err, output)
+	}
+
+	version, err := strconv.Atoi(strings.TrimSpace(string(output)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse server version %q: %w", output, err)
+	}
+	return version, nil
+}
+
+// forceDropSQL returns the statements force-dropping a database on a server version:
+// DROP DATABASE ... WITH (FORCE) on PG13+, or terminating its sessions before a plain
+// DROP DATABASE on older servers
+func forceDropSQL(dbName string, version int) (terminateSQL, dropSQL string) {
+	if version >= forceDropSupportedVersion {
+		return "", fmt.Sprintf("DROP DATABASE IF EXISTS %s WITH (FORCE);", quoteIdent(dbName))
+	}
+	terminateSQL = fmt.Sprintf(
+		"SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE datname = %s AND pid <> pg_backend_pid();",
+		quoteLiteral(dbName))
+	return terminateSQL, "DROP DATABASE IF EXISTS " + quoteIdent(dbName)
+}
+
+// forceDropDatabase drops a database even when sessions are connected to it.
+// PG13+ uses DROP DATABASE ... WITH (FORCE); older servers terminate backends first.
+// Protections.checkDrop has confirmed the drop.
+func forceDropDatabase(config DBConfig) error {
+	admin := maintenanceConfig(config)
+	version, err := serverVersionNum(admin)
+	if err != nil {
+		return err
+	}
+
+	terminateSQL, dropSQL := forceDropSQL(config.DBName, version)
+	if terminateSQL != "" {
+		cmd := newPsqlCmd(admin, "-c", terminateSQL)
+		if output, err := runStreaming(cmd, "terminate_"+config.DBName, nil); err != nil {
+			return fmt.Errorf("failed to terminate sessions on %s: %w, output: %s", config.DBName, err, output)
+		}
+	}
+
+	log.Printf("Force-dropping database %s", config.DBName)
+	cmd := newPsqlCmd(admin, "-c", dropSQL)
+	if output, err := runStreaming(cmd, "drop_"+config.DBName, nil); err != nil {
+		return fmt.Errorf("failed to force-drop database %s: %v, output: %s", config.DBName, err, string(output))
+	}
+	return nil
+}
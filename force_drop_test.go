@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestIsLocalHost(t *testing.T) {
+	tests := map[string]bool{
+		"":                    true,
+		"localhost":           true,
+		"127.0.0.1":           true,
+		"::1":                 true,
+		"/var/run/postgresql": true,
+		"db.internal":         false,
+		"10.0.0.5":            false,
+	}
+	for host, want := range tests {
+		if got := isLocalHost(host); got != want {
+			t.Errorf("isLocalHost(%q) = %v, want %v", host, got, want)
+		}
+	}
+}
+
+func TestForceDropSQL(t *testing.T) {
+	terminate, drop := forceDropSQL("tenant", 160004)
+	if terminate != "" || drop != `DROP DATABASE IF EXISTS "tenant" WITH (FORCE);` {
+		t.Errorf("PG16: got %q, %q", terminate, drop)
+	}
+	terminate, drop = forceDropSQL("it's", 120015)
+	if !strings.Contains(terminate, "pg_terminate_backend") || !strings.Contains(terminate, `datname = 'it''s'`) {
+		t.Errorf("PG12 terminate = %q", terminate)
+	}
+	if drop != `DROP DATABASE IF EXISTS "it's"` {
+		t.Errorf("PG12 drop = %q", drop)
+	}
+}
+
+func TestConfirmForceDrop(t *testing.T) {
+	defer func(input *bufio.Reader) { confirmationInput = input }(confirmationInput)
+	optOut := false
+	remote := DBConfig{Host: "db.internal", DBName: "tenant"}
+	tests := []struct {
+		name    string
+		p       *Protections
+		config  DBConfig
+		answer  string
+		wantErr bool
+	}{
+		{"one prompt", &Protections{}, remote, "drop tenant\n", false},
+		{"bare name", &Protections{}, remote, "tenant\n", true},
+		{"no answer", &Protections{}, remote, "", true},
+		{"yes-i-mean-it", &Protections{Confirmed: true}, remote, "", false},
+		{"opted out of plain drops", &Protections{RequireConfirmation: &optOut}, remote, "", true},
+		{"opted out, local", &Protections{RequireConfirmation: &optOut}, DBConfig{Host: "localhost", DBName: "tenant"}, "", false},
+		{"nil protections", nil, remote, "drop tenant\n", false},
+	}
+	for _, tt := range tests {
+		confirmationInput = bufio.NewReader(strings.NewReader(tt.answer))
+		if err := tt.p.checkDrop(tt.config, DropOptions{Force: true}); (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// Artifact formats, named as pg_dump's --format
+const (
+	FormatPlain     = "plain"
+	FormatCustom    = "custom"
+	FormatTar       = "tar"
+	FormatDirectory = "directory"
+)
+
+// ArtifactFormat is what an artifact on disk holds, detected from its contents
+type ArtifactFormat struct {
+	Kind string
+	// Compression is "gzip", "zstd", or "lz4" when the whole file is compressed
+	// outside pg_dump, e.g. a .sql.gz
+	Compression string
+}
+
+func (f ArtifactFormat) String() string {
+	if f.Compression != "" {
+		return f.Kind + "+" + f.Compression
+	}
+	return f.Kind
+}
+
+// archive reports whether the artifact is restored with pg_restore rather than psql
+func (f ArtifactFormat) archive() bool {
+	return f.Kind != FormatPlain
+}
+
+// parallel reports whether pg_restore can use parallel workers on the artifact
+func (f ArtifactFormat) parallel() bool {
+	return f.Compression == "" && (f.Kind == FormatCustom || f.Kind == FormatDirectory)
+}
+
+// compressionMagic maps file signatures to the compressor that wrote them
+var compressionMagic = []struct {
+	magic []byte
+	name  string
+}{
+	{[]byte{0x1f, 0x8b}, "gzip"},
+	{[]byte{0x28, 0xb5, 0x2f, 0xfd}, "zstd"},
+	{[]byte{0x04, 0x22, 0x4d, 0x18}, "lz4"},
+}
+
+// sniffCompression returns the compressor whose signature starts header, if any
+func sniffCompression(header []byte) string {
+	for _, c := range compressionMagic {
+		if bytes.HasPrefix(header, c.magic) {
+			return c.name
+		}
+	}
+	return ""
+}
+
+// sniffKind identifies an uncompressed pg_dump file from its first bytes: custom
+// archives start with "PGDMP", tar archives carry "ustar" at offset 257, and
+// anything else is taken to be SQL
+func sniffKind(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, []byte("PGDMP")):
+		return FormatCustom
+	case len(header) >= 262 && bytes.Equal(header[257:262], []byte("ustar")):
+		return FormatTar
+	default:
+		return FormatPlain
+	}
+}
+
+// decompressCmd writes the decompressed contents of path to its stdout
+func decompressCmd(compression, path string) (*exec.Cmd, error) {
+	switch compression {
+	case "gzip", "zstd", "lz4":
+		return exec.Command(compression, "-d", "-c", path), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", compression)
+	}
+}
+
+// readHeader returns up to the first 512 bytes of r
+func readHeader(r io.Reader) ([]byte, error) {
+	header := make([]byte, 512)
+	n, err := io.ReadFull(r, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	return header[:n], nil
+}
+
+// DetectArtifactFormat identifies the format of a dump artifact from its contents
+// rather than its name. The index of a split dump is plain SQL; a directory holding
+// toc.dat is a directory-format archive.
+func DetectArtifactFormat(path string) (ArtifactFormat, error) {
+	if isPartsIndex(path) {
+		return ArtifactFormat{Kind: FormatPlain}, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return ArtifactFormat{}, fmt.Errorf("failed to inspect artifact: %w", err)
+	}
+	if info.IsDir() {
+		for _, toc := range []string{"toc.dat", "toc.dat.gz", "toc.dat.lz4", "toc.dat.zst"} {
+			if _, err := os.Stat(filepath.Join(path, toc)); err == nil {
+				return ArtifactFormat{Kind: FormatDirectory}, nil
+			}
+		}
+		return ArtifactFormat{}, fmt.Errorf("%s is a directory without a pg_dump toc.dat", path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return ArtifactFormat{}, fmt.Errorf("failed to open artifact: %w", err)
+	}
+	defer f.Close()
+	header, err := readHeader(f)
+	if err != nil {
+		return ArtifactFormat{}, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	compression := sniffCompression(header)
+	if compression == "" {
+		return ArtifactFormat{Kind: sniffKind(header)}, nil
+	}
+
+	// Look inside the compressed stream for what it holds
+	cmd, err := decompressCmd(compression, path)
+	if err != nil {
+		return ArtifactFormat{}, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return ArtifactFormat{}, err
+	}
+	if err := cmd.Start(); err != nil {
+		return ArtifactFormat{}, fmt.Errorf("failed to run %s: %w", compression, err)
+	}
+	inner, err := readHeader(stdout)
+	cmd.Process.Kill()
+	cmd.Wait()
+	if err != nil {
+		return ArtifactFormat{}, fmt.Errorf("failed to decompress %s: %w", path, err)
+	}
+	return ArtifactFormat{Kind: sniffKind(inner), Compression: compression}, nil
+}
+
+// detectStreamFormat identifies the format of an artifact read from a stream, such as
+// an encrypted artifact as it is decrypted. pg_restore reads only uncompressed custom
+// and tar archives from stdin, and compressed scripts aren't streamed through a
+// decompressor, so compressed artifacts are refused.
+func detectStreamFormat(path string, open func() (io.ReadCloser, error)) (ArtifactFormat, error) {
+	in, err := open()
+	if err != nil {
+		return ArtifactFormat{}, err
+	}
+	defer in.Close()
+	header, err := readHeader(in)
+	if err != nil {
+		return ArtifactFormat{}, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if compression := sniffCompression(header); compression != "" {
+		return ArtifactFormat{}, fmt.Errorf("%s holds a %s-compressed dump, which can't be restored from a stream", path, compression)
+	}
+	return ArtifactFormat{Kind: sniffKind(header)}, nil
+}
+
+// prepareArtifact detects an artifact's format. Compressed archives are decompressed
+// into a scratch file, since pg_restore needs a seekable file to list the contents or
+// run parallel workers; compressed SQL is left to be streamed into psql. The returned
+// cleanup removes any scratch file.
+func prepareArtifact(path string) (string, ArtifactFormat, func(), error) {
+	noop := func() {}
+	format, err := DetectArtifactFormat(path)
+	if err != nil {
+		return "", format, noop, err
+	}
+	if format.Compression == "" || !format.archive() {
+		return path, format, noop, nil
+	}
+
+	dir, err := os.MkdirTemp("", "pg_restore_fdw_")
+	if err != nil {
+		return "", format, noop, fmt.Errorf("failed to create scratch directory: %w", err)
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	plainPath := filepath.Join(dir, filepath.Base(path))
+	out, err := os.Create(plainPath)
+	if err != nil {
+		cleanup()
+		return "", format, noop, fmt.Errorf("failed to create decompressed copy: %w", err)
+	}
+	defer out.Close()
+	cmd, err := decompressCmd(format.Compression, path)
+	if err != nil {
+		cleanup()
+		return "", format, noop, err
+	}
+	cmd.Stdout = out
+	log.Printf("Decompressing %s (%s)", path, format)
+	if output, err := runStreaming(cmd, stepName("decompress", path), nil); err != nil {
+		cleanup()
+		return "", format, noop, fmt.Errorf("failed to decompress %s: %w\nOutput: %s", path, err, output)
+	}
+	return plainPath, ArtifactFormat{Kind: format.Kind}, cleanup, nil
+}
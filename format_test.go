@@ -0,0 +1,133 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSniffCompression(t *testing.T) {
+	cases := map[string][]byte{
+		"gzip": {0x1f, 0x8b, 0x08},
+		"zstd": {0x28, 0xb5, 0x2f, 0xfd, 0x00},
+		"lz4":  {0x04, 0x22, 0x4d, 0x18},
+		"":     []byte("PGDMP"),
+	}
+	for want, header := range cases {
+		if got := sniffCompression(header); got != want {
+			t.Errorf("sniffCompression(%x) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestDetectArtifactFormat(t *testing.T) {
+	dir := t.TempDir()
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	tw.WriteHeader(&tar.Header{Name: "toc.dat", Mode: 0644, Size: 3})
+	tw.Write([]byte("abc"))
+	tw.Close()
+
+	files := map[string][]byte{
+		"data.dump": []byte("PGDMP\x01\x0e\x00"),
+		"data.sql":  []byte("--\n-- PostgreSQL database dump\n"),
+		"data.tar":  archive.Bytes(),
+		"empty.sql": nil,
+	}
+	want := map[string]string{"data.dump": FormatCustom, "data.sql": FormatPlain, "data.tar": FormatTar, "empty.sql": FormatPlain}
+	for name, content := range files {
+		os.WriteFile(filepath.Join(dir, name), content, 0644)
+	}
+	os.Mkdir(filepath.Join(dir, "data.dir"), 0755)
+	os.WriteFile(filepath.Join(dir, "data.dir", "toc.dat"), []byte("PGDMP"), 0644)
+	want["data.dir"] = FormatDirectory
+
+	for name, kind := range want {
+		format, err := DetectArtifactFormat(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if format.Kind != kind || format.Compression != "" {
+			t.Errorf("%s detected as %s, want %s", name, format, kind)
+		}
+	}
+	if format := (ArtifactFormat{Kind: FormatTar}); format.parallel() || !format.archive() {
+		t.Error("tar archives should restore with pg_restore and no parallel workers")
+	}
+}
+
+func TestSectionArtifactNameTar(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "tenant_post-data.tar"), nil, 0644)
+	if got := sectionArtifactName(dir, "tenant", "post-data"); got != "tenant_post-data.tar" {
+		t.Errorf("tar post-data = %s", got)
+	}
+	if got := sectionArtifactName(dir, "tenant", "data"); got != "tenant_data.dump" {
+		t.Errorf("custom data = %s", got)
+	}
+}
+
+func TestDecompressedScriptConsumer(t *testing.T) {
+	if _, err := exec.LookPath("gzip"); err != nil {
+		t.Skip("gzip not installed")
+	}
+
+	// Far more than a pipe buffer, so the decompressor blocks if nobody reads
+	path := filepath.Join(t.TempDir(), "tenant_data.sql.gz")
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write([]byte(strings.Repeat("INSERT INTO customer_transactions VALUES (1);\n", 100000)))
+	zw.Close()
+	if err := os.WriteFile(path, compressed.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		script  string
+		wantErr bool
+	}{
+		{"consumer reads everything", "cat > /dev/null", false},
+		{"consumer fails without reading", "exit 3", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			producer, err := decompressCmd("gzip", path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			script, err := producer.StdoutPipe()
+			if err != nil {
+				t.Fatal(err)
+			}
+			consumer := exec.Command("sh", "-c", tt.script)
+			consumer.Stdin = script
+			if err := producer.Start(); err != nil {
+				t.Fatal(err)
+			}
+			consumerErr := consumer.Run()
+			if (consumerErr != nil) != tt.wantErr {
+				t.Fatalf("consumer error = %v, want error %v", consumerErr, tt.wantErr)
+			}
+
+			done := make(chan error, 1)
+			go func() { done <- finishProducer(producer, script, consumerErr) }()
+			select {
+			case err := <-done:
+				if !tt.wantErr && err != nil {
+					t.Errorf("decompressor failed after a clean read: %v", err)
+				}
+			case <-time.After(10 * time.Second):
+				producer.Process.Kill()
+				t.Fatal("decompressor stayed blocked after psql exited")
+			}
+		})
+	}
+}
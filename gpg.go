@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// gpgExt is appended to artifacts encrypted with GPG
+const gpgExt = ".gpg"
+
+// GPGConfig encrypts artifacts to GPG recipients and signs them with an operator key
+type GPGConfig struct {
+	// Recipients are key IDs, fingerprints, or emails allowed to decrypt the dump set
+	Recipients []string `json:"recipients"`
+	// SignKey signs each artifact and the manifest; empty disables signing
+	SignKey string `json:"sign_key"`
+	// TrustedSigners are fingerprints accepted on restore; when set, unsigned or
+	// foreign-signed artifacts are refused
+	TrustedSigners []string `json:"trusted_signers"`
+	// Homedir overrides the GnuPG home directory
+	Homedir string `json:"homedir"`
+	// ScratchDir receives decrypted copies during restore; defaults to the system temp dir
+	ScratchDir string `json:"scratch_dir"`
+}
+
+// gpgCmd builds a non-interactive gpg command
+func (g *GPGConfig) gpgCmd(args ...string) *exec.Cmd {
+	baseArgs := []string{"--batch", "--yes", "--no-tty"}
+	if g.Homedir != "" {
+		baseArgs = append(baseArgs, "--homedir", g.Homedir)
+	}
+	return exec.Command("gpg", append(baseArgs, args...)...)
+}
+
+func (g *GPGConfig) ext() string { return gpgExt }
+
+func (g *GPGConfig) scratchDir() string { return g.ScratchDir }
+
+func (g *GPGConfig) keyRef() string {
+	return "gpg:" + strings.Join(g.Recipients, ",")
+}
+
+// encryptArgs returns the gpg arguments encrypting to the recipients, signing when a
+// sign key is configured
+func (g *GPGConfig) encryptArgs(outputFile string) []string {
+	args := []string{"--encrypt", "--output", outputFile}
+	for _, recipient := range g.Recipients {
+		args = append(args, "--recipient", recipient)
+	}
+	if g.SignKey != "" {
+		args = append(args, "--sign", "--local-user", g.SignKey)
+	}
+	return args
+}
+
+// encode pipes cmd's stdout through gpg, encrypting to the recipients and signing when configured
+func (g *GPGConfig) encode(cmd *exec.Cmd, outputFile, step string) error {
+	if len(g.Recipients) == 0 {
+		return fmt.Errorf("gpg encryption requires at least one recipient")
+	}
+
+	gpg := g.gpgCmd(g.encryptArgs(outputFile)...)
+
+	// A bandwidth limit needs the output to pass through this process on its way to gpg
+	var gpgIn io.WriteCloser
+	if activeThrottle.limitsBandwidth() {
+		var err error
+		if gpgIn, err = gpg.StdinPipe(); err != nil {
+			return fmt.Errorf("failed to connect command to gpg: %w", err)
+		}
+		cmd.Stdout = activeThrottle.limit(gpgIn)
+	} else {
+		pipe, err := cmd.StdoutPipe()
+		if err != nil {
+			return fmt.Errorf("failed to connect command to gpg: %w", err)
+		}
+		gpg.Stdin = pipe
+	}
+
+	var gpgOutput strings.Builder
+	gpg.Stdout = &gpgOutput
+	gpg.Stderr = &gpgOutput
+
+	if err := gpg.Start(); err != nil {
+		return fmt.Errorf("failed to start gpg: %w", err)
+	}
+	output, err := runStreaming(cmd, step, nil)
+	if gpgIn != nil {
+		gpgIn.Close()
+	}
+	if err != nil {
+		gpg.Wait()
+		log.Printf("Error dumping database section: %s", output)
+		return fmt.Errorf("failed to dump database section: %w", err)
+	}
+	if err := gpg.Wait(); err != nil {
+		return fmt.Errorf("gpg encryption failed: %w\nOutput: %s", err, gpgOutput.String())
+	}
+	return nil
+}
+
+// decodeFile decrypts an artifact and verifies its signature against the trusted signers
+func (g *GPGConfig) decodeFile(src, dst string) error {
+	status, stderr, err := g.runWithStatus("--decrypt", "--output", dst, src)
+	if err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("gpg decryption of %s failed: %w\nOutput: %s", src, err, stderr)
+	}
+
+	if err := g.checkSigner(src, status); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return nil
+}
+
+// SignFile writes an armored detached signature next to path
+func (g *GPGConfig) SignFile(path string) error {
+	if g.SignKey == "" {
+		return nil
+	}
+	cmd := g.gpgCmd("--detach-sign", "--armor", "--local-user", g.SignKey, "--output", path+".asc", path)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to sign %s: %w\nOutput: %s", filepath.Base(path), err, output)
+	}
+	return nil
+}
+
+// VerifyFile checks the detached signature next to path against the trusted signers
+func (g *GPGConfig) VerifyFile(path string) error {
+	if len(g.TrustedSigners) == 0 {
+		return nil
+	}
+	status, stderr, err := g.runWithStatus("--verify", path+".asc", path)
+	if err != nil {
+		return fmt.Errorf("signature verification of %s failed: %w\nOutput: %s", filepath.Base(path), err, stderr)
+	}
+	return g.checkSigner(path, status)
+}
+
+// runWithStatus runs gpg with its machine-readable status lines written to a private
+// file of their own, so nothing gpg prints to stdout or stderr can pass for a status
+// line. A file rather than an inherited descriptor works on Windows as well.
+func (g *GPGConfig) runWithStatus(args ...string) (status, stderr string, err error) {
+	statusFile, err := os.CreateTemp("", "pg_restore_fdw_gpg_status_")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create gpg status file: %w", err)
+	}
+	statusFile.Close()
+	defer os.Remove(statusFile.Name())
+
+	cmd := g.gpgCmd(append([]string{"--status-file", statusFile.Name()}, args...)...)
+	var errOutput strings.Builder
+	cmd.Stdout = io.Discard
+	cmd.Stderr = &errOutput
+
+	runErr := cmd.Run()
+	statusOutput, readErr := os.ReadFile(statusFile.Name())
+	if runErr != nil {
+		return string(statusOutput), errOutput.String(), runErr
+	}
+	if readErr != nil {
+		return "", errOutput.String(), fmt.Errorf("failed to read gpg status: %w", readErr)
+	}
+	return string(statusOutput), errOutput.String(), nil
+}
+
+// validate requires every trusted signer to be a full fingerprint; a short key ID
+// can be matched by a key generated to collide with it
+func (g *GPGConfig) validate() error {
+	for _, trusted := range g.TrustedSigners {
+		if !isFullFingerprint(normalizeFingerprint(trusted)) {
+			return fmt.Errorf("trusted signer %q is not a full 40-hex-digit fingerprint", trusted)
+		}
+	}
+	return nil
+}
+
+// normalizeFingerprint uppercases a fingerprint and drops spaces and a 0x prefix
+func normalizeFingerprint(fpr string) string {
+	fpr = strings.ToUpper(strings.ReplaceAll(fpr, " ", ""))
+	return strings.TrimPrefix(fpr, "0X")
+}
+
+// isFullFingerprint reports whether a normalized fingerprint is a v4 key's 40 hex digits
+func isFullFingerprint(fpr string) bool {
+	if len(fpr) != 40 {
+		return false
+	}
+	for _, c := range fpr {
+		if !strings.ContainsRune("0123456789ABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// gpgSignature is a valid signature's signing key and, for a subkey, its primary key
+type gpgSignature struct {
+	Fingerprint string
+	Primary     string
+}
+
+// checkSigner requires a VALIDSIG status line whose signing key or primary key is a
+// trusted fingerprint
+func (g *GPGConfig) checkSigner(path, status string) error {
+	if len(g.TrustedSigners) == 0 {
+		return nil
+	}
+
+	signatures := parseGPGValidSigs(status)
+	var signers []string
+	for _, sig := range signatures {
+		for _, trusted := range g.TrustedSigners {
+			trusted = normalizeFingerprint(trusted)
+			if !isFullFingerprint(trusted) {
+				continue
+			}
+			if sig.Fingerprint == trusted || sig.Primary == trusted {
+				log.Printf("Verified %s signed by %s", filepath.Base(path), sig.Fingerprint)
+				return nil
+			}
+		}
+		signers = append(signers, sig.Fingerprint)
+	}
+
+	if len(signatures) == 0 {
+		return fmt.Errorf("%s is not signed; refusing to restore unsigned artifacts", filepath.Base(path))
+	}
+	return fmt.Errorf("%s is signed by untrusted key(s) %s", filepath.Base(path), strings.Join(signers, ", "))
+}
+
+// parseGPGValidSigs extracts the signing and primary key fingerprints from gpg
+// --status-fd output. VALIDSIG names the signing key, which may be a subkey, and ends
+// with the primary key's fingerprint.
+func parseGPGValidSigs(status string) []gpgSignature {
+	var signatures []gpgSignature
+	scanner := bufio.NewScanner(strings.NewReader(status))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 3 && fields[0] == "[GNUPG:]" && fields[1] == "VALIDSIG" {
+			sig := gpgSignature{Fingerprint: normalizeFingerprint(fields[2])}
+			if len(fields) >= 12 {
+				sig.Primary = normalizeFingerprint(fields[len(fields)-1])
+			}
+			signatures = append(signatures, sig)
+		}
+	}
+	return signatures
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGPGEncryptArgs(t *testing.T) {
+	tests := []struct {
+		config GPGConfig
+		want   []string
+	}{
+		{
+			GPGConfig{Recipients: []string{"ops@example.com"}},
+			[]string{"--encrypt", "--output", "out.gpg", "--recipient", "ops@example.com"},
+		},
+		{
+			GPGConfig{Recipients: []string{"ops@example.com", "0xABCD"}, SignKey: "release"},
+			[]string{"--encrypt", "--output", "out.gpg", "--recipient", "ops@example.com", "--recipient", "0xABCD", "--sign", "--local-user", "release"},
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.config.encryptArgs("out.gpg"); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("encryptArgs(%+v) = %q, want %q", tt.config, got, tt.want)
+		}
+	}
+}
+
+func TestGPGCmd(t *testing.T) {
+	g := &GPGConfig{Homedir: "/etc/pg_restore_fdw/gnupg"}
+	want := []string{"gpg", "--batch", "--yes", "--no-tty", "--homedir", "/etc/pg_restore_fdw/gnupg", "--decrypt", "in.gpg"}
+	if got := g.gpgCmd("--decrypt", "in.gpg").Args; !reflect.DeepEqual(got, want) {
+		t.Errorf("gpgCmd args = %q, want %q", got, want)
+	}
+	if got := (&GPGConfig{Recipients: []string{"a", "b"}}).keyRef(); got != "gpg:a,b" {
+		t.Errorf("keyRef = %q", got)
+	}
+}
+
+func TestGPGCheckSigner(t *testing.T) {
+	status := `[GNUPG:] NEWSIG
+[GNUPG:] GOODSIG 1234567890ABCDEF Release Key
+[GNUPG:] VALIDSIG 0123456789abcdef0123456789abcdef01234567 2026-10-01 1759300000 0 4 0 1 10 00 0123456789ABCDEF0123456789ABCDEF01234567
+`
+	want := []gpgSignature{{"0123456789ABCDEF0123456789ABCDEF01234567", "0123456789ABCDEF0123456789ABCDEF01234567"}}
+	if got := parseGPGValidSigs(status); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseGPGValidSigs = %+v", got)
+	}
+
+	// Signed by a subkey; the primary key's fingerprint ends the line
+	subkeyStatus := "[GNUPG:] VALIDSIG AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA 2026-10-01 1759300000 0 4 0 1 10 00 0123456789ABCDEF0123456789ABCDEF01234567\n"
+
+	tests := []struct {
+		trusted []string
+		status  string
+		wantErr bool
+	}{
+		{nil, "", false},
+		{[]string{"0123 4567 89AB CDEF 0123  4567 89AB CDEF 0123 4567"}, status, false},
+		{[]string{"0x0123456789abcdef0123456789abcdef01234567"}, status, false},
+		{[]string{"0123456789ABCDEF0123456789ABCDEF01234567"}, subkeyStatus, false},
+		{[]string{"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"}, subkeyStatus, false},
+		{[]string{"89abcdef01234567"}, status, true},
+		{[]string{"FFFFFFFF0123456789ABCDEF0123456789ABCDEF01234567"}, status, true},
+		{[]string{"FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF"}, status, true},
+		{[]string{"0123456789ABCDEF0123456789ABCDEF01234567"}, "", true},
+	}
+	for _, tt := range tests {
+		g := &GPGConfig{TrustedSigners: tt.trusted}
+		if err := g.checkSigner("moodys_data.dump.gpg", tt.status); (err != nil) != tt.wantErr {
+			t.Errorf("trusted %q: error = %v, want error %v", tt.trusted, err, tt.wantErr)
+		}
+	}
+}
+
+func TestGPGValidate(t *testing.T) {
+	tests := []struct {
+		trusted []string
+		wantErr bool
+	}{
+		{nil, false},
+		{[]string{"0123 4567 89AB CDEF 0123  4567 89AB CDEF 0123 4567"}, false},
+		{[]string{"0x0123456789abcdef0123456789abcdef01234567"}, false},
+		{[]string{"89ABCDEF01234567"}, true},
+		{[]string{"0123456789ABCDEF0123456789ABCDEF0123456Z"}, true},
+		{[]string{"ops@example.com"}, true},
+	}
+	for _, tt := range tests {
+		g := &GPGConfig{TrustedSigners: tt.trusted}
+		if err := g.validate(); (err != nil) != tt.wantErr {
+			t.Errorf("trusted %q: error = %v, want error %v", tt.trusted, err, tt.wantErr)
+		}
+	}
+}
+
+func TestGPGStatusFile(t *testing.T) {
+	// A fake gpg that prints a forged status line on stdout and stderr and the real
+	// one to its --status-file
+	dir := t.TempDir()
+	script := `#!/bin/sh
+while [ "$1" != "--status-file" ]; do shift; done
+echo "[GNUPG:] VALIDSIG 0123456789ABCDEF0123456789ABCDEF01234567 x"
+echo "[GNUPG:] VALIDSIG 0123456789ABCDEF0123456789ABCDEF01234567 x" >&2
+echo "[GNUPG:] VALIDSIG FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF 2026-10-01 1759300000 0 4 0 1 10 00 FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF" >"$2"
+`
+	if err := os.WriteFile(filepath.Join(dir, "gpg"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+
+	g := &GPGConfig{TrustedSigners: []string{"0123456789ABCDEF0123456789ABCDEF01234567"}}
+	status, stderr, err := g.runWithStatus("--verify", "manifest.json.asc", "manifest.json")
+	if err != nil {
+		t.Fatalf("runWithStatus: %v", err)
+	}
+	if !strings.Contains(status, "VALIDSIG FFFFFFFF") {
+		t.Errorf("status = %q, want the line written to the status file", status)
+	}
+	if !strings.Contains(stderr, "VALIDSIG") {
+		t.Errorf("stderr = %q, want the forged line kept for error messages", stderr)
+	}
+	if err := g.checkSigner("manifest.json", status); err == nil {
+		t.Error("lines outside the status file should not be parsed as status")
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"text/template"
+)
+
+// GrantTemplate renders GRANT statements for the schemas of a restored database, since
+// restores run with --no-privileges
+type GrantTemplate struct {
+	// Database is "moodys" or "tenant"; empty applies to both
+	Database string `json:"database"`
+	// Schemas are path.Match patterns selecting schemas; empty selects every user schema
+	Schemas []string `json:"schemas"`
+	// Statements are text/template strings rendered once per selected schema, e.g.
+	// "GRANT SELECT ON ALL TABLES IN SCHEMA {{.Schema}} TO app_ro". Identical
+	// statements are run once.
+	Statements []string `json:"statements"`
+}
+
+// GrantData is available to grant templates
+type GrantData struct {
+	// Database is the destination database name, quoted as an identifier
+	Database string
+	// Schema is the schema name, quoted as an identifier
+	Schema string
+}
+
+// validateGrants parses every template and checks its database
+func validateGrants(grants []GrantTemplate) error {
+	for i, g := range grants {
+		if g.Database != "" && g.Database != "moodys" && g.Database != "tenant" {
+			return fmt.Errorf("grant template %d: unknown database %q", i+1, g.Database)
+		}
+		for _, pattern := range g.Schemas {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("grant template %d: invalid schema pattern %q: %w", i+1, pattern, err)
+			}
+		}
+		for _, stmt := range g.Statements {
+			if _, err := template.New("grant").Option("missingkey=error").Parse(stmt); err != nil {
+				return fmt.Errorf("grant template %d: invalid statement %q: %w", i+1, stmt, err)
+			}
+		}
+	}
+	return nil
+}
+
+// schemaSelected reports whether a schema matches any pattern; no patterns select all
+func schemaSelected(patterns []string, schema string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if matched, _ := path.Match(p, schema); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// renderGrants renders the statements of the templates that apply to database for the
+// given schemas of the destination database dbName
+func renderGrants(grants []GrantTemplate, database, dbName string, schemas []string) ([]string, error) {
+	var statements []string
+	seen := make(map[string]bool)
+	for _, g := range grants {
+		if g.Database != "" && g.Database != database {
+			continue
+		}
+		for _, schema := range schemas {
+			if !schemaSelected(g.Schemas, schema) {
+				continue
+			}
+			data := GrantData{Database: quoteIdent(dbName), Schema: quoteIdent(schema)}
+			for _, text := range g.Statements {
+				tmpl, err := template.New("grant").Option("missingkey=error").Parse(text)
+				if err != nil {
+					return nil, fmt.Errorf("invalid grant statement %q: %w", text, err)
+				}
+				var b strings.Builder
+				if err := tmpl.Execute(&b, data); err != nil {
+					return nil, fmt.Errorf("failed to render grant statement %q: %w", text, err)
+				}
+				stmt := strings.TrimSuffix(strings.TrimSpace(b.String()), ";") + ";"
+				if !seen[stmt] {
+					seen[stmt] = true
+					statements = append(statements, stmt)
+				}
+			}
+		}
+	}
+	return statements, nil
+}
+
+// ApplyGrants renders the grant templates for each destination database and runs the
+// statements in one transaction per database. With dryRun the statements are only printed.
+func ApplyGrants(grants []GrantTemplate, dests map[string]DBConfig, dryRun bool) error {
+	for _, database := range []string{"moodys", "tenant"} {
+		config, ok := dests[database]
+		if !ok {
+			continue
+		}
+		output, err := newPsqlCmd(config, "-t", "-A", "-c", userSchemaQuery).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("failed to list schemas of %s: %w, output: %s", config.DBName, err, output)
+		}
+		statements, err := renderGrants(grants, database, config.DBName, strings.Fields(string(output)))
+		if err != nil {
+			return err
+		}
+		if len(statements) == 0 {
+			continue
+		}
+
+		if dryRun {
+			alwaysLog.Printf("Grants for %s (dry run):\n%s", config.DBName, strings.Join(statements, "\n"))
+			continue
+		}
+		log.Printf("Applying %d grant statements to %s", len(statements), config.DBName)
+		cmd := newPsqlCmd(config, "-v", "ON_ERROR_STOP=1", "--single-transaction")
+		cmd.Stdin = strings.NewReader(strings.Join(statements, "\n") + "\n")
+		if output, err := runStreaming(cmd, "grants_"+config.DBName, nil); err != nil {
+			return fmt.Errorf("failed to apply grants to %s: %w\nOutput: %s", config.DBName, err, output)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRenderGrants(t *testing.T) {
+	grants := []GrantTemplate{
+		{Statements: []string{"GRANT USAGE ON SCHEMA {{.Schema}} TO app_ro", "GRANT CONNECT ON DATABASE {{.Database}} TO app_ro"}},
+		{Database: "tenant", Schemas: []string{"report*"}, Statements: []string{"GRANT SELECT ON ALL TABLES IN SCHEMA {{.Schema}} TO analyst;"}},
+		{Database: "moodys", Statements: []string{"GRANT SELECT ON ALL TABLES IN SCHEMA {{.Schema}} TO moodys_ro"}},
+	}
+	got, err := renderGrants(grants, "tenant", "tenant_dest", []string{"public", "reporting"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`GRANT USAGE ON SCHEMA "public" TO app_ro;`,
+		`GRANT CONNECT ON DATABASE "tenant_dest" TO app_ro;`,
+		`GRANT USAGE ON SCHEMA "reporting" TO app_ro;`,
+		`GRANT SELECT ON ALL TABLES IN SCHEMA "reporting" TO analyst;`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q\nwant %q", got, want)
+	}
+	if err := validateGrants([]GrantTemplate{{Statements: []string{"GRANT {{.Schema"}}}); err == nil {
+		t.Error("expected an unparsable template to be rejected")
+	}
+}
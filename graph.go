@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// topoSort orders nodes so every node follows its dependencies, keeping the input
+// order among nodes that are ready at the same time. Dependencies outside nodes are
+// ignored. A cycle is reported with the nodes that could not be ordered.
+func topoSort(nodes []string, deps map[string][]string) ([]string, error) {
+	known := make(map[string]bool, len(nodes))
+	for _, n := range nodes {
+		known[n] = true
+	}
+
+	pending := make(map[string]int, len(nodes))
+	dependents := make(map[string][]string)
+	for _, n := range nodes {
+		for _, d := range deps[n] {
+			if !known[d] || d == n {
+				continue
+			}
+			pending[n]++
+			dependents[d] = append(dependents[d], n)
+		}
+	}
+
+	ordered := make([]string, 0, len(nodes))
+	done := make(map[string]bool, len(nodes))
+	for len(ordered) < len(nodes) {
+		progressed := false
+		for _, n := range nodes {
+			if done[n] || pending[n] > 0 {
+				continue
+			}
+			done[n] = true
+			ordered = append(ordered, n)
+			for _, dep := range dependents[n] {
+				pending[dep]--
+			}
+			progressed = true
+		}
+		if !progressed {
+			var cycle []string
+			for _, n := range nodes {
+				if !done[n] {
+					cycle = append(cycle, n)
+				}
+			}
+			return nil, fmt.Errorf("dependency cycle among: %s", strings.Join(cycle, ", "))
+		}
+	}
+	return ordered, nil
+}
+
+// Task is a unit of work in a dependency graph. Run gets the span its own spans belong
+// under, since concurrent tasks can't rely on the innermost open span.
+type Task struct {
+	Name      string
+	DependsOn []string
+	Run       func(parent *Span) error
+}
+
+// runTasks runs each task once its dependencies have succeeded, running tasks that don't
+// depend on each other concurrently. After a failure no further tasks start; running
+// tasks are waited for and the first error is returned. Tasks' spans belong under the
+// span open when runTasks is called.
+func runTasks(tasks []Task) error {
+	names := make([]string, 0, len(tasks))
+	deps := make(map[string][]string, len(tasks))
+	byName := make(map[string]Task, len(tasks))
+	for _, t := range tasks {
+		if _, dup := byName[t.Name]; dup {
+			return fmt.Errorf("duplicate task %s", t.Name)
+		}
+		names = append(names, t.Name)
+		deps[t.Name] = t.DependsOn
+		byName[t.Name] = t
+	}
+	for _, t := range tasks {
+		for _, d := range t.DependsOn {
+			if _, ok := byName[d]; !ok {
+				return fmt.Errorf("task %s depends on unknown task %s", t.Name, d)
+			}
+		}
+	}
+	if _, err := topoSort(names, deps); err != nil {
+		return err
+	}
+
+	type result struct {
+		name string
+		err  error
+	}
+	results := make(chan result)
+	pending := make(map[string]int, len(tasks))
+	for _, t := range tasks {
+		pending[t.Name] = len(t.DependsOn)
+	}
+	started := make(map[string]bool, len(tasks))
+	parent := currentSpan()
+	defer freezeSpans()()
+
+	running := 0
+	var firstErr error
+	start := func() {
+		for _, name := range names {
+			if started[name] || pending[name] > 0 {
+				continue
+			}
+			started[name] = true
+			running++
+			go func(t Task) {
+				results <- result{t.Name, t.Run(parent)}
+			}(byName[name])
+		}
+	}
+
+	start()
+	for running > 0 {
+		r := <-results
+		running--
+		if r.err != nil {
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			continue
+		}
+		for _, name := range names {
+			for _, d := range deps[name] {
+				if d == r.name {
+					pending[name]--
+				}
+			}
+		}
+		if firstErr == nil {
+			start()
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestTopoSort(t *testing.T) {
+	nodes := []string{"c", "a", "b", "d"}
+	deps := map[string][]string{
+		"c": {"b"},
+		"b": {"a", "external"},
+	}
+	got, err := topoSort(nodes, deps)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"a", "b", "d", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	deps["a"] = []string{"c"}
+	if _, err := topoSort(nodes, deps); err == nil {
+		t.Error("expected cycle error")
+	}
+}
+
+func TestRunTasks(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+	record := func(name string, err error) func(*Span) error {
+		return func(*Span) error {
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			return err
+		}
+	}
+
+	tasks := []Task{
+		{Name: "tenant_data", DependsOn: []string{"tenant_pre"}, Run: record("tenant_data", nil)},
+		{Name: "moodys_pre", Run: record("moodys_pre", nil)},
+		{Name: "tenant_pre", DependsOn: []string{"moodys_pre"}, Run: record("tenant_pre", nil)},
+	}
+	if err := runTasks(tasks); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(order, []string{"moodys_pre", "tenant_pre", "tenant_data"}) {
+		t.Errorf("order = %v", order)
+	}
+
+	order = nil
+	failing := errors.New("boom")
+	tasks[1].Run = record("moodys_pre", failing)
+	if err := runTasks(tasks); err != failing {
+		t.Errorf("err = %v, want %v", err, failing)
+	}
+	if !reflect.DeepEqual(order, []string{"moodys_pre"}) {
+		t.Errorf("dependents of a failed task ran: %v", order)
+	}
+
+	tasks[1].DependsOn = []string{"tenant_data"}
+	if err := runTasks(tasks); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("expected a cycle error, got %v", err)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Health statuses, in the order of their Nagios plugin exit codes 0 to 3
+const (
+	HealthOK       = "OK"
+	HealthWarning  = "WARNING"
+	HealthCritical = "CRITICAL"
+	HealthUnknown  = "UNKNOWN"
+)
+
+var healthExitCodes = map[string]int{HealthOK: 0, HealthWarning: 1, HealthCritical: 2, HealthUnknown: 3}
+
+// clientTools are the PostgreSQL client commands every run needs
+var clientTools = []string{"psql", "pg_dump", "pg_restore"}
+
+// clientVersionPattern finds the version in e.g. "pg_dump (PostgreSQL) 16.2 (Debian 16.2-1)"
+var clientVersionPattern = regexp.MustCompile(`\(PostgreSQL\) (\d+)`)
+
+// HealthCheck is the result of one check
+type HealthCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+}
+
+// HealthReport is what healthcheck prints: the worst status of its checks, and each
+// check, for schedulers that gate refresh jobs on it
+type HealthReport struct {
+	Status string        `json:"status"`
+	Checks []HealthCheck `json:"checks"`
+}
+
+// ExitCode returns the Nagios plugin exit code of the report's status
+func (r *HealthReport) ExitCode() int {
+	return healthExitCodes[r.Status]
+}
+
+// Write writes the report as JSON
+func (r *HealthReport) Write(w io.Writer) error {
+	content, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(content))
+	return err
+}
+
+// add records a check, raising the report's status to it when it is worse
+func (r *HealthReport) add(name, status, detail string) {
+	r.Checks = append(r.Checks, HealthCheck{Name: name, Status: status, Detail: detail})
+	if r.Status == "" || healthExitCodes[status] > healthExitCodes[r.Status] {
+		r.Status = status
+	}
+}
+
+// parseClientMajor returns the major version of a client tool's --version output
+func parseClientMajor(output string) (int, error) {
+	m := clientVersionPattern.FindStringSubmatch(output)
+	if m == nil {
+		return 0, fmt.Errorf("unrecognized version %q", strings.TrimSpace(output))
+	}
+	return strconv.Atoi(m[1])
+}
+
+// checkClientCompatibility compares the client tools' major versions with the source
+// servers': pg_dump refuses to dump a newer server, and pg_restore can't read archives
+// written by a newer pg_dump
+func checkClientCompatibility(r *HealthReport, clients map[string]int, sourceMajors map[string]int) {
+	dump, restore := clients["pg_dump"], clients["pg_restore"]
+	if dump == 0 {
+		return
+	}
+	var names []string
+	for name := range sourceMajors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if major := sourceMajors[name]; major > dump {
+			r.add("compatibility "+name, HealthCritical, fmt.Sprintf("pg_dump %d can't dump %s, which runs PostgreSQL %d", dump, name, major))
+		} else {
+			r.add("compatibility "+name, HealthOK, fmt.Sprintf("pg_dump %d can dump PostgreSQL %d", dump, major))
+		}
+	}
+	if restore != 0 && restore < dump {
+		r.add("compatibility pg_restore", HealthCritical, fmt.Sprintf("pg_restore %d can't read archives written by pg_dump %d", restore, dump))
+	}
+}
+
+// probeServerVersion returns the server's version like serverVersionNum, but gives up
+// on a server that doesn't answer rather than hanging the check
+func probeServerVersion(config DBConfig) (int, error) {
+	cmd := pgCommand("psql", config).Param("connect_timeout", "10").Arg("-t", "-A", "-c", "SHOW server_version_num;").Cmd()
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("failed to connect: %w, output: %s", err, strings.TrimSpace(string(output)))
+	}
+	return strconv.Atoi(strings.TrimSpace(string(output)))
+}
+
+// RunHealthCheck checks that the sources and the other databases accept connections,
+// that the client tools are installed and can dump the sources, and that storage is
+// reachable when configured. Destinations belong among the others as their maintenance
+// database, since the databases themselves may not exist until the first restore.
+func RunHealthCheck(sources, others map[string]DBConfig, store *ObjectStore) *HealthReport {
+	report := &HealthReport{}
+
+	var names []string
+	configs := make(map[string]DBConfig)
+	for _, group := range []map[string]DBConfig{sources, others} {
+		for name, config := range group {
+			names, configs[name] = append(names, name), config
+		}
+	}
+	sort.Strings(names)
+	versions := make([]int, len(names))
+	errs := make([]error, len(names))
+	runConcurrently(len(names), len(names), func(i int) error {
+		versions[i], errs[i] = probeServerVersion(configs[names[i]])
+		return nil
+	})
+	sourceMajors := make(map[string]int)
+	for i, name := range names {
+		if errs[i] != nil {
+			report.add("connect "+name, HealthCritical, errs[i].Error())
+			continue
+		}
+		report.add("connect "+name, HealthOK, fmt.Sprintf("%s, PostgreSQL %d", describeDB(configs[name]), majorVersion(versions[i])))
+		if _, ok := sources[name]; ok {
+			sourceMajors[name] = majorVersion(versions[i])
+		}
+	}
+
+	clients := make(map[string]int)
+	for _, tool := range clientTools {
+		cmd := exec.Command(tool, "--version")
+		cmd.Env = subprocessEnv()
+		output, err := cmd.Output()
+		if err != nil {
+			report.add("client "+tool, HealthCritical, fmt.Sprintf("%s --version failed: %v", tool, err))
+			continue
+		}
+		major, err := parseClientMajor(string(output))
+		if err != nil {
+			report.add("client "+tool, HealthUnknown, err.Error())
+			continue
+		}
+		clients[tool] = major
+		report.add("client "+tool, HealthOK, strings.TrimSpace(string(output)))
+	}
+	checkClientCompatibility(report, clients, sourceMajors)
+
+	if store != nil {
+		if err := store.run(nil, "head-bucket", "--bucket", store.bucket); err != nil {
+			report.add("storage", HealthCritical, err.Error())
+		} else {
+			report.add("storage", HealthOK, store.config.URL+" is reachable")
+		}
+	}
+
+	for _, c := range report.Checks {
+		if c.Status != HealthOK {
+			log.Printf("%s %s: %s", c.Status, c.Name, c.Detail)
+		}
+	}
+	return report
+}
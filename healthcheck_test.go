@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestParseClientMajor(t *testing.T) {
+	for output, want := range map[string]int{
+		"pg_dump (PostgreSQL) 16.2 (Debian 16.2-1.pgdg120+2)\n": 16,
+		"psql (PostgreSQL) 9.6.24\n":                            9,
+	} {
+		if got, err := parseClientMajor(output); err != nil || got != want {
+			t.Errorf("%q: got %d, %v, want %d", output, got, err, want)
+		}
+	}
+	if _, err := parseClientMajor("something else"); err == nil {
+		t.Error("expected an error for unrecognized output")
+	}
+}
+
+func TestHealthReportStatus(t *testing.T) {
+	r := &HealthReport{}
+	r.add("connect source_moodys", HealthOK, "")
+	checkClientCompatibility(r, map[string]int{"pg_dump": 15, "pg_restore": 15}, map[string]int{"source_moodys": 16})
+	if r.Status != HealthCritical || r.ExitCode() != 2 {
+		t.Errorf("pg_dump older than a source: got %s, exit %d", r.Status, r.ExitCode())
+	}
+
+	r = &HealthReport{}
+	checkClientCompatibility(r, map[string]int{"pg_dump": 16, "pg_restore": 16}, map[string]int{"source_moodys": 14})
+	if r.Status != HealthOK || r.ExitCode() != 0 {
+		t.Errorf("compatible versions: got %s, exit %d", r.Status, r.ExitCode())
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HistoryConfig names the Postgres database run reports are kept in for trend analysis
+type HistoryConfig struct {
+	DBConfig
+	// Schema holds the runs table; defaults to pg_restore_fdw
+	Schema string `json:"schema"`
+	// BaselineRuns is how many recent successful runs form the baseline; defaults to 10
+	BaselineRuns int `json:"baseline_runs"`
+	// Threshold is the relative deviation from the baseline that raises an alert;
+	// defaults to 0.5 (50%)
+	Threshold float64 `json:"threshold"`
+}
+
+// withDefaults fills unset settings
+func (c HistoryConfig) withDefaults() HistoryConfig {
+	if c.Schema == "" {
+		c.Schema = "pg_restore_fdw"
+	}
+	if c.BaselineRuns <= 0 {
+		c.BaselineRuns = 10
+	}
+	if c.Threshold <= 0 {
+		c.Threshold = 0.5
+	}
+	return c
+}
+
+// table returns the quoted name of the runs table
+func (c HistoryConfig) table() string {
+	return quoteIdent(c.Schema) + ".runs"
+}
+
+// RunMetrics are the figures of a run that are tracked over time
+type RunMetrics struct {
+	DumpBytes      int64
+	DumpSeconds    float64
+	RestoreSeconds float64
+	TotalSeconds   float64
+}
+
+// runMetrics extracts the tracked figures from a finished report
+func runMetrics(r *RunReport, dumpBytes int64) RunMetrics {
+	m := RunMetrics{DumpBytes: dumpBytes, TotalSeconds: r.FinishedAt.Sub(r.StartedAt).Seconds()}
+	for _, phase := range r.Phases {
+		d, err := time.ParseDuration(phase.Duration)
+		if err != nil {
+			continue
+		}
+		switch phase.Name {
+		case "dump":
+			m.DumpSeconds = d.Seconds()
+		case "restore":
+			m.RestoreSeconds = d.Seconds()
+		}
+	}
+	return m
+}
+
+// deviations describes each metric that differs from the baseline by more than threshold.
+// Metrics missing from either side are not compared.
+func deviations(current, baseline RunMetrics, threshold float64) []string {
+	var out []string
+	check := func(name string, cur, base float64, format func(float64) string) {
+		if cur <= 0 || base <= 0 {
+			return
+		}
+		if change := (cur - base) / base; math.Abs(change) > threshold {
+			out = append(out, fmt.Sprintf("%s %s is %+.0f%% from the baseline of %s",
+				name, format(cur), change*100, format(base)))
+		}
+	}
+	seconds := func(v float64) string { return (time.Duration(v) * time.Second).String() }
+	check("dump size", float64(current.DumpBytes), float64(baseline.DumpBytes),
+		func(v float64) string { return formatBytes(int64(v)) })
+	check("dump duration", current.DumpSeconds, baseline.DumpSeconds, seconds)
+	check("restore duration", current.RestoreSeconds, baseline.RestoreSeconds, seconds)
+	return out
+}
+
+// historySchema creates the runs table if it doesn't exist
+func historySchema(c HistoryConfig) string {
+	return fmt.Sprintf(`CREATE SCHEMA IF NOT EXISTS %s;
+CREATE TABLE IF NOT EXISTS %s (
+	run_id text PRIMARY KEY,
+	started_at timestamptz NOT NULL,
+	finished_at timestamptz,
+	status text NOT NULL,
+	error text,
+	dump_bytes bigint,
+	dump_seconds double precision,
+	restore_seconds double precision,
+	total_seconds double precision,
+	report jsonb NOT NULL
+);
+`, quoteIdent(c.Schema), c.table())
+}
+
+// historyBaseline averages the metrics of the most recent successful runs
+func historyBaseline(c HistoryConfig) (RunMetrics, int, error) {
+	query := fmt.Sprintf(`SELECT count(*), coalesce(avg(nullif(dump_bytes, 0)), 0)::bigint,
+	coalesce(avg(nullif(dump_seconds, 0)), 0), coalesce(avg(nullif(restore_seconds, 0)), 0)
+FROM (SELECT * FROM %s WHERE status = 'succeeded' ORDER BY started_at DESC LIMIT %d) recent;`,
+		c.table(), c.BaselineRuns)
+	output, err := newPsqlCmd(c.DBConfig, "-t", "-A", "-F", "|", "-v", "ON_ERROR_STOP=1", "-c", query).Output()
+	if err != nil {
+		return RunMetrics{}, 0, fmt.Errorf("failed to read run history baseline: %w, output: %s", err, output)
+	}
+	fields := strings.Split(strings.TrimSpace(string(output)), "|")
+	if len(fields) != 4 {
+		return RunMetrics{}, 0, fmt.Errorf("unexpected run history baseline %q", output)
+	}
+	runs, _ := strconv.Atoi(fields[0])
+	var m RunMetrics
+	m.DumpBytes, _ = strconv.ParseInt(fields[1], 10, 64)
+	m.DumpSeconds, _ = strconv.ParseFloat(fields[2], 64)
+	m.RestoreSeconds, _ = strconv.ParseFloat(fields[3], 64)
+	return m, runs, nil
+}
+
+// RecordRun stores a finished run in the history database and returns how it deviates
+// from the baseline of earlier successful runs
+func RecordRun(c HistoryConfig, r *RunReport, dumpBytes int64) ([]string, error) {
+	c = c.withDefaults()
+	if output, err := newPsqlCmd(c.DBConfig, "-q", "-v", "ON_ERROR_STOP=1", "-c", historySchema(c)).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("failed to create run history table: %w, output: %s", err, output)
+	}
+
+	baseline, runs, err := historyBaseline(c)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	content, err := json.Marshal(r)
+	r.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode run report: %w", err)
+	}
+	m := runMetrics(r, dumpBytes)
+	insert := fmt.Sprintf(`INSERT INTO %s (run_id, started_at, finished_at, status, error, dump_bytes,
+	dump_seconds, restore_seconds, total_seconds, report)
+VALUES (%s, %s, %s, %s, nullif(%s, ''), %d, %f, %f, %f, %s)
+ON CONFLICT (run_id) DO UPDATE SET finished_at = EXCLUDED.finished_at, status = EXCLUDED.status,
+	error = EXCLUDED.error, dump_bytes = EXCLUDED.dump_bytes, dump_seconds = EXCLUDED.dump_seconds,
+	restore_seconds = EXCLUDED.restore_seconds, total_seconds = EXCLUDED.total_seconds,
+	report = EXCLUDED.report;
+`, c.table(), quoteLiteral(r.RunID), quoteLiteral(r.StartedAt.Format(time.RFC3339Nano)),
+		quoteLiteral(r.FinishedAt.Format(time.RFC3339Nano)), quoteLiteral(r.Status), quoteLiteral(r.Error),
+		m.DumpBytes, m.DumpSeconds, m.RestoreSeconds, m.TotalSeconds, quoteLiteral(string(content)))
+
+	cmd := newPsqlCmd(c.DBConfig, "-q", "-v", "ON_ERROR_STOP=1")
+	cmd.Stdin = strings.NewReader(insert)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("failed to record run %s: %w, output: %s", r.RunID, err, output)
+	}
+	log.Printf("Run %s recorded in %s.%s", r.RunID, c.DBName, c.table())
+
+	if runs == 0 || r.Status != "succeeded" {
+		return nil, nil
+	}
+	return deviations(m, baseline, c.Threshold), nil
+}
+
+// historyTrendQuery summarizes runs by week
+const historyTrendQuery = `SELECT to_char(date_trunc('week', started_at), 'YYYY-MM-DD'),
+	count(*),
+	count(*) FILTER (WHERE status = 'succeeded'),
+	coalesce(avg(nullif(dump_bytes, 0)), 0)::bigint,
+	coalesce(avg(nullif(dump_seconds, 0)), 0)::bigint,
+	coalesce(avg(nullif(restore_seconds, 0)), 0)::bigint,
+	coalesce(max(restore_seconds), 0)::bigint
+FROM %s
+WHERE started_at >= now() - interval '%d weeks'
+GROUP BY 1 ORDER BY 1;`
+
+// ShowHistory logs weekly trends of dump size and durations over the last weeks
+func ShowHistory(c HistoryConfig, weeks int) error {
+	c = c.withDefaults()
+	query := fmt.Sprintf(historyTrendQuery, c.table(), weeks)
+	output, err := newPsqlCmd(c.DBConfig, "-t", "-A", "-F", "|", "-v", "ON_ERROR_STOP=1", "-c", query).Output()
+	if err != nil {
+		return fmt.Errorf("failed to read run history: %w, output: %s", err, output)
+	}
+
+	alwaysLog.Printf("%-10s %5s %9s %10s %9s %12s %12s", "week", "runs", "succeeded", "dump size", "dump", "restore avg", "restore max")
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.Split(line, "|")
+		if len(fields) != 7 {
+			continue
+		}
+		bytes, _ := strconv.ParseInt(fields[3], 10, 64)
+		seconds := make([]time.Duration, 3)
+		for i, f := range fields[4:] {
+			n, _ := strconv.ParseInt(f, 10, 64)
+			seconds[i] = time.Duration(n) * time.Second
+		}
+		alwaysLog.Printf("%-10s %5s %9s %10s %9s %12s %12s",
+			fields[0], fields[1], fields[2], formatBytes(bytes), seconds[0], seconds[1], seconds[2])
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunMetrics(t *testing.T) {
+	start := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	r := &RunReport{
+		StartedAt:  start,
+		FinishedAt: start.Add(10 * time.Minute),
+		Phases: []PhaseReport{
+			{Name: "dump", Duration: "2m30s"},
+			{Name: "restore", Duration: "6m0.5s"},
+		},
+	}
+	m := runMetrics(r, 1024)
+	if m.DumpSeconds != 150 || m.RestoreSeconds != 360.5 || m.TotalSeconds != 600 || m.DumpBytes != 1024 {
+		t.Errorf("unexpected metrics: %+v", m)
+	}
+}
+
+func TestDeviations(t *testing.T) {
+	baseline := RunMetrics{DumpBytes: 1 << 30, DumpSeconds: 100, RestoreSeconds: 200}
+
+	if got := deviations(RunMetrics{DumpBytes: 1 << 30, DumpSeconds: 120, RestoreSeconds: 250}, baseline, 0.5); len(got) != 0 {
+		t.Errorf("expected no deviations within 50%%, got %v", got)
+	}
+
+	got := deviations(RunMetrics{DumpBytes: 3 << 30, DumpSeconds: 100, RestoreSeconds: 50}, baseline, 0.5)
+	if len(got) != 2 || !strings.HasPrefix(got[0], "dump size 3.0 GiB is +200%") || !strings.HasPrefix(got[1], "restore duration 50s is -75%") {
+		t.Errorf("unexpected deviations: %v", got)
+	}
+
+	if got := deviations(RunMetrics{DumpSeconds: 500}, RunMetrics{}, 0.5); len(got) != 0 {
+		t.Errorf("expected no comparison without a baseline, got %v", got)
+	}
+}
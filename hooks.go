@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// Hook timing relative to its phase
+const (
+	HookBefore = "before"
+	HookAfter  = "after"
+	// HookAlways runs after the phase whether it succeeded or failed
+	HookAlways = "always"
+)
+
+// workflowPhases lists the phases of a run that hooks can attach to
+var workflowPhases = []string{
+	"app_role", "cdc", "check_foreign_keys", "cleanup", "clone", "cutover", "discover",
+	"download", "dump", "grants", "incremental", "prewarm", "query_pack", "replay", "restore",
+	"restore_point_after", "restore_point_before", "setup", "upload", "validate",
+	"validate_behavior", "validate_catalog", "workflow",
+}
+
+// Hook is a shell command or SQL file run around a workflow phase
+type Hook struct {
+	Name  string `json:"name"`
+	Phase string `json:"phase"`
+	When  string `json:"when"`
+	// Command runs through sh -c with PG_RESTORE_FDW_PHASE and PG_RESTORE_FDW_WHEN set
+	Command string `json:"command"`
+	// SQLFile runs through psql against Database
+	SQLFile  string `json:"sql_file"`
+	Database string `json:"database"`
+	// OnFailure is "fatal" (default) to fail the phase or "warn" to log and continue
+	OnFailure string `json:"on_failure"`
+}
+
+// Hooks runs configured hooks against a set of named databases
+type Hooks struct {
+	hooks     []Hook
+	databases map[string]DBConfig
+}
+
+// NewHooks validates the hooks against the phases they may attach to and binds SQL
+// hooks to the named databases
+func NewHooks(hooks []Hook, phases []string, databases map[string]DBConfig) (*Hooks, error) {
+	for _, h := range hooks {
+		if !slices.Contains(phases, h.Phase) {
+			return nil, fmt.Errorf("hook %q: unknown phase %q", h.Name, h.Phase)
+		}
+		switch h.When {
+		case HookBefore, HookAfter, HookAlways:
+		default:
+			return nil, fmt.Errorf("hook %q: when must be before, after, or always", h.Name)
+		}
+		if (h.Command == "") == (h.SQLFile == "") {
+			return nil, fmt.Errorf("hook %q: exactly one of command or sql_file is required", h.Name)
+		}
+		if h.SQLFile != "" {
+			if _, ok := databases[h.Database]; !ok {
+				return nil, fmt.Errorf("hook %q: unknown database %q", h.Name, h.Database)
+			}
+		}
+		if h.OnFailure != "" && h.OnFailure != "fatal" && h.OnFailure != "warn" {
+			return nil, fmt.Errorf("hook %q: on_failure must be fatal or warn", h.Name)
+		}
+	}
+	return &Hooks{hooks: hooks, databases: databases}, nil
+}
+
+// Wrap returns fn surrounded by the phase's hooks
+func (h *Hooks) Wrap(phase string, fn func() error) func() error {
+	return func() error {
+		if err := h.run(phase, HookBefore); err != nil {
+			return err
+		}
+
+		phaseErr := fn()
+		if phaseErr == nil {
+			if err := h.run(phase, HookAfter); err != nil {
+				return err
+			}
+		}
+		if err := h.run(phase, HookAlways); err != nil && phaseErr == nil {
+			return err
+		}
+		return phaseErr
+	}
+}
+
+// run executes the hooks for a phase and timing in configured order
+func (h *Hooks) run(phase, when string) error {
+	if h == nil {
+		return nil
+	}
+
+	for _, hook := range h.hooks {
+		if hook.Phase != phase || hook.When != when {
+			continue
+		}
+
+		log.Printf("Running %s-%s hook %q", when, phase, hook.Name)
+		if err := h.runHook(hook); err != nil {
+			if hook.OnFailure == "warn" {
+				log.Printf("WARNING: hook %q failed: %v", hook.Name, err)
+				continue
+			}
+			return fmt.Errorf("%s-%s hook %q failed: %w", when, phase, hook.Name, err)
+		}
+	}
+	return nil
+}
+
+// hookEnv returns the environment of a command hook: env plus the hook's phase and timing
+func hookEnv(env []string, hook Hook) []string {
+	return append(env,
+		"PG_RESTORE_FDW_PHASE="+hook.Phase,
+		"PG_RESTORE_FDW_WHEN="+hook.When,
+	)
+}
+
+// runHook runs a single hook's command or SQL file
+func (h *Hooks) runHook(hook Hook) error {
+	var cmd *exec.Cmd
+	if hook.Command != "" {
+		cmd = exec.Command("sh", "-c", hook.Command)
+		cmd.Env = hookEnv(os.Environ(), hook)
+	} else {
+		cmd = newPsqlCmd(h.databases[hook.Database], "-v", "ON_ERROR_STOP=1", "-f", hook.SQLFile)
+	}
+
+	step := fmt.Sprintf("hook_%s_%s_%s", hook.When, hook.Phase, strings.ReplaceAll(hook.Name, " ", "_"))
+	if output, err := runStreaming(cmd, step, nil); err != nil {
+		return fmt.Errorf("%w\nOutput: %s", err, output)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestNewHooks(t *testing.T) {
+	databases := map[string]DBConfig{"dest_tenant": {DBName: "tenant"}}
+	tests := []struct {
+		name    string
+		hook    Hook
+		wantErr bool
+	}{
+		{"command", Hook{Name: "notify", Phase: "restore", When: HookAfter, Command: "true"}, false},
+		{"sql file", Hook{Name: "analyze", Phase: "restore", When: HookAlways, SQLFile: "analyze.sql", Database: "dest_tenant", OnFailure: "warn"}, false},
+		{"bad timing", Hook{Name: "x", Phase: "restore", When: "during", Command: "true"}, true},
+		{"unknown phase", Hook{Name: "x", Phase: "pre_restor", When: HookBefore, Command: "true"}, true},
+		{"no phase", Hook{Name: "x", When: HookBefore, Command: "true"}, true},
+		{"command and sql file", Hook{Name: "x", Phase: "restore", When: HookBefore, Command: "true", SQLFile: "a.sql", Database: "dest_tenant"}, true},
+		{"neither", Hook{Name: "x", Phase: "restore", When: HookBefore}, true},
+		{"unknown database", Hook{Name: "x", Phase: "restore", When: HookBefore, SQLFile: "a.sql", Database: "dest_moodys"}, true},
+		{"bad on_failure", Hook{Name: "x", Phase: "restore", When: HookBefore, Command: "true", OnFailure: "ignore"}, true},
+	}
+	for _, tt := range tests {
+		if _, err := NewHooks([]Hook{tt.hook}, workflowPhases, databases); (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestHookEnv(t *testing.T) {
+	got := hookEnv([]string{"PATH=/usr/bin"}, Hook{Phase: "cutover", When: HookBefore})
+	want := []string{"PATH=/usr/bin", "PG_RESTORE_FDW_PHASE=cutover", "PG_RESTORE_FDW_WHEN=before"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("hookEnv = %q, want %q", got, want)
+	}
+}
+
+func TestWrapWithoutHooks(t *testing.T) {
+	var h *Hooks
+	phaseErr := errors.New("restore failed")
+	if err := h.Wrap("restore", func() error { return phaseErr })(); err != phaseErr {
+		t.Errorf("Wrap returned %v, want the phase's error", err)
+	}
+	hooks, _ := NewHooks([]Hook{{Name: "other", Phase: "dump", When: HookBefore, Command: "false"}}, workflowPhases, nil)
+	ran := false
+	if err := hooks.Wrap("restore", func() error { ran = true; return nil })(); err != nil || !ran {
+		t.Errorf("Wrap = %v, ran %v", err, ran)
+	}
+}
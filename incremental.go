@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// IncrementalTable configures a table refreshed by watermark instead of a full dump
+type IncrementalTable struct {
+	// Database is "moodys" or "tenant"
+	Database string `json:"database"`
+	// Table is the schema-qualified table name
+	Table string `json:"table"`
+	// Column is a timestamp or monotonically increasing column, e.g. updated_at or id
+	Column string `json:"column"`
+	// Key identifies rows; changed rows replace destination rows with the same key
+	Key []string `json:"key"`
+	// Lag re-reads rows this far below the previous watermark, in the column's terms:
+	// an interval such as "10 minutes" for a timestamp, a number for an id. Rows that
+	// commit after a refresh with values below its watermark are otherwise never seen.
+	Lag string `json:"lag"`
+}
+
+// Watermark is the highest column value transferred for a table
+type Watermark struct {
+	Column    string    `json:"column"`
+	Value     string    `json:"value"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// watermarksPath returns where watermarks are stored
+func watermarksPath(dir string) string {
+	return filepath.Join(dir, "watermarks.json")
+}
+
+// LoadWatermarks reads stored watermarks keyed by database/table; a missing file yields none
+func LoadWatermarks(dir string) (map[string]Watermark, error) {
+	marks := make(map[string]Watermark)
+	content, err := os.ReadFile(watermarksPath(dir))
+	if os.IsNotExist(err) {
+		return marks, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read watermarks: %w", err)
+	}
+	if err := json.Unmarshal(content, &marks); err != nil {
+		return nil, fmt.Errorf("failed to parse watermarks: %w", err)
+	}
+	return marks, nil
+}
+
+// SaveWatermarks writes watermarks under dir
+func SaveWatermarks(dir string, marks map[string]Watermark) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create watermark directory: %w", err)
+	}
+	content, err := json.MarshalIndent(marks, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode watermarks: %w", err)
+	}
+	if err := os.WriteFile(watermarksPath(dir), content, 0644); err != nil {
+		return fmt.Errorf("failed to write watermarks: %w", err)
+	}
+	return nil
+}
+
+// changedRowsQuery selects rows whose column lies in (from - lag, to]; an empty from
+// selects everything up to to. columnType is the column's type, which from and the lag
+// are cast with.
+func changedRowsQuery(t IncrementalTable, from, to, columnType string) string {
+	column := quoteIdent(t.Column)
+	where := fmt.Sprintf("%s <= %s", column, quoteLiteral(to))
+	switch {
+	case from != "" && t.Lag != "":
+		lagType := columnType
+		if isTemporalType(columnType) {
+			lagType = "interval"
+		}
+		where = fmt.Sprintf("%s > %s::%s - %s::%s AND %s", column, quoteLiteral(from), columnType, quoteLiteral(t.Lag), lagType, where)
+	case from != "":
+		where = fmt.Sprintf("%s > %s AND %s", column, quoteLiteral(from), where)
+	}
+	return fmt.Sprintf("SELECT * FROM %s WHERE %s", quoteQualifiedName(t.Table), where)
+}
+
+// isTemporalType reports whether a type, as pg_typeof names it, is a date or time
+// type, whose lag is an interval
+func isTemporalType(typ string) bool {
+	return typ == "date" || strings.HasPrefix(typ, "timestamp") || strings.HasPrefix(typ, "time ")
+}
+
+// mergeStatements replaces destination rows that share a key with the staged rows
+func mergeStatements(t IncrementalTable, staging string) []string {
+	table := quoteQualifiedName(t.Table)
+	var match []string
+	for _, key := range t.Key {
+		match = append(match, fmt.Sprintf("t.%s = s.%s", quoteIdent(key), quoteIdent(key)))
+	}
+	return []string{
+		fmt.Sprintf("CREATE TEMP TABLE %s (LIKE %s) ON COMMIT DROP", staging, table),
+		fmt.Sprintf("COPY %s FROM STDIN", staging),
+		fmt.Sprintf("DELETE FROM %s t USING %s s WHERE %s", table, staging, strings.Join(match, " AND ")),
+		fmt.Sprintf("INSERT INTO %s SELECT * FROM %s", table, staging),
+	}
+}
+
+// maxColumnValue returns the current maximum of the watermark column as text, and the
+// column's type
+func maxColumnValue(config DBConfig, t IncrementalTable) (string, string, error) {
+	query := fmt.Sprintf("SELECT pg_typeof(max(%s)), max(%s)::text FROM %s;", quoteIdent(t.Column), quoteIdent(t.Column), quoteQualifiedName(t.Table))
+	cmd := newPsqlCmd(config, "-t", "-A", "-c", query)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read watermark of %s: %w, output: %s", t.Table, err, output)
+	}
+	columnType, value, _ := strings.Cut(strings.TrimSpace(string(output)), "|")
+	return value, columnType, nil
+}
+
+// refreshTable copies rows changed since the previous watermark from src to dest in
+// one destination transaction and returns the new watermark
+func refreshTable(src, dest DBConfig, t IncrementalTable, from string) (string, error) {
+	to, columnType, err := maxColumnValue(src, t)
+	if err != nil {
+		return "", err
+	}
+	// With a lag, rows that committed late below the watermark are looked for even
+	// when nothing newer arrived
+	if to == "" || (to == from && t.Lag == "") {
+		log.Printf("No changes in %s since watermark %q", t.Table, from)
+		return from, nil
+	}
+
+	if t.Lag != "" && from != "" {
+		log.Printf("Refreshing %s rows with %s in (%q - %s, %q]", t.Table, t.Column, from, t.Lag, to)
+	} else {
+		log.Printf("Refreshing %s rows with %s in (%q, %q]", t.Table, t.Column, from, to)
+	}
+	copyOut := newPsqlCmd(src, "-v", "ON_ERROR_STOP=1",
+		"-c", fmt.Sprintf("COPY (%s) TO STDOUT", changedRowsQuery(t, from, to, columnType)))
+
+	args := []string{"-v", "ON_ERROR_STOP=1", "--single-transaction"}
+	for _, stmt := range mergeStatements(t, "pg_restore_fdw_staging") {
+		args = append(args, "-c", stmt)
+	}
+	copyIn := newPsqlCmd(dest, args...)
+
+	pipe, err := copyOut.StdoutPipe()
+	if err != nil {
+		return "", fmt.Errorf("failed to connect source to destination: %w", err)
+	}
+	copyIn.Stdin = pipe
+	var copyOutErr strings.Builder
+	copyOut.Stderr = &copyOutErr
+
+	if err := copyOut.Start(); err != nil {
+		return "", fmt.Errorf("failed to start copy from %s: %w", src.DBName, err)
+	}
+	step := fmt.Sprintf("incremental_%s_%s", dest.DBName, t.Table)
+	if output, err := runStreaming(copyIn, step, nil); err != nil {
+		copyOut.Wait()
+		return "", fmt.Errorf("failed to merge changes into %s: %w\nOutput: %s", t.Table, err, output)
+	}
+	if err := copyOut.Wait(); err != nil {
+		return "", fmt.Errorf("failed to copy changes from %s: %w\nOutput: %s", t.Table, err, copyOutErr.String())
+	}
+	return to, nil
+}
+
+// IncrementalOptions controls optional incremental refresh behavior
+type IncrementalOptions struct {
+	// Triggers keeps the destination tables' user triggers from firing while changes
+	// merge: TriggersReplica, TriggersDisable, or empty to let them fire
+	Triggers string
+}
+
+// IncrementalRefresh transfers rows changed since each table's last watermark and
+// records the new watermarks under dir. Rows deleted at the source are not propagated,
+// and without a lag, rows that commit after a refresh with column values below its
+// watermark, such as a long transaction's, are never transferred.
+func IncrementalRefresh(tables []IncrementalTable, sources, dests map[string]DBConfig, dir string) error {
+	return IncrementalRefreshWithOptions(tables, sources, dests, dir, IncrementalOptions{})
+}
+
+// IncrementalRefreshWithOptions transfers changed rows like IncrementalRefresh
+func IncrementalRefreshWithOptions(tables []IncrementalTable, sources, dests map[string]DBConfig, dir string, opts IncrementalOptions) error {
+	marks, err := LoadWatermarks(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range tables {
+		src, ok := sources[t.Database]
+		if !ok {
+			return fmt.Errorf("incremental table %s: unknown database %q", t.Table, t.Database)
+		}
+		src = activeThrottle.source(src)
+		if t.Column == "" || len(t.Key) == 0 {
+			return fmt.Errorf("incremental table %s: column and key are required", t.Table)
+		}
+
+		id := t.Database + "/" + t.Table
+		from := marks[id].Value
+		if marks[id].Column != "" && marks[id].Column != t.Column {
+			log.Printf("Watermark column of %s changed from %s to %s; transferring all rows", id, marks[id].Column, t.Column)
+			from = ""
+		}
+
+		span := startSpan("incremental "+id, "db.name", dests[t.Database].DBName)
+		var to string
+		err := withTriggersOff(dests[t.Database], []string{t.Table}, opts.Triggers, func(dest DBConfig) error {
+			var err error
+			to, err = refreshTable(src, dest, t, from)
+			return err
+		})
+		span.End(err)
+		if err != nil {
+			return err
+		}
+
+		marks[id] = Watermark{Column: t.Column, Value: to, UpdatedAt: time.Now()}
+		if err := SaveWatermarks(dir, marks); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIncrementalStatements(t *testing.T) {
+	table := IncrementalTable{Table: "public.customer_transactions", Column: "updated_at", Key: []string{"id"}}
+
+	if got, want := changedRowsQuery(table, "", "2024-06-01 10:00:00", "timestamp with time zone"),
+		`SELECT * FROM "public"."customer_transactions" WHERE "updated_at" <= '2024-06-01 10:00:00'`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if got, want := changedRowsQuery(table, "2024-05-31", "2024-06-01", "timestamp with time zone"),
+		`SELECT * FROM "public"."customer_transactions" WHERE "updated_at" > '2024-05-31' AND "updated_at" <= '2024-06-01'`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	lagged := table
+	lagged.Lag = "10 minutes"
+	if got, want := changedRowsQuery(lagged, "2024-05-31", "2024-06-01", "timestamp with time zone"),
+		`SELECT * FROM "public"."customer_transactions" WHERE "updated_at" > '2024-05-31'::timestamp with time zone - '10 minutes'::interval AND "updated_at" <= '2024-06-01'`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if got, want := changedRowsQuery(lagged, "", "2024-06-01", "timestamp with time zone"),
+		`SELECT * FROM "public"."customer_transactions" WHERE "updated_at" <= '2024-06-01'`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	byID := IncrementalTable{Table: "public.events", Column: "id", Key: []string{"id"}, Lag: "1000"}
+	if got, want := changedRowsQuery(byID, "52000", "53000", "bigint"),
+		`SELECT * FROM "public"."events" WHERE "id" > '52000'::bigint - '1000'::bigint AND "id" <= '53000'`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	want := []string{
+		`CREATE TEMP TABLE staging (LIKE "public"."customer_transactions") ON COMMIT DROP`,
+		`COPY staging FROM STDIN`,
+		`DELETE FROM "public"."customer_transactions" t USING staging s WHERE t."id" = s."id"`,
+		`INSERT INTO "public"."customer_transactions" SELECT * FROM staging`,
+	}
+	if got := mergeStatements(table, "staging"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
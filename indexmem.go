@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Defaults of IndexMemoryConfig
+const (
+	defaultIndexMemoryFraction = 0.5
+	defaultIndexMemoryMaxMB    = 2048
+	// minIndexMemoryMB is PostgreSQL's default maintenance_work_mem; sizing never goes below it
+	minIndexMemoryMB = 64
+)
+
+// IndexMemoryConfig sizes maintenance_work_mem for post-data sessions from the
+// destination's memory and the number of parallel workers, so index builds get as much
+// memory as the server can spare without running it out of memory
+type IndexMemoryConfig struct {
+	// ServerMemoryMB is the destination server's memory; when unset it is estimated from
+	// shared_buffers and effective_cache_size
+	ServerMemoryMB int `json:"server_memory_mb"`
+	// Fraction is the share of the memory beyond shared_buffers the workers use
+	// together; default 0.5
+	Fraction float64 `json:"fraction"`
+	// MaxMB caps each worker's maintenance_work_mem; default 2048
+	MaxMB int `json:"max_mb"`
+}
+
+func (c *IndexMemoryConfig) validate() error {
+	if c.ServerMemoryMB < 0 || c.MaxMB < 0 {
+		return fmt.Errorf("server_memory_mb and max_mb must not be negative")
+	}
+	if c.Fraction < 0 || c.Fraction > 1 {
+		return fmt.Errorf("fraction must be between 0 and 1")
+	}
+	return nil
+}
+
+// serverMemoryQuery reports shared_buffers and effective_cache_size in bytes
+const serverMemoryQuery = `SELECT pg_size_bytes(current_setting('shared_buffers')), pg_size_bytes(current_setting('effective_cache_size'));`
+
+// estimateServerMemoryMB guesses a server's memory from its settings, which tuning
+// guides put at a quarter (shared_buffers) and three quarters (effective_cache_size) of
+// memory; the larger guess wins, since either may be left at its small default
+func estimateServerMemoryMB(sharedBuffersMB, effectiveCacheMB int) int {
+	estimate := sharedBuffersMB * 4
+	if fromCache := effectiveCacheMB * 4 / 3; fromCache > estimate {
+		estimate = fromCache
+	}
+	return estimate
+}
+
+// workerMemoryMB divides the memory beyond shared_buffers the workers may use between
+// them, between PostgreSQL's default and the cap
+func (c *IndexMemoryConfig) workerMemoryMB(serverMB, sharedBuffersMB, jobs int) int {
+	fraction := c.Fraction
+	if fraction == 0 {
+		fraction = defaultIndexMemoryFraction
+	}
+	maxMB := c.MaxMB
+	if maxMB == 0 {
+		maxMB = defaultIndexMemoryMaxMB
+	}
+	if jobs < 1 {
+		jobs = 1
+	}
+	mb := int(float64(serverMB-sharedBuffersMB) * fraction / float64(jobs))
+	if mb > maxMB {
+		mb = maxMB
+	}
+	if mb < minIndexMemoryMB {
+		mb = minIndexMemoryMB
+	}
+	return mb
+}
+
+// session sets maintenance_work_mem on a post-data connection restoring with jobs
+// workers. When the destination's settings can't be read, config is left as it is.
+func (c *IndexMemoryConfig) session(config DBConfig, jobs int) DBConfig {
+	if c == nil {
+		return config
+	}
+	rows, err := catalogRows(config, serverMemoryQuery)
+	if err != nil || len(rows) != 1 || len(rows[0]) != 2 {
+		log.Printf("WARNING: could not read the memory settings of %s, leaving maintenance_work_mem alone: %v", config.DBName, err)
+		return config
+	}
+	sharedBuffers, err1 := strconv.ParseInt(rows[0][0], 10, 64)
+	effectiveCache, err2 := strconv.ParseInt(rows[0][1], 10, 64)
+	if err1 != nil || err2 != nil {
+		log.Printf("WARNING: unexpected memory settings %q of %s, leaving maintenance_work_mem alone", strings.Join(rows[0], "|"), config.DBName)
+		return config
+	}
+	sharedBuffersMB := int(sharedBuffers >> 20)
+	serverMB, source := c.ServerMemoryMB, "configured"
+	if serverMB == 0 {
+		serverMB, source = estimateServerMemoryMB(sharedBuffersMB, int(effectiveCache>>20)), "estimated"
+	}
+	mb := c.workerMemoryMB(serverMB, sharedBuffersMB, jobs)
+	log.Printf("Setting maintenance_work_mem to %dMB for each of %d workers restoring %s (%s server memory %dMB, shared_buffers %dMB)",
+		mb, jobs, config.DBName, source, serverMB, sharedBuffersMB)
+	config.Options = strings.TrimSpace(fmt.Sprintf("%s -c maintenance_work_mem=%dMB", config.Options, mb))
+	return config
+}
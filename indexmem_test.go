@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestEstimateServerMemoryMB(t *testing.T) {
+	if got := estimateServerMemoryMB(16384, 4096); got != 65536 {
+		t.Errorf("from shared_buffers got %d", got)
+	}
+	if got := estimateServerMemoryMB(128, 49152); got != 65536 {
+		t.Errorf("from effective_cache_size got %d", got)
+	}
+}
+
+func TestWorkerMemoryMB(t *testing.T) {
+	c := &IndexMemoryConfig{}
+	if got := c.workerMemoryMB(65536, 16384, 8); got != 2048 {
+		t.Errorf("capped: got %d", got)
+	}
+	c.MaxMB = 8192
+	if got := c.workerMemoryMB(65536, 16384, 8); got != 3072 {
+		t.Errorf("divided: got %d", got)
+	}
+	if got := c.workerMemoryMB(1024, 256, 16); got != minIndexMemoryMB {
+		t.Errorf("floor: got %d", got)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// initConnectionNames are the connections the init wizard asks for, in order
+var initConnectionNames = []string{"source_moodys", "source_tenant", "dest_moodys", "dest_tenant"}
+
+// initDefaults are the wizard's suggestions before any answer; they match the
+// built-in connections
+var initDefaults = map[string]ConnectionSettings{
+	"source_moodys": {Host: "localhost", Port: "5432", User: "postgres", Password: "your_password", DBName: "moodys"},
+	"source_tenant": {DBName: "tenant"},
+	"dest_moodys":   {DBName: "moodys_dest"},
+	"dest_tenant":   {DBName: "tenant_dest"},
+}
+
+// initWizard asks questions on out and reads the answers from in
+type initWizard struct {
+	in  *bufio.Reader
+	out io.Writer
+	// terminal turns off echo while a password is typed
+	terminal bool
+}
+
+// ask prompts for a value, returning def when the answer is empty
+func (w *initWizard) ask(label, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(w.out, "%s [%s]: ", label, def)
+	} else {
+		fmt.Fprintf(w.out, "%s: ", label)
+	}
+	answer, err := w.in.ReadString('\n')
+	if err != nil && (err != io.EOF || answer == "") {
+		return "", fmt.Errorf("failed to read an answer: %w", err)
+	}
+	if answer = strings.TrimSpace(answer); answer != "" {
+		return answer, nil
+	}
+	return def, nil
+}
+
+// confirm asks a yes/no question
+func (w *initWizard) confirm(label string, def bool) (bool, error) {
+	choices := "y/N"
+	if def {
+		choices = "Y/n"
+	}
+	answer, err := w.ask(label+" ("+choices+")", "")
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToLower(answer) {
+	case "":
+		return def, nil
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
+
+// askPassword prompts for a password, without echoing it on a terminal. An empty
+// answer keeps def.
+func (w *initWizard) askPassword(label, def string) (string, error) {
+	if w.terminal {
+		stty := exec.Command("stty", "-echo")
+		stty.Stdin = os.Stdin
+		if stty.Run() == nil {
+			defer func() {
+				restore := exec.Command("stty", "echo")
+				restore.Stdin = os.Stdin
+				restore.Run()
+				fmt.Fprintln(w.out)
+			}()
+		}
+	}
+	if def != "" {
+		label += " (Enter keeps the current one)"
+	}
+	answer, err := w.ask(label, "")
+	if answer == "" {
+		return def, err
+	}
+	return answer, err
+}
+
+// askConnection prompts for one connection, suggesting the previous answers and dbname
+func (w *initWizard) askConnection(name string, previous ConnectionSettings, dbname string) (ConnectionSettings, error) {
+	fmt.Fprintf(w.out, "\n%s\n", name)
+	s := previous
+	s.DBName = dbname
+	for _, field := range []struct {
+		label  string
+		target *string
+	}{
+		{"  Host", &s.Host}, {"  Port", &s.Port}, {"  User", &s.User}, {"  Database", &s.DBName},
+	} {
+		value, err := w.ask(field.label, *field.target)
+		if err != nil {
+			return s, err
+		}
+		*field.target = value
+	}
+	password, err := w.askPassword("  Password", previous.Password)
+	if err != nil {
+		return s, err
+	}
+	s.Password = password
+	return s, nil
+}
+
+// testConnection connects to a source database, or to a destination's server since
+// the restore creates the database itself
+func testConnection(name string, s ConnectionSettings) (string, error) {
+	var config DBConfig
+	s.apply(&config)
+	if strings.HasPrefix(name, "source_") {
+		rows, err := queryRows(config, "SELECT current_setting('server_version');")
+		if err != nil {
+			return "", err
+		}
+		return "connected, PostgreSQL " + rows[0][0], nil
+	}
+	exists, err := databaseExists(config, config.DBName)
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		return "connected; " + config.DBName + " exists and is replaced by a restore", nil
+	}
+	return "connected; " + config.DBName + " is created by the first restore", nil
+}
+
+// describeTenantServers reports where the source tenant's foreign servers point
+func describeTenantServers(out io.Writer, servers []ForeignServer, tenant, moodys DBConfig) (allMoodys bool) {
+	if len(servers) == 0 {
+		fmt.Fprintln(out, "  source_tenant has no postgres_fdw servers")
+		return true
+	}
+	databases := map[string]WorkflowDatabase{"source_moodys": {Source: moodys}}
+	allMoodys = true
+	for _, server := range servers {
+		if _, ok := serverTarget(server, tenant, databases); ok {
+			fmt.Fprintf(out, "  %s points at source_moodys (%d foreign tables) and is retargeted to dest_moodys on restore\n",
+				server.Name, server.ForeignTables)
+			continue
+		}
+		allMoodys = false
+		fmt.Fprintf(out, "  %s points at %s:%s/%s, outside the configured databases\n",
+			server.Name, server.Options["host"], server.Options["port"], server.Options["dbname"])
+	}
+	return allMoodys
+}
+
+// initConfigFile is the part of Config the wizard writes
+type initConfigFile struct {
+	Defaults    ConnectionSettings            `json:"defaults"`
+	Connections map[string]ConnectionSettings `json:"connections"`
+}
+
+// buildInitConfig writes settings shared by every connection once, in defaults, and
+// the rest per connection
+func buildInitConfig(answers map[string]ConnectionSettings) initConfigFile {
+	file := initConfigFile{Connections: make(map[string]ConnectionSettings)}
+	shared := func(get func(ConnectionSettings) string) string {
+		value := get(answers[initConnectionNames[0]])
+		for _, name := range initConnectionNames[1:] {
+			if get(answers[name]) != value {
+				return ""
+			}
+		}
+		return value
+	}
+	file.Defaults = ConnectionSettings{
+		Host:     shared(func(s ConnectionSettings) string { return s.Host }),
+		Port:     shared(func(s ConnectionSettings) string { return s.Port }),
+		User:     shared(func(s ConnectionSettings) string { return s.User }),
+		Password: shared(func(s ConnectionSettings) string { return s.Password }),
+	}
+	for _, name := range initConnectionNames {
+		s := answers[name]
+		if s.Host == file.Defaults.Host {
+			s.Host = ""
+		}
+		if s.Port == file.Defaults.Port {
+			s.Port = ""
+		}
+		if s.User == file.Defaults.User {
+			s.User = ""
+		}
+		if s.Password == file.Defaults.Password {
+			s.Password = ""
+		}
+		file.Connections[name] = s
+	}
+	return file
+}
+
+// RunInitWizard asks for the four connections, tests them, reports the source tenant's
+// foreign servers, and writes a starter configuration file
+func RunInitWizard(path string, force bool, in io.Reader, out io.Writer) error {
+	if _, err := os.Stat(path); err == nil && !force {
+		return fmt.Errorf("%s already exists; pass -force to overwrite it", path)
+	}
+	w := &initWizard{in: bufio.NewReader(in), out: out, terminal: in == os.Stdin}
+	fmt.Fprintf(out, "This writes the connections pg_restore_fdw uses to %s.\n", path)
+	fmt.Fprintln(out, "Press Enter to keep the suggestion in brackets; each connection suggests the previous one's settings.")
+
+	answers := make(map[string]ConnectionSettings)
+	previous := initDefaults["source_moodys"]
+	for _, name := range initConnectionNames {
+		dbname := initDefaults[name].DBName
+		for {
+			s, err := w.askConnection(name, previous, dbname)
+			if err != nil {
+				return err
+			}
+			previous, dbname = s, s.DBName
+			result, err := testConnection(name, s)
+			if err == nil {
+				fmt.Fprintf(out, "  %s\n", result)
+				answers[name] = s
+				break
+			}
+			fmt.Fprintf(out, "  Connection failed: %v\n", err)
+			retry, err := w.confirm("  Change the settings", true)
+			if err != nil {
+				return err
+			}
+			if !retry {
+				answers[name] = s
+				break
+			}
+		}
+	}
+
+	fmt.Fprintln(out, "\nForeign servers")
+	var tenant, moodys DBConfig
+	answers["source_tenant"].apply(&tenant)
+	answers["source_moodys"].apply(&moodys)
+	if servers, err := listForeignServers(tenant); err != nil {
+		fmt.Fprintf(out, "  Could not list source_tenant's foreign servers: %v\n", err)
+	} else if !describeTenantServers(out, servers, tenant, moodys) {
+		fmt.Fprintln(out, "  Run with -discover-fdw so servers outside the migration are probed instead of rewritten,")
+		fmt.Fprintln(out, "  or set fdw_target to point them somewhere else.")
+	}
+
+	content, err := json.MarshalIndent(buildInitConfig(answers), "", "  ")
+	if err != nil {
+		return err
+	}
+	// The file may hold passwords
+	if err := os.WriteFile(path, append(content, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	fmt.Fprintf(out, "\nWrote %s. Next: pg_restore_fdw -config %s -plan plan.json\n", path, path)
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInitWizardPrompts(t *testing.T) {
+	var out bytes.Buffer
+	w := &initWizard{in: bufio.NewReader(strings.NewReader("db1\n\n\n\nsecret\n\n\n\n\n\nn\n")), out: &out}
+
+	s, err := w.askConnection("source_moodys", initDefaults["source_moodys"], "moodys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ConnectionSettings{Host: "db1", Port: "5432", User: "postgres", Password: "secret", DBName: "moodys"}
+	if s != want {
+		t.Errorf("first connection = %+v, want %+v", s, want)
+	}
+	s, err = w.askConnection("source_tenant", s, "tenant")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want.DBName = "tenant"
+	if s != want {
+		t.Errorf("second connection = %+v, want %+v", s, want)
+	}
+	if retry, err := w.confirm("Change the settings", true); err != nil || retry {
+		t.Errorf("confirm = %v, %v", retry, err)
+	}
+	if !strings.Contains(out.String(), "  Host [db1]: ") || strings.Contains(out.String(), "secret") {
+		t.Errorf("prompts:\n%s", out.String())
+	}
+	if _, err := w.ask("Host", "x"); !errors.Is(err, io.EOF) {
+		t.Errorf("ask at end of input: %v", err)
+	}
+}
+
+func TestBuildInitConfig(t *testing.T) {
+	answers := map[string]ConnectionSettings{
+		"source_moodys": {Host: "prod", Port: "5432", User: "postgres", Password: "pw", DBName: "moodys"},
+		"source_tenant": {Host: "prod", Port: "5432", User: "postgres", Password: "pw", DBName: "tenant"},
+		"dest_moodys":   {Host: "staging", Port: "5432", User: "postgres", Password: "pw", DBName: "moodys"},
+		"dest_tenant":   {Host: "staging", Port: "5432", User: "postgres", Password: "pw", DBName: "tenant"},
+	}
+	file := buildInitConfig(answers)
+	if want := (ConnectionSettings{Port: "5432", User: "postgres", Password: "pw"}); file.Defaults != want {
+		t.Errorf("defaults = %+v, want %+v", file.Defaults, want)
+	}
+	if want := (ConnectionSettings{Host: "staging", DBName: "tenant"}); file.Connections["dest_tenant"] != want {
+		t.Errorf("dest_tenant = %+v, want %+v", file.Connections["dest_tenant"], want)
+	}
+
+	// Resolving the written file gives back the answers
+	cfg := &Config{Defaults: file.Defaults, Connections: file.Connections}
+	connections := make(map[string]*DBConfig)
+	for _, name := range initConnectionNames {
+		connections[name] = &DBConfig{}
+	}
+	if err := resolveConnections(cfg, "", connections); err != nil {
+		t.Fatal(err)
+	}
+	for name, s := range answers {
+		var want DBConfig
+		s.apply(&want)
+		if !reflect.DeepEqual(*connections[name], want) {
+			t.Errorf("%s resolves to %+v, want %+v", name, *connections[name], want)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// LabelChange edits the labels and hold of a dump set's manifest
+type LabelChange struct {
+	Add    []string
+	Remove []string
+	// Hold marks the set immutable and Release lifts the mark
+	Hold    bool
+	Release bool
+}
+
+// parseLabels splits a comma-separated list of labels
+func parseLabels(value string) []string {
+	var labels []string
+	for _, label := range strings.Split(value, ",") {
+		if label = strings.TrimSpace(label); label != "" {
+			labels = append(labels, label)
+		}
+	}
+	return labels
+}
+
+// apply edits m, keeping its labels sorted and without duplicates
+func (c LabelChange) apply(m *Manifest) {
+	labels := make(map[string]bool)
+	for _, label := range append(append([]string{}, m.Labels...), c.Add...) {
+		labels[label] = true
+	}
+	for _, label := range c.Remove {
+		delete(labels, label)
+	}
+	m.Labels = nil
+	for label := range labels {
+		m.Labels = append(m.Labels, label)
+	}
+	sort.Strings(m.Labels)
+	if c.Hold {
+		m.Hold = true
+	}
+	if c.Release {
+		m.Hold = false
+	}
+}
+
+// checkNotHeld refuses to replace a held dump set; m is nil when where holds no set
+func checkNotHeld(m *Manifest, where string) error {
+	if m != nil && m.Hold {
+		return fmt.Errorf("the dump set in %s is held; release it with label -release before replacing it", where)
+	}
+	return nil
+}
+
+// checkHeldDumpSets refuses a dump that would replace a held set in dir or, when the
+// dump is uploaded, at store
+func checkHeldDumpSets(dir string, store *ObjectStore) error {
+	local, _ := LoadManifest(dir)
+	if err := checkNotHeld(local, dir); err != nil {
+		return err
+	}
+	if store == nil {
+		return nil
+	}
+	remote, err := store.FetchManifest()
+	if err != nil {
+		debugf("No dump set at %s to check for a hold: %v", store.config.URL, err)
+		return nil
+	}
+	return checkNotHeld(remote, store.config.URL)
+}
+
+// rewriteManifest writes an edited manifest to dir, signing it again when the set was
+// signed, since the old signature no longer matches
+func rewriteManifest(dir string, m *Manifest, signed bool, gpg *GPGConfig) error {
+	if signed && (gpg == nil || gpg.SignKey == "") {
+		return fmt.Errorf("the manifest is signed, so relabelling it needs gpg.sign_key to sign it again")
+	}
+	if err := WriteManifest(dir, m); err != nil {
+		return err
+	}
+	if signed {
+		return gpg.SignFile(filepath.Join(dir, manifestFileName))
+	}
+	return nil
+}
+
+// RelabelLocal applies a label change to the dump set in dir
+func RelabelLocal(dir string, c LabelChange, gpg *GPGConfig) (*Manifest, error) {
+	m, err := LoadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	_, err = os.Stat(filepath.Join(dir, manifestFileName+".asc"))
+	c.apply(m)
+	return m, rewriteManifest(dir, m, err == nil, gpg)
+}
+
+// Relabel applies a label change to the dump set stored at the location. The manifest
+// is uploaded before its new signature, so a restore starting in between fails
+// verification rather than trusting an unsigned change.
+func (s *ObjectStore) Relabel(c LabelChange, gpg *GPGConfig) (*Manifest, error) {
+	dir, err := os.MkdirTemp("", "pg_restore_fdw_manifest_")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	if err := s.Download(manifestFileName, filepath.Join(dir, manifestFileName), nil); err != nil {
+		return nil, err
+	}
+	m, err := LoadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.size(manifestFileName + ".asc")
+	signed := err == nil
+	c.apply(m)
+	if err := rewriteManifest(dir, m, signed, gpg); err != nil {
+		return nil, err
+	}
+	if _, err := s.Upload(filepath.Join(dir, manifestFileName), manifestFileName); err != nil {
+		return nil, err
+	}
+	if signed {
+		if _, err := s.Upload(filepath.Join(dir, manifestFileName+".asc"), manifestFileName+".asc"); err != nil {
+			return nil, err
+		}
+	}
+	log.Printf("Updated the manifest at %s", s.config.URL)
+	return m, nil
+}
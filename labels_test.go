@@ -0,0 +1,41 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLabelChange(t *testing.T) {
+	m := &Manifest{Labels: []string{"nightly", "pre-release"}}
+	LabelChange{Add: parseLabels(" release-1.42, nightly,"), Remove: []string{"pre-release"}, Hold: true}.apply(m)
+	if want := []string{"nightly", "release-1.42"}; !reflect.DeepEqual(m.Labels, want) || !m.Hold {
+		t.Errorf("labels %v, hold %t; want %v, held", m.Labels, m.Hold, want)
+	}
+	if err := checkNotHeld(m, "s3://backups/nightly"); err == nil {
+		t.Error("a held set should not be replaced")
+	}
+	LabelChange{Release: true}.apply(m)
+	if m.Hold || len(m.Labels) != 2 {
+		t.Errorf("release changed labels or kept the hold: %+v", m)
+	}
+	if err := checkNotHeld(nil, "/backups"); err != nil {
+		t.Errorf("no set to replace: %v", err)
+	}
+}
+
+func TestRelabelLocal(t *testing.T) {
+	dir := t.TempDir()
+	if err := WriteManifest(dir, &Manifest{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RelabelLocal(dir, LabelChange{Add: []string{"pre-migration"}, Hold: true}, nil); err != nil {
+		t.Fatal(err)
+	}
+	m, err := LoadManifest(dir)
+	if err != nil || !m.Hold || len(m.Labels) != 1 {
+		t.Fatalf("relabelled %+v, %v", m, err)
+	}
+	if err := checkHeldDumpSets(dir, nil); err == nil {
+		t.Error("dumping over a held set should be refused")
+	}
+}
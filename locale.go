@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Locale mismatch actions
+const (
+	// LocaleWarn logs mismatches and restores anyway (the default)
+	LocaleWarn = "warn"
+	// LocaleFail stops the restore before anything is created
+	LocaleFail = "fail"
+	// LocaleMatch creates the destination databases with the source's locale settings
+	LocaleMatch = "match"
+)
+
+// LocaleCheckConfig decides what happens when a destination's encoding, collation, or
+// collation version differs from the source's
+type LocaleCheckConfig struct {
+	// OnMismatch is warn, fail, or match
+	OnMismatch string `json:"on_mismatch"`
+}
+
+func (c *LocaleCheckConfig) validate() error {
+	switch c.OnMismatch {
+	case "", LocaleWarn, LocaleFail, LocaleMatch:
+		return nil
+	}
+	return fmt.Errorf("on_mismatch must be %s, %s, or %s, not %q", LocaleWarn, LocaleFail, LocaleMatch, c.OnMismatch)
+}
+
+// DatabaseLocale is a database's encoding and default collation. CollationVersion is
+// the version the collation library reports now, which changes with glibc and ICU
+// upgrades and with them the order of text.
+type DatabaseLocale struct {
+	Encoding string `json:"encoding"`
+	Collate  string `json:"collate"`
+	Ctype    string `json:"ctype"`
+	Provider string `json:"provider"`
+	// Locale is the ICU or builtin provider's locale
+	Locale           string `json:"locale,omitempty"`
+	CollationVersion string `json:"collation_version,omitempty"`
+}
+
+// localeQuery reads the locale of a database; the provider columns arrived in
+// PostgreSQL 15 and daticulocale became datlocale in 17
+func localeQuery(versionNum int, dbname string) string {
+	columns := "'c', '', ''"
+	switch {
+	case majorVersion(versionNum) >= 17:
+		columns = "datlocprovider, coalesce(datlocale, ''), coalesce(pg_database_collation_actual_version(oid), '')"
+	case majorVersion(versionNum) >= 15:
+		columns = "datlocprovider, coalesce(daticulocale, ''), coalesce(pg_database_collation_actual_version(oid), '')"
+	}
+	return fmt.Sprintf("SELECT pg_encoding_to_char(encoding), datcollate, datctype, %s FROM pg_database WHERE datname = %s;",
+		columns, quoteLiteral(dbname))
+}
+
+// readLocale returns the locale of the database dbname on config's server
+func readLocale(config DBConfig, versionNum int, dbname string) (*DatabaseLocale, error) {
+	rows, err := queryRows(config, localeQuery(versionNum, dbname))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the locale of %s: %w", dbname, err)
+	}
+	if len(rows) != 1 || len(rows[0]) != 6 {
+		return nil, fmt.Errorf("database %s not found", dbname)
+	}
+	row := rows[0]
+	return &DatabaseLocale{Encoding: row[0], Collate: row[1], Ctype: row[2], Provider: row[3], Locale: row[4], CollationVersion: row[5]}, nil
+}
+
+// compareLocales describes how dest differs from src
+func compareLocales(src, dest *DatabaseLocale) []string {
+	var diffs []string
+	for _, f := range []struct{ name, src, dest string }{
+		{"encoding", src.Encoding, dest.Encoding},
+		{"LC_COLLATE", src.Collate, dest.Collate},
+		{"LC_CTYPE", src.Ctype, dest.Ctype},
+		{"locale provider", src.Provider, dest.Provider},
+		{"provider locale", src.Locale, dest.Locale},
+	} {
+		if f.src != f.dest {
+			diffs = append(diffs, fmt.Sprintf("%s %q on the source, %q on the destination", f.name, f.src, f.dest))
+		}
+	}
+	// Versions are only comparable within one provider, and unknown before PostgreSQL 15
+	if len(diffs) == 0 && src.CollationVersion != "" && dest.CollationVersion != "" && src.CollationVersion != dest.CollationVersion {
+		diffs = append(diffs, fmt.Sprintf("collation version %s on the source, %s on the destination; text may sort differently", src.CollationVersion, dest.CollationVersion))
+	}
+	return diffs
+}
+
+// createOptions returns the CREATE DATABASE options reproducing a locale on a server of
+// the given version. template0 is required to pick a locale other than template1's.
+func (l *DatabaseLocale) createOptions(versionNum int) (string, error) {
+	opts := fmt.Sprintf(" TEMPLATE template0 ENCODING %s LC_COLLATE %s LC_CTYPE %s",
+		quoteLiteral(l.Encoding), quoteLiteral(l.Collate), quoteLiteral(l.Ctype))
+	switch l.Provider {
+	case "", "c":
+		if majorVersion(versionNum) >= 15 {
+			opts += " LOCALE_PROVIDER libc"
+		}
+	case "i":
+		if majorVersion(versionNum) < 15 {
+			return "", fmt.Errorf("the source uses an ICU default collation, which PostgreSQL %d can't create", majorVersion(versionNum))
+		}
+		opts += " LOCALE_PROVIDER icu ICU_LOCALE " + quoteLiteral(l.Locale)
+	case "b":
+		if majorVersion(versionNum) < 17 {
+			return "", fmt.Errorf("the source uses the builtin locale provider, which PostgreSQL %d doesn't have", majorVersion(versionNum))
+		}
+		opts += " LOCALE_PROVIDER builtin BUILTIN_LOCALE " + quoteLiteral(l.Locale)
+	default:
+		return "", fmt.Errorf("unknown locale provider %q", l.Provider)
+	}
+	return opts, nil
+}
+
+// recordedLocales returns the locale the manifest in dir recorded for each source database
+func recordedLocales(dir string) map[string]*DatabaseLocale {
+	locales := make(map[string]*DatabaseLocale)
+	manifest, err := LoadManifest(dir)
+	if err != nil {
+		return locales
+	}
+	for _, catalog := range manifest.Catalogs {
+		if catalog.Locale != nil {
+			locales[catalog.Database] = catalog.Locale
+		}
+	}
+	return locales
+}
+
+// checkLocale compares a source's recorded locale with what the destination database
+// has, or would get from template1 when it doesn't exist yet, and returns the options
+// to create it with when c asks to match the source
+func (c *LocaleCheckConfig) checkLocale(database string, dest DBConfig, versionNum int, src *DatabaseLocale) (string, error) {
+	if src == nil {
+		debugf("The manifest records no locale for %s; skipping the locale check", database)
+		return "", nil
+	}
+	exists, err := databaseExists(dest, dest.DBName)
+	if err != nil {
+		return "", err
+	}
+	target := "template1"
+	if exists {
+		target = dest.DBName
+	}
+	destLocale, err := readLocale(maintenanceConfig(dest), versionNum, target)
+	if err != nil {
+		return "", err
+	}
+	diffs := compareLocales(src, destLocale)
+	if len(diffs) == 0 {
+		return "", nil
+	}
+
+	mode := LocaleWarn
+	if c != nil && c.OnMismatch != "" {
+		mode = c.OnMismatch
+	}
+	// A database created to match can't change the collation library's version
+	same := *destLocale
+	same.CollationVersion = src.CollationVersion
+	if mode == LocaleMatch && same == *src {
+		mode = LocaleWarn
+	}
+	summary := fmt.Sprintf("%s locale differs from the source: %s", database, strings.Join(diffs, "; "))
+	switch {
+	case mode == LocaleFail:
+		return "", fmt.Errorf("%s", summary)
+	case mode == LocaleMatch && exists:
+		return "", fmt.Errorf("%s, and %s already exists so it can't be created to match", summary, dest.DBName)
+	case mode == LocaleMatch:
+		opts, err := src.createOptions(versionNum)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", summary, err)
+		}
+		log.Printf("%s; creating %s to match the source", summary, dest.DBName)
+		return opts, nil
+	}
+	log.Printf("WARNING: %s", summary)
+	return "", nil
+}
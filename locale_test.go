@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompareLocales(t *testing.T) {
+	src := &DatabaseLocale{Encoding: "UTF8", Collate: "en_US.UTF-8", Ctype: "en_US.UTF-8", Provider: "c", CollationVersion: "2.31"}
+	same := *src
+	if diffs := compareLocales(src, &same); len(diffs) != 0 {
+		t.Errorf("identical locales differ: %v", diffs)
+	}
+
+	upgraded := *src
+	upgraded.CollationVersion = "2.36"
+	if diffs := compareLocales(src, &upgraded); len(diffs) != 1 || !strings.Contains(diffs[0], "collation version 2.31") {
+		t.Errorf("glibc upgrade not reported: %v", diffs)
+	}
+	older := upgraded
+	older.CollationVersion = ""
+	if diffs := compareLocales(src, &older); len(diffs) != 0 {
+		t.Errorf("unknown version reported: %v", diffs)
+	}
+
+	cLocale := &DatabaseLocale{Encoding: "SQL_ASCII", Collate: "C", Ctype: "C", Provider: "c", CollationVersion: "2.36"}
+	diffs := compareLocales(src, cLocale)
+	if len(diffs) != 3 || !strings.HasPrefix(diffs[0], `encoding "UTF8" on the source, "SQL_ASCII"`) {
+		t.Errorf("diffs = %v", diffs)
+	}
+}
+
+func TestLocaleCreateOptions(t *testing.T) {
+	icu := &DatabaseLocale{Encoding: "UTF8", Collate: "en_US.UTF-8", Ctype: "en_US.UTF-8", Provider: "i", Locale: "en-US"}
+	got, err := icu.createOptions(160004)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := ` TEMPLATE template0 ENCODING 'UTF8' LC_COLLATE 'en_US.UTF-8' LC_CTYPE 'en_US.UTF-8' LOCALE_PROVIDER icu ICU_LOCALE 'en-US'`; got != want {
+		t.Errorf("createOptions = %s", got)
+	}
+	if _, err := icu.createOptions(140010); err == nil {
+		t.Error("ICU default collation accepted by PostgreSQL 14")
+	}
+	libc := &DatabaseLocale{Encoding: "UTF8", Collate: "C", Ctype: "C", Provider: "c"}
+	if got, _ := libc.createOptions(130000); strings.Contains(got, "LOCALE_PROVIDER") {
+		t.Errorf("PostgreSQL 13 given a locale provider: %s", got)
+	}
+
+	if q := localeQuery(170002, "tenant"); !strings.Contains(q, "datlocale") {
+		t.Errorf("PostgreSQL 17 query = %s", q)
+	}
+	if q := localeQuery(140000, "tenant"); strings.Contains(q, "datlocprovider") {
+		t.Errorf("PostgreSQL 14 query = %s", q)
+	}
+	if err := (&LocaleCheckConfig{OnMismatch: "ignore"}).validate(); err == nil {
+		t.Error("unknown on_mismatch accepted")
+	}
+}
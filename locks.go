@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LockPolicy keeps restore sessions from waiting silently on locks held by others
+type LockPolicy struct {
+	// LockTimeout fails a restore statement that waits longer than this for a lock, e.g. "1min"
+	LockTimeout string `json:"lock_timeout"`
+	// CheckEvery is how often blocked restore sessions are looked for; default 10s
+	CheckEvery string `json:"check_every"`
+	// TerminateIdleAfter terminates blockers idle in a transaction for longer than this;
+	// empty only logs them
+	TerminateIdleAfter string `json:"terminate_idle_after"`
+}
+
+// validate checks the policy's durations
+func (p *LockPolicy) validate() error {
+	if strings.ContainsAny(p.LockTimeout, " \\'\"") {
+		return fmt.Errorf("lock_timeout %q must not contain spaces or quotes", p.LockTimeout)
+	}
+	for name, value := range map[string]string{"check_every": p.CheckEvery, "terminate_idle_after": p.TerminateIdleAfter} {
+		if value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+			return fmt.Errorf("invalid %s %q: use a positive duration such as 30s", name, value)
+		}
+	}
+	return nil
+}
+
+// session sets the lock timeout on a restore connection
+func (p *LockPolicy) session(config DBConfig) DBConfig {
+	if p == nil || p.LockTimeout == "" {
+		return config
+	}
+	config.Options = strings.TrimSpace(config.Options + " -c lock_timeout=" + p.LockTimeout)
+	return config
+}
+
+// blockerQuery lists the sessions blocking restore sessions in the current database
+const blockerQuery = `SELECT w.pid, b.pid, b.usename, b.application_name, coalesce(b.client_addr::text, 'local'),
+	b.state, EXTRACT(EPOCH FROM now() - b.state_change)::int,
+	left(regexp_replace(b.query, '[|[:space:]]+', ' ', 'g'), 120)
+FROM pg_stat_activity w
+CROSS JOIN LATERAL unnest(pg_blocking_pids(w.pid)) AS blocker(pid)
+JOIN pg_stat_activity b ON b.pid = blocker.pid
+WHERE w.datname = current_database() AND w.application_name IN ('pg_restore', 'psql')
+	AND w.pid <> pg_backend_pid()
+ORDER BY w.pid, b.pid;`
+
+// Blocker is a session holding a lock a restore session waits for
+type Blocker struct {
+	WaitingPID  int
+	PID         int
+	User        string
+	Application string
+	Client      string
+	State       string
+	// Idle is how long the blocker has been in its current state
+	Idle  time.Duration
+	Query string
+}
+
+// parseBlockers parses blockerQuery rows
+func parseBlockers(rows [][]string) ([]Blocker, error) {
+	var blockers []Blocker
+	for _, row := range rows {
+		if len(row) < 8 {
+			return nil, fmt.Errorf("unexpected blocker row %q", strings.Join(row, "|"))
+		}
+		waiting, err1 := strconv.Atoi(row[0])
+		pid, err2 := strconv.Atoi(row[1])
+		idle, err3 := strconv.Atoi(row[6])
+		if err1 != nil || err2 != nil || err3 != nil {
+			return nil, fmt.Errorf("unexpected blocker row %q", strings.Join(row, "|"))
+		}
+		blockers = append(blockers, Blocker{
+			WaitingPID: waiting, PID: pid, User: row[2], Application: row[3], Client: row[4],
+			State: row[5], Idle: time.Duration(idle) * time.Second, Query: strings.Join(row[7:], "|"),
+		})
+	}
+	return blockers, nil
+}
+
+// terminable reports whether a blocker has sat idle in a transaction past the grace
+// period. The restore's own sessions are never terminated.
+func (b Blocker) terminable(grace time.Duration) bool {
+	if grace <= 0 || b.Application == "pg_restore" || b.Application == "psql" {
+		return false
+	}
+	return strings.HasPrefix(b.State, "idle in transaction") && b.Idle >= grace
+}
+
+// lockWatcher polls for blocked restore sessions while a step runs
+type lockWatcher struct {
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// watch logs the sessions blocking restores into config until the returned watcher is
+// stopped, terminating idle ones per the policy
+func (p *LockPolicy) watch(config DBConfig, step string) *lockWatcher {
+	if p == nil {
+		return nil
+	}
+	every := 10 * time.Second
+	if p.CheckEvery != "" {
+		every, _ = time.ParseDuration(p.CheckEvery)
+	}
+	var grace time.Duration
+	if p.TerminateIdleAfter != "" {
+		grace, _ = time.ParseDuration(p.TerminateIdleAfter)
+	}
+
+	w := &lockWatcher{stop: make(chan struct{})}
+	w.done.Add(1)
+	go func() {
+		defer w.done.Done()
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-ticker.C:
+				checkBlockers(config, step, grace)
+			}
+		}
+	}()
+	return w
+}
+
+// Stop ends the watcher and waits for a check in progress
+func (w *lockWatcher) Stop() {
+	if w == nil {
+		return
+	}
+	close(w.stop)
+	w.done.Wait()
+}
+
+// checkBlockers logs who blocks restore sessions in config and terminates idle blockers
+// beyond grace
+func checkBlockers(config DBConfig, step string, grace time.Duration) {
+	rows, err := catalogRows(config, blockerQuery)
+	if err != nil {
+		debugf("Could not check for blocking sessions during %s: %v", step, err)
+		return
+	}
+	blockers, err := parseBlockers(rows)
+	if err != nil {
+		log.Printf("Could not check for blocking sessions during %s: %v", step, err)
+		return
+	}
+	for _, b := range blockers {
+		log.Printf("WARNING: %s: restore session %d on %s is waiting for a lock held by pid %d (%s, %s from %s, %s for %v): %s",
+			step, b.WaitingPID, config.DBName, b.PID, b.User, b.Application, b.Client, b.State, b.Idle, b.Query)
+		if !b.terminable(grace) {
+			continue
+		}
+		log.Printf("Terminating pid %d, idle in transaction for %v while blocking %s", b.PID, b.Idle, step)
+		output, err := newPsqlCmd(config, "-t", "-A", "-c", fmt.Sprintf("SELECT pg_terminate_backend(%d);", b.PID)).CombinedOutput()
+		if err != nil {
+			log.Printf("Failed to terminate pid %d: %v, output: %s", b.PID, err, output)
+		}
+	}
+}
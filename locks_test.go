@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseBlockers(t *testing.T) {
+	rows := [][]string{{"101", "202", "app", "report-job", "10.0.0.5", "idle in transaction", "300", "SELECT a ", " b FROM t"}}
+	blockers, err := parseBlockers(rows)
+	if err != nil || len(blockers) != 1 {
+		t.Fatalf("got %v, %v", blockers, err)
+	}
+	b := blockers[0]
+	if b.WaitingPID != 101 || b.PID != 202 || b.Idle != 5*time.Minute || b.Query != "SELECT a | b FROM t" {
+		t.Errorf("parsed %+v", b)
+	}
+	if _, err := parseBlockers([][]string{{"x"}}); err == nil {
+		t.Error("expected an error for a short row")
+	}
+}
+
+func TestBlockerTerminable(t *testing.T) {
+	idle := Blocker{Application: "report-job", State: "idle in transaction", Idle: 5 * time.Minute}
+	if !idle.terminable(time.Minute) {
+		t.Error("expected an idle blocker past the grace period to be terminable")
+	}
+	if idle.terminable(0) || idle.terminable(10*time.Minute) {
+		t.Error("expected no termination without or within the grace period")
+	}
+	active := idle
+	active.State = "active"
+	own := idle
+	own.Application = "pg_restore"
+	if active.terminable(time.Minute) || own.terminable(time.Minute) {
+		t.Error("expected active blockers and restore sessions to be left alone")
+	}
+}
+
+func TestLockPolicySession(t *testing.T) {
+	if got := (&LockPolicy{LockTimeout: "1min"}).session(DBConfig{}).Options; got != "-c lock_timeout=1min" {
+		t.Errorf("options = %q", got)
+	}
+	if got := (*LockPolicy)(nil).session(DBConfig{Options: "-c a=b"}).Options; got != "-c a=b" {
+		t.Errorf("options without a policy = %q", got)
+	}
+	if err := (&LockPolicy{TerminateIdleAfter: "soon"}).validate(); err == nil {
+		t.Error("expected an invalid duration to be rejected")
+	}
+}
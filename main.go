@@ -1,69 +1,489 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"log"
+	"os"
+	"os/exec"
+	"strings"
 	"time"
 )
 
+// cliFlags are the command-line flags shared by the workflow and its subcommands
+type cliFlags struct {
+	configPath           string
+	force                bool
+	dumpDir              string
+	perTable             bool
+	yesIMeanIt           bool
+	syncSnapshots        bool
+	maxSkew              time.Duration
+	singleTx             bool
+	retryPreData         bool
+	bundle               bool
+	refreshMatviews      bool
+	refreshConcurrently  bool
+	disableEventTriggers bool
+	skipExtensionObjects bool
+	incremental          bool
+	cdc                  bool
+	cdcTimeout           time.Duration
+	concurrency          int
+	maxBadRows           int
+	quiet                bool
+	verbose              bool
+	debug                bool
+	discoverFDW          bool
+	plainData            bool
+	splitGB              float64
+	tarArchives          bool
+	partitions           bool
+	unlogged             bool
+	disableTriggers      string
+	detachPartitions     bool
+	grantsDryRun         bool
+	clone                bool
+	blueGreen            bool
+	dropPrevious         bool
+	validateCatalog      bool
+	nonSuperuser         string
+	noRunLock            bool
+	restorePoints        bool
+	checkFKs             bool
+	tocTiming            bool
+	statusFile           string
+	progressFile         string
+	progressMetrics      string
+	profile              string
+	planFile             string
+	approvedPlan         string
+}
+
+// parseFlags parses the command line into its flags
+func parseFlags() *cliFlags {
+	f := &cliFlags{}
+	flag.StringVar(&f.configPath, "config", "", "Path to optional JSON configuration file")
+	flag.BoolVar(&f.force, "force", false, "Terminate active sessions when dropping databases")
+	flag.StringVar(&f.dumpDir, "dump-dir", "dump_test", "Directory holding dump files and run state")
+	flag.BoolVar(&f.perTable, "per-table", false, "Restore data table by table, quarantining tables that fail")
+	flag.BoolVar(&f.yesIMeanIt, "yes-i-mean-it", false, "Confirm database drops without prompting, e.g. for scheduled runs")
+	flag.BoolVar(&f.yesIMeanIt, "force-confirm", false, "Older name of -yes-i-mean-it")
+	flag.BoolVar(&f.syncSnapshots, "snapshot", false, "Dump all sections of both databases from snapshots exported before the first dump")
+	flag.DurationVar(&f.maxSkew, "max-snapshot-skew", time.Minute, "Warn when the databases were captured further apart than this")
+	flag.BoolVar(&f.singleTx, "single-transaction", false, "Restore plain-text sections in a single transaction that stops at the first error")
+	flag.BoolVar(&f.retryPreData, "retry-pre-data", false, "Restore plain pre-data object by object, retrying objects that fail on dependencies in later passes")
+	flag.BoolVar(&f.bundle, "bundle", false, "Tar step logs, manifest, and run report into <dump-dir>/bundle_<runID>.tar.gz")
+	flag.BoolVar(&f.refreshMatviews, "refresh-matviews", false, "Refresh materialized views in dependency order after restore")
+	flag.BoolVar(&f.refreshConcurrently, "refresh-concurrently", false, "With -refresh-matviews, refresh CONCURRENTLY where a view has a unique index")
+	flag.BoolVar(&f.disableEventTriggers, "disable-event-triggers", false, "Keep event triggers from firing during restore; dumped ones are created last")
+	flag.BoolVar(&f.skipExtensionObjects, "skip-extension-objects", false, "Leave post-data objects owned by extensions out of the restore")
+	flag.BoolVar(&f.incremental, "incremental", false, "Refresh the configured incremental tables from their watermarks instead of a full dump and restore")
+	flag.BoolVar(&f.cdc, "cdc", false, "Dump from a logical replication slot's snapshot and replay changes made since the dump after restore")
+	flag.DurationVar(&f.cdcTimeout, "cdc-timeout", 30*time.Minute, "With -cdc, fail when the destinations haven't caught up within this long")
+	flag.IntVar(&f.concurrency, "concurrency", 4, "Number of tenants migrate-all migrates at once")
+	flag.IntVar(&f.maxBadRows, "max-bad-rows", 0, "With -per-table, skip up to this many rejected rows per table into spill files")
+	flag.BoolVar(&f.quiet, "q", false, "Log only errors and the final summary")
+	flag.BoolVar(&f.verbose, "v", false, "Log every command before it runs")
+	flag.BoolVar(&f.debug, "debug", false, "Same as -v")
+	flag.BoolVar(&f.discoverFDW, "discover-fdw", false, "Discover foreign server targets in the source catalogs and retarget them from that plan")
+	flag.BoolVar(&f.plainData, "plain-data", false, "Dump the data section as plain SQL instead of a custom-format archive")
+	flag.Float64Var(&f.splitGB, "split-gb", 0, "With -plain-data, split each data dump into parts of at most this many GB")
+	flag.BoolVar(&f.tarArchives, "tar", false, "Dump data and post-data as tar archives instead of custom-format archives")
+	flag.BoolVar(&f.partitions, "partitions", false, "Dump each leaf partition's data into its own archive so partitions dump and restore in parallel")
+	flag.BoolVar(&f.unlogged, "unlogged", false, "Load data into unlogged tables and switch them back to logged before post-data, skipping WAL during the load")
+	flag.StringVar(&f.disableTriggers, "disable-triggers", "", "With -incremental, keep user triggers from firing while changes merge: replica or disable")
+	flag.BoolVar(&f.detachPartitions, "detach-partitions", false, "Detach separately dumped partitions while their data loads and attach them again afterwards")
+	flag.BoolVar(&f.grantsDryRun, "grants-dry-run", false, "Print the statements rendered from the grant templates instead of running them")
+	flag.BoolVar(&f.clone, "clone", false, "Copy the sources with CREATE DATABASE ... TEMPLATE instead of dumping and restoring; needs a shared cluster")
+	flag.BoolVar(&f.blueGreen, "blue-green", false, "Restore into <db>_staging and rename it into place once every check has passed")
+	flag.BoolVar(&f.dropPrevious, "drop-previous", false, "With -blue-green, drop the replaced databases after cutover instead of keeping them as <db>_previous_<timestamp>")
+	flag.BoolVar(&f.validateCatalog, "validate-catalog", false, "After restore, compare the restored databases with the source catalogs recorded in the manifest")
+	flag.StringVar(&f.nonSuperuser, "non-superuser", "", "Run as a role without superuser rights: check lists the grants it lacks and stops, skip leaves out what it can't restore")
+	flag.BoolVar(&f.noRunLock, "no-run-lock", false, "Don't lock the destination databases against other runs")
+	flag.BoolVar(&f.restorePoints, "restore-points", false, "Create named restore points on the destination clusters before and after the run and log their LSNs")
+	flag.BoolVar(&f.checkFKs, "check-foreign-keys", false, "After restore, look for rows that violate foreign keys and report them")
+	flag.BoolVar(&f.tocTiming, "toc-timing", false, "Run pg_restore with --verbose and report the slowest objects each restore step restored")
+	flag.StringVar(&f.statusFile, "status-file", "", "Keep JSON progress in this file for external monitors to poll")
+	flag.StringVar(&f.progressFile, "progress-file", "", "Keep the latest progress of every dump, restore, and transfer in this JSON file")
+	flag.StringVar(&f.progressMetrics, "progress-metrics", "", "Keep progress as Prometheus gauges in this file for node_exporter's textfile collector")
+	flag.StringVar(&f.profile, "profile", "", "Apply this profile's connection settings from the configuration, e.g. staging")
+	flag.StringVar(&f.planFile, "plan", "", "Write what the run would do to this JSON file for review and stop; run it later with apply <file>")
+	flag.StringVar(&f.approvedPlan, "approved-plan", "", "Set by apply: stop unless the run still matches this reviewed plan")
+	flag.Parse()
+	return f
+}
+
+// validate rejects flags that are invalid or can't be combined
+func (f *cliFlags) validate() error {
+	if f.splitGB < 0 || (f.splitGB > 0 && !f.plainData) {
+		return fmt.Errorf("-split-gb needs -plain-data and a positive size")
+	}
+	if err := validNonSuperuserMode(f.nonSuperuser); err != nil {
+		return fmt.Errorf("invalid -non-superuser: %w", err)
+	}
+	if err := validTriggerMode(f.disableTriggers); err != nil {
+		return fmt.Errorf("invalid -disable-triggers: %w", err)
+	}
+	if f.retryPreData && f.singleTx {
+		return fmt.Errorf("-retry-pre-data can't be combined with -single-transaction")
+	}
+	if f.partitions && f.splitGB > 0 {
+		return fmt.Errorf("-partitions can't be combined with -split-gb")
+	}
+	if f.dropPrevious && !f.blueGreen {
+		return fmt.Errorf("-drop-previous needs -blue-green")
+	}
+	if f.maxBadRows < 0 || (f.maxBadRows > 0 && !f.perTable) {
+		return fmt.Errorf("-max-bad-rows needs -per-table and a positive count")
+	}
+	return nil
+}
+
 func main() {
-	startTime := time.Now()
+	f := parseFlags()
+	if err := f.validate(); err != nil {
+		fatalf("%v", err)
+	}
+	setUpOutput(f)
+	if runStandaloneCommand(f) {
+		return
+	}
 
-	// Source configurations
-	moodysConfig := DBConfig{
-		Host:     "localhost",
-		Port:     "5432",
-		User:     "postgres",
-		Password: "your_password", // Replace with actual password
-		DBName:   "moodys",
+	startTime := time.Now()
+	e := loadEnv(f)
+	if runConfigCommand(e) {
+		return
 	}
 
-	tenantConfig := DBConfig{
-		Host:     "localhost",
-		Port:     "5432",
-		User:     "postgres",
-		Password: "your_password", // Replace with actual password
-		DBName:   "tenant",
+	r := e.selectRun()
+	if runTargetCommand(r) {
+		return
+	}
+	r.checkCombinations()
+	if runRestoreCommand(r) || r.checkPlan() || r.checkPrivileges() {
+		return
 	}
+	r.execute(startTime)
+}
 
-	// Destination configurations (for testing restore)
-	destMoodysConfig := moodysConfig
-	destMoodysConfig.DBName = "moodys_dest"
-	destTenantConfig := tenantConfig
-	destTenantConfig.DBName = "tenant_dest"
+// setUpOutput applies the verbosity and progress flags
+func setUpOutput(f *cliFlags) {
+	switch {
+	case f.verbose || f.debug:
+		setVerbosity(VerbosityDebug)
+	case f.quiet:
+		setVerbosity(VerbosityQuiet)
+	}
+	if underJournald() {
+		log.SetFlags(0)
+		alwaysLog.SetFlags(0)
+	}
+	if f.progressFile != "" {
+		progressSinks = append(progressSinks, newProgressFile(f.progressFile))
+	}
+	if f.progressMetrics != "" {
+		progressSinks = append(progressSinks, newProgressMetrics(f.progressMetrics))
+	}
+}
 
-	// Clean up any existing databases
-	log.Println("Cleaning up existing databases...")
-	if err := DeleteDatabases(moodysConfig, tenantConfig, destMoodysConfig, destTenantConfig); err != nil {
-		log.Fatalf("Failed to cleanup existing databases: %v", err)
+// runStandaloneCommand runs the subcommands that need no configuration and reports
+// whether it ran one
+func runStandaloneCommand(f *cliFlags) bool {
+	switch flag.Arg(0) {
+	// apply runs a reviewed plan with the command line it was written for
+	case "apply":
+		if flag.Arg(1) == "" {
+			fatalf("Usage: apply <plan.json>")
+		}
+		if err := ApplyPlan(flag.Arg(1)); err != nil {
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				os.Exit(exitErr.ExitCode())
+			}
+			fatalf("Failed to apply plan: %v", err)
+		}
+	// init writes a starter configuration, so it runs before one is loaded
+	case "init":
+		sub := flag.NewFlagSet("init", flag.ExitOnError)
+		force := sub.Bool("force", false, "Overwrite an existing configuration file")
+		sub.Parse(flag.Args()[1:])
+		path := sub.Arg(0)
+		if path == "" {
+			path = f.configPath
+		}
+		if path == "" {
+			path = "config.json"
+		}
+		if err := RunInitWizard(path, *force, os.Stdin, os.Stderr); err != nil {
+			fatalf("Setup failed: %v", err)
+		}
+	default:
+		return false
 	}
+	return true
+}
 
-	// Setup source databases with a large number of records
-	// 50 million records should take ~10-15 minutes to generate
-	const numTestRecords = 50000000
-	log.Printf("Setting up source databases with %d records...", numTestRecords)
+// runEnv is the loaded configuration with the connections resolved from it
+type runEnv struct {
+	flags *cliFlags
+	cfg   *Config
+	// moodys, tenant, destMoodys, and destTenant are the built-in connections
+	moodys, tenant, destMoodys, destTenant DBConfig
+	store                                  *ObjectStore
+	replicas                               map[string]*ReplicaConfig
+}
 
-	if err := SetupSourceDatabases(moodysConfig, tenantConfig, numTestRecords); err != nil {
-		log.Fatalf("Failed to setup source databases: %v", err)
+// connections returns the built-in connections by their configuration names
+func (e *runEnv) connections() map[string]*DBConfig {
+	return map[string]*DBConfig{
+		"source_moodys": &e.moodys,
+		"source_tenant": &e.tenant,
+		"dest_moodys":   &e.destMoodys,
+		"dest_tenant":   &e.destTenant,
 	}
+}
+
+// restoreOptions returns the restore settings every restore shares, whatever restores
+// the dump set: the built-in workflow, a configured workflow, reconcile, or consolidate
+func (e *runEnv) restoreOptions(runID string) RestoreOptions {
+	return RestoreOptions{
+		ErrorPolicy:  e.cfg.ErrorPolicy,
+		Encryption:   e.cfg.Encryption,
+		GPG:          e.cfg.GPG,
+		RunID:        runID,
+		OnFailure:    e.cfg.OnRestoreFailure,
+		Locks:        e.cfg.Locks,
+		Watchdog:     e.cfg.Watchdog,
+		PostData:     e.cfg.PostData,
+		IndexMemory:  e.cfg.IndexMemory,
+		TOCTiming:    e.flags.tocTiming,
+		Compat:       e.cfg.Compat,
+		Transforms:   e.cfg.Transforms,
+		NonSuperuser: e.flags.nonSuperuser,
+		Provider:     e.cfg.Provider,
+	}
+}
 
-	// Perform dump workflow
-	log.Println("Starting database dump workflow...")
-	if err := DumpWorkflow(moodysConfig, tenantConfig, "dump_test"); err != nil {
-		log.Fatalf("Failed to dump databases: %v", err)
+// loadEnv loads the configuration, resolves the connections, and validates every
+// section before anything runs
+func loadEnv(f *cliFlags) *runEnv {
+	cfg, err := LoadConfig(f.configPath)
+	if err != nil {
+		fatalf("Failed to load configuration: %v", err)
 	}
+	cfg.Protections.Confirmed = f.yesIMeanIt
+	SetProtections(&cfg.Protections)
 
-	// Perform restore workflow
-	log.Println("Starting database restore workflow...")
-	if err := RestoreWorkflow(moodysConfig, tenantConfig, destMoodysConfig, destTenantConfig, "dump_test"); err != nil {
-		log.Fatalf("Failed to restore databases: %v", err)
+	e := &runEnv{
+		flags: f,
+		cfg:   cfg,
+		// Source configurations
+		moodys: DBConfig{
+			Host:     "localhost",
+			Port:     "5432",
+			User:     "postgres",
+			Password: "your_password", // Replace with actual password
+			DBName:   "moodys",
+		},
+		tenant: DBConfig{
+			Host:     "localhost",
+			Port:     "5432",
+			User:     "postgres",
+			Password: "your_password", // Replace with actual password
+			DBName:   "tenant",
+		},
 	}
 
-	// Validate the restoration
-	log.Println("Validating restored data...")
-	if err := ValidateDatabaseContent(tenantConfig, destTenantConfig); err != nil {
-		log.Fatalf("Data validation failed: %v", err)
+	// Destination configurations (for testing restore)
+	e.destMoodys = e.moodys
+	e.destMoodys.DBName = "moodys_dest"
+	e.destTenant = e.tenant
+	e.destTenant.DBName = "tenant_dest"
+
+	connections := e.connections()
+	if err := resolveConnections(cfg, f.profile, connections); err != nil {
+		fatalf("Invalid connection configuration: %v", err)
+	}
+	for name, auth := range cfg.Auth {
+		config, ok := connections[name]
+		if !ok {
+			fatalf("Invalid auth configuration: unknown connection %q", name)
+		}
+		if err := auth.validate(); err != nil {
+			fatalf("Invalid auth for %s: %v", name, err)
+		}
+		config.Auth = auth
+	}
+	if err := applyDirectEndpoints(cfg.Direct, connections); err != nil {
+		fatalf("Invalid direct configuration: %v", err)
 	}
+	sources := map[string]DBConfig{"source_moodys": e.moodys, "source_tenant": e.tenant}
+	if cfg.Workflow != nil {
+		for name, db := range cfg.Workflow.Databases {
+			sources["source_"+name] = db.Source
+		}
+	}
+	if err := cfg.Protections.resolveReadOnly(sources); err != nil {
+		fatalf("Invalid protections: %v", err)
+	}
+	e.configure()
+	return e
+}
 
-	duration := time.Since(startTime)
-	log.Printf("Complete database backup/restore workflow completed successfully in %v", duration.Round(time.Second))
+// configure validates the configuration's sections and sets up what they enable
+func (e *runEnv) configure() {
+	cfg := e.cfg
+	var err error
+	if err := cfg.ErrorPolicy.validate(); err != nil {
+		fatalf("Invalid error_policy configuration: %v", err)
+	}
+	// Hooks are bound to the final connections when the run starts; checking them here
+	// also covers -plan
+	hookDatabases := make(map[string]DBConfig)
+	for name, config := range e.connections() {
+		hookDatabases[name] = *config
+	}
+	if _, err := NewHooks(cfg.Hooks, workflowPhases, hookDatabases); err != nil {
+		fatalf("Invalid hook configuration: %v", err)
+	}
+	if cfg.GPG != nil {
+		if err := cfg.GPG.validate(); err != nil {
+			fatalf("Invalid gpg configuration: %v", err)
+		}
+	}
+	if err := cfg.Throttle.validate(); err != nil {
+		fatalf("Invalid throttle configuration: %v", err)
+	}
+	activeThrottle = NewThrottle(cfg.Throttle)
+	if activeWindow, err = NewMaintenanceWindow(cfg.MaintenanceWindow); err != nil {
+		fatalf("Invalid maintenance_window configuration: %v", err)
+	}
+	e.replicas = make(map[string]*ReplicaConfig)
+	for name, replica := range cfg.Replicas {
+		if name != "source_moodys" && name != "source_tenant" {
+			fatalf("Invalid replicas configuration: %q is not a source connection", name)
+		}
+		if err := replica.validate(); err != nil {
+			fatalf("Invalid replica for %s: %v", name, err)
+		}
+		e.replicas[strings.TrimPrefix(name, "source_")] = replica
+	}
+	if cfg.Locks != nil {
+		if err := cfg.Locks.validate(); err != nil {
+			fatalf("Invalid locks configuration: %v", err)
+		}
+	}
+	if cfg.PostData != nil {
+		if err := cfg.PostData.validate(); err != nil {
+			fatalf("Invalid post_data configuration: %v", err)
+		}
+	}
+	if cfg.IndexMemory != nil {
+		if err := cfg.IndexMemory.validate(); err != nil {
+			fatalf("Invalid index_memory configuration: %v", err)
+		}
+	}
+	if cfg.Prewarm != nil {
+		if err := cfg.Prewarm.validate(); err != nil {
+			fatalf("Invalid prewarm configuration: %v", err)
+		}
+	}
+	if cfg.Watchdog != nil {
+		if err := cfg.Watchdog.validate(); err != nil {
+			fatalf("Invalid watchdog configuration: %v", err)
+		}
+	}
+	if cfg.Validation != nil {
+		if err := cfg.Validation.validate(); err != nil {
+			fatalf("Invalid validation configuration: %v", err)
+		}
+	}
+	if err := validateQueryPack(cfg.QueryPack); err != nil {
+		fatalf("Invalid query pack: %v", err)
+	}
+	if cfg.Replay != nil {
+		if err := cfg.Replay.validate(); err != nil {
+			fatalf("Invalid replay configuration: %v", err)
+		}
+	}
+	if err := validateGrants(cfg.Grants); err != nil {
+		fatalf("Invalid grants configuration: %v", err)
+	}
+	if err := validateBehaviorChecks(cfg.BehaviorChecks); err != nil {
+		fatalf("Invalid behavior_checks configuration: %v", err)
+	}
+	if err := validateFailurePolicy(cfg.OnRestoreFailure); err != nil {
+		fatalf("Invalid on_restore_failure configuration: %v", err)
+	}
+	if cfg.Storage != nil {
+		if e.store, err = NewObjectStore(*cfg.Storage); err != nil {
+			fatalf("Invalid storage configuration: %v", err)
+		}
+	}
+	if cfg.Compat != nil {
+		if err := cfg.Compat.validate(); err != nil {
+			fatalf("Invalid compat configuration: %v", err)
+		}
+	}
+	if err := validateTransforms(cfg.Transforms); err != nil {
+		fatalf("Invalid transforms configuration: %v", err)
+	}
+	if cfg.Provider != nil {
+		if err := cfg.Provider.validate(); err != nil {
+			fatalf("Invalid provider configuration: %v", err)
+		}
+		// Managed services have no superuser, so their admin role restores what it can
+		if e.flags.nonSuperuser == "" {
+			e.flags.nonSuperuser = NonSuperuserSkip
+		}
+	}
+	if cfg.Email != nil {
+		if err := cfg.Email.validate(); err != nil {
+			fatalf("Invalid email configuration: %v", err)
+		}
+	}
+	if cfg.FDWTuning != nil {
+		if err := cfg.FDWTuning.validate(); err != nil {
+			fatalf("Invalid fdw_tuning configuration: %v", err)
+		}
+	}
+	if cfg.ImportForeignSchema != nil {
+		if err := cfg.ImportForeignSchema.validate(); err != nil {
+			fatalf("Invalid import_foreign_schema configuration: %v", err)
+		}
+	}
+	if cfg.AppRole != nil {
+		if err := cfg.AppRole.validate(); err != nil {
+			fatalf("Invalid app_role configuration: %v", err)
+		}
+	}
+	if cfg.Delta != nil {
+		if err := cfg.Delta.validate(); err != nil {
+			fatalf("Invalid delta configuration: %v", err)
+		}
+	}
+	if cfg.Locale != nil {
+		if err := cfg.Locale.validate(); err != nil {
+			fatalf("Invalid locale configuration: %v", err)
+		}
+	}
+	if cfg.DatabaseSettings != nil {
+		if err := cfg.DatabaseSettings.validate(); err != nil {
+			fatalf("Invalid database_settings configuration: %v", err)
+		}
+	}
+	if err := validateEnvPassthrough(cfg.EnvPassthrough); err != nil {
+		fatalf("Invalid env_passthrough configuration: %v", err)
+	}
+	passEnv = cfg.EnvPassthrough
+	if cfg.FanOut != nil {
+		if err := cfg.FanOut.validate(); err != nil {
+			fatalf("Invalid fan_out configuration: %v", err)
+		}
+	}
+	if cfg.Daemon != nil {
+		if err := cfg.Daemon.validate(); err != nil {
+			fatalf("Invalid daemon configuration: %v", err)
+		}
+	}
 }
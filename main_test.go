@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestCLIFlagsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		flags   cliFlags
+		wantErr bool
+	}{
+		{"defaults", cliFlags{}, false},
+		{"max bad rows with per-table", cliFlags{perTable: true, maxBadRows: 100}, false},
+		{"max bad rows without per-table", cliFlags{maxBadRows: 100}, true},
+		{"negative max bad rows", cliFlags{perTable: true, maxBadRows: -1}, true},
+		{"drop previous without blue-green", cliFlags{dropPrevious: true}, true},
+		{"retry pre-data in one transaction", cliFlags{retryPreData: true, singleTx: true}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.flags.validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, wantErr %t", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// manifestFileName is the name of the manifest written alongside dump artifacts
+const manifestFileName = "manifest.json"
+
+// Manifest describes a dump set
+type Manifest struct {
+	CreatedAt    time.Time          `json:"created_at"`
+	Artifacts    []ManifestArtifact `json:"artifacts"`
+	Snapshots    []SnapshotInfo     `json:"snapshots"`
+	SnapshotSkew string             `json:"snapshot_skew"`
+	CDCSlots     []CDCSlot          `json:"cdc_slots,omitempty"`
+	// Sources records the server each database was dumped from
+	Sources []DumpSource `json:"sources,omitempty"`
+	// Catalogs record the shape of each source database when it was dumped
+	Catalogs []CatalogSnapshot `json:"catalogs,omitempty"`
+	// Labels name the set, e.g. "pre-release", so a restore can select it by label
+	Labels []string `json:"labels,omitempty"`
+	// Hold marks the set immutable: dumps refuse to replace it until it is released
+	Hold bool `json:"hold,omitempty"`
+}
+
+// ManifestArtifact describes one dump file in the set
+type ManifestArtifact struct {
+	Database  string `json:"database"`
+	DBName    string `json:"dbname"`
+	Section   string `json:"section"`
+	File      string `json:"file"`
+	Format    string `json:"format"`
+	Size      int64  `json:"size"`
+	Encrypted bool   `json:"encrypted"`
+	KeyRef    string `json:"key_ref,omitempty"`
+	// Partition names the leaf partition a separately dumped data archive holds
+	Partition string `json:"partition,omitempty"`
+	// Table names the table a delta dump's data archive holds, with the row count and
+	// checksum later delta dumps compare to decide whether to reuse the archive
+	Table    string `json:"table,omitempty"`
+	Rows     int64  `json:"rows,omitempty"`
+	Checksum string `json:"checksum,omitempty"`
+	// Reused marks a delta archive kept from the previous dump set
+	Reused bool `json:"reused,omitempty"`
+}
+
+// WriteManifest stores the manifest in the dump directory
+func WriteManifest(dir string, m *Manifest) error {
+	content, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, manifestFileName), content, 0644); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
+
+// LoadManifest reads the manifest of a dump directory
+func LoadManifest(dir string) (*Manifest, error) {
+	content, err := os.ReadFile(filepath.Join(dir, manifestFileName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	m := &Manifest{}
+	if err := json.Unmarshal(content, m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	return m, nil
+}
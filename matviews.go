@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// matviewQuery lists materialized views with their populated state, whether they have
+// a unique index (required for CONCURRENTLY), and the materialized views they read
+// from, following plain views in between.
+const matviewQuery = `
+WITH RECURSIVE refs(matview, obj) AS (
+    SELECT r.ev_class, d.refobjid
+    FROM pg_rewrite r
+    JOIN pg_depend d ON d.classid = 'pg_rewrite'::regclass AND d.objid = r.oid
+    JOIN pg_class mv ON mv.oid = r.ev_class AND mv.relkind = 'm'
+    WHERE d.refobjid <> r.ev_class
+  UNION
+    SELECT refs.matview, d.refobjid
+    FROM refs
+    JOIN pg_class v ON v.oid = refs.obj AND v.relkind = 'v'
+    JOIN pg_rewrite r ON r.ev_class = v.oid
+    JOIN pg_depend d ON d.classid = 'pg_rewrite'::regclass AND d.objid = r.oid
+    WHERE d.refobjid <> v.oid
+)
+SELECT format('%I.%I', n.nspname, c.relname),
+       c.relispopulated,
+       EXISTS (SELECT 1 FROM pg_index i WHERE i.indrelid = c.oid AND i.indisunique AND i.indpred IS NULL),
+       coalesce(string_agg(DISTINCT format('%I.%I', dn.nspname, dc.relname), ','), '')
+FROM pg_class c
+JOIN pg_namespace n ON n.oid = c.relnamespace
+LEFT JOIN refs ON refs.matview = c.oid
+LEFT JOIN pg_class dc ON dc.oid = refs.obj AND dc.relkind = 'm'
+LEFT JOIN pg_namespace dn ON dn.oid = dc.relnamespace
+WHERE c.relkind = 'm'
+GROUP BY n.nspname, c.relname, c.relispopulated, c.oid
+ORDER BY 1;`
+
+// Matview describes a materialized view in a restored database
+type Matview struct {
+	Name      string
+	Populated bool
+	HasUnique bool
+	DependsOn []string
+}
+
+// parseMatviewRows parses unaligned, |-separated rows of matviewQuery
+func parseMatviewRows(output string) []Matview {
+	var views []Matview
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Split(strings.TrimSpace(line), "|")
+		if len(fields) != 4 {
+			continue
+		}
+		mv := Matview{
+			Name:      fields[0],
+			Populated: fields[1] == "t",
+			HasUnique: fields[2] == "t",
+		}
+		if fields[3] != "" {
+			mv.DependsOn = strings.Split(fields[3], ",")
+		}
+		views = append(views, mv)
+	}
+	return views
+}
+
+// listMatviews returns the materialized views of a database
+func listMatviews(config DBConfig) ([]Matview, error) {
+	cmd := newPsqlCmd(config, "-t", "-A", "-F", "|", "-c", matviewQuery)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list materialized views in %s: %w, output: %s", config.DBName, err, output)
+	}
+	return parseMatviewRows(string(output)), nil
+}
+
+// RefreshMatviews refreshes every materialized view of a database, dependencies first.
+// With concurrently, populated views with a unique index are refreshed CONCURRENTLY;
+// the rest fall back to a plain refresh.
+func RefreshMatviews(config DBConfig, concurrently bool) error {
+	views, err := listMatviews(config)
+	if err != nil {
+		return err
+	}
+	if len(views) == 0 {
+		return nil
+	}
+
+	byName := make(map[string]Matview, len(views))
+	names := make([]string, 0, len(views))
+	deps := make(map[string][]string, len(views))
+	for _, mv := range views {
+		byName[mv.Name] = mv
+		names = append(names, mv.Name)
+		deps[mv.Name] = mv.DependsOn
+	}
+	ordered, err := topoSort(names, deps)
+	if err != nil {
+		return fmt.Errorf("cannot order materialized views in %s: %w", config.DBName, err)
+	}
+
+	log.Printf("Refreshing %d materialized views in %s", len(ordered), config.DBName)
+	for _, name := range ordered {
+		mv := byName[name]
+		refreshSQL := "REFRESH MATERIALIZED VIEW " + name
+		if concurrently && mv.Populated && mv.HasUnique {
+			refreshSQL = "REFRESH MATERIALIZED VIEW CONCURRENTLY " + name
+		} else if concurrently {
+			log.Printf("Materialized view %s is unpopulated or has no unique index; refreshing without CONCURRENTLY", name)
+		}
+
+		span := startSpan("refresh "+name, "db.name", config.DBName)
+		cmd := newPsqlCmd(config, "-v", "ON_ERROR_STOP=1", "-c", refreshSQL)
+		output, err := runStreaming(cmd, "refresh_"+config.DBName+"_"+name, nil)
+		span.End(err)
+		if err != nil {
+			return fmt.Errorf("failed to refresh materialized view %s in %s: %w, output: %s", name, config.DBName, err, output)
+		}
+	}
+	return nil
+}
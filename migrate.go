@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TenantEntry is one tenant database in a migration registry
+type TenantEntry struct {
+	Name     string
+	SourceDB string
+	DestDB   string
+}
+
+// parseRegistryCSV reads "tenant,source_db[,dest_db]" rows after a header line
+func parseRegistryCSV(r io.Reader) ([]TenantEntry, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse registry: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("registry is empty")
+	}
+
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"tenant", "source_db"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("registry is missing column %q", required)
+		}
+	}
+
+	var tenants []TenantEntry
+	for _, record := range records[1:] {
+		entry := TenantEntry{
+			Name:     record[columns["tenant"]],
+			SourceDB: record[columns["source_db"]],
+		}
+		if i, ok := columns["dest_db"]; ok {
+			entry.DestDB = record[i]
+		}
+		tenants = append(tenants, entry)
+	}
+	return tenants, nil
+}
+
+// LoadRegistry reads tenants from a CSV file, or from a control table in the given
+// database when source is "table:<schema.table>"
+func LoadRegistry(source string, config DBConfig) ([]TenantEntry, error) {
+	if table, ok := strings.CutPrefix(source, "table:"); ok {
+		query := fmt.Sprintf("COPY (SELECT tenant, source_db, dest_db FROM %s ORDER BY tenant) TO STDOUT WITH (FORMAT csv, HEADER)",
+			quoteQualifiedName(table))
+		output, err := newPsqlCmd(config, "-v", "ON_ERROR_STOP=1", "-c", query).Output()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read registry table %s: %w", table, err)
+		}
+		return parseRegistryCSV(strings.NewReader(string(output)))
+	}
+
+	f, err := os.Open(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open registry: %w", err)
+	}
+	defer f.Close()
+	return parseRegistryCSV(f)
+}
+
+// TenantResult is one row of the migration matrix
+type TenantResult struct {
+	Tenant   string            `json:"tenant"`
+	Status   string            `json:"status"`
+	Duration string            `json:"duration"`
+	Phases   map[string]string `json:"phases"`
+	Error    string            `json:"error,omitempty"`
+	DumpDir  string            `json:"dump_dir"`
+}
+
+// latestReport loads the most recent run report written under dir
+func latestReport(dir string) (*RunReport, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "reports", "report_*.json"))
+	if err != nil || len(paths) == 0 {
+		return nil, fmt.Errorf("no run report in %s", dir)
+	}
+	sort.Strings(paths)
+	content, err := os.ReadFile(paths[len(paths)-1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to read run report: %w", err)
+	}
+	report := &RunReport{}
+	if err := json.Unmarshal(content, report); err != nil {
+		return nil, fmt.Errorf("failed to parse run report: %w", err)
+	}
+	return report, nil
+}
+
+// runMigration runs this program as a child process for one migration unit, so each
+// tenant gets its own dump directory, step logs, and run report
+func runMigration(name, dumpDir string, childArgs []string, migrateArgs ...string) TenantResult {
+	result := TenantResult{Tenant: name, DumpDir: dumpDir, Phases: make(map[string]string)}
+	start := time.Now()
+
+	exe, err := os.Executable()
+	if err == nil {
+		args := append(append([]string{}, childArgs...), "-dump-dir", dumpDir, "migrate")
+		cmd := exec.Command(exe, append(args, migrateArgs...)...)
+		_, err = runStreaming(cmd, "migrate_"+name, nil)
+	}
+	result.Duration = time.Since(start).Round(time.Second).String()
+	result.Status, result.Error = statusOf(err)
+
+	if report, reportErr := latestReport(dumpDir); reportErr == nil {
+		for _, phase := range report.Phases {
+			result.Phases[phase.Name] = phase.Status
+		}
+		if report.Error != "" {
+			result.Error = report.Error
+		}
+	}
+	return result
+}
+
+// MigrateAll migrates the shared moodys database and then every tenant in the registry,
+// running at most concurrency tenants at a time. Tenants are skipped when moodys fails,
+// since their foreign tables would point at a missing server. Tenants without a
+// destination database get one from the naming template.
+func MigrateAll(tenants []TenantEntry, naming NameTemplates, dir string, concurrency int, childArgs []string) ([]TenantResult, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	for i, t := range tenants {
+		if t.DestDB != "" {
+			continue
+		}
+		if naming.Database == "" {
+			return nil, fmt.Errorf("tenant %s has no dest_db and no naming.database template is configured", t.Name)
+		}
+		dest, err := renderName(naming.Database, NameData{Source: t.SourceDB, Tenant: t.Name}, "")
+		if err != nil {
+			return nil, err
+		}
+		tenants[i].DestDB = dest
+	}
+
+	shared := runMigration("moodys", filepath.Join(dir, "moodys"), childArgs, "moodys")
+	results := []TenantResult{shared}
+	if shared.Status != "succeeded" {
+		for _, t := range tenants {
+			results = append(results, TenantResult{Tenant: t.Name, Status: "skipped", Error: "moodys migration failed"})
+		}
+		return results, nil
+	}
+
+	tenantResults := make([]TenantResult, len(tenants))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, t := range tenants {
+		wg.Add(1)
+		go func(i int, t TenantEntry) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			log.Printf("Migrating tenant %s (%s -> %s)", t.Name, t.SourceDB, t.DestDB)
+			tenantResults[i] = runMigration(t.Name, filepath.Join(dir, "tenants", t.Name), childArgs, "tenant", t.SourceDB, t.DestDB, t.Name)
+			log.Printf("Tenant %s %s in %s", t.Name, tenantResults[i].Status, tenantResults[i].Duration)
+		}(i, t)
+	}
+	wg.Wait()
+	return append(results, tenantResults...), nil
+}
+
+// matrixPhases are the columns of the migration matrix
+var matrixPhases = []string{"dump", "restore", "validate"}
+
+// WriteMigrationMatrix writes the results as CSV and JSON under dir and returns the CSV path
+func WriteMigrationMatrix(dir, runID string, results []TenantResult) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create migration directory: %w", err)
+	}
+
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	w.Write(append(append([]string{"tenant", "status"}, matrixPhases...), "duration", "error"))
+	for _, r := range results {
+		row := []string{r.Tenant, r.Status}
+		for _, phase := range matrixPhases {
+			row = append(row, r.Phases[phase])
+		}
+		w.Write(append(row, r.Duration, r.Error))
+	}
+	w.Flush()
+
+	csvPath := filepath.Join(dir, fmt.Sprintf("migration_%s.csv", runID))
+	if err := os.WriteFile(csvPath, []byte(b.String()), 0644); err != nil {
+		return "", fmt.Errorf("failed to write migration matrix: %w", err)
+	}
+
+	content, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode migration matrix: %w", err)
+	}
+	if err := os.WriteFile(strings.TrimSuffix(csvPath, ".csv")+".json", content, 0644); err != nil {
+		return "", fmt.Errorf("failed to write migration matrix: %w", err)
+	}
+	return csvPath, nil
+}
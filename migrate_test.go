@@ -0,0 +1,30 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRegistryCSV(t *testing.T) {
+	registry := `# tenants moving to the new cluster
+dest_db,tenant,source_db
+acme_v2,acme,acme
+globex_v2, globex, globex
+`
+	got, err := parseRegistryCSV(strings.NewReader(registry))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []TenantEntry{
+		{Name: "acme", SourceDB: "acme", DestDB: "acme_v2"},
+		{Name: "globex", SourceDB: "globex", DestDB: "globex_v2"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if _, err := parseRegistryCSV(strings.NewReader("tenant,dest_db\nacme,acme_v2\n")); err == nil {
+		t.Error("expected error for missing source_db column")
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// NameTemplates derive destination names from text/template strings such as
+// "{{.Source}}_staging" or "{{.Tenant}}_v2", instead of configuring every pair
+type NameTemplates struct {
+	// Database names the destination database
+	Database string `json:"database"`
+	// Server names the foreign server tenant uses to reach moodys
+	Server string `json:"server"`
+	// Schema renames each schema of the restored tenant database
+	Schema string `json:"schema"`
+}
+
+// NameData is available to name templates
+type NameData struct {
+	// Source is the source database name
+	Source string
+	// Tenant is the tenant name from the registry, or the source database name
+	Tenant string
+	// Schema is the schema being renamed, for the schema template
+	Schema string
+}
+
+// renderName executes a name template; an empty template yields fallback
+func renderName(text string, data NameData, fallback string) (string, error) {
+	if text == "" {
+		return fallback, nil
+	}
+	tmpl, err := template.New("name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid name template %q: %w", text, err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("failed to render name template %q: %w", text, err)
+	}
+	name := strings.TrimSpace(b.String())
+	if name == "" {
+		return "", fmt.Errorf("name template %q rendered an empty name", text)
+	}
+	return name, nil
+}
+
+// renameServer rewrites references to a foreign server in a plain-text script:
+// CREATE SERVER, USER MAPPING ... SERVER, FOREIGN TABLE ... SERVER, and comments
+func renameServer(script, from, to string) string {
+	re := regexp.MustCompile(`(\bSERVER\s+)` + regexp.QuoteMeta(from) + `\b`)
+	return re.ReplaceAllString(script, "${1}"+strings.ReplaceAll(quoteIdent(to), "$", "$$"))
+}
+
+// userSchemaQuery lists the schemas of a database that aren't system schemas
+const userSchemaQuery = `SELECT nspname FROM pg_namespace
+WHERE nspname NOT LIKE 'pg\_%' AND nspname <> 'information_schema'
+ORDER BY 1;`
+
+// renameSchemas renames every user schema of a database through the schema template.
+// References inside function bodies and views' stored text are not rewritten.
+func renameSchemas(config DBConfig, text string, data NameData) error {
+	output, err := newPsqlCmd(config, "-t", "-A", "-c", userSchemaQuery).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to list schemas of %s: %w, output: %s", config.DBName, err, output)
+	}
+
+	var b strings.Builder
+	for _, schema := range strings.Fields(string(output)) {
+		data.Schema = schema
+		renamed, err := renderName(text, data, schema)
+		if err != nil {
+			return err
+		}
+		if renamed != schema {
+			log.Printf("Renaming schema %s to %s in %s", schema, renamed, config.DBName)
+			fmt.Fprintf(&b, "ALTER SCHEMA %s RENAME TO %s;\n", quoteIdent(schema), quoteIdent(renamed))
+		}
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+
+	cmd := newPsqlCmd(config, "-v", "ON_ERROR_STOP=1", "--single-transaction", "-c", b.String())
+	if output, err := runStreaming(cmd, "rename_schemas_"+config.DBName, nil); err != nil {
+		return fmt.Errorf("failed to rename schemas in %s: %w, output: %s", config.DBName, err, output)
+	}
+	return nil
+}
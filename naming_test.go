@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestRenderName(t *testing.T) {
+	data := NameData{Source: "tenant", Tenant: "acme", Schema: "public"}
+	tests := []struct {
+		template, want string
+	}{
+		{"{{.Source}}_staging", "tenant_staging"},
+		{"{{.Tenant}}_v2", "acme_v2"},
+		{"{{.Tenant}}_{{.Schema}}", "acme_public"},
+		{"", "fallback"},
+	}
+	for _, tt := range tests {
+		got, err := renderName(tt.template, data, "fallback")
+		if err != nil || got != tt.want {
+			t.Errorf("renderName(%q) = %q, %v; want %q", tt.template, got, err, tt.want)
+		}
+	}
+
+	if _, err := renderName("{{.Region}}", data, ""); err == nil {
+		t.Error("expected error for unknown field")
+	}
+}
+
+func TestRenameServer(t *testing.T) {
+	script := `CREATE SERVER moodys_server FOREIGN DATA WRAPPER postgres_fdw;
+CREATE USER MAPPING FOR postgres SERVER moodys_server OPTIONS (password 'x');
+CREATE FOREIGN TABLE public.companies_foreign (id integer)
+SERVER moodys_server
+OPTIONS (schema_name 'public', table_name 'moodys_server_log');`
+
+	want := `CREATE SERVER "acme_moodys" FOREIGN DATA WRAPPER postgres_fdw;
+CREATE USER MAPPING FOR postgres SERVER "acme_moodys" OPTIONS (password 'x');
+CREATE FOREIGN TABLE public.companies_foreign (id integer)
+SERVER "acme_moodys"
+OPTIONS (schema_name 'public', table_name 'moodys_server_log');`
+
+	if got := renameServer(script, "moodys_server", "acme_moodys"); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Verbosity controls how much the tool logs
+type Verbosity int
+
+const (
+	// VerbosityQuiet logs only errors and the final summary
+	VerbosityQuiet Verbosity = iota - 1
+	// VerbosityNormal logs progress and command output
+	VerbosityNormal
+	// VerbosityDebug additionally echoes every command before it runs
+	VerbosityDebug
+)
+
+// verbosity is the current output level
+var verbosity = VerbosityNormal
+
+// alwaysLog writes errors and summaries, which are shown even in quiet mode
+var alwaysLog = log.New(os.Stderr, "", log.LstdFlags)
+
+// setVerbosity applies an output level to the standard logger
+func setVerbosity(v Verbosity) {
+	verbosity = v
+	switch v {
+	case VerbosityQuiet:
+		log.SetOutput(io.Discard)
+	case VerbosityDebug:
+		log.SetFlags(log.LstdFlags | log.Lmicroseconds)
+		alwaysLog.SetFlags(log.LstdFlags | log.Lmicroseconds)
+	}
+}
+
+// fatalf logs an error regardless of verbosity and exits
+func fatalf(format string, args ...interface{}) {
+	alwaysLog.Fatalf(format, args...)
+}
+
+// debugf logs only in debug mode
+func debugf(format string, args ...interface{}) {
+	if verbosity >= VerbosityDebug {
+		log.Printf(format, args...)
+	}
+}
+
+// warningWriter passes log output through and records WARNING and ALERT lines in the
+// run report, so the report and its email list them
+type warningWriter struct {
+	out    io.Writer
+	report *RunReport
+}
+
+func (w warningWriter) Write(p []byte) (int, error) {
+	line := string(p)
+	for _, prefix := range []string{"WARNING: ", "ALERT: "} {
+		if i := strings.Index(line, prefix); i >= 0 {
+			w.report.recordWarning(strings.TrimSpace(line[i+len(prefix):]))
+			break
+		}
+	}
+	return w.out.Write(p)
+}
+
+// captureWarnings records the warnings either logger writes in the run report
+func captureWarnings(r *RunReport) {
+	log.SetOutput(warningWriter{out: log.Writer(), report: r})
+	alwaysLog.SetOutput(warningWriter{out: alwaysLog.Writer(), report: r})
+}
+
+// printSummary logs each phase's outcome regardless of verbosity
+func printSummary(r *RunReport) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range r.Phases {
+		alwaysLog.Printf("%-18s %-10s %s", p.Name, p.Status, p.Duration)
+	}
+	failed := 0
+	for _, s := range r.Steps {
+		if s.Status != "succeeded" {
+			failed++
+		}
+	}
+	alwaysLog.Printf("Run %s %s: %d steps, %d failed, %d objects skipped",
+		r.RunID, r.Status, len(r.Steps), failed, len(r.Skipped))
+}
+
+// RunStatus is the progress snapshot written to the status file
+type RunStatus struct {
+	RunID     string    `json:"run_id"`
+	PID       int       `json:"pid"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Elapsed   string    `json:"elapsed"`
+	// Phase is the phase currently running
+	Phase string `json:"phase,omitempty"`
+	// PhasesDone lists finished phases in the order they finished
+	PhasesDone []string `json:"phases_done"`
+	// Running lists steps in progress; migrate-all runs several at once
+	Running        []string `json:"running"`
+	StepsSucceeded int      `json:"steps_succeeded"`
+	StepsFailed    int      `json:"steps_failed"`
+	// LastOutput is the most recent line a step printed
+	LastOutput string `json:"last_output,omitempty"`
+	// Paused is set while the run holds new steps; see PauseGate
+	Paused bool `json:"paused,omitempty"`
+}
+
+// statusWriter keeps a status file up to date as the run progresses
+type statusWriter struct {
+	mu        sync.Mutex
+	path      string
+	status    RunStatus
+	running   map[string]bool
+	lastWrite time.Time
+}
+
+// activeStatus receives progress updates; nil disables the status file
+var activeStatus *statusWriter
+
+// statusWriteEvery limits how often output lines rewrite the status file
+const statusWriteEvery = time.Second
+
+// newStatusWriter starts a status file for a run
+func newStatusWriter(path, runID string) (*statusWriter, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create status file directory: %w", err)
+	}
+	now := time.Now()
+	w := &statusWriter{
+		path:    path,
+		running: make(map[string]bool),
+		status:  RunStatus{RunID: runID, PID: os.Getpid(), Status: "running", StartedAt: now, PhasesDone: []string{}},
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w, w.write()
+}
+
+// write replaces the status file atomically so pollers never read a partial document.
+// The caller holds w.mu.
+func (w *statusWriter) write() error {
+	now := time.Now()
+	w.lastWrite = now
+	w.status.UpdatedAt = now
+	w.status.Elapsed = now.Sub(w.status.StartedAt).Round(time.Second).String()
+	w.status.Running = w.status.Running[:0]
+	for step := range w.running {
+		w.status.Running = append(w.status.Running, step)
+	}
+	sort.Strings(w.status.Running)
+
+	content, err := json.MarshalIndent(w.status, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode status: %w", err)
+	}
+	tmp := w.path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0644); err != nil {
+		return fmt.Errorf("failed to write status file: %w", err)
+	}
+	if err := os.Rename(tmp, w.path); err != nil {
+		return fmt.Errorf("failed to replace status file: %w", err)
+	}
+	return nil
+}
+
+// update applies fn and rewrites the file; throttled updates are skipped when the file
+// was written within statusWriteEvery
+func (w *statusWriter) update(throttled bool, fn func(s *RunStatus)) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	fn(&w.status)
+	if throttled && time.Since(w.lastWrite) < statusWriteEvery {
+		return
+	}
+	if err := w.write(); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+}
+
+func (w *statusWriter) phaseStarted(name string) {
+	w.update(false, func(s *RunStatus) { s.Phase = name })
+}
+
+func (w *statusWriter) phaseFinished(name string) {
+	w.update(false, func(s *RunStatus) {
+		s.PhasesDone = append(s.PhasesDone, name)
+		if s.Phase == name {
+			s.Phase = ""
+		}
+	})
+}
+
+func (w *statusWriter) stepStarted(step string) {
+	w.update(false, func(s *RunStatus) { w.running[step] = true })
+}
+
+func (w *statusWriter) stepFinished(step string, err error) {
+	w.update(false, func(s *RunStatus) {
+		delete(w.running, step)
+		if err != nil {
+			s.StepsFailed++
+		} else {
+			s.StepsSucceeded++
+		}
+	})
+}
+
+func (w *statusWriter) setPaused(paused bool) {
+	w.update(false, func(s *RunStatus) { s.Paused = paused })
+}
+
+func (w *statusWriter) output(step, line string) {
+	w.update(true, func(s *RunStatus) {
+		s.LastOutput = fmt.Sprintf("[%s] %s", step, strings.TrimSpace(line))
+	})
+}
+
+// finish records the run's outcome
+func (w *statusWriter) finish(err error) {
+	w.update(false, func(s *RunStatus) {
+		s.Status, s.Error = statusOf(err)
+		s.Phase = ""
+	})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStatusWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status.json")
+	w, err := newStatusWriter(path, "run1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.phaseStarted("restore")
+	w.stepStarted("restore_moodys_pre-data")
+	w.stepStarted("restore_tenant_pre-data")
+	w.stepFinished("restore_moodys_pre-data", nil)
+	w.stepFinished("restore_tenant_pre-data", errors.New("exit status 1"))
+	w.stepStarted("restore_tenant_data")
+
+	var status RunStatus
+	read := func() {
+		status = RunStatus{}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(content, &status); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read()
+	if status.Phase != "restore" || status.StepsSucceeded != 1 || status.StepsFailed != 1 {
+		t.Errorf("unexpected progress: %+v", status)
+	}
+	if len(status.Running) != 1 || status.Running[0] != "restore_tenant_data" {
+		t.Errorf("running = %v, want [restore_tenant_data]", status.Running)
+	}
+
+	w.phaseFinished("restore")
+	w.finish(nil)
+	read()
+	if status.Status != "succeeded" || status.Phase != "" || len(status.PhasesDone) != 1 {
+		t.Errorf("unexpected final status: %+v", status)
+	}
+}
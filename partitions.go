@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// leafPartitionsQuery lists the leaf partitions of declaratively partitioned tables with
+// their parents and partition bounds
+const leafPartitionsQuery = `SELECT n.nspname || '.' || c.relname, pn.nspname || '.' || p.relname, pg_get_expr(c.relpartbound, c.oid)
+FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+	JOIN pg_inherits i ON i.inhrelid = c.oid
+	JOIN pg_class p ON p.oid = i.inhparent
+	JOIN pg_namespace pn ON pn.oid = p.relnamespace
+WHERE c.relispartition AND c.relkind = 'r' AND p.relkind = 'p'
+ORDER BY 1;`
+
+// Partition is a leaf partition of a partitioned table
+type Partition struct {
+	Table  string
+	Parent string
+	// Bound is the FOR VALUES clause the partition is attached with
+	Bound string
+}
+
+// listPartitions returns the leaf partitions of config's database
+func listPartitions(config DBConfig) ([]Partition, error) {
+	rows, err := queryRows(config, leafPartitionsQuery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list partitions of %s: %w", config.DBName, err)
+	}
+	var partitions []Partition
+	for _, row := range rows {
+		if len(row) == 3 {
+			partitions = append(partitions, Partition{Table: row[0], Parent: row[1], Bound: row[2]})
+		}
+	}
+	return partitions, nil
+}
+
+// partitionArtifactName returns the archive a partition's data is dumped to
+func partitionArtifactName(namePrefix, table string) string {
+	return fmt.Sprintf("%s_data_partition_%s.dump", namePrefix, table)
+}
+
+// runConcurrently calls fn for 0..n-1, up to workers at once, and returns the first error
+func runConcurrently(n, workers int, fn func(i int) error) error {
+	if workers < 1 {
+		workers = 1
+	}
+	errs := make([]error, n)
+	slots := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			errs[i] = fn(i)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dumpTableArchive dumps the data of one table into a custom-format archive
+func dumpTableArchive(config DBConfig, outFile, table, snapshotID, label string) error {
+	dump := pgCommand("pg_dump", config).Arg(
+		"-Fc", "--data-only", "--strict-names", "-t", quoteQualifiedName(table), "-f", outFile)
+	if snapshotID != "" {
+		dump.Arg("--snapshot=" + snapshotID)
+	}
+	dump.Arg(activeThrottle.dumpArgs()...)
+
+	release := activeThrottle.acquire()
+	defer release()
+	cmd := dump.Cmd()
+	monitor := NewProgressMonitor(fmt.Sprintf("Dump %s %s", label, table))
+	if output, err := runStreaming(cmd, stepName("dump", outFile), monitor); err != nil {
+		return fmt.Errorf("failed to dump %s %s: %w, output: %s", label, table, err, output)
+	}
+	return nil
+}
+
+// dumpPartitions dumps the data of each partition into its own custom-format archive,
+// several at once, all from the same exported snapshot
+func dumpPartitions(config DBConfig, outputDir, namePrefix, snapshotID string, partitions []Partition) ([]ManifestArtifact, error) {
+	artifacts := make([]ManifestArtifact, len(partitions))
+	log.Printf("Dumping %d partitions of %s in parallel", len(partitions), config.DBName)
+	err := runConcurrently(len(partitions), getNumCPUs(), func(i int) error {
+		p := partitions[i]
+		outFile := filepath.Join(outputDir, partitionArtifactName(namePrefix, p.Table))
+		if err := dumpTableArchive(config, outFile, p.Table, snapshotID, "partition"); err != nil {
+			return err
+		}
+		artifacts[i] = ManifestArtifact{
+			Database:  namePrefix,
+			DBName:    config.DBName,
+			Section:   "data",
+			File:      filepath.Base(outFile),
+			Format:    FormatCustom,
+			Size:      artifactSize(outFile),
+			Partition: p.Table,
+		}
+		return nil
+	})
+	return artifacts, err
+}
+
+// detachStatement detaches a partition from its parent
+func detachStatement(p Partition) string {
+	return fmt.Sprintf("ALTER TABLE %s DETACH PARTITION %s;", quoteQualifiedName(p.Parent), quoteQualifiedName(p.Table))
+}
+
+// attachStatement attaches a partition to its parent with its original bound
+func attachStatement(p Partition) string {
+	return fmt.Sprintf("ALTER TABLE %s ATTACH PARTITION %s %s;", quoteQualifiedName(p.Parent), quoteQualifiedName(p.Table), p.Bound)
+}
+
+// detachPartitions detaches the partitions of the given tables in the destination and
+// returns what is needed to attach them again
+func detachPartitions(config DBConfig, tables []string) ([]Partition, error) {
+	partitions, err := listPartitions(config)
+	if err != nil {
+		return nil, err
+	}
+	var detached []Partition
+	for _, p := range partitions {
+		if !includesDatabase(tables, p.Table) {
+			continue
+		}
+		if output, err := newPsqlCmd(config, "-v", "ON_ERROR_STOP=1", "-c", detachStatement(p)).CombinedOutput(); err != nil {
+			return detached, fmt.Errorf("failed to detach %s: %w, output: %s", p.Table, err, output)
+		}
+		detached = append(detached, p)
+	}
+	return detached, nil
+}
+
+// attachPartitions attaches detached partitions to their parents again. PostgreSQL scans
+// each partition to check its bound, so this takes a while on large partitions.
+func attachPartitions(config DBConfig, partitions []Partition) error {
+	var failed []string
+	for _, p := range partitions {
+		if output, err := newPsqlCmd(config, "-v", "ON_ERROR_STOP=1", "-c", attachStatement(p)).CombinedOutput(); err != nil {
+			log.Printf("WARNING: failed to attach %s to %s: %v, output: %s", p.Table, p.Parent, err, output)
+			failed = append(failed, p.Table)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to attach partitions %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// restorePartitions loads dumped partitions as independent units, several at once, each
+// in one transaction. With detach, the partitions are detached from their parents while
+// loading and attached again afterwards, whether or not the load succeeded.
+func restorePartitions(config DBConfig, files map[string]artifactInput, detach bool) (err error) {
+	if len(files) == 0 {
+		return nil
+	}
+	tables := make([]string, 0, len(files))
+	for table := range files {
+		tables = append(tables, table)
+	}
+
+	if detach {
+		detached, detachErr := detachPartitions(config, tables)
+		defer func() {
+			if attachErr := attachPartitions(config, detached); attachErr != nil && err == nil {
+				err = attachErr
+			}
+		}()
+		if detachErr != nil {
+			return detachErr
+		}
+	}
+
+	start := time.Now()
+	log.Printf("Restoring %d partitions into %s in parallel", len(tables), config.DBName)
+	err = runConcurrently(len(tables), getNumCPUs(), func(i int) error {
+		file := files[tables[i]]
+		return RetryWithBackoff("restore partition "+tables[i], 3, func() error {
+			args := []string{"--data-only", "--single-transaction", "--exit-on-error"}
+			if file.stream == nil {
+				args = append(args, file.path)
+			}
+			cmd := newPgRestoreCmd(config, args...)
+			if file.stream != nil {
+				input, err := file.stream()
+				if err != nil {
+					return err
+				}
+				defer input.Close()
+				cmd.Stdin = input
+			}
+			monitor := NewProgressMonitor(fmt.Sprintf("Restore partition %s", tables[i]))
+			if output, err := runStreaming(cmd, stepName("restore", file.path), monitor); err != nil {
+				return fmt.Errorf("failed to restore partition %s: %w, output: %s", tables[i], err, output)
+			}
+			return nil
+		})
+	})
+	if err == nil {
+		log.Printf("Restored %d partitions into %s in %v", len(tables), config.DBName, time.Since(start).Round(time.Second))
+	}
+	return err
+}
+
+// partitionArtifacts reads the separately dumped partitions and delta tables of a dump
+// set from its manifest, as archive names by table by database
+func partitionArtifacts(dir string) map[string]map[string]string {
+	artifacts := make(map[string]map[string]string)
+	manifest, err := LoadManifest(dir)
+	if err != nil {
+		return artifacts
+	}
+	for _, a := range manifest.Artifacts {
+		table := a.Partition
+		if table == "" {
+			table = a.Table
+		}
+		if table == "" {
+			continue
+		}
+		if artifacts[a.Database] == nil {
+			artifacts[a.Database] = make(map[string]string)
+		}
+		artifacts[a.Database][table] = a.File
+	}
+	return artifacts
+}
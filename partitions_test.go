@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestPartitionStatements(t *testing.T) {
+	p := Partition{Table: "sales.orders_2024", Parent: "sales.orders", Bound: "FOR VALUES FROM ('2024-01-01') TO ('2025-01-01')"}
+	if got := partitionArtifactName("tenant", p.Table); got != "tenant_data_partition_sales.orders_2024.dump" {
+		t.Errorf("partitionArtifactName = %q", got)
+	}
+	if got, want := detachStatement(p), `ALTER TABLE "sales"."orders" DETACH PARTITION "sales"."orders_2024";`; got != want {
+		t.Errorf("detachStatement = %q, want %q", got, want)
+	}
+	want := `ALTER TABLE "sales"."orders" ATTACH PARTITION "sales"."orders_2024" FOR VALUES FROM ('2024-01-01') TO ('2025-01-01');`
+	if got := attachStatement(p); got != want {
+		t.Errorf("attachStatement = %q, want %q", got, want)
+	}
+}
+
+func TestRunConcurrently(t *testing.T) {
+	seen := make([]bool, 5)
+	if err := runConcurrently(len(seen), 2, func(i int) error { seen[i] = true; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	for i, ok := range seen {
+		if !ok {
+			t.Errorf("item %d not run", i)
+		}
+	}
+}
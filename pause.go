@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// pauseFileName is the file in the dump directory whose presence pauses a run
+const pauseFileName = "PAUSE"
+
+// pausePollInterval is how often the pause file is looked for
+const pausePollInterval = 2 * time.Second
+
+// PauseGate holds a run between steps. Pausing lets running steps finish but keeps new
+// ones from starting until the run is resumed.
+type PauseGate struct {
+	mu      sync.Mutex
+	paused  bool
+	since   time.Time
+	resumed chan struct{}
+}
+
+// activePause holds steps while the run is paused; nil never pauses
+var activePause *PauseGate
+
+// Pause holds steps that haven't started yet; it reports whether the run was running
+func (g *PauseGate) Pause() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.paused {
+		return false
+	}
+	g.paused, g.since = true, time.Now()
+	g.resumed = make(chan struct{})
+	activeStatus.setPaused(true)
+	return true
+}
+
+// Resume lets held steps start; it reports whether the run was paused
+func (g *PauseGate) Resume() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.paused {
+		return false
+	}
+	g.paused = false
+	close(g.resumed)
+	activeStatus.setPaused(false)
+	return true
+}
+
+// wait returns once the run isn't paused
+func (g *PauseGate) wait(step string) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	paused, resumed := g.paused, g.resumed
+	g.mu.Unlock()
+	if !paused {
+		return
+	}
+	alwaysLog.Printf("Paused: %s waits to start until the run is resumed", step)
+	<-resumed
+}
+
+// watchPauseFile pauses while a file exists at path and resumes once it is removed, for
+// the rest of the process. Unlike the signals it works on every platform, including
+// for daemon jobs and Windows services. A pause from a signal is left to the signals.
+func watchPauseFile(g *PauseGate, path string, every time.Duration) {
+	go func() {
+		held := false
+		for {
+			_, err := os.Stat(path)
+			switch exists := err == nil; {
+			case exists && !held:
+				if held = g.Pause(); held {
+					alwaysLog.Printf("Pausing: %s exists; running steps finish, then the run waits until it is removed", path)
+				}
+			case !exists && held:
+				g.mu.Lock()
+				since := g.since
+				g.mu.Unlock()
+				if g.Resume() {
+					alwaysLog.Printf("Resumed after %s paused", time.Since(since).Round(time.Second))
+				}
+				held = false
+			}
+			time.Sleep(every)
+		}
+	}()
+}
+
+// watchPause pauses and resumes the run with signals where there are any, and with a
+// pause file in dumpDir
+func watchPause(g *PauseGate, dumpDir string) {
+	watchPauseSignals(g)
+	watchPauseFile(g, filepath.Join(dumpDir, pauseFileName), pausePollInterval)
+}
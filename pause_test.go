@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPauseGate(t *testing.T) {
+	var unset *PauseGate
+	unset.wait("restore_tenant_data")
+
+	g := &PauseGate{}
+	g.wait("restore_tenant_data")
+	if !g.Pause() || g.Pause() {
+		t.Fatal("Pause should report only the first call")
+	}
+
+	started := make(chan struct{})
+	go func() {
+		g.wait("restore_tenant_data")
+		close(started)
+	}()
+	select {
+	case <-started:
+		t.Fatal("step started while paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if !g.Resume() || g.Resume() {
+		t.Fatal("Resume should report only the first call")
+	}
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("step still held after resume")
+	}
+}
+
+func TestPauseFile(t *testing.T) {
+	g := &PauseGate{}
+	path := filepath.Join(t.TempDir(), pauseFileName)
+	watchPauseFile(g, path, 10*time.Millisecond)
+
+	paused := func() bool {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		return g.paused
+	}
+	waitFor := func(want bool) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for paused() != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("paused = %t, want %t", !want, want)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(true)
+	os.Remove(path)
+	waitFor(false)
+
+	// A pause the file didn't make isn't lifted by the file's absence
+	g.Pause()
+	time.Sleep(50 * time.Millisecond)
+	if !paused() {
+		t.Error("the pause file watcher resumed a pause it didn't make")
+	}
+	g.Resume()
+}
@@ -0,0 +1,32 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// watchPauseSignals pauses on SIGUSR1 and resumes on SIGUSR2 for the rest of the process
+func watchPauseSignals(g *PauseGate) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGUSR1 {
+				if g.Pause() {
+					alwaysLog.Printf("Pausing (pid %d): running steps finish, then the run waits; resume with kill -USR2 %d", os.Getpid(), os.Getpid())
+				}
+				continue
+			}
+			g.mu.Lock()
+			since := g.since
+			g.mu.Unlock()
+			if g.Resume() {
+				alwaysLog.Printf("Resumed after %s paused", time.Since(since).Round(time.Second))
+			}
+		}
+	}()
+}
@@ -0,0 +1,9 @@
+//go:build windows
+
+package main
+
+// watchPauseSignals does nothing on Windows, which has no SIGUSR1 and SIGUSR2 to pause
+// and resume with; the pause file does their job there
+func watchPauseSignals(g *PauseGate) {
+	debugf("Pausing with signals is not available on Windows; create %s in the dump directory instead", pauseFileName)
+}
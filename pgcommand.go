@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// PgCommand builds a psql, pg_dump, or pg_restore command line for a connection, so
+// every command gets its connection flags, database, and ownership options the same
+// way. Fields left empty in the configuration are left off for libpq's defaults, and
+// SSL and authentication settings reach the command through its environment.
+type PgCommand struct {
+	name     string
+	config   DBConfig
+	database string
+	noOwner  bool
+	// params are further conninfo keywords; with any set, the whole connection is
+	// passed as one conninfo string instead of -h, -p, and -U
+	params [][2]string
+	args   []string
+}
+
+// pgCommand starts a command connected to config's database
+func pgCommand(name string, config DBConfig) *PgCommand {
+	return &PgCommand{name: name, config: config, database: config.DBName}
+}
+
+// Database connects to another database of the server, e.g. postgres to create the
+// configured one; empty leaves the choice to libpq
+func (c *PgCommand) Database(dbname string) *PgCommand {
+	c.database = dbname
+	return c
+}
+
+// NoOwner leaves ownership and privileges to the role restoring, or out of the dump
+func (c *PgCommand) NoOwner() *PgCommand {
+	c.noOwner = true
+	return c
+}
+
+// Param adds a conninfo keyword, e.g. replication=database, which passes the
+// connection as a conninfo string; an empty value is left out
+func (c *PgCommand) Param(key, value string) *PgCommand {
+	c.params = append(c.params, [2]string{key, value})
+	return c
+}
+
+// Arg appends arguments after the connection and ownership options
+func (c *PgCommand) Arg(args ...string) *PgCommand {
+	c.args = append(c.args, args...)
+	return c
+}
+
+// conninfo renders the connection as a conninfo string, leaving out unset fields
+func (c *PgCommand) conninfo() string {
+	params := [][2]string{{"host", c.config.dialHost()}, {"port", c.config.dialPort()}, {"user", c.config.User}, {"dbname", c.database}}
+	var fields []string
+	for _, p := range append(params, c.params...) {
+		if p[1] != "" {
+			fields = append(fields, p[0]+"="+quoteConninfoValue(p[1]))
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+// Args returns the command's arguments
+func (c *PgCommand) Args() []string {
+	var args []string
+	if len(c.params) > 0 {
+		args = []string{"-d", c.conninfo()}
+	} else {
+		args = c.config.connArgs()
+		if c.database != "" {
+			args = append(args, "-d", c.database)
+		}
+	}
+	if c.noOwner {
+		args = append(args, "--no-owner", "--no-privileges")
+	}
+	return append(args, c.args...)
+}
+
+// Cmd returns the command, run with the connection's environment and as its OS user
+func (c *PgCommand) Cmd() *exec.Cmd {
+	return c.config.command(c.name, c.Args()...)
+}
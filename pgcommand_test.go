@@ -0,0 +1,26 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPgCommandArgs(t *testing.T) {
+	config := DBConfig{Host: "db", Port: "5432", User: "admin", DBName: "tenant"}
+	for _, c := range []struct {
+		name string
+		cmd  *PgCommand
+		want string
+	}{
+		{"psql", pgCommand("psql", config).Arg("-c", "SELECT 1"), "-h db -p 5432 -U admin -d tenant -c SELECT 1"},
+		{"no owner", pgCommand("pg_restore", config).NoOwner().Arg("x.dump"), "-h db -p 5432 -U admin -d tenant --no-owner --no-privileges x.dump"},
+		{"other database", pgCommand("psql", config).Database("postgres"), "-h db -p 5432 -U admin -d postgres"},
+		{"libpq defaults", pgCommand("psql", DBConfig{Host: "/var/run/postgresql"}), "-h /var/run/postgresql"},
+		{"conninfo", pgCommand("psql", DBConfig{Host: "db", DBName: "my db"}).Param("replication", "database").Param("sslmode", ""),
+			"-d host=db dbname='my db' replication=database"},
+	} {
+		if got := strings.Join(c.cmd.Args(), " "); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,877 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// workflowRun is a run of the built-in workflow, or of the configured one, as selected
+// by the subcommand and flags
+type workflowRun struct {
+	*runEnv
+	// dumpOnly, restoreOnly, and migrating are set by the dump, restore, and migrate
+	// subcommands
+	dumpOnly, restoreOnly, migrating bool
+	toStdout, fromStdin, holdSet     bool
+	setLabels                        []string
+	databases                        []string
+	tenantNames                      NameData
+	serverName                       string
+	fanOut                           []fanOutTarget
+	lockDests                        []DBConfig
+	cutover                          []CutoverTarget
+
+	runID         string
+	report        *RunReport
+	hooks         *Hooks
+	fdwPlan       []FDWRemap
+	pointDests    []DBConfig
+	pointsCreated bool
+}
+
+// selectRun applies the dump, restore, and migrate subcommands and derives the
+// destination names
+func (e *runEnv) selectRun() *workflowRun {
+	f, cfg := e.flags, e.cfg
+	r := &workflowRun{runEnv: e}
+	var err error
+
+	// dump and restore run one half of the workflow, optionally through a pipe
+	r.dumpOnly, r.restoreOnly = flag.Arg(0) == "dump", flag.Arg(0) == "restore"
+	if r.dumpOnly || r.restoreOnly {
+		sub := flag.NewFlagSet(flag.Arg(0), flag.ExitOnError)
+		if r.dumpOnly {
+			sub.BoolVar(&r.toStdout, "stdout", false, "Write the dump set to stdout as a tar stream instead of keeping it in -dump-dir")
+		} else {
+			sub.BoolVar(&r.fromStdin, "stdin", false, "Read the dump set from stdin as a tar stream into -dump-dir before restoring")
+		}
+		set := sub.String("set", "", "Name of the dump set below the storage location to upload to or download from")
+		var latest bool
+		var from, label string
+		if r.dumpOnly {
+			sub.StringVar(&label, "label", "", "Comma-separated labels to record in the manifest, e.g. release-1.42,pre-migration")
+			sub.BoolVar(&r.holdSet, "hold", false, "Mark the dump set immutable, so later dumps refuse to replace it")
+		}
+		if r.restoreOnly {
+			sub.BoolVar(&latest, "latest", false, "Restore the newest complete dump set at the storage location, or below -dump-dir without storage")
+			sub.StringVar(&from, "from", "", "Restore the newest complete dump set created on or before this date (2006-01-02) or timestamp")
+			sub.StringVar(&label, "label", "", "Restore the newest complete dump set with this label")
+		}
+		sub.Parse(flag.Args()[1:])
+		if r.dumpOnly {
+			r.setLabels, label = parseLabels(label), ""
+			if (len(r.setLabels) > 0 || r.holdSet) && cfg.Workflow != nil {
+				fatalf("-label and -hold apply to the built-in dump; label a workflow's dump set afterwards with label")
+			}
+		}
+		selecting := latest || from != "" || label != ""
+		if selecting && (*set != "" || r.fromStdin) {
+			fatalf("-latest, -from, and -label select the dump set and can't be combined with -set or -stdin")
+		}
+		if *set != "" {
+			if r.store == nil {
+				fatalf("-set needs storage in the configuration")
+			}
+			r.store = r.store.Set(*set)
+		}
+		if selecting {
+			filter := DumpSetFilter{Label: label}
+			if from != "" {
+				if filter.Before, err = parseSetTime(from); err != nil {
+					fatalf("Invalid -from: %v", err)
+				}
+			}
+			var sets []DumpSetSummary
+			if r.store != nil {
+				sets, err = r.store.ListDumpSets()
+			} else {
+				sets, err = ListLocalDumpSets(f.dumpDir)
+			}
+			if err != nil {
+				fatalf("Failed to list dump sets: %v", err)
+			}
+			selected, err := selectDumpSet(sets, filter)
+			if err != nil {
+				fatalf("Failed to select a dump set: %v", err)
+			}
+			log.Printf("Selected dump set %s, created %s", selected.Name, selected.CreatedAt.Local().Format(time.RFC3339))
+			if r.store != nil {
+				r.store = r.store.Set(selected.Name)
+			} else {
+				f.dumpDir = filepath.Join(f.dumpDir, selected.Name)
+			}
+		}
+	}
+
+	// migrate runs one unit of migrate-all: the shared moodys database, or a tenant
+	tenantName := r.tenant.DBName
+	r.migrating = flag.Arg(0) == "migrate"
+	if r.migrating {
+		switch flag.Arg(1) {
+		case "moodys":
+			r.databases = []string{"moodys"}
+		case "tenant":
+			if flag.Arg(2) == "" {
+				fatalf("Usage: migrate tenant <source_db> [dest_db] [tenant]")
+			}
+			r.tenant.DBName = flag.Arg(2)
+			r.destTenant.DBName = flag.Arg(3)
+			tenantName = r.tenant.DBName
+			if flag.Arg(4) != "" {
+				tenantName = flag.Arg(4)
+			}
+			r.databases = []string{"tenant"}
+		default:
+			fatalf("Usage: migrate moodys | migrate tenant <source_db> [dest_db] [tenant]")
+		}
+	}
+
+	// Derive destination names from the naming templates unless given explicitly
+	if r.destMoodys.DBName, err = renderName(cfg.Naming.Database,
+		NameData{Source: r.moodys.DBName}, r.destMoodys.DBName); err != nil {
+		fatalf("Invalid naming configuration: %v", err)
+	}
+	r.tenantNames = NameData{Source: r.tenant.DBName, Tenant: tenantName}
+	if !r.migrating || r.destTenant.DBName == "" {
+		fallback := r.destTenant.DBName
+		if fallback == "" {
+			fallback = r.tenant.DBName + "_dest"
+		}
+		if r.destTenant.DBName, err = renderName(cfg.Naming.Database, r.tenantNames, fallback); err != nil {
+			fatalf("Invalid naming configuration: %v", err)
+		}
+	}
+	if r.serverName, err = renderName(cfg.Naming.Server, r.tenantNames, moodysServerName); err != nil {
+		fatalf("Invalid naming configuration: %v", err)
+	}
+	return r
+}
+
+// checkCombinations rejects flags and sections that can't run together and settles the
+// fan-out, run lock, and blue/green targets
+func (r *workflowRun) checkCombinations() {
+	f, cfg := r.flags, r.cfg
+	if f.validateCatalog && (f.clone || f.incremental || len(cfg.Renames) > 0 || cfg.Naming.Schema != "") {
+		fatalf("-validate-catalog compares names as dumped and can't be combined with -clone, -incremental, rename rules, or a schema template")
+	}
+
+	if f.clone && (cfg.Workflow != nil || f.incremental || r.dumpOnly || r.restoreOnly || f.cdc) {
+		fatalf("-clone replaces the dump and restore and can't be combined with a custom workflow, -incremental, -cdc, dump, or restore")
+	}
+
+	// A fan-out restores the main destination and the configured ones together
+	if cfg.FanOut != nil && !r.dumpOnly {
+		if cfg.Workflow != nil || f.incremental || f.clone || f.cdc || f.blueGreen || r.migrating {
+			fatalf("fan_out restores with the built-in workflow and can't be combined with a custom workflow, -incremental, -clone, -cdc, -blue-green, or migrate")
+		}
+		r.fanOut = cfg.FanOut.targets(r.destMoodys, r.destTenant, cfg.FDWTarget)
+	}
+
+	// A schema split restores some tenant schemas into databases next to dest_tenant
+	if len(cfg.SchemaSplit) > 0 {
+		if err := validateSchemaSplit(cfg.SchemaSplit, r.destTenant.DBName); err != nil {
+			fatalf("Invalid schema_split configuration: %v", err)
+		}
+		if cfg.Workflow != nil || f.incremental || f.clone || f.blueGreen || r.migrating || cfg.FanOut != nil || f.validateCatalog {
+			fatalf("schema_split restores with the built-in workflow and can't be combined with a custom workflow, -incremental, -clone, -blue-green, migrate, fan_out, or -validate-catalog")
+		}
+	}
+
+	// Runs against the same destination databases exclude each other; blue/green runs
+	// lock the live names
+	if !r.dumpOnly && !f.noRunLock {
+		r.lockDests = r.includedDests()
+		if len(r.fanOut) > 1 {
+			r.lockDests = append(r.lockDests, fanOutConfigs(r.fanOut[1:])...)
+		}
+		if includesDatabase(r.databases, "tenant") {
+			for _, t := range cfg.SchemaSplit {
+				r.lockDests = append(r.lockDests, t.config(r.destTenant))
+			}
+		}
+	}
+
+	// Blue/green restores fill staging databases; the live names are only used at cutover
+	if f.blueGreen {
+		if cfg.Workflow != nil || f.incremental || r.dumpOnly {
+			fatalf("-blue-green restores with the built-in workflow and can't be combined with a custom workflow, -incremental, or dump")
+		}
+		for _, db := range []struct {
+			name   string
+			config *DBConfig
+		}{{"moodys", &r.destMoodys}, {"tenant", &r.destTenant}} {
+			if !includesDatabase(r.databases, db.name) {
+				continue
+			}
+			live := db.config.DBName
+			db.config.DBName = stagingName(live)
+			r.cutover = append(r.cutover, CutoverTarget{Staging: *db.config, Live: live})
+		}
+	}
+}
+
+// includedDests returns the destinations of the databases the run includes
+func (r *workflowRun) includedDests() []DBConfig {
+	var dests []DBConfig
+	for _, db := range []struct {
+		name   string
+		config DBConfig
+	}{{"moodys", r.destMoodys}, {"tenant", r.destTenant}} {
+		if includesDatabase(r.databases, db.name) {
+			dests = append(dests, db.config)
+		}
+	}
+	return dests
+}
+
+// restored returns the destinations of the databases the run includes by name
+func (r *workflowRun) restored() map[string]DBConfig {
+	restored := make(map[string]DBConfig)
+	if includesDatabase(r.databases, "moodys") {
+		restored["moodys"] = r.destMoodys
+	}
+	if includesDatabase(r.databases, "tenant") {
+		restored["tenant"] = r.destTenant
+	}
+	return restored
+}
+
+// checkPlan writes the run's plan with -plan, or checks it against the reviewed one
+// with -approved-plan, and reports whether the run stops there
+func (r *workflowRun) checkPlan() bool {
+	f, cfg := r.flags, r.cfg
+	// Plans describe the run as configured; apply checks it hasn't changed since review
+	if f.planFile == "" && f.approvedPlan == "" {
+		return false
+	}
+	steps := BuildRunPlan(PlanInputs{
+		DumpOnly:         r.dumpOnly,
+		RestoreOnly:      r.restoreOnly,
+		Migrating:        r.migrating,
+		Incremental:      f.incremental,
+		Clone:            f.clone,
+		CDC:              f.cdc,
+		DiscoverFDW:      f.discoverFDW,
+		RestorePoints:    f.restorePoints,
+		CheckForeignKeys: f.checkFKs,
+		ValidateCatalog:  f.validateCatalog,
+		GrantsDryRun:     f.grantsDryRun,
+		Upload:           r.store != nil && !r.toStdout,
+		Download:         r.restoreOnly && r.store != nil && !r.fromStdin,
+		Databases:        r.databases,
+		Sources:          map[string]DBConfig{"moodys": r.moodys, "tenant": r.tenant},
+		Dests:            map[string]DBConfig{"moodys": r.destMoodys, "tenant": r.destTenant},
+		Cutover:          r.cutover,
+		DropPrevious:     f.dropPrevious,
+		DumpDir:          f.dumpDir,
+		ServerName:       r.serverName,
+		Config:           cfg,
+	})
+	if f.approvedPlan != "" {
+		plan, err := LoadPlan(f.approvedPlan)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		if err := checkPlan(plan, f.configPath, steps); err != nil {
+			fatalf("Refusing to apply %s: %v", f.approvedPlan, err)
+		}
+		alwaysLog.Printf("Run matches plan %s", f.approvedPlan)
+		return false
+	}
+	plan, err := NewRunPlan(os.Args[1:], f.configPath, steps)
+	if err != nil {
+		fatalf("Failed to plan run: %v", err)
+	}
+	if err := WritePlan(f.planFile, plan); err != nil {
+		fatalf("%v", err)
+	}
+	printPlan(plan)
+	alwaysLog.Printf("Plan written to %s; review it, then run: %s apply %s", f.planFile, filepath.Base(os.Args[0]), f.planFile)
+	return true
+}
+
+// checkPrivileges lists the grants the roles lack with -non-superuser check and reports
+// whether they lack none, which ends the run
+func (r *workflowRun) checkPrivileges() bool {
+	if r.flags.nonSuperuser != NonSuperuserCheck {
+		return false
+	}
+	sources := make(map[string]DBConfig)
+	dests := make(map[string]DBConfig)
+	for _, db := range []struct {
+		name      string
+		src, dest DBConfig
+	}{{"moodys", r.moodys, r.destMoodys}, {"tenant", r.tenant, r.destTenant}} {
+		if includesDatabase(r.databases, db.name) {
+			sources[db.name], dests[db.name] = db.src, db.dest
+		}
+	}
+	needs, err := CheckPrivileges(sources, dests, r.cfg.Provider)
+	if err != nil {
+		fatalf("Privilege check failed: %v", err)
+	}
+	if len(needs) == 0 {
+		alwaysLog.Printf("The configured roles can run the restore without superuser rights")
+		return true
+	}
+	for _, need := range needs {
+		fmt.Printf("%s: %s\n    %s\n", need.Database, need.Operation, need.Grant)
+	}
+	fatalf("The configured roles lack %d privileges; grant them or run with -non-superuser skip", len(needs))
+	return true
+}
+
+// execute runs the selected phases under the run lock and reports how they went
+func (r *workflowRun) execute(startTime time.Time) {
+	f, cfg := r.flags, r.cfg
+	connections := map[string]DBConfig{
+		"source_moodys": r.moodys,
+		"source_tenant": r.tenant,
+		"dest_moodys":   r.destMoodys,
+		"dest_tenant":   r.destTenant,
+	}
+	var err error
+	if r.hooks, err = NewHooks(cfg.Hooks, workflowPhases, connections); err != nil {
+		fatalf("Invalid hook configuration: %v", err)
+	}
+
+	CheckPoolers(connections, poolerIncompatible(f.syncSnapshots, f.cdc, f.singleTx))
+
+	if !r.restoreOnly && !f.clone {
+		uploadTo := r.store
+		if r.toStdout {
+			uploadTo = nil
+		}
+		if err := checkHeldDumpSets(f.dumpDir, uploadTo); err != nil {
+			fatalf("%v", err)
+		}
+	}
+
+	r.runID = time.Now().Format("20060102-150405")
+	lock, err := acquireRunLock(r.lockDests, r.runID)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	defer lock.Release()
+	r.report = NewRunReport(r.runID)
+	r.report.Profile = f.profile
+	activeReport = r.report
+	captureWarnings(r.report)
+	activePause = &PauseGate{}
+	watchPause(activePause, f.dumpDir)
+	activeTracer = NewTracer(cfg.Tracing)
+	if f.statusFile != "" {
+		if activeStatus, err = newStatusWriter(f.statusFile, r.runID); err != nil {
+			fatalf("Failed to start status file: %v", err)
+		}
+	}
+
+	// Restore points bracket everything the run does to the destination clusters
+	if f.restorePoints && !r.dumpOnly {
+		r.pointDests = r.includedDests()
+	}
+
+	err = r.runPhases()
+
+	// The closing restore point is created after failed runs too, to mark what they left
+	if r.pointsCreated {
+		pointErr := r.phase("restore_point_after", func() error {
+			return CreateRestorePoints(r.pointDests, r.runID, "after")
+		})
+		if pointErr != nil && err == nil {
+			err = fmt.Errorf("failed to create restore points: %w", pointErr)
+		}
+	}
+	r.finish(err)
+	if err != nil {
+		fatalf("Workflow failed: %v", err)
+	}
+
+	duration := time.Since(startTime)
+	alwaysLog.Printf("Complete database backup/restore workflow completed successfully in %v", duration.Round(time.Second))
+}
+
+// phase runs fn as a reported phase wrapped in the configured hooks
+func (r *workflowRun) phase(name string, fn func() error) error {
+	return r.report.Phase(name, r.hooks.Wrap(name, fn))
+}
+
+// runPhases runs the workflow from the opening restore point to cutover
+func (r *workflowRun) runPhases() error {
+	if len(r.pointDests) > 0 {
+		if err := r.phase("restore_point_before", func() error {
+			return CreateRestorePoints(r.pointDests, r.runID, "before")
+		}); err != nil {
+			return fmt.Errorf("failed to create restore points: %w", err)
+		}
+		r.pointsCreated = true
+	}
+
+	if r.cfg.Workflow != nil {
+		return r.phase("workflow", r.runWorkflow)
+	}
+
+	if r.flags.incremental {
+		if err := r.phase("incremental", r.refreshIncremental); err != nil {
+			return fmt.Errorf("incremental refresh failed: %w", err)
+		}
+	} else {
+		if err := r.prepareSources(); err != nil {
+			return err
+		}
+		if err := r.discoverFDW(); err != nil {
+			return err
+		}
+		if err := r.dump(); err != nil {
+			return err
+		}
+		if r.dumpOnly {
+			return nil
+		}
+		if err := r.restore(); err != nil {
+			return err
+		}
+	}
+
+	if err := r.validate(); err != nil {
+		return err
+	}
+	return r.cutOver()
+}
+
+// runWorkflow runs the configured workflow in place of the built-in one
+func (r *workflowRun) runWorkflow() error {
+	f, cfg := r.flags, r.cfg
+	log.Println("Running the configured workflow...")
+	builtin := map[string]WorkflowDatabase{
+		"moodys": {Source: r.moodys, Dest: r.destMoodys},
+		"tenant": {Source: r.tenant, Dest: r.destTenant, ForeignServers: []string{"moodys"}},
+	}
+	config := *cfg.Workflow
+	if len(config.Steps) == 0 {
+		// No steps: order restores by the foreign servers found in each database
+		var err error
+		if config, err = DiscoverWorkflow(config, builtin); err != nil {
+			return err
+		}
+	}
+	workflow, err := NewWorkflow(config, builtin, f.dumpDir, r.restoreOptions(r.runID))
+	if err != nil {
+		return err
+	}
+	workflow.validation = cfg.Validation
+	return workflow.Run()
+}
+
+// refreshIncremental refreshes the configured incremental tables from their watermarks
+func (r *workflowRun) refreshIncremental() error {
+	log.Println("Starting incremental refresh...")
+	sources := map[string]DBConfig{"moodys": r.moodys, "tenant": r.tenant}
+	dests := map[string]DBConfig{"moodys": r.destMoodys, "tenant": r.destTenant}
+	return IncrementalRefreshWithOptions(r.cfg.Incremental, sources, dests, r.flags.dumpDir, IncrementalOptions{Triggers: r.flags.disableTriggers})
+}
+
+// prepareSources drops the databases of an earlier full run and fills the sources with
+// test records
+func (r *workflowRun) prepareSources() error {
+	if r.migrating || r.dumpOnly || r.restoreOnly {
+		return nil
+	}
+	// Clean up any existing databases
+	if err := r.phase("cleanup", func() error {
+		log.Println("Cleaning up existing databases...")
+		dropOpts := DropOptions{Force: r.flags.force}
+		drops := []DBConfig{r.moodys, r.tenant, r.destMoodys, r.destTenant}
+		for _, t := range r.cfg.SchemaSplit {
+			drops = append(drops, t.config(r.destTenant))
+		}
+		return DeleteDatabasesWithOptions(dropOpts, drops...)
+	}); err != nil {
+		return fmt.Errorf("failed to cleanup existing databases: %w", err)
+	}
+
+	// Setup source databases with a large number of records
+	// 50 million records should take ~10-15 minutes to generate
+	const numTestRecords = 50000000
+	if err := r.phase("setup", func() error {
+		log.Printf("Setting up source databases with %d records...", numTestRecords)
+		return SetupSourceDatabases(r.moodys, r.tenant, numTestRecords)
+	}); err != nil {
+		return fmt.Errorf("failed to setup source databases: %w", err)
+	}
+	return nil
+}
+
+// discoverFDW finds where foreign servers point before anything is dumped
+func (r *workflowRun) discoverFDW() error {
+	if !r.flags.discoverFDW || r.flags.clone {
+		return nil
+	}
+	if err := r.phase("discover", func() error {
+		log.Println("Discovering foreign server targets...")
+		var err error
+		r.fdwPlan, err = PlanFDWRemaps(map[string]WorkflowDatabase{
+			"moodys": {Source: r.moodys, Dest: r.destMoodys},
+			"tenant": {Source: r.tenant, Dest: r.destTenant},
+		})
+		if err != nil {
+			return err
+		}
+		if err := WriteFDWPlan(r.flags.dumpDir, r.fdwPlan); err != nil {
+			return err
+		}
+		if r.fdwPlan == nil {
+			r.fdwPlan = []FDWRemap{}
+		}
+		return checkFDWPlan(r.fdwPlan)
+	}); err != nil {
+		return fmt.Errorf("foreign server discovery failed: %w", err)
+	}
+	return nil
+}
+
+// dump dumps the sources and uploads the dump set to the configured storage
+func (r *workflowRun) dump() error {
+	f, cfg := r.flags, r.cfg
+	if r.restoreOnly || f.clone {
+		return nil
+	}
+	if err := r.phase("dump", func() error {
+		log.Println("Starting database dump workflow...")
+		dumpOpts := DumpOptions{
+			Encryption:            cfg.Encryption,
+			GPG:                   cfg.GPG,
+			SynchronizedSnapshots: f.syncSnapshots,
+			MaxSnapshotSkew:       f.maxSkew,
+			CDC:                   f.cdc,
+			Databases:             r.databases,
+			Replicas:              r.replicas,
+			Layout:                ArtifactLayout{PlainData: f.plainData, SplitBytes: int64(f.splitGB * (1 << 30)), Tar: f.tarArchives},
+			Partitions:            f.partitions,
+			Delta:                 cfg.Delta,
+			Labels:                r.setLabels,
+			Hold:                  r.holdSet,
+		}
+		if err := DumpWorkflowWithOptions(r.moodys, r.tenant, f.dumpDir, dumpOpts); err != nil {
+			return err
+		}
+		if r.toStdout {
+			files, err := WriteDumpStream(f.dumpDir, os.Stdout)
+			if err != nil {
+				return err
+			}
+			removeDumpSet(f.dumpDir, files)
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to dump databases: %w", err)
+	}
+	if r.store != nil && !r.toStdout {
+		if err := r.phase("upload", func() error {
+			return r.store.UploadDumpSet(f.dumpDir)
+		}); err != nil {
+			return fmt.Errorf("failed to upload dump set: %w", err)
+		}
+	}
+	return nil
+}
+
+// restore restores the dump set into the destinations, or clones the sources on their
+// own cluster, and replays changes made since the dump
+func (r *workflowRun) restore() error {
+	f := r.flags
+	// A restore on its own fetches the dump set the dump uploaded
+	if r.restoreOnly && r.store != nil && !r.fromStdin {
+		if err := r.phase("download", func() error {
+			return r.store.DownloadDumpSet(f.dumpDir)
+		}); err != nil {
+			return fmt.Errorf("failed to download dump set: %w", err)
+		}
+	}
+
+	// Perform restore workflow, or copy the sources on their own cluster
+	if f.clone {
+		if err := r.phase("clone", func() error {
+			log.Println("Cloning source databases...")
+			return CloneWorkflow(map[string]WorkflowDatabase{
+				"moodys": {Source: r.moodys, Dest: r.destMoodys},
+				"tenant": {Source: r.tenant, Dest: r.destTenant},
+			}, r.databases, r.serverName)
+		}); err != nil {
+			return fmt.Errorf("failed to clone databases: %w", err)
+		}
+	} else if err := r.phase("restore", r.restoreDumpSet); err != nil {
+		return fmt.Errorf("failed to restore databases: %w", err)
+	}
+
+	// Replay changes made since the dump
+	if f.cdc {
+		if err := r.phase("cdc", func() error {
+			log.Println("Catching up with changes made since the dump...")
+			manifest, err := LoadManifest(f.dumpDir)
+			if err != nil {
+				return err
+			}
+			sources := map[string]DBConfig{"moodys": r.moodys, "tenant": r.tenant}
+			dests := map[string]DBConfig{"moodys": r.destMoodys, "tenant": r.destTenant}
+			return CDCCatchUp(manifest.CDCSlots, sources, dests, f.cdcTimeout)
+		}); err != nil {
+			return fmt.Errorf("change data capture failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// restoreDumpSet restores the dump set's pre-data, data, and post-data into the
+// destinations
+func (r *workflowRun) restoreDumpSet() error {
+	f, cfg := r.flags, r.cfg
+	log.Println("Starting database restore workflow...")
+	if r.restoreOnly || r.migrating {
+		// Staging databases left by an earlier run are scratch
+		for _, t := range r.cutover {
+			if err := DeleteDatabasesWithOptions(DropOptions{Force: true, Automatic: true}, t.Staging); err != nil {
+				return err
+			}
+		}
+	}
+	if r.fromStdin {
+		if err := ReadDumpStream(os.Stdin, f.dumpDir); err != nil {
+			return err
+		}
+	}
+	restoreOpts := RestoreOptions{
+		PerTable:             f.perTable,
+		MaxBadRows:           f.maxBadRows,
+		SingleTransaction:    f.singleTx,
+		RetryPreData:         f.retryPreData,
+		DatabaseSettings:     cfg.DatabaseSettings,
+		Locale:               cfg.Locale,
+		ErrorPolicy:          cfg.ErrorPolicy,
+		Encryption:           cfg.Encryption,
+		GPG:                  cfg.GPG,
+		RunID:                r.runID,
+		OnFailure:            cfg.OnRestoreFailure,
+		Databases:            r.databases,
+		ServerName:           r.serverName,
+		FDWTarget:            cfg.FDWTarget,
+		ImportForeignSchema:  cfg.ImportForeignSchema,
+		FDWTuning:            cfg.FDWTuning,
+		Compat:               cfg.Compat,
+		Transforms:           cfg.Transforms,
+		FDWPlan:              r.fdwPlan,
+		Locks:                cfg.Locks,
+		Watchdog:             cfg.Watchdog,
+		PostData:             cfg.PostData,
+		IndexMemory:          cfg.IndexMemory,
+		TOCTiming:            f.tocTiming,
+		SchemaTemplate:       cfg.Naming.Schema,
+		NameData:             r.tenantNames,
+		Renames:              cfg.Renames,
+		RefreshMatviews:      f.refreshMatviews,
+		RefreshConcurrently:  f.refreshConcurrently,
+		Subscriptions:        cfg.Subscriptions,
+		DisableEventTriggers: f.disableEventTriggers,
+		SkipExtensionObjects: f.skipExtensionObjects,
+		DetachPartitions:     f.detachPartitions,
+		Unlogged:             f.unlogged,
+		NonSuperuser:         f.nonSuperuser,
+		Provider:             cfg.Provider,
+		SchemaSplit:          cfg.SchemaSplit,
+	}
+	if r.fanOut != nil {
+		return RestoreFanOut(r.fanOut, r.moodys, r.tenant, f.dumpDir, restoreOpts, cfg.FanOut.Concurrency)
+	}
+	return RestoreWorkflowWithOptions(r.moodys, r.tenant, r.destMoodys, r.destTenant, f.dumpDir, restoreOpts)
+}
+
+// validate checks the restored databases: foreign keys, grants, the application role,
+// query checks, catalogs, data, and behavior, then warms and replays against them
+func (r *workflowRun) validate() error {
+	f, cfg := r.flags, r.cfg
+	if f.checkFKs {
+		if err := r.phase("check_foreign_keys", func() error {
+			var failed []string
+			for _, db := range []struct {
+				name   string
+				config DBConfig
+			}{{"moodys", r.destMoodys}, {"tenant", r.destTenant}} {
+				if !includesDatabase(r.databases, db.name) {
+					continue
+				}
+				if _, err := CheckForeignKeys(db.config, runtime.NumCPU()); err != nil {
+					log.Printf("%v", err)
+					failed = append(failed, db.name)
+				}
+			}
+			if len(failed) > 0 {
+				return fmt.Errorf("foreign key violations in %s; see the run report", strings.Join(failed, " and "))
+			}
+			return nil
+		}); err != nil {
+			return fmt.Errorf("foreign key check failed: %w", err)
+		}
+	}
+
+	restored := r.restored()
+	if len(cfg.Grants) > 0 {
+		if err := r.phase("grants", func() error {
+			log.Println("Applying grant templates...")
+			return ApplyGrants(cfg.Grants, restored, f.grantsDryRun)
+		}); err != nil {
+			return fmt.Errorf("failed to apply grants: %w", err)
+		}
+	}
+
+	if cfg.AppRole != nil {
+		if err := r.phase("app_role", func() error {
+			log.Printf("Checking read access as %s...", cfg.AppRole.User)
+			return SmokeTestAppRole(cfg.AppRole, restored)
+		}); err != nil {
+			return fmt.Errorf("application role smoke test failed: %w", err)
+		}
+	}
+
+	if len(cfg.QueryPack) > 0 {
+		if err := r.phase("query_pack", func() error {
+			log.Printf("Running %d query checks...", len(cfg.QueryPack))
+			return RunQueryPack(cfg.QueryPack, restored)
+		}); err != nil {
+			return fmt.Errorf("query pack failed: %w", err)
+		}
+	}
+
+	if f.validateCatalog {
+		if err := r.phase("validate_catalog", func() error {
+			log.Println("Comparing restored databases with the recorded source catalogs...")
+			manifest, err := LoadManifest(f.dumpDir)
+			if err != nil {
+				return err
+			}
+			if len(manifest.Catalogs) == 0 {
+				return fmt.Errorf("the manifest in %s records no catalogs", f.dumpDir)
+			}
+			return ValidateCatalogs(manifest.Catalogs, restored)
+		}); err != nil {
+			return fmt.Errorf("catalog validation failed: %w", err)
+		}
+	}
+
+	// Validate the restoration: moodys first, since tenant's foreign tables read it
+	if err := r.phase("validate", r.validateData); err != nil {
+		return fmt.Errorf("data validation failed: %w", err)
+	}
+
+	if includesDatabase(r.databases, "tenant") && len(cfg.BehaviorChecks) > 0 {
+		if err := r.phase("validate_behavior", func() error {
+			log.Println("Validating function and trigger behavior...")
+			return ValidateBehavior(r.tenant, r.destTenant, cfg.BehaviorChecks)
+		}); err != nil {
+			return fmt.Errorf("behavior validation failed: %w", err)
+		}
+	}
+
+	// Warming is best effort: a cold cache slows the first queries but breaks nothing
+	if cfg.Prewarm != nil {
+		if err := r.phase("prewarm", func() error {
+			log.Println("Warming the destination caches...")
+			return Prewarm(cfg.Prewarm, restored)
+		}); err != nil {
+			log.Printf("WARNING: cache warm-up failed: %v", err)
+		}
+	}
+
+	// Replayed after warming, so latencies reflect the cache the application will meet
+	if cfg.Replay != nil {
+		if err := r.phase("replay", func() error {
+			log.Printf("Replaying the workload in %s...", cfg.Replay.File)
+			return RunReplay(cfg.Replay, restored)
+		}); err != nil {
+			return fmt.Errorf("workload replay failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// validateData compares the restored data with the sources
+func (r *workflowRun) validateData() error {
+	validation := r.cfg.Validation
+	log.Println("Validating restored data...")
+	if includesDatabase(r.databases, "moodys") {
+		if err := validateReference(r.moodys, r.destMoodys, validation); err != nil {
+			return fmt.Errorf("moodys: %w", err)
+		}
+		if err := ValidateTimestamps(r.moodys, r.destMoodys, validation.timestampColumns()); err != nil {
+			return fmt.Errorf("moodys: %w", err)
+		}
+	}
+	if !includesDatabase(r.databases, "tenant") {
+		return nil
+	}
+	if err := validateContent(r.tenant, r.destTenant, validation); err != nil {
+		return fmt.Errorf("tenant: %w", err)
+	}
+	if err := ValidateTimestamps(r.tenant, r.destTenant, validation.timestampColumns()); err != nil {
+		return fmt.Errorf("tenant: %w", err)
+	}
+	return ValidateForeignTables(r.tenant, r.destTenant, validation)
+}
+
+// cutOver swaps the validated staging databases into place
+func (r *workflowRun) cutOver() error {
+	if len(r.cutover) == 0 {
+		return nil
+	}
+	if err := r.phase("cutover", func() error {
+		log.Println("Cutting over to the restored databases...")
+		return Cutover(r.cutover, r.flags.dropPrevious)
+	}); err != nil {
+		return fmt.Errorf("cutover failed: %w", err)
+	}
+	return nil
+}
+
+// finish saves, bundles, records, and mails the run report and prints its summary
+func (r *workflowRun) finish(err error) {
+	f, cfg, report := r.flags, r.cfg, r.report
+	report.Finish(err)
+	activeStatus.finish(err)
+	if traceErr := activeTracer.Flush(); traceErr != nil {
+		alwaysLog.Printf("Failed to export trace: %v", traceErr)
+	}
+	if _, saveErr := report.Save(f.dumpDir); saveErr != nil {
+		alwaysLog.Printf("Failed to save run report: %v", saveErr)
+	}
+	if f.bundle {
+		if _, bundleErr := CreateBundle(f.dumpDir, report); bundleErr != nil {
+			alwaysLog.Printf("Failed to create bundle: %v", bundleErr)
+		}
+	}
+	if cfg.History != nil {
+		var dumpBytes int64
+		if manifest, manifestErr := LoadManifest(f.dumpDir); manifestErr == nil && !f.incremental {
+			for _, a := range manifest.Artifacts {
+				dumpBytes += a.Size
+			}
+		}
+		alerts, historyErr := RecordRun(*cfg.History, report, dumpBytes)
+		if historyErr != nil {
+			alwaysLog.Printf("Failed to record run history: %v", historyErr)
+		}
+		for _, alert := range alerts {
+			alwaysLog.Printf("ALERT: %s", alert)
+		}
+	}
+	if cfg.Email != nil {
+		if mailErr := SendReportEmail(*cfg.Email, report); mailErr != nil {
+			alwaysLog.Printf("Failed to email run report: %v", mailErr)
+		}
+	}
+	printSummary(report)
+}
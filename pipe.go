@@ -0,0 +1,116 @@
+package main
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// dumpSetFiles lists the files of a dump set in stream order: the manifest and its
+// signature first, so a reader learns what follows, then every artifact
+func dumpSetFiles(dir string) ([]string, error) {
+	manifest, err := LoadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{manifestFileName}
+	if _, err := os.Stat(filepath.Join(dir, manifestFileName+".asc")); err == nil {
+		files = append(files, manifestFileName+".asc")
+	}
+	for _, a := range manifest.Artifacts {
+		artifact, err := artifactFiles(dir, a.File)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, artifact...)
+	}
+	return files, nil
+}
+
+// WriteDumpStream writes the dump set in dir to w as an uncompressed tar stream, so it
+// can be piped through external compressors, encryptors, or ssh. It returns the names
+// of the files written.
+func WriteDumpStream(dir string, w io.Writer) ([]string, error) {
+	files, err := dumpSetFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	tw := tar.NewWriter(w)
+	for _, name := range files {
+		if err := addToBundle(tw, dir, filepath.Join(dir, name)); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish dump stream: %w", err)
+	}
+	log.Printf("Wrote %d files of %s to the dump stream", len(files), dir)
+	return files, nil
+}
+
+// streamEntryName checks a tar entry names a file directly in the dump set
+func streamEntryName(name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return "", fmt.Errorf("unexpected entry %q in dump stream", name)
+	}
+	return name, nil
+}
+
+// ReadDumpStream extracts a tar stream written by WriteDumpStream into dir
+func ReadDumpStream(r io.Reader, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create dump directory: %w", err)
+	}
+	tr := tar.NewReader(r)
+	count := 0
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read dump stream: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			return fmt.Errorf("unexpected entry %q in dump stream", header.Name)
+		}
+		name, err := streamEntryName(header.Name)
+		if err != nil {
+			return err
+		}
+
+		path := filepath.Join(dir, name)
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", path, err)
+		}
+		if _, err := io.Copy(f, tr); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to extract %s: %w", path, err)
+		}
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("failed to extract %s: %w", path, err)
+		}
+		count++
+	}
+	if _, err := LoadManifest(dir); err != nil {
+		return fmt.Errorf("dump stream has no manifest: %w", err)
+	}
+	log.Printf("Extracted %d files from the dump stream into %s", count, dir)
+	return nil
+}
+
+// removeDumpSet deletes the files of a dump set once they have been streamed elsewhere;
+// step logs and reports stay
+func removeDumpSet(dir string, files []string) {
+	for _, name := range files {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			log.Printf("Failed to remove %s after streaming: %v", name, err)
+		}
+	}
+}
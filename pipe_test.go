@@ -0,0 +1,47 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDumpStreamRoundTrip(t *testing.T) {
+	src, dest := t.TempDir(), t.TempDir()
+	manifest := &Manifest{Artifacts: []ManifestArtifact{{Database: "tenant", Section: "data", File: "tenant_data"}}}
+	if err := WriteManifest(src, manifest); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "tenant_data"), []byte("archive"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(src, "unrelated.log"), []byte("x"), 0644)
+
+	var stream bytes.Buffer
+	files, err := WriteDumpStream(src, &stream)
+	if err != nil || len(files) != 2 {
+		t.Fatalf("wrote %v, %v", files, err)
+	}
+	if err := ReadDumpStream(&stream, dest); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := os.ReadFile(filepath.Join(dest, "tenant_data")); err != nil || string(content) != "archive" {
+		t.Errorf("extracted %q, %v", content, err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "unrelated.log")); err == nil {
+		t.Error("files outside the dump set should not be streamed")
+	}
+}
+
+func TestDumpStreamRejectsPaths(t *testing.T) {
+	var stream bytes.Buffer
+	tw := tar.NewWriter(&stream)
+	tw.WriteHeader(&tar.Header{Name: "../escape", Mode: 0644, Size: 1, Typeflag: tar.TypeReg})
+	tw.Write([]byte("x"))
+	tw.Close()
+	if err := ReadDumpStream(&stream, t.TempDir()); err == nil {
+		t.Error("expected an entry outside the dump directory to be rejected")
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// dialHost returns the host this tool connects to, bypassing any pooler
+func (c DBConfig) dialHost() string {
+	if c.DirectHost != "" {
+		return c.DirectHost
+	}
+	return c.Host
+}
+
+// dialPort returns the port this tool connects to, bypassing any pooler
+func (c DBConfig) dialPort() string {
+	if c.DirectPort != "" {
+		return c.DirectPort
+	}
+	return c.Port
+}
+
+// connArgs returns the -h, -p, and -U flags of the client tools for config, leaving out
+// those that are unset so libpq's defaults apply. A host starting with / is a Unix
+// socket directory, such as /var/run/postgresql, where the port picks the socket file.
+func (c DBConfig) connArgs() []string {
+	var args []string
+	for _, flag := range [][2]string{{"-h", c.dialHost()}, {"-p", c.dialPort()}, {"-U", c.User}} {
+		if flag[1] != "" {
+			args = append(args, flag[0], flag[1])
+		}
+	}
+	return args
+}
+
+// DirectEndpoint bypasses a connection pooler for one of the built-in connections
+type DirectEndpoint struct {
+	Host string `json:"host"`
+	Port string `json:"port"`
+}
+
+// serverPortQuery reports the port the PostgreSQL server itself listens on
+const serverPortQuery = "SELECT current_setting('port');"
+
+// poolerSuspected reports whether a connection reaches a server listening on another
+// port than the one connected to, which is how a pooler such as pgbouncer shows up
+func poolerSuspected(connectedPort, serverPort string) bool {
+	serverPort = strings.TrimSpace(serverPort)
+	return connectedPort != "" && serverPort != "" && connectedPort != serverPort
+}
+
+// poolerIncompatible lists the requested features that break through a transaction- or
+// statement-mode pooler
+func poolerIncompatible(snapshot, cdc, singleTransaction bool) []string {
+	features := []string{"pg_dump and pg_restore session settings"}
+	if snapshot {
+		features = append(features, "-snapshot (exported snapshots must stay in one session)")
+	}
+	if cdc {
+		features = append(features, "-cdc (poolers don't accept replication connections)")
+	}
+	if singleTransaction {
+		features = append(features, "-single-transaction")
+	}
+	return features
+}
+
+// CheckPoolers warns about connections that appear to go through a pooler without a
+// direct endpoint, naming the features that may misbehave. Connections that can't be
+// reached are skipped; the workflow reports those itself.
+func CheckPoolers(configs map[string]DBConfig, features []string) {
+	for _, name := range []string{"source_moodys", "source_tenant", "dest_moodys", "dest_tenant"} {
+		config, ok := configs[name]
+		if !ok || config.DirectHost != "" || config.DirectPort != "" {
+			continue
+		}
+		// Destinations don't exist yet; the server's port is the same from any database
+		probe := config
+		probe.DBName = "postgres"
+		output, err := newPsqlCmd(probe, "-t", "-A", "-c", serverPortQuery).Output()
+		if err != nil {
+			continue
+		}
+		if poolerSuspected(config.Port, string(output)) {
+			log.Printf("WARNING: %s (%s:%s) looks like a connection pooler in front of a server on port %s. "+
+				"In transaction or statement mode these may misbehave: %s. Set direct_host/direct_port to connect to the server itself.",
+				name, config.Host, config.Port, strings.TrimSpace(string(output)), strings.Join(features, "; "))
+		}
+	}
+}
+
+// applyDirectEndpoints sets direct endpoints on the built-in connections
+func applyDirectEndpoints(direct map[string]DirectEndpoint, targets map[string]*DBConfig) error {
+	for name, endpoint := range direct {
+		config, ok := targets[name]
+		if !ok {
+			return fmt.Errorf("unknown connection %q", name)
+		}
+		config.DirectHost, config.DirectPort = endpoint.Host, endpoint.Port
+	}
+	return nil
+}
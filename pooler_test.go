@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDialEndpoint(t *testing.T) {
+	config := DBConfig{Host: "pgbouncer", Port: "6432"}
+	if config.dialHost() != "pgbouncer" || config.dialPort() != "6432" {
+		t.Errorf("without an override got %s:%s", config.dialHost(), config.dialPort())
+	}
+	config.DirectHost, config.DirectPort = "db-primary", "5432"
+	if config.dialHost() != "db-primary" || config.dialPort() != "5432" {
+		t.Errorf("with an override got %s:%s", config.dialHost(), config.dialPort())
+	}
+}
+
+func TestPoolerSuspected(t *testing.T) {
+	if !poolerSuspected("6432", "5432\n") {
+		t.Error("expected a port mismatch to suggest a pooler")
+	}
+	if poolerSuspected("5432", "5432\n") || poolerSuspected("5432", "") {
+		t.Error("expected no pooler when the ports match or the server port is unknown")
+	}
+}
+
+func TestConnArgs(t *testing.T) {
+	for _, c := range []struct {
+		config DBConfig
+		want   string
+	}{
+		{DBConfig{Host: "db", Port: "5432", User: "postgres"}, "-h db -p 5432 -U postgres"},
+		{DBConfig{Host: "/var/run/postgresql", User: "postgres"}, "-h /var/run/postgresql -U postgres"},
+		{DBConfig{Host: "pgbouncer", Port: "6432", DirectHost: "/tmp"}, "-h /tmp -p 6432"},
+		{DBConfig{}, ""},
+	} {
+		if got := strings.Join(c.config.connArgs(), " "); got != c.want {
+			t.Errorf("%+v: got %q, want %q", c.config, got, c.want)
+		}
+	}
+}
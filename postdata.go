@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Default workers of the post-data phases: index builds parallelize well, while foreign
+// key checks scan the referenced tables too and contend with each other
+const (
+	defaultIndexJobs      = 4
+	defaultConstraintJobs = 2
+)
+
+// PostDataConfig restores post-data in two phases: first indexes and everything else
+// but foreign keys, then foreign keys, each with its own parallelism. A failure then
+// shows which phase it came from.
+type PostDataConfig struct {
+	// IndexJobs is how many pg_restore workers build indexes; default 4
+	IndexJobs int `json:"index_jobs"`
+	// ConstraintJobs is how many pg_restore workers add foreign keys; default 2
+	ConstraintJobs int `json:"constraint_jobs"`
+	// NotValid adds foreign keys NOT VALID, skipping the check of existing rows; they
+	// are listed as warnings to validate later
+	NotValid bool `json:"not_valid"`
+}
+
+func (c *PostDataConfig) validate() error {
+	if c.IndexJobs < 0 || c.ConstraintJobs < 0 {
+		return fmt.Errorf("index_jobs and constraint_jobs must not be negative")
+	}
+	return nil
+}
+
+// splitPostData separates foreign keys from the rest of post-data
+func splitPostData(entries []TOCEntry) (indexes, foreignKeys []TOCEntry) {
+	for _, entry := range entries {
+		if entry.Desc == "FK CONSTRAINT" {
+			foreignKeys = append(foreignKeys, entry)
+		} else {
+			indexes = append(indexes, entry)
+		}
+	}
+	return indexes, foreignKeys
+}
+
+// foreignKeyStatementRe matches a foreign key pg_dump adds to a plain table. Foreign
+// keys of partitioned tables, added without ONLY, are left alone, since those can't be
+// NOT VALID before PostgreSQL 18.
+var foreignKeyStatementRe = regexp.MustCompile(`(?m)^(ALTER TABLE ONLY [^\n]*\n\s+ADD CONSTRAINT [^\n]* FOREIGN KEY [^\n]*?)( NOT VALID)?;$`)
+
+// notValidForeignKeys rewrites a script's foreign keys to be added NOT VALID, returning
+// the script and the constraints changed
+func notValidForeignKeys(script string) (string, []string) {
+	var changed []string
+	rewritten := foreignKeyStatementRe.ReplaceAllStringFunc(script, func(statement string) string {
+		m := foreignKeyStatementRe.FindStringSubmatch(statement)
+		if m[2] == "" {
+			fields := strings.Fields(m[1])
+			// ALTER TABLE ONLY <table> ADD CONSTRAINT <name> ...
+			changed = append(changed, fields[3]+" "+fields[6])
+		}
+		return m[1] + " NOT VALID;"
+	})
+	return rewritten, changed
+}
+
+// tempTOCList writes entries to a temporary list file for pg_restore -L
+func tempTOCList(entries []TOCEntry) (string, func(), error) {
+	listFile, err := os.CreateTemp("", "pg_restore_fdw_*.list")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create TOC list file: %w", err)
+	}
+	listFile.Close()
+	cleanup := func() { os.Remove(listFile.Name()) }
+	if err := writeTOCList(listFile.Name(), entries); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return listFile.Name(), cleanup, nil
+}
+
+// restorePostDataPhases restores the post-data entries to keep in an index phase and
+// a foreign key phase
+func restorePostDataPhases(config DBConfig, inputFile, section string, keep []TOCEntry, opts RestoreOptions) error {
+	c := opts.PostData
+	indexes, foreignKeys := splitPostData(keep)
+	phases := []struct {
+		name    string
+		entries []TOCEntry
+		jobs    int
+	}{
+		{"indexes", indexes, defaultIfZero(c.IndexJobs, defaultIndexJobs)},
+		{"constraints", foreignKeys, defaultIfZero(c.ConstraintJobs, defaultConstraintJobs)},
+	}
+
+	for _, phase := range phases {
+		if len(phase.entries) == 0 {
+			continue
+		}
+		start := time.Now()
+		var err error
+		if phase.name == "constraints" && c.NotValid && opts.renamer == nil {
+			log.Printf("Adding %d foreign keys to %s NOT VALID", len(phase.entries), config.DBName)
+			err = restoreNotValidForeignKeys(config, inputFile, phase.entries, opts)
+		} else {
+			if phase.name == "constraints" && c.NotValid {
+				log.Printf("WARNING: foreign keys of %s are added with validation, since rename rules apply to it", config.DBName)
+			}
+			log.Printf("Restoring %d %s entries of %s with %d workers", len(phase.entries), phase.name, config.DBName, phase.jobs)
+			err = restorePhase(config, inputFile, section, phase.name, phase.entries, phase.jobs, opts)
+		}
+		if err != nil {
+			return fmt.Errorf("%s phase of %s: %w", phase.name, config.DBName, err)
+		}
+		log.Printf("Restored the %s of %s in %v", phase.name, config.DBName, time.Since(start).Round(time.Second))
+	}
+	return nil
+}
+
+// restorePhase restores some entries of a section with the given number of workers
+func restorePhase(config DBConfig, inputFile, section, phase string, entries []TOCEntry, jobs int, opts RestoreOptions) error {
+	listFile, cleanup, err := tempTOCList(entries)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	opts.listFile, opts.jobs, opts.phase = listFile, jobs, phase
+	return restoreDatabaseSection(config, inputFile, section, opts)
+}
+
+// restoreNotValidForeignKeys adds foreign keys NOT VALID through the archive's script
+func restoreNotValidForeignKeys(config DBConfig, inputFile string, entries []TOCEntry, opts RestoreOptions) error {
+	listFile, cleanup, err := tempTOCList(entries)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	script, err := newPgRestoreScriptCmd(listFile, inputFile).Output()
+	if err != nil {
+		return fmt.Errorf("failed to render foreign keys of %s: %w", inputFile, err)
+	}
+	rewritten, changed := notValidForeignKeys(string(script))
+
+	config = opts.Locks.session(config)
+	args := append(opts.ErrorPolicy.extraArgs("psql"), sessionArgs(opts.session)...)
+	cmd := newPsqlCmd(config, append(args, "-f", "-")...)
+	cmd.Stdin = strings.NewReader(rewritten)
+	output, err := runStreaming(cmd, stepName("restore", inputFile)+"_constraints", nil)
+	if err := opts.ErrorPolicy.Evaluate(err, string(output)); err != nil {
+		return fmt.Errorf("failed to add foreign keys: %w", err)
+	}
+	for _, constraint := range changed {
+		log.Printf("WARNING: foreign key %s in %s was added NOT VALID; check it with ALTER TABLE ... VALIDATE CONSTRAINT", constraint, config.DBName)
+	}
+	return nil
+}
+
+// defaultIfZero returns value, or def when value is zero
+func defaultIfZero(value, def int) int {
+	if value == 0 {
+		return def
+	}
+	return value
+}
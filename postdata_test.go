@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitPostData(t *testing.T) {
+	entries := parseTOC(`3344; 1259 16400 INDEX public idx_orders_date postgres
+3345; 2606 16401 CONSTRAINT public orders orders_pkey postgres
+3346; 2606 16402 FK CONSTRAINT public order_items order_items_order_id_fkey postgres
+3347; 2620 16403 TRIGGER public orders orders_audit postgres
+`)
+	indexes, foreignKeys := splitPostData(entries)
+	if len(indexes) != 3 || len(foreignKeys) != 1 || foreignKeys[0].DumpID != 3346 {
+		t.Errorf("got %d index phase entries and foreign keys %+v", len(indexes), foreignKeys)
+	}
+}
+
+func TestNotValidForeignKeys(t *testing.T) {
+	script := `ALTER TABLE ONLY public.order_items
+    ADD CONSTRAINT order_items_order_id_fkey FOREIGN KEY (order_id) REFERENCES public.orders(id) ON DELETE CASCADE;
+
+ALTER TABLE ONLY public.payments
+    ADD CONSTRAINT payments_order_id_fkey FOREIGN KEY (order_id) REFERENCES public.orders(id) NOT VALID;
+
+ALTER TABLE public.measurements
+    ADD CONSTRAINT measurements_city_id_fkey FOREIGN KEY (city_id) REFERENCES public.cities(id);
+`
+	rewritten, changed := notValidForeignKeys(script)
+	if strings.Join(changed, ",") != "public.order_items order_items_order_id_fkey" {
+		t.Errorf("changed %q", changed)
+	}
+	for _, want := range []string{
+		"REFERENCES public.orders(id) ON DELETE CASCADE NOT VALID;",
+		"REFERENCES public.orders(id) NOT VALID;\n",
+		"REFERENCES public.cities(id);",
+	} {
+		if !strings.Contains(rewritten, want) {
+			t.Errorf("expected %q in:\n%s", want, rewritten)
+		}
+	}
+	if strings.Contains(rewritten, "NOT VALID NOT VALID") {
+		t.Errorf("doubled NOT VALID in:\n%s", rewritten)
+	}
+}
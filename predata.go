@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// scriptHeaderRe matches the comment pg_dump writes before each object of a plain script,
+// e.g. "-- Name: v_orders; Type: VIEW; Schema: sales; Owner: app"
+var scriptHeaderRe = regexp.MustCompile(`^-- (?:Data for )?Name: (.*?); Type: (.*?); Schema: (.*?);`)
+
+// scriptEntry is one object of a plain script: the statements from its header comment
+// to the next, with the session settings in effect where it starts
+type scriptEntry struct {
+	Name     string
+	Type     string
+	Schema   string
+	settings []string
+	lines    []string
+}
+
+func (e scriptEntry) String() string {
+	if e.Schema == "-" || e.Schema == "" {
+		return fmt.Sprintf("%s %s", e.Type, e.Name)
+	}
+	return fmt.Sprintf("%s %s.%s", e.Type, e.Schema, e.Name)
+}
+
+// splitScript splits a pg_dump plain script into the preamble before the first object
+// and one entry per object. SET lines between objects, such as default_tablespace, are
+// carried into the settings of the entries that follow them.
+func splitScript(path string) ([]string, []scriptEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	var preamble []string
+	var entries []scriptEntry
+	settings := make(map[string]string)
+	var order []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if match := scriptHeaderRe.FindStringSubmatch(line); match != nil {
+			entry := scriptEntry{Name: match[1], Type: match[2], Schema: match[3]}
+			for _, key := range order {
+				entry.settings = append(entry.settings, settings[key])
+			}
+			entries = append(entries, entry)
+		}
+		if len(entries) == 0 {
+			preamble = append(preamble, line)
+			continue
+		}
+		if strings.HasPrefix(line, "SET ") {
+			key, _, _ := strings.Cut(strings.TrimPrefix(line, "SET "), " ")
+			if _, ok := settings[key]; !ok {
+				order = append(order, key)
+			}
+			settings[key] = line
+		}
+		last := &entries[len(entries)-1]
+		last.lines = append(last.lines, line)
+	}
+	return preamble, entries, scanner.Err()
+}
+
+// renderEntries writes a script of the preamble and entries, returning for each line of
+// it the index of the entry it belongs to, or -1
+func renderEntries(preamble []string, entries []scriptEntry) (string, []int) {
+	var b strings.Builder
+	var owners []int
+	write := func(owner int, lines []string) {
+		for _, line := range lines {
+			b.WriteString(line)
+			b.WriteByte('\n')
+			owners = append(owners, owner)
+		}
+	}
+	write(-1, preamble)
+	for i, entry := range entries {
+		write(-1, entry.settings)
+		write(i, entry.lines)
+	}
+	return b.String(), owners
+}
+
+// failedEntries maps psql's errors to the entries whose statements reported them;
+// ignorable errors, such as "already exists" on a retry, don't fail an entry
+func failedEntries(messages []RestoreMessage, owners []int) map[int][]RestoreMessage {
+	failed := make(map[int][]RestoreMessage)
+	for _, m := range messages {
+		if m.Severity != "ERROR" || m.Ignorable || m.Line < 1 || m.Line > len(owners) {
+			continue
+		}
+		if owner := owners[m.Line-1]; owner >= 0 {
+			failed[owner] = append(failed[owner], m)
+		}
+	}
+	return failed
+}
+
+// restoreScriptInPasses restores a plain pre-data script object by object: objects that
+// fail, typically because they depend on an object in another schema that comes later
+// in the script, are retried in further passes until every object is created or a pass
+// makes no progress, which fails the section with the objects left over. Errors the
+// policy counts as ignorable don't fail an object.
+func restoreScriptInPasses(config DBConfig, inputFile string, opts RestoreOptions) error {
+	preamble, pending, err := splitScript(inputFile)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", inputFile, err)
+	}
+	dir, err := os.MkdirTemp(filepath.Dir(inputFile), "passes_")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	log.Printf("Restoring %d objects of %s, retrying those that fail", len(pending), filepath.Base(inputFile))
+	for pass := 1; ; pass++ {
+		script, owners := renderEntries(preamble, pending)
+		passFile := filepath.Join(dir, fmt.Sprintf("%s_pass%d.sql", strings.TrimSuffix(filepath.Base(inputFile), ".sql"), pass))
+		if err := os.WriteFile(passFile, []byte(script), 0600); err != nil {
+			return err
+		}
+		cmd := newPsqlCmd(config, append(sessionArgs(opts.session), "-f", passFile)...)
+		output, runErr := runStreaming(cmd, stepName("restore", passFile), nil)
+
+		parsed := opts.ErrorPolicy.parseRestoreOutput(string(output))
+		failed := failedEntries(parsed.Messages, owners)
+		if len(failed) > 0 && len(failed) == len(pending) {
+			var errs []RestoreMessage
+			for i := range pending {
+				for _, m := range failed[i] {
+					m.Message = fmt.Sprintf("%s: %s", pending[i], m.Message)
+					errs = append(errs, m)
+				}
+			}
+			return fmt.Errorf("failed to restore database section: %d objects still fail after %d passes: %w",
+				len(failed), pass, &RestoreError{Errors: errs, Output: parsed})
+		}
+		if len(failed) == 0 {
+			if pass > 1 {
+				log.Printf("Every object of %s was restored after %d passes", filepath.Base(inputFile), pass)
+			}
+			if err := opts.ErrorPolicy.Evaluate(runErr, string(output)); err != nil {
+				return fmt.Errorf("failed to restore database section: %w", err)
+			}
+			return nil
+		}
+
+		var retry []scriptEntry
+		for i, entry := range pending {
+			if _, ok := failed[i]; ok {
+				retry = append(retry, entry)
+			}
+		}
+		log.Printf("Pass %d: %d of %d objects failed; retrying them now that the rest exist", pass, len(retry), len(pending))
+		pending = retry
+	}
+}
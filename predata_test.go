@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const passScript = `SET statement_timeout = 0;
+SET search_path = '';
+
+--
+-- Name: sales; Type: SCHEMA; Schema: -; Owner: app
+--
+
+CREATE SCHEMA sales;
+
+SET default_tablespace = '';
+
+--
+-- Name: v_orders; Type: VIEW; Schema: reporting; Owner: app
+--
+
+CREATE VIEW reporting.v_orders AS SELECT * FROM sales.orders;
+
+--
+-- Name: orders; Type: TABLE; Schema: sales; Owner: app
+--
+
+CREATE TABLE sales.orders (id integer);
+`
+
+func TestSplitScript(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tenant_pre-data.sql")
+	if err := os.WriteFile(path, []byte(passScript), 0644); err != nil {
+		t.Fatal(err)
+	}
+	preamble, entries, err := splitScript(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(preamble) != 4 || preamble[0] != "SET statement_timeout = 0;" {
+		t.Errorf("preamble = %q", preamble)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.String())
+	}
+	if got := strings.Join(names, ", "); got != "SCHEMA sales, VIEW reporting.v_orders, TABLE sales.orders" {
+		t.Errorf("entries = %s", got)
+	}
+	if len(entries[0].settings) != 0 {
+		t.Errorf("schema settings = %q", entries[0].settings)
+	}
+	if want := []string{"SET default_tablespace = '';"}; len(entries[2].settings) != 1 || entries[2].settings[0] != want[0] {
+		t.Errorf("table settings = %q, want %q", entries[2].settings, want)
+	}
+}
+
+func TestRenderEntriesAndFailedEntries(t *testing.T) {
+	preamble := []string{"SET search_path = '';"}
+	entries := []scriptEntry{
+		{Name: "v_orders", Type: "VIEW", Schema: "reporting", lines: []string{"--", "CREATE VIEW reporting.v_orders AS SELECT * FROM sales.orders;"}},
+		{Name: "orders", Type: "TABLE", Schema: "sales", settings: []string{"SET default_tablespace = '';"}, lines: []string{"--", "CREATE TABLE sales.orders (id integer);"}},
+	}
+	script, owners := renderEntries(preamble, entries)
+	if lines := strings.Split(strings.TrimSuffix(script, "\n"), "\n"); len(lines) != len(owners) || lines[4] != "--" {
+		t.Fatalf("script = %q, owners = %v", script, owners)
+	}
+	if want := []int{-1, 0, 0, -1, 1, 1}; !reflect.DeepEqual(owners, want) {
+		t.Errorf("owners = %v, want %v", owners, want)
+	}
+
+	messages := []RestoreMessage{
+		{Severity: "ERROR", Message: `relation "sales.orders" does not exist`, Line: 3},
+		{Severity: "ERROR", Message: `relation "orders" already exists`, Line: 6, Ignorable: true},
+		{Severity: "WARNING", Message: "no privileges were granted", Line: 6},
+		{Severity: "ERROR", Message: "syntax error", Line: 1},
+	}
+	failed := failedEntries(messages, owners)
+	if len(failed) != 1 || len(failed[0]) != 1 {
+		t.Errorf("failed = %+v", failed)
+	}
+}
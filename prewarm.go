@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// defaultPrewarmTables is how many of the largest tables are warmed by default
+const defaultPrewarmTables = 10
+
+// PrewarmConfig loads the largest restored tables and their indexes into the
+// destination's cache with pg_prewarm, so the first queries after a refresh don't all
+// read from disk
+type PrewarmConfig struct {
+	// Databases lists which of "moodys" and "tenant" to warm; default both
+	Databases []string `json:"databases"`
+	// Tables is how many of the largest tables, with their indexes, are candidates; default 10
+	Tables int `json:"tables"`
+	// MaxMB caps how much is loaded per database; default the size of shared_buffers
+	MaxMB int `json:"max_mb"`
+	// Mode is pg_prewarm's mode: buffer (default), read, or prefetch
+	Mode string `json:"mode"`
+}
+
+func (c *PrewarmConfig) validate() error {
+	for _, db := range c.Databases {
+		if db != "moodys" && db != "tenant" {
+			return fmt.Errorf("unknown database %q", db)
+		}
+	}
+	if c.Tables < 0 || c.MaxMB < 0 {
+		return fmt.Errorf("tables and max_mb must not be negative")
+	}
+	switch c.Mode {
+	case "", "buffer", "read", "prefetch":
+	default:
+		return fmt.Errorf("unknown mode %q: use buffer, read, or prefetch", c.Mode)
+	}
+	return nil
+}
+
+// databases returns the databases to warm
+func (c *PrewarmConfig) databases() []string {
+	if len(c.Databases) == 0 {
+		return []string{"moodys", "tenant"}
+	}
+	return c.Databases
+}
+
+// prewarmCandidatesQuery lists the largest tables, each followed by its indexes
+const prewarmCandidatesQuery = `WITH t AS (
+	SELECT c.oid, pg_relation_size(c.oid) AS bytes FROM pg_class c
+	JOIN pg_namespace n ON n.oid = c.relnamespace
+	WHERE c.relkind IN ('r', 'm') AND n.nspname NOT IN ('pg_catalog', 'information_schema') AND n.nspname NOT LIKE 'pg\_%%'
+	ORDER BY 2 DESC LIMIT %d)
+SELECT r.oid::regclass, r.bytes FROM (
+	SELECT t.oid, t.bytes, t.bytes AS table_bytes, 0 AS is_index FROM t
+	UNION ALL
+	SELECT i.indexrelid, pg_relation_size(i.indexrelid), t.bytes, 1 FROM pg_index i JOIN t ON t.oid = i.indrelid
+) r ORDER BY r.table_bytes DESC, r.is_index, r.bytes DESC;`
+
+// prewarmRelation is a table or index considered for warming
+type prewarmRelation struct {
+	Name  string
+	Bytes int64
+}
+
+// selectPrewarm picks relations in order while they fit the budget, skipping ones too
+// large for what is left
+func selectPrewarm(candidates []prewarmRelation, budget int64) ([]prewarmRelation, int64) {
+	var selected []prewarmRelation
+	var total int64
+	for _, r := range candidates {
+		if r.Bytes == 0 || total+r.Bytes > budget {
+			continue
+		}
+		selected = append(selected, r)
+		total += r.Bytes
+	}
+	return selected, total
+}
+
+// Prewarm loads the largest tables and indexes of the restored databases into cache.
+// A database without the pg_prewarm extension available is skipped with a warning.
+func Prewarm(c *PrewarmConfig, restored map[string]DBConfig) error {
+	for _, name := range c.databases() {
+		config, ok := restored[name]
+		if !ok {
+			continue
+		}
+		if err := prewarmDatabase(c, config); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// prewarmDatabase warms one database
+func prewarmDatabase(c *PrewarmConfig, config DBConfig) error {
+	rows, err := catalogRows(config, "SELECT count(*) FROM pg_available_extensions WHERE name = 'pg_prewarm';")
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 || rows[0][0] != "1" {
+		log.Printf("WARNING: pg_prewarm is not available on %s; skipping cache warm-up", config.DBName)
+		return nil
+	}
+	output, err := newPsqlCmd(config, "-v", "ON_ERROR_STOP=1", "-c", "CREATE EXTENSION IF NOT EXISTS pg_prewarm;").CombinedOutput()
+	if err != nil {
+		log.Printf("WARNING: could not create pg_prewarm in %s; skipping cache warm-up: %v, output: %s", config.DBName, err, output)
+		return nil
+	}
+
+	budget := int64(c.MaxMB) << 20
+	if budget == 0 {
+		rows, err := catalogRows(config, "SELECT pg_size_bytes(current_setting('shared_buffers'));")
+		if err != nil || len(rows) == 0 {
+			return fmt.Errorf("failed to read shared_buffers: %v", err)
+		}
+		if budget, err = strconv.ParseInt(rows[0][0], 10, 64); err != nil {
+			return fmt.Errorf("unexpected shared_buffers %q", rows[0][0])
+		}
+	}
+	tables := c.Tables
+	if tables == 0 {
+		tables = defaultPrewarmTables
+	}
+	rows, err = catalogRows(config, fmt.Sprintf(prewarmCandidatesQuery, tables))
+	if err != nil {
+		return err
+	}
+	var candidates []prewarmRelation
+	for _, row := range rows {
+		if len(row) < 2 {
+			continue
+		}
+		bytes, _ := strconv.ParseInt(row[1], 10, 64)
+		candidates = append(candidates, prewarmRelation{Name: row[0], Bytes: bytes})
+	}
+	selected, total := selectPrewarm(candidates, budget)
+	if len(selected) == 0 {
+		log.Printf("Nothing to warm in %s within %s", config.DBName, formatBytes(budget))
+		return nil
+	}
+
+	mode := c.Mode
+	if mode == "" {
+		mode = "buffer"
+	}
+	var names []string
+	for _, r := range selected {
+		names = append(names, quoteLiteral(r.Name))
+	}
+	query := fmt.Sprintf("SELECT sum(pg_prewarm(r::regclass, %s)) FROM unnest(ARRAY[%s]) AS r;", quoteLiteral(mode), strings.Join(names, ", "))
+	log.Printf("Warming %d relations of %s (%s) with pg_prewarm in %s mode", len(selected), config.DBName, formatBytes(total), mode)
+	rows, err = catalogRows(config, query)
+	if err != nil {
+		return err
+	}
+	if len(rows) > 0 {
+		log.Printf("Warmed %s blocks of %s", rows[0][0], config.DBName)
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+package main
+
+import "testing"
+
+func TestSelectPrewarm(t *testing.T) {
+	candidates := []prewarmRelation{
+		{"public.orders", 600}, {"public.orders_pkey", 200}, {"public.items", 300}, {"public.items_pkey", 100}, {"public.empty", 0},
+	}
+	selected, total := selectPrewarm(candidates, 1000)
+	if total != 900 || len(selected) != 3 || selected[2].Name != "public.items_pkey" {
+		t.Errorf("got %v, %d", selected, total)
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+)
+
+// Ways to run without superuser rights
+const (
+	// NonSuperuserCheck lists the grants the role lacks for the run and stops
+	NonSuperuserCheck = "check"
+	// NonSuperuserSkip runs anyway, leaving out what the role can't restore
+	NonSuperuserSkip = "skip"
+)
+
+// validNonSuperuserMode checks a -non-superuser value; empty assumes a superuser
+func validNonSuperuserMode(mode string) error {
+	switch mode {
+	case "", NonSuperuserCheck, NonSuperuserSkip:
+		return nil
+	}
+	return fmt.Errorf("unknown mode %q: use %s or %s", mode, NonSuperuserCheck, NonSuperuserSkip)
+}
+
+// rolePrivilegesQuery reports the connecting role's relevant attributes
+const rolePrivilegesQuery = `SELECT current_user, rolsuper, rolcreatedb FROM pg_roles WHERE rolname = current_user;`
+
+// extensionSupportQuery lists available extensions, whether a non-superuser may create
+// them, and whether they are installed in the current database
+const extensionSupportQuery = `SELECT e.name, coalesce(bool_or(v.trusted), false), e.installed_version IS NOT NULL
+FROM pg_available_extensions e LEFT JOIN pg_available_extension_versions v ON v.name = e.name
+GROUP BY e.name, e.installed_version;`
+
+// superuserSettingsQuery lists the settings only a superuser may change
+const superuserSettingsQuery = `SELECT name FROM pg_settings WHERE context = 'superuser' ORDER BY 1;`
+
+// fdwUsageQuery lists the foreign-data wrappers in the current database and whether
+// the connecting role may create servers for them
+const fdwUsageQuery = `SELECT fdwname, has_foreign_data_wrapper_privilege(fdwname, 'USAGE') FROM pg_foreign_data_wrapper;`
+
+// sourceNeedsQuery lists what a source database has that needs elevated rights to restore
+const sourceNeedsQuery = `SELECT 'extension', extname FROM pg_extension
+UNION SELECT 'fdw', w.fdwname FROM pg_foreign_server s JOIN pg_foreign_data_wrapper w ON w.oid = s.srvfdw
+UNION SELECT 'event_trigger', evtname FROM pg_event_trigger;`
+
+// RolePrivileges describes the role a destination is restored as
+type RolePrivileges struct {
+	Role      string
+	Superuser bool
+	CreateDB  bool
+}
+
+// PrivilegeTarget is what a destination offers a non-superuser: the extensions and
+// wrappers in the database objects are created in, template1 for new databases
+type PrivilegeTarget struct {
+	Database  string
+	DBName    string
+	Exists    bool
+	Role      RolePrivileges
+	Trusted   map[string]bool
+	Installed map[string]bool
+	// FDWUsage maps each installed wrapper to whether the role may use it
+	FDWUsage map[string]bool
+	// EventTriggers reports whether the role may create event triggers anyway, as a
+	// managed service's admin role may
+	EventTriggers bool
+	// SuperuserSettings are the settings only a superuser may change
+	SuperuserSettings []string
+}
+
+// PrivilegeNeed is an operation the role can't do and the grant that would allow it
+type PrivilegeNeed struct {
+	Database  string
+	Operation string
+	Grant     string
+}
+
+// lookUpPrivileges reads what the destination offers the connecting role, including
+// what a managed service's provider lets its admin role do
+func lookUpPrivileges(database string, dest DBConfig, provider *ProviderConfig) (PrivilegeTarget, error) {
+	target := PrivilegeTarget{Database: database, DBName: dest.DBName, Trusted: map[string]bool{}, Installed: map[string]bool{}, FDWUsage: map[string]bool{}}
+	rows, err := queryRows(maintenanceConfig(dest), rolePrivilegesQuery)
+	if err != nil {
+		return target, err
+	}
+	if len(rows) != 1 || len(rows[0]) != 3 {
+		return target, fmt.Errorf("failed to read the privileges of %s", dest.User)
+	}
+	target.Role = RolePrivileges{Role: rows[0][0], Superuser: rows[0][1] == "t", CreateDB: rows[0][2] == "t"}
+	if target.Role.Superuser {
+		return target, nil
+	}
+
+	if rows, err = queryRows(maintenanceConfig(dest), superuserSettingsQuery); err != nil {
+		return target, err
+	}
+	for _, row := range rows {
+		target.SuperuserSettings = append(target.SuperuserSettings, row[0])
+	}
+	if target.Exists, err = databaseExists(dest, dest.DBName); err != nil {
+		return target, err
+	}
+	catalog := dest
+	if !target.Exists {
+		catalog.DBName = "template1"
+	}
+	if rows, err = queryRows(catalog, extensionSupportQuery); err != nil {
+		return target, err
+	}
+	for _, row := range rows {
+		if len(row) == 3 {
+			target.Trusted[row[0]] = row[1] == "t"
+			target.Installed[row[0]] = row[2] == "t"
+		}
+	}
+	if rows, err = queryRows(catalog, fdwUsageQuery); err != nil {
+		return target, err
+	}
+	for _, row := range rows {
+		if len(row) == 2 {
+			target.FDWUsage[row[0]] = row[1] == "t"
+		}
+	}
+
+	if profile := provider.profile(); profile != nil {
+		allowed, err := provider.allowedExtensions(maintenanceConfig(dest))
+		if err != nil {
+			return target, err
+		}
+		for _, name := range allowed {
+			target.Trusted[name] = true
+		}
+		target.EventTriggers = profile.EventTriggers
+		rows, err := queryRows(maintenanceConfig(dest), fmt.Sprintf("SELECT pg_has_role(%s, 'MEMBER');", quoteLiteral(profile.AdminRole)))
+		if err != nil || len(rows) != 1 || rows[0][0] != "t" {
+			log.Printf("WARNING: %s is not a member of %s, so %s won't let it create extensions", target.Role.Role, profile.AdminRole, provider.Name)
+		}
+	}
+	return target, nil
+}
+
+// privilegeNeeds lists what restoring a source with the given extensions, foreign-data
+// wrappers, and event triggers needs beyond the target's role
+func privilegeNeeds(t PrivilegeTarget, extensions, fdws, eventTriggers []string) []PrivilegeNeed {
+	if t.Role.Superuser {
+		return nil
+	}
+	var needs []PrivilegeNeed
+	add := func(operation, grant string) {
+		needs = append(needs, PrivilegeNeed{Database: t.Database, Operation: operation, Grant: grant})
+	}
+	catalog := t.DBName
+	if !t.Exists {
+		catalog = "template1"
+		if !t.Role.CreateDB {
+			add("CREATE DATABASE "+quoteIdent(t.DBName), fmt.Sprintf("ALTER ROLE %s CREATEDB;", quoteIdent(t.Role.Role)))
+		}
+	}
+	for _, name := range extensions {
+		if !t.Installed[name] && !t.Trusted[name] {
+			add("CREATE EXTENSION "+quoteIdent(name),
+				fmt.Sprintf("-- as a superuser in %s: CREATE EXTENSION IF NOT EXISTS %s;", catalog, quoteIdent(name)))
+		}
+	}
+	for _, name := range fdws {
+		if !t.FDWUsage[name] {
+			add("CREATE SERVER ... FOREIGN DATA WRAPPER "+quoteIdent(name),
+				fmt.Sprintf("-- in %s: GRANT USAGE ON FOREIGN DATA WRAPPER %s TO %s;", catalog, quoteIdent(name), quoteIdent(t.Role.Role)))
+		}
+	}
+	if len(eventTriggers) > 0 && !t.EventTriggers {
+		add(fmt.Sprintf("CREATE EVENT TRIGGER (%d)", len(eventTriggers)),
+			"-- event triggers need a superuser; -non-superuser skip leaves them out")
+	}
+	return needs
+}
+
+// CheckPrivileges compares what each source database needs with what the role offers
+// on its destination
+func CheckPrivileges(sources, dests map[string]DBConfig, provider *ProviderConfig) ([]PrivilegeNeed, error) {
+	var names []string
+	for name := range dests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var needs []PrivilegeNeed
+	for _, name := range names {
+		target, err := lookUpPrivileges(name, dests[name], provider)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check privileges on %s: %w", name, err)
+		}
+		rows, err := queryRows(sources[name], sourceNeedsQuery)
+		if err != nil {
+			return nil, fmt.Errorf("failed to inspect source %s: %w", name, err)
+		}
+		found := map[string][]string{}
+		for _, row := range rows {
+			if len(row) == 2 {
+				found[row[0]] = append(found[row[0]], row[1])
+			}
+		}
+		needs = append(needs, privilegeNeeds(target, found["extension"], found["fdw"], found["event_trigger"])...)
+	}
+	return needs, nil
+}
+
+// superuserRules comment out what pg_dump writes into pre-data that the target's role
+// can't run: extensions it may not create, comments on extensions it doesn't own, and
+// settings only a superuser may change
+func superuserRules(t PrivilegeTarget) []CompatRule {
+	if t.Role.Superuser {
+		return nil
+	}
+	rules := []CompatRule{{Name: "comment_on_extension", Pattern: `^COMMENT ON EXTENSION .*;$`, Replace: "-- skipped, needs extension owner: $0"}}
+	for _, name := range t.SuperuserSettings {
+		rules = append(rules, CompatRule{Name: "superuser_setting_" + name, Pattern: `^SET ` + regexp.QuoteMeta(name) + ` = .*;$`, Replace: "-- skipped, needs superuser: $0"})
+	}
+	var names []string
+	for name := range t.Trusted {
+		if !t.Trusted[name] && !t.Installed[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		rules = append(rules, CompatRule{
+			Name:    "superuser_extension_" + name,
+			Pattern: `^CREATE EXTENSION IF NOT EXISTS (` + regexp.QuoteMeta(name) + `|` + regexp.QuoteMeta(quoteIdent(name)) + `)( .*)?;$`,
+			Replace: "-- skipped, needs superuser: $0",
+		})
+	}
+	return rules
+}
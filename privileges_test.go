@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPrivilegeNeeds(t *testing.T) {
+	target := PrivilegeTarget{
+		Database:  "tenant",
+		DBName:    "tenant_restored",
+		Role:      RolePrivileges{Role: "restorer"},
+		Trusted:   map[string]bool{"pgcrypto": true, "postgres_fdw": false, "plpgsql": false},
+		Installed: map[string]bool{"plpgsql": true},
+		FDWUsage:  map[string]bool{},
+	}
+	needs := privilegeNeeds(target, []string{"plpgsql", "pgcrypto", "postgres_fdw"}, []string{"postgres_fdw"}, nil)
+	var ops []string
+	for _, need := range needs {
+		ops = append(ops, need.Operation)
+	}
+	want := `CREATE DATABASE "tenant_restored"|CREATE EXTENSION "postgres_fdw"|CREATE SERVER ... FOREIGN DATA WRAPPER "postgres_fdw"`
+	if got := strings.Join(ops, "|"); got != want {
+		t.Errorf("needs = %s, want %s", got, want)
+	}
+	target.Role.Superuser = true
+	if needs := privilegeNeeds(target, []string{"postgres_fdw"}, nil, []string{"audit"}); len(needs) != 0 {
+		t.Errorf("a superuser needs nothing, got %v", needs)
+	}
+}
+
+func TestSuperuserRules(t *testing.T) {
+	target := PrivilegeTarget{
+		Trusted:   map[string]bool{"pgcrypto": true, "postgres_fdw": false, "uuid-ossp": false},
+		Installed: map[string]bool{},
+	}
+	layer := newCompatLayer(nil, 16, 16, superuserRules(target)...)
+	script := "CREATE EXTENSION IF NOT EXISTS postgres_fdw WITH SCHEMA public;\nCREATE EXTENSION IF NOT EXISTS \"uuid-ossp\" WITH SCHEMA public;\nCREATE EXTENSION IF NOT EXISTS pgcrypto WITH SCHEMA public;\nCOMMENT ON EXTENSION pgcrypto IS 'cryptographic functions';\n"
+	var out bytes.Buffer
+	if err := layer.rewriteScript(strings.NewReader(script), &out); err != nil {
+		t.Fatal(err)
+	}
+	want := "-- skipped, needs superuser: CREATE EXTENSION IF NOT EXISTS postgres_fdw WITH SCHEMA public;\n" +
+		"-- skipped, needs superuser: CREATE EXTENSION IF NOT EXISTS \"uuid-ossp\" WITH SCHEMA public;\n" +
+		"CREATE EXTENSION IF NOT EXISTS pgcrypto WITH SCHEMA public;\n" +
+		"-- skipped, needs extension owner: COMMENT ON EXTENSION pgcrypto IS 'cryptographic functions';\n"
+	if out.String() != want {
+		t.Errorf("rewritten script = %q, want %q", out.String(), want)
+	}
+}
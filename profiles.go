@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ConnectionSettings override fields of a connection; empty fields are inherited
+type ConnectionSettings struct {
+	Host     string `json:"host,omitempty"`
+	Port     string `json:"port,omitempty"`
+	User     string `json:"user,omitempty"`
+	Password string `json:"password,omitempty"`
+	DBName   string `json:"dbname,omitempty"`
+	Options  string `json:"options,omitempty"`
+	// Environment tags the databases reached through the connection, e.g. prod
+	Environment string `json:"environment,omitempty"`
+}
+
+// ProfileConfig is a named set of connection settings, such as dev, staging, or prod,
+// selected with -profile
+type ProfileConfig struct {
+	// Defaults apply to every connection while the profile is selected
+	Defaults    ConnectionSettings            `json:"defaults"`
+	Connections map[string]ConnectionSettings `json:"connections"`
+}
+
+// apply overrides config's fields with the settings that are set
+func (s ConnectionSettings) apply(config *DBConfig) {
+	for _, field := range []struct {
+		value  string
+		target *string
+	}{
+		{s.Host, &config.Host}, {s.Port, &config.Port}, {s.User, &config.User}, {s.Password, &config.Password},
+		{s.DBName, &config.DBName}, {s.Options, &config.Options}, {s.Environment, &config.Environment},
+	} {
+		if field.value != "" {
+			*field.target = field.value
+		}
+	}
+}
+
+// checkConnectionNames rejects settings for connections that don't exist
+func checkConnectionNames(settings map[string]ConnectionSettings, connections map[string]*DBConfig) error {
+	for name := range settings {
+		if _, ok := connections[name]; !ok {
+			var names []string
+			for known := range connections {
+				names = append(names, known)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("unknown connection %q: use one of %s", name, strings.Join(names, ", "))
+		}
+	}
+	return nil
+}
+
+// resolveConnections layers the configured settings over the built-in connections, from
+// least to most specific: shared defaults, shared per-connection settings, the
+// profile's defaults, and the profile's per-connection settings
+func resolveConnections(cfg *Config, profile string, connections map[string]*DBConfig) error {
+	if err := checkConnectionNames(cfg.Connections, connections); err != nil {
+		return err
+	}
+	layers := []ConnectionSettings{cfg.Defaults}
+	perConnection := []map[string]ConnectionSettings{cfg.Connections}
+	if profile != "" {
+		p, ok := cfg.Profiles[profile]
+		if !ok {
+			var names []string
+			for name := range cfg.Profiles {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("unknown profile %q: the configuration has %s", profile, strings.Join(names, ", "))
+		}
+		if err := checkConnectionNames(p.Connections, connections); err != nil {
+			return fmt.Errorf("profile %s: %w", profile, err)
+		}
+		layers = append(layers, p.Defaults)
+		perConnection = append(perConnection, p.Connections)
+	}
+	for name, config := range connections {
+		for i := range layers {
+			layers[i].apply(config)
+			perConnection[i][name].apply(config)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestResolveConnections(t *testing.T) {
+	cfg := &Config{
+		Defaults:    ConnectionSettings{User: "restore", Password: "shared"},
+		Connections: map[string]ConnectionSettings{"dest_tenant": {DBName: "tenant_copy"}},
+		Profiles: map[string]ProfileConfig{
+			"prod": {
+				Defaults:    ConnectionSettings{Host: "prod-db", Environment: "prod"},
+				Connections: map[string]ConnectionSettings{"dest_tenant": {Host: "prod-replica", Environment: "prod-copy"}},
+			},
+		},
+	}
+	src := DBConfig{Host: "localhost", Port: "5432", User: "postgres", DBName: "tenant"}
+	dest := DBConfig{Host: "localhost", Port: "5432", User: "postgres", DBName: "tenant_dest"}
+	if err := resolveConnections(cfg, "prod", map[string]*DBConfig{"source_tenant": &src, "dest_tenant": &dest}); err != nil {
+		t.Fatal(err)
+	}
+	if want := (DBConfig{Host: "prod-db", Port: "5432", User: "restore", Password: "shared", DBName: "tenant", Environment: "prod"}); src != want {
+		t.Errorf("source = %+v, want %+v", src, want)
+	}
+	if want := (DBConfig{Host: "prod-replica", Port: "5432", User: "restore", Password: "shared", DBName: "tenant_copy", Environment: "prod-copy"}); dest != want {
+		t.Errorf("dest = %+v, want %+v", dest, want)
+	}
+
+	if err := resolveConnections(cfg, "qa", map[string]*DBConfig{"dest_tenant": &dest}); err == nil {
+		t.Error("unknown profile resolved")
+	}
+	if err := resolveConnections(cfg, "", map[string]*DBConfig{"source_tenant": &src}); err == nil {
+		t.Error("settings for an unknown connection resolved")
+	}
+}
+
+func TestDenyEnvironments(t *testing.T) {
+	p := &Protections{DenyEnvironments: []string{"prod"}, Confirmed: true}
+	if err := p.checkDrop(DBConfig{DBName: "tenant", Environment: "prod"}, DropOptions{}); err == nil {
+		t.Error("dropped a database tagged prod")
+	}
+	if err := p.checkDrop(DBConfig{DBName: "tenant", Environment: "staging"}, DropOptions{}); err != nil {
+		t.Errorf("staging drop refused: %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProgressEvent is one update from a ProgressMonitor
+type ProgressEvent struct {
+	Operation string    `json:"operation"`
+	Status    string    `json:"status"`
+	StartedAt time.Time `json:"started_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// BytesDone and BytesTotal are set by operations that know their size, like transfers
+	BytesDone  int64 `json:"bytes_done,omitempty"`
+	BytesTotal int64 `json:"bytes_total,omitempty"`
+	Finished   bool  `json:"finished"`
+}
+
+// elapsed returns how long the operation had been running at the event
+func (e ProgressEvent) elapsed() time.Duration {
+	return e.UpdatedAt.Sub(e.StartedAt).Round(time.Second)
+}
+
+// ProgressSink receives the events of every ProgressMonitor. Sinks are called from
+// concurrent operations and must be safe for concurrent use.
+type ProgressSink interface {
+	Progress(e ProgressEvent)
+}
+
+// progressSinks receive progress events; set up in main before any work starts
+var progressSinks = []ProgressSink{logSink{}}
+
+// emitProgress sends an event to every sink
+func emitProgress(e ProgressEvent) {
+	for _, sink := range progressSinks {
+		sink.Progress(e)
+	}
+}
+
+// logSink logs each event, which is what ProgressMonitor always did
+type logSink struct{}
+
+func (logSink) Progress(e ProgressEvent) {
+	log.Printf("[%s] %s (elapsed: %v)", e.Operation, e.Status, e.elapsed())
+}
+
+// ProgressState keeps the latest event of each operation in memory, for in-process
+// consumers such as an API or a terminal UI
+type ProgressState struct {
+	mu  sync.Mutex
+	ops map[string]ProgressEvent
+}
+
+func NewProgressState() *ProgressState {
+	return &ProgressState{ops: make(map[string]ProgressEvent)}
+}
+
+func (s *ProgressState) Progress(e ProgressEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ops[e.Operation] = e
+}
+
+// Snapshot returns the latest event of each operation in the order they started
+func (s *ProgressState) Snapshot() []ProgressEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	events := make([]ProgressEvent, 0, len(s.ops))
+	for _, e := range s.ops {
+		events = append(events, e)
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if !events[i].StartedAt.Equal(events[j].StartedAt) {
+			return events[i].StartedAt.Before(events[j].StartedAt)
+		}
+		return events[i].Operation < events[j].Operation
+	})
+	return events
+}
+
+// snapshotFile rewrites a file from a ProgressState after every event
+type snapshotFile struct {
+	mu     sync.Mutex
+	state  *ProgressState
+	path   string
+	render func([]ProgressEvent) ([]byte, error)
+}
+
+func (f *snapshotFile) Progress(e ProgressEvent) {
+	f.state.Progress(e)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	content, err := f.render(f.state.Snapshot())
+	if err == nil {
+		err = replaceFile(f.path, content)
+	}
+	if err != nil {
+		log.Printf("Warning: failed to update %s: %v", f.path, err)
+	}
+}
+
+// newProgressFile keeps a JSON list of every operation's latest progress in path
+func newProgressFile(path string) ProgressSink {
+	return &snapshotFile{state: NewProgressState(), path: path, render: func(events []ProgressEvent) ([]byte, error) {
+		return json.MarshalIndent(events, "", "  ")
+	}}
+}
+
+// newProgressMetrics keeps Prometheus gauges in path, in the text format read by
+// node_exporter's textfile collector
+func newProgressMetrics(path string) ProgressSink {
+	return &snapshotFile{state: NewProgressState(), path: path, render: func(events []ProgressEvent) ([]byte, error) {
+		return []byte(renderProgressMetrics(events)), nil
+	}}
+}
+
+// metricLabel escapes a Prometheus label value
+func metricLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// renderProgressMetrics renders progress events as Prometheus gauges
+func renderProgressMetrics(events []ProgressEvent) string {
+	gauges := []struct {
+		name, help string
+		value      func(ProgressEvent) float64
+	}{
+		{"pg_restore_fdw_progress_elapsed_seconds", "Seconds the operation has been running.", func(e ProgressEvent) float64 { return e.UpdatedAt.Sub(e.StartedAt).Seconds() }},
+		{"pg_restore_fdw_progress_bytes_done", "Bytes the operation has processed, when known.", func(e ProgressEvent) float64 { return float64(e.BytesDone) }},
+		{"pg_restore_fdw_progress_bytes_total", "Bytes the operation will process, when known.", func(e ProgressEvent) float64 { return float64(e.BytesTotal) }},
+		{"pg_restore_fdw_progress_finished", "1 once the operation has finished.", func(e ProgressEvent) float64 {
+			if e.Finished {
+				return 1
+			}
+			return 0
+		}},
+	}
+	var b strings.Builder
+	for _, g := range gauges {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+		for _, e := range events {
+			fmt.Fprintf(&b, "%s{operation=\"%s\"} %g\n", g.name, metricLabel(e.Operation), g.value(e))
+		}
+	}
+	return b.String()
+}
+
+// replaceFile writes content to path atomically so readers never see a partial file
+func replaceFile(path string, content []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}
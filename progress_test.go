@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgressStateSnapshot(t *testing.T) {
+	state := NewProgressState()
+	start := time.Now()
+	state.Progress(ProgressEvent{Operation: "Restore b", StartedAt: start.Add(time.Second), Status: "one"})
+	state.Progress(ProgressEvent{Operation: "Dump a", StartedAt: start, Status: "one"})
+	state.Progress(ProgressEvent{Operation: "Restore b", StartedAt: start.Add(time.Second), Status: "two"})
+
+	events := state.Snapshot()
+	if len(events) != 2 || events[0].Operation != "Dump a" || events[1].Status != "two" {
+		t.Errorf("Snapshot() = %+v", events)
+	}
+}
+
+func TestRenderProgressMetrics(t *testing.T) {
+	start := time.Now()
+	metrics := renderProgressMetrics([]ProgressEvent{{
+		Operation: `Upload "x"`, StartedAt: start, UpdatedAt: start.Add(90 * time.Second),
+		BytesDone: 512, BytesTotal: 1024, Finished: true,
+	}})
+	for _, want := range []string{
+		"# TYPE pg_restore_fdw_progress_bytes_done gauge\n",
+		`pg_restore_fdw_progress_bytes_done{operation="Upload \"x\""} 512`,
+		`pg_restore_fdw_progress_elapsed_seconds{operation="Upload \"x\""} 90`,
+		`pg_restore_fdw_progress_finished{operation="Upload \"x\""} 1`,
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("metrics missing %q:\n%s", want, metrics)
+		}
+	}
+}
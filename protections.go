@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Protections guards against destructive actions on the wrong databases
+type Protections struct {
+	// DenyPatterns are glob patterns (e.g. "prod*", "*_primary") of databases that may never be dropped
+	DenyPatterns []string `json:"deny_patterns"`
+	// ReadOnly names source connections, e.g. "source_moodys", whose databases may never
+	// be dropped or created: a database on the same host and port with the same name
+	ReadOnly []string `json:"read_only"`
+	// DenyEnvironments are environment tags, e.g. "prod", of databases that may never be dropped
+	DenyEnvironments []string `json:"deny_environments"`
+	// RequireConfirmation demands --yes-i-mean-it or an interactive confirmation before each
+	// DROP the operator asked for; default true, false opts out
+	RequireConfirmation *bool `json:"require_confirmation"`
+	// Confirmed is set from --yes-i-mean-it and satisfies RequireConfirmation
+	Confirmed bool `json:"-"`
+
+	// readOnly are the resolved ReadOnly connections by name
+	readOnly map[string]DBConfig
+}
+
+// resolveReadOnly looks up the ReadOnly connections among the source connections
+func (p *Protections) resolveReadOnly(sources map[string]DBConfig) error {
+	p.readOnly = make(map[string]DBConfig, len(p.ReadOnly))
+	for _, name := range p.ReadOnly {
+		config, ok := sources[name]
+		if !ok {
+			names := make([]string, 0, len(sources))
+			for source := range sources {
+				names = append(names, source)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("read_only names %q, which is not a source connection; use %s", name, strings.Join(names, ", "))
+		}
+		p.readOnly[name] = config
+	}
+	return nil
+}
+
+// readOnlySource returns the read-only source connection config is the database of
+func (p *Protections) readOnlySource(config DBConfig) (string, bool) {
+	for name, source := range p.readOnly {
+		if sameDatabase(source, config) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// sameDatabase reports whether two configs connect to the same database of the same server
+func sameDatabase(a, b DBConfig) bool {
+	port := func(c DBConfig) string {
+		if c.Port == "" {
+			return "5432"
+		}
+		return c.Port
+	}
+	return strings.EqualFold(a.Host, b.Host) && port(a) == port(b) && a.DBName == b.DBName
+}
+
+// activeProtections is consulted before every CREATE or DROP DATABASE; nil disables checks
+var activeProtections *Protections
+
+// SetProtections installs the protections used by CreateDatabase and DeleteDatabases
+func SetProtections(p *Protections) {
+	activeProtections = p
+}
+
+// confirmationInput is where confirmation prompts read the operator's answer. It is shared
+// by every prompt of a run, so answers piped in together are read one line at a time.
+var confirmationInput = bufio.NewReader(os.Stdin)
+
+// requiresConfirmation reports whether drops must be confirmed, which they are unless the
+// config opts out
+func (p *Protections) requiresConfirmation() bool {
+	return p.RequireConfirmation == nil || *p.RequireConfirmation
+}
+
+// matchesAny reports whether name matches one of the glob patterns
+func matchesAny(patterns []string, name string) (string, bool) {
+	for _, pattern := range patterns {
+		if ok, err := path.Match(pattern, name); err == nil && ok {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// checkCreate verifies a database may be created
+func (p *Protections) checkCreate(config DBConfig) error {
+	if p == nil {
+		return nil
+	}
+	if source, ok := p.readOnlySource(config); ok {
+		return fmt.Errorf("refusing to create database %s on %s: it is the read-only %s", config.DBName, config.Host, source)
+	}
+	return nil
+}
+
+// checkDrop verifies a database may be dropped, prompting for confirmation when required.
+// Automatic drops, which the tool makes on its own such as the previous database after a
+// blue/green cutover, are checked against the deny rules but never prompt. A forced drop
+// on another host terminates the sessions there, so it is confirmed even when plain
+// drops aren't; the one prompt or --yes-i-mean-it covers both.
+func (p *Protections) checkDrop(config DBConfig, opts DropOptions) error {
+	forceRemote := opts.Force && !isLocalHost(config.Host)
+	if p == nil {
+		p = &Protections{RequireConfirmation: &forceRemote}
+	}
+	if pattern, ok := matchesAny(p.DenyPatterns, config.DBName); ok {
+		return fmt.Errorf("refusing to drop database %s: it is protected (matches %q)", config.DBName, pattern)
+	}
+	if source, ok := p.readOnlySource(config); ok {
+		return fmt.Errorf("refusing to drop database %s on %s: it is the read-only %s", config.DBName, config.Host, source)
+	}
+	for _, environment := range p.DenyEnvironments {
+		if config.Environment == environment {
+			return fmt.Errorf("refusing to drop database %s: it is tagged %s", config.DBName, environment)
+		}
+	}
+	if (p.requiresConfirmation() || forceRemote) && !p.Confirmed && !opts.Automatic {
+		token := "drop " + config.DBName
+		message := fmt.Sprintf("About to drop database %s on %s.", config.DBName, config.Host)
+		if forceRemote {
+			message = fmt.Sprintf("About to force-drop database %s on %s, terminating all sessions connected to it.", config.DBName, config.Host)
+		}
+		if err := promptConfirmation(message, token); err != nil {
+			return fmt.Errorf("drop of %s not confirmed (use --yes-i-mean-it for non-interactive runs): %w",
+				config.DBName, err)
+		}
+		log.Printf("Drop of %s confirmed interactively", config.DBName)
+	}
+	return nil
+}
+
+// promptConfirmation asks the operator to type the expected token on stdin
+func promptConfirmation(message, token string) error {
+	fmt.Fprintln(os.Stderr, message)
+	fmt.Fprintf(os.Stderr, "Type %q to confirm: ", token)
+
+	answer, err := confirmationInput.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read confirmation: %w", err)
+	}
+	if strings.TrimSpace(answer) != token {
+		return fmt.Errorf("confirmation did not match %q", token)
+	}
+	return nil
+}
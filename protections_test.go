@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestMatchesAny(t *testing.T) {
+	patterns := []string{"prod*", "*_primary"}
+	tests := map[string]bool{
+		"prod":           true,
+		"production":     true,
+		"tenant_primary": true,
+		"tenant":         false,
+		"staging_prod":   false,
+	}
+	for name, want := range tests {
+		if _, got := matchesAny(patterns, name); got != want {
+			t.Errorf("matchesAny(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestProtectionsReadOnly(t *testing.T) {
+	optOut := false
+	source := DBConfig{Host: "prod-db", Port: "5432", DBName: "moodys"}
+	p := &Protections{ReadOnly: []string{"source_moodys"}, RequireConfirmation: &optOut}
+	if err := p.resolveReadOnly(map[string]DBConfig{"source_moodys": source}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.checkCreate(DBConfig{Host: "PROD-DB", DBName: "moodys"}); err == nil {
+		t.Error("checkCreate allowed the read-only source")
+	}
+	if err := p.checkDrop(source, DropOptions{}); err == nil {
+		t.Error("checkDrop allowed the read-only source")
+	}
+	// The destination of the same name on the QA host is fair game
+	qa := DBConfig{Host: "qa-db", Port: "5432", DBName: "moodys"}
+	if err := p.checkCreate(qa); err != nil {
+		t.Errorf("checkCreate on another host: %v", err)
+	}
+	if err := p.checkDrop(qa, DropOptions{}); err != nil {
+		t.Errorf("checkDrop on another host: %v", err)
+	}
+	if err := p.checkDrop(DBConfig{Host: "prod-db", Port: "5433", DBName: "moodys"}, DropOptions{}); err != nil {
+		t.Errorf("checkDrop on another port: %v", err)
+	}
+
+	if err := (&Protections{ReadOnly: []string{"moodys"}}).resolveReadOnly(map[string]DBConfig{"source_moodys": source}); err == nil {
+		t.Error("a bare database name resolved as a source connection")
+	}
+}
+
+func TestProtectionsDeny(t *testing.T) {
+	p := &Protections{DenyPatterns: []string{"prod*"}, DenyEnvironments: []string{"prod"}, Confirmed: true}
+	if err := p.checkDrop(DBConfig{DBName: "production"}, DropOptions{}); err == nil {
+		t.Error("checkDrop allowed a denied pattern")
+	}
+	if err := p.checkDrop(DBConfig{DBName: "tenant", Environment: "prod"}, DropOptions{}); err == nil {
+		t.Error("checkDrop allowed a denied environment")
+	}
+	if err := p.checkDrop(DBConfig{DBName: "tenant", Environment: "staging"}, DropOptions{}); err != nil {
+		t.Errorf("checkDrop: %v", err)
+	}
+}
+
+func TestProtectionsConfirmation(t *testing.T) {
+	defer func(input *bufio.Reader) { confirmationInput = input }(confirmationInput)
+	optOut := false
+	tests := []struct {
+		name    string
+		p       *Protections
+		answer  string
+		wantErr bool
+	}{
+		{"nil protections", nil, "", false},
+		{"default requires confirmation", &Protections{}, "", true},
+		{"wrong answer", &Protections{}, "yes\n", true},
+		{"typed confirmation", &Protections{}, "drop tenant\n", false},
+		{"yes-i-mean-it", &Protections{Confirmed: true}, "", false},
+		{"opted out", &Protections{RequireConfirmation: &optOut}, "", false},
+	}
+	for _, tt := range tests {
+		confirmationInput = bufio.NewReader(strings.NewReader(tt.answer))
+		if err := tt.p.checkDrop(DBConfig{DBName: "tenant"}, DropOptions{}); (err != nil) != tt.wantErr {
+			t.Errorf("%s: checkDrop error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestProtectionsAutomaticDrop(t *testing.T) {
+	defer func(input *bufio.Reader) { confirmationInput = input }(confirmationInput)
+	confirmationInput = bufio.NewReader(strings.NewReader(""))
+
+	// The tool's own drops don't prompt, but the deny rules still hold
+	p := &Protections{DenyPatterns: []string{"prod*"}}
+	if err := p.checkDrop(DBConfig{DBName: "tenant_previous"}, DropOptions{Automatic: true}); err != nil {
+		t.Errorf("automatic drop prompted: %v", err)
+	}
+	if err := p.checkDrop(DBConfig{DBName: "production"}, DropOptions{Automatic: true}); err == nil {
+		t.Error("automatic drop bypassed a denied pattern")
+	}
+}
+
+func TestPromptConfirmationSequence(t *testing.T) {
+	defer func(input *bufio.Reader) { confirmationInput = input }(confirmationInput)
+	// Answers piped in together must each reach their own prompt
+	confirmationInput = bufio.NewReader(strings.NewReader("drop moodys\ndrop tenant\n"))
+
+	p := &Protections{}
+	for _, name := range []string{"moodys", "tenant"} {
+		if err := p.checkDrop(DBConfig{DBName: name}, DropOptions{}); err != nil {
+			t.Errorf("drop of %s: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ProviderConfig adapts restores to a managed PostgreSQL service at the destination
+type ProviderConfig struct {
+	// Name is rds, aurora, or cloudsql
+	Name string `json:"name"`
+	// Extensions adds to the extensions the provider lets its admin role create
+	Extensions []string `json:"extensions"`
+}
+
+// providerProfile describes what a managed service withholds from its admin role
+type providerProfile struct {
+	// AdminRole stands in for a superuser
+	AdminRole string
+	// ExtensionsSetting names a setting listing the extensions the admin role may create
+	ExtensionsSetting string
+	// Extensions the admin role may create when the provider has no such setting
+	Extensions []string
+	// EventTriggers reports whether the admin role may create event triggers
+	EventTriggers bool
+	// Tablespaces reports whether objects may be placed in tablespaces
+	Tablespaces bool
+}
+
+// cloudSQLExtensions are the extensions Cloud SQL lets cloudsqlsuperuser create
+var cloudSQLExtensions = []string{
+	"btree_gin", "btree_gist", "citext", "cube", "dblink", "dict_int", "dict_xsyn",
+	"earthdistance", "fuzzystrmatch", "hstore", "intagg", "intarray", "isn", "lo", "ltree",
+	"pg_buffercache", "pg_cron", "pg_partman", "pg_prewarm", "pg_repack", "pg_stat_statements",
+	"pg_trgm", "pgaudit", "pgcrypto", "pglogical", "pgrowlocks", "pgstattuple", "plpgsql",
+	"postgis", "postgis_raster", "postgis_topology", "postgres_fdw", "sslinfo", "tablefunc",
+	"tsm_system_rows", "tsm_system_time", "unaccent", "uuid-ossp", "vector",
+}
+
+var providerProfiles = map[string]providerProfile{
+	"rds":      {AdminRole: "rds_superuser", ExtensionsSetting: "rds.extensions", EventTriggers: true, Tablespaces: true},
+	"aurora":   {AdminRole: "rds_superuser", ExtensionsSetting: "rds.extensions", EventTriggers: true},
+	"cloudsql": {AdminRole: "cloudsqlsuperuser", Extensions: cloudSQLExtensions, Tablespaces: true},
+}
+
+func (p *ProviderConfig) validate() error {
+	if _, ok := providerProfiles[p.Name]; !ok {
+		var names []string
+		for name := range providerProfiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown provider %q: use one of %s", p.Name, strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// profile returns the provider's profile; nil for a self-managed destination
+func (p *ProviderConfig) profile() *providerProfile {
+	if p == nil {
+		return nil
+	}
+	profile := providerProfiles[p.Name]
+	return &profile
+}
+
+// allowedExtensions lists the extensions the provider lets its admin role create on
+// the destination, read from the provider's setting when it has one
+func (p *ProviderConfig) allowedExtensions(config DBConfig) ([]string, error) {
+	profile := p.profile()
+	allowed := append(append([]string(nil), profile.Extensions...), p.Extensions...)
+	if profile.ExtensionsSetting == "" {
+		return allowed, nil
+	}
+	rows, err := queryRows(config, fmt.Sprintf("SELECT current_setting(%s, true);", quoteLiteral(profile.ExtensionsSetting)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", profile.ExtensionsSetting, err)
+	}
+	if len(rows) == 1 && len(rows[0]) == 1 {
+		for _, name := range strings.Split(rows[0][0], ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				allowed = append(allowed, name)
+			}
+		}
+	}
+	return allowed, nil
+}
+
+// providerRules rewrite pre-data for what the provider doesn't allow: tablespaces, and
+// user mappings that skip the password, which postgres_fdw requires of non-superusers
+func (p *ProviderConfig) providerRules() []CompatRule {
+	profile := p.profile()
+	if profile == nil {
+		return nil
+	}
+	var rules []CompatRule
+	if !profile.Tablespaces {
+		rules = append(rules, CompatRule{Name: "no_tablespaces", Pattern: `^SET default_tablespace = .+;$`, Replace: "SET default_tablespace = '';"})
+	}
+	// Only superusers may waive the password, which is the default anyway
+	return append(rules,
+		CompatRule{Name: "password_required", Pattern: `\bpassword_required 'false'`, Replace: "password_required 'true'"},
+		CompatRule{Name: "mapping_without_password", Pattern: `^CREATE USER MAPPING FOR .* SERVER [^ ]+;$`, Warn: true},
+	)
+}
+
+// stripSettings removes "-c name=value" options for the given settings from PGOPTIONS
+// and returns the settings removed
+func stripSettings(options string, names []string) (string, []string) {
+	blocked := make(map[string]bool)
+	for _, name := range names {
+		blocked[name] = true
+	}
+	fields := strings.Fields(options)
+	var kept, removed []string
+	for i := 0; i < len(fields); i++ {
+		setting := fields[i]
+		if setting == "-c" && i+1 < len(fields) {
+			i++
+			setting = fields[i]
+		} else if !strings.HasPrefix(setting, "-c") {
+			kept = append(kept, setting)
+			continue
+		} else {
+			setting = strings.TrimPrefix(setting, "-c")
+		}
+		name, _, _ := strings.Cut(setting, "=")
+		if blocked[strings.ToLower(name)] {
+			removed = append(removed, name)
+			continue
+		}
+		kept = append(kept, "-c", setting)
+	}
+	return strings.Join(kept, " "), removed
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStripSettings(t *testing.T) {
+	options, removed := stripSettings("-c lock_timeout=1min -c session_replication_role=replica -cwork_mem=64MB", []string{"session_replication_role"})
+	if options != "-c lock_timeout=1min -c work_mem=64MB" {
+		t.Errorf("options = %q", options)
+	}
+	if !reflect.DeepEqual(removed, []string{"session_replication_role"}) {
+		t.Errorf("removed = %v", removed)
+	}
+}
+
+func TestProviderRules(t *testing.T) {
+	if err := (&ProviderConfig{Name: "heroku"}).validate(); err == nil {
+		t.Error("validate accepted an unknown provider")
+	}
+	var none *ProviderConfig
+	if rules := none.providerRules(); rules != nil {
+		t.Errorf("self-managed destinations need no rules, got %v", rules)
+	}
+
+	layer := newCompatLayer(nil, 16, 16, (&ProviderConfig{Name: "aurora"}).providerRules()...)
+	script := "SET default_tablespace = fast_ssd;\nCREATE USER MAPPING FOR app SERVER moodys_server OPTIONS (\n    password_required 'false',\n    \"user\" 'app'\n);\n"
+	var out bytes.Buffer
+	if err := layer.rewriteScript(strings.NewReader(script), &out); err != nil {
+		t.Fatal(err)
+	}
+	want := "SET default_tablespace = '';\nCREATE USER MAPPING FOR app SERVER moodys_server OPTIONS (\n    password_required 'true',\n    \"user\" 'app'\n);\n"
+	if out.String() != want {
+		t.Errorf("rewritten script = %q, want %q", out.String(), want)
+	}
+}
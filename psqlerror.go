@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// psqlScriptErrorRe matches psql's "psql:<file>:<line>: ERROR:  <message>" output
+var psqlScriptErrorRe = regexp.MustCompile(`(?m)^psql:(.+?):(\d+): ERROR:\s+(.*)$`)
+
+// ScriptError locates the first failing statement of a plain-text script
+type ScriptError struct {
+	File      string
+	Line      int
+	Message   string
+	Statement string
+}
+
+func (e *ScriptError) Error() string {
+	if e.Statement == "" {
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Message)
+	}
+	return fmt.Sprintf("%s:%d: %s\nStatement:\n%s", e.File, e.Line, e.Message, e.Statement)
+}
+
+// parseScriptError finds the first ERROR reported by psql for a script
+func parseScriptError(output string) *ScriptError {
+	match := psqlScriptErrorRe.FindStringSubmatch(output)
+	if match == nil {
+		return nil
+	}
+	line, _ := strconv.Atoi(match[2])
+	return &ScriptError{File: match[1], Line: line, Message: strings.TrimSpace(match[3])}
+}
+
+// statementAt returns the statement ending at the given 1-based line, walking back to the
+// previous statement terminator
+func statementAt(lines []string, lineNum int) string {
+	if lineNum < 1 || lineNum > len(lines) {
+		return ""
+	}
+
+	start := lineNum - 1
+	for start > 0 {
+		prev := strings.TrimSpace(lines[start-1])
+		if strings.HasSuffix(prev, ";") || prev == "" || strings.HasPrefix(prev, "--") {
+			break
+		}
+		start--
+	}
+	return strings.Join(lines[start:lineNum], "\n")
+}
+
+// describeScriptError enriches psql output with the failing statement read from the script
+func describeScriptError(output string) *ScriptError {
+	scriptErr := parseScriptError(output)
+	if scriptErr == nil {
+		return nil
+	}
+
+	f, err := os.Open(scriptErr.File)
+	if err != nil {
+		return scriptErr
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for scanner.Scan() && len(lines) < scriptErr.Line {
+		lines = append(lines, scanner.Text())
+	}
+	scriptErr.Statement = statementAt(lines, scriptErr.Line)
+	return scriptErr
+}
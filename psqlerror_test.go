@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestParseScriptError(t *testing.T) {
+	output := `SET
+CREATE EXTENSION
+psql:dump_test/tenant_pre-data.sql:41: ERROR:  foreign-data wrapper "postgres_fdw" does not exist
+psql:dump_test/tenant_pre-data.sql:52: ERROR:  server "moodys_server" does not exist
+`
+	scriptErr := parseScriptError(output)
+	if scriptErr == nil {
+		t.Fatal("expected an error to be parsed")
+	}
+	if scriptErr.File != "dump_test/tenant_pre-data.sql" || scriptErr.Line != 41 {
+		t.Errorf("unexpected location %s:%d", scriptErr.File, scriptErr.Line)
+	}
+	if scriptErr.Message != `foreign-data wrapper "postgres_fdw" does not exist` {
+		t.Errorf("unexpected message %q", scriptErr.Message)
+	}
+
+	if parseScriptError("SET\nCREATE TABLE\n") != nil {
+		t.Error("expected no error for clean output")
+	}
+}
+
+func TestStatementAt(t *testing.T) {
+	lines := []string{
+		"SET lock_timeout = 0;",
+		"",
+		"CREATE SERVER moodys_server FOREIGN DATA WRAPPER postgres_fdw OPTIONS (",
+		"    dbname 'moodys',",
+		"    host 'localhost'",
+		");",
+	}
+	want := "CREATE SERVER moodys_server FOREIGN DATA WRAPPER postgres_fdw OPTIONS (\n    dbname 'moodys',\n    host 'localhost'\n);"
+	if got := statementAt(lines, 6); got != want {
+		t.Errorf("statementAt = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// QuarantinedTable records a table whose data restore failed in per-table mode
+type QuarantinedTable struct {
+	Database string    `json:"database"`
+	DumpFile string    `json:"dump_file"`
+	Table    string    `json:"table"`
+	TOCLine  string    `json:"toc_line"`
+	Error    string    `json:"error"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// RunState is the persisted state of a restore run, used by retry-failed
+type RunState struct {
+	mu sync.Mutex
+
+	RunID       string             `json:"run_id"`
+	InputDir    string             `json:"input_dir"`
+	Quarantined []QuarantinedTable `json:"quarantined"`
+}
+
+// NewRunState creates the state for a new run, generating an ID when none is given
+func NewRunState(runID, inputDir string) *RunState {
+	if runID == "" {
+		runID = time.Now().Format("20060102-150405")
+	}
+	return &RunState{RunID: runID, InputDir: inputDir}
+}
+
+// runStatePath returns where the state for a run is stored
+func runStatePath(inputDir, runID string) string {
+	return filepath.Join(inputDir, "runs", runID+".json")
+}
+
+// Save writes the run state under the input directory
+func (s *RunState) Save() error {
+	path := runStatePath(s.InputDir, s.RunID)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create run state directory: %w", err)
+	}
+
+	content, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode run state: %w", err)
+	}
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		return fmt.Errorf("failed to write run state: %w", err)
+	}
+
+	log.Printf("Run state saved to %s", path)
+	return nil
+}
+
+// quarantine records a failed table; sections of different databases restore concurrently
+func (s *RunState) quarantine(q QuarantinedTable) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Quarantined = append(s.Quarantined, q)
+}
+
+// LoadRunState reads the state of a previous run
+func LoadRunState(inputDir, runID string) (*RunState, error) {
+	content, err := os.ReadFile(runStatePath(inputDir, runID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state for run %s: %w", runID, err)
+	}
+
+	state := &RunState{}
+	if err := json.Unmarshal(content, state); err != nil {
+		return nil, fmt.Errorf("failed to parse state for run %s: %w", runID, err)
+	}
+	return state, nil
+}
+
+// restoreTOCEntries restores only the given TOC entries from a custom-format dump
+func restoreTOCEntries(config DBConfig, inputFile string, entries []TOCEntry) error {
+	listFile, err := os.CreateTemp("", "pg_restore_fdw_*.list")
+	if err != nil {
+		return fmt.Errorf("failed to create TOC list file: %w", err)
+	}
+	listFile.Close()
+	defer os.Remove(listFile.Name())
+
+	if err := writeTOCList(listFile.Name(), entries); err != nil {
+		return err
+	}
+
+	cmd := newPgRestoreCmd(config, "-L", listFile.Name(), inputFile)
+	step := stepName("restore", inputFile)
+	if len(entries) == 1 {
+		step += "_" + strings.ReplaceAll(entries[0].QualifiedName(), " ", "_")
+	}
+	if output, err := runStreaming(cmd, step, nil); err != nil {
+		return fmt.Errorf("failed to restore TOC entries: %w\nOutput: %s", err, output)
+	}
+	return nil
+}
+
+// restoreDataPerTable restores each table's data separately. Tables that fail are
+// quarantined in the run state and the remaining tables continue restoring.
+func restoreDataPerTable(config DBConfig, inputFile string, state *RunState, opts RestoreOptions) error {
+	entries, err := listTOC(inputFile)
+	if err != nil {
+		return err
+	}
+
+	var tables, others []TOCEntry
+	for _, entry := range entries {
+		if entry.Desc == "TABLE DATA" {
+			tables = append(tables, entry)
+		} else {
+			others = append(others, entry)
+		}
+	}
+
+	var loader *CopyLoader
+	if opts.MaxBadRows > 0 {
+		loader = NewCopyLoader(config, opts.MaxBadRows, filepath.Join(state.InputDir, "rejected"))
+	}
+
+	monitor := NewProgressMonitor(fmt.Sprintf("Restore %s", filepath.Base(inputFile)))
+	quarantined := 0
+	for i, table := range tables {
+		monitor.Update(fmt.Sprintf("Restoring table %d/%d: %s", i+1, len(tables), table.QualifiedName()))
+
+		var err error
+		if loader != nil {
+			_, _, err = loader.LoadTable(inputFile, table)
+		} else {
+			err = restoreTOCEntries(config, inputFile, []TOCEntry{table})
+		}
+		if err != nil {
+			log.Printf("Quarantining table %s in %s: %v", table.QualifiedName(), config.DBName, err)
+			state.quarantine(QuarantinedTable{
+				Database: config.DBName,
+				DumpFile: filepath.Base(inputFile),
+				Table:    table.QualifiedName(),
+				TOCLine:  table.Line,
+				Error:    err.Error(),
+				FailedAt: time.Now(),
+			})
+			quarantined++
+		}
+	}
+
+	// Sequence values and large objects are restored together after the tables
+	if len(others) > 0 {
+		if err := restoreTOCEntries(config, inputFile, others); err != nil {
+			return fmt.Errorf("failed to restore non-table data: %w", err)
+		}
+	}
+
+	log.Printf("Per-table restore of %s finished: %d tables, %d quarantined",
+		config.DBName, len(tables), quarantined)
+	return nil
+}
+
+// RetryFailedTables re-attempts the quarantined tables of a previous run. Each table is
+// emptied first, since a failed load can leave part of its rows committed. Tables that
+// succeed are removed from the quarantine; the state is saved either way.
+func RetryFailedTables(state *RunState, codec artifactCodec, configs ...DBConfig) error {
+	artifacts := newArtifactResolver(state.InputDir, codec)
+	defer artifacts.Cleanup()
+
+	byName := make(map[string]DBConfig)
+	for _, config := range configs {
+		byName[config.DBName] = config
+	}
+
+	var remaining []QuarantinedTable
+	for _, q := range state.Quarantined {
+		config, ok := byName[q.Database]
+		if !ok {
+			return fmt.Errorf("no configuration for database %s of quarantined table %s", q.Database, q.Table)
+		}
+
+		entry, ok := parseTOCLine(q.TOCLine)
+		if !ok {
+			return fmt.Errorf("invalid TOC line recorded for %s: %q", q.Table, q.TOCLine)
+		}
+
+		dumpFile, err := artifacts.Resolve(q.DumpFile)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Retrying quarantined table %s in %s", q.Table, q.Database)
+		err = truncateTable(config, entry)
+		if err == nil {
+			err = restoreTOCEntries(config, dumpFile, []TOCEntry{entry})
+		}
+		if err != nil {
+			log.Printf("Table %s still failing: %v", q.Table, err)
+			q.Error = err.Error()
+			q.FailedAt = time.Now()
+			remaining = append(remaining, q)
+			continue
+		}
+		log.Printf("Table %s restored successfully", q.Table)
+	}
+
+	state.Quarantined = remaining
+	if err := state.Save(); err != nil {
+		return err
+	}
+
+	if len(remaining) > 0 {
+		return fmt.Errorf("%d tables remain quarantined in run %s", len(remaining), state.RunID)
+	}
+	return nil
+}
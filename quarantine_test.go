@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRetryFailedTablesTruncatesFirst(t *testing.T) {
+	// Fake client commands record what they were asked to run
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls.log")
+	for _, name := range []string{"psql", "pg_restore"} {
+		script := "#!/bin/sh\necho \"" + name + " $*\" >> " + calls + "\n"
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir)
+
+	inputDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(inputDir, "tenant_data.dump"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	state := NewRunState("retry", inputDir)
+	state.Quarantined = []QuarantinedTable{{
+		Database: "tenant_dest",
+		DumpFile: "tenant_data.dump",
+		Table:    "public.accounts",
+		TOCLine:  "3261; 0 16390 TABLE DATA public accounts postgres",
+	}}
+
+	if err := RetryFailedTables(state, nil, DBConfig{DBName: "tenant_dest"}); err != nil {
+		t.Fatalf("RetryFailedTables: %v", err)
+	}
+	if len(state.Quarantined) != 0 {
+		t.Errorf("expected the table to leave the quarantine, got %+v", state.Quarantined)
+	}
+
+	content, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "psql ") || !strings.Contains(lines[0], `TRUNCATE TABLE ONLY "public"."accounts";`) {
+		t.Fatalf("expected the table truncated before it is restored, got %q", lines)
+	}
+	if !strings.HasPrefix(lines[1], "pg_restore ") {
+		t.Errorf("expected pg_restore after the truncate, got %q", lines[1])
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// QueryCheck is a named query run against a restored database with a known answer
+type QueryCheck struct {
+	Name string `json:"name"`
+	// Database is "moodys" or "tenant"; default tenant
+	Database string `json:"database"`
+	SQL      string `json:"sql"`
+	// Expect is the expected unaligned output, one line per row with fields separated by
+	// "|". Empty expects no rows.
+	Expect string `json:"expect"`
+}
+
+// QueryCheckResult is the outcome of a query check
+type QueryCheckResult struct {
+	Name     string `json:"name"`
+	Database string `json:"database"`
+	Status   string `json:"status"`
+	Expected string `json:"expected,omitempty"`
+	Got      string `json:"got,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// database returns the database the check runs against
+func (c QueryCheck) database() string {
+	if c.Database == "" {
+		return "tenant"
+	}
+	return c.Database
+}
+
+// validateQueryPack checks every query names a known database
+func validateQueryPack(checks []QueryCheck) error {
+	seen := make(map[string]bool)
+	for _, c := range checks {
+		if c.Name == "" || strings.TrimSpace(c.SQL) == "" {
+			return fmt.Errorf("query checks need a name and sql")
+		}
+		if seen[c.Name] {
+			return fmt.Errorf("query check %q is defined twice", c.Name)
+		}
+		seen[c.Name] = true
+		if db := c.database(); db != "moodys" && db != "tenant" {
+			return fmt.Errorf("query check %q: unknown database %q", c.Name, db)
+		}
+	}
+	return nil
+}
+
+// normalizeRows trims surrounding whitespace from the output and each of its lines
+func normalizeRows(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// runQueryCheck runs a check in a read-only transaction and compares its rows
+func runQueryCheck(config DBConfig, check QueryCheck) QueryCheckResult {
+	result := QueryCheckResult{Name: check.Name, Database: config.DBName, Expected: normalizeRows(check.Expect)}
+	cmd := newPsqlCmd(config, "-X", "-q", "-t", "-A", "-v", "ON_ERROR_STOP=1")
+	cmd.Stdin = strings.NewReader("BEGIN READ ONLY;\n" + strings.TrimSpace(check.SQL) + "\n;\nROLLBACK;\n")
+	output, err := cmd.CombinedOutput()
+	result.Got = normalizeRows(string(output))
+	switch {
+	case err != nil:
+		result.Status, result.Error = "failed", fmt.Sprintf("%v: %s", err, result.Got)
+		result.Got = ""
+	case result.Got != result.Expected:
+		result.Status = "failed"
+	default:
+		result.Status = "passed"
+	}
+	return result
+}
+
+// RunQueryPack runs every check against its restored database, records the results in
+// the run report, and fails when any check did not return its expected rows
+func RunQueryPack(checks []QueryCheck, dests map[string]DBConfig) error {
+	var failed []string
+	for _, check := range checks {
+		config, ok := dests[check.database()]
+		if !ok {
+			debugf("Skipping query check %q: %s was not restored", check.Name, check.database())
+			continue
+		}
+		result := runQueryCheck(config, check)
+		activeReport.recordQueryCheck(result)
+		switch {
+		case result.Error != "":
+			failed = append(failed, fmt.Sprintf("%s: %s", check.Name, result.Error))
+		case result.Status == "failed":
+			failed = append(failed, fmt.Sprintf("%s: expected %q, got %q", check.Name, result.Expected, result.Got))
+		default:
+			log.Printf("Query check %q passed", check.Name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d query checks failed:\n%s", len(failed), len(checks), strings.Join(failed, "\n"))
+	}
+	return nil
+}
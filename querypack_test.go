@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestValidateQueryPack(t *testing.T) {
+	valid := []QueryCheck{
+		{Name: "no_future_transactions", SQL: "SELECT id FROM customer_transactions WHERE created_at > now()"},
+		{Name: "companies", Database: "moodys", SQL: "SELECT count(*) > 0 FROM companies", Expect: "t"},
+	}
+	if err := validateQueryPack(valid); err != nil {
+		t.Errorf("valid pack rejected: %v", err)
+	}
+	for _, pack := range [][]QueryCheck{
+		{{Name: "a", SQL: "SELECT 1"}, {Name: "a", SQL: "SELECT 2"}},
+		{{Name: "a", Database: "other", SQL: "SELECT 1"}},
+		{{Name: "a"}},
+	} {
+		if err := validateQueryPack(pack); err == nil {
+			t.Errorf("expected %+v to be rejected", pack)
+		}
+	}
+}
+
+func TestNormalizeRows(t *testing.T) {
+	if got := normalizeRows("  1|a \n2|b\n\n"); got != "1|a\n2|b" {
+		t.Errorf("got %q", got)
+	}
+	if got := normalizeRows("\n"); got != "" {
+		t.Errorf("empty output normalized to %q", got)
+	}
+}
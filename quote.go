@@ -0,0 +1,35 @@
+package main
+
+import "strings"
+
+// quoteIdent quotes a SQL identifier such as a database, role, or server name,
+// preserving case and escaping embedded double quotes
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// quoteLiteral quotes a SQL string literal, escaping embedded single quotes.
+// Backslashes switch to the escape-string (E'...') form like PostgreSQL's quote_literal.
+func quoteLiteral(value string) string {
+	escaped := strings.ReplaceAll(value, `'`, `''`)
+	if strings.Contains(escaped, `\`) {
+		return `E'` + strings.ReplaceAll(escaped, `\`, `\\`) + `'`
+	}
+	return `'` + escaped + `'`
+}
+
+// dumpedLiteral quotes a string literal the way pg_dump writes it under
+// standard_conforming_strings, which its scripts turn on: embedded single quotes are
+// doubled and backslashes are left as they are
+func dumpedLiteral(value string) string {
+	return `'` + strings.ReplaceAll(value, `'`, `''`) + `'`
+}
+
+// quoteQualifiedName quotes each part of a dotted name such as schema.table
+func quoteQualifiedName(name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = quoteIdent(part)
+	}
+	return strings.Join(parts, ".")
+}
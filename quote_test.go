@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestQuoteIdent(t *testing.T) {
+	tests := map[string]string{
+		"tenant":           `"tenant"`,
+		"Tenant-Staging":   `"Tenant-Staging"`,
+		`evil"; DROP x; -`: `"evil""; DROP x; -"`,
+	}
+	for in, want := range tests {
+		if got := quoteIdent(in); got != want {
+			t.Errorf("quoteIdent(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestQuoteLiteral(t *testing.T) {
+	tests := map[string]string{
+		"localhost":  `'localhost'`,
+		"it's":       `'it''s'`,
+		`pa\ss'word`: `E'pa\\ss''word'`,
+	}
+	for in, want := range tests {
+		if got := quoteLiteral(in); got != want {
+			t.Errorf("quoteLiteral(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestDumpedLiteral(t *testing.T) {
+	tests := map[string]string{
+		"localhost":  `'localhost'`,
+		"it's":       `'it''s'`,
+		`pa\ss'word`: `'pa\ss''word'`,
+	}
+	for in, want := range tests {
+		if got := dumpedLiteral(in); got != want {
+			t.Errorf("dumpedLiteral(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
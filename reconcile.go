@@ -0,0 +1,373 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// dumpSetSetting is the database setting recording which dump set a database was
+// restored from, so reconcile can tell whether it is current
+const dumpSetSetting = "pg_restore_fdw.dump_set"
+
+// dumpSetSettingQuery reads a database's dumpSetSetting from pg_db_role_setting
+const dumpSetSettingQuery = `SELECT substr(s, length(%[2]s) + 2) FROM pg_db_role_setting r
+JOIN pg_database d ON d.oid = r.setdatabase, unnest(r.setconfig) s
+WHERE d.datname = %[1]s AND r.setrole = 0 AND s LIKE %[2]s || '=%%';`
+
+// Reconcile actions, in the order they run
+const (
+	ReconcileDump     = "dump"
+	ReconcileRestore  = "restore"
+	ReconcileRetarget = "retarget"
+)
+
+// EnvironmentSpec declares the destination environments reconcile keeps up to date
+type EnvironmentSpec struct {
+	Environments []DesiredEnvironment `json:"environments"`
+}
+
+// EnvironmentDatabases are the moodys and tenant databases of one side of an environment;
+// either may be left out
+type EnvironmentDatabases struct {
+	Moodys *DBConfig `json:"moodys"`
+	Tenant *DBConfig `json:"tenant"`
+}
+
+// DesiredEnvironment is the state one environment should be in: which databases exist,
+// the dump set they were restored from, and where the tenant's foreign server points
+type DesiredEnvironment struct {
+	Name string `json:"name"`
+	// DumpSet is the directory of the dump set to restore from; it is dumped from Source
+	// when it holds no dump set yet
+	DumpSet string               `json:"dump_set"`
+	Source  EnvironmentDatabases `json:"source"`
+	Dest    EnvironmentDatabases `json:"dest"`
+	// Server is the tenant's foreign server; empty uses the default name
+	Server string `json:"server"`
+	// FDWTarget is where the foreign server points; unset fields come from Dest.Moodys
+	FDWTarget *FDWTarget `json:"fdw_target"`
+}
+
+// ObservedDatabase is what reconcile found of a destination database
+type ObservedDatabase struct {
+	Exists bool
+	// DumpSet is the dump set the database was last restored from by reconcile
+	DumpSet string
+	// ServerDrift lists the statements that would bring the foreign server back to its target
+	ServerDrift []string
+}
+
+// ReconcileAction is one step that brings an environment to its desired state
+type ReconcileAction struct {
+	Environment string `json:"environment"`
+	Action      string `json:"action"`
+	Database    string `json:"database,omitempty"`
+	Reason      string `json:"reason"`
+}
+
+// LoadEnvironmentSpec reads and checks a spec file
+func LoadEnvironmentSpec(path string) (*EnvironmentSpec, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read environment spec: %w", err)
+	}
+	spec := &EnvironmentSpec{}
+	if err := json.Unmarshal(content, spec); err != nil {
+		return nil, fmt.Errorf("failed to parse environment spec %s: %w", path, err)
+	}
+	seen := make(map[string]bool)
+	for _, env := range spec.Environments {
+		if err := env.validate(); err != nil {
+			return nil, err
+		}
+		if seen[env.Name] {
+			return nil, fmt.Errorf("environment %s is declared twice", env.Name)
+		}
+		seen[env.Name] = true
+	}
+	return spec, nil
+}
+
+func (e DesiredEnvironment) validate() error {
+	if e.Name == "" {
+		return fmt.Errorf("environment without a name")
+	}
+	if e.DumpSet == "" {
+		return fmt.Errorf("environment %s has no dump_set", e.Name)
+	}
+	if e.Dest.Moodys == nil && e.Dest.Tenant == nil {
+		return fmt.Errorf("environment %s has no dest databases", e.Name)
+	}
+	// Restores rewrite the foreign server from the source moodys connection
+	for _, db := range []struct {
+		name         string
+		source, dest *DBConfig
+	}{{"moodys", e.Source.Moodys, e.Dest.Moodys}, {"tenant", e.Source.Tenant, e.Dest.Tenant}} {
+		if db.dest != nil && db.source == nil {
+			return fmt.Errorf("environment %s has a dest %s without a source %s", e.Name, db.name, db.name)
+		}
+	}
+	if e.Dest.Tenant != nil && e.Source.Moodys == nil {
+		return fmt.Errorf("environment %s needs source moodys to rewrite the tenant's foreign server", e.Name)
+	}
+	if e.Dest.Tenant != nil && e.Dest.Moodys == nil && e.FDWTarget == nil {
+		return fmt.Errorf("environment %s needs dest moodys or fdw_target for the tenant's foreign server", e.Name)
+	}
+	return nil
+}
+
+// databases lists the environment's destination databases in restore order
+func (e DesiredEnvironment) databases() []string {
+	var names []string
+	if e.Dest.Moodys != nil {
+		names = append(names, "moodys")
+	}
+	if e.Dest.Tenant != nil {
+		names = append(names, "tenant")
+	}
+	return names
+}
+
+func (e DesiredEnvironment) dest(name string) DBConfig {
+	if name == "moodys" && e.Dest.Moodys != nil {
+		return *e.Dest.Moodys
+	} else if name == "tenant" && e.Dest.Tenant != nil {
+		return *e.Dest.Tenant
+	}
+	return DBConfig{}
+}
+
+func (e DesiredEnvironment) source(name string) DBConfig {
+	if name == "moodys" && e.Source.Moodys != nil {
+		return *e.Source.Moodys
+	} else if name == "tenant" && e.Source.Tenant != nil {
+		return *e.Source.Tenant
+	}
+	return DBConfig{}
+}
+
+func (e DesiredEnvironment) server() string {
+	if e.Server == "" {
+		return moodysServerName
+	}
+	return e.Server
+}
+
+// fdwTarget is where the tenant's foreign server should point
+func (e DesiredEnvironment) fdwTarget() FDWTarget {
+	target := FDWTarget{}
+	if e.FDWTarget != nil {
+		target = *e.FDWTarget
+	}
+	return target.withDefaults(e.dest("moodys"))
+}
+
+// dumpSetID identifies a dump set by when it was taken; empty when the directory holds none
+func dumpSetID(dir string) (string, error) {
+	manifest, err := LoadManifest(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return manifest.CreatedAt.UTC().Format("20060102T150405Z"), nil
+}
+
+// reconcileActions compares an environment with what was observed of it and lists the
+// actions that bring it to the desired state. An empty dumpSet means the dump set is
+// yet to be taken, which makes every database out of date.
+func reconcileActions(env DesiredEnvironment, dumpSet string, observed map[string]ObservedDatabase) []ReconcileAction {
+	var actions []ReconcileAction
+	add := func(action, database, reason string) {
+		actions = append(actions, ReconcileAction{Environment: env.Name, Action: action, Database: database, Reason: reason})
+	}
+	if dumpSet == "" {
+		add(ReconcileDump, "", "no dump set in "+env.DumpSet)
+	}
+	restored := make(map[string]bool)
+	for _, name := range env.databases() {
+		db := observed[name]
+		switch {
+		case !db.Exists:
+			add(ReconcileRestore, name, env.dest(name).DBName+" doesn't exist")
+		case dumpSet == "":
+			add(ReconcileRestore, name, "restoring the new dump set")
+		case db.DumpSet == "":
+			add(ReconcileRestore, name, env.dest(name).DBName+" wasn't restored by reconcile")
+		case db.DumpSet != dumpSet:
+			add(ReconcileRestore, name, fmt.Sprintf("restored from dump set %s, want %s", db.DumpSet, dumpSet))
+		default:
+			continue
+		}
+		restored[name] = true
+	}
+	// A restore points the server at its target; otherwise fix drift in place
+	if env.Dest.Tenant != nil && !restored["tenant"] && len(observed["tenant"].ServerDrift) > 0 {
+		add(ReconcileRetarget, "tenant", fmt.Sprintf("server %s doesn't point at %s", env.server(), describeTarget(env.fdwTarget())))
+	}
+	return actions
+}
+
+// observeEnvironment reads the current state of an environment's destination databases
+func observeEnvironment(env DesiredEnvironment) (map[string]ObservedDatabase, error) {
+	observed := make(map[string]ObservedDatabase)
+	for _, name := range env.databases() {
+		config := env.dest(name)
+		var db ObservedDatabase
+		var err error
+		if db.Exists, err = databaseExists(config, config.DBName); err != nil {
+			return nil, err
+		}
+		if !db.Exists {
+			observed[name] = db
+			continue
+		}
+		rows, err := queryRows(maintenanceConfig(config), fmt.Sprintf(dumpSetSettingQuery, quoteLiteral(config.DBName), quoteLiteral(dumpSetSetting)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the dump set of %s: %w", config.DBName, err)
+		}
+		if len(rows) == 1 && len(rows[0]) == 1 {
+			db.DumpSet = rows[0][0]
+		}
+		if name == "tenant" {
+			servers, err := listForeignServers(config)
+			if err != nil {
+				return nil, err
+			}
+			db.ServerDrift = []string{fmt.Sprintf("-- server %s is missing", env.server())}
+			for _, server := range servers {
+				if server.Name == env.server() {
+					db.ServerDrift = retargetServerStatements(server, env.fdwTarget(), nil)
+				}
+			}
+		}
+		observed[name] = db
+	}
+	return observed, nil
+}
+
+// PlanReconcile observes every environment and lists what reconcile would do
+func PlanReconcile(spec *EnvironmentSpec, only string) ([]ReconcileAction, error) {
+	var actions []ReconcileAction
+	for _, env := range spec.Environments {
+		if only != "" && env.Name != only {
+			continue
+		}
+		dumpSet, err := dumpSetID(env.DumpSet)
+		if err != nil {
+			return nil, fmt.Errorf("environment %s: %w", env.Name, err)
+		}
+		observed, err := observeEnvironment(env)
+		if err != nil {
+			return nil, fmt.Errorf("environment %s: %w", env.Name, err)
+		}
+		actions = append(actions, reconcileActions(env, dumpSet, observed)...)
+	}
+	return actions, nil
+}
+
+// ReconcileOptions carry the run's settings into the dumps and restores reconcile runs
+type ReconcileOptions struct {
+	Dump    DumpOptions
+	Restore RestoreOptions
+	Drop    DropOptions
+}
+
+// reconcileDests lists the destination databases of the environments actions change
+func reconcileDests(spec *EnvironmentSpec, actions []ReconcileAction) []DBConfig {
+	var dests []DBConfig
+	for _, env := range spec.Environments {
+		for _, action := range actions {
+			if action.Environment == env.Name {
+				for _, name := range env.databases() {
+					dests = append(dests, env.dest(name))
+				}
+				break
+			}
+		}
+	}
+	return dests
+}
+
+// ApplyReconcile runs the actions PlanReconcile listed, environment by environment
+func ApplyReconcile(spec *EnvironmentSpec, actions []ReconcileAction, opts ReconcileOptions) error {
+	for _, env := range spec.Environments {
+		var mine []ReconcileAction
+		for _, action := range actions {
+			if action.Environment == env.Name {
+				mine = append(mine, action)
+			}
+		}
+		if len(mine) == 0 {
+			continue
+		}
+		if err := applyEnvironment(env, mine, opts); err != nil {
+			return fmt.Errorf("environment %s: %w", env.Name, err)
+		}
+	}
+	return nil
+}
+
+func applyEnvironment(env DesiredEnvironment, actions []ReconcileAction, opts ReconcileOptions) error {
+	for _, action := range actions {
+		alwaysLog.Printf("Reconciling %s: %s %s (%s)", env.Name, action.Action, action.Database, action.Reason)
+		switch action.Action {
+		case ReconcileDump:
+			dumpOpts := opts.Dump
+			dumpOpts.Databases = env.databases()
+			if err := DumpWorkflowWithOptions(env.source("moodys"), env.source("tenant"), env.DumpSet, dumpOpts); err != nil {
+				return err
+			}
+
+		case ReconcileRestore:
+			dumpSet, err := dumpSetID(env.DumpSet)
+			if err != nil {
+				return err
+			}
+			config := env.dest(action.Database)
+			exists, err := databaseExists(config, config.DBName)
+			if err != nil {
+				return err
+			}
+			if exists {
+				if err := DeleteDatabasesWithOptions(opts.Drop, config); err != nil {
+					return err
+				}
+			}
+			restoreOpts := opts.Restore
+			restoreOpts.Databases = []string{action.Database}
+			restoreOpts.ServerName = env.server()
+			restoreOpts.FDWTarget = env.FDWTarget
+			if err := RestoreWorkflowWithOptions(env.source("moodys"), env.source("tenant"), env.dest("moodys"), env.dest("tenant"), env.DumpSet, restoreOpts); err != nil {
+				return err
+			}
+			sql := fmt.Sprintf("ALTER DATABASE %s SET %s = %s;", quoteIdent(config.DBName), dumpSetSetting, quoteLiteral(dumpSet))
+			if output, err := newPsqlCmd(maintenanceConfig(config), "-v", "ON_ERROR_STOP=1", "-c", sql).CombinedOutput(); err != nil {
+				return fmt.Errorf("failed to record the dump set of %s: %w, output: %s", config.DBName, err, output)
+			}
+
+		case ReconcileRetarget:
+			if err := RetargetFDW(env.dest("tenant"), FDWRetarget{Server: env.server(), Target: env.fdwTarget()}); err != nil {
+				return err
+			}
+		}
+	}
+	log.Printf("Environment %s is reconciled", env.Name)
+	return nil
+}
+
+// formatReconcileActions lists actions for the dry run and the log
+func formatReconcileActions(actions []ReconcileAction) string {
+	if len(actions) == 0 {
+		return "Every environment matches its spec"
+	}
+	var lines []string
+	for _, a := range actions {
+		lines = append(lines, fmt.Sprintf("%-20s %-8s %-7s %s", a.Environment, a.Action, a.Database, a.Reason))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testEnvironment() DesiredEnvironment {
+	return DesiredEnvironment{
+		Name:    "staging",
+		DumpSet: "dumps/nightly",
+		Source: EnvironmentDatabases{
+			Moodys: &DBConfig{Host: "prod", Port: "5432", DBName: "moodys"},
+			Tenant: &DBConfig{Host: "prod", Port: "5432", DBName: "tenant"},
+		},
+		Dest: EnvironmentDatabases{
+			Moodys: &DBConfig{Host: "staging", Port: "5432", DBName: "moodys"},
+			Tenant: &DBConfig{Host: "staging", Port: "5432", DBName: "tenant"},
+		},
+	}
+}
+
+func actionSummary(actions []ReconcileAction) []string {
+	var summary []string
+	for _, a := range actions {
+		summary = append(summary, a.Action+" "+a.Database)
+	}
+	return summary
+}
+
+func TestReconcileActions(t *testing.T) {
+	env := testEnvironment()
+	current := ObservedDatabase{Exists: true, DumpSet: "20260101T000000Z"}
+	drifted := current
+	drifted.ServerDrift = []string{"ALTER SERVER ..."}
+
+	tests := []struct {
+		name     string
+		dumpSet  string
+		observed map[string]ObservedDatabase
+		want     []string
+	}{
+		{"up to date", "20260101T000000Z", map[string]ObservedDatabase{"moodys": current, "tenant": current}, nil},
+		{"missing dump set", "", map[string]ObservedDatabase{"moodys": current, "tenant": current}, []string{"dump ", "restore moodys", "restore tenant"}},
+		{"missing database", "20260101T000000Z", map[string]ObservedDatabase{"moodys": current}, []string{"restore tenant"}},
+		{"newer dump set", "20260102T000000Z", map[string]ObservedDatabase{"moodys": current, "tenant": drifted}, []string{"restore moodys", "restore tenant"}},
+		{"server drift", "20260101T000000Z", map[string]ObservedDatabase{"moodys": current, "tenant": drifted}, []string{"retarget tenant"}},
+		{"not restored by reconcile", "20260101T000000Z", map[string]ObservedDatabase{"moodys": {Exists: true}, "tenant": current}, []string{"restore moodys"}},
+	}
+	for _, tt := range tests {
+		if got := actionSummary(reconcileActions(env, tt.dumpSet, tt.observed)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: actions = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReconcileSpecValidation(t *testing.T) {
+	env := testEnvironment()
+	if err := env.validate(); err != nil {
+		t.Fatalf("valid environment: %v", err)
+	}
+
+	noMoodys := testEnvironment()
+	noMoodys.Dest.Moodys = nil
+	if err := noMoodys.validate(); err == nil {
+		t.Error("tenant without dest moodys or fdw_target passed")
+	}
+	noMoodys.FDWTarget = &FDWTarget{Host: "shared", DBName: "moodys"}
+	if err := noMoodys.validate(); err != nil {
+		t.Errorf("tenant with fdw_target: %v", err)
+	}
+	if got := noMoodys.fdwTarget(); got.Host != "shared" || got.DBName != "moodys" {
+		t.Errorf("fdwTarget = %+v", got)
+	}
+
+	noSource := testEnvironment()
+	noSource.Source.Tenant = nil
+	if err := noSource.validate(); err == nil {
+		t.Error("dest tenant without source tenant passed")
+	}
+}
+
+func TestReconcileDumpSetID(t *testing.T) {
+	dir := t.TempDir()
+	if id, err := dumpSetID(dir); err != nil || id != "" {
+		t.Fatalf("empty directory: %q, %v", id, err)
+	}
+	created := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	if err := WriteManifest(dir, &Manifest{CreatedAt: created}); err != nil {
+		t.Fatal(err)
+	}
+	if id, err := dumpSetID(dir); err != nil || id != "20260304T050607Z" {
+		t.Errorf("dumpSetID = %q, %v", id, err)
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// RenameRule renames a schema, or a table, on the destination. Table rules name the
+// table as schema.table; To is the new table name, optionally schema-qualified.
+type RenameRule struct {
+	// Database limits the rule to "moodys" or "tenant"; empty applies to both
+	Database string `json:"database"`
+	Schema   string `json:"schema"`
+	Table    string `json:"table"`
+	To       string `json:"to"`
+}
+
+// identChars are the characters that can continue an unquoted identifier
+const identChars = `\w$`
+
+// renameRewrite is a compiled rename applied to SQL text
+type renameRewrite struct {
+	re   *regexp.Regexp
+	repl string
+}
+
+// renamer rewrites object references in pg_dump SQL for one database's rename rules
+type renamer struct {
+	schemas  map[string]string
+	tables   map[string]string
+	rewrites []renameRewrite
+}
+
+// identPattern matches an identifier written plain or double-quoted
+func identPattern(name string) string {
+	return `(?:` + regexp.QuoteMeta(name) + `|` + regexp.QuoteMeta(quoteIdent(name)) + `)`
+}
+
+// newRenamer compiles the rules that apply to database ("moodys" or "tenant").
+// It returns nil when no rule applies.
+func newRenamer(rules []RenameRule, database string) (*renamer, error) {
+	r := &renamer{schemas: make(map[string]string), tables: make(map[string]string)}
+	for _, rule := range rules {
+		if rule.Database != "" && rule.Database != database {
+			continue
+		}
+		if rule.To == "" || (rule.Schema == "") == (rule.Table == "") {
+			return nil, fmt.Errorf("rename rule needs exactly one of schema or table, and to")
+		}
+		if rule.Schema != "" {
+			r.schemas[rule.Schema] = rule.To
+			continue
+		}
+		if !strings.Contains(rule.Table, ".") {
+			return nil, fmt.Errorf("rename rule table %q must be schema-qualified", rule.Table)
+		}
+		r.tables[rule.Table] = rule.To
+	}
+	if len(r.schemas) == 0 && len(r.tables) == 0 {
+		return nil, nil
+	}
+
+	// Tables first, matched with their original schema, then whole schemas
+	for _, table := range sortedKeys(r.tables) {
+		schema, name, _ := strings.Cut(table, ".")
+		to := r.tables[table]
+		if !strings.Contains(to, ".") {
+			to = schema + "." + to
+		}
+		r.rewrites = append(r.rewrites, renameRewrite{
+			re:   regexp.MustCompile(`(^|[^` + identChars + `".])` + identPattern(schema) + `\.` + identPattern(name) + `($|[^` + identChars + `"])`),
+			repl: "${1}" + escapeReplacement(quoteQualifiedName(to)) + "${2}",
+		})
+	}
+	for _, schema := range sortedKeys(r.schemas) {
+		to := escapeReplacement(quoteIdent(r.schemas[schema]))
+		r.rewrites = append(r.rewrites,
+			renameRewrite{
+				re:   regexp.MustCompile(`(^|[^` + identChars + `".])` + identPattern(schema) + `\.`),
+				repl: "${1}" + to + ".",
+			},
+			renameRewrite{
+				re:   regexp.MustCompile(`(\bSCHEMA\s+)` + identPattern(schema) + `($|[^` + identChars + `"])`),
+				repl: "${1}" + to + "${2}",
+			},
+		)
+	}
+	return r, nil
+}
+
+// sortedKeys returns map keys in a stable order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// escapeReplacement escapes $ in regexp replacement text
+func escapeReplacement(s string) string {
+	return strings.ReplaceAll(s, "$", "$$")
+}
+
+// rewrite applies the renames to a chunk of SQL
+func (r *renamer) rewrite(sql string) string {
+	for _, rw := range r.rewrites {
+		// Run twice so adjacent matches sharing a delimiter are both replaced
+		sql = rw.re.ReplaceAllString(sql, rw.repl)
+		sql = rw.re.ReplaceAllString(sql, rw.repl)
+	}
+	return sql
+}
+
+// preamble creates the target schemas, which may not exist in the dump (e.g. public)
+func (r *renamer) preamble() string {
+	targets := make(map[string]bool)
+	for _, to := range r.schemas {
+		targets[to] = true
+	}
+	for _, to := range r.tables {
+		if schema, _, found := strings.Cut(to, "."); found {
+			targets[schema] = true
+		}
+	}
+
+	var b strings.Builder
+	for _, schema := range sortedKeysBool(targets) {
+		fmt.Fprintf(&b, "CREATE SCHEMA IF NOT EXISTS %s;\n", quoteIdent(schema))
+	}
+	return b.String()
+}
+
+// sortedKeysBool returns the keys of a set in a stable order
+func sortedKeysBool(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// rewriteScript streams a pg_dump script from src to dst, renaming references in
+// statements while copying COPY data rows unchanged
+func (r *renamer) rewriteScript(src io.Reader, dst io.Writer) error {
+	reader := bufio.NewReaderSize(src, 1<<20)
+	writer := bufio.NewWriterSize(dst, 1<<20)
+	if _, err := writer.WriteString(r.preamble()); err != nil {
+		return err
+	}
+
+	inCopy := false
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			switch {
+			case inCopy:
+				if strings.TrimRight(line, "\r\n") == `\.` {
+					inCopy = false
+				}
+			case strings.HasPrefix(line, "COPY ") && strings.HasSuffix(strings.TrimRight(line, "\r\n"), "FROM stdin;"):
+				inCopy = true
+				line = r.rewrite(line)
+			default:
+				line = r.rewrite(line)
+			}
+			if _, werr := writer.WriteString(line); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// foreignOptionsRe matches the OPTIONS of a foreign table naming its remote table
+var foreignOptionsRe = regexp.MustCompile(`(?s)(SERVER\s+\S+\s+OPTIONS \()([^)]*)\)`)
+
+// optionValueRe matches a name 'value' pair inside an OPTIONS list
+var optionValueRe = regexp.MustCompile(`(schema_name|table_name)\s+'((?:[^']|'')*)'`)
+
+// rewriteForeignTableOptions points foreign tables at remote objects renamed by the
+// remote database's rules, fixing their schema_name and table_name options
+func rewriteForeignTableOptions(script string, remote *renamer) string {
+	if remote == nil {
+		return script
+	}
+	return foreignOptionsRe.ReplaceAllStringFunc(script, func(block string) string {
+		m := foreignOptionsRe.FindStringSubmatch(block)
+		options := m[2]
+
+		values := make(map[string]string)
+		for _, kv := range optionValueRe.FindAllStringSubmatch(options, -1) {
+			values[kv[1]] = strings.ReplaceAll(kv[2], "''", "'")
+		}
+		schema, table := values["schema_name"], values["table_name"]
+		if schema == "" {
+			schema = "public"
+		}
+
+		newSchema, newTable := schema, table
+		if to, ok := remote.tables[schema+"."+table]; ok && table != "" {
+			newTable = to
+			if s, t, found := strings.Cut(to, "."); found {
+				newSchema, newTable = s, t
+			}
+		} else if to, ok := remote.schemas[schema]; ok {
+			newSchema = to
+		}
+		if newSchema == schema && newTable == table {
+			return block
+		}
+
+		options = optionValueRe.ReplaceAllStringFunc(options, func(kv string) string {
+			name := optionValueRe.FindStringSubmatch(kv)[1]
+			if name == "schema_name" {
+				return "schema_name " + quoteLiteral(newSchema)
+			}
+			return "table_name " + quoteLiteral(newTable)
+		})
+		if _, ok := values["schema_name"]; !ok && newSchema != schema {
+			options = "schema_name " + quoteLiteral(newSchema) + ", " + options
+		}
+		return m[1] + options + ")"
+	})
+}
+
+// renamedCopy writes a renamed copy of a plain-text section, under the same file name in
+// a temporary directory, and returns its path; the caller removes the directory
+func renamedCopy(inputFile string, r *renamer, remote *renamer) (string, error) {
+	content, err := os.ReadFile(inputFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", inputFile, err)
+	}
+
+	dir, err := os.MkdirTemp("", "pg_restore_fdw_renamed_")
+	if err != nil {
+		return "", fmt.Errorf("failed to create renamed script directory: %w", err)
+	}
+	out, err := os.Create(filepath.Join(dir, filepath.Base(inputFile)))
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("failed to create renamed script: %w", err)
+	}
+	defer out.Close()
+
+	script := rewriteForeignTableOptions(string(content), remote)
+	if r != nil {
+		err = r.rewriteScript(strings.NewReader(script), out)
+	} else {
+		_, err = out.WriteString(script)
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("failed to write renamed script: %w", err)
+	}
+	return out.Name(), nil
+}
+
+// renamedScriptCmd returns a pg_restore command rendering a custom-format archive as SQL,
+// optionally limited to listFile, and a reader yielding that script with renames applied.
+// With stdin, the archive is read from it and inputFile only names it.
+func renamedScriptCmd(inputFile, listFile string, stdin io.Reader, r *renamer) (*exec.Cmd, io.ReadCloser, error) {
+	args := []string{"--no-owner", "--no-privileges", "-f", "-"}
+	if listFile != "" {
+		args = append(args, "-L", listFile)
+	}
+	if stdin == nil {
+		args = append(args, inputFile)
+	}
+	producer := exec.Command("pg_restore", args...)
+	producer.Stdin = stdin
+	producer.Env = subprocessEnv()
+	stdout, err := producer.StdoutPipe()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read script of %s: %w", inputFile, err)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		err := r.rewriteScript(stdout, pw)
+		io.Copy(io.Discard, stdout)
+		pw.CloseWithError(err)
+	}()
+	return producer, pr, nil
+}
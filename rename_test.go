@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenamerRewriteScript(t *testing.T) {
+	r, err := newRenamer([]RenameRule{
+		{Schema: "public", To: "tenant_123"},
+		{Table: "public.customer_transactions", To: "transactions"},
+		{Database: "moodys", Schema: "public", To: "ignored"},
+	}, "tenant")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	script := `CREATE TABLE public.customer_transactions (
+    id integer NOT NULL
+);
+ALTER SEQUENCE public.customer_transactions_id_seq OWNED BY public.customer_transactions.id;
+CREATE EXTENSION IF NOT EXISTS postgres_fdw WITH SCHEMA public;
+COPY public.customer_transactions (id, description) FROM stdin;
+1	paid public.customer_transactions
+\.
+CREATE INDEX idx ON public.customer_transactions USING btree (id);
+`
+	want := `CREATE SCHEMA IF NOT EXISTS "tenant_123";
+CREATE TABLE "tenant_123"."transactions" (
+    id integer NOT NULL
+);
+ALTER SEQUENCE "tenant_123".customer_transactions_id_seq OWNED BY "tenant_123"."transactions".id;
+CREATE EXTENSION IF NOT EXISTS postgres_fdw WITH SCHEMA "tenant_123";
+COPY "tenant_123"."transactions" (id, description) FROM stdin;
+1	paid public.customer_transactions
+\.
+CREATE INDEX idx ON "tenant_123"."transactions" USING btree (id);
+`
+	var out strings.Builder
+	if err := r.rewriteScript(strings.NewReader(script), &out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestRewriteForeignTableOptions(t *testing.T) {
+	remote, err := newRenamer([]RenameRule{{Database: "moodys", Schema: "public", To: "ratings"}}, "moodys")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	script := `CREATE FOREIGN TABLE public.companies_foreign (
+    id integer
+)
+SERVER moodys_server
+OPTIONS (
+    schema_name 'public',
+    table_name 'companies'
+);`
+	got := rewriteForeignTableOptions(script, remote)
+	if !strings.Contains(got, "schema_name 'ratings',\n    table_name 'companies'") {
+		t.Errorf("schema_name not rewritten:\n%s", got)
+	}
+	if !strings.Contains(got, "CREATE FOREIGN TABLE public.companies_foreign") {
+		t.Errorf("local name changed:\n%s", got)
+	}
+}
+
+func TestRenamedScriptConsumerFails(t *testing.T) {
+	// A fake pg_restore renders an endless script
+	dir := t.TempDir()
+	script := "#!/bin/sh\nexec yes 'CREATE TABLE public.customer_transactions (id integer);'\n"
+	if err := os.WriteFile(filepath.Join(dir, "pg_restore"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	r, err := newRenamer([]RenameRule{{Schema: "public", To: "tenant_123"}}, "tenant")
+	if err != nil {
+		t.Fatal(err)
+	}
+	producer, rendered, err := renamedScriptCmd("tenant_pre_data.dump", "", nil, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The consumer exits without reading, as psql does on a failed login
+	consumer := exec.Command("sh", "-c", "exit 3")
+	consumer.Stdin = rendered
+	if err := producer.Start(); err != nil {
+		t.Fatal(err)
+	}
+	consumerErr := consumer.Run()
+	if consumerErr == nil {
+		t.Fatal("expected the consumer to fail")
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- finishProducer(producer, rendered, consumerErr) }()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		producer.Process.Kill()
+		t.Fatal("pg_restore stayed blocked after psql failed")
+	}
+}
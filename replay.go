@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReplayConfig replays a file of representative read-only queries against the restored
+// databases after restore and fails the run when one errors or is slower than its
+// threshold, so a refresh is only cut over when it serves the application's query shapes
+type ReplayConfig struct {
+	// File holds the queries, each after a "-- name:" line (see replayHeaderRe)
+	File string `json:"file"`
+	// MaxLatency is the threshold of queries that don't set their own, e.g. "500ms";
+	// empty only times them
+	MaxLatency string `json:"max_latency"`
+	// Runs is how many times each query runs; its median latency is compared. Default 3.
+	Runs int `json:"runs"`
+}
+
+// defaultReplayRuns is how many times each replayed query runs by default
+const defaultReplayRuns = 3
+
+// ReplayQuery is a query of the replay file
+type ReplayQuery struct {
+	Name string
+	// Database is "moodys" or "tenant"; default tenant
+	Database   string
+	MaxLatency time.Duration
+	SQL        string
+}
+
+// ReplayResult is the outcome of a replayed query
+type ReplayResult struct {
+	Name       string `json:"name"`
+	Database   string `json:"database"`
+	Status     string `json:"status"`
+	Latency    string `json:"latency,omitempty"`
+	MaxLatency string `json:"max_latency,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// replayHeaderRe matches the comment lines that start a query and set its options:
+//
+//	-- name: open_invoices
+//	-- database: moodys
+//	-- max_latency: 200ms
+var replayHeaderRe = regexp.MustCompile(`^--\s*(name|database|max_latency)\s*:\s*(.*?)\s*$`)
+
+func (c *ReplayConfig) validate() error {
+	if c.File == "" {
+		return fmt.Errorf("file is required")
+	}
+	if c.MaxLatency != "" {
+		if d, err := time.ParseDuration(c.MaxLatency); err != nil || d <= 0 {
+			return fmt.Errorf("invalid max_latency %q: use a positive duration such as 500ms", c.MaxLatency)
+		}
+	}
+	if c.Runs < 0 {
+		return fmt.Errorf("runs must not be negative")
+	}
+	_, err := c.load()
+	return err
+}
+
+// load reads and parses the replay file, applying the default threshold
+func (c *ReplayConfig) load() ([]ReplayQuery, error) {
+	data, err := os.ReadFile(c.File)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read replay file: %w", err)
+	}
+	queries, err := parseReplayFile(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", c.File, err)
+	}
+	defaultLatency, _ := time.ParseDuration(c.MaxLatency)
+	for i := range queries {
+		if queries[i].MaxLatency == 0 {
+			queries[i].MaxLatency = defaultLatency
+		}
+	}
+	return queries, nil
+}
+
+// parseReplayFile splits a replay file into its queries. Each query starts with a
+// "-- name:" line, optionally followed by "-- database:" and "-- max_latency:" lines;
+// everything up to the next name is its SQL.
+func parseReplayFile(content string) ([]ReplayQuery, error) {
+	var queries []ReplayQuery
+	var current *ReplayQuery
+	var sql []string
+	seen := make(map[string]bool)
+	finish := func() error {
+		if current == nil {
+			return nil
+		}
+		current.SQL = strings.TrimSpace(strings.Join(sql, "\n"))
+		if strings.Trim(current.SQL, "; \t\n") == "" {
+			return fmt.Errorf("query %q has no SQL", current.Name)
+		}
+		queries = append(queries, *current)
+		return nil
+	}
+
+	for i, line := range strings.Split(content, "\n") {
+		m := replayHeaderRe.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			if current == nil {
+				if trimmed := strings.TrimSpace(line); trimmed != "" && !strings.HasPrefix(trimmed, "--") {
+					return nil, fmt.Errorf("line %d: SQL before the first \"-- name:\" line", i+1)
+				}
+				continue
+			}
+			sql = append(sql, line)
+			continue
+		}
+		key, value := m[1], m[2]
+		if key == "name" {
+			if err := finish(); err != nil {
+				return nil, err
+			}
+			if value == "" {
+				return nil, fmt.Errorf("line %d: empty query name", i+1)
+			}
+			if seen[value] {
+				return nil, fmt.Errorf("query %q is defined twice", value)
+			}
+			seen[value] = true
+			current, sql = &ReplayQuery{Name: value, Database: "tenant"}, nil
+			continue
+		}
+		// Options belong to the header, before the query's SQL starts
+		if current == nil || strings.TrimSpace(strings.Join(sql, "")) != "" {
+			return nil, fmt.Errorf("line %d: %s must directly follow a \"-- name:\" line", i+1, key)
+		}
+		switch key {
+		case "database":
+			if value != "moodys" && value != "tenant" {
+				return nil, fmt.Errorf("query %q: unknown database %q", current.Name, value)
+			}
+			current.Database = value
+		case "max_latency":
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("query %q: invalid max_latency %q", current.Name, value)
+			}
+			current.MaxLatency = d
+		}
+	}
+	if err := finish(); err != nil {
+		return nil, err
+	}
+	if len(queries) == 0 {
+		return nil, fmt.Errorf("no queries found; start each with a \"-- name:\" line")
+	}
+	return queries, nil
+}
+
+// psqlTimingRe matches the line psql's \timing prints after each statement
+var psqlTimingRe = regexp.MustCompile(`(?m)^Time: ([0-9.]+) ms`)
+
+// parseTimings returns the total time of the statements psql timed
+func parseTimings(output string) (time.Duration, bool) {
+	matches := psqlTimingRe.FindAllStringSubmatch(output, -1)
+	if len(matches) == 0 {
+		return 0, false
+	}
+	var total time.Duration
+	for _, m := range matches {
+		ms, _ := strconv.ParseFloat(m[1], 64)
+		total += time.Duration(ms * float64(time.Millisecond))
+	}
+	return total, true
+}
+
+// medianDuration returns the median of durations, which must not be empty
+func medianDuration(durations []time.Duration) time.Duration {
+	sorted := append([]time.Duration{}, durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[len(sorted)/2]
+}
+
+// replayQuery runs a query runs times, each in a read-only transaction, and returns its
+// latencies. Rows are discarded; psql's \timing measures each run as the client sees it,
+// summing the statements of a query that has several.
+func replayQuery(config DBConfig, query ReplayQuery, runs int) ([]time.Duration, error) {
+	var latencies []time.Duration
+	for run := 0; run < runs; run++ {
+		cmd := newPsqlCmd(config, "-X", "-q", "-v", "ON_ERROR_STOP=1", "-o", os.DevNull)
+		cmd.Stdin = strings.NewReader("BEGIN READ ONLY;\n\\timing on\n" + query.SQL + "\n;\n\\timing off\nROLLBACK;\n")
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(psqlTimingRe.ReplaceAllString(string(output), "")))
+		}
+		latency, ok := parseTimings(string(output))
+		if !ok {
+			return nil, fmt.Errorf("psql reported no timing: %s", strings.TrimSpace(string(output)))
+		}
+		latencies = append(latencies, latency)
+	}
+	return latencies, nil
+}
+
+// RunReplay replays the queries of the replay file against their restored databases,
+// records each result in the run report, and fails when any query errors or its median
+// latency is past its threshold
+func RunReplay(c *ReplayConfig, dests map[string]DBConfig) error {
+	queries, err := c.load()
+	if err != nil {
+		return err
+	}
+	runs := defaultIfZero(c.Runs, defaultReplayRuns)
+
+	var failed []string
+	for _, query := range queries {
+		config, ok := dests[query.Database]
+		if !ok {
+			debugf("Skipping replayed query %q: %s was not restored", query.Name, query.Database)
+			continue
+		}
+		result := ReplayResult{Name: query.Name, Database: config.DBName, Status: "passed"}
+		if query.MaxLatency > 0 {
+			result.MaxLatency = query.MaxLatency.String()
+		}
+		latencies, err := replayQuery(config, query, runs)
+		switch {
+		case err != nil:
+			result.Status, result.Error = "failed", err.Error()
+			failed = append(failed, fmt.Sprintf("%s: %v", query.Name, err))
+		default:
+			latency := medianDuration(latencies)
+			result.Latency = latency.Round(time.Microsecond).String()
+			if query.MaxLatency > 0 && latency > query.MaxLatency {
+				result.Status = "failed"
+				failed = append(failed, fmt.Sprintf("%s: median latency %s is past %s", query.Name, result.Latency, query.MaxLatency))
+			} else {
+				log.Printf("Replayed %q on %s: median latency %s over %d runs", query.Name, config.DBName, result.Latency, runs)
+			}
+		}
+		activeReport.recordReplay(result)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d replayed queries failed:\n%s", len(failed), len(queries), strings.Join(failed, "\n"))
+	}
+	return nil
+}
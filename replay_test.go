@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseReplayFile(t *testing.T) {
+	content := `-- Hot queries of the dashboard
+
+-- name: dashboard
+-- max_latency: 200ms
+SELECT count(*)
+FROM customer_transactions;
+
+-- name: ratings
+-- database: moodys
+SELECT * FROM ratings LIMIT 10;
+`
+	queries, err := parseReplayFile(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 2 {
+		t.Fatalf("got %d queries", len(queries))
+	}
+	if q := queries[0]; q.Name != "dashboard" || q.Database != "tenant" || q.MaxLatency != 200*time.Millisecond ||
+		q.SQL != "SELECT count(*)\nFROM customer_transactions;" {
+		t.Errorf("got %+v", q)
+	}
+	if q := queries[1]; q.Database != "moodys" || q.MaxLatency != 0 {
+		t.Errorf("got %+v", q)
+	}
+
+	for content, want := range map[string]string{
+		"SELECT 1;":                                   "before the first",
+		"-- name: a\n-- name: b\nSELECT 1;":           "has no SQL",
+		"-- name: a\nSELECT 1;\n-- name: a\nSELECT 2": "defined twice",
+		"-- name: a\nSELECT 1;\n-- max_latency: 1s":   "must directly follow",
+		"-- name: a\n-- database: other\nSELECT 1;":   "unknown database",
+		"-- just a comment":                           "no queries",
+	} {
+		if _, err := parseReplayFile(content); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: got %v, want %q", content, err, want)
+		}
+	}
+}
+
+func TestParseTimings(t *testing.T) {
+	latency, ok := parseTimings("Time: 12.500 ms\nTime: 0.500 ms (00:00.000)\n")
+	if !ok || latency != 13*time.Millisecond {
+		t.Errorf("got %v, %v", latency, ok)
+	}
+	if _, ok := parseTimings("ERROR: boom"); ok {
+		t.Error("expected no timing")
+	}
+	if m := medianDuration([]time.Duration{3, 1, 2}); m != 2 {
+		t.Errorf("median %v", m)
+	}
+}
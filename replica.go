@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Replica lag policies
+const (
+	// OnLagPrimary dumps from the primary when the replica is too far behind
+	OnLagPrimary = "primary"
+	// OnLagAbort fails the dump when the replica is too far behind
+	OnLagAbort = "abort"
+)
+
+// ReplicaConfig points a source at a read replica to dump from
+type ReplicaConfig struct {
+	Host string `json:"host"`
+	Port string `json:"port"`
+	// MaxLag is the replay lag beyond which the replica is not used, e.g. "30s"
+	MaxLag string `json:"max_lag"`
+	// OnLag is "primary" (default) or "abort"
+	OnLag string `json:"on_lag"`
+}
+
+// validate checks the replica settings
+func (r *ReplicaConfig) validate() error {
+	if r.Host == "" {
+		return fmt.Errorf("host is required")
+	}
+	if r.MaxLag != "" {
+		if _, err := time.ParseDuration(r.MaxLag); err != nil {
+			return fmt.Errorf("invalid max_lag %q: %w", r.MaxLag, err)
+		}
+	}
+	switch r.OnLag {
+	case "", OnLagPrimary, OnLagAbort:
+	default:
+		return fmt.Errorf("on_lag must be %q or %q, got %q", OnLagPrimary, OnLagAbort, r.OnLag)
+	}
+	return nil
+}
+
+// maxLag returns the configured lag threshold; zero means any lag is accepted
+func (r *ReplicaConfig) maxLag() time.Duration {
+	lag, _ := time.ParseDuration(r.MaxLag)
+	return lag
+}
+
+// DumpSource records which server a database was dumped from
+type DumpSource struct {
+	Database string `json:"database"`
+	Host     string `json:"host"`
+	Port     string `json:"port"`
+	Replica  bool   `json:"replica"`
+	// ReplayLag is how far the replica was behind when the dump started
+	ReplayLag string `json:"replay_lag,omitempty"`
+	// Fallback explains why a configured replica was not used
+	Fallback string `json:"fallback,omitempty"`
+}
+
+// replicaLagQuery reports whether the server is a standby and how far its replay is
+// behind. A standby that has replayed everything it received counts as caught up, since
+// pg_last_xact_replay_timestamp only moves when the primary commits.
+const replicaLagQuery = `SELECT pg_is_in_recovery(),
+	CASE WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+	ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), -1) END;`
+
+// parseReplicaLag parses "t|seconds" output; a negative lag means nothing was replayed yet
+func parseReplicaLag(row string) (standby bool, lag time.Duration, err error) {
+	recovery, seconds, found := strings.Cut(strings.TrimSpace(row), "|")
+	if !found {
+		return false, 0, fmt.Errorf("unexpected replica lag output %q", row)
+	}
+	if recovery != "t" {
+		return false, 0, nil
+	}
+	if seconds == "" {
+		return true, -1, nil
+	}
+	value, err := strconv.ParseFloat(seconds, 64)
+	if err != nil {
+		return true, 0, fmt.Errorf("failed to parse replica lag %q: %w", seconds, err)
+	}
+	if value < 0 {
+		return true, -1, nil
+	}
+	return true, time.Duration(value * float64(time.Second)), nil
+}
+
+// replicaProblem explains why a replica can't be dumped from, or returns ""
+func replicaProblem(standby bool, lag, maxLag time.Duration) string {
+	switch {
+	case !standby:
+		return "server is not in recovery"
+	case lag < 0:
+		return "replica has not replayed any transactions"
+	case maxLag > 0 && lag > maxLag:
+		return fmt.Sprintf("replay lag %v exceeds %v", lag.Round(time.Millisecond), maxLag)
+	}
+	return ""
+}
+
+// chooseDumpSource returns the connection to dump database from: the replica when it
+// is a standby within its lag threshold, otherwise the primary or an error per OnLag
+func chooseDumpSource(database string, primary DBConfig, replica *ReplicaConfig) (DBConfig, DumpSource, error) {
+	source := DumpSource{Database: database, Host: primary.dialHost(), Port: primary.dialPort()}
+	if replica == nil {
+		return primary, source, nil
+	}
+
+	config := primary
+	config.Host, config.Port = replica.Host, replica.Port
+	if config.Port == "" {
+		config.Port = primary.Port
+	}
+	config.DirectHost, config.DirectPort = "", ""
+
+	var problem string
+	output, err := newPsqlCmd(config, "-t", "-A", "-c", replicaLagQuery).CombinedOutput()
+	if err != nil {
+		problem = fmt.Sprintf("replica unreachable: %v, output: %s", err, strings.TrimSpace(string(output)))
+	} else {
+		standby, lag, err := parseReplicaLag(string(output))
+		if err != nil {
+			return primary, source, err
+		}
+		problem = replicaProblem(standby, lag, replica.maxLag())
+		if problem == "" {
+			log.Printf("Dumping %s from replica %s:%s (replay lag %v)", database, config.Host, config.Port, lag.Round(time.Millisecond))
+			return config, DumpSource{Database: database, Host: config.Host, Port: config.Port, Replica: true,
+				ReplayLag: lag.Round(time.Millisecond).String()}, nil
+		}
+	}
+
+	if replica.OnLag == OnLagAbort {
+		return primary, source, fmt.Errorf("can't dump %s from replica %s:%s: %s", database, config.Host, config.Port, problem)
+	}
+	log.Printf("WARNING: dumping %s from the primary instead of replica %s:%s: %s", database, config.Host, config.Port, problem)
+	source.Fallback = problem
+	return primary, source, nil
+}
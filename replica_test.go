@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseReplicaLag(t *testing.T) {
+	standby, lag, err := parseReplicaLag("t|2.5\n")
+	if err != nil || !standby || lag != 2500*time.Millisecond {
+		t.Errorf("got %v %v %v", standby, lag, err)
+	}
+	if standby, _, err := parseReplicaLag("f|-1\n"); err != nil || standby {
+		t.Errorf("primary parsed as standby=%v err=%v", standby, err)
+	}
+	if _, lag, _ := parseReplicaLag("t|-1"); lag >= 0 {
+		t.Errorf("expected unknown lag, got %v", lag)
+	}
+	if _, _, err := parseReplicaLag("garbage"); err == nil {
+		t.Error("expected an error for malformed output")
+	}
+}
+
+func TestReplicaProblem(t *testing.T) {
+	if p := replicaProblem(true, time.Second, 30*time.Second); p != "" {
+		t.Errorf("caught-up replica rejected: %s", p)
+	}
+	if replicaProblem(true, time.Minute, 30*time.Second) == "" {
+		t.Error("expected lag beyond the threshold to be rejected")
+	}
+	if replicaProblem(false, 0, 0) == "" || replicaProblem(true, -1, 0) == "" {
+		t.Error("expected a primary or an unreplayed standby to be rejected")
+	}
+	if err := (&ReplicaConfig{Host: "r", OnLag: "wait"}).validate(); err == nil {
+		t.Error("expected an unknown on_lag to be rejected")
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// RunReport summarizes a workflow run: its phases and every command step they ran
+type RunReport struct {
+	mu sync.Mutex
+
+	RunID      string        `json:"run_id"`
+	StartedAt  time.Time     `json:"started_at"`
+	FinishedAt time.Time     `json:"finished_at"`
+	Duration   string        `json:"duration"`
+	Status     string        `json:"status"`
+	Error      string        `json:"error,omitempty"`
+	Phases     []PhaseReport `json:"phases"`
+	Steps      []StepReport  `json:"steps"`
+	// Profile is the configuration profile the run used
+	Profile string `json:"profile,omitempty"`
+	// Skipped lists objects deliberately left out of the restore
+	Skipped []SkippedObject `json:"skipped,omitempty"`
+	// ForeignKeys lists the foreign key checks run after restore
+	ForeignKeys []ForeignKeyCheck `json:"foreign_keys,omitempty"`
+	// QueryChecks lists the results of the configured query pack
+	QueryChecks []QueryCheckResult `json:"query_checks,omitempty"`
+	// Replay lists the latencies of the replayed workload queries
+	Replay []ReplayResult `json:"replay,omitempty"`
+	// AppRoleFailures lists relations the application role could not read
+	AppRoleFailures []AppRoleFailure `json:"app_role_failures,omitempty"`
+	// FailureCleanup records what was done with the databases of a failed restore
+	FailureCleanup []FailureCleanup `json:"failure_cleanup,omitempty"`
+	// Cutover records the databases a blue/green restore swapped into place
+	Cutover []CutoverRecord `json:"cutover,omitempty"`
+	// Triggers lists the user triggers kept from firing while data loaded
+	Triggers []TriggerToggle `json:"triggers,omitempty"`
+	// RestorePoints lists the restore points created on the destination clusters
+	RestorePoints []RestorePoint `json:"restore_points,omitempty"`
+	// Validation lists every table compared by the configured validation
+	Validation []TableValidation `json:"validation,omitempty"`
+	// Warnings lists the warnings and alerts logged during the run
+	Warnings []string `json:"warnings,omitempty"`
+	// Destinations lists the restore to each destination of a fan-out
+	Destinations []DestinationReport `json:"destinations,omitempty"`
+	// SlowestObjects lists the slowest objects each pg_restore step restored, with -toc-timing
+	SlowestObjects []ObjectTiming `json:"slowest_objects,omitempty"`
+}
+
+// SkippedObject records a TOC entry left out of the restore and why
+type SkippedObject struct {
+	Database string `json:"database"`
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	Reason   string `json:"reason"`
+}
+
+// PhaseReport records a high-level phase such as dump or restore
+type PhaseReport struct {
+	Name      string    `json:"name"`
+	StartedAt time.Time `json:"started_at"`
+	Duration  string    `json:"duration"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// StepReport records a single command run within a phase
+type StepReport struct {
+	Name      string    `json:"name"`
+	LogFile   string    `json:"log_file,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	Duration  string    `json:"duration"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// activeReport collects steps run through runStreaming; nil disables collection
+var activeReport *RunReport
+
+// NewRunReport starts a report for a run
+func NewRunReport(runID string) *RunReport {
+	return &RunReport{RunID: runID, StartedAt: time.Now(), Status: "running"}
+}
+
+// statusOf maps an error to a report status
+func statusOf(err error) (string, string) {
+	if err != nil {
+		return "failed", err.Error()
+	}
+	return "succeeded", ""
+}
+
+// Phase runs fn as a named phase and records its outcome
+func (r *RunReport) Phase(name string, fn func() error) error {
+	if err := activeWindow.await(name); err != nil {
+		r.mu.Lock()
+		r.Phases = append(r.Phases, PhaseReport{Name: name, StartedAt: time.Now(), Duration: "0s", Status: "failed", Error: err.Error()})
+		r.mu.Unlock()
+		return err
+	}
+	span := startSpan("phase "+name, "run.id", r.RunID)
+	activeStatus.phaseStarted(name)
+	start := time.Now()
+	err := fn()
+	span.End(err)
+	activeStatus.phaseFinished(name)
+
+	status, msg := statusOf(err)
+	r.mu.Lock()
+	r.Phases = append(r.Phases, PhaseReport{
+		Name:      name,
+		StartedAt: start,
+		Duration:  time.Since(start).Round(time.Millisecond).String(),
+		Status:    status,
+		Error:     msg,
+	})
+	r.mu.Unlock()
+	return err
+}
+
+// recordStep adds a finished command step
+func (r *RunReport) recordStep(name, logFile string, start time.Time, err error) {
+	if r == nil {
+		return
+	}
+	status, msg := statusOf(err)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Steps = append(r.Steps, StepReport{
+		Name:      name,
+		LogFile:   logFile,
+		StartedAt: start,
+		Duration:  time.Since(start).Round(time.Millisecond).String(),
+		Status:    status,
+		Error:     msg,
+	})
+}
+
+// recordSkipped adds an object left out of the restore
+func (r *RunReport) recordSkipped(database string, entry TOCEntry, reason string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Skipped = append(r.Skipped, SkippedObject{
+		Database: database,
+		Type:     entry.Desc,
+		Name:     entry.QualifiedName(),
+		Reason:   reason,
+	})
+}
+
+// recordForeignKey adds the outcome of a foreign key check
+func (r *RunReport) recordForeignKey(check ForeignKeyCheck) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ForeignKeys = append(r.ForeignKeys, check)
+}
+
+// recordQueryCheck adds the outcome of a query pack check
+func (r *RunReport) recordQueryCheck(result QueryCheckResult) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.QueryChecks = append(r.QueryChecks, result)
+}
+
+// recordReplay adds the outcome of a replayed query
+func (r *RunReport) recordReplay(result ReplayResult) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Replay = append(r.Replay, result)
+}
+
+// recordAppRoleFailure adds a relation the application role could not read
+func (r *RunReport) recordAppRoleFailure(failure AppRoleFailure) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.AppRoleFailures = append(r.AppRoleFailures, failure)
+}
+
+// recordFailureCleanup adds what was done with a database left by a failed restore
+func (r *RunReport) recordFailureCleanup(decision FailureCleanup) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.FailureCleanup = append(r.FailureCleanup, decision)
+}
+
+// recordCutover adds a database swapped into place
+func (r *RunReport) recordCutover(record CutoverRecord) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Cutover = append(r.Cutover, record)
+}
+
+// recordTrigger adds a user trigger kept from firing during a load
+func (r *RunReport) recordTrigger(toggle TriggerToggle) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Triggers = append(r.Triggers, toggle)
+}
+
+// recordWarning adds a logged warning
+func (r *RunReport) recordWarning(warning string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Warnings = append(r.Warnings, warning)
+}
+
+// recordValidation adds a table compared by the configured validation
+func (r *RunReport) recordValidation(result TableValidation) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Validation = append(r.Validation, result)
+}
+
+// recordRestorePoint adds a restore point created on a destination cluster
+func (r *RunReport) recordRestorePoint(point RestorePoint) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.RestorePoints = append(r.RestorePoints, point)
+}
+
+// recordDestination adds the restore to one destination of a fan-out
+func (r *RunReport) recordDestination(record DestinationReport) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Destinations = append(r.Destinations, record)
+}
+
+// recordObjectTimings adds the slowest objects a step restored
+func (r *RunReport) recordObjectTimings(timings []ObjectTiming) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.SlowestObjects = append(r.SlowestObjects, timings...)
+}
+
+// Finish records the overall outcome
+func (r *RunReport) Finish(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.FinishedAt = time.Now()
+	r.Duration = r.FinishedAt.Sub(r.StartedAt).Round(time.Second).String()
+	r.Status, r.Error = statusOf(err)
+}
+
+// reportPath returns where the report for a run is stored
+func reportPath(dir, runID string) string {
+	return filepath.Join(dir, "reports", fmt.Sprintf("report_%s.json", runID))
+}
+
+// Save writes the report under dir/reports and returns its path
+func (r *RunReport) Save(dir string) (string, error) {
+	r.mu.Lock()
+	content, err := json.MarshalIndent(r, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return "", fmt.Errorf("failed to encode run report: %w", err)
+	}
+
+	path := reportPath(dir, r.RunID)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("failed to create report directory: %w", err)
+	}
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		return "", fmt.Errorf("failed to write run report: %w", err)
+	}
+
+	log.Printf("Run report saved to %s", path)
+	return path, nil
+}
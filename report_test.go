@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestRunReportPhases(t *testing.T) {
+	report := NewRunReport("20260601-101500")
+	if err := report.Phase("dump", func() error { return nil }); err != nil {
+		t.Fatalf("dump phase: %v", err)
+	}
+	restoreErr := errors.New("restore failed")
+	if err := report.Phase("restore", func() error { return restoreErr }); err != restoreErr {
+		t.Fatalf("restore phase returned %v, want the phase's error", err)
+	}
+	report.Finish(restoreErr)
+
+	if len(report.Phases) != 2 {
+		t.Fatalf("expected 2 phases, got %+v", report.Phases)
+	}
+	if p := report.Phases[0]; p.Name != "dump" || p.Status != "succeeded" || p.Error != "" {
+		t.Errorf("dump phase = %+v", p)
+	}
+	if p := report.Phases[1]; p.Name != "restore" || p.Status != "failed" || p.Error != "restore failed" {
+		t.Errorf("restore phase = %+v", p)
+	}
+	if report.Status != "failed" || report.Error != "restore failed" || report.FinishedAt.IsZero() {
+		t.Errorf("run outcome = %s %q at %v", report.Status, report.Error, report.FinishedAt)
+	}
+}
+
+func TestRunReportSave(t *testing.T) {
+	dir := t.TempDir()
+	report := NewRunReport("20260601-101500")
+	report.Phase("restore", func() error { return nil })
+	report.recordStep("restore_tenant_data", "logs/restore_tenant_data.log", report.StartedAt, nil)
+	report.recordWarning("slow restore")
+	report.Finish(nil)
+
+	path, err := report.Save(dir)
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if path != reportPath(dir, "20260601-101500") {
+		t.Errorf("saved to %s", path)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved map[string]any
+	if err := json.Unmarshal(content, &saved); err != nil {
+		t.Fatalf("report is not JSON: %v", err)
+	}
+	if saved["run_id"] != "20260601-101500" || saved["status"] != "succeeded" {
+		t.Errorf("run_id, status = %v, %v", saved["run_id"], saved["status"])
+	}
+	if _, ok := saved["error"]; ok {
+		t.Error("a successful run should leave out error")
+	}
+	steps, _ := saved["steps"].([]any)
+	if len(steps) != 1 || steps[0].(map[string]any)["log_file"] != "logs/restore_tenant_data.log" {
+		t.Errorf("steps = %v", saved["steps"])
+	}
+	phases, _ := saved["phases"].([]any)
+	if len(phases) != 1 || phases[0].(map[string]any)["name"] != "restore" {
+		t.Errorf("phases = %v", saved["phases"])
+	}
+	if warnings, _ := saved["warnings"].([]any); len(warnings) != 1 {
+		t.Errorf("warnings = %v", saved["warnings"])
+	}
+}
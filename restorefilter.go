@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// extensionMemberQuery lists relations and functions owned by extensions as schema|name
+const extensionMemberQuery = `
+SELECT n.nspname, c.relname
+FROM pg_depend d
+JOIN pg_class c ON d.classid = 'pg_class'::regclass AND d.objid = c.oid
+JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE d.deptype = 'e'
+UNION
+SELECT n.nspname, p.proname
+FROM pg_depend d
+JOIN pg_proc p ON d.classid = 'pg_proc'::regclass AND d.objid = p.oid
+JOIN pg_namespace n ON n.oid = p.pronamespace
+WHERE d.deptype = 'e';`
+
+// extensionMembers returns the schema.name keys of objects owned by extensions in a database
+func extensionMembers(config DBConfig) (map[string]bool, error) {
+	cmd := newPsqlCmd(config, "-t", "-A", "-F", "|", "-c", extensionMemberQuery)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list extension members in %s: %w, output: %s", config.DBName, err, output)
+	}
+
+	members := make(map[string]bool)
+	for _, line := range strings.Split(string(output), "\n") {
+		schema, name, found := strings.Cut(strings.TrimSpace(line), "|")
+		if found {
+			members[schema+"."+name] = true
+		}
+	}
+	return members, nil
+}
+
+// isExtensionOwned reports whether a TOC entry is, or hangs off, an extension member.
+// Constraint and trigger names start with their table; function names end with their
+// argument list. Table data is kept since extensions dump their configuration tables.
+func isExtensionOwned(entry TOCEntry, members map[string]bool) bool {
+	if len(members) == 0 || entry.Desc == "TABLE DATA" || entry.Desc == "SEQUENCE SET" {
+		return false
+	}
+	name := entry.Name
+	if i := strings.Index(name, "("); i >= 0 {
+		name = name[:i]
+	}
+	if first, _, found := strings.Cut(name, " "); found {
+		name = first
+	}
+	return members[entry.Schema+"."+name]
+}
+
+// eventTriggerGuard keeps event triggers from firing while databases are restored:
+// triggers already present in a destination are disabled, and dumped event triggers
+// are held back until the restore is finished
+type eventTriggerGuard struct {
+	mu       sync.Mutex
+	disabled []disabledEventTriggers
+	held     []heldEventTriggers
+}
+
+type disabledEventTriggers struct {
+	config   DBConfig
+	triggers []string
+}
+
+type heldEventTriggers struct {
+	config    DBConfig
+	inputFile string
+	entries   []TOCEntry
+}
+
+// disable turns off the enabled event triggers of a database until finish or restore
+func (g *eventTriggerGuard) disable(config DBConfig) error {
+	cmd := newPsqlCmd(config, "-t", "-A", "-c", "SELECT evtname FROM pg_event_trigger WHERE evtenabled <> 'D' ORDER BY 1;")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to list event triggers in %s: %w, output: %s", config.DBName, err, output)
+	}
+
+	var triggers []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if name := strings.TrimSpace(line); name != "" {
+			triggers = append(triggers, name)
+		}
+	}
+	if len(triggers) == 0 {
+		return nil
+	}
+
+	if err := setEventTriggers(config, triggers, "DISABLE"); err != nil {
+		return err
+	}
+	g.mu.Lock()
+	g.disabled = append(g.disabled, disabledEventTriggers{config: config, triggers: triggers})
+	g.mu.Unlock()
+	log.Printf("Disabled %d event triggers in %s for the restore", len(triggers), config.DBName)
+	return nil
+}
+
+// hold defers restoring dumped event trigger entries until finish
+func (g *eventTriggerGuard) hold(config DBConfig, inputFile string, entries []TOCEntry) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.held = append(g.held, heldEventTriggers{config: config, inputFile: inputFile, entries: entries})
+}
+
+// finish restores held-back event triggers and re-enables disabled ones
+func (g *eventTriggerGuard) finish() error {
+	for _, h := range g.held {
+		log.Printf("Restoring %d event triggers in %s", len(h.entries), h.config.DBName)
+		if err := restoreTOCEntries(h.config, h.inputFile, h.entries); err != nil {
+			return fmt.Errorf("failed to restore event triggers in %s: %w", h.config.DBName, err)
+		}
+	}
+	g.held = nil
+	return g.restore()
+}
+
+// restore re-enables the event triggers disabled by the guard
+func (g *eventTriggerGuard) restore() error {
+	for _, d := range g.disabled {
+		if err := setEventTriggers(d.config, d.triggers, "ENABLE"); err != nil {
+			return err
+		}
+		log.Printf("Re-enabled %d event triggers in %s", len(d.triggers), d.config.DBName)
+	}
+	g.disabled = nil
+	return nil
+}
+
+// setEventTriggers runs ALTER EVENT TRIGGER ... ENABLE or DISABLE for each trigger
+func setEventTriggers(config DBConfig, triggers []string, action string) error {
+	var b strings.Builder
+	for _, name := range triggers {
+		fmt.Fprintf(&b, "ALTER EVENT TRIGGER %s %s;\n", quoteIdent(name), action)
+	}
+	cmd := newPsqlCmd(config, "-v", "ON_ERROR_STOP=1", "-c", b.String())
+	if output, err := runStreaming(cmd, "event_triggers_"+config.DBName, nil); err != nil {
+		return fmt.Errorf("failed to %s event triggers in %s: %w, output: %s",
+			strings.ToLower(action), config.DBName, err, output)
+	}
+	return nil
+}
+
+// restoreFilteredSection restores an archived post-data section, leaving out
+// extension-owned objects and replication objects the options exclude, and holding
+// back event triggers and subscriptions that are restored separately
+func restoreFilteredSection(config DBConfig, inputFile, section string, opts RestoreOptions, guard *eventTriggerGuard) error {
+	entries, err := listTOC(inputFile)
+	if err != nil {
+		return err
+	}
+
+	var members map[string]bool
+	if opts.SkipExtensionObjects {
+		if members, err = extensionMembers(config); err != nil {
+			return err
+		}
+	}
+
+	var publications, subscriptions int
+	var candidates, eventTriggers []TOCEntry
+	filtered := false
+	for _, entry := range entries {
+		switch {
+		case entry.Desc == "PUBLICATION":
+			publications++
+		case entry.Desc == "SUBSCRIPTION":
+			subscriptions++
+		}
+
+		switch {
+		case opts.split != nil && !opts.split.keeps(opts.splitHome, false, entry.Desc, entry.Schema, entry.Name):
+			// Restored into another database of the split
+			filtered = true
+		case isExtensionOwned(entry, members):
+			log.Printf("Skipping extension-owned %s %s", entry.Desc, entry.QualifiedName())
+			activeReport.recordSkipped(config.DBName, entry, "owned by an extension")
+			filtered = true
+		case entry.Desc == "EVENT TRIGGER" && opts.skipEventTriggers:
+			log.Printf("Skipping %s %s, which needs a superuser", entry.Desc, entry.QualifiedName())
+			activeReport.recordSkipped(config.DBName, entry, "needs superuser")
+			filtered = true
+		case entry.Desc == "EVENT TRIGGER" && guard != nil:
+			eventTriggers = append(eventTriggers, entry)
+			filtered = true
+		default:
+			candidates = append(candidates, entry)
+		}
+	}
+
+	policy := opts.Subscriptions
+	if publications > 0 || subscriptions > 0 {
+		log.Printf("Found %d publications and %d subscriptions in %s", publications, subscriptions, inputFile)
+		if subscriptions > 0 && (policy.Mode == "" || policy.Mode == SubscriptionsKeep) {
+			log.Printf("WARNING: restoring subscriptions as dumped; set subscriptions.mode to skip, disable, or rewrite to keep them from pulling from the original publisher")
+		}
+	}
+	if opts.split != nil {
+		kept, err := opts.split.dropCrossingForeignKeys(config, inputFile, opts.splitHome, candidates)
+		if err != nil {
+			return err
+		}
+		filtered = filtered || len(kept) < len(candidates)
+		candidates = kept
+	}
+	keep, deferred, skipped := policy.split(candidates)
+	for _, entry := range skipped {
+		log.Printf("Skipping %s %s", entry.Desc, entry.QualifiedName())
+		activeReport.recordSkipped(config.DBName, entry, "excluded by subscriptions policy")
+	}
+
+	if opts.PostData != nil {
+		if err := restorePostDataPhases(config, inputFile, section, keep, opts); err != nil {
+			return err
+		}
+	} else if !filtered && len(deferred) == 0 && len(skipped) == 0 {
+		return restoreDatabaseSection(config, inputFile, section, opts)
+	} else {
+		listFile, cleanup, err := tempTOCList(keep)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		opts.listFile = listFile
+		if err := restoreDatabaseSection(config, inputFile, section, opts); err != nil {
+			return err
+		}
+	}
+	if len(deferred) > 0 {
+		if err := restoreSubscriptions(config, inputFile, deferred, policy); err != nil {
+			return err
+		}
+	}
+	if len(eventTriggers) > 0 {
+		guard.hold(config, inputFile, eventTriggers)
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestIsExtensionOwned(t *testing.T) {
+	members := map[string]bool{"public.spatial_ref_sys": true, "public.st_area": true}
+	tests := []struct {
+		entry TOCEntry
+		want  bool
+	}{
+		{TOCEntry{Desc: "CONSTRAINT", Schema: "public", Name: "spatial_ref_sys spatial_ref_sys_pkey"}, true},
+		{TOCEntry{Desc: "FUNCTION", Schema: "public", Name: "st_area(geometry)"}, true},
+		{TOCEntry{Desc: "TABLE DATA", Schema: "public", Name: "spatial_ref_sys"}, false},
+		{TOCEntry{Desc: "INDEX", Schema: "public", Name: "idx_customer_transactions_amount"}, false},
+	}
+	for _, tt := range tests {
+		if got := isExtensionOwned(tt.entry, members); got != tt.want {
+			t.Errorf("isExtensionOwned(%s %s) = %v, want %v", tt.entry.Desc, tt.entry.Name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// psqlMessageRe matches "psql:<file>:<line>: ERROR:  <message>" and WARNING lines
+	psqlMessageRe = regexp.MustCompile(`^psql:(.+?):(\d+): (ERROR|WARNING|FATAL):\s+(.*)$`)
+	// pgRestoreTOCRe matches "pg_restore: from TOC entry 3365; 0 16390 TABLE DATA public t postgres"
+	pgRestoreTOCRe = regexp.MustCompile(`^pg_restore: from TOC entry (.*)$`)
+	// pgRestoreMessageRe matches pg_restore error and warning lines, with or without a server ERROR
+	pgRestoreMessageRe = regexp.MustCompile(`^pg_restore: (error|warning): (?:could not execute query: )?(?:(ERROR|WARNING):\s+)?(.*)$`)
+)
+
+// Error policy modes deciding whether a restore step failed. Without a mode, any error
+// that isn't benign fails the step, as does a non-zero exit that reported no errors.
+const (
+	// ErrorModeExitCode trusts the tool's exit status
+	ErrorModeExitCode = "exit-code"
+	// ErrorModeExitOnError stops the tool at the first error
+	ErrorModeExitOnError = "exit-on-error"
+	// ErrorModeIgnorable fails only when a non-ignorable error was reported
+	ErrorModeIgnorable = "ignorable"
+	// ErrorModeStrict fails on any reported error, even when the exit status is zero
+	ErrorModeStrict = "strict"
+)
+
+// benignError is a class of error that restores commonly report without anything
+// being wrong with the restored database
+type benignError struct {
+	Class   string
+	Pattern *regexp.Regexp
+}
+
+// defaultBenignErrors classify errors ignorable when no ignorable patterns are configured
+var defaultBenignErrors = []benignError{
+	// Objects the destination or an earlier pass already has
+	{"already-exists", regexp.MustCompile(`already exists`)},
+	// Owners and grantees that exist on the source cluster only
+	{"missing-role", regexp.MustCompile(`^role ".*" does not exist`)},
+	// COMMENT ON EXTENSION and ALTER SCHEMA public by a non-owner
+	{"not-owner", regexp.MustCompile(`^must be owner of (extension|schema public)`)},
+	// SET lines a newer pg_dump writes for settings an older server doesn't have
+	{"unknown-setting", regexp.MustCompile(`^unrecognized configuration parameter "(transaction_timeout|idle_in_transaction_session_timeout|default_table_access_method|row_security|xmloption)"`)},
+}
+
+// defaultFatalPatterns are errors that fail a step whatever the mode and exit status
+var defaultFatalPatterns = []string{
+	"out of memory",
+	"No space left on device",
+	"could not connect to server",
+	"server closed the connection unexpectedly",
+	"terminating connection",
+	// Statements the watchdog cancelled, which the retry runs again
+	"canceling statement due to user request",
+}
+
+// ErrorPolicy decides whether errors reported by psql or pg_restore fail a step
+type ErrorPolicy struct {
+	Mode string `json:"mode"`
+	// IgnorablePatterns are substrings of error messages counted as ignorable; when set
+	// they replace the built-in benign error classes
+	IgnorablePatterns []string `json:"ignorable_patterns"`
+	// FatalPatterns are substrings of error messages that fail a step in every mode, in
+	// addition to the built-in ones and FATAL messages
+	FatalPatterns []string `json:"fatal_patterns"`
+}
+
+// classify marks a message ignorable with the benign class it belongs to, or fatal
+func (p ErrorPolicy) classify(msg *RestoreMessage) {
+	if msg.Severity == "FATAL" {
+		msg.Fatal = true
+		return
+	}
+	for _, pattern := range append(defaultFatalPatterns, p.FatalPatterns...) {
+		if strings.Contains(msg.Message, pattern) {
+			msg.Fatal = true
+			return
+		}
+	}
+	if len(p.IgnorablePatterns) > 0 {
+		for _, pattern := range p.IgnorablePatterns {
+			if strings.Contains(msg.Message, pattern) {
+				msg.Ignorable, msg.Class = true, pattern
+				return
+			}
+		}
+		return
+	}
+	for _, benign := range defaultBenignErrors {
+		if benign.Pattern.MatchString(msg.Message) {
+			msg.Ignorable, msg.Class = true, benign.Class
+			return
+		}
+	}
+}
+
+// RestoreMessage is a single ERROR or WARNING reported while restoring
+type RestoreMessage struct {
+	Severity  string
+	Message   string
+	TOCEntry  string
+	Statement string
+	Line      int
+	Ignorable bool
+	// Class is the benign class or ignorable pattern an ignorable message matched
+	Class string
+	// Fatal messages fail the step even when the tool exits zero
+	Fatal bool
+}
+
+func (m RestoreMessage) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %s", m.Severity, m.Message)
+	if m.TOCEntry != "" {
+		fmt.Fprintf(&b, " (TOC entry %s)", m.TOCEntry)
+	}
+	if m.Line > 0 {
+		fmt.Fprintf(&b, " (line %d)", m.Line)
+	}
+	if m.Statement != "" {
+		fmt.Fprintf(&b, "\n  Statement: %s", m.Statement)
+	}
+	return b.String()
+}
+
+// RestoreOutput is the parsed output of a restore command
+type RestoreOutput struct {
+	Messages []RestoreMessage
+}
+
+// parseRestoreOutput extracts ERROR and WARNING messages from psql or pg_restore output,
+// attaching the TOC entry or statement each one belongs to
+func (p ErrorPolicy) parseRestoreOutput(output string) *RestoreOutput {
+	result := &RestoreOutput{}
+	var currentTOC string
+	lastIdx := -1
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if match := pgRestoreTOCRe.FindStringSubmatch(line); match != nil {
+			currentTOC = match[1]
+			continue
+		}
+
+		if strings.HasPrefix(line, "Command was: ") && lastIdx >= 0 {
+			result.Messages[lastIdx].Statement = strings.TrimPrefix(line, "Command was: ")
+			continue
+		}
+
+		var msg RestoreMessage
+		if match := psqlMessageRe.FindStringSubmatch(line); match != nil {
+			lineNum, _ := strconv.Atoi(match[2])
+			msg = RestoreMessage{Severity: match[3], Message: match[4], Line: lineNum}
+		} else if match := pgRestoreMessageRe.FindStringSubmatch(line); match != nil {
+			severity := strings.ToUpper(match[1])
+			if match[2] != "" {
+				severity = match[2]
+			}
+			// The closing summary repeats errors already counted
+			if strings.HasPrefix(match[3], "errors ignored on restore") {
+				continue
+			}
+			msg = RestoreMessage{Severity: severity, Message: match[3], TOCEntry: currentTOC}
+		} else {
+			continue
+		}
+
+		p.classify(&msg)
+		result.Messages = append(result.Messages, msg)
+		lastIdx = len(result.Messages) - 1
+	}
+
+	return result
+}
+
+// Errors returns the reported errors, optionally excluding ignorable ones
+func (o *RestoreOutput) Errors(includeIgnorable bool) []RestoreMessage {
+	var errs []RestoreMessage
+	for _, m := range o.Messages {
+		if m.Severity == "WARNING" {
+			continue
+		}
+		if m.Ignorable && !includeIgnorable {
+			continue
+		}
+		errs = append(errs, m)
+	}
+	return errs
+}
+
+// Summary counts errors, ignorable errors, and warnings
+func (o *RestoreOutput) Summary() string {
+	var errs, ignorable, warnings int
+	for _, m := range o.Messages {
+		switch {
+		case m.Severity == "WARNING":
+			warnings++
+		case m.Ignorable:
+			ignorable++
+		default:
+			errs++
+		}
+	}
+	return fmt.Sprintf("%d errors, %d ignorable errors, %d warnings", errs, ignorable, warnings)
+}
+
+// Ignored counts the ignorable errors by class, e.g. "2 missing-role, 1 already-exists"
+func (o *RestoreOutput) Ignored() string {
+	counts := make(map[string]int)
+	var classes []string
+	for _, m := range o.Messages {
+		if m.Severity == "WARNING" || !m.Ignorable {
+			continue
+		}
+		if counts[m.Class] == 0 {
+			classes = append(classes, m.Class)
+		}
+		counts[m.Class]++
+	}
+	var parts []string
+	for _, class := range classes {
+		parts = append(parts, fmt.Sprintf("%d %s", counts[class], class))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// fatal returns the messages that fail a step in every mode
+func (o *RestoreOutput) fatal() []RestoreMessage {
+	var fatal []RestoreMessage
+	for _, m := range o.Messages {
+		if m.Fatal {
+			fatal = append(fatal, m)
+		}
+	}
+	return fatal
+}
+
+// RestoreError reports the errors that failed a restore step
+type RestoreError struct {
+	Errors []RestoreMessage
+	Output *RestoreOutput
+}
+
+func (e *RestoreError) Error() string {
+	const maxShown = 5
+	var b strings.Builder
+	fmt.Fprintf(&b, "restore reported %s", e.Output.Summary())
+	for i, m := range e.Errors {
+		if i == maxShown {
+			fmt.Fprintf(&b, "\n  ... and %d more", len(e.Errors)-maxShown)
+			break
+		}
+		fmt.Fprintf(&b, "\n  %s", m)
+	}
+	return b.String()
+}
+
+// extraArgs returns the tool flags implementing the policy
+func (p ErrorPolicy) extraArgs(tool string) []string {
+	if p.Mode != ErrorModeExitOnError {
+		return nil
+	}
+	if tool == "pg_restore" {
+		return []string{"--exit-on-error"}
+	}
+	return []string{"-v", "ON_ERROR_STOP=1"}
+}
+
+func (p ErrorPolicy) validate() error {
+	switch p.Mode {
+	case "", ErrorModeExitCode, ErrorModeExitOnError, ErrorModeIgnorable, ErrorModeStrict:
+		return nil
+	}
+	return fmt.Errorf("unknown mode %q: use %s, %s, %s, or %s", p.Mode, ErrorModeExitCode, ErrorModeExitOnError, ErrorModeIgnorable, ErrorModeStrict)
+}
+
+// Evaluate decides whether a step failed given its exit error and output
+func (p ErrorPolicy) Evaluate(runErr error, output string) error {
+	parsed := p.parseRestoreOutput(output)
+	if fatal := parsed.fatal(); len(fatal) > 0 {
+		return &RestoreError{Errors: fatal, Output: parsed}
+	}
+
+	switch p.Mode {
+	case ErrorModeExitCode, ErrorModeExitOnError:
+		if runErr == nil {
+			return nil
+		}
+		if errs := parsed.Errors(true); len(errs) > 0 {
+			return &RestoreError{Errors: errs, Output: parsed}
+		}
+		return runErr
+	case "", ErrorModeIgnorable:
+		// psql exits zero after failed statements unless ON_ERROR_STOP is set
+		if errs := parsed.Errors(false); len(errs) > 0 {
+			return &RestoreError{Errors: errs, Output: parsed}
+		}
+		if runErr != nil && len(parsed.Errors(true)) == 0 {
+			// Failed without reporting anything parseable, e.g. a connection failure
+			return runErr
+		}
+		if ignored := parsed.Ignored(); ignored != "" {
+			log.Printf("Ignored benign restore errors: %s", ignored)
+		}
+		return nil
+	case ErrorModeStrict:
+		if errs := parsed.Errors(true); len(errs) > 0 {
+			return &RestoreError{Errors: errs, Output: parsed}
+		}
+		return runErr
+	default:
+		return fmt.Errorf("unknown error policy mode %q", p.Mode)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+const sampleRestoreOutput = `pg_restore: while PROCESSING TOC:
+pg_restore: from TOC entry 215; 1259 16390 TABLE public customer_transactions postgres
+pg_restore: error: could not execute query: ERROR:  relation "customer_transactions" already exists
+Command was: CREATE TABLE public.customer_transactions (id integer NOT NULL);
+pg_restore: from TOC entry 3218; 2606 16397 CONSTRAINT public customer_transactions customer_transactions_pkey postgres
+pg_restore: error: could not execute query: ERROR:  could not create unique index "customer_transactions_pkey"
+Command was: ALTER TABLE ONLY public.customer_transactions ADD CONSTRAINT customer_transactions_pkey PRIMARY KEY (id);
+pg_restore: warning: errors ignored on restore: 2
+`
+
+func TestParseRestoreOutput(t *testing.T) {
+	parsed := ErrorPolicy{}.parseRestoreOutput(sampleRestoreOutput)
+	if len(parsed.Messages) != 2 {
+		t.Fatalf("expected 2 messages, got %d: %+v", len(parsed.Messages), parsed.Messages)
+	}
+
+	first := parsed.Messages[0]
+	if !first.Ignorable || first.Severity != "ERROR" {
+		t.Errorf("expected ignorable ERROR, got %+v", first)
+	}
+	if first.TOCEntry != "215; 1259 16390 TABLE public customer_transactions postgres" {
+		t.Errorf("unexpected TOC entry %q", first.TOCEntry)
+	}
+	if first.Statement != "CREATE TABLE public.customer_transactions (id integer NOT NULL);" {
+		t.Errorf("unexpected statement %q", first.Statement)
+	}
+
+	if parsed.Messages[1].Ignorable {
+		t.Errorf("index failure should not be ignorable")
+	}
+	if got := parsed.Summary(); got != "1 errors, 1 ignorable errors, 0 warnings" {
+		t.Errorf("unexpected summary %q", got)
+	}
+}
+
+func TestErrorPolicyEvaluate(t *testing.T) {
+	exitErr := errors.New("exit status 1")
+	onlyIgnorable := "psql:pre.sql:12: ERROR:  extension \"postgres_fdw\" already exists\n"
+
+	tests := []struct {
+		mode    string
+		runErr  error
+		output  string
+		wantErr bool
+	}{
+		{"", exitErr, onlyIgnorable, false},
+		{"", exitErr, sampleRestoreOutput, true},
+		{"", exitErr, "", true},
+		{"", nil, onlyIgnorable, false},
+		{"", nil, "psql:pre.sql:30: ERROR:  type \"x\" does not exist\n", true},
+		{ErrorModeExitCode, nil, onlyIgnorable, false},
+		{ErrorModeExitCode, exitErr, onlyIgnorable, true},
+		{ErrorModeExitCode, exitErr, sampleRestoreOutput, true},
+		{ErrorModeIgnorable, exitErr, onlyIgnorable, false},
+		{ErrorModeIgnorable, exitErr, sampleRestoreOutput, true},
+		{ErrorModeIgnorable, exitErr, "could not connect to server\n", true},
+		{ErrorModeStrict, nil, onlyIgnorable, true},
+	}
+	for _, tt := range tests {
+		err := ErrorPolicy{Mode: tt.mode}.Evaluate(tt.runErr, tt.output)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("mode %s with output %q: got error %v, wantErr %t", tt.mode, tt.output, err, tt.wantErr)
+		}
+	}
+}
+
+func TestErrorPolicyValidate(t *testing.T) {
+	for _, mode := range []string{"", ErrorModeExitCode, ErrorModeExitOnError, ErrorModeIgnorable, ErrorModeStrict} {
+		if err := (ErrorPolicy{Mode: mode}).validate(); err != nil {
+			t.Errorf("mode %q: %v", mode, err)
+		}
+	}
+	if err := (ErrorPolicy{Mode: "ignoreable"}).validate(); err == nil {
+		t.Error("misspelled mode accepted")
+	}
+}
+
+func TestBenignErrorClasses(t *testing.T) {
+	output := `psql:pre.sql:3: ERROR:  unrecognized configuration parameter "transaction_timeout"
+psql:pre.sql:40: ERROR:  role "etl_owner" does not exist
+psql:pre.sql:41: ERROR:  role "reporting" does not exist
+psql:pre.sql:52: ERROR:  must be owner of extension plpgsql
+psql:pre.sql:60: ERROR:  extension "postgres_fdw" already exists
+`
+	parsed := ErrorPolicy{}.parseRestoreOutput(output)
+	if got := parsed.Ignored(); got != "1 unknown-setting, 2 missing-role, 1 not-owner, 1 already-exists" {
+		t.Errorf("Ignored() = %q", got)
+	}
+	if err := (ErrorPolicy{Mode: ErrorModeIgnorable}).Evaluate(errors.New("exit status 3"), output); err != nil {
+		t.Errorf("benign errors failed the step: %v", err)
+	}
+
+	custom := ErrorPolicy{Mode: ErrorModeIgnorable, IgnorablePatterns: []string{"already exists"}}
+	if err := custom.Evaluate(errors.New("exit status 3"), output); err == nil {
+		t.Error("configured patterns should replace the built-in classes")
+	}
+}
+
+func TestFatalRestoreErrors(t *testing.T) {
+	fatal := "psql:pre.sql:9: FATAL:  terminating connection due to administrator command\n"
+	if err := (ErrorPolicy{}).Evaluate(nil, fatal); err == nil {
+		t.Error("FATAL message passed with exit status zero")
+	}
+	full := "pg_restore: error: could not execute query: ERROR:  could not extend file: No space left on device\n"
+	policy := ErrorPolicy{Mode: ErrorModeIgnorable, IgnorablePatterns: []string{"could not extend"}}
+	if err := policy.Evaluate(nil, full); err == nil {
+		t.Error("fatal pattern was ignored")
+	}
+	policy.FatalPatterns = []string{"deadlock detected"}
+	if err := policy.Evaluate(nil, "psql:data.sql:2: ERROR:  deadlock detected\n"); err == nil {
+		t.Error("configured fatal pattern passed")
+	}
+}
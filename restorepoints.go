@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// RestorePoint records a named restore point created on a destination cluster
+type RestorePoint struct {
+	Name    string `json:"name"`
+	Cluster string `json:"cluster"`
+	LSN     string `json:"lsn"`
+	// WALFile is the WAL segment holding the restore point
+	WALFile   string    `json:"wal_file"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// restorePointQuery creates a restore point and reports where it is in the WAL, and
+// whether WAL is archived so the point can be recovered to
+const restorePointQuery = `SELECT lsn, pg_walfile_name(lsn), current_setting('archive_mode')
+FROM pg_create_restore_point(%s) AS lsn;`
+
+// restorePointName names the restore point taken before or after a run
+func restorePointName(runID, when string) string {
+	return fmt.Sprintf("pg_restore_fdw_%s_%s", runID, when)
+}
+
+// clusterOf identifies the cluster a connection reaches
+func clusterOf(config DBConfig) string {
+	return net.JoinHostPort(config.dialHost(), config.dialPort())
+}
+
+// distinctClusters returns one connection per cluster, so databases sharing a cluster
+// get a single restore point
+func distinctClusters(configs []DBConfig) []DBConfig {
+	seen := make(map[string]bool)
+	var clusters []DBConfig
+	for _, config := range configs {
+		if cluster := clusterOf(config); !seen[cluster] {
+			seen[cluster] = true
+			clusters = append(clusters, config)
+		}
+	}
+	return clusters
+}
+
+// CreateRestorePoints creates a named restore point on each destination cluster and
+// logs its LSN, so the clusters can be recovered to just before or after a run
+func CreateRestorePoints(dests []DBConfig, runID, when string) error {
+	name := restorePointName(runID, when)
+	for _, config := range distinctClusters(dests) {
+		rows, err := queryRows(maintenanceConfig(config), fmt.Sprintf(restorePointQuery, quoteLiteral(name)))
+		if err != nil {
+			return fmt.Errorf("failed to create restore point %s on %s: %w", name, clusterOf(config), err)
+		}
+		if len(rows) != 1 || len(rows[0]) != 3 {
+			return fmt.Errorf("unexpected output creating restore point %s on %s: %v", name, clusterOf(config), rows)
+		}
+		point := RestorePoint{Name: name, Cluster: clusterOf(config), LSN: rows[0][0], WALFile: rows[0][1], CreatedAt: time.Now().UTC()}
+		activeReport.recordRestorePoint(point)
+		alwaysLog.Printf("Created restore point %s on %s at LSN %s (WAL file %s)", point.Name, point.Cluster, point.LSN, point.WALFile)
+		if rows[0][2] == "off" {
+			alwaysLog.Printf("WARNING: archive_mode is off on %s; recovering to %s needs the WAL to be archived", point.Cluster, point.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+package main
+
+import "testing"
+
+func TestDistinctClusters(t *testing.T) {
+	configs := []DBConfig{
+		{Host: "db1", Port: "5432", DBName: "moodys"},
+		{Host: "db1", Port: "5432", DBName: "tenant"},
+		{Host: "db2", Port: "5432", DBName: "tenant"},
+	}
+	clusters := distinctClusters(configs)
+	if len(clusters) != 2 || clusters[0].DBName != "moodys" || clusterOf(clusters[1]) != "db2:5432" {
+		t.Errorf("distinctClusters = %+v", clusters)
+	}
+	if got := restorePointName("20260101-120000", "before"); got != "pg_restore_fdw_20260101-120000_before" {
+		t.Errorf("restorePointName = %q", got)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// diffPasswordRe matches password options in FDW server and user mapping definitions
+var diffPasswordRe = regexp.MustCompile(`(password\s+)'(?:[^']|'')*'`)
+
+// unifiedDiff returns the unified diff from original to rewritten, labelled with name,
+// with passwords redacted; it is empty when the files are the same
+func unifiedDiff(name, original, rewritten string) (string, error) {
+	output, err := exec.Command("diff", "-u", "--label", "a/"+name, "--label", "b/"+name, original, rewritten).Output()
+	// diff exits 1 when the files differ
+	var exitErr *exec.ExitError
+	if err != nil && !(errors.As(err, &exitErr) && exitErr.ExitCode() == 1) {
+		return "", fmt.Errorf("failed to diff %s: %w", name, err)
+	}
+	return diffPasswordRe.ReplaceAllString(string(output), "${1}'"+redacted+"'"), nil
+}
+
+// diffStat counts the lines a unified diff removes and adds
+func diffStat(diff string) (removed, added int) {
+	for _, line := range strings.Split(diff, "\n") {
+		switch {
+		case strings.HasPrefix(line, "---") || strings.HasPrefix(line, "+++"):
+		case strings.HasPrefix(line, "-"):
+			removed++
+		case strings.HasPrefix(line, "+"):
+			added++
+		}
+	}
+	return removed, added
+}
+
+// rewriteReview keeps a copy of a plain section as dumped, so the changes made to it
+// before restore can be saved as a diff for review once every rewrite is done
+type rewriteReview struct {
+	name string
+	copy string
+}
+
+// reviewRewrites copies a section before it is rewritten. Archives, compressed, and
+// split sections aren't rewritten in place, so they yield nil, which save ignores.
+func reviewRewrites(inputFile string) (*rewriteReview, error) {
+	format, err := DetectArtifactFormat(inputFile)
+	if err != nil {
+		return nil, err
+	}
+	if format.Kind != FormatPlain || format.Compression != "" || isPartsIndex(inputFile) {
+		return nil, nil
+	}
+	in, err := os.Open(inputFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", inputFile, err)
+	}
+	defer in.Close()
+	out, err := os.CreateTemp("", "pg_restore_fdw_original_*.sql")
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy %s for review: %w", inputFile, err)
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, in); err != nil {
+		os.Remove(out.Name())
+		return nil, fmt.Errorf("failed to copy %s for review: %w", inputFile, err)
+	}
+	return &rewriteReview{name: filepath.Base(inputFile), copy: out.Name()}, nil
+}
+
+// save writes the diff from the copy to the rewritten section next to the step logs as
+// <section>.diff and logs how much changed
+func (r *rewriteReview) save(rewritten string) error {
+	if r == nil {
+		return nil
+	}
+	diff, err := unifiedDiff(r.name, r.copy, rewritten)
+	if err != nil {
+		return err
+	}
+	if diff == "" {
+		debugf("No rewrites were made to %s", r.name)
+		return nil
+	}
+	removed, added := diffStat(diff)
+	debugf("Rewrites made to %s:\n%s", r.name, diff)
+	if stepLogDir == "" {
+		log.Printf("Rewrote %s: %d lines removed, %d added", r.name, removed, added)
+		return nil
+	}
+	path := filepath.Join(stepLogDir, strings.TrimSuffix(r.name, filepath.Ext(r.name))+".diff")
+	if err := os.WriteFile(path, []byte(diff), 0600); err != nil {
+		return fmt.Errorf("failed to save the rewrites of %s: %w", r.name, err)
+	}
+	log.Printf("Rewrote %s: %d lines removed, %d added; diff saved to %s", r.name, removed, added, path)
+	return nil
+}
+
+// close removes the copy
+func (r *rewriteReview) close() {
+	if r != nil {
+		os.Remove(r.copy)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const reviewedPreData = `CREATE SERVER moodys_server FOREIGN DATA WRAPPER postgres_fdw OPTIONS (
+    dbname 'moodys',
+    host 'localhost',
+    port '5432'
+);
+CREATE USER MAPPING FOR postgres SERVER moodys_server OPTIONS (
+    "user" 'postgres',
+    password 'source_secret'
+);
+`
+
+func TestRewriteReview(t *testing.T) {
+	dir := t.TempDir()
+	logs := t.TempDir()
+	defer func(previous string) { stepLogDir = previous }(stepLogDir)
+	stepLogDir = logs
+
+	input := filepath.Join(dir, "tenant_pre-data.sql")
+	if err := os.WriteFile(input, []byte(reviewedPreData), 0644); err != nil {
+		t.Fatal(err)
+	}
+	review, err := reviewRewrites(input)
+	if err != nil || review == nil {
+		t.Fatalf("reviewRewrites = %v, %v", review, err)
+	}
+	defer review.close()
+	src := DBConfig{DBName: "moodys", Host: "localhost", Port: "5432", User: "postgres", Password: "source_secret"}
+	dest := DBConfig{DBName: "moodys_restored", Host: "db2", Port: "5432", User: "postgres", Password: "dest_secret"}
+	if err := modifyPreDataFile(input, src, dest); err != nil {
+		t.Fatal(err)
+	}
+	if err := review.save(input); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := os.ReadFile(filepath.Join(logs, "tenant_pre-data.diff"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff := string(saved)
+	for _, want := range []string{"--- a/tenant_pre-data.sql", "-    dbname 'moodys',", "+    dbname 'moodys_restored',", "+    host 'db2',"} {
+		if !strings.Contains(diff, want) {
+			t.Errorf("diff lacks %q:\n%s", want, diff)
+		}
+	}
+	if strings.Contains(diff, "secret") {
+		t.Errorf("diff shows a password:\n%s", diff)
+	}
+	if removed, added := diffStat(diff); removed != 3 || added != 3 {
+		t.Errorf("diffStat = -%d +%d", removed, added)
+	}
+
+	var unset *rewriteReview
+	if err := unset.save(input); err != nil {
+		t.Error(err)
+	}
+	unset.close()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"strings"
+)
+
+// runLockNamespace is the first key of every run lock, so they don't collide with
+// advisory locks taken by applications
+const runLockNamespace = "pg_restore_fdw"
+
+// tryRunLockQuery takes the run lock of a destination database without waiting
+const tryRunLockQuery = `SELECT pg_try_advisory_lock(hashtext(%s), hashtext(%s));`
+
+// runLockHolderQuery describes the session holding the run lock of a destination database
+const runLockHolderQuery = `SELECT a.pid, a.usename, a.application_name, coalesce(a.client_addr::text, 'local'),
+	to_char(a.backend_start, 'YYYY-MM-DD HH24:MI:SS TZ')
+FROM pg_locks l JOIN pg_stat_activity a ON a.pid = l.pid
+WHERE l.locktype = 'advisory' AND l.granted AND l.objsubid = 2
+	AND l.classid = hashtext(%s)::oid AND l.objid = hashtext(%s)::oid;`
+
+// runLock holds advisory locks on the destination databases for as long as a run lasts.
+// Each cluster gets one session, and the locks go away with it, also when the process dies.
+type runLock struct {
+	sessions []*runLockSession
+}
+
+type runLockSession struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+}
+
+// lockHolder formats a runLockHolderQuery row for the error a locked-out run fails with
+func lockHolder(row []string) string {
+	if len(row) != 5 {
+		return "an unknown session"
+	}
+	holder := fmt.Sprintf("pid %s (user %s from %s, connected %s)", row[0], row[1], row[3], row[4])
+	if row[2] != "" {
+		holder = fmt.Sprintf("%s, %s", row[2], holder)
+	}
+	return holder
+}
+
+// acquireRunLock takes the run lock of each destination database, failing fast with the
+// holder when another run has one. The locks are taken in the clusters' postgres
+// database, since the destinations may not exist yet or get dropped during the run.
+func acquireRunLock(dests []DBConfig, runID string) (*runLock, error) {
+	lock := &runLock{}
+	for _, cluster := range distinctClusters(dests) {
+		config := maintenanceConfig(cluster)
+		// The holder shows up in pg_stat_activity under the run's ID
+		config.appName = "pg_restore_fdw run " + runID
+		cmd := newPsqlCmd(config, "-q", "-t", "-A", "-v", "ON_ERROR_STOP=1")
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			lock.Release()
+			return nil, fmt.Errorf("failed to open run lock session input: %w", err)
+		}
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			lock.Release()
+			return nil, fmt.Errorf("failed to open run lock session output: %w", err)
+		}
+		var stderr strings.Builder
+		cmd.Stderr = &stderr
+		if err := cmd.Start(); err != nil {
+			lock.Release()
+			return nil, fmt.Errorf("failed to start run lock session on %s: %w", clusterOf(config), err)
+		}
+		lock.sessions = append(lock.sessions, &runLockSession{cmd: cmd, stdin: stdin})
+
+		reader := bufio.NewReader(stdout)
+		for _, dest := range dests {
+			if clusterOf(dest) != clusterOf(config) {
+				continue
+			}
+			fmt.Fprintf(stdin, tryRunLockQuery+"\n", quoteLiteral(runLockNamespace), quoteLiteral(dest.DBName))
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				lock.Release()
+				return nil, fmt.Errorf("failed to lock %s on %s: %w\nOutput: %s", dest.DBName, clusterOf(config), err, stderr.String())
+			}
+			if strings.TrimSpace(line) == "t" {
+				log.Printf("Locked destination %s on %s for run %s", dest.DBName, clusterOf(config), runID)
+				continue
+			}
+			lock.Release()
+			holder := "an unknown session"
+			rows, err := queryRows(config, fmt.Sprintf(runLockHolderQuery, quoteLiteral(runLockNamespace), quoteLiteral(dest.DBName)))
+			if err == nil && len(rows) > 0 {
+				holder = lockHolder(rows[0])
+			}
+			return nil, fmt.Errorf("destination %s on %s is in use by another run: %s", dest.DBName, clusterOf(config), holder)
+		}
+	}
+	return lock, nil
+}
+
+// Release ends the lock sessions, which releases their locks
+func (l *runLock) Release() {
+	if l == nil {
+		return
+	}
+	for _, s := range l.sessions {
+		s.stdin.Close()
+		s.cmd.Wait()
+	}
+	l.sessions = nil
+}
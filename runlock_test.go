@@ -0,0 +1,14 @@
+package main
+
+import "testing"
+
+func TestLockHolder(t *testing.T) {
+	row := []string{"4242", "deploy", "pg_restore_fdw run 20260101-120000", "10.0.0.5/32", "2026-01-01 12:00:01 UTC"}
+	want := "pg_restore_fdw run 20260101-120000, pid 4242 (user deploy from 10.0.0.5/32, connected 2026-01-01 12:00:01 UTC)"
+	if got := lockHolder(row); got != want {
+		t.Errorf("lockHolder = %q, want %q", got, want)
+	}
+	if got := lockHolder(nil); got != "an unknown session" {
+		t.Errorf("lockHolder(nil) = %q", got)
+	}
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// RunPlan describes everything a run will do, written by -plan for review and
+// executed by apply. Args and the configuration's checksum pin the run to the plan.
+type RunPlan struct {
+	CreatedAt time.Time `json:"created_at"`
+	// Args are the command line the run is applied with
+	Args         []string   `json:"args"`
+	ConfigFile   string     `json:"config_file,omitempty"`
+	ConfigSHA256 string     `json:"config_sha256,omitempty"`
+	Steps        []PlanStep `json:"steps"`
+	// Fingerprint covers Args, ConfigSHA256, and Steps, so edits to a plan are caught
+	Fingerprint string `json:"fingerprint"`
+}
+
+// PlanStep is one action of a run phase
+type PlanStep struct {
+	Phase  string `json:"phase"`
+	Action string `json:"action"`
+	Target string `json:"target,omitempty"`
+	// Destructive marks actions that drop, replace, or overwrite existing data
+	Destructive bool `json:"destructive,omitempty"`
+	// Rewrites are the changes made to dumped definitions on the way in
+	Rewrites []string `json:"rewrites,omitempty"`
+}
+
+// PlanInputs are the decisions main makes before a run starts
+type PlanInputs struct {
+	DumpOnly, RestoreOnly, Migrating bool
+	Incremental, Clone, CDC          bool
+	DiscoverFDW, RestorePoints       bool
+	CheckForeignKeys                 bool
+	ValidateCatalog                  bool
+	GrantsDryRun                     bool
+	// Upload and Download report whether dump sets go through configured storage
+	Upload, Download bool
+	Databases        []string
+	Sources, Dests   map[string]DBConfig
+	Cutover          []CutoverTarget
+	DropPrevious     bool
+	DumpDir          string
+	ServerName       string
+	Config           *Config
+}
+
+// planTarget names a database with the cluster it is on
+func planTarget(config DBConfig) string {
+	return fmt.Sprintf("%s on %s", config.DBName, clusterOf(config))
+}
+
+// BuildRunPlan lists the steps of a run in the order main runs its phases, with each
+// configured hook next to the phase it wraps
+func BuildRunPlan(in PlanInputs) []PlanStep {
+	cfg := in.Config
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	var steps []PlanStep
+	hooks := func(name, when string) {
+		for _, hook := range cfg.Hooks {
+			if hook.Phase == name && hook.When == when {
+				steps = append(steps, PlanStep{Phase: name, Action: fmt.Sprintf("run %s hook %s", when, hook.Name)})
+			}
+		}
+	}
+	phase := func(name string, actions ...PlanStep) {
+		hooks(name, HookBefore)
+		for _, action := range actions {
+			action.Phase = name
+			steps = append(steps, action)
+		}
+		hooks(name, HookAfter)
+	}
+	each := func(configs map[string]DBConfig, action string, destructive bool) []PlanStep {
+		var actions []PlanStep
+		for _, name := range []string{"moodys", "tenant"} {
+			if includesDatabase(in.Databases, name) {
+				actions = append(actions, PlanStep{Action: action, Target: planTarget(configs[name]), Destructive: destructive})
+			}
+		}
+		return actions
+	}
+
+	restorePoints := in.RestorePoints && !in.DumpOnly
+	if restorePoints {
+		phase("restore_point_before", each(in.Dests, "create restore point", false)...)
+	}
+	// The closing restore point comes after everything else, also after runs that stop early
+	func() {
+		if cfg.Workflow != nil {
+			var actions []PlanStep
+			for _, step := range cfg.Workflow.Steps {
+				actions = append(actions, PlanStep{Action: fmt.Sprintf("%s step %s", step.Action, step.Name), Target: step.Database, Destructive: step.Action == "restore"})
+			}
+			if len(actions) == 0 {
+				actions = append(actions, PlanStep{Action: "discover step order from foreign servers, then dump and restore every workflow database", Destructive: true})
+			}
+			phase("workflow", actions...)
+			return
+		}
+
+		if in.Incremental {
+			var actions []PlanStep
+			for _, table := range cfg.Incremental {
+				actions = append(actions, PlanStep{
+					Action:      fmt.Sprintf("merge rows changed since the %s watermark", table.Column),
+					Target:      fmt.Sprintf("%s in %s", table.Table, planTarget(in.Dests[table.Database])),
+					Destructive: true,
+				})
+			}
+			phase("incremental", actions...)
+		} else {
+			if !in.Migrating && !in.DumpOnly && !in.RestoreOnly {
+				var drops []PlanStep
+				for _, config := range []DBConfig{in.Sources["moodys"], in.Sources["tenant"], in.Dests["moodys"], in.Dests["tenant"]} {
+					drops = append(drops, PlanStep{Action: "DROP DATABASE", Target: planTarget(config), Destructive: true})
+				}
+				for _, t := range cfg.SchemaSplit {
+					drops = append(drops, PlanStep{Action: "DROP DATABASE", Target: planTarget(t.config(in.Dests["tenant"])), Destructive: true})
+				}
+				phase("cleanup", drops...)
+				phase("setup", each(in.Sources, "create and fill source database", true)...)
+			}
+			if in.DiscoverFDW && !in.Clone {
+				phase("discover", PlanStep{Action: "read foreign server targets from the source catalogs into " + in.DumpDir})
+			}
+			if !in.RestoreOnly && !in.Clone {
+				phase("dump", each(in.Sources, "pg_dump pre-data, data, and post-data into "+in.DumpDir, false)...)
+				if in.Upload {
+					phase("upload", PlanStep{Action: "upload the dump set in " + in.DumpDir})
+				}
+			}
+			if in.DumpOnly {
+				return
+			}
+			if in.Download {
+				phase("download", PlanStep{Action: "download the dump set into " + in.DumpDir, Destructive: true})
+			}
+
+			if in.Clone {
+				phase("clone", each(in.Dests, "CREATE DATABASE from the source as template", false)...)
+			} else {
+				var actions []PlanStep
+				if in.RestoreOnly || in.Migrating {
+					for _, t := range in.Cutover {
+						actions = append(actions, PlanStep{Action: "DROP DATABASE left by an earlier run", Target: planTarget(t.Staging), Destructive: true})
+					}
+				}
+				for _, name := range []string{"moodys", "tenant"} {
+					if !includesDatabase(in.Databases, name) {
+						continue
+					}
+					target := planTarget(in.Dests[name])
+					actions = append(actions,
+						PlanStep{Action: "CREATE DATABASE", Target: target},
+						PlanStep{Action: "pg_restore pre-data, data, and post-data from " + in.DumpDir, Target: target, Rewrites: planRewrites(name, in)})
+					if cfg.OnRestoreFailure == FailureDrop {
+						actions = append(actions, PlanStep{Action: "DROP DATABASE if the restore fails", Target: target, Destructive: true})
+					}
+				}
+				if includesDatabase(in.Databases, "tenant") {
+					for _, t := range cfg.SchemaSplit {
+						target := planTarget(t.config(in.Dests["tenant"]))
+						actions = append(actions,
+							PlanStep{Action: "CREATE DATABASE", Target: target},
+							PlanStep{Action: "pg_restore tenant schemas " + strings.Join(t.Schemas, ", ") + " from " + in.DumpDir, Target: target})
+					}
+				}
+				phase("restore", actions...)
+			}
+			if in.CDC {
+				phase("cdc", each(in.Dests, "replay changes made since the dump", true)...)
+			}
+		}
+
+		if in.CheckForeignKeys {
+			phase("check_foreign_keys", each(in.Dests, "look for rows violating foreign keys", false)...)
+		}
+		if len(cfg.Grants) > 0 {
+			action := fmt.Sprintf("apply %d grant templates", len(cfg.Grants))
+			if in.GrantsDryRun {
+				action = fmt.Sprintf("print %d grant templates", len(cfg.Grants))
+			}
+			phase("grants", each(in.Dests, action, false)...)
+		}
+		if cfg.AppRole != nil {
+			phase("app_role", each(in.Dests, "check read access as "+cfg.AppRole.User, false)...)
+		}
+		if len(cfg.QueryPack) > 0 {
+			phase("query_pack", PlanStep{Action: fmt.Sprintf("run %d query checks", len(cfg.QueryPack))})
+		}
+		if in.ValidateCatalog {
+			phase("validate_catalog", each(in.Dests, "compare with the source catalog in the manifest", false)...)
+		}
+		phase("validate", each(in.Dests, "compare restored data with the source", false)...)
+		if includesDatabase(in.Databases, "tenant") {
+			if len(cfg.BehaviorChecks) > 0 {
+				phase("validate_behavior", PlanStep{Action: fmt.Sprintf("run %d behavior checks", len(cfg.BehaviorChecks)), Target: planTarget(in.Dests["tenant"])})
+			}
+		}
+		if cfg.Prewarm != nil {
+			phase("prewarm", each(in.Dests, "load the largest tables into cache with pg_prewarm", false)...)
+		}
+		if cfg.Replay != nil {
+			phase("replay", PlanStep{Action: "replay the queries in " + cfg.Replay.File})
+		}
+
+		if len(in.Cutover) > 0 {
+			var actions []PlanStep
+			for _, t := range in.Cutover {
+				live := t.Staging
+				live.DBName = t.Live
+				action := "rename to " + t.Live + "_previous_<timestamp>"
+				if in.DropPrevious {
+					action = "DROP DATABASE"
+				}
+				actions = append(actions,
+					PlanStep{Action: action, Target: planTarget(live), Destructive: true},
+					PlanStep{Action: "rename to " + t.Live, Target: planTarget(t.Staging)})
+			}
+			phase("cutover", actions...)
+		}
+	}()
+	if restorePoints {
+		phase("restore_point_after", each(in.Dests, "create restore point", false)...)
+	}
+	return steps
+}
+
+// planRewrites lists the changes the restore makes to a database's dumped definitions
+func planRewrites(name string, in PlanInputs) []string {
+	cfg := in.Config
+	var rewrites []string
+	if name == "tenant" {
+		target := fmt.Sprintf("%s on %s", in.Dests["moodys"].DBName, clusterOf(in.Dests["moodys"]))
+		if cfg.FDWTarget != nil {
+			target = describeTarget(*cfg.FDWTarget)
+		}
+		if in.DiscoverFDW {
+			target = "the targets found by discovery"
+		}
+		rewrites = append(rewrites, fmt.Sprintf("point foreign server %s at %s", in.ServerName, target))
+		for _, t := range cfg.SchemaSplit {
+			rewrites = append(rewrites, fmt.Sprintf("import schemas %s from %s through foreign server %s", strings.Join(t.Schemas, ", "), t.DBName, t.server()))
+		}
+		if cfg.ImportForeignSchema != nil {
+			rewrites = append(rewrites, "import the foreign schema instead of restoring dumped foreign tables")
+		}
+		if cfg.Naming.Schema != "" {
+			rewrites = append(rewrites, "rename schemas from template "+cfg.Naming.Schema)
+		}
+	}
+	for _, rule := range cfg.Renames {
+		if rule.Database == "" || rule.Database == name {
+			rewrites = append(rewrites, fmt.Sprintf("rename %s to %s", strings.Trim(rule.Schema+"."+rule.Table, "."), rule.To))
+		}
+	}
+	if cfg.Compat != nil {
+		rewrites = append(rewrites, "apply compatibility rules for the destination's major version")
+	}
+	if cfg.Provider != nil {
+		rewrites = append(rewrites, "adapt definitions to "+cfg.Provider.Name)
+	}
+	return rewrites
+}
+
+// planFingerprint hashes what apply checks a plan against
+func planFingerprint(plan RunPlan) string {
+	content, _ := json.Marshal(struct {
+		Args         []string
+		ConfigSHA256 string
+		Steps        []PlanStep
+	}{plan.Args, plan.ConfigSHA256, plan.Steps})
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// fileSHA256 returns the hex SHA-256 of a file; empty for no file
+func fileSHA256(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// planArgs removes -plan from a command line, leaving the arguments apply runs with
+func planArgs(args []string) []string {
+	var kept []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "-plan" || arg == "--plan":
+			i++
+		case strings.HasPrefix(arg, "-plan=") || strings.HasPrefix(arg, "--plan="):
+		default:
+			kept = append(kept, arg)
+		}
+	}
+	return kept
+}
+
+// NewRunPlan builds a plan for the command line and configuration file
+func NewRunPlan(args []string, configFile string, steps []PlanStep) (RunPlan, error) {
+	plan := RunPlan{CreatedAt: time.Now().UTC(), Args: planArgs(args), ConfigFile: configFile, Steps: steps}
+	var err error
+	if plan.ConfigSHA256, err = fileSHA256(configFile); err != nil {
+		return plan, fmt.Errorf("failed to read configuration file: %w", err)
+	}
+	plan.Fingerprint = planFingerprint(plan)
+	return plan, nil
+}
+
+// WritePlan saves a plan as indented JSON
+func WritePlan(path string, plan RunPlan) error {
+	content, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(content, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write plan: %w", err)
+	}
+	return nil
+}
+
+// LoadPlan reads a plan and checks it wasn't edited since it was written
+func LoadPlan(path string) (RunPlan, error) {
+	var plan RunPlan
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return plan, fmt.Errorf("failed to read plan: %w", err)
+	}
+	if err := json.Unmarshal(content, &plan); err != nil {
+		return plan, fmt.Errorf("failed to parse plan %s: %w", path, err)
+	}
+	if plan.Fingerprint != planFingerprint(plan) {
+		return plan, fmt.Errorf("plan %s was edited after it was written; write a new one with -plan", path)
+	}
+	return plan, nil
+}
+
+// checkPlan confirms a run still matches a reviewed plan: same configuration and the
+// same steps
+func checkPlan(plan RunPlan, configFile string, steps []PlanStep) error {
+	sum, err := fileSHA256(configFile)
+	if err != nil {
+		return fmt.Errorf("failed to read configuration file: %w", err)
+	}
+	if sum != plan.ConfigSHA256 {
+		return fmt.Errorf("configuration file %s changed since the plan was written", configFile)
+	}
+	current := plan
+	current.Steps = steps
+	if planFingerprint(current) != plan.Fingerprint {
+		return fmt.Errorf("the run no longer matches the plan; write and review a new one")
+	}
+	return nil
+}
+
+// ApplyPlan runs this program with a reviewed plan's command line. The run checks
+// itself against the plan before it touches anything.
+func ApplyPlan(path string) error {
+	plan, err := LoadPlan(path)
+	if err != nil {
+		return err
+	}
+	printPlan(plan)
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, append([]string{"-approved-plan", path}, plan.Args...)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+// printPlan writes a plan's steps for review, marking destructive ones
+func printPlan(plan RunPlan) {
+	destructive := 0
+	for _, step := range plan.Steps {
+		mark := " "
+		if step.Destructive {
+			mark = "!"
+			destructive++
+		}
+		line := fmt.Sprintf("%s %-20s %s", mark, step.Phase, step.Action)
+		if step.Target != "" {
+			line += ": " + step.Target
+		}
+		fmt.Println(line)
+		for _, rewrite := range step.Rewrites {
+			fmt.Printf("  %-20s   rewrite: %s\n", "", rewrite)
+		}
+	}
+	fmt.Printf("%d steps, %d destructive (marked !)\n", len(plan.Steps), destructive)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func testPlanInputs() PlanInputs {
+	return PlanInputs{
+		Databases: []string{"moodys", "tenant"},
+		Sources: map[string]DBConfig{
+			"moodys": {Host: "src", Port: "5432", DBName: "moodys"},
+			"tenant": {Host: "src", Port: "5432", DBName: "tenant"},
+		},
+		Dests: map[string]DBConfig{
+			"moodys": {Host: "dest", Port: "5432", DBName: "moodys"},
+			"tenant": {Host: "dest", Port: "5432", DBName: "tenant_dest"},
+		},
+		DumpDir:    "dump_test",
+		ServerName: "moodys_server",
+		Config:     &Config{},
+	}
+}
+
+func planPhases(steps []PlanStep) []string {
+	var phases []string
+	for _, step := range steps {
+		if len(phases) == 0 || phases[len(phases)-1] != step.Phase {
+			phases = append(phases, step.Phase)
+		}
+	}
+	return phases
+}
+
+func TestRunPlanFullRun(t *testing.T) {
+	steps := BuildRunPlan(testPlanInputs())
+	want := []string{"cleanup", "setup", "dump", "restore", "validate"}
+	if got := planPhases(steps); !reflect.DeepEqual(got, want) {
+		t.Fatalf("phases = %v, want %v", got, want)
+	}
+	if !steps[0].Destructive || steps[0].Action != "DROP DATABASE" {
+		t.Errorf("first step = %+v, want a destructive DROP DATABASE", steps[0])
+	}
+	for _, step := range steps {
+		if step.Phase == "restore" && step.Target == "tenant_dest on dest:5432" && len(step.Rewrites) > 0 {
+			if step.Rewrites[0] != "point foreign server moodys_server at moodys on dest:5432" {
+				t.Errorf("tenant rewrite = %q", step.Rewrites[0])
+			}
+			return
+		}
+	}
+	t.Error("no tenant restore step with rewrites")
+}
+
+func TestRunPlanValidatesMoodysAlone(t *testing.T) {
+	in := testPlanInputs()
+	in.Databases = []string{"moodys"}
+	var validated []string
+	for _, step := range BuildRunPlan(in) {
+		if step.Phase == "validate" {
+			validated = append(validated, step.Target)
+		}
+	}
+	if want := []string{"moodys on dest:5432"}; !reflect.DeepEqual(validated, want) {
+		t.Errorf("validated %v, want %v", validated, want)
+	}
+}
+
+func TestRunPlanBlueGreenRestore(t *testing.T) {
+	in := testPlanInputs()
+	in.RestoreOnly = true
+	in.Databases = []string{"tenant"}
+	staging := in.Dests["tenant"]
+	staging.DBName = "tenant_dest_staging"
+	in.Dests["tenant"] = staging
+	in.Cutover = []CutoverTarget{{Staging: staging, Live: "tenant_dest"}}
+	in.DropPrevious = true
+	in.RestorePoints = true
+	in.Config.Hooks = []Hook{{Name: "notify", Phase: "cutover", When: HookBefore, Command: "true"}}
+
+	steps := BuildRunPlan(in)
+	want := []string{"restore_point_before", "restore", "validate", "cutover", "restore_point_after"}
+	if got := planPhases(steps); !reflect.DeepEqual(got, want) {
+		t.Fatalf("phases = %v, want %v", got, want)
+	}
+	var cutover []PlanStep
+	for _, step := range steps {
+		if step.Phase == "cutover" {
+			cutover = append(cutover, step)
+		}
+	}
+	if len(cutover) != 3 || cutover[0].Action != "run before hook notify" {
+		t.Fatalf("cutover steps = %+v", cutover)
+	}
+	if cutover[1].Action != "DROP DATABASE" || cutover[1].Target != "tenant_dest on dest:5432" || !cutover[1].Destructive {
+		t.Errorf("cutover drop = %+v", cutover[1])
+	}
+}
+
+func TestRunPlanArgs(t *testing.T) {
+	got := planArgs([]string{"-config", "c.json", "-plan", "p.json", "-plan=q.json", "restore"})
+	if want := []string{"-config", "c.json", "restore"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("planArgs = %v, want %v", got, want)
+	}
+}
+
+func TestRunPlanChecks(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(config, []byte(`{}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	steps := BuildRunPlan(testPlanInputs())
+	plan, err := NewRunPlan([]string{"-config", config}, config, steps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "plan.json")
+	if err := WritePlan(path, plan); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadPlan(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkPlan(loaded, config, steps); err != nil {
+		t.Errorf("unchanged run: %v", err)
+	}
+	if err := checkPlan(loaded, config, steps[1:]); err == nil {
+		t.Error("changed steps passed the check")
+	}
+
+	if err := os.WriteFile(config, []byte(`{"grants": []}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkPlan(loaded, config, steps); err == nil {
+		t.Error("changed configuration passed the check")
+	}
+
+	loaded.Args = append(loaded.Args, "-force")
+	if err := WritePlan(path, loaded); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadPlan(path); err == nil {
+		t.Error("edited plan loaded")
+	}
+}
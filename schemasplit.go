@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// SplitTarget is a destination database some of tenant's schemas are restored into
+// instead of dest_tenant, which reaches them through a foreign server
+type SplitTarget struct {
+	// DBName is the database, created on dest_tenant's cluster
+	DBName string `json:"dbname"`
+	// Schemas are the tenant schemas restored into it
+	Schemas []string `json:"schemas"`
+	// Server is dest_tenant's foreign server for the target; default split_<dbname>
+	Server string `json:"server"`
+	// Link overrides how the server connects; unset fields come from dest_tenant's
+	// connection, with the target's dbname
+	Link *FDWTarget `json:"link"`
+	// Roles get a user mapping on the server; default the restoring user
+	Roles []string `json:"roles"`
+}
+
+// server returns the name of the target's foreign server in dest_tenant
+func (t SplitTarget) server() string {
+	if t.Server != "" {
+		return t.Server
+	}
+	return "split_" + t.DBName
+}
+
+// config returns the connection the target is restored through
+func (t SplitTarget) config(destTenant DBConfig) DBConfig {
+	destTenant.DBName = t.DBName
+	return destTenant
+}
+
+// validateSchemaSplit checks every target names a database of its own and every schema
+// is split once
+func validateSchemaSplit(targets []SplitTarget, destTenant string) error {
+	databases := map[string]bool{destTenant: true}
+	schemas := make(map[string]string)
+	for _, t := range targets {
+		if t.DBName == "" || len(t.Schemas) == 0 {
+			return fmt.Errorf("split targets need a dbname and schemas")
+		}
+		if databases[t.DBName] {
+			return fmt.Errorf("split target %s is dest_tenant or another target", t.DBName)
+		}
+		databases[t.DBName] = true
+		for _, schema := range t.Schemas {
+			if other, ok := schemas[schema]; ok {
+				return fmt.Errorf("schema %s is split into both %s and %s", schema, other, t.DBName)
+			}
+			schemas[schema] = t.DBName
+		}
+	}
+	return nil
+}
+
+// schemaSplit decides which database each object of tenant's dump is restored into
+type schemaSplit struct {
+	targets []SplitTarget
+	// home maps split schemas to their target database
+	home map[string]string
+}
+
+func newSchemaSplit(targets []SplitTarget) *schemaSplit {
+	if len(targets) == 0 {
+		return nil
+	}
+	s := &schemaSplit{targets: targets, home: make(map[string]string)}
+	for _, t := range targets {
+		for _, schema := range t.Schemas {
+			s.home[schema] = t.DBName
+		}
+	}
+	return s
+}
+
+// entryHome returns the database an object goes to: a target's name, or "" for
+// dest_tenant. Schema-less objects other than schemas and their comments and grants,
+// such as extensions and foreign servers, are shared.
+func (s *schemaSplit) entryHome(typ, schema, name string) (home string, shared bool) {
+	switch {
+	case schema != "" && schema != "-":
+		return s.home[schema], false
+	case typ == "SCHEMA":
+		return s.home[unquoteIdent(name)], false
+	case strings.HasPrefix(name, "SCHEMA "):
+		return s.home[unquoteIdent(strings.TrimPrefix(name, "SCHEMA "))], false
+	}
+	return "", true
+}
+
+// keeps reports whether an object is restored into home. Shared pre-data objects go to
+// every database, so foreign tables in split schemas keep their servers; shared data and
+// post-data objects, such as large objects and event triggers, stay in dest_tenant.
+func (s *schemaSplit) keeps(home string, preData bool, typ, schema, name string) bool {
+	h, shared := s.entryHome(typ, schema, name)
+	if shared {
+		return preData || home == ""
+	}
+	return h == home
+}
+
+// filterTOC returns the archive entries restored into home
+func (s *schemaSplit) filterTOC(home string, entries []TOCEntry) []TOCEntry {
+	var kept []TOCEntry
+	for _, entry := range entries {
+		if s.keeps(home, false, entry.Desc, entry.Schema, entry.Name) {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+// firstRelationTypes are the entries before the first of which dest_tenant's links to
+// the targets are created: pg_dump puts extensions and foreign servers ahead of them
+var firstRelationTypes = map[string]bool{"TABLE": true, "VIEW": true, "MATERIALIZED VIEW": true, "FOREIGN TABLE": true}
+
+// linkEntry creates dest_tenant's foreign server for a target and imports the target's
+// schemas through it, under their own names
+func (t SplitTarget) linkEntry(destTenant DBConfig) scriptEntry {
+	link := FDWTarget{}
+	if t.Link != nil {
+		link = *t.Link
+	}
+	link = link.withDefaults(t.config(destTenant))
+	server := quoteIdent(t.server())
+	lines := []string{
+		"--",
+		fmt.Sprintf("-- Name: %s; Type: SERVER; Schema: -; Owner: -", t.server()),
+		"--",
+		"",
+		"CREATE EXTENSION IF NOT EXISTS postgres_fdw WITH SCHEMA public;",
+		fmt.Sprintf("CREATE SERVER %s FOREIGN DATA WRAPPER postgres_fdw OPTIONS (%s);", server, mergeOptions("", setOptions(link.serverOptions()))),
+	}
+	roles := t.Roles
+	if len(roles) == 0 {
+		roles = []string{"CURRENT_USER"}
+	}
+	for _, role := range roles {
+		if role != "CURRENT_USER" {
+			role = mappingRole(role)
+		}
+		lines = append(lines, fmt.Sprintf("CREATE USER MAPPING FOR %s SERVER %s OPTIONS (%s);",
+			role, server, mergeOptions("", setOptions(map[string]string{"user": link.User, "password": link.Password}))))
+	}
+	for _, schema := range t.Schemas {
+		lines = append(lines,
+			fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s;", quoteIdent(schema)),
+			fmt.Sprintf("IMPORT FOREIGN SCHEMA %s FROM SERVER %s INTO %s;", quoteIdent(schema), server, quoteIdent(schema)))
+	}
+	return scriptEntry{Name: t.server(), Type: "SERVER", Schema: "-", lines: append(lines, "")}
+}
+
+// setOptions drops unset options, such as the host of a socket connection
+func setOptions(options map[string]string) map[string]string {
+	set := make(map[string]string)
+	for k, v := range options {
+		if v != "" {
+			set[k] = v
+		}
+	}
+	return set
+}
+
+// splitPreData writes the objects of a plain pre-data script that go to home to a file
+// next to it, followed for dest_tenant by its links to the targets
+func (s *schemaSplit) splitPreData(inputFile, home string, destTenant DBConfig) (string, error) {
+	preamble, entries, err := splitScript(inputFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", inputFile, err)
+	}
+	var kept []scriptEntry
+	linked := home != ""
+	link := func(settings []string) {
+		if linked {
+			return
+		}
+		for _, t := range s.targets {
+			entry := t.linkEntry(destTenant)
+			entry.settings = settings
+			kept = append(kept, entry)
+		}
+		linked = true
+	}
+	for _, entry := range entries {
+		if firstRelationTypes[entry.Type] {
+			link(entry.settings)
+		}
+		if s.keeps(home, true, entry.Type, entry.Schema, entry.Name) {
+			kept = append(kept, entry)
+		}
+	}
+	link(nil)
+
+	script, _ := renderEntries(preamble, kept)
+	name := home
+	if name == "" {
+		name = destTenant.DBName
+	}
+	path := filepath.Join(filepath.Dir(inputFile), strings.TrimSuffix(filepath.Base(inputFile), ".sql")+"_"+name+".sql")
+	if err := os.WriteFile(path, []byte(script), 0600); err != nil {
+		return "", fmt.Errorf("failed to write split pre-data file: %w", err)
+	}
+	return path, nil
+}
+
+// referencesRe matches the schema of the table a foreign key references
+var referencesRe = regexp.MustCompile(`\bREFERENCES (` + dumpedIdent + `)\.`)
+
+// referencedSchemas maps each foreign key of a rendered post-data script, by schema and
+// name as its header gives them, to the schema of the table it references
+func referencedSchemas(script string) map[string]string {
+	referenced := make(map[string]string)
+	var current string
+	scanner := bufio.NewScanner(strings.NewReader(script))
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := scriptHeaderRe.FindStringSubmatch(line); m != nil {
+			current = ""
+			if m[2] == "FK CONSTRAINT" {
+				current = m[3] + " " + m[1]
+			}
+			continue
+		}
+		if m := referencesRe.FindStringSubmatch(line); m != nil && current != "" {
+			referenced[current] = unquoteIdent(m[1])
+		}
+	}
+	return referenced
+}
+
+// dropCrossingForeignKeys leaves out the foreign keys among entries restored into home
+// that reference a table restored into another database, which PostgreSQL can't enforce
+func (s *schemaSplit) dropCrossingForeignKeys(config DBConfig, inputFile, home string, entries []TOCEntry) ([]TOCEntry, error) {
+	var foreignKeys []TOCEntry
+	for _, entry := range entries {
+		if entry.Desc == "FK CONSTRAINT" {
+			foreignKeys = append(foreignKeys, entry)
+		}
+	}
+	if len(foreignKeys) == 0 {
+		return entries, nil
+	}
+	listFile, cleanup, err := tempTOCList(foreignKeys)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	script, err := newPgRestoreScriptCmd(listFile, inputFile).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to render foreign keys of %s: %w", inputFile, err)
+	}
+	referenced := referencedSchemas(string(script))
+
+	var kept []TOCEntry
+	for _, entry := range entries {
+		schema, ok := referenced[entry.Schema+" "+entry.Name]
+		if entry.Desc != "FK CONSTRAINT" || !ok || s.home[schema] == home {
+			kept = append(kept, entry)
+			continue
+		}
+		target := s.home[schema]
+		if target == "" {
+			target = "dest_tenant"
+		}
+		log.Printf("WARNING: leaving out foreign key %s of %s, which references %s in %s", entry.QualifiedName(), config.DBName, schema, target)
+		activeReport.recordSkipped(config.DBName, entry, "references a table split into another database")
+	}
+	return kept, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const splitPreDataScript = `--
+-- PostgreSQL database dump
+--
+
+SET statement_timeout = 0;
+
+--
+-- Name: reporting; Type: SCHEMA; Schema: -; Owner: app
+--
+
+CREATE SCHEMA reporting;
+
+--
+-- Name: SCHEMA reporting; Type: COMMENT; Schema: -; Owner: app
+--
+
+COMMENT ON SCHEMA reporting IS 'reports';
+
+--
+-- Name: postgres_fdw; Type: EXTENSION; Schema: -; Owner: -
+--
+
+CREATE EXTENSION IF NOT EXISTS postgres_fdw WITH SCHEMA public;
+
+--
+-- Name: customers; Type: TABLE; Schema: public; Owner: app
+--
+
+CREATE TABLE public.customers (id integer);
+
+--
+-- Name: daily; Type: TABLE; Schema: reporting; Owner: app
+--
+
+CREATE TABLE reporting.daily (id integer);
+
+--
+-- Name: v_daily; Type: VIEW; Schema: public; Owner: app
+--
+
+CREATE VIEW public.v_daily AS SELECT id FROM reporting.daily;
+`
+
+func TestSplitPreData(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "tenant_pre-data.sql")
+	if err := os.WriteFile(input, []byte(splitPreDataScript), 0644); err != nil {
+		t.Fatal(err)
+	}
+	split := newSchemaSplit([]SplitTarget{{DBName: "analytics", Schemas: []string{"reporting"}}})
+	dest := DBConfig{Host: "db1", Port: "5432", User: "app", Password: "secret", DBName: "tenant"}
+
+	path, err := split.splitPreData(input, "analytics", dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := os.ReadFile(path)
+	got := string(content)
+	for _, want := range []string{"CREATE SCHEMA reporting;", "COMMENT ON SCHEMA reporting", "CREATE EXTENSION", "CREATE TABLE reporting.daily"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("analytics script lacks %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "public.customers") || strings.Contains(got, "CREATE SERVER") {
+		t.Errorf("analytics script has tenant objects:\n%s", got)
+	}
+
+	path, err = split.splitPreData(input, "", dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ = os.ReadFile(path)
+	got = string(content)
+	if strings.Contains(got, "CREATE TABLE reporting.daily") || strings.Contains(got, "CREATE SCHEMA reporting;") {
+		t.Errorf("tenant script has split objects:\n%s", got)
+	}
+	server := strings.Index(got, `CREATE SERVER "split_analytics"`)
+	imported := strings.Index(got, `IMPORT FOREIGN SCHEMA "reporting" FROM SERVER "split_analytics" INTO "reporting";`)
+	if server < 0 || imported < server || imported > strings.Index(got, "CREATE TABLE public.customers") {
+		t.Errorf("links to analytics missing or after the first table:\n%s", got)
+	}
+	if !strings.Contains(got, "dbname 'analytics'") || !strings.Contains(got, "password 'secret'") {
+		t.Errorf("link options wrong:\n%s", got)
+	}
+}
+
+func TestSchemaSplitKeeps(t *testing.T) {
+	split := newSchemaSplit([]SplitTarget{{DBName: "analytics", Schemas: []string{"reporting"}}})
+	for _, c := range []struct {
+		home              string
+		preData           bool
+		typ, schema, name string
+		want              bool
+	}{
+		{"", false, "TABLE DATA", "public", "customers", true},
+		{"analytics", false, "TABLE DATA", "public", "customers", false},
+		{"analytics", false, "TABLE DATA", "reporting", "daily", true},
+		{"analytics", false, "ACL", "-", "SCHEMA reporting", true},
+		{"analytics", false, "EVENT TRIGGER", "-", "audit", false},
+		{"", false, "EVENT TRIGGER", "-", "audit", true},
+		{"analytics", true, "SERVER", "-", "moodys_server", true},
+	} {
+		if got := split.keeps(c.home, c.preData, c.typ, c.schema, c.name); got != c.want {
+			t.Errorf("keeps(%q, %v, %s %s.%s) = %v", c.home, c.preData, c.typ, c.schema, c.name, got)
+		}
+	}
+
+	if err := validateSchemaSplit([]SplitTarget{{DBName: "a", Schemas: []string{"x"}}, {DBName: "b", Schemas: []string{"x"}}}, "tenant"); err == nil {
+		t.Error("expected a schema split twice to fail")
+	}
+	if err := validateSchemaSplit([]SplitTarget{{DBName: "tenant", Schemas: []string{"x"}}}, "tenant"); err == nil {
+		t.Error("expected dest_tenant as a target to fail")
+	}
+}
+
+func TestReferencedSchemas(t *testing.T) {
+	script := `--
+-- Name: orders orders_customer_fkey; Type: FK CONSTRAINT; Schema: sales; Owner: app
+--
+
+ALTER TABLE ONLY sales.orders
+    ADD CONSTRAINT orders_customer_fkey FOREIGN KEY (customer_id) REFERENCES "Reporting".customers(id);
+`
+	got := referencedSchemas(script)
+	if got["sales orders orders_customer_fkey"] != "Reporting" {
+		t.Errorf("got %v", got)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import "strings"
+
+// restoreSessionSettings returns the SET statements a plain script is replayed after, so
+// its values are read the way pg_dump wrote them whatever the destination's defaults:
+// pg_dump writes dates in ISO style and intervals in postgres style, and values without
+// a zone offset in the source's time zone
+func restoreSessionSettings(source map[string]string) []string {
+	dateStyle := "ISO"
+	if _, order, ok := strings.Cut(source["DateStyle"], ", "); ok {
+		dateStyle += ", " + order
+	}
+	statements := []string{
+		"SET datestyle = " + quoteLiteral(dateStyle) + ";",
+		"SET intervalstyle = 'postgres';",
+	}
+	if tz := source["TimeZone"]; tz != "" {
+		statements = append(statements, "SET timezone = "+quoteLiteral(tz)+";")
+	}
+	return statements
+}
+
+// recordedSessionSettings returns the session settings for each source database the
+// manifest in dir recorded a catalog for, or the style settings alone without one
+func recordedSessionSettings(dir string) map[string][]string {
+	sessions := map[string][]string{
+		"moodys": restoreSessionSettings(nil),
+		"tenant": restoreSessionSettings(nil),
+	}
+	manifest, err := LoadManifest(dir)
+	if err != nil {
+		return sessions
+	}
+	for _, catalog := range manifest.Catalogs {
+		sessions[catalog.Database] = restoreSessionSettings(catalog.Settings)
+	}
+	return sessions
+}
+
+// sessionArgs runs statements ahead of a script in psql's session; the script, which
+// must follow with -f, can still override them
+func sessionArgs(statements []string) []string {
+	var args []string
+	for _, statement := range statements {
+		args = append(args, "-c", statement)
+	}
+	return args
+}
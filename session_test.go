@@ -0,0 +1,24 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRestoreSessionSettings(t *testing.T) {
+	got := restoreSessionSettings(map[string]string{"DateStyle": "SQL, DMY", "TimeZone": "Europe/London"})
+	want := []string{
+		"SET datestyle = 'ISO, DMY';",
+		"SET intervalstyle = 'postgres';",
+		"SET timezone = 'Europe/London';",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("settings = %q", got)
+	}
+	if got := restoreSessionSettings(nil); len(got) != 2 || got[0] != "SET datestyle = 'ISO';" {
+		t.Errorf("settings without a catalog = %q", got)
+	}
+	if args := sessionArgs(want[:1]); !reflect.DeepEqual(args, []string{"-c", "SET datestyle = 'ISO, DMY';"}) {
+		t.Errorf("args = %q", args)
+	}
+}
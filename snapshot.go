@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// snapshotQuery exports a snapshot and reports the WAL position it corresponds to
+const snapshotQuery = `SELECT pg_export_snapshot(),
+	CASE WHEN pg_is_in_recovery() THEN pg_last_wal_replay_lsn() ELSE pg_current_wal_lsn() END,
+	to_char(clock_timestamp() AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.US"Z"');`
+
+// positionQuery reports the current WAL position without exporting a snapshot
+const positionQuery = `SELECT '',
+	CASE WHEN pg_is_in_recovery() THEN pg_last_wal_replay_lsn() ELSE pg_current_wal_lsn() END,
+	to_char(clock_timestamp() AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.US"Z"');`
+
+// SnapshotInfo records the point in time a database was dumped at
+type SnapshotInfo struct {
+	Database   string    `json:"database"`
+	SnapshotID string    `json:"snapshot_id,omitempty"`
+	LSN        string    `json:"lsn"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// snapshotSession holds a transaction open so its exported snapshot stays importable
+type snapshotSession struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	Info  SnapshotInfo
+	// replication sessions hold no transaction; closing the connection ends the export
+	replication bool
+}
+
+// parseSnapshotRow parses "snapshot|lsn|timestamp" output
+func parseSnapshotRow(database, row string) (SnapshotInfo, error) {
+	fields := strings.Split(strings.TrimSpace(row), "|")
+	if len(fields) != 3 {
+		return SnapshotInfo{}, fmt.Errorf("unexpected snapshot output %q", row)
+	}
+	ts, err := time.Parse(time.RFC3339Nano, fields[2])
+	if err != nil {
+		return SnapshotInfo{}, fmt.Errorf("failed to parse snapshot timestamp %q: %w", fields[2], err)
+	}
+	return SnapshotInfo{Database: database, SnapshotID: fields[0], LSN: fields[1], Timestamp: ts}, nil
+}
+
+// openSnapshotSession starts a repeatable-read transaction and exports its snapshot.
+// The session must be closed once every dump using the snapshot has finished.
+func openSnapshotSession(config DBConfig) (*snapshotSession, error) {
+	cmd := newPsqlCmd(config, "-q", "-t", "-A", "-v", "ON_ERROR_STOP=1")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open snapshot session input: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open snapshot session output: %w", err)
+	}
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start snapshot session: %w", err)
+	}
+
+	fmt.Fprintf(stdin, "BEGIN ISOLATION LEVEL REPEATABLE READ READ ONLY;\n%s\n", snapshotQuery)
+
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		stdin.Close()
+		cmd.Wait()
+		return nil, fmt.Errorf("failed to export snapshot for %s: %w\nOutput: %s", config.DBName, err, stderr.String())
+	}
+
+	info, err := parseSnapshotRow(config.DBName, line)
+	if err != nil {
+		stdin.Close()
+		cmd.Wait()
+		return nil, err
+	}
+
+	log.Printf("Exported snapshot %s of %s at LSN %s", info.SnapshotID, config.DBName, info.LSN)
+	return &snapshotSession{cmd: cmd, stdin: stdin, Info: info}, nil
+}
+
+// Close ends the snapshot transaction
+func (s *snapshotSession) Close() error {
+	if !s.replication {
+		fmt.Fprintln(s.stdin, "COMMIT;")
+	}
+	s.stdin.Close()
+	if err := s.cmd.Wait(); err != nil {
+		return fmt.Errorf("snapshot session for %s ended with error: %w", s.Info.Database, err)
+	}
+	return nil
+}
+
+// currentPosition records the WAL position and time without exporting a snapshot
+func currentPosition(config DBConfig) (SnapshotInfo, error) {
+	output, err := newPsqlCmd(config, "-t", "-A", "-c", positionQuery).CombinedOutput()
+	if err != nil {
+		return SnapshotInfo{}, fmt.Errorf("failed to query WAL position of %s: %w, output: %s", config.DBName, err, output)
+	}
+	return parseSnapshotRow(config.DBName, string(output))
+}
+
+// snapshotSkew returns the time between the earliest and latest snapshots
+func snapshotSkew(snapshots []SnapshotInfo) time.Duration {
+	if len(snapshots) < 2 {
+		return 0
+	}
+	earliest, latest := snapshots[0].Timestamp, snapshots[0].Timestamp
+	for _, s := range snapshots[1:] {
+		if s.Timestamp.Before(earliest) {
+			earliest = s.Timestamp
+		}
+		if s.Timestamp.After(latest) {
+			latest = s.Timestamp
+		}
+	}
+	return latest.Sub(earliest)
+}
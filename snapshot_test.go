@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSnapshotRow(t *testing.T) {
+	info, err := parseSnapshotRow("moodys", "00000003-0000001B-1|0/1A2B3C4|2026-10-16T02:30:00.123456Z\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := SnapshotInfo{
+		Database:   "moodys",
+		SnapshotID: "00000003-0000001B-1",
+		LSN:        "0/1A2B3C4",
+		Timestamp:  time.Date(2026, 10, 16, 2, 30, 0, 123456000, time.UTC),
+	}
+	if info != want {
+		t.Errorf("parseSnapshotRow = %+v, want %+v", info, want)
+	}
+
+	// positionQuery exports no snapshot
+	if info, err := parseSnapshotRow("tenant", "|0/5000000|2026-10-16T02:30:01Z"); err != nil || info.SnapshotID != "" || info.LSN != "0/5000000" {
+		t.Errorf("position row = %+v, %v", info, err)
+	}
+	for _, row := range []string{"", "00000003-0000001B-1|0/1A2B3C4", "id|0/1|yesterday"} {
+		if _, err := parseSnapshotRow("moodys", row); err == nil {
+			t.Errorf("parseSnapshotRow(%q) succeeded", row)
+		}
+	}
+}
+
+func TestSnapshotSkew(t *testing.T) {
+	at := func(s int) SnapshotInfo { return SnapshotInfo{Timestamp: time.Unix(int64(s), 0)} }
+	if got := snapshotSkew([]SnapshotInfo{at(10)}); got != 0 {
+		t.Errorf("single snapshot skew = %s", got)
+	}
+	if got := snapshotSkew([]SnapshotInfo{at(12), at(10), at(15)}); got != 5*time.Second {
+		t.Errorf("skew = %s, want 5s", got)
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// partsIndexExt is appended to an artifact's name for the index of its parts
+const partsIndexExt = ".parts.json"
+
+// ArtifactLayout controls the format and file layout of dumped sections
+type ArtifactLayout struct {
+	// PlainData dumps the data section as plain SQL instead of a custom-format archive
+	PlainData bool
+	// SplitBytes splits plain data dumps into parts of at most this many bytes; zero
+	// writes one file
+	SplitBytes int64
+	// Tar dumps archived sections in tar format instead of custom format
+	Tar bool
+	// ExcludeData leaves the data of these tables out of the data section, for tables
+	// dumped separately
+	ExcludeData []string
+}
+
+// format returns the pg_dump format name used for a section
+func (l ArtifactLayout) format(section string) string {
+	if section == "data" && l.PlainData {
+		return FormatPlain
+	}
+	if l.Tar && section != "pre-data" {
+		return FormatTar
+	}
+	return sectionFormat(section)
+}
+
+// PartsIndex lists the parts of a split artifact in order
+type PartsIndex struct {
+	// File is the name of the artifact the parts reassemble into
+	File  string     `json:"file"`
+	Size  int64      `json:"size"`
+	Parts []PartInfo `json:"parts"`
+}
+
+// PartInfo describes one part of a split artifact
+type PartInfo struct {
+	File   string `json:"file"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// partName returns the name of the n-th part (1-based) of an artifact
+func partName(file string, n int) string {
+	return fmt.Sprintf("%s.part%04d", file, n)
+}
+
+// partWriter writes a stream into size-bounded parts and records them in an index
+type partWriter struct {
+	path  string
+	limit int64
+	index PartsIndex
+
+	cur     *os.File
+	curSize int64
+	hash    hash.Hash
+}
+
+// newPartWriter splits what is written into parts of path of at most limit bytes
+func newPartWriter(path string, limit int64) *partWriter {
+	return &partWriter{path: path, limit: limit, index: PartsIndex{File: filepath.Base(path)}}
+}
+
+func (w *partWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if w.cur == nil || w.curSize == w.limit {
+			if err := w.next(); err != nil {
+				return written, err
+			}
+		}
+		chunk := p
+		if room := w.limit - w.curSize; int64(len(chunk)) > room {
+			chunk = chunk[:room]
+		}
+		n, err := w.cur.Write(chunk)
+		w.hash.Write(chunk[:n])
+		w.curSize += int64(n)
+		w.index.Size += int64(n)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// next finishes the current part and starts another
+func (w *partWriter) next() error {
+	if err := w.finishPart(); err != nil {
+		return err
+	}
+	name := partName(w.path, len(w.index.Parts)+1)
+	f, err := os.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to create dump part: %w", err)
+	}
+	w.cur, w.curSize, w.hash = f, 0, sha256.New()
+	return nil
+}
+
+// finishPart closes the current part and adds it to the index
+func (w *partWriter) finishPart() error {
+	if w.cur == nil {
+		return nil
+	}
+	if err := w.cur.Close(); err != nil {
+		return fmt.Errorf("failed to write dump part: %w", err)
+	}
+	w.index.Parts = append(w.index.Parts, PartInfo{
+		File:   filepath.Base(w.cur.Name()),
+		Size:   w.curSize,
+		SHA256: hex.EncodeToString(w.hash.Sum(nil)),
+	})
+	w.cur = nil
+	return nil
+}
+
+// Close finishes the last part and writes the index, returning its path
+func (w *partWriter) Close() (string, error) {
+	if w.cur == nil && len(w.index.Parts) == 0 {
+		// Keep an empty part so the index always names at least one file
+		if err := w.next(); err != nil {
+			return "", err
+		}
+	}
+	if err := w.finishPart(); err != nil {
+		return "", err
+	}
+	content, err := json.MarshalIndent(w.index, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode parts index: %w", err)
+	}
+	indexPath := w.path + partsIndexExt
+	if err := os.WriteFile(indexPath, content, 0644); err != nil {
+		return "", fmt.Errorf("failed to write parts index: %w", err)
+	}
+	return indexPath, nil
+}
+
+// isPartsIndex reports whether a path names the index of a split artifact
+func isPartsIndex(path string) bool {
+	return strings.HasSuffix(path, partsIndexExt)
+}
+
+// LoadPartsIndex reads the index of a split artifact
+func LoadPartsIndex(path string) (*PartsIndex, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read parts index: %w", err)
+	}
+	index := &PartsIndex{}
+	if err := json.Unmarshal(content, index); err != nil {
+		return nil, fmt.Errorf("failed to parse parts index %s: %w", path, err)
+	}
+	return index, nil
+}
+
+// partsReader reads the parts of a split artifact in order, failing when a part's
+// size or checksum doesn't match the index
+type partsReader struct {
+	dir   string
+	parts []PartInfo
+
+	cur  *os.File
+	read int64
+	hash hash.Hash
+}
+
+// openParts reassembles a split artifact from the parts next to its index
+func openParts(indexPath string) (io.ReadCloser, error) {
+	index, err := LoadPartsIndex(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	return &partsReader{dir: filepath.Dir(indexPath), parts: index.Parts}, nil
+}
+
+func (r *partsReader) Read(p []byte) (int, error) {
+	for {
+		if r.cur == nil {
+			if len(r.parts) == 0 {
+				return 0, io.EOF
+			}
+			f, err := os.Open(filepath.Join(r.dir, r.parts[0].File))
+			if err != nil {
+				return 0, fmt.Errorf("failed to open dump part: %w", err)
+			}
+			r.cur, r.read, r.hash = f, 0, sha256.New()
+		}
+		n, err := r.cur.Read(p)
+		r.hash.Write(p[:n])
+		r.read += int64(n)
+		if err == io.EOF {
+			part := r.parts[0]
+			r.cur.Close()
+			r.cur = nil
+			r.parts = r.parts[1:]
+			if r.read != part.Size || hex.EncodeToString(r.hash.Sum(nil)) != part.SHA256 {
+				return n, fmt.Errorf("dump part %s is corrupt or incomplete", part.File)
+			}
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (r *partsReader) Close() error {
+	if r.cur != nil {
+		return r.cur.Close()
+	}
+	return nil
+}
+
+// artifactFiles returns the files an artifact consists of: the file itself, or the
+// index of a split artifact followed by its parts
+func artifactFiles(dir, file string) ([]string, error) {
+	if !isPartsIndex(file) {
+		return []string{file}, nil
+	}
+	index, err := LoadPartsIndex(filepath.Join(dir, file))
+	if err != nil {
+		return nil, err
+	}
+	files := []string{file}
+	for _, part := range index.Parts {
+		files = append(files, part.File)
+	}
+	return files, nil
+}
+
+// artifactSize returns the size of a dumped artifact, summing the parts of a split one
+func artifactSize(path string) int64 {
+	if isPartsIndex(path) {
+		if index, err := LoadPartsIndex(path); err == nil {
+			return index.Size
+		}
+		return 0
+	}
+	if info, err := os.Stat(path); err == nil {
+		return info.Size()
+	}
+	return 0
+}
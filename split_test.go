@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSplitRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	content := strings.Repeat("COPY public.t (id) FROM stdin;\n1\n\\.\n", 10)
+	w := newPartWriter(filepath.Join(dir, "tenant_data.sql"), 64)
+	if _, err := io.Copy(w, strings.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	indexPath, err := w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := LoadPartsIndex(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index.Size != int64(len(content)) || len(index.Parts) != (len(content)+63)/64 {
+		t.Errorf("index has size %d and %d parts for %d bytes", index.Size, len(index.Parts), len(content))
+	}
+	if artifactSize(indexPath) != int64(len(content)) {
+		t.Errorf("artifact size = %d", artifactSize(indexPath))
+	}
+
+	r, err := openParts(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || string(got) != content {
+		t.Errorf("reassembled %d bytes, %v", len(got), err)
+	}
+
+	// A changed part fails the read
+	os.WriteFile(filepath.Join(dir, index.Parts[1].File), []byte(strings.Repeat("x", 64)), 0644)
+	r, _ = openParts(indexPath)
+	if _, err := io.ReadAll(r); err == nil {
+		t.Error("expected a corrupt part to be detected")
+	}
+	r.Close()
+}
+
+func TestSectionArtifactName(t *testing.T) {
+	dir := t.TempDir()
+	if got := sectionArtifactName(dir, "tenant", "data"); got != "tenant_data.dump" {
+		t.Errorf("custom data = %s", got)
+	}
+	os.WriteFile(filepath.Join(dir, "tenant_data.sql"+partsIndexExt), []byte("{}"), 0644)
+	if got := sectionArtifactName(dir, "tenant", "data"); got != "tenant_data.sql" {
+		t.Errorf("split plain data = %s", got)
+	}
+	if got := sectionArtifactName(dir, "tenant", "pre-data"); got != "tenant_pre-data.sql" {
+		t.Errorf("pre-data = %s", got)
+	}
+}
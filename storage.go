@@ -0,0 +1,578 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gcsEndpoint is the S3-compatible XML API of Google Cloud Storage
+const gcsEndpoint = "https://storage.googleapis.com"
+
+// checksumsFileName lists the size and SHA-256 of every uploaded file of a dump set
+const checksumsFileName = "checksums.json"
+
+// S3 multipart limits
+const (
+	minPartSize = 5 << 20
+	maxParts    = 10000
+)
+
+// StorageConfig points at an S3 or GCS location that holds a dump set. Transfers go
+// through the aws CLI's s3api commands; GCS is reached through its S3-compatible API
+// with HMAC keys.
+type StorageConfig struct {
+	// URL is s3://bucket/prefix or gs://bucket/prefix
+	URL string `json:"url"`
+	// Endpoint overrides the API endpoint, e.g. for MinIO
+	Endpoint string `json:"endpoint"`
+	Profile  string `json:"profile"`
+	Region   string `json:"region"`
+	// PartSizeMB is the size of each uploaded part and downloaded range; default 64
+	PartSizeMB int `json:"part_size_mb"`
+	// Concurrency is how many parts transfer at once; default 8
+	Concurrency int `json:"concurrency"`
+	// MaxMBPerSec caps the average transfer rate across parts; zero is unlimited
+	MaxMBPerSec float64 `json:"max_mb_per_sec"`
+	// Attempts is how often a part is tried before the transfer fails; default 3
+	Attempts int `json:"attempts"`
+}
+
+// ObjectStore transfers files to and from a storage location
+type ObjectStore struct {
+	config   StorageConfig
+	bucket   string
+	prefix   string
+	endpoint string
+	limiter  *rateLimiter
+}
+
+// NewObjectStore validates the config and applies defaults
+func NewObjectStore(config StorageConfig) (*ObjectStore, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid storage url %q: use s3://bucket/prefix or gs://bucket/prefix", config.URL)
+	}
+	store := &ObjectStore{config: config, bucket: u.Host, prefix: strings.Trim(u.Path, "/"), endpoint: config.Endpoint}
+	switch u.Scheme {
+	case "s3":
+	case "gs":
+		if store.endpoint == "" {
+			store.endpoint = gcsEndpoint
+		}
+	default:
+		return nil, fmt.Errorf("unsupported storage scheme %q", u.Scheme)
+	}
+	if store.config.PartSizeMB <= 0 {
+		store.config.PartSizeMB = 64
+	}
+	if store.config.Concurrency <= 0 {
+		store.config.Concurrency = 8
+	}
+	if store.config.Attempts <= 0 {
+		store.config.Attempts = 3
+	}
+	if config.MaxMBPerSec > 0 {
+		store.limiter = &rateLimiter{bytesPerSec: config.MaxMBPerSec * 1024 * 1024}
+	}
+	return store, nil
+}
+
+// key returns the object key of a dump set file
+func (s *ObjectStore) key(name string) string {
+	return path.Join(s.prefix, name)
+}
+
+// s3api builds an aws s3api command for the store's endpoint and credentials
+func (s *ObjectStore) s3api(args ...string) *exec.Cmd {
+	base := []string{"s3api", "--output", "json"}
+	if s.endpoint != "" {
+		base = append(base, "--endpoint-url", s.endpoint)
+	}
+	if s.config.Profile != "" {
+		base = append(base, "--profile", s.config.Profile)
+	}
+	if s.config.Region != "" {
+		base = append(base, "--region", s.config.Region)
+	}
+	return exec.Command("aws", append(base, args...)...)
+}
+
+// run runs an s3api command and decodes its JSON output into result, if given
+func (s *ObjectStore) run(result interface{}, args ...string) error {
+	cmd := s.s3api(args...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("aws s3api %s failed: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	if result != nil {
+		if err := json.Unmarshal(output, result); err != nil {
+			return fmt.Errorf("failed to parse aws s3api %s output: %w", args[0], err)
+		}
+	}
+	return nil
+}
+
+// byteRange is one part of an object
+type byteRange struct {
+	Offset int64
+	Length int64
+}
+
+// partSizeFor grows the requested part size until size fits in the part limit
+func partSizeFor(size, requested int64) int64 {
+	if requested < minPartSize {
+		requested = minPartSize
+	}
+	for size > requested*maxParts {
+		requested *= 2
+	}
+	return requested
+}
+
+// partRanges divides size bytes into consecutive parts of partSize bytes
+func partRanges(size, partSize int64) []byteRange {
+	var ranges []byteRange
+	for offset := int64(0); offset < size; offset += partSize {
+		length := partSize
+		if size-offset < length {
+			length = size - offset
+		}
+		ranges = append(ranges, byteRange{Offset: offset, Length: length})
+	}
+	return ranges
+}
+
+// forEachPart runs fn for every range, Concurrency at a time, pacing part starts to
+// the bandwidth cap and retrying each part. stage, when set, runs for each part in
+// order before its fn is started. It returns the first error.
+func (s *ObjectStore) forEachPart(operation string, ranges []byteRange, stage func(i int, r byteRange) error, fn func(i int, r byteRange) error) error {
+	slots := make(chan struct{}, s.config.Concurrency)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	for i, r := range ranges {
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			break
+		}
+		if s.limiter != nil {
+			s.limiter.wait(int(r.Length))
+		}
+		slots <- struct{}{}
+		if stage != nil {
+			if err := stage(i, r); err != nil {
+				<-slots
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+				break
+			}
+		}
+		wg.Add(1)
+		go func(i int, r byteRange) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			err := RetryWithBackoff(fmt.Sprintf("%s part %d", operation, i+1), s.config.Attempts, func() error {
+				return fn(i, r)
+			})
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}(i, r)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// fileChecksum is what checksums.json records for each file of a dump set
+type fileChecksum struct {
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// transferProgress reports the bytes moved by concurrent parts through a ProgressMonitor
+type transferProgress struct {
+	mu      sync.Mutex
+	monitor *ProgressMonitor
+	total   int64
+	done    int64
+}
+
+func newTransferProgress(operation string, total int64) *transferProgress {
+	return &transferProgress{monitor: NewProgressMonitor(operation), total: total}
+}
+
+// add records n more bytes transferred
+func (p *transferProgress) add(n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done += n
+	p.monitor.UpdateBytes(formatTransfer(p.done, p.total, time.Since(p.monitor.StartTime)), p.done, p.total)
+}
+
+// finish reports the final size and throughput
+func (p *transferProgress) finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.monitor.Finish(formatTransfer(p.done, p.total, time.Since(p.monitor.StartTime)), p.done, p.total)
+}
+
+// formatTransfer describes transfer progress and its average throughput
+func formatTransfer(done, total int64, elapsed time.Duration) string {
+	const mb = 1024 * 1024
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(done) / mb / elapsed.Seconds()
+	}
+	return fmt.Sprintf("%.1f of %.1f MB (%.1f MB/s)", float64(done)/mb, float64(total)/mb, rate)
+}
+
+// hashFile returns the base64 MD5 S3 checks uploads against and the checksum of path
+func hashFile(path string) (string, fileChecksum, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fileChecksum{}, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+	md5sum, sha := md5.New(), sha256.New()
+	n, err := io.Copy(io.MultiWriter(md5sum, sha), f)
+	if err != nil {
+		return "", fileChecksum{}, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return base64.StdEncoding.EncodeToString(md5sum.Sum(nil)), fileChecksum{Size: n, SHA256: hex.EncodeToString(sha.Sum(nil))}, nil
+}
+
+// stagedPart is a part copied out of the file being uploaded
+type stagedPart struct {
+	file string
+	md5  string
+}
+
+// writePartFile copies a range of src into a temporary file for upload, adding its
+// bytes to whole and returning the part's base64 MD5
+func writePartFile(src *os.File, r byteRange, dir string, whole hash.Hash) (stagedPart, error) {
+	tmp, err := os.CreateTemp(dir, "part_")
+	if err != nil {
+		return stagedPart{}, fmt.Errorf("failed to create part file: %w", err)
+	}
+	defer tmp.Close()
+	md5sum := md5.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, md5sum, whole), io.NewSectionReader(src, r.Offset, r.Length)); err != nil {
+		os.Remove(tmp.Name())
+		return stagedPart{}, fmt.Errorf("failed to stage part: %w", err)
+	}
+	return stagedPart{file: tmp.Name(), md5: base64.StdEncoding.EncodeToString(md5sum.Sum(nil))}, nil
+}
+
+// completedPart is one entry of a complete-multipart-upload request
+type completedPart struct {
+	ETag       string `json:"ETag"`
+	PartNumber int    `json:"PartNumber"`
+}
+
+// Upload copies a local file to the named object, in concurrent parts when it is
+// larger than one part, and returns the file's checksum. Parts are staged in order so
+// the checksum is computed while uploading, each part carries its MD5 for the store to
+// check, and the object's size is verified afterwards.
+func (s *ObjectStore) Upload(localPath, name string) (fileChecksum, error) {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return fileChecksum{}, fmt.Errorf("failed to stat %s: %w", localPath, err)
+	}
+	key := s.key(name)
+	partSize := partSizeFor(info.Size(), int64(s.config.PartSizeMB)<<20)
+	progress := newTransferProgress("Upload "+name, info.Size())
+	if info.Size() <= partSize {
+		md5sum, sum, err := hashFile(localPath)
+		if err != nil {
+			return fileChecksum{}, err
+		}
+		if s.limiter != nil {
+			s.limiter.wait(int(info.Size()))
+		}
+		err = RetryWithBackoff("upload "+name, s.config.Attempts, func() error {
+			return s.run(nil, "put-object", "--bucket", s.bucket, "--key", key, "--body", localPath, "--content-md5", md5sum)
+		})
+		if err != nil {
+			return fileChecksum{}, err
+		}
+		progress.add(sum.Size)
+		progress.finish()
+		return sum, s.verifySize(name, sum.Size)
+	}
+
+	src, err := os.Open(localPath)
+	if err != nil {
+		return fileChecksum{}, fmt.Errorf("failed to open %s: %w", localPath, err)
+	}
+	defer src.Close()
+	scratch, err := os.MkdirTemp("", "pg_restore_fdw_upload_")
+	if err != nil {
+		return fileChecksum{}, fmt.Errorf("failed to create scratch directory: %w", err)
+	}
+	defer os.RemoveAll(scratch)
+
+	var upload struct{ UploadId string }
+	if err := s.run(&upload, "create-multipart-upload", "--bucket", s.bucket, "--key", key); err != nil {
+		return fileChecksum{}, err
+	}
+
+	ranges := partRanges(info.Size(), partSize)
+	staged := make([]stagedPart, len(ranges))
+	parts := make([]completedPart, len(ranges))
+	whole := sha256.New()
+	log.Printf("Uploading %s to s3://%s/%s in %d parts", name, s.bucket, key, len(ranges))
+	stage := func(i int, r byteRange) error {
+		var err error
+		staged[i], err = writePartFile(src, r, scratch, whole)
+		return err
+	}
+	err = s.forEachPart("upload "+name, ranges, stage, func(i int, r byteRange) error {
+		var result struct{ ETag string }
+		if err := s.run(&result, "upload-part", "--bucket", s.bucket, "--key", key, "--upload-id", upload.UploadId,
+			"--part-number", fmt.Sprint(i+1), "--body", staged[i].file, "--content-md5", staged[i].md5); err != nil {
+			return err
+		}
+		os.Remove(staged[i].file)
+		parts[i] = completedPart{ETag: result.ETag, PartNumber: i + 1}
+		progress.add(r.Length)
+		return nil
+	})
+	if err != nil {
+		if abortErr := s.run(nil, "abort-multipart-upload", "--bucket", s.bucket, "--key", key, "--upload-id", upload.UploadId); abortErr != nil {
+			log.Printf("Failed to abort upload of %s: %v", name, abortErr)
+		}
+		return fileChecksum{}, fmt.Errorf("failed to upload %s: %w", name, err)
+	}
+
+	manifest, err := json.Marshal(map[string][]completedPart{"Parts": parts})
+	if err != nil {
+		return fileChecksum{}, fmt.Errorf("failed to encode part list: %w", err)
+	}
+	partList := filepath.Join(scratch, "parts.json")
+	if err := os.WriteFile(partList, manifest, 0600); err != nil {
+		return fileChecksum{}, fmt.Errorf("failed to write part list: %w", err)
+	}
+	if err := s.run(nil, "complete-multipart-upload", "--bucket", s.bucket, "--key", key,
+		"--upload-id", upload.UploadId, "--multipart-upload", "file://"+partList); err != nil {
+		return fileChecksum{}, err
+	}
+	progress.finish()
+	sum := fileChecksum{Size: info.Size(), SHA256: hex.EncodeToString(whole.Sum(nil))}
+	return sum, s.verifySize(name, sum.Size)
+}
+
+// size returns an object's size, or an error when it doesn't exist
+func (s *ObjectStore) size(name string) (int64, error) {
+	var head struct{ ContentLength int64 }
+	if err := s.run(&head, "head-object", "--bucket", s.bucket, "--key", s.key(name)); err != nil {
+		return 0, err
+	}
+	return head.ContentLength, nil
+}
+
+// verifySize checks the stored object has the size that was uploaded
+func (s *ObjectStore) verifySize(name string, want int64) error {
+	got, err := s.size(name)
+	if err != nil {
+		return fmt.Errorf("failed to verify upload of %s: %w", name, err)
+	}
+	if got != want {
+		return fmt.Errorf("uploaded %s has %d bytes, expected %d", name, got, want)
+	}
+	return nil
+}
+
+// Download copies the named object to a local file with concurrent ranged reads. With
+// an expected checksum, the downloaded file is verified against it.
+func (s *ObjectStore) Download(name, localPath string, expected *fileChecksum) error {
+	size, err := s.size(name)
+	if err != nil {
+		return fmt.Errorf("failed to find %s: %w", name, err)
+	}
+	if expected != nil && size != expected.Size {
+		return fmt.Errorf("stored %s has %d bytes, expected %d", name, size, expected.Size)
+	}
+	dst, err := os.Create(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", localPath, err)
+	}
+	defer dst.Close()
+	if err := dst.Truncate(size); err != nil {
+		return fmt.Errorf("failed to size %s: %w", localPath, err)
+	}
+	scratch, err := os.MkdirTemp("", "pg_restore_fdw_download_")
+	if err != nil {
+		return fmt.Errorf("failed to create scratch directory: %w", err)
+	}
+	defer os.RemoveAll(scratch)
+
+	key := s.key(name)
+	ranges := partRanges(size, partSizeFor(size, int64(s.config.PartSizeMB)<<20))
+	progress := newTransferProgress("Download "+name, size)
+	log.Printf("Downloading %s from s3://%s/%s in %d parts", name, s.bucket, key, len(ranges))
+	err = s.forEachPart("download "+name, ranges, nil, func(i int, r byteRange) error {
+		partFile := filepath.Join(scratch, fmt.Sprintf("part_%d", i+1))
+		defer os.Remove(partFile)
+		if err := s.run(nil, "get-object", "--bucket", s.bucket, "--key", key,
+			"--range", fmt.Sprintf("bytes=%d-%d", r.Offset, r.Offset+r.Length-1), partFile); err != nil {
+			return err
+		}
+		part, err := os.Open(partFile)
+		if err != nil {
+			return fmt.Errorf("failed to open downloaded part: %w", err)
+		}
+		defer part.Close()
+		n, err := io.Copy(io.NewOffsetWriter(dst, r.Offset), part)
+		if err != nil {
+			return fmt.Errorf("failed to write part of %s: %w", localPath, err)
+		}
+		if n != r.Length {
+			return fmt.Errorf("part %d of %s has %d bytes, expected %d", i+1, name, n, r.Length)
+		}
+		progress.add(n)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", name, err)
+	}
+	if err := dst.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", localPath, err)
+	}
+	progress.finish()
+
+	if expected == nil {
+		return nil
+	}
+	_, sum, err := hashFile(localPath)
+	if err != nil {
+		return err
+	}
+	if sum != *expected {
+		return fmt.Errorf("downloaded %s does not match its checksum (sha256 %s, expected %s)", name, sum.SHA256, expected.SHA256)
+	}
+	return nil
+}
+
+// UploadDumpSet uploads the dump set in dir with a checksums.json listing every file.
+// The manifest goes last, so a manifest in storage means the set is complete.
+func (s *ObjectStore) UploadDumpSet(dir string) error {
+	files, err := dumpSetFiles(dir)
+	if err != nil {
+		return err
+	}
+	checksums := make(map[string]fileChecksum)
+	// dumpSetFiles starts with the manifest
+	for _, name := range files[1:] {
+		if checksums[name], err = s.Upload(filepath.Join(dir, name), name); err != nil {
+			return err
+		}
+	}
+
+	content, err := json.MarshalIndent(checksums, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode checksums: %w", err)
+	}
+	checksumsPath := filepath.Join(dir, checksumsFileName)
+	if err := os.WriteFile(checksumsPath, content, 0644); err != nil {
+		return fmt.Errorf("failed to write checksums: %w", err)
+	}
+	if _, err := s.Upload(checksumsPath, checksumsFileName); err != nil {
+		return err
+	}
+	if _, err := s.Upload(filepath.Join(dir, manifestFileName), manifestFileName); err != nil {
+		return err
+	}
+	log.Printf("Uploaded %d files to %s", len(files), s.config.URL)
+	return nil
+}
+
+// DownloadDumpSet downloads the dump set stored at the location into dir, verifying
+// each file against checksums.json when the set has one
+func (s *ObjectStore) DownloadDumpSet(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create dump directory: %w", err)
+	}
+	if err := s.Download(manifestFileName, filepath.Join(dir, manifestFileName), nil); err != nil {
+		return err
+	}
+	var checksums map[string]fileChecksum
+	if _, err := s.size(checksumsFileName); err == nil {
+		checksumsPath := filepath.Join(dir, checksumsFileName)
+		if err := s.Download(checksumsFileName, checksumsPath, nil); err != nil {
+			return err
+		}
+		content, err := os.ReadFile(checksumsPath)
+		if err != nil {
+			return fmt.Errorf("failed to read checksums: %w", err)
+		}
+		if err := json.Unmarshal(content, &checksums); err != nil {
+			return fmt.Errorf("failed to parse checksums: %w", err)
+		}
+	} else {
+		log.Printf("WARNING: %s has no %s; downloaded files are not verified", s.config.URL, checksumsFileName)
+	}
+	download := func(name string) error {
+		var expected *fileChecksum
+		if sum, ok := checksums[name]; ok {
+			expected = &sum
+		} else if checksums != nil {
+			return fmt.Errorf("%s is not listed in %s", name, checksumsFileName)
+		}
+		return s.Download(name, filepath.Join(dir, name), expected)
+	}
+
+	if _, err := s.size(manifestFileName + ".asc"); err == nil {
+		if err := download(manifestFileName + ".asc"); err != nil {
+			return err
+		}
+	}
+	manifest, err := LoadManifest(dir)
+	if err != nil {
+		return err
+	}
+	count := 1
+	for _, a := range manifest.Artifacts {
+		if err := download(a.File); err != nil {
+			return err
+		}
+		// A split artifact's index names its parts
+		files, err := artifactFiles(dir, a.File)
+		if err != nil {
+			return err
+		}
+		for _, part := range files[1:] {
+			if err := download(part); err != nil {
+				return err
+			}
+		}
+		count += len(files)
+	}
+	log.Printf("Downloaded %d files from %s", count, s.config.URL)
+	return nil
+}
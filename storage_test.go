@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPartRanges(t *testing.T) {
+	ranges := partRanges(25, 10)
+	want := []byteRange{{0, 10}, {10, 10}, {20, 5}}
+	if len(ranges) != len(want) {
+		t.Fatalf("partRanges(25, 10) = %v, want %v", ranges, want)
+	}
+	for i := range want {
+		if ranges[i] != want[i] {
+			t.Errorf("range %d = %v, want %v", i, ranges[i], want[i])
+		}
+	}
+	if got := partRanges(0, 10); len(got) != 0 {
+		t.Errorf("partRanges(0, 10) = %v, want none", got)
+	}
+}
+
+func TestPartSizeFor(t *testing.T) {
+	if got := partSizeFor(100, 1); got != minPartSize {
+		t.Errorf("partSizeFor raised a tiny part size to %d, want %d", got, minPartSize)
+	}
+	size := int64(maxParts)*minPartSize + 1
+	if got := partSizeFor(size, minPartSize); got != 2*minPartSize {
+		t.Errorf("partSizeFor(%d) = %d, want %d", size, got, 2*minPartSize)
+	}
+}
+
+func TestNewObjectStore(t *testing.T) {
+	store, err := NewObjectStore(StorageConfig{URL: "gs://bucket/a/b/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.bucket != "bucket" || store.key("manifest.json") != "a/b/manifest.json" {
+		t.Errorf("parsed bucket %q key %q", store.bucket, store.key("manifest.json"))
+	}
+	if store.endpoint != gcsEndpoint || store.config.Concurrency != 8 || store.config.Attempts != 3 {
+		t.Errorf("defaults not applied: %+v", store)
+	}
+	for _, url := range []string{"", "s3://", "ftp://bucket/x"} {
+		if _, err := NewObjectStore(StorageConfig{URL: url}); err == nil {
+			t.Errorf("NewObjectStore(%q) succeeded", url)
+		}
+	}
+}
+
+func TestPartChecksums(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data")
+	content := strings.Repeat("0123456789", 100)
+	os.WriteFile(path, []byte(content), 0644)
+
+	_, want, err := hashFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want.Size != int64(len(content)) {
+		t.Errorf("hashFile size = %d, want %d", want.Size, len(content))
+	}
+
+	// Staging parts in order yields the checksum of the whole file
+	src, _ := os.Open(path)
+	defer src.Close()
+	whole := sha256.New()
+	for _, r := range partRanges(int64(len(content)), 300) {
+		part, err := writePartFile(src, r, dir, whole)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := os.ReadFile(part.file)
+		if string(got) != content[r.Offset:r.Offset+r.Length] {
+			t.Errorf("part at %d has the wrong bytes", r.Offset)
+		}
+	}
+	if got := hex.EncodeToString(whole.Sum(nil)); got != want.SHA256 {
+		t.Errorf("staged checksum %s, want %s", got, want.SHA256)
+	}
+}
+
+func TestFormatTransfer(t *testing.T) {
+	got := formatTransfer(50<<20, 100<<20, 10*time.Second)
+	if want := "50.0 of 100.0 MB (5.0 MB/s)"; got != want {
+		t.Errorf("formatTransfer = %q, want %q", got, want)
+	}
+}
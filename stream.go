@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stepLogDir receives a full output log per step; empty disables step log files
+var stepLogDir string
+
+// stepLogAttempts counts the runs of each step logged since the log directory was set,
+// so a retried step appends to its log instead of replacing the failure it retries
+var (
+	stepLogMu       sync.Mutex
+	stepLogAttempts = make(map[string]int)
+)
+
+// setStepLogDir directs step logs to a directory, creating it if needed
+func setStepLogDir(dir string) error {
+	stepLogMu.Lock()
+	stepLogAttempts = make(map[string]int)
+	stepLogMu.Unlock()
+	if dir == "" {
+		stepLogDir = ""
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+	stepLogDir = dir
+	return nil
+}
+
+// openStepLog opens a step's log: replaced by the step's first attempt, since it may be
+// left from an earlier run, and appended to by later ones under an attempt header
+func openStepLog(path string) (*os.File, error) {
+	stepLogMu.Lock()
+	stepLogAttempts[path]++
+	attempt := stepLogAttempts[path]
+	stepLogMu.Unlock()
+	if attempt == 1 {
+		return os.Create(path)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(f, "\n=== attempt %d ===\n", attempt)
+	return f, nil
+}
+
+// lineStreamer forwards complete output lines to the logger and progress monitor as they
+// arrive, while capturing the full output
+type lineStreamer struct {
+	mu      sync.Mutex
+	step    string
+	monitor *ProgressMonitor
+	partial []byte
+	output  bytes.Buffer
+	logFile io.Writer
+	// observe, when set, sees each line as it arrives
+	observe func(line string, at time.Time)
+}
+
+func (ls *lineStreamer) Write(p []byte) (int, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	ls.output.Write(p)
+	if ls.logFile != nil {
+		ls.logFile.Write(p)
+	}
+
+	ls.partial = append(ls.partial, p...)
+	for {
+		idx := bytes.IndexByte(ls.partial, '\n')
+		if idx < 0 {
+			break
+		}
+		ls.emit(string(ls.partial[:idx]))
+		ls.partial = ls.partial[idx+1:]
+	}
+	return len(p), nil
+}
+
+func (ls *lineStreamer) emit(line string) {
+	line = strings.TrimRight(line, "\r")
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	log.Printf("[%s] %s", ls.step, line)
+	if ls.observe != nil {
+		ls.observe(line, time.Now())
+	}
+	activeStatus.output(ls.step, line)
+	if ls.monitor != nil {
+		ls.monitor.Update(line)
+	}
+}
+
+// flush emits any trailing output that did not end with a newline
+func (ls *lineStreamer) flush() {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if len(ls.partial) > 0 {
+		ls.emit(string(ls.partial))
+		ls.partial = nil
+	}
+}
+
+// runStreaming runs cmd, streaming stdout and stderr line by line instead of buffering
+// until exit. It returns the combined output like CombinedOutput, and writes it to
+// <stepLogDir>/<step>.log when step logging is enabled. Stdout already redirected by the
+// caller (e.g. into an encryptor) is left alone and only stderr is streamed.
+func runStreaming(cmd *exec.Cmd, step string, monitor *ProgressMonitor) ([]byte, error) {
+	return runObserved(nil, cmd, step, monitor, nil)
+}
+
+// runObserved runs cmd like runStreaming, passing each line of output to observe as it
+// arrives when observe is set, with its span under parent when that is set
+func runObserved(parent *Span, cmd *exec.Cmd, step string, monitor *ProgressMonitor, observe func(line string, at time.Time)) ([]byte, error) {
+	streamer := &lineStreamer{step: step, monitor: monitor, observe: observe}
+
+	var logPath string
+	if stepLogDir != "" {
+		logPath = filepath.Join(stepLogDir, step+".log")
+		f, err := openStepLog(logPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create step log %s: %w", logPath, err)
+		}
+		defer f.Close()
+		fmt.Fprintf(f, "$ %s\n", strings.Join(cmd.Args, " "))
+		streamer.logFile = f
+	}
+
+	if cmd.Stdout == nil {
+		cmd.Stdout = streamer
+	}
+	cmd.Stderr = streamer
+
+	activePause.wait(step)
+	debugf("[%s] $ %s", step, strings.Join(cmd.Args, " "))
+	span := startChildSpan(parent, "command "+step, "process.executable.name", filepath.Base(cmd.Path))
+	activeStatus.stepStarted(step)
+	start := time.Now()
+	err := cmd.Run()
+	streamer.flush()
+	span.End(err)
+	activeStatus.stepFinished(step, err)
+	activeReport.recordStep(step, logPath, start, err)
+	return streamer.output.Bytes(), err
+}
+
+// stepName derives a log-friendly step name from an action and a file
+func stepName(action, file string) string {
+	base := filepath.Base(file)
+	base = strings.TrimSuffix(base, encryptedExt)
+	base = strings.TrimSuffix(base, gpgExt)
+	return action + "_" + strings.TrimSuffix(base, filepath.Ext(base))
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunStreaming(t *testing.T) {
+	dir := t.TempDir()
+	if err := setStepLogDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer setStepLogDir("")
+
+	cmd := exec.Command("sh", "-c", "echo first; echo second >&2; printf partial")
+	output, err := runStreaming(cmd, "restore_tenant_data", nil)
+	if err != nil {
+		t.Fatalf("runStreaming: %v", err)
+	}
+
+	for _, want := range []string{"first\n", "second\n", "partial"} {
+		if !strings.Contains(string(output), want) {
+			t.Errorf("output %q missing %q", output, want)
+		}
+	}
+
+	logged, err := os.ReadFile(filepath.Join(dir, "restore_tenant_data.log"))
+	if err != nil {
+		t.Fatalf("step log not written: %v", err)
+	}
+	if !strings.Contains(string(logged), "second") {
+		t.Errorf("step log %q missing stderr output", logged)
+	}
+}
+
+func TestRunStreamingKeepsRetriedAttempts(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "restore_tenant_pre-data.log")
+	if err := os.WriteFile(logPath, []byte("left from an earlier run\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := setStepLogDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer setStepLogDir("")
+
+	runStreaming(exec.Command("sh", "-c", "echo deadlock detected >&2; exit 1"), "restore_tenant_pre-data", nil)
+	if _, err := runStreaming(exec.Command("sh", "-c", "echo restored"), "restore_tenant_pre-data", nil); err != nil {
+		t.Fatalf("runStreaming: %v", err)
+	}
+
+	logged, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(logged), "earlier run") {
+		t.Errorf("step log kept an earlier run's output: %q", logged)
+	}
+	for _, want := range []string{"deadlock detected", "=== attempt 2 ===", "restored"} {
+		if !strings.Contains(string(logged), want) {
+			t.Errorf("step log %q missing %q", logged, want)
+		}
+	}
+}
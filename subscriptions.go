@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Subscription handling modes
+const (
+	// SubscriptionsKeep restores subscriptions as dumped (the default)
+	SubscriptionsKeep = "keep"
+	// SubscriptionsSkip leaves subscriptions out of the restore
+	SubscriptionsSkip = "skip"
+	// SubscriptionsDisable creates subscriptions with connect = false so they never pull
+	SubscriptionsDisable = "disable"
+	// SubscriptionsRewrite points subscriptions at the configured connection strings
+	SubscriptionsRewrite = "rewrite"
+)
+
+var (
+	// createSubscriptionRe matches "CREATE SUBSCRIPTION name CONNECTION '...'"
+	createSubscriptionRe = regexp.MustCompile(`(?s)CREATE SUBSCRIPTION (\S+) CONNECTION '((?:[^']|'')*)'(.*?);`)
+	// subscriptionWithRe matches the WITH (...) options of a CREATE SUBSCRIPTION
+	subscriptionWithRe = regexp.MustCompile(`(?s)WITH \((.*)\)`)
+	// subscriptionConnectRe matches the connect option inside WITH (...)
+	subscriptionConnectRe = regexp.MustCompile(`connect\s*=\s*\w+`)
+)
+
+// SubscriptionPolicy controls how logical replication objects are restored
+type SubscriptionPolicy struct {
+	Mode string `json:"mode"`
+	// Connections maps subscription names to the conninfo used in rewrite mode
+	Connections map[string]string `json:"connections"`
+	// DefaultConnection is used in rewrite mode for subscriptions not in Connections
+	DefaultConnection string `json:"default_connection"`
+	// SkipPublications leaves publications out of the restore
+	SkipPublications bool `json:"skip_publications"`
+}
+
+// isSubscriptionEntry reports whether a TOC entry belongs to a subscription
+func isSubscriptionEntry(entry TOCEntry) bool {
+	return entry.Desc == "SUBSCRIPTION" || entry.Desc == "SUBSCRIPTION TABLE"
+}
+
+// isPublicationEntry reports whether a TOC entry belongs to a publication
+func isPublicationEntry(entry TOCEntry) bool {
+	return strings.HasPrefix(entry.Desc, "PUBLICATION")
+}
+
+// split sorts TOC entries into those restored normally, subscriptions restored
+// separately after rewriting, and entries left out
+func (p SubscriptionPolicy) split(entries []TOCEntry) (keep, deferred, skipped []TOCEntry) {
+	for _, entry := range entries {
+		switch {
+		case isPublicationEntry(entry) && p.SkipPublications:
+			skipped = append(skipped, entry)
+		case isSubscriptionEntry(entry) && p.Mode == SubscriptionsSkip:
+			skipped = append(skipped, entry)
+		case isSubscriptionEntry(entry) && (p.Mode == SubscriptionsDisable || p.Mode == SubscriptionsRewrite):
+			deferred = append(deferred, entry)
+		default:
+			keep = append(keep, entry)
+		}
+	}
+	return keep, deferred, skipped
+}
+
+// rewriteScript applies the policy to the CREATE SUBSCRIPTION statements of a script
+func (p SubscriptionPolicy) rewriteScript(script string) (string, error) {
+	var rewriteErr error
+	result := createSubscriptionRe.ReplaceAllStringFunc(script, func(stmt string) string {
+		m := createSubscriptionRe.FindStringSubmatch(stmt)
+		name, conninfo, rest := m[1], "'"+m[2]+"'", m[3]
+
+		if p.Mode == SubscriptionsRewrite {
+			target, ok := p.Connections[strings.Trim(name, `"`)]
+			if !ok {
+				target = p.DefaultConnection
+			}
+			if target == "" {
+				rewriteErr = fmt.Errorf("no connection configured for subscription %s", name)
+				return stmt
+			}
+			conninfo = quoteLiteral(target)
+		} else {
+			rest = disableConnect(rest)
+		}
+		return fmt.Sprintf("CREATE SUBSCRIPTION %s CONNECTION %s%s;", name, conninfo, rest)
+	})
+	return result, rewriteErr
+}
+
+// disableConnect forces connect = false in the options following the connection string
+func disableConnect(rest string) string {
+	m := subscriptionWithRe.FindStringSubmatchIndex(rest)
+	if m == nil {
+		return rest + " WITH (connect = false)"
+	}
+	options := rest[m[2]:m[3]]
+	if subscriptionConnectRe.MatchString(options) {
+		options = subscriptionConnectRe.ReplaceAllString(options, "connect = false")
+	} else {
+		options = "connect = false, " + options
+	}
+	return rest[:m[2]] + options + rest[m[3]:]
+}
+
+// restoreSubscriptions renders the held-back subscription entries to SQL, applies the
+// policy, and runs the result through psql
+func restoreSubscriptions(config DBConfig, inputFile string, entries []TOCEntry, policy SubscriptionPolicy) error {
+	listFile, err := os.CreateTemp("", "pg_restore_fdw_*.list")
+	if err != nil {
+		return fmt.Errorf("failed to create TOC list file: %w", err)
+	}
+	listFile.Close()
+	defer os.Remove(listFile.Name())
+	if err := writeTOCList(listFile.Name(), entries); err != nil {
+		return err
+	}
+
+	script, err := newPgRestoreScriptCmd(listFile.Name(), inputFile).Output()
+	if err != nil {
+		return fmt.Errorf("failed to extract subscriptions from %s: %w", inputFile, err)
+	}
+	rewritten, err := policy.rewriteScript(string(script))
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Restoring %d subscription entries in %s mode", len(entries), policy.Mode)
+	cmd := newPsqlCmd(config, "-v", "ON_ERROR_STOP=1")
+	cmd.Stdin = strings.NewReader(rewritten)
+	if output, err := runStreaming(cmd, "subscriptions_"+config.DBName, nil); err != nil {
+		return fmt.Errorf("failed to restore subscriptions: %w\nOutput: %s", err, output)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSubscriptionRewriteScript(t *testing.T) {
+	script := `CREATE SUBSCRIPTION orders_sub CONNECTION 'host=prod dbname=orders' PUBLICATION orders_pub WITH (connect = true, slot_name = 'orders_sub');
+CREATE SUBSCRIPTION audit_sub CONNECTION 'host=prod dbname=audit' PUBLICATION audit_pub;`
+
+	disabled, err := SubscriptionPolicy{Mode: SubscriptionsDisable}.rewriteScript(script)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(disabled, "WITH (connect = false, slot_name = 'orders_sub');") {
+		t.Errorf("connect option not disabled:\n%s", disabled)
+	}
+	if !strings.Contains(disabled, "PUBLICATION audit_pub WITH (connect = false);") {
+		t.Errorf("connect option not added:\n%s", disabled)
+	}
+
+	policy := SubscriptionPolicy{
+		Mode:              SubscriptionsRewrite,
+		Connections:       map[string]string{"orders_sub": "host=staging dbname=orders"},
+		DefaultConnection: "host=staging password='x'",
+	}
+	rewritten, err := policy.rewriteScript(script)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(rewritten, "CONNECTION 'host=staging dbname=orders' PUBLICATION orders_pub WITH (connect = true") {
+		t.Errorf("orders_sub not rewritten:\n%s", rewritten)
+	}
+	if !strings.Contains(rewritten, "CONNECTION 'host=staging password=''x''' PUBLICATION audit_pub;") {
+		t.Errorf("audit_sub not rewritten to default:\n%s", rewritten)
+	}
+
+	policy.DefaultConnection = `host=staging password=a\b`
+	if rewritten, _ := policy.rewriteScript(script); !strings.Contains(rewritten, `CONNECTION E'host=staging password=a\\b' PUBLICATION audit_pub;`) {
+		t.Errorf("backslash not escaped:\n%s", rewritten)
+	}
+
+	policy.DefaultConnection = ""
+	if _, err := policy.rewriteScript(script); err == nil {
+		t.Error("expected error for subscription without a connection")
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// qualifyTable defaults an unqualified table name to the public schema
+func qualifyTable(table string) string {
+	if strings.Contains(table, ".") {
+		return table
+	}
+	return "public." + table
+}
+
+// tableArtifactName returns the default file a single-table dump is written to
+func tableArtifactName(dir, table string) string {
+	return filepath.Join(dir, "table_"+qualifyTable(table)+".dump")
+}
+
+// DumpTable dumps one table, its definition and data or only its data, into a
+// custom-format archive. pg_dump fails when the table doesn't exist.
+func DumpTable(config DBConfig, table, outputFile string, dataOnly bool) error {
+	table = qualifyTable(table)
+	dump := pgCommand("pg_dump", config).NoOwner().Arg(
+		"-Fc", "--strict-names", "-t", quoteQualifiedName(table), "-f", outputFile)
+	if dataOnly {
+		dump.Arg("--data-only")
+	}
+	dump.Arg(activeThrottle.dumpArgs()...)
+
+	monitor := NewProgressMonitor(fmt.Sprintf("Dump table %s", table))
+	start := time.Now()
+	err := RetryWithBackoff("dump table "+table, 3, func() error {
+		cmd := dump.Cmd()
+		release := activeThrottle.acquire()
+		defer release()
+		if output, err := runStreaming(cmd, stepName("dump", outputFile), monitor); err != nil {
+			return fmt.Errorf("failed to dump %s from %s: %w, output: %s", table, config.DBName, err, output)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	size := artifactSize(outputFile)
+	monitor.Finish(fmt.Sprintf("Dumped %s (%s) in %v", table, formatBytes(size), time.Since(start).Round(time.Second)), size, size)
+	return nil
+}
+
+// RestoreTable restores a single-table archive in one transaction, so a failed attempt
+// leaves nothing behind and can be retried. With clean, an existing table is dropped
+// first; otherwise data-only archives append to it, with the table's user triggers
+// kept from firing as triggers says.
+func RestoreTable(config DBConfig, table, inputFile string, clean bool, triggers string) error {
+	args := []string{"--single-transaction", "--exit-on-error"}
+	if clean {
+		args = append(args, "--clean", "--if-exists")
+		// The dropped table's triggers go with it
+		triggers = ""
+	}
+	args = append(args, inputFile)
+
+	monitor := NewProgressMonitor(fmt.Sprintf("Restore table %s", filepath.Base(inputFile)))
+	start := time.Now()
+	err := withTriggersOff(config, []string{qualifyTable(table)}, triggers, func(config DBConfig) error {
+		return RetryWithBackoff("restore table "+filepath.Base(inputFile), 3, func() error {
+			cmd := newPgRestoreCmd(config, args...)
+			if output, err := runStreaming(cmd, stepName("restore", inputFile), monitor); err != nil {
+				return fmt.Errorf("failed to restore %s into %s: %w, output: %s", inputFile, config.DBName, err, output)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	monitor.Finish(fmt.Sprintf("Restored into %s in %v", config.DBName, time.Since(start).Round(time.Second)), 0, 0)
+	return nil
+}
+
+// ValidateTableCopy compares a copied table with its source using the table's
+// configured validation strategy
+func ValidateTableCopy(src, dest DBConfig, table string, v *ValidationConfig) error {
+	if v == nil {
+		v = &ValidationConfig{}
+	}
+	table = qualifyTable(table)
+	mismatches, err := validateTable(src, dest, table, v)
+	if err != nil {
+		return err
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("%d validation mismatches:\n  %s", len(mismatches), strings.Join(mismatches, "\n  "))
+	}
+	log.Printf("%s in %s matches %s", table, dest.DBName, src.DBName)
+	return nil
+}
@@ -0,0 +1,14 @@
+package main
+
+import "testing"
+
+func TestQualifyTable(t *testing.T) {
+	for in, want := range map[string]string{"orders": "public.orders", "sales.orders": "sales.orders"} {
+		if got := qualifyTable(in); got != want {
+			t.Errorf("qualifyTable(%q) = %q, want %q", in, got, want)
+		}
+	}
+	if got := tableArtifactName("dump", "orders"); got != "dump/table_public.orders.dump" {
+		t.Errorf("tableArtifactName = %q", got)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ThrottleConfig limits the load dumps put on the source databases
+type ThrottleConfig struct {
+	// MaxMBPerSec caps the combined rate pg_dump output is accepted at. pg_dump can only
+	// read as fast as its output drains, so this bounds source reads too; custom-format
+	// output is compressed, so reads run somewhat faster than the cap.
+	MaxMBPerSec float64 `json:"max_mb_per_sec"`
+	// MaxDumpConnections caps how many pg_dump processes run at once
+	MaxDumpConnections int `json:"max_dump_connections"`
+	// StatementTimeout and LockTimeout are applied to sessions on the sources, e.g. "5min".
+	// pg_dump turns statement_timeout off for itself, and gets LockTimeout as
+	// --lock-wait-timeout instead.
+	StatementTimeout string `json:"statement_timeout"`
+	LockTimeout      string `json:"lock_timeout"`
+}
+
+// Throttle enforces a ThrottleConfig across every dump of the process
+type Throttle struct {
+	config  ThrottleConfig
+	slots   chan struct{}
+	limiter *rateLimiter
+}
+
+// activeThrottle limits dumps; nil leaves them unthrottled
+var activeThrottle *Throttle
+
+// NewThrottle returns nil when the config sets no limits
+func NewThrottle(config ThrottleConfig) *Throttle {
+	if config == (ThrottleConfig{}) {
+		return nil
+	}
+	t := &Throttle{config: config}
+	if config.MaxDumpConnections > 0 {
+		t.slots = make(chan struct{}, config.MaxDumpConnections)
+	}
+	if config.MaxMBPerSec > 0 {
+		t.limiter = &rateLimiter{bytesPerSec: config.MaxMBPerSec * 1024 * 1024}
+	}
+	return t
+}
+
+// acquire waits for a dump connection slot and returns its release
+func (t *Throttle) acquire() func() {
+	if t == nil || t.slots == nil {
+		return func() {}
+	}
+	t.slots <- struct{}{}
+	return func() { <-t.slots }
+}
+
+// limitsBandwidth reports whether dump output must pass through limit
+func (t *Throttle) limitsBandwidth() bool {
+	return t != nil && t.limiter != nil
+}
+
+// limit wraps a dump's output writer with the shared rate limit
+func (t *Throttle) limit(w io.Writer) io.Writer {
+	if !t.limitsBandwidth() {
+		return w
+	}
+	return &limitedWriter{w: w, limiter: t.limiter}
+}
+
+// dumpArgs returns pg_dump arguments for the throttle
+func (t *Throttle) dumpArgs() []string {
+	if t == nil || t.config.LockTimeout == "" {
+		return nil
+	}
+	return []string{"--lock-wait-timeout=" + t.config.LockTimeout}
+}
+
+// sessionOptions returns PGOPTIONS setting the timeouts on source sessions
+func (t *Throttle) sessionOptions() string {
+	if t == nil {
+		return ""
+	}
+	var opts []string
+	if t.config.StatementTimeout != "" {
+		opts = append(opts, "-c statement_timeout="+t.config.StatementTimeout)
+	}
+	if t.config.LockTimeout != "" {
+		opts = append(opts, "-c lock_timeout="+t.config.LockTimeout)
+	}
+	return strings.Join(opts, " ")
+}
+
+// source returns config with the session timeouts applied, for reading a source
+func (t *Throttle) source(config DBConfig) DBConfig {
+	if opts := t.sessionOptions(); opts != "" {
+		config.Options = strings.TrimSpace(config.Options + " " + opts)
+	}
+	return config
+}
+
+// validate rejects timeouts that would break PGOPTIONS
+func (c ThrottleConfig) validate() error {
+	for name, v := range map[string]string{"statement_timeout": c.StatementTimeout, "lock_timeout": c.LockTimeout} {
+		if strings.ContainsAny(v, " \\'\"") {
+			return fmt.Errorf("%s %q must not contain spaces or quotes", name, v)
+		}
+	}
+	if c.MaxMBPerSec < 0 || c.MaxDumpConnections < 0 {
+		return fmt.Errorf("throttle limits must not be negative")
+	}
+	return nil
+}
+
+// rateLimiter spaces out writes so their combined rate stays under bytesPerSec
+type rateLimiter struct {
+	mu          sync.Mutex
+	bytesPerSec float64
+	next        time.Time
+}
+
+// wait blocks until n more bytes may be written
+func (l *rateLimiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(float64(n) / l.bytesPerSec * float64(time.Second)))
+	l.mu.Unlock()
+	time.Sleep(delay)
+}
+
+type limitedWriter struct {
+	w       io.Writer
+	limiter *rateLimiter
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	lw.limiter.wait(len(p))
+	return lw.w.Write(p)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestLimitedWriter(t *testing.T) {
+	throttle := NewThrottle(ThrottleConfig{MaxMBPerSec: 1})
+	var buf bytes.Buffer
+	w := throttle.limit(&buf)
+
+	chunk := make([]byte, 256*1024)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		w.Write(chunk)
+	}
+	// The first chunk goes straight through; the next two wait a quarter second each
+	if elapsed := time.Since(start); elapsed < 450*time.Millisecond {
+		t.Errorf("wrote 768KiB at 1MB/s in %v", elapsed)
+	}
+	if buf.Len() != 3*len(chunk) {
+		t.Errorf("wrote %d bytes", buf.Len())
+	}
+}
+
+func TestThrottleOptions(t *testing.T) {
+	if NewThrottle(ThrottleConfig{}) != nil {
+		t.Error("expected no throttle without limits")
+	}
+	throttle := NewThrottle(ThrottleConfig{StatementTimeout: "5min", LockTimeout: "10s"})
+	if got := throttle.sessionOptions(); got != "-c statement_timeout=5min -c lock_timeout=10s" {
+		t.Errorf("sessionOptions = %q", got)
+	}
+	if got := throttle.dumpArgs(); len(got) != 1 || got[0] != "--lock-wait-timeout=10s" {
+		t.Errorf("dumpArgs = %v", got)
+	}
+	if got := throttle.source(DBConfig{Options: "-c work_mem=64MB"}).Options; got != "-c work_mem=64MB -c statement_timeout=5min -c lock_timeout=10s" {
+		t.Errorf("source options = %q", got)
+	}
+	if got := (*Throttle)(nil).source(DBConfig{}).Options; got != "" {
+		t.Errorf("unthrottled source options = %q", got)
+	}
+	if err := (ThrottleConfig{StatementTimeout: "5 min"}).validate(); err == nil {
+		t.Error("expected a timeout with a space to be rejected")
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// TOCEntry is a single line of a pg_restore table of contents listing
+type TOCEntry struct {
+	Line   string
+	DumpID int
+	Desc   string
+	Schema string
+	Name   string
+	Owner  string
+}
+
+// tocDescs lists multi-word TOC descriptors, longest first so prefixes don't shadow them
+var tocDescs = []string{
+	"MATERIALIZED VIEW DATA",
+	"FOREIGN DATA WRAPPER",
+	"SEQUENCE OWNED BY",
+	"MATERIALIZED VIEW",
+	"PUBLICATION TABLE",
+	"PUBLICATION TABLES IN SCHEMA",
+	"DEFAULT ACL",
+	"EVENT TRIGGER",
+	"FOREIGN TABLE",
+	"FK CONSTRAINT",
+	"INDEX ATTACH",
+	"LARGE OBJECT",
+	"SEQUENCE SET",
+	"TABLE ATTACH",
+	"TABLE DATA",
+	"USER MAPPING",
+	"SUBSCRIPTION TABLE",
+}
+
+// parseTOC parses the output of pg_restore -l, skipping comments and blank lines
+func parseTOC(listing string) []TOCEntry {
+	var entries []TOCEntry
+	scanner := bufio.NewScanner(strings.NewReader(listing))
+	for scanner.Scan() {
+		if entry, ok := parseTOCLine(scanner.Text()); ok {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// parseTOCLine parses a line such as "3365; 0 16390 TABLE DATA public customer_transactions postgres"
+func parseTOCLine(line string) (TOCEntry, bool) {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || strings.HasPrefix(trimmed, ";") {
+		return TOCEntry{}, false
+	}
+
+	idPart, rest, found := strings.Cut(trimmed, ";")
+	if !found {
+		return TOCEntry{}, false
+	}
+	dumpID, err := strconv.Atoi(strings.TrimSpace(idPart))
+	if err != nil {
+		return TOCEntry{}, false
+	}
+
+	// Skip the catalog table OID and object OID
+	fields := strings.Fields(rest)
+	if len(fields) < 3 {
+		return TOCEntry{}, false
+	}
+	rest = strings.Join(fields[2:], " ")
+
+	entry := TOCEntry{Line: line, DumpID: dumpID}
+	for _, desc := range tocDescs {
+		if strings.HasPrefix(rest, desc+" ") {
+			entry.Desc = desc
+			rest = strings.TrimPrefix(rest, desc+" ")
+			break
+		}
+	}
+
+	fields = strings.Fields(rest)
+	if entry.Desc == "" {
+		if len(fields) == 0 {
+			return TOCEntry{}, false
+		}
+		entry.Desc = fields[0]
+		fields = fields[1:]
+	}
+
+	switch {
+	case len(fields) >= 3:
+		entry.Schema = fields[0]
+		entry.Name = strings.Join(fields[1:len(fields)-1], " ")
+		entry.Owner = fields[len(fields)-1]
+	case len(fields) == 2:
+		entry.Schema = fields[0]
+		entry.Name = fields[1]
+	case len(fields) == 1:
+		entry.Name = fields[0]
+	}
+
+	return entry, true
+}
+
+// QualifiedName returns schema.name for the entry
+func (e TOCEntry) QualifiedName() string {
+	if e.Schema == "" || e.Schema == "-" {
+		return e.Name
+	}
+	return e.Schema + "." + e.Name
+}
+
+// QuotedName returns the entry's name quoted for use in SQL, schema-qualified when it has one
+func (e TOCEntry) QuotedName() string {
+	if e.Schema == "" || e.Schema == "-" {
+		return quoteIdent(e.Name)
+	}
+	return quoteIdent(e.Schema) + "." + quoteIdent(e.Name)
+}
+
+// listTOC runs pg_restore -l against a custom-format dump and parses the result
+func listTOC(inputFile string) ([]TOCEntry, error) {
+	output, err := newPgRestoreListCmd(inputFile).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list TOC of %s: %w\nOutput: %s", inputFile, err, output)
+	}
+	return parseTOC(string(output)), nil
+}
+
+// listTOCStream reads the table of contents of an archive from a stream
+func listTOCStream(path string, open func() (io.ReadCloser, error)) ([]TOCEntry, error) {
+	in, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	cmd := exec.Command("pg_restore", "-l")
+	cmd.Env = subprocessEnv()
+	cmd.Stdin = in
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list TOC of %s: %w\nOutput: %s", path, err, output)
+	}
+	return parseTOC(string(output)), nil
+}
+
+// writeTOCList writes entries to a list file usable with pg_restore -L
+func writeTOCList(path string, entries []TOCEntry) error {
+	var b strings.Builder
+	for _, entry := range entries {
+		b.WriteString(entry.Line)
+		b.WriteString("\n")
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write TOC list %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestParseTOC(t *testing.T) {
+	listing := `;
+; Archive created at 2024-06-01 10:00:00 UTC
+;     dbname: tenant
+;
+3365; 0 16390 TABLE DATA public customer_transactions postgres
+3372; 0 0 SEQUENCE SET public customer_transactions_id_seq postgres
+3218; 2606 16397 CONSTRAINT public customer_transactions customer_transactions_pkey postgres
+3219; 1259 16399 INDEX public idx_customer_transactions_amount postgres
+
+`
+	entries := parseTOC(listing)
+	if len(entries) != 4 {
+		t.Fatalf("expected 4 entries, got %d", len(entries))
+	}
+
+	tests := []struct {
+		desc, schema, name, owner string
+		dumpID                    int
+	}{
+		{"TABLE DATA", "public", "customer_transactions", "postgres", 3365},
+		{"SEQUENCE SET", "public", "customer_transactions_id_seq", "postgres", 3372},
+		{"CONSTRAINT", "public", "customer_transactions customer_transactions_pkey", "postgres", 3218},
+		{"INDEX", "public", "idx_customer_transactions_amount", "postgres", 3219},
+	}
+	for i, tt := range tests {
+		got := entries[i]
+		if got.Desc != tt.desc || got.Schema != tt.schema || got.Name != tt.name ||
+			got.Owner != tt.owner || got.DumpID != tt.dumpID {
+			t.Errorf("entry %d: got %+v, want %+v", i, got, tt)
+		}
+	}
+
+	if q := entries[0].QualifiedName(); q != "public.customer_transactions" {
+		t.Errorf("unexpected qualified name %q", q)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// slowestObjectsLogged is how many of a step's slowest objects are logged and reported
+const slowestObjectsLogged = 20
+
+// pg_restore --verbose lines that start restoring a TOC entry. Parallel workers report
+// launching and finishing each item; serial restores only report starts, so an entry
+// runs until the next one starts.
+var (
+	tocLaunchRe = regexp.MustCompile(`^pg_restore: launching item (\d+) (.+)$`)
+	tocFinishRe = regexp.MustCompile(`^pg_restore: finished item (\d+) (.+)$`)
+	tocSerialRe = regexp.MustCompile(`^pg_restore: (?:processing item \d+ (.+)|creating (.+)|processing data for table (.+))$`)
+)
+
+// ObjectTiming is how long one TOC entry took to restore
+type ObjectTiming struct {
+	Step string `json:"step"`
+	// Object is the entry as pg_restore names it, e.g. INDEX "public.idx_orders_date"
+	Object   string        `json:"object"`
+	Duration time.Duration `json:"-"`
+	Elapsed  string        `json:"duration"`
+}
+
+// tocTimer times TOC entries from pg_restore --verbose output as its lines arrive
+type tocTimer struct {
+	mu       sync.Mutex
+	step     string
+	serial   *ObjectTiming
+	started  time.Time
+	parallel map[string]time.Time
+	names    map[string]string
+	timings  []ObjectTiming
+}
+
+func newTOCTimer(step string) *tocTimer {
+	return &tocTimer{step: step, parallel: make(map[string]time.Time), names: make(map[string]string)}
+}
+
+// observe records a line of output that arrived at t
+func (tt *tocTimer) observe(line string, t time.Time) {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	if m := tocFinishRe.FindStringSubmatch(line); m != nil {
+		if start, ok := tt.parallel[m[1]]; ok {
+			tt.add(tt.names[m[1]], t.Sub(start))
+			delete(tt.parallel, m[1])
+		}
+		return
+	}
+	if m := tocLaunchRe.FindStringSubmatch(line); m != nil {
+		tt.endSerial(t)
+		tt.parallel[m[1]], tt.names[m[1]] = t, m[2]
+		return
+	}
+	if m := tocSerialRe.FindStringSubmatch(line); m != nil {
+		tt.endSerial(t)
+		object := m[1] + m[2]
+		if m[3] != "" {
+			object = "TABLE DATA " + m[3]
+		}
+		tt.serial, tt.started = &ObjectTiming{Object: object}, t
+	}
+}
+
+// endSerial closes the serial entry in progress, if any
+func (tt *tocTimer) endSerial(t time.Time) {
+	if tt.serial != nil {
+		tt.add(tt.serial.Object, t.Sub(tt.started))
+		tt.serial = nil
+	}
+}
+
+func (tt *tocTimer) add(object string, d time.Duration) {
+	tt.timings = append(tt.timings, ObjectTiming{Step: tt.step, Object: object, Duration: d, Elapsed: d.Round(time.Millisecond).String()})
+}
+
+// slowest closes the entries still open at t, when pg_restore exited, and returns the
+// n slowest entries, slowest first
+func (tt *tocTimer) slowest(t time.Time, n int) []ObjectTiming {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	tt.endSerial(t)
+	for id, start := range tt.parallel {
+		tt.add(tt.names[id], t.Sub(start))
+	}
+	tt.parallel = make(map[string]time.Time)
+	timings := append([]ObjectTiming{}, tt.timings...)
+	sort.SliceStable(timings, func(i, j int) bool { return timings[i].Duration > timings[j].Duration })
+	if len(timings) > n {
+		timings = timings[:n]
+	}
+	return timings
+}
+
+// formatObjectTimings lays out timings as a table for the log
+func formatObjectTimings(timings []ObjectTiming) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%12s  %s\n", "duration", "object")
+	for _, t := range timings {
+		fmt.Fprintf(&b, "%12s  %s\n", t.Elapsed, t.Object)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// reportSlowestObjects logs and records the slowest entries a step restored
+func reportSlowestObjects(tt *tocTimer, end time.Time) {
+	if tt == nil {
+		return
+	}
+	timings := tt.slowest(end, slowestObjectsLogged)
+	if len(timings) == 0 {
+		return
+	}
+	log.Printf("Slowest objects restored by %s:\n%s", tt.step, formatObjectTimings(timings))
+	activeReport.recordObjectTimings(timings)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTOCTimerSerial(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tt := newTOCTimer("restore_tenant_post-data")
+	tt.observe(`pg_restore: creating CONSTRAINT "public.orders orders_pkey"`, start)
+	tt.observe(`pg_restore: creating INDEX "public.idx_orders_date"`, start.Add(2*time.Second))
+	tt.observe(`pg_restore: warning: something unrelated`, start.Add(3*time.Second))
+	got := tt.slowest(start.Add(12*time.Second), 5)
+	if len(got) != 2 || got[0].Object != `INDEX "public.idx_orders_date"` || got[0].Duration != 10*time.Second ||
+		got[1].Duration != 2*time.Second {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestTOCTimerParallel(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tt := newTOCTimer("restore_tenant_data")
+	tt.observe(`pg_restore: processing item 210 SCHEMA public`, start)
+	tt.observe(`pg_restore: launching item 3344 TABLE DATA public orders`, start.Add(time.Second))
+	tt.observe(`pg_restore: launching item 3345 TABLE DATA public items`, start.Add(time.Second))
+	tt.observe(`pg_restore: finished item 3345 TABLE DATA public items`, start.Add(4*time.Second))
+	tt.observe(`pg_restore: finished item 3344 TABLE DATA public orders`, start.Add(9*time.Second))
+	got := tt.slowest(start.Add(10*time.Second), 2)
+	if len(got) != 2 || got[0].Object != "TABLE DATA public orders" || got[0].Duration != 8*time.Second ||
+		got[1].Object != "TABLE DATA public items" || got[1].Elapsed != "3s" {
+		t.Errorf("got %+v", got)
+	}
+}
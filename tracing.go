@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TracingConfig exports workflow spans to an OpenTelemetry collector over OTLP/HTTP
+type TracingConfig struct {
+	// Endpoint is the collector base URL, e.g. http://otel-collector:4318; defaults to
+	// OTEL_EXPORTER_OTLP_ENDPOINT
+	Endpoint    string            `json:"endpoint"`
+	ServiceName string            `json:"service_name"`
+	Headers     map[string]string `json:"headers"`
+}
+
+// Span is a timed operation within a trace
+type Span struct {
+	tracer     *Tracer
+	traceID    string
+	spanID     string
+	parentID   string
+	name       string
+	start      time.Time
+	end        time.Time
+	attributes map[string]string
+	err        error
+}
+
+// Tracer records spans in call order and exports them in one OTLP batch on Flush.
+// Spans started while another is open become its children. Concurrent tasks can't share
+// that stack, so while tasks run it is frozen: tasks pass their parent explicitly, and
+// spans started without one become children of the span that started the tasks.
+type Tracer struct {
+	mu       sync.Mutex
+	config   TracingConfig
+	traceID  string
+	stack    []*Span
+	frozen   int
+	finished []*Span
+	client   *http.Client
+}
+
+// activeTracer receives workflow spans; nil disables tracing
+var activeTracer *Tracer
+
+// randomHex returns n random bytes hex-encoded
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// NewTracer creates a tracer, or nil when no endpoint is configured
+func NewTracer(cfg *TracingConfig) *Tracer {
+	var config TracingConfig
+	if cfg != nil {
+		config = *cfg
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	}
+	if config.Endpoint == "" {
+		return nil
+	}
+	if config.ServiceName == "" {
+		config.ServiceName = "pg_restore_fdw"
+	}
+	return &Tracer{
+		config:  config,
+		traceID: randomHex(16),
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// startSpan opens a span on the active tracer. It is safe to call when tracing is disabled.
+func startSpan(name string, attrs ...string) *Span {
+	return startChildSpan(nil, name, attrs...)
+}
+
+// startChildSpan opens a span under parent, or under the innermost open span when parent
+// is nil. Only spans without an explicit parent, started while no tasks run, become
+// parents of the spans started after them.
+func startChildSpan(parent *Span, name string, attrs ...string) *Span {
+	t := activeTracer
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	span := &Span{
+		tracer:     t,
+		traceID:    t.traceID,
+		spanID:     randomHex(8),
+		name:       name,
+		start:      time.Now(),
+		attributes: make(map[string]string),
+	}
+	if parent != nil {
+		span.parentID = parent.spanID
+	} else if len(t.stack) > 0 {
+		span.parentID = t.stack[len(t.stack)-1].spanID
+	}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		span.attributes[attrs[i]] = attrs[i+1]
+	}
+	if parent == nil && t.frozen == 0 {
+		t.stack = append(t.stack, span)
+	}
+	return span
+}
+
+// currentSpan returns the innermost open span, nil when there is none
+func currentSpan() *Span {
+	t := activeTracer
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.stack) == 0 {
+		return nil
+	}
+	return t.stack[len(t.stack)-1]
+}
+
+// freezeSpans keeps spans started until the returned function is called from becoming
+// parents, for the duration of concurrent tasks
+func freezeSpans() func() {
+	t := activeTracer
+	if t == nil {
+		return func() {}
+	}
+	t.mu.Lock()
+	t.frozen++
+	t.mu.Unlock()
+	return func() {
+		t.mu.Lock()
+		t.frozen--
+		t.mu.Unlock()
+	}
+}
+
+// SetAttribute adds a string attribute to the span
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.tracer.mu.Lock()
+	s.attributes[key] = value
+	s.tracer.mu.Unlock()
+}
+
+// End closes the span, recording err as its status
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	t := s.tracer
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s.end = time.Now()
+	s.err = err
+	for i := len(t.stack) - 1; i >= 0; i-- {
+		if t.stack[i] == s {
+			t.stack = append(t.stack[:i], t.stack[i+1:]...)
+			break
+		}
+	}
+	t.finished = append(t.finished, s)
+}
+
+// otlpAttribute is a key/value attribute in OTLP JSON encoding
+type otlpAttribute struct {
+	Key   string            `json:"key"`
+	Value map[string]string `json:"value"`
+}
+
+func otlpAttributes(attrs map[string]string) []otlpAttribute {
+	out := make([]otlpAttribute, 0, len(attrs))
+	for k, v := range attrs {
+		out = append(out, otlpAttribute{Key: k, Value: map[string]string{"stringValue": v}})
+	}
+	return out
+}
+
+// payload encodes the finished spans as an OTLP/JSON ExportTraceServiceRequest
+func (t *Tracer) payload() ([]byte, int) {
+	t.mu.Lock()
+	spans := t.finished
+	t.finished = nil
+	t.mu.Unlock()
+
+	encoded := make([]map[string]any, 0, len(spans))
+	for _, s := range spans {
+		status := map[string]any{"code": 1}
+		if s.err != nil {
+			status = map[string]any{"code": 2, "message": s.err.Error()}
+		}
+		span := map[string]any{
+			"traceId":           s.traceID,
+			"spanId":            s.spanID,
+			"name":              s.name,
+			"kind":              1,
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        otlpAttributes(s.attributes),
+			"status":            status,
+		}
+		if s.parentID != "" {
+			span["parentSpanId"] = s.parentID
+		}
+		encoded = append(encoded, span)
+	}
+
+	request := map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": otlpAttributes(map[string]string{"service.name": t.config.ServiceName}),
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]string{"name": "pg_restore_fdw"},
+				"spans": encoded,
+			}},
+		}},
+	}
+	body, _ := json.Marshal(request)
+	return body, len(spans)
+}
+
+// Flush exports all finished spans to the collector
+func (t *Tracer) Flush() error {
+	if t == nil {
+		return nil
+	}
+
+	body, count := t.payload()
+	if count == 0 {
+		return nil
+	}
+
+	url := strings.TrimSuffix(t.config.Endpoint, "/") + "/v1/traces"
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build OTLP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.config.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to export spans: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("collector rejected spans: %s", resp.Status)
+	}
+
+	log.Printf("Exported %d spans of trace %s to %s", count, t.traceID, url)
+	return nil
+}
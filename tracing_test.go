@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestTracerExportsNestedSpans(t *testing.T) {
+	var received map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &received)
+	}))
+	defer server.Close()
+
+	activeTracer = NewTracer(&TracingConfig{Endpoint: server.URL})
+	defer func() { activeTracer = nil }()
+
+	phase := startSpan("phase restore")
+	section := startSpan("restore tenant data", "db.name", "tenant_dest")
+	section.End(errors.New("boom"))
+	phase.End(nil)
+
+	if err := activeTracer.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	spans := received["resourceSpans"].([]any)[0].(map[string]any)["scopeSpans"].([]any)[0].(map[string]any)["spans"].([]any)
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	child, parent := spans[0].(map[string]any), spans[1].(map[string]any)
+	if child["parentSpanId"] != parent["spanId"] {
+		t.Errorf("child span not parented to phase span")
+	}
+	if child["status"].(map[string]any)["code"].(float64) != 2 {
+		t.Errorf("expected error status on failed span")
+	}
+}
+
+func TestConcurrentTaskSpans(t *testing.T) {
+	activeTracer = NewTracer(&TracingConfig{Endpoint: "http://collector.invalid"})
+	defer func() { activeTracer = nil }()
+
+	// Both tasks hold their spans open until the other has started its own
+	var started sync.WaitGroup
+	started.Add(2)
+	task := func(name string) func(*Span) error {
+		return func(parent *Span) error {
+			span := startChildSpan(parent, "restore "+name)
+			command := startChildSpan(span, "command "+name)
+			unparented := startSpan("unparented " + name)
+			started.Done()
+			started.Wait()
+			unparented.End(nil)
+			command.End(nil)
+			span.End(nil)
+			return nil
+		}
+	}
+	phase := startSpan("phase restore")
+	if err := runTasks([]Task{{Name: "moodys", Run: task("moodys")}, {Name: "tenant", Run: task("tenant")}}); err != nil {
+		t.Fatal(err)
+	}
+	after := startSpan("after tasks")
+	after.End(nil)
+	phase.End(nil)
+
+	byName := make(map[string]*Span)
+	for _, s := range activeTracer.finished {
+		byName[s.name] = s
+	}
+	for _, name := range []string{"moodys", "tenant"} {
+		if got := byName["restore "+name].parentID; got != phase.spanID {
+			t.Errorf("restore %s span has parent %q, want the phase span %q", name, got, phase.spanID)
+		}
+		if got := byName["command "+name].parentID; got != byName["restore "+name].spanID {
+			t.Errorf("command %s span not under its restore span", name)
+		}
+		if got := byName["unparented "+name].parentID; got != phase.spanID {
+			t.Errorf("span started without a parent inside task %s has parent %q, want the phase span", name, got)
+		}
+	}
+	if byName["after tasks"].parentID != phase.spanID {
+		t.Error("span started after the tasks not under the phase span")
+	}
+	if len(activeTracer.stack) != 0 {
+		t.Errorf("spans left open: %d", len(activeTracer.stack))
+	}
+}
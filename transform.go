@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+)
+
+// TransformRule rewrites plain sections before they are restored, e.g. to replace a
+// hostname hard-coded in function bodies or change a column default. A rule either
+// rewrites lines with Pattern and Replace or pipes the script through Command.
+type TransformRule struct {
+	Name string `json:"name"`
+	// Database limits the rule to moodys, tenant, or a workflow database; empty applies
+	// it to all
+	Database string `json:"database"`
+	// Sections the rule applies to; pre-data when empty
+	Sections []string `json:"sections"`
+	// Pattern is a regular expression matched against each line outside COPY data
+	Pattern string `json:"pattern"`
+	// Replace replaces matches, with $1-style references to the pattern's groups
+	Replace string `json:"replace"`
+	// Command runs through sh -c with the script on stdin and writes the rewritten
+	// script to stdout, with PG_RESTORE_FDW_DATABASE and PG_RESTORE_FDW_SECTION set
+	Command string `json:"command"`
+}
+
+// validateTransforms checks every rule
+func validateTransforms(rules []TransformRule) error {
+	for _, rule := range rules {
+		if rule.Name == "" {
+			return fmt.Errorf("transform rules need a name")
+		}
+		if (rule.Pattern == "") == (rule.Command == "") {
+			return fmt.Errorf("transform %s: set either pattern or command", rule.Name)
+		}
+		if rule.Pattern != "" {
+			if _, err := regexp.Compile(rule.Pattern); err != nil {
+				return fmt.Errorf("transform %s: %w", rule.Name, err)
+			}
+		}
+		for _, section := range rule.Sections {
+			switch section {
+			case "pre-data", "data", "post-data":
+			default:
+				return fmt.Errorf("transform %s: unknown section %q", rule.Name, section)
+			}
+		}
+	}
+	return nil
+}
+
+// appliesTo reports whether a rule rewrites a database's section
+func (rule TransformRule) appliesTo(database, section string) bool {
+	if rule.Database != "" && rule.Database != database {
+		return false
+	}
+	if len(rule.Sections) == 0 {
+		return section == "pre-data"
+	}
+	for _, s := range rule.Sections {
+		if s == section {
+			return true
+		}
+	}
+	return false
+}
+
+// transformsFor returns the rules that rewrite a database's section, in order
+func transformsFor(rules []TransformRule, database, section string) []TransformRule {
+	var selected []TransformRule
+	for _, rule := range rules {
+		if rule.appliesTo(database, section) {
+			selected = append(selected, rule)
+		}
+	}
+	return selected
+}
+
+// transformScript writes src rewritten by one rule to dst, returning how many lines a
+// pattern rule changed
+func (rule TransformRule) transformScript(database, section string, src io.Reader, dst io.Writer) (int, error) {
+	if rule.Command != "" {
+		var stderr bytes.Buffer
+		cmd := exec.Command("sh", "-c", rule.Command)
+		cmd.Env = append(os.Environ(), "PG_RESTORE_FDW_DATABASE="+database, "PG_RESTORE_FDW_SECTION="+section)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = src, dst, &stderr
+		if err := cmd.Run(); err != nil {
+			return 0, fmt.Errorf("transform %s failed: %w, output: %s", rule.Name, err, stderr.String())
+		}
+		return 0, nil
+	}
+	// Pattern rules rewrite lines the way compatibility rules do, leaving COPY data alone
+	layer := &compatLayer{
+		rules: []compiledCompatRule{{CompatRule{Name: rule.Name, Pattern: rule.Pattern, Replace: rule.Replace}, regexp.MustCompile(rule.Pattern)}},
+		hits:  make(map[string]int),
+	}
+	if err := layer.rewriteScript(src, dst); err != nil {
+		return 0, err
+	}
+	return layer.hits[rule.Name], nil
+}
+
+// applyTransforms returns a copy of a plain section rewritten by the rules that apply to
+// it, or the input itself when none do or it is an archive, compressed, or split
+func applyTransforms(rules []TransformRule, database, section, inputFile string) (string, func(), error) {
+	none := func() {}
+	rules = transformsFor(rules, database, section)
+	if len(rules) == 0 {
+		return inputFile, none, nil
+	}
+	format, err := DetectArtifactFormat(inputFile)
+	if err != nil {
+		return "", none, err
+	}
+	if format.Kind != FormatPlain || format.Compression != "" || isPartsIndex(inputFile) {
+		log.Printf("WARNING: %s is not an uncompressed plain script; transforms are not applied to it", filepath.Base(inputFile))
+		return inputFile, none, nil
+	}
+
+	dir, err := os.MkdirTemp("", "pg_restore_fdw_transform_")
+	if err != nil {
+		return "", none, fmt.Errorf("failed to create transform directory: %w", err)
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	current := inputFile
+	for i, rule := range rules {
+		next := filepath.Join(dir, fmt.Sprintf("%d_%s", i, filepath.Base(inputFile)))
+		lines, err := transformFile(rule, database, section, current, next)
+		if err != nil {
+			cleanup()
+			return "", none, err
+		}
+		if rule.Pattern != "" {
+			log.Printf("Transform %s rewrote %d lines of %s", rule.Name, lines, filepath.Base(inputFile))
+		} else {
+			log.Printf("Transform %s rewrote %s", rule.Name, filepath.Base(inputFile))
+		}
+		current = next
+	}
+	return current, cleanup, nil
+}
+
+// transformFile rewrites one file into another with a rule
+func transformFile(rule TransformRule, database, section, inputFile, outputFile string) (int, error) {
+	in, err := os.Open(inputFile)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", inputFile, err)
+	}
+	defer in.Close()
+	out, err := os.Create(outputFile)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create transformed script: %w", err)
+	}
+	defer out.Close()
+	return rule.transformScript(database, section, in, out)
+}
+
+// DiffTransforms writes a unified diff of what the rewrites that need no connection
+// change in each plain section of the dump set in dir, without restoring anything:
+// retarget, when set, rewrites a database's pre-data the way the restore points its
+// foreign servers, and then the transform rules apply
+func DiffTransforms(rules []TransformRule, dir string, w io.Writer, retarget func(database, file string) error) error {
+	changed := 0
+	for _, database := range []string{"moodys", "tenant"} {
+		for _, section := range []string{"pre-data", "data", "post-data"} {
+			retargeted := retarget != nil && section == "pre-data"
+			if len(transformsFor(rules, database, section)) == 0 && !retargeted {
+				continue
+			}
+			name := sectionArtifactName(dir, database, section)
+			original := filepath.Join(dir, name)
+			if _, err := os.Stat(original); err != nil {
+				log.Printf("Skipping %s: no unencrypted artifact in %s", name, dir)
+				continue
+			}
+			review, err := reviewRewrites(original)
+			if err != nil {
+				return err
+			}
+			if review == nil {
+				log.Printf("Skipping %s: not an uncompressed plain script", name)
+				continue
+			}
+			diff, err := func() (string, error) {
+				// The review's copy is the one rewritten, leaving the dump set as it is
+				defer review.close()
+				if retargeted {
+					if err := retarget(database, review.copy); err != nil {
+						return "", err
+					}
+				}
+				transformed, cleanup, err := applyTransforms(rules, database, section, review.copy)
+				if err != nil {
+					return "", err
+				}
+				defer cleanup()
+				return unifiedDiff(name, original, transformed)
+			}()
+			if err != nil {
+				return err
+			}
+			if diff != "" {
+				changed++
+				io.WriteString(w, diff)
+			}
+		}
+	}
+	log.Printf("Rewrites change %d sections", changed)
+	return nil
+}
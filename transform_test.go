@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const transformScript = `CREATE FUNCTION public.notify() RETURNS void
+    LANGUAGE sql
+    AS $$ SELECT dblink_exec('host=db-prod.internal dbname=audit', 'SELECT 1') $$;
+
+COPY public.hosts (name) FROM stdin;
+db-prod.internal
+\.
+`
+
+func TestTransformRules(t *testing.T) {
+	for _, rules := range [][]TransformRule{
+		{{Pattern: "x"}},
+		{{Name: "both", Pattern: "x", Command: "cat"}},
+		{{Name: "neither"}},
+		{{Name: "bad", Pattern: "("}},
+		{{Name: "section", Pattern: "x", Sections: []string{"schema"}}},
+	} {
+		if err := validateTransforms(rules); err == nil {
+			t.Errorf("%+v passed validation", rules)
+		}
+	}
+
+	rule := TransformRule{Name: "host", Database: "tenant", Pattern: "x"}
+	if !rule.appliesTo("tenant", "pre-data") || rule.appliesTo("tenant", "data") || rule.appliesTo("moodys", "pre-data") {
+		t.Error("a rule without sections should apply to its database's pre-data only")
+	}
+	rule.Sections = []string{"data", "post-data"}
+	if rule.appliesTo("tenant", "pre-data") || !rule.appliesTo("tenant", "post-data") {
+		t.Error("sections not honored")
+	}
+}
+
+func TestApplyTransforms(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "tenant_pre-data.sql")
+	if err := os.WriteFile(input, []byte(transformScript), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rules := []TransformRule{
+		{Name: "host", Pattern: `host=db-prod\.internal`, Replace: "host=db-staging.internal"},
+		{Name: "plugin", Command: `sed "s/audit/audit_$PG_RESTORE_FDW_DATABASE/"`},
+		{Name: "moodys-only", Database: "moodys", Pattern: "public", Replace: "other"},
+	}
+	if err := validateTransforms(rules); err != nil {
+		t.Fatal(err)
+	}
+	output, cleanup, err := applyTransforms(rules, "tenant", "pre-data", input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	got, _ := os.ReadFile(output)
+	if !strings.Contains(string(got), "'host=db-staging.internal dbname=audit_tenant'") {
+		t.Errorf("function body not rewritten:\n%s", got)
+	}
+	if !strings.Contains(string(got), "\ndb-prod.internal\n") || strings.Contains(string(got), "other") {
+		t.Errorf("COPY data or another database's rule rewritten:\n%s", got)
+	}
+	if original, _ := os.ReadFile(input); string(original) != transformScript {
+		t.Error("the dump set was modified")
+	}
+
+	var diff bytes.Buffer
+	if err := DiffTransforms(rules, dir, &diff, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(diff.String(), "+++ b/tenant_pre-data.sql") || !strings.Contains(diff.String(), "+    AS $$ SELECT dblink_exec('host=db-staging.internal") {
+		t.Errorf("diff = %s", diff.String())
+	}
+}
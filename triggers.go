@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Ways to keep user triggers from firing while data loads into existing tables
+const (
+	// TriggersReplica loads with session_replication_role=replica, which skips ordinary
+	// user triggers and foreign key checks for the loading session only. It needs
+	// superuser or the privilege to set the parameter.
+	TriggersReplica = "replica"
+	// TriggersDisable disables each enabled user trigger on the loaded tables and
+	// restores its previous state afterwards. It needs table ownership and affects
+	// every session while the load runs.
+	TriggersDisable = "disable"
+)
+
+// validTriggerMode checks a -disable-triggers value; empty lets triggers fire
+func validTriggerMode(mode string) error {
+	switch mode {
+	case "", TriggersReplica, TriggersDisable:
+		return nil
+	}
+	return fmt.Errorf("unknown trigger mode %q: use %s or %s", mode, TriggersReplica, TriggersDisable)
+}
+
+// userTriggersQuery lists a table's enabled user triggers with their enabled state
+const userTriggersQuery = `SELECT t.tgname, t.tgenabled FROM pg_trigger t
+WHERE t.tgrelid = %s::regclass AND NOT t.tgisinternal AND t.tgenabled <> 'D'
+ORDER BY 1;`
+
+// TriggerToggle records a user trigger kept from firing during a load
+type TriggerToggle struct {
+	Database string `json:"database"`
+	Table    string `json:"table"`
+	Trigger  string `json:"trigger"`
+	Mode     string `json:"mode"`
+	// State is the trigger's pg_trigger.tgenabled before the load: O, R, or A
+	State string `json:"state"`
+	// Restored reports whether a disabled trigger was enabled again; replica mode
+	// never changes the trigger
+	Restored bool `json:"restored"`
+}
+
+// enableStatement returns the statement that puts a trigger back in its previous
+// enabled state
+func enableStatement(toggle TriggerToggle) string {
+	mode := ""
+	switch toggle.State {
+	case "R":
+		mode = "REPLICA "
+	case "A":
+		mode = "ALWAYS "
+	}
+	return fmt.Sprintf("ALTER TABLE %s ENABLE %sTRIGGER %s;", quoteQualifiedName(toggle.Table), mode, quoteIdent(toggle.Trigger))
+}
+
+// disableStatement disables one trigger
+func disableStatement(toggle TriggerToggle) string {
+	return fmt.Sprintf("ALTER TABLE %s DISABLE TRIGGER %s;", quoteQualifiedName(toggle.Table), quoteIdent(toggle.Trigger))
+}
+
+// userTriggers lists the enabled user triggers of tables
+func userTriggers(config DBConfig, tables []string, mode string) ([]TriggerToggle, error) {
+	var toggles []TriggerToggle
+	for _, table := range tables {
+		rows, err := queryRows(config, fmt.Sprintf(userTriggersQuery, quoteLiteral(quoteQualifiedName(table))))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list triggers of %s: %w", table, err)
+		}
+		for _, row := range rows {
+			if len(row) == 2 {
+				toggles = append(toggles, TriggerToggle{Database: config.DBName, Table: table, Trigger: row[0], Mode: mode, State: row[1]})
+			}
+		}
+	}
+	return toggles, nil
+}
+
+// withTriggersOff runs load against config with the tables' user triggers kept from
+// firing as mode says, and records every trigger involved in the run report. Disabled
+// triggers are enabled again whether or not load succeeded. An empty mode just runs load.
+func withTriggersOff(config DBConfig, tables []string, mode string, load func(DBConfig) error) (err error) {
+	if mode == "" {
+		return load(config)
+	}
+	toggles, err := userTriggers(config, tables, mode)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, toggle := range toggles {
+			activeReport.recordTrigger(toggle)
+		}
+	}()
+
+	if mode == TriggersReplica {
+		// Triggers enabled as REPLICA or ALWAYS still fire in a replica session
+		log.Printf("Loading %s with session_replication_role=replica (%d user triggers skipped)", strings.Join(tables, ", "), len(toggles))
+		config.Options = strings.TrimSpace(config.Options + " -c session_replication_role=replica")
+		return load(config)
+	}
+
+	disabled := 0
+	defer func() {
+		var failed []string
+		for i := range toggles[:disabled] {
+			sql := enableStatement(toggles[i])
+			if output, enableErr := newPsqlCmd(config, "-v", "ON_ERROR_STOP=1", "-c", sql).CombinedOutput(); enableErr != nil {
+				log.Printf("WARNING: failed to run %s: %v, output: %s", sql, enableErr, output)
+				failed = append(failed, toggles[i].Table+"."+toggles[i].Trigger)
+				continue
+			}
+			toggles[i].Restored = true
+		}
+		if len(failed) > 0 && err == nil {
+			err = fmt.Errorf("failed to enable triggers %s again", strings.Join(failed, ", "))
+		}
+	}()
+	for _, toggle := range toggles {
+		sql := disableStatement(toggle)
+		if output, err := newPsqlCmd(config, "-v", "ON_ERROR_STOP=1", "-c", sql).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to run %s: %w, output: %s", sql, err, output)
+		}
+		disabled++
+	}
+	if disabled > 0 {
+		log.Printf("Disabled %d user triggers on %s for the load", disabled, strings.Join(tables, ", "))
+	}
+	return load(config)
+}
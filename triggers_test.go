@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestTriggerStatements(t *testing.T) {
+	toggle := TriggerToggle{Table: "sales.orders", Trigger: "audit"}
+	if got, want := disableStatement(toggle), `ALTER TABLE "sales"."orders" DISABLE TRIGGER "audit";`; got != want {
+		t.Errorf("disableStatement = %q, want %q", got, want)
+	}
+	for state, want := range map[string]string{
+		"O": `ALTER TABLE "sales"."orders" ENABLE TRIGGER "audit";`,
+		"R": `ALTER TABLE "sales"."orders" ENABLE REPLICA TRIGGER "audit";`,
+		"A": `ALTER TABLE "sales"."orders" ENABLE ALWAYS TRIGGER "audit";`,
+	} {
+		toggle.State = state
+		if got := enableStatement(toggle); got != want {
+			t.Errorf("enableStatement(%s) = %q, want %q", state, got, want)
+		}
+	}
+	if err := validTriggerMode("off"); err == nil {
+		t.Error("validTriggerMode accepted an unknown mode")
+	}
+}
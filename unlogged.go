@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// permanentTablesQuery lists the logged ordinary tables outside system schemas. Tables
+// that were dumped unlogged are left out so they stay unlogged.
+const permanentTablesQuery = `SELECT n.nspname || '.' || c.relname FROM pg_class c
+JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE c.relkind = 'r' AND c.relpersistence = 'p'
+	AND n.nspname NOT IN ('pg_catalog', 'information_schema') AND n.nspname NOT LIKE 'pg\_%'
+ORDER BY 1;`
+
+// persistenceStatement switches a table between logged and unlogged
+func persistenceStatement(table string, logged bool) string {
+	mode := "UNLOGGED"
+	if logged {
+		mode = "LOGGED"
+	}
+	return fmt.Sprintf("ALTER TABLE %s SET %s;", quoteQualifiedName(table), mode)
+}
+
+// setPersistence switches tables to logged or unlogged, several at once. Switching to
+// logged rewrites each table and writes it to WAL in one pass.
+func setPersistence(config DBConfig, tables []string, logged bool) error {
+	return runConcurrently(len(tables), getNumCPUs(), func(i int) error {
+		sql := persistenceStatement(tables[i], logged)
+		if output, err := newPsqlCmd(config, "-v", "ON_ERROR_STOP=1", "-c", sql).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to run %s: %w, output: %s", sql, err, output)
+		}
+		return nil
+	})
+}
+
+// withUnloggedTables runs load with the database's logged tables switched to unlogged,
+// so their data skips WAL, and switches them back afterwards whether or not load
+// succeeded. A crash in between empties the unlogged tables.
+func withUnloggedTables(config DBConfig, load func() error) (err error) {
+	rows, err := queryRows(config, permanentTablesQuery)
+	if err != nil {
+		return fmt.Errorf("failed to list tables of %s: %w", config.DBName, err)
+	}
+	var tables []string
+	for _, row := range rows {
+		if len(row) == 1 {
+			tables = append(tables, row[0])
+		}
+	}
+	if len(tables) == 0 {
+		return load()
+	}
+
+	log.Printf("Switching %d tables in %s to unlogged for the data load", len(tables), config.DBName)
+	defer func() {
+		start := time.Now()
+		if loggedErr := setPersistence(config, tables, true); loggedErr != nil {
+			log.Printf("WARNING: tables in %s may still be unlogged: %v", config.DBName, loggedErr)
+			if err == nil {
+				err = loggedErr
+			}
+			return
+		}
+		log.Printf("Switched %d tables in %s back to logged in %v", len(tables), config.DBName, time.Since(start).Round(time.Second))
+	}()
+	if err := setPersistence(config, tables, false); err != nil {
+		return err
+	}
+	return load()
+}
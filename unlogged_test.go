@@ -0,0 +1,12 @@
+package main
+
+import "testing"
+
+func TestPersistenceStatement(t *testing.T) {
+	if got, want := persistenceStatement("sales.orders", false), `ALTER TABLE "sales"."orders" SET UNLOGGED;`; got != want {
+		t.Errorf("persistenceStatement(unlogged) = %q, want %q", got, want)
+	}
+	if got, want := persistenceStatement("sales.orders", true), `ALTER TABLE "sales"."orders" SET LOGGED;`; got != want {
+		t.Errorf("persistenceStatement(logged) = %q, want %q", got, want)
+	}
+}
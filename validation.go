@@ -0,0 +1,563 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Validation strategies
+const (
+	// ValidateCount compares row counts
+	ValidateCount = "count"
+	// ValidateSample compares a random sample of rows by primary key
+	ValidateSample = "sample"
+	// ValidateAggregate compares row counts and configured aggregate expressions
+	ValidateAggregate = "aggregate"
+	// ValidateChecksum compares a checksum of every row
+	ValidateChecksum = "checksum"
+	// ValidateSkip leaves the table out
+	ValidateSkip = "skip"
+)
+
+// defaultSampleSize is how many rows the sample strategy compares when unset
+const defaultSampleSize = 1000
+
+// defaultTimestampColumns is how many date and timestamp columns are compared when unset
+const defaultTimestampColumns = 5
+
+// defaultValidationWorkers is how many tables are validated at once when unset
+const defaultValidationWorkers = 4
+
+// ValidationRule selects how a table is compared between source and destination
+type ValidationRule struct {
+	// Table is a schema-qualified name or a path.Match pattern such as "audit.*";
+	// ignored for the default rule
+	Table    string `json:"table,omitempty"`
+	Strategy string `json:"strategy"`
+	// SampleSize is how many rows the sample strategy compares
+	SampleSize int `json:"sample_size,omitempty"`
+	// Key identifies sampled rows; the primary key when empty
+	Key []string `json:"key,omitempty"`
+	// Aggregates are SQL expressions compared by the aggregate strategy, e.g. "sum(amount)"
+	Aggregates []string `json:"aggregates,omitempty"`
+	// Tolerance is the fraction counts and numeric aggregates may differ by, or the
+	// fraction of sampled rows that may mismatch
+	Tolerance float64 `json:"tolerance,omitempty"`
+}
+
+// ValidationConfig picks a validation rule for every table in the source
+type ValidationConfig struct {
+	// Default applies to tables no rule in Tables matches; count when unset
+	Default ValidationRule   `json:"default"`
+	Tables  []ValidationRule `json:"tables"`
+	// Workers is how many tables are validated at once, each with a query on the source
+	// and one on the destination; defaultValidationWorkers when unset
+	Workers int `json:"workers,omitempty"`
+	// TimestampColumns is how many date and timestamp columns ValidateTimestamps
+	// compares; defaultTimestampColumns when unset, and none when negative
+	TimestampColumns int `json:"timestamp_columns,omitempty"`
+}
+
+// workers returns how many tables are validated at once
+func (v *ValidationConfig) workers() int {
+	if v == nil || v.Workers == 0 {
+		return defaultValidationWorkers
+	}
+	return v.Workers
+}
+
+// timestampColumns returns how many timestamp columns to compare
+func (v *ValidationConfig) timestampColumns() int {
+	if v == nil || v.TimestampColumns == 0 {
+		return defaultTimestampColumns
+	}
+	return v.TimestampColumns
+}
+
+// TableValidation records how one table compared and how long it took
+type TableValidation struct {
+	Table      string `json:"table"`
+	Strategy   string `json:"strategy"`
+	Mismatches int    `json:"mismatches"`
+	Duration   string `json:"duration"`
+	Error      string `json:"error,omitempty"`
+
+	elapsed time.Duration
+}
+
+// validate checks every rule
+func (v *ValidationConfig) validate() error {
+	if v.Workers < 0 {
+		return fmt.Errorf("validation workers must not be negative")
+	}
+	for _, rule := range append([]ValidationRule{v.Default}, v.Tables...) {
+		switch rule.Strategy {
+		case "", ValidateCount, ValidateSample, ValidateChecksum, ValidateSkip:
+		case ValidateAggregate:
+			if len(rule.Aggregates) == 0 {
+				return fmt.Errorf("validation rule %q: aggregate needs aggregates", rule.Table)
+			}
+		default:
+			return fmt.Errorf("validation rule %q: unknown strategy %q", rule.Table, rule.Strategy)
+		}
+		if _, err := path.Match(rule.Table, ""); err != nil {
+			return fmt.Errorf("validation rule %q: %w", rule.Table, err)
+		}
+		if rule.Tolerance < 0 || rule.SampleSize < 0 {
+			return fmt.Errorf("validation rule %q: tolerance and sample_size must not be negative", rule.Table)
+		}
+	}
+	return nil
+}
+
+// ruleFor returns the first rule matching table, or the default
+func (v *ValidationConfig) ruleFor(table string) ValidationRule {
+	rule := v.Default
+	for _, r := range v.Tables {
+		if matched, _ := path.Match(r.Table, table); matched {
+			rule = r
+			break
+		}
+	}
+	if rule.Strategy == "" {
+		rule.Strategy = ValidateCount
+	}
+	return rule
+}
+
+// withinTolerance reports whether dest differs from src by at most tolerance of src
+func withinTolerance(src, dest, tolerance float64) bool {
+	if src == dest {
+		return true
+	}
+	return math.Abs(src-dest) <= tolerance*math.Abs(src)
+}
+
+// validationTablesQuery lists the ordinary and partitioned tables outside system schemas.
+// Partitions are validated through their parent.
+const validationTablesQuery = `SELECT n.nspname || '.' || c.relname FROM pg_class c
+JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE c.relkind IN ('r', 'p') AND NOT c.relispartition
+	AND n.nspname NOT IN ('pg_catalog', 'information_schema') AND n.nspname NOT LIKE 'pg\_%'
+ORDER BY 1;`
+
+// primaryKeyQuery lists the primary key columns of a table in key order
+const primaryKeyQuery = `SELECT a.attname FROM pg_index i
+JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+WHERE i.indrelid = %s::regclass AND i.indisprimary
+ORDER BY array_position(i.indkey::int2[], a.attnum);`
+
+// Field and record separators for query output that may contain any printable text
+const (
+	fieldSep  = "\x1f"
+	recordSep = "\x1e"
+)
+
+// queryRows runs a query and splits its output into rows of fields
+func queryRows(config DBConfig, query string) ([][]string, error) {
+	output, err := newPsqlCmd(config, "-X", "-q", "-t", "-A", "-F", fieldSep, "-R", recordSep,
+		"-v", "ON_ERROR_STOP=1", "-c", query).Output()
+	if err != nil {
+		return nil, fmt.Errorf("query on %s failed: %w", config.DBName, err)
+	}
+	var rows [][]string
+	for _, record := range strings.Split(string(output), recordSep) {
+		if record = strings.TrimSuffix(record, "\n"); record != "" {
+			rows = append(rows, strings.Split(record, fieldSep))
+		}
+	}
+	return rows, nil
+}
+
+// queryValues runs a query returning one row on both databases at once
+func queryValues(src, dest DBConfig, query string) ([]string, []string, error) {
+	var destRows [][]string
+	destDone := make(chan error, 1)
+	go func() {
+		var err error
+		destRows, err = queryRows(dest, query)
+		destDone <- err
+	}()
+	srcRows, err := queryRows(src, query)
+	if destErr := <-destDone; err == nil {
+		err = destErr
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(srcRows) != 1 || len(destRows) != 1 {
+		return nil, nil, fmt.Errorf("expected one row from %q", query)
+	}
+	return srcRows[0], destRows[0], nil
+}
+
+// compareValues compares aggregate results, numerically within tolerance where both parse
+func compareValues(table string, labels, src, dest []string, tolerance float64) []string {
+	var mismatches []string
+	for i := range labels {
+		if src[i] == dest[i] {
+			continue
+		}
+		s, errS := strconv.ParseFloat(src[i], 64)
+		d, errD := strconv.ParseFloat(dest[i], 64)
+		if errS == nil && errD == nil && withinTolerance(s, d, tolerance) {
+			continue
+		}
+		mismatches = append(mismatches, fmt.Sprintf("%s: %s is %s on the source and %s on the destination",
+			table, labels[i], src[i], dest[i]))
+	}
+	return mismatches
+}
+
+// validateAggregates compares count(*) and the rule's aggregates
+func validateAggregates(src, dest DBConfig, table string, rule ValidationRule) ([]string, error) {
+	labels := append([]string{"count(*)"}, rule.Aggregates...)
+	var exprs []string
+	for _, label := range labels {
+		exprs = append(exprs, fmt.Sprintf("(%s)::text", label))
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s;", strings.Join(exprs, ", "), quoteQualifiedName(table))
+	srcValues, destValues, err := queryValues(src, dest, query)
+	if err != nil {
+		return nil, err
+	}
+	return compareValues(table, labels, srcValues, destValues, rule.Tolerance), nil
+}
+
+// validateChecksum compares the md5 of every row in a stable order
+func validateChecksum(src, dest DBConfig, table string) ([]string, error) {
+	query := fmt.Sprintf("SELECT coalesce(md5(string_agg(h, '' ORDER BY h)), '') FROM (SELECT md5(t::text) AS h FROM %s t) rows;",
+		quoteQualifiedName(table))
+	srcValues, destValues, err := queryValues(src, dest, query)
+	if err != nil {
+		return nil, err
+	}
+	if srcValues[0] != destValues[0] {
+		return []string{fmt.Sprintf("%s: checksum differs", table)}, nil
+	}
+	return nil, nil
+}
+
+// samplePercent returns the share of pages to sample for about twice n rows, so that
+// n remain after sampling; unanalyzed tables (reltuples < 1) are read in full
+func samplePercent(reltuples float64, n int) float64 {
+	if reltuples < 1 {
+		return 100
+	}
+	return math.Min(100, 200*float64(n)/reltuples)
+}
+
+// sampleQuery picks n random rows from pages sampled at percent, which reads only the
+// sampled pages
+func sampleQuery(table string, key []string, n int, percent float64) string {
+	var cols []string
+	for _, k := range key {
+		cols = append(cols, quoteIdent(k)+"::text")
+	}
+	return fmt.Sprintf("SELECT %s, md5(t::text) FROM %s t TABLESAMPLE SYSTEM (%g) ORDER BY random() LIMIT %d;",
+		strings.Join(cols, ", "), quoteQualifiedName(table), percent, n)
+}
+
+// lookupQuery fetches the row hashes of the sampled keys
+func lookupQuery(table string, key []string, rows [][]string) string {
+	var cols []string
+	for _, k := range key {
+		cols = append(cols, quoteIdent(k))
+	}
+	var tuples []string
+	for _, row := range rows {
+		var values []string
+		for _, v := range row[:len(key)] {
+			values = append(values, quoteLiteral(v))
+		}
+		tuples = append(tuples, "("+strings.Join(values, ", ")+")")
+	}
+	var textCols []string
+	for _, c := range cols {
+		textCols = append(textCols, c+"::text")
+	}
+	return fmt.Sprintf("SELECT %s, md5(t::text) FROM %s t WHERE (%s) IN (%s);",
+		strings.Join(textCols, ", "), quoteQualifiedName(table), strings.Join(cols, ", "), strings.Join(tuples, ", "))
+}
+
+// compareSample counts sampled source rows that are missing or differ on the destination
+func compareSample(keyLen int, srcRows, destRows [][]string) int {
+	destHashes := make(map[string]string, len(destRows))
+	for _, row := range destRows {
+		destHashes[strings.Join(row[:keyLen], fieldSep)] = row[keyLen]
+	}
+	mismatched := 0
+	for _, row := range srcRows {
+		if destHashes[strings.Join(row[:keyLen], fieldSep)] != row[keyLen] {
+			mismatched++
+		}
+	}
+	return mismatched
+}
+
+// validateSample compares a random sample of rows by key, falling back to counts for
+// tables without a key
+func validateSample(src, dest DBConfig, table string, rule ValidationRule) ([]string, error) {
+	key := rule.Key
+	if len(key) == 0 {
+		rows, err := queryRows(src, fmt.Sprintf(primaryKeyQuery, quoteLiteral(quoteQualifiedName(table))))
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			key = append(key, row[0])
+		}
+	}
+	if len(key) == 0 {
+		log.Printf("%s has no primary key; comparing row counts instead of a sample", table)
+		return validateAggregates(src, dest, table, ValidationRule{Tolerance: rule.Tolerance})
+	}
+
+	n := rule.SampleSize
+	if n == 0 {
+		n = defaultSampleSize
+	}
+	stats, err := queryRows(src, fmt.Sprintf("SELECT reltuples FROM pg_class WHERE oid = %s::regclass;",
+		quoteLiteral(quoteQualifiedName(table))))
+	if err != nil {
+		return nil, err
+	}
+	reltuples := 0.0
+	if len(stats) == 1 {
+		reltuples, _ = strconv.ParseFloat(stats[0][0], 64)
+	}
+	srcRows, err := queryRows(src, sampleQuery(table, key, n, samplePercent(reltuples, n)))
+	if err != nil {
+		return nil, err
+	}
+	if len(srcRows) == 0 {
+		return validateAggregates(src, dest, table, ValidationRule{Tolerance: rule.Tolerance})
+	}
+	destRows, err := queryRows(dest, lookupQuery(table, key, srcRows))
+	if err != nil {
+		return nil, err
+	}
+
+	mismatched := compareSample(len(key), srcRows, destRows)
+	log.Printf("%s: %d of %d sampled rows match", table, len(srcRows)-mismatched, len(srcRows))
+	if !withinTolerance(float64(len(srcRows)), float64(len(srcRows)-mismatched), rule.Tolerance) {
+		return []string{fmt.Sprintf("%s: %d of %d sampled rows are missing or differ", table, mismatched, len(srcRows))}, nil
+	}
+	return nil, nil
+}
+
+// validateTable compares one table with the destination using its configured strategy
+func validateTable(src, dest DBConfig, table string, v *ValidationConfig) ([]string, error) {
+	rule := v.ruleFor(table)
+	var found []string
+	var err error
+	switch rule.Strategy {
+	case ValidateSkip:
+		debugf("Skipping validation of %s", table)
+		return nil, nil
+	case ValidateSample:
+		found, err = validateSample(src, dest, table, rule)
+	case ValidateChecksum:
+		found, err = validateChecksum(src, dest, table)
+	case ValidateAggregate:
+		found, err = validateAggregates(src, dest, table, rule)
+	default:
+		found, err = validateAggregates(src, dest, table, ValidationRule{Tolerance: rule.Tolerance})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to validate %s: %w", table, err)
+	}
+	log.Printf("Validated %s (%s): %d mismatches", table, rule.Strategy, len(found))
+	return found, nil
+}
+
+// ValidateTables compares every source table with the destination using its configured
+// strategy, a bounded number of tables at once, and reports all mismatches and failures
+// together. Each table's outcome and timing go into the run report.
+func ValidateTables(src, dest DBConfig, v *ValidationConfig) error {
+	rows, err := queryRows(src, validationTablesQuery)
+	if err != nil {
+		return fmt.Errorf("failed to list tables to validate: %w", err)
+	}
+	workers := v.workers()
+
+	start := time.Now()
+	results := make([]TableValidation, len(rows))
+	found := make([][]string, len(rows))
+	runConcurrently(len(rows), workers, func(i int) error {
+		table := rows[i][0]
+		tableStart := time.Now()
+		mismatches, err := validateTable(src, dest, table, v)
+		elapsed := time.Since(tableStart)
+		results[i] = TableValidation{
+			Table:      table,
+			Strategy:   v.ruleFor(table).Strategy,
+			Mismatches: len(mismatches),
+			Duration:   elapsed.Round(time.Millisecond).String(),
+			elapsed:    elapsed,
+		}
+		if err != nil {
+			results[i].Error = err.Error()
+		}
+		found[i] = mismatches
+		return nil
+	})
+
+	var mismatches, failures []string
+	for i, result := range results {
+		activeReport.recordValidation(result)
+		mismatches = append(mismatches, found[i]...)
+		if result.Error != "" {
+			failures = append(failures, result.Error)
+		}
+	}
+	log.Printf("Validated %d tables in %s with %d workers%s", len(results),
+		time.Since(start).Round(time.Millisecond), workers, slowestTables(results, 5))
+
+	if len(failures) > 0 {
+		return fmt.Errorf("%d tables could not be validated (%d mismatches in the others):\n  %s",
+			len(failures), len(mismatches), strings.Join(failures, "\n  "))
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("%d validation mismatches:\n  %s", len(mismatches), strings.Join(mismatches, "\n  "))
+	}
+	return nil
+}
+
+// slowestTables lists the n tables that took longest to validate, for the summary line
+func slowestTables(results []TableValidation, n int) string {
+	sorted := append([]TableValidation(nil), results...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].elapsed > sorted[j].elapsed })
+	if len(sorted) > n {
+		sorted = sorted[:n]
+	}
+	var slowest []string
+	for _, result := range sorted {
+		slowest = append(slowest, fmt.Sprintf("%s %s", result.Table, result.Duration))
+	}
+	if len(slowest) == 0 {
+		return ""
+	}
+	return "; slowest: " + strings.Join(slowest, ", ")
+}
+
+// validateContent runs the configured validation, or the built-in row count check
+func validateContent(src, dest DBConfig, v *ValidationConfig) error {
+	if v == nil {
+		return ValidateDatabaseContent(src, dest)
+	}
+	return ValidateTables(src, dest, v)
+}
+
+// validateReference compares a database other databases read through foreign tables,
+// such as moodys, with the configured rules or else every table's row count. A broken
+// reference restore would otherwise only show up as wrong results through the FDW.
+func validateReference(src, dest DBConfig, v *ValidationConfig) error {
+	if v == nil {
+		v = &ValidationConfig{}
+	}
+	return ValidateTables(src, dest, v)
+}
+
+// foreignTablesQuery lists foreign tables outside system schemas
+const foreignTablesQuery = `SELECT n.nspname || '.' || c.relname FROM pg_class c
+JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE c.relkind = 'f' AND n.nspname NOT IN ('pg_catalog', 'information_schema')
+ORDER BY 1;`
+
+// ValidateForeignTables counts the rows of every foreign table through the source's and
+// the destination's foreign servers, which checks that each restored server reaches its
+// target and that the target holds the same data, wherever fdw_target points. Tables
+// are counted v's workers at a time.
+func ValidateForeignTables(src, dest DBConfig, v *ValidationConfig) error {
+	rows, err := queryRows(src, foreignTablesQuery)
+	if err != nil {
+		return fmt.Errorf("failed to list foreign tables to validate: %w", err)
+	}
+	found := make([][]string, len(rows))
+	failures := make([]string, len(rows))
+	runConcurrently(len(rows), v.workers(), func(i int) error {
+		table := rows[i][0]
+		srcValues, destValues, err := queryValues(src, dest, fmt.Sprintf("SELECT count(*) FROM %s;", quoteQualifiedName(table)))
+		if err != nil {
+			failures[i] = fmt.Sprintf("failed to read foreign table %s: %v", table, err)
+			return nil
+		}
+		found[i] = compareValues(table, []string{"count(*)"}, srcValues, destValues, 0)
+		return nil
+	})
+
+	var mismatches, failed []string
+	for i := range rows {
+		mismatches = append(mismatches, found[i]...)
+		if failures[i] != "" {
+			failed = append(failed, failures[i])
+		}
+	}
+	log.Printf("Validated %d foreign tables of %s through their servers", len(rows), dest.DBName)
+	if len(failed) > 0 {
+		return fmt.Errorf("%d foreign tables could not be read (%d mismatches in the others):\n  %s",
+			len(failed), len(mismatches), strings.Join(failed, "\n  "))
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("%d foreign table mismatches:\n  %s", len(mismatches), strings.Join(mismatches, "\n  "))
+	}
+	return nil
+}
+
+// timestampColumnsQuery picks the first date or timestamp column of up to %d tables
+const timestampColumnsQuery = `SELECT DISTINCT ON (c.oid) n.nspname || '.' || c.relname, a.attname
+FROM pg_attribute a
+	JOIN pg_class c ON c.oid = a.attrelid
+	JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE c.relkind IN ('r', 'p') AND NOT c.relispartition AND a.attnum > 0 AND NOT a.attisdropped
+	AND a.atttypid IN ('date'::regtype, 'timestamp'::regtype, 'timestamptz'::regtype)
+	AND n.nspname NOT IN ('pg_catalog', 'information_schema') AND n.nspname NOT LIKE 'pg\_%%'
+ORDER BY c.oid, a.attnum
+LIMIT %d;`
+
+// timestampQuery compares a column as epochs, which don't depend on the session's time
+// zone or date style, so a shifted value shows up as a different minimum or maximum
+func timestampQuery(table, column string) string {
+	col := quoteIdent(column)
+	return fmt.Sprintf("SELECT count(%[1]s), extract(epoch FROM min(%[1]s)), extract(epoch FROM max(%[1]s)) FROM %[2]s;",
+		col, quoteQualifiedName(table))
+}
+
+// ValidateTimestamps compares the count, earliest, and latest value of a sample of date
+// and timestamp columns, which catches values shifted by a session time zone or date
+// style that differed between dump and restore
+func ValidateTimestamps(src, dest DBConfig, n int) error {
+	if n <= 0 {
+		return nil
+	}
+	rows, err := queryRows(src, fmt.Sprintf(timestampColumnsQuery, n))
+	if err != nil {
+		return fmt.Errorf("failed to list timestamp columns to validate: %w", err)
+	}
+	var mismatches []string
+	for _, row := range rows {
+		table, column := row[0], row[1]
+		srcValues, destValues, err := queryValues(src, dest, timestampQuery(table, column))
+		if err != nil {
+			return fmt.Errorf("failed to compare %s.%s: %w", table, column, err)
+		}
+		labels := []string{
+			fmt.Sprintf("count(%s)", column),
+			fmt.Sprintf("min(%s)", column),
+			fmt.Sprintf("max(%s)", column),
+		}
+		mismatches = append(mismatches, compareValues(table, labels, srcValues, destValues, 0)...)
+	}
+	log.Printf("Compared %d timestamp columns of %s", len(rows), dest.DBName)
+	if len(mismatches) > 0 {
+		return fmt.Errorf("%d timestamp mismatches:\n  %s", len(mismatches), strings.Join(mismatches, "\n  "))
+	}
+	return nil
+}
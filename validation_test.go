@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidationRuleFor(t *testing.T) {
+	v := &ValidationConfig{
+		Default: ValidationRule{Strategy: ValidateSample},
+		Tables: []ValidationRule{
+			{Table: "audit.*", Strategy: ValidateSkip},
+			{Table: "public.ledger", Tolerance: 0.01},
+		},
+	}
+	if got := v.ruleFor("audit.events").Strategy; got != ValidateSkip {
+		t.Errorf("audit.events strategy = %s", got)
+	}
+	if got := v.ruleFor("public.ledger").Strategy; got != ValidateCount {
+		t.Errorf("rule without a strategy = %s, want count", got)
+	}
+	if got := v.ruleFor("public.orders").Strategy; got != ValidateSample {
+		t.Errorf("default strategy = %s", got)
+	}
+	if err := (&ValidationConfig{Tables: []ValidationRule{{Table: "t", Strategy: ValidateAggregate}}}).validate(); err == nil {
+		t.Error("expected aggregate without aggregates to be rejected")
+	}
+}
+
+func TestCompareValues(t *testing.T) {
+	labels := []string{"count(*)", "sum(amount)", "max(created_at)"}
+	src := []string{"1000", "5000.00", "2024-01-02"}
+	if m := compareValues("t", labels, src, []string{"999", "5000.00", "2024-01-02"}, 0.01); len(m) != 0 {
+		t.Errorf("expected a count within tolerance to match, got %v", m)
+	}
+	if m := compareValues("t", labels, src, []string{"1000", "5000.00", "2024-01-01"}, 0.5); len(m) != 1 {
+		t.Errorf("expected the date to mismatch regardless of tolerance, got %v", m)
+	}
+	if m := compareValues("t", labels, src, []string{"900", "5000.00", "2024-01-02"}, 0.01); len(m) != 1 {
+		t.Errorf("expected a count beyond tolerance to mismatch, got %v", m)
+	}
+}
+
+func TestCompareSample(t *testing.T) {
+	src := [][]string{{"1", "a"}, {"2", "b"}, {"3", "c"}}
+	dest := [][]string{{"1", "a"}, {"2", "x"}}
+	if got := compareSample(1, src, dest); got != 2 {
+		t.Errorf("mismatched = %d, want 2 (one differing, one missing)", got)
+	}
+}
+
+func TestSampleQueries(t *testing.T) {
+	if got := samplePercent(1e6, 1000); got != 0.2 {
+		t.Errorf("percent = %v", got)
+	}
+	if samplePercent(500, 1000) != 100 || samplePercent(-1, 1000) != 100 {
+		t.Error("expected small and unanalyzed tables to be read in full")
+	}
+	want := `SELECT "id"::text, md5(t::text) FROM "public"."orders" t WHERE ("id") IN (('1'), ('it''s'));`
+	if got := lookupQuery("public.orders", []string{"id"}, [][]string{{"1", "h"}, {"it's", "h"}}); got != want {
+		t.Errorf("lookup query:\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestSlowestTables(t *testing.T) {
+	results := []TableValidation{
+		{Table: "a", Duration: "1s", elapsed: time.Second},
+		{Table: "b", Duration: "3s", elapsed: 3 * time.Second},
+		{Table: "c", Duration: "2s", elapsed: 2 * time.Second},
+	}
+	if got := slowestTables(results, 2); got != "; slowest: b 3s, c 2s" {
+		t.Errorf("slowest = %q", got)
+	}
+	if got := slowestTables(nil, 2); got != "" {
+		t.Errorf("slowest of nothing = %q", got)
+	}
+	if results[0].Table != "a" {
+		t.Error("slowestTables reordered its input")
+	}
+	if err := (&ValidationConfig{Workers: -1}).validate(); err == nil {
+		t.Error("negative workers passed validation")
+	}
+}
+
+func TestTimestampValidation(t *testing.T) {
+	want := `SELECT count("created_at"), extract(epoch FROM min("created_at")), extract(epoch FROM max("created_at")) FROM "sales"."orders";`
+	if got := timestampQuery("sales.orders", "created_at"); got != want {
+		t.Errorf("timestampQuery = %s", got)
+	}
+	var unset *ValidationConfig
+	if unset.timestampColumns() != defaultTimestampColumns {
+		t.Error("nil config should compare the default number of columns")
+	}
+	if n := (&ValidationConfig{TimestampColumns: -1}).timestampColumns(); n >= 0 {
+		t.Errorf("negative timestamp_columns = %d", n)
+	}
+}
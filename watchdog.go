@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StatementWatchdog reports restore statements that run too long, such as a runaway
+// index build, and can cancel them so the restore's retry runs them with other settings
+type StatementWatchdog struct {
+	// MaxDuration flags a restore statement running longer than this, e.g. "30m"
+	MaxDuration string `json:"max_duration"`
+	// CheckEvery is how often restore statements are looked at; default 30s
+	CheckEvery string `json:"check_every"`
+	// Cancel cancels flagged statements; without it they are only logged
+	Cancel bool `json:"cancel"`
+	// RetrySettings are server settings the retry after a cancel runs with, e.g.
+	// {"maintenance_work_mem": "2GB", "max_parallel_maintenance_workers": "4"}
+	RetrySettings map[string]string `json:"retry_settings"`
+}
+
+// settingNamePattern matches server setting names, including custom ones like auto_explain.log_min_duration
+var settingNamePattern = regexp.MustCompile(`^[a-z_][a-z0-9_]*(\.[a-z_][a-z0-9_]*)?$`)
+
+// validate checks the watchdog's durations and settings
+func (w *StatementWatchdog) validate() error {
+	if d, err := time.ParseDuration(w.MaxDuration); err != nil || d <= 0 {
+		return fmt.Errorf("invalid max_duration %q: use a positive duration such as 30m", w.MaxDuration)
+	}
+	if w.CheckEvery != "" {
+		if d, err := time.ParseDuration(w.CheckEvery); err != nil || d <= 0 {
+			return fmt.Errorf("invalid check_every %q: use a positive duration such as 30s", w.CheckEvery)
+		}
+	}
+	if len(w.RetrySettings) > 0 && !w.Cancel {
+		return fmt.Errorf("retry_settings only apply after a cancel; set cancel")
+	}
+	for name, value := range w.RetrySettings {
+		if !settingNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid setting name %q", name)
+		}
+		if value == "" || strings.ContainsAny(value, " \\'\"") {
+			return fmt.Errorf("setting %s: value %q must be non-empty without spaces or quotes", name, value)
+		}
+	}
+	return nil
+}
+
+// retrySession applies the retry settings to a restore connection, in name order
+func (w *StatementWatchdog) retrySession(config DBConfig) DBConfig {
+	var names []string
+	for name := range w.RetrySettings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		config.Options = strings.TrimSpace(config.Options + " -c " + name + "=" + w.RetrySettings[name])
+	}
+	return config
+}
+
+// longStatementQuery lists the restore's statements in the current database running
+// longer than a number of seconds, longest first
+const longStatementQuery = `SELECT pid, EXTRACT(EPOCH FROM query_start)::bigint, EXTRACT(EPOCH FROM now() - query_start)::int,
+	left(regexp_replace(query, '[|[:space:]]+', ' ', 'g'), 500)
+FROM pg_stat_activity
+WHERE datname = current_database() AND application_name IN ('pg_restore', 'psql') AND state = 'active'
+	AND pid <> pg_backend_pid() AND now() - query_start > make_interval(secs => %d)
+ORDER BY 3 DESC;`
+
+// LongStatement is a restore statement running past the watchdog's limit
+type LongStatement struct {
+	PID int
+	// Start identifies the statement among those a session runs, in epoch seconds
+	Start   int64
+	Running time.Duration
+	Query   string
+}
+
+// parseLongStatements parses longStatementQuery rows
+func parseLongStatements(rows [][]string) ([]LongStatement, error) {
+	var statements []LongStatement
+	for _, row := range rows {
+		if len(row) < 4 {
+			return nil, fmt.Errorf("unexpected statement row %q", strings.Join(row, "|"))
+		}
+		pid, err1 := strconv.Atoi(row[0])
+		start, err2 := strconv.ParseInt(row[1], 10, 64)
+		running, err3 := strconv.Atoi(row[2])
+		if err1 != nil || err2 != nil || err3 != nil {
+			return nil, fmt.Errorf("unexpected statement row %q", strings.Join(row, "|"))
+		}
+		statements = append(statements, LongStatement{
+			PID: pid, Start: start, Running: time.Duration(running) * time.Second, Query: strings.Join(row[3:], "|"),
+		})
+	}
+	return statements, nil
+}
+
+// statementWatcher polls for long restore statements while a step runs
+type statementWatcher struct {
+	stop chan struct{}
+	done sync.WaitGroup
+	// seen holds the statements already reported, by pid and start
+	seen      map[[2]int64]bool
+	cancelled bool
+}
+
+// watch reports the restore statements in config running past the limit until the
+// returned watcher is stopped, cancelling them when configured
+func (w *StatementWatchdog) watch(config DBConfig, step string) *statementWatcher {
+	if w == nil {
+		return nil
+	}
+	limit, _ := time.ParseDuration(w.MaxDuration)
+	every := 30 * time.Second
+	if w.CheckEvery != "" {
+		every, _ = time.ParseDuration(w.CheckEvery)
+	}
+
+	sw := &statementWatcher{stop: make(chan struct{}), seen: make(map[[2]int64]bool)}
+	sw.done.Add(1)
+	go func() {
+		defer sw.done.Done()
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-sw.stop:
+				return
+			case <-ticker.C:
+				sw.check(config, step, limit, w.Cancel)
+			}
+		}
+	}()
+	return sw
+}
+
+// Stop ends the watcher, waits for a check in progress, and reports whether it
+// cancelled a statement
+func (sw *statementWatcher) Stop() bool {
+	if sw == nil {
+		return false
+	}
+	close(sw.stop)
+	sw.done.Wait()
+	return sw.cancelled
+}
+
+// check logs the restore statements in config running past limit, once each, and
+// cancels them when cancel is set
+func (sw *statementWatcher) check(config DBConfig, step string, limit time.Duration, cancel bool) {
+	rows, err := catalogRows(config, fmt.Sprintf(longStatementQuery, int(limit.Seconds())))
+	if err != nil {
+		debugf("Could not check for long statements during %s: %v", step, err)
+		return
+	}
+	statements, err := parseLongStatements(rows)
+	if err != nil {
+		log.Printf("Could not check for long statements during %s: %v", step, err)
+		return
+	}
+	for _, s := range statements {
+		key := [2]int64{int64(s.PID), s.Start}
+		if sw.seen[key] {
+			continue
+		}
+		sw.seen[key] = true
+		log.Printf("WARNING: %s: statement of pid %d on %s has run for %v, past %v: %s",
+			step, s.PID, config.DBName, s.Running, limit, s.Query)
+		if !cancel {
+			continue
+		}
+		log.Printf("Cancelling the statement of pid %d", s.PID)
+		output, err := newPsqlCmd(config, "-t", "-A", "-c", fmt.Sprintf("SELECT pg_cancel_backend(%d);", s.PID)).CombinedOutput()
+		if err != nil {
+			log.Printf("Failed to cancel pid %d: %v, output: %s", s.PID, err, output)
+			continue
+		}
+		sw.cancelled = true
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLongStatements(t *testing.T) {
+	rows := [][]string{{"303", "1760000000", "2400", "CREATE INDEX idx ON t ", " (a)"}}
+	statements, err := parseLongStatements(rows)
+	if err != nil || len(statements) != 1 {
+		t.Fatalf("got %v, %v", statements, err)
+	}
+	s := statements[0]
+	if s.PID != 303 || s.Start != 1760000000 || s.Running != 40*time.Minute || s.Query != "CREATE INDEX idx ON t | (a)" {
+		t.Errorf("parsed %+v", s)
+	}
+	if _, err := parseLongStatements([][]string{{"x"}}); err == nil {
+		t.Error("expected an error for a short row")
+	}
+}
+
+func TestStatementWatchdogValidate(t *testing.T) {
+	w := StatementWatchdog{MaxDuration: "30m", Cancel: true, RetrySettings: map[string]string{"maintenance_work_mem": "2GB"}}
+	if err := w.validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for _, bad := range []StatementWatchdog{
+		{MaxDuration: ""},
+		{MaxDuration: "30m", RetrySettings: map[string]string{"work_mem": "1GB"}},
+		{MaxDuration: "30m", Cancel: true, RetrySettings: map[string]string{"work_mem": "1GB -c x=y"}},
+		{MaxDuration: "30m", Cancel: true, RetrySettings: map[string]string{"Bad Name": "1"}},
+	} {
+		if bad.validate() == nil {
+			t.Errorf("expected %+v to be rejected", bad)
+		}
+	}
+}
+
+func TestStatementWatchdogRetrySession(t *testing.T) {
+	w := &StatementWatchdog{RetrySettings: map[string]string{"maintenance_work_mem": "2GB", "max_parallel_maintenance_workers": "4"}}
+	config := w.retrySession(DBConfig{Options: "-c lock_timeout=1min"})
+	if config.Options != "-c lock_timeout=1min -c maintenance_work_mem=2GB -c max_parallel_maintenance_workers=4" {
+		t.Errorf("got %q", config.Options)
+	}
+}
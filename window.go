@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MaintenanceWindowConfig restricts when phases that change the destinations may start.
+// A phase started inside a window runs to completion even if the window closes.
+type MaintenanceWindowConfig struct {
+	// Windows are daily ranges such as "01:00-05:00"; a range may wrap past midnight
+	Windows []string `json:"windows"`
+	// Days limits the windows to days of the week, e.g. ["sat", "sun"]; a window wrapping
+	// past midnight belongs to the day it opens on. Empty allows every day.
+	Days []string `json:"days"`
+	// TimeZone is the destination's zone, e.g. "Europe/London"; empty uses local time
+	TimeZone string `json:"time_zone"`
+	// Wait pauses before a destructive phase until the next window opens instead of
+	// failing the run
+	Wait bool `json:"wait"`
+}
+
+// windowPhases are the run phases that change the destinations
+var windowPhases = map[string]bool{
+	"cleanup": true, "setup": true, "restore": true, "clone": true, "incremental": true,
+	"cdc": true, "grants": true, "cutover": true, "workflow": true,
+}
+
+// dailyWindow is a range of minutes after midnight; end before start wraps past midnight
+type dailyWindow struct {
+	start, end int
+}
+
+func parseDailyWindow(s string) (dailyWindow, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return dailyWindow{}, fmt.Errorf("window %q is not HH:MM-HH:MM", s)
+	}
+	var minutes [2]int
+	for i, clock := range []string{from, to} {
+		t, err := time.Parse("15:04", strings.TrimSpace(clock))
+		if err != nil {
+			return dailyWindow{}, fmt.Errorf("window %q is not HH:MM-HH:MM", s)
+		}
+		minutes[i] = t.Hour()*60 + t.Minute()
+	}
+	if minutes[0] == minutes[1] {
+		return dailyWindow{}, fmt.Errorf("window %q is empty", s)
+	}
+	return dailyWindow{start: minutes[0], end: minutes[1]}, nil
+}
+
+// MaintenanceWindow decides whether a destructive phase may start
+type MaintenanceWindow struct {
+	windows  []dailyWindow
+	days     map[time.Weekday]bool
+	location *time.Location
+	wait     bool
+}
+
+// activeWindow gates destructive phases; nil lets them start at any time
+var activeWindow *MaintenanceWindow
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// NewMaintenanceWindow returns nil when no window is configured
+func NewMaintenanceWindow(config *MaintenanceWindowConfig) (*MaintenanceWindow, error) {
+	if config == nil {
+		return nil, nil
+	}
+	if len(config.Windows) == 0 {
+		return nil, fmt.Errorf("windows is required")
+	}
+	m := &MaintenanceWindow{location: time.Local, wait: config.Wait}
+	for _, s := range config.Windows {
+		w, err := parseDailyWindow(s)
+		if err != nil {
+			return nil, err
+		}
+		m.windows = append(m.windows, w)
+	}
+	if len(config.Days) > 0 {
+		m.days = make(map[time.Weekday]bool)
+		for _, day := range config.Days {
+			weekday, ok := weekdays[strings.ToLower(day)]
+			if !ok {
+				return nil, fmt.Errorf("unknown day %q: use sun, mon, tue, wed, thu, fri, or sat", day)
+			}
+			m.days[weekday] = true
+		}
+	}
+	if config.TimeZone != "" {
+		location, err := time.LoadLocation(config.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("time_zone: %w", err)
+		}
+		m.location = location
+	}
+	return m, nil
+}
+
+// allowsDay reports whether windows may open on a day
+func (m *MaintenanceWindow) allowsDay(day time.Weekday) bool {
+	return m.days == nil || m.days[day]
+}
+
+// open reports whether t falls inside a window
+func (m *MaintenanceWindow) open(t time.Time) bool {
+	t = t.In(m.location)
+	minute := t.Hour()*60 + t.Minute()
+	for _, w := range m.windows {
+		opened := t
+		switch {
+		case w.start < w.end && minute >= w.start && minute < w.end:
+		case w.start > w.end && minute >= w.start:
+		case w.start > w.end && minute < w.end:
+			// The early hours of a window that opened the day before
+			opened = t.AddDate(0, 0, -1)
+		default:
+			continue
+		}
+		if m.allowsDay(opened.Weekday()) {
+			return true
+		}
+	}
+	return false
+}
+
+// nextOpen returns when the next window opens after t
+func (m *MaintenanceWindow) nextOpen(t time.Time) time.Time {
+	t = t.In(m.location)
+	var next time.Time
+	for days := 0; days <= 7; days++ {
+		for _, w := range m.windows {
+			candidate := time.Date(t.Year(), t.Month(), t.Day()+days, w.start/60, w.start%60, 0, 0, m.location)
+			if !candidate.After(t) || !m.allowsDay(candidate.Weekday()) {
+				continue
+			}
+			if next.IsZero() || candidate.Before(next) {
+				next = candidate
+			}
+		}
+	}
+	return next
+}
+
+// await returns once a phase may start: at once for phases that don't change the
+// destinations or inside a window, otherwise after waiting for the next window or with
+// an error
+func (m *MaintenanceWindow) await(phase string) error {
+	if m == nil || !windowPhases[phase] {
+		return nil
+	}
+	now := time.Now()
+	if m.open(now) {
+		return nil
+	}
+	next := m.nextOpen(now)
+	if !m.wait {
+		return fmt.Errorf("phase %s may not start outside the maintenance window; the next one opens %s",
+			phase, next.Format("2006-01-02 15:04 MST"))
+	}
+	alwaysLog.Printf("Waiting until the maintenance window opens at %s to start phase %s",
+		next.Format("2006-01-02 15:04 MST"), phase)
+	time.Sleep(time.Until(next))
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaintenanceWindowOpen(t *testing.T) {
+	m, err := NewMaintenanceWindow(&MaintenanceWindowConfig{Windows: []string{"23:00-02:00", "12:00-12:30"}, Days: []string{"Sat"}, TimeZone: "UTC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(day, hour, minute int) time.Time { return time.Date(2026, 1, day, hour, minute, 0, 0, time.UTC) }
+	// 2026-01-03 is a Saturday
+	tests := []struct {
+		at   time.Time
+		want bool
+	}{
+		{at(3, 23, 30), true},
+		{at(4, 1, 59), true}, // Sunday morning, in Saturday's window
+		{at(4, 2, 0), false},
+		{at(3, 1, 0), false}, // Saturday morning belongs to Friday's window
+		{at(3, 12, 15), true},
+		{at(4, 12, 15), false},
+	}
+	for _, tt := range tests {
+		if got := m.open(tt.at); got != tt.want {
+			t.Errorf("open(%s) = %v, want %v", tt.at.Format("Mon 15:04"), got, tt.want)
+		}
+	}
+	if got, want := m.nextOpen(at(4, 2, 0)), at(10, 12, 0); !got.Equal(want) {
+		t.Errorf("nextOpen = %s, want %s", got, want)
+	}
+	if got, want := m.nextOpen(at(3, 12, 0)), at(3, 23, 0); !got.Equal(want) {
+		t.Errorf("nextOpen = %s, want %s", got, want)
+	}
+}
+
+func TestMaintenanceWindowConfig(t *testing.T) {
+	if m, err := NewMaintenanceWindow(nil); m != nil || err != nil {
+		t.Errorf("nil config = %v, %v", m, err)
+	}
+	for _, bad := range []MaintenanceWindowConfig{
+		{},
+		{Windows: []string{"01:00"}},
+		{Windows: []string{"01:00-01:00"}},
+		{Windows: []string{"25:00-01:00"}},
+		{Windows: []string{"01:00-05:00"}, Days: []string{"someday"}},
+		{Windows: []string{"01:00-05:00"}, TimeZone: "Nowhere/Special"},
+	} {
+		if _, err := NewMaintenanceWindow(&bad); err == nil {
+			t.Errorf("%+v passed validation", bad)
+		}
+	}
+
+	var m *MaintenanceWindow
+	if err := m.await("restore"); err != nil {
+		t.Errorf("no window: %v", err)
+	}
+	closed, _ := NewMaintenanceWindow(&MaintenanceWindowConfig{Windows: []string{"00:00-00:01"}, Days: []string{"sun"}})
+	closed.days = map[time.Weekday]bool{}
+	if err := closed.await("dump"); err != nil {
+		t.Errorf("dump outside the window: %v", err)
+	}
+	if err := closed.await("restore"); err == nil {
+		t.Error("restore started outside the window")
+	}
+}
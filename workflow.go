@@ -0,0 +1,333 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Workflow step actions
+const (
+	StepDump     = "dump"
+	StepRestore  = "restore"
+	StepHook     = "hook"
+	StepValidate = "validate"
+)
+
+// WorkflowDatabase is a database a custom workflow dumps from and restores into
+type WorkflowDatabase struct {
+	Source DBConfig `json:"source"`
+	Dest   DBConfig `json:"dest"`
+	// ForeignServers names the workflow databases this database's foreign servers point
+	// at; their connection options are retargeted from source to destination on restore
+	ForeignServers []string `json:"foreign_servers"`
+}
+
+// WorkflowStep is one node of a custom workflow
+type WorkflowStep struct {
+	Name   string `json:"name"`
+	Action string `json:"action"`
+	// Database names the workflow database dump, restore, and validate steps act on
+	Database string `json:"database"`
+	// Section limits dump and restore to pre-data, data, or post-data; empty does all three
+	Section   string   `json:"section"`
+	DependsOn []string `json:"depends_on"`
+	// Hook is the command or SQL file a hook step runs. SQL databases are named
+	// source_<database> or dest_<database>.
+	Hook *Hook `json:"hook"`
+}
+
+// WorkflowConfig declares the workflow as a graph of steps instead of the built-in
+// cleanup, dump, restore, and validate phases
+type WorkflowConfig struct {
+	// Databases adds to the built-in moodys and tenant databases
+	Databases map[string]WorkflowDatabase `json:"databases"`
+	Steps     []WorkflowStep              `json:"steps"`
+}
+
+// workflowSections returns the sections a step covers
+func workflowSections(section string) []string {
+	if section == "" {
+		return []string{"pre-data", "data", "post-data"}
+	}
+	return []string{section}
+}
+
+// Workflow runs a configured step graph
+type Workflow struct {
+	config    WorkflowConfig
+	databases map[string]WorkflowDatabase
+	hooks     *Hooks
+	dir       string
+	opts      RestoreOptions
+	// validation selects how validate steps compare tables; nil compares row counts
+	validation *ValidationConfig
+	codec      artifactCodec
+	artifacts  *artifactResolver
+	// created tracks databases restore steps created, for the failure policy
+	created createdDatabases
+
+	mu       sync.Mutex
+	manifest *Manifest
+}
+
+// NewWorkflow validates a workflow against the built-in databases plus the configured ones
+func NewWorkflow(config WorkflowConfig, builtin map[string]WorkflowDatabase, dir string, opts RestoreOptions) (*Workflow, error) {
+	databases := make(map[string]WorkflowDatabase)
+	for name, db := range builtin {
+		databases[name] = db
+	}
+	for name, db := range config.Databases {
+		databases[name] = db
+	}
+	for name, db := range databases {
+		for _, server := range db.ForeignServers {
+			if _, ok := databases[server]; !ok {
+				return nil, fmt.Errorf("workflow database %s: foreign server target %q is not a workflow database", name, server)
+			}
+		}
+	}
+
+	hookDatabases := make(map[string]DBConfig)
+	for name, db := range databases {
+		hookDatabases["source_"+name] = db.Source
+		hookDatabases["dest_"+name] = db.Dest
+	}
+	var hooks []Hook
+	var hookSteps []string
+	for i, step := range config.Steps {
+		if step.Name == "" {
+			return nil, fmt.Errorf("workflow step %d has no name", i+1)
+		}
+		switch step.Action {
+		case StepDump, StepRestore, StepValidate:
+			if _, ok := databases[step.Database]; !ok {
+				return nil, fmt.Errorf("workflow step %s: unknown database %q", step.Name, step.Database)
+			}
+			switch step.Section {
+			case "", "pre-data", "data", "post-data":
+			default:
+				return nil, fmt.Errorf("workflow step %s: unknown section %q", step.Name, step.Section)
+			}
+		case StepHook:
+			if step.Hook == nil {
+				return nil, fmt.Errorf("workflow step %s: hook steps need a hook", step.Name)
+			}
+			hook := *step.Hook
+			hook.Name, hook.Phase, hook.When = step.Name, step.Name, HookBefore
+			hooks = append(hooks, hook)
+			hookSteps = append(hookSteps, step.Name)
+		default:
+			return nil, fmt.Errorf("workflow step %s: action must be dump, restore, hook, or validate", step.Name)
+		}
+	}
+	h, err := NewHooks(hooks, hookSteps, hookDatabases)
+	if err != nil {
+		return nil, err
+	}
+
+	codec, err := newArtifactCodec(opts.Encryption, opts.GPG)
+	if err != nil {
+		return nil, err
+	}
+	return &Workflow{
+		config:    config,
+		databases: databases,
+		hooks:     h,
+		dir:       dir,
+		opts:      opts,
+		codec:     codec,
+		artifacts: newArtifactResolver(dir, codec),
+		manifest:  &Manifest{CreatedAt: time.Now()},
+	}, nil
+}
+
+// Run executes the steps, each once its dependencies have succeeded, and writes the
+// manifest of whatever was dumped
+func (w *Workflow) Run() error {
+	if err := os.MkdirAll(w.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	if err := setStepLogDir(filepath.Join(w.dir, "logs")); err != nil {
+		return err
+	}
+	defer w.artifacts.Cleanup()
+
+	tasks := make([]Task, 0, len(w.config.Steps))
+	for _, step := range w.config.Steps {
+		step := step
+		tasks = append(tasks, Task{Name: step.Name, DependsOn: step.DependsOn, Run: func(parent *Span) error {
+			span := startChildSpan(parent, "step "+step.Name, "workflow.action", step.Action)
+			err := w.runStep(step, span)
+			span.End(err)
+			if err != nil {
+				return fmt.Errorf("workflow step %s failed: %w", step.Name, err)
+			}
+			return nil
+		}})
+	}
+	err := runTasks(tasks)
+	if err != nil {
+		w.created.cleanUp(w.opts.OnFailure)
+	}
+
+	if len(w.manifest.Artifacts) > 0 {
+		sort.Slice(w.manifest.Artifacts, func(i, j int) bool {
+			return w.manifest.Artifacts[i].File < w.manifest.Artifacts[j].File
+		})
+		manifestErr := WriteManifest(w.dir, w.manifest)
+		if manifestErr == nil && w.opts.GPG != nil {
+			manifestErr = w.opts.GPG.SignFile(filepath.Join(w.dir, manifestFileName))
+		}
+		if manifestErr != nil && err == nil {
+			err = manifestErr
+		}
+	}
+	return err
+}
+
+func (w *Workflow) runStep(step WorkflowStep, span *Span) error {
+	log.Printf("Running workflow step %s (%s %s)", step.Name, step.Action, step.Database)
+	db := w.databases[step.Database]
+	switch step.Action {
+	case StepDump:
+		return w.dump(step.Database, db, workflowSections(step.Section))
+	case StepRestore:
+		return w.restore(step.Database, db, workflowSections(step.Section), span)
+	case StepValidate:
+		return validateContent(db.Source, db.Dest, w.validation)
+	default:
+		return w.hooks.run(step.Name, HookBefore)
+	}
+}
+
+func (w *Workflow) dump(name string, db WorkflowDatabase, sections []string) error {
+	for _, section := range sections {
+		outFile := filepath.Join(w.dir, fmt.Sprintf("%s_%s", name, section))
+		written, err := dumpDatabaseSection(activeThrottle.source(db.Source), outFile, section, w.codec, "", ArtifactLayout{})
+		if err != nil {
+			return fmt.Errorf("failed to dump %s %s: %w", name, section, err)
+		}
+
+		artifact := ManifestArtifact{
+			Database: name,
+			DBName:   db.Source.DBName,
+			Section:  section,
+			File:     filepath.Base(written),
+			Format:   sectionFormat(section),
+		}
+		if info, err := os.Stat(written); err == nil {
+			artifact.Size = info.Size()
+		}
+		if w.codec != nil {
+			artifact.Encrypted = true
+			artifact.KeyRef = w.codec.keyRef()
+		}
+		w.mu.Lock()
+		w.manifest.Artifacts = append(w.manifest.Artifacts, artifact)
+		w.mu.Unlock()
+	}
+	return nil
+}
+
+func (w *Workflow) restore(name string, db WorkflowDatabase, sections []string, span *Span) error {
+	compat, err := w.compatLayer(name, db)
+	if err != nil {
+		return err
+	}
+	for _, section := range sections {
+		if section == "pre-data" {
+			if err := CreateDatabase(db.Dest); err != nil {
+				return fmt.Errorf("failed to create database %s: %w", db.Dest.DBName, err)
+			}
+			w.created.add(db.Dest)
+		}
+		artifact := sectionArtifactName(w.dir, name, section)
+		if inFile, stream, ok := w.artifacts.Stream(artifact); ok && section == "data" {
+			// Encrypted data is decrypted on the way into the restore, never to a file
+			format, err := detectStreamFormat(inFile, stream)
+			if err != nil {
+				return err
+			}
+			if err := checkStreamable(inFile, format, false, compat != nil || len(transformsFor(w.opts.Transforms, name, section)) > 0); err != nil {
+				return err
+			}
+			opts := w.opts
+			opts.span, opts.stream = span, stream
+			if err := restoreDatabaseSection(db.Dest, inFile, section, opts); err != nil {
+				return fmt.Errorf("failed to restore %s %s: %w", name, section, err)
+			}
+			continue
+		}
+		inFile, err := w.artifacts.Resolve(artifact)
+		if err != nil {
+			return err
+		}
+		inFile, _, cleanup, err := prepareArtifact(inFile)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+
+		if section == "pre-data" && len(db.ForeignServers) > 0 {
+			if inFile, err = destinationCopy(inFile, db.Dest); err != nil {
+				return err
+			}
+			for _, server := range db.ForeignServers {
+				target := w.databases[server]
+				if err := modifyPreDataFile(inFile, target.Source, target.Dest); err != nil {
+					return fmt.Errorf("failed to retarget %s foreign servers at %s: %w", name, server, err)
+				}
+			}
+		}
+		inFile, cleanupCompat, err := compat.copy(inFile)
+		if err != nil {
+			return err
+		}
+		defer cleanupCompat()
+		inFile, cleanupTransforms, err := applyTransforms(w.opts.Transforms, name, section, inFile)
+		if err != nil {
+			return err
+		}
+		defer cleanupTransforms()
+		opts := w.opts
+		opts.span = span
+		if err := restoreDatabaseSection(db.Dest, inFile, section, opts); err != nil {
+			return fmt.Errorf("failed to restore %s %s: %w", name, section, err)
+		}
+	}
+	return nil
+}
+
+// compatLayer selects the rules rewriting name's plain sections for its destination, as
+// the built-in restore does. The source version comes from the dump set's manifest or,
+// when it wasn't recorded, from the source itself; an unreachable source gets only the
+// rules that apply whatever the versions.
+func (w *Workflow) compatLayer(name string, db WorkflowDatabase) (*compatLayer, error) {
+	version, err := serverVersionNum(maintenanceConfig(db.Dest))
+	if err != nil {
+		return nil, err
+	}
+	dest := majorVersion(version)
+	source := sourceMajorVersions(w.dir)[name]
+	if source == 0 {
+		source = dest
+		if num, err := serverVersionNum(maintenanceConfig(db.Source)); err == nil {
+			source = majorVersion(num)
+		}
+	}
+	var always []CompatRule
+	if w.opts.NonSuperuser == NonSuperuserSkip {
+		privileges, err := lookUpPrivileges(name, db.Dest, w.opts.Provider)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check privileges on %s: %w", name, err)
+		}
+		always = superuserRules(privileges)
+	}
+	always = append(always, w.opts.Provider.providerRules()...)
+	return newCompatLayer(w.opts.Compat, source, dest, always...), nil
+}
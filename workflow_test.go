@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewWorkflowValidation(t *testing.T) {
+	builtin := map[string]WorkflowDatabase{
+		"moodys": {},
+		"tenant": {ForeignServers: []string{"moodys"}},
+	}
+	valid := WorkflowConfig{
+		Databases: map[string]WorkflowDatabase{"shared": {ForeignServers: []string{"moodys"}}},
+		Steps: []WorkflowStep{
+			{Name: "dump_shared", Action: StepDump, Database: "shared"},
+			{Name: "restore_shared", Action: StepRestore, Database: "shared", DependsOn: []string{"dump_shared"}},
+			{Name: "notify", Action: StepHook, Hook: &Hook{Command: "true"}, DependsOn: []string{"restore_shared"}},
+		},
+	}
+	if _, err := NewWorkflow(valid, builtin, t.TempDir(), RestoreOptions{}); err != nil {
+		t.Fatalf("valid workflow rejected: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		steps []WorkflowStep
+		dbs   map[string]WorkflowDatabase
+		want  string
+	}{
+		{"unknown database", []WorkflowStep{{Name: "a", Action: StepDump, Database: "ledger"}}, nil, `unknown database "ledger"`},
+		{"unknown action", []WorkflowStep{{Name: "a", Action: "copy", Database: "moodys"}}, nil, "action must be"},
+		{"bad section", []WorkflowStep{{Name: "a", Action: StepRestore, Database: "moodys", Section: "schema"}}, nil, `unknown section "schema"`},
+		{"hook without hook", []WorkflowStep{{Name: "a", Action: StepHook}}, nil, "need a hook"},
+		{"hook database", []WorkflowStep{{Name: "a", Action: StepHook, Hook: &Hook{SQLFile: "x.sql", Database: "moodys"}}}, nil, `unknown database "moodys"`},
+		{"foreign server", nil, map[string]WorkflowDatabase{"shared": {ForeignServers: []string{"reference"}}}, `"reference" is not a workflow database`},
+	} {
+		_, err := NewWorkflow(WorkflowConfig{Databases: tc.dbs, Steps: tc.steps}, builtin, t.TempDir(), RestoreOptions{})
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: err = %v, want %q", tc.name, err, tc.want)
+		}
+	}
+}